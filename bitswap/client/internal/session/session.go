@@ -185,6 +185,14 @@ func (s *Session) Shutdown() {
 	s.shutdown()
 }
 
+// Close shuts the session down, it implements io.Closer so that the users of
+// the exchange.Fetcher returned by Client.NewSession can release the session
+// before its context is done.
+func (s *Session) Close() error {
+	s.Shutdown()
+	return nil
+}
+
 // ReceiveFrom receives incoming blocks from the given peer.
 func (s *Session) ReceiveFrom(from peer.ID, ks []cid.Cid, haves []cid.Cid, dontHaves []cid.Cid) {
 	// The SessionManager tells each Session about all keys that it may be
@@ -3,6 +3,8 @@ package defaults
 import (
 	"encoding/binary"
 	"time"
+
+	"github.com/ipfs/boxo/verifcid"
 )
 
 const (
@@ -29,9 +31,7 @@ const (
 	// Maximum size of the wantlist we are willing to keep in memory.
 	MaxQueuedWantlistEntiresPerPeer = 1024
 
-	// Copied from github.com/ipfs/go-verifcid#maximumHashLength
-	// FIXME: expose this in go-verifcid.
-	MaximumHashLength = 128
+	MaximumHashLength = verifcid.DefaultMaxDigestSize
 	MaximumAllowedCid = binary.MaxVarintLen64*4 + MaximumHashLength
 
 	// RebroadcastDelay is the default delay to trigger broadcast of
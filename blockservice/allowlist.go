@@ -0,0 +1,33 @@
+package blockservice
+
+import (
+	"fmt"
+
+	"github.com/ipfs/boxo/verifcid"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrCidNotAllowed is returned for CIDs rejected by the allowlist of the
+// blockservice, see WithAllowlist. It wraps the verifcid error.
+type ErrCidNotAllowed struct {
+	Cid cid.Cid
+	// MhType is the multihash function code of the CID.
+	MhType uint64
+	Err    error
+}
+
+func (e ErrCidNotAllowed) Error() string {
+	return fmt.Sprintf("cid %s (hash function 0x%x) not allowed: %s", e.Cid, e.MhType, e.Err)
+}
+
+func (e ErrCidNotAllowed) Unwrap() error {
+	return e.Err
+}
+
+// validateCid checks c against the allowlist, for hash security.
+func validateCid(allowlist verifcid.Allowlist, c cid.Cid) error {
+	if err := verifcid.ValidateCid(allowlist, c); err != nil {
+		return ErrCidNotAllowed{Cid: c, MhType: c.Prefix().MhType, Err: err}
+	}
+	return nil
+}
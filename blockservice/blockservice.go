@@ -5,8 +5,10 @@ package blockservice
 
 import (
 	"context"
+	"errors"
 	"io"
 	"sync"
+	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -78,12 +80,20 @@ type blockService struct {
 	// If checkFirst is true then first check that a block doesn't
 	// already exist to avoid republishing the block on the exchange.
 	checkFirst bool
+
+	orderedBufferSize int
+
+	fallback        exchange.Interface
+	fallbackTimeout time.Duration
+
+	metrics *metrics
 }
 
 type Option func(*blockService)
 
 // WriteThrough disable cache checks for writes and make them go straight to
-// the blockstore.
+// the blockstore. Use [blockstore.WithWriteThrough] to do it for some calls
+// only.
 func WriteThrough() Option {
 	return func(bs *blockService) {
 		bs.checkFirst = false
@@ -104,16 +114,25 @@ func New(bs blockstore.Blockstore, exchange exchange.Interface, opts ...Option)
 	}
 
 	service := &blockService{
-		allowlist:  verifcid.DefaultAllowlist,
-		blockstore: bs,
-		exchange:   exchange,
-		checkFirst: true,
+		allowlist:         verifcid.DefaultAllowlist,
+		blockstore:        bs,
+		exchange:          exchange,
+		checkFirst:        true,
+		orderedBufferSize: DefaultOrderedBufferSize,
 	}
 
 	for _, opt := range opts {
 		opt(service)
 	}
 
+	if service.fallback != nil {
+		if exchange == nil {
+			service.exchange = service.fallback
+		} else {
+			service.exchange = newFallbackExchange(exchange, service.fallback, service.fallbackTimeout)
+		}
+	}
+
 	return service
 }
 
@@ -144,29 +163,50 @@ func (s *blockService) Allowlist() verifcid.Allowlist {
 // If the current exchange is a SessionExchange, a new exchange
 // session will be created. Otherwise, the current exchange will be used
 // directly.
+//
+// The session is closed when ctx is done or when Close is called, whichever
+// happens first. The exchange sessions are tied to ctx, so that calling Close
+// is only needed to release the session before ctx is done. Sessions are not
+// shared between contexts unless attached to one with ContextWithSession.
 func NewSession(ctx context.Context, bs BlockService) *Session {
 	allowlist := verifcid.Allowlist(verifcid.DefaultAllowlist)
 	if bbs, ok := bs.(BoundedBlockService); ok {
 		allowlist = bbs.Allowlist()
 	}
+	orderedBufferSize := DefaultOrderedBufferSize
+	var m *metrics
+	if s, ok := bs.(*blockService); ok {
+		orderedBufferSize = s.orderedBufferSize
+		m = s.metrics
+	}
+	sessCtx, cancel := context.WithCancel(ctx)
 	exch := bs.Exchange()
+	var s *Session
 	if sessEx, ok := exch.(exchange.SessionExchange); ok {
-		return &Session{
-			allowlist: allowlist,
-			sessCtx:   ctx,
-			ses:       nil,
-			sessEx:    sessEx,
-			bs:        bs.Blockstore(),
-			notifier:  exch,
+		s = &Session{
+			allowlist:         allowlist,
+			sessCtx:           sessCtx,
+			cancel:            cancel,
+			ses:               nil,
+			sessEx:            sessEx,
+			bs:                bs.Blockstore(),
+			notifier:          exch,
+			orderedBufferSize: orderedBufferSize,
+			metrics:           m,
+		}
+	} else {
+		s = &Session{
+			allowlist:         allowlist,
+			ses:               exch,
+			sessCtx:           sessCtx,
+			cancel:            cancel,
+			bs:                bs.Blockstore(),
+			notifier:          exch,
+			orderedBufferSize: orderedBufferSize,
+			metrics:           m,
 		}
 	}
-	return &Session{
-		allowlist: allowlist,
-		ses:       exch,
-		sessCtx:   ctx,
-		bs:        bs.Blockstore(),
-		notifier:  exch,
-	}
+	return s
 }
 
 // AddBlock adds a particular block to the service, Putting it into the datastore.
@@ -175,11 +215,11 @@ func (s *blockService) AddBlock(ctx context.Context, o blocks.Block) error {
 	defer span.End()
 
 	c := o.Cid()
-	err := verifcid.ValidateCid(s.allowlist, c) // hash security
+	err := validateCid(s.allowlist, c) // hash security
 	if err != nil {
 		return err
 	}
-	if s.checkFirst {
+	if s.checkFirst && !blockstore.IsWriteThrough(ctx) {
 		if has, err := s.blockstore.Has(ctx, c); has || err != nil {
 			return err
 		}
@@ -192,6 +232,7 @@ func (s *blockService) AddBlock(ctx context.Context, o blocks.Block) error {
 	logger.Debugf("BlockService.BlockAdded %s", c)
 
 	if s.exchange != nil {
+		s.metrics.notifiedBlocks(1)
 		if err := s.exchange.NotifyNewBlocks(ctx, o); err != nil {
 			logger.Errorf("NotifyNewBlocks: %s", err.Error())
 		}
@@ -206,13 +247,13 @@ func (s *blockService) AddBlocks(ctx context.Context, bs []blocks.Block) error {
 
 	// hash security
 	for _, b := range bs {
-		err := verifcid.ValidateCid(s.allowlist, b.Cid())
+		err := validateCid(s.allowlist, b.Cid())
 		if err != nil {
 			return err
 		}
 	}
 	var toput []blocks.Block
-	if s.checkFirst {
+	if s.checkFirst && !blockstore.IsWriteThrough(ctx) {
 		toput = make([]blocks.Block, 0, len(bs))
 		for _, b := range bs {
 			has, err := s.blockstore.Has(ctx, b.Cid())
@@ -238,6 +279,7 @@ func (s *blockService) AddBlocks(ctx context.Context, bs []blocks.Block) error {
 
 	if s.exchange != nil {
 		logger.Debugf("BlockService.BlockAdded %d blocks", len(toput))
+		s.metrics.notifiedBlocks(len(toput))
 		if err := s.exchange.NotifyNewBlocks(ctx, toput...); err != nil {
 			logger.Errorf("NotifyNewBlocks: %s", err.Error())
 		}
@@ -256,39 +298,48 @@ func (s *blockService) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, e
 		f = s.getExchange
 	}
 
-	return getBlock(ctx, c, s.blockstore, s.allowlist, f)
+	return getBlock(ctx, c, s.blockstore, s.allowlist, f, s.metrics)
 }
 
 func (s *blockService) getExchange() notifiableFetcher {
 	return s.exchange
 }
 
-func getBlock(ctx context.Context, c cid.Cid, bs blockstore.Blockstore, allowlist verifcid.Allowlist, fget func() notifiableFetcher) (blocks.Block, error) {
-	err := verifcid.ValidateCid(allowlist, c) // hash security
+func getBlock(ctx context.Context, c cid.Cid, bs blockstore.Blockstore, allowlist verifcid.Allowlist, fget func() notifiableFetcher, m *metrics) (blocks.Block, error) {
+	if IsLocalOnly(ctx) {
+		fget = nil
+	}
+	err := validateCid(allowlist, c) // hash security
 	if err != nil {
 		return nil, err
 	}
 
 	block, err := bs.Get(ctx, c)
 	if err == nil {
+		m.servedFromBlockstore(block)
 		return block, nil
 	}
 
-	if ipld.IsNotFound(err) && fget != nil {
+	if errors.Is(err, ipld.ErrNotFound{}) && fget != nil {
 		f := fget() // Don't load the exchange until we have to
 
-		// TODO be careful checking ErrNotFound. If the underlying
-		// implementation changes, this will break.
 		logger.Debug("BlockService: Searching")
+		start := time.Now()
 		blk, err := f.GetBlock(ctx, c)
 		if err != nil {
 			return nil, err
 		}
+		// the exchange must not make us store and announce other blocks
+		if err := validateCid(allowlist, blk.Cid()); err != nil {
+			return nil, err
+		}
+		m.servedFromExchange(blk, start)
 		// also write in the blockstore for caching, inform the exchange that the block is available
 		err = bs.Put(ctx, blk)
 		if err != nil {
 			return nil, err
 		}
+		m.notifiedBlocks(1)
 		err = f.NotifyNewBlocks(ctx, blk)
 		if err != nil {
 			return nil, err
@@ -313,10 +364,13 @@ func (s *blockService) GetBlocks(ctx context.Context, ks []cid.Cid) <-chan block
 		f = s.getExchange
 	}
 
-	return getBlocks(ctx, ks, s.blockstore, s.allowlist, f)
+	return getBlocks(ctx, ks, s.blockstore, s.allowlist, f, s.metrics)
 }
 
-func getBlocks(ctx context.Context, ks []cid.Cid, bs blockstore.Blockstore, allowlist verifcid.Allowlist, fget func() notifiableFetcher) <-chan blocks.Block {
+func getBlocks(ctx context.Context, ks []cid.Cid, bs blockstore.Blockstore, allowlist verifcid.Allowlist, fget func() notifiableFetcher, m *metrics) <-chan blocks.Block {
+	if IsLocalOnly(ctx) {
+		fget = nil
+	}
 	out := make(chan blocks.Block)
 
 	go func() {
@@ -351,6 +405,7 @@ func getBlocks(ctx context.Context, ks []cid.Cid, bs blockstore.Blockstore, allo
 				misses = append(misses, c)
 				continue
 			}
+			m.servedFromBlockstore(hit)
 			select {
 			case out <- hit:
 			case <-ctx.Done():
@@ -363,6 +418,7 @@ func getBlocks(ctx context.Context, ks []cid.Cid, bs blockstore.Blockstore, allo
 		}
 
 		f := fget() // don't load exchange unless we have to
+		start := time.Now()
 		rblocks, err := f.GetBlocks(ctx, misses)
 		if err != nil {
 			logger.Debugf("Error with GetBlocks: %s", err)
@@ -382,6 +438,12 @@ func getBlocks(ctx context.Context, ks []cid.Cid, bs blockstore.Blockstore, allo
 				return
 			}
 
+			if err := validateCid(allowlist, b.Cid()); err != nil {
+				logger.Errorf("unsafe CID (%s) returned by the exchange: %s", b.Cid(), err)
+				continue
+			}
+			m.servedFromExchange(b, start)
+
 			// write in the blockstore for caching
 			err = bs.Put(ctx, b)
 			if err != nil {
@@ -391,6 +453,7 @@ func getBlocks(ctx context.Context, ks []cid.Cid, bs blockstore.Blockstore, allo
 
 			// inform the exchange that the blocks are available
 			cache[0] = b
+			m.notifiedBlocks(1)
 			err = f.NotifyNewBlocks(ctx, cache[:]...)
 			if err != nil {
 				logger.Errorf("could not tell the exchange about new blocks: %s", err)
@@ -436,8 +499,13 @@ type Session struct {
 	ses       exchange.Fetcher
 	sessEx    exchange.SessionExchange
 	sessCtx   context.Context
+	cancel    context.CancelFunc
 	notifier  notifier
 	lk        sync.Mutex
+	closed    bool
+
+	orderedBufferSize int
+	metrics           *metrics
 }
 
 type notifiableFetcher interface {
@@ -453,6 +521,9 @@ type notifiableFetcherWrapper struct {
 func (s *Session) getSession() notifiableFetcher {
 	s.lk.Lock()
 	defer s.lk.Unlock()
+	if s.closedLocked() {
+		return closedFetcher{}
+	}
 	if s.ses == nil {
 		s.ses = s.sessEx.NewSession(s.sessCtx)
 	}
@@ -461,9 +532,56 @@ func (s *Session) getSession() notifiableFetcher {
 }
 
 func (s *Session) getExchange() notifiableFetcher {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.closedLocked() {
+		return closedFetcher{}
+	}
 	return notifiableFetcherWrapper{s.ses, s.notifier}
 }
 
+// Close ends the session and releases the exchange session behind it. Later
+// requests through the session fail with ErrSessionClosed, as they do once the
+// context given to NewSession is done. Calling it more than once is fine.
+func (s *Session) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.closed {
+		return nil
+	}
+	return s.closeLocked()
+}
+
+func (s *Session) closeLocked() error {
+	s.closed = true
+	s.cancel()
+
+	// Only close exchange sessions we created, not the exchange itself.
+	if s.sessEx != nil && s.ses != nil {
+		if c, ok := s.ses.(io.Closer); ok {
+			return c.Close()
+		}
+	}
+	return nil
+}
+
+// closedLocked reports whether the session is closed, closing it if its
+// context is done.
+func (s *Session) closedLocked() bool {
+	if !s.closed && s.sessCtx.Err() != nil {
+		if err := s.closeLocked(); err != nil {
+			logger.Debugf("closing blockservice session: %s", err)
+		}
+	}
+	return s.closed
+}
+
+func (s *Session) isClosed() bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.closedLocked()
+}
+
 func (s *Session) getFetcherFactory() func() notifiableFetcher {
 	if s.sessEx != nil {
 		return s.getSession
@@ -480,7 +598,10 @@ func (s *Session) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error)
 	ctx, span := internal.StartSpan(ctx, "Session.GetBlock", trace.WithAttributes(attribute.Stringer("CID", c)))
 	defer span.End()
 
-	return getBlock(ctx, c, s.bs, s.allowlist, s.getFetcherFactory())
+	if s.isClosed() {
+		return nil, ErrSessionClosed
+	}
+	return getBlock(ctx, c, s.bs, s.allowlist, s.getFetcherFactory(), s.metrics)
 }
 
 // GetBlocks gets blocks in the context of a request session
@@ -488,7 +609,12 @@ func (s *Session) GetBlocks(ctx context.Context, ks []cid.Cid) <-chan blocks.Blo
 	ctx, span := internal.StartSpan(ctx, "Session.GetBlocks")
 	defer span.End()
 
-	return getBlocks(ctx, ks, s.bs, s.allowlist, s.getFetcherFactory())
+	if s.isClosed() {
+		out := make(chan blocks.Block)
+		close(out)
+		return out
+	}
+	return getBlocks(ctx, ks, s.bs, s.allowlist, s.getFetcherFactory(), s.metrics)
 }
 
 var _ BlockGetter = (*Session)(nil)
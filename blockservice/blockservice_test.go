@@ -2,6 +2,8 @@ package blockservice
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 
 	blockstore "github.com/ipfs/boxo/blockstore"
@@ -50,6 +52,36 @@ func TestWriteThroughWorks(t *testing.T) {
 	}
 }
 
+func TestPerCallWriteThrough(t *testing.T) {
+	t.Parallel()
+
+	bstore := &hasCountingBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())),
+	}
+	bserv := New(bstore, nil)
+	bgen := butil.NewBlockGenerator()
+	ctx := context.Background()
+
+	if err := bserv.AddBlocks(ctx, bgen.Blocks(4)); err != nil {
+		t.Fatal(err)
+	}
+	if bstore.HasCounter == 0 {
+		t.Fatal("expected Has calls without write-through")
+	}
+
+	bstore.HasCounter = 0
+	wctx := blockstore.WithWriteThrough(ctx)
+	if err := bserv.AddBlock(wctx, bgen.Next()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bserv.AddBlocks(wctx, bgen.Blocks(4)); err != nil {
+		t.Fatal(err)
+	}
+	if bstore.HasCounter != 0 {
+		t.Fatalf("expected no Has calls with write-through, have: %d", bstore.HasCounter)
+	}
+}
+
 func TestExchangeWrite(t *testing.T) {
 	t.Parallel()
 
@@ -197,6 +229,16 @@ func (bs *PutCountingBlockstore) PutMany(ctx context.Context, blocks []blocks.Bl
 	return bs.Blockstore.PutMany(ctx, blocks)
 }
 
+type hasCountingBlockstore struct {
+	blockstore.Blockstore
+	HasCounter int
+}
+
+func (bs *hasCountingBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	bs.HasCounter++
+	return bs.Blockstore.Has(ctx, c)
+}
+
 var _ exchange.Interface = (*notifyCountingExchange)(nil)
 
 type notifyCountingExchange struct {
@@ -253,6 +295,37 @@ func TestNilExchange(t *testing.T) {
 	}
 }
 
+func TestOfflineNotFound(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bgen := butil.NewBlockGenerator()
+	blks := bgen.Blocks(3)
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bserv := New(bs, offline.Exchange(bs))
+	if err := bserv.AddBlock(ctx, blks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := bserv.GetBlock(ctx, blks[1].Cid())
+	var nf ipld.ErrNotFound
+	if !errors.As(err, &nf) || !nf.Cid.Equals(blks[1].Cid()) {
+		t.Fatalf("expected a not found error for %s, got %v", blks[1].Cid(), err)
+	}
+	if !errors.Is(err, offline.ErrNotFound{}) {
+		t.Fatalf("expected the offline exchange error, got %v", err)
+	}
+
+	var got []blocks.Block
+	for b := range bserv.GetBlocks(ctx, []cid.Cid{blks[1].Cid(), blks[0].Cid(), blks[2].Cid()}) {
+		got = append(got, b)
+	}
+	if len(got) != 1 || !got[0].Cid().Equals(blks[0].Cid()) {
+		t.Fatalf("expected only the local block, got %d blocks", len(got))
+	}
+}
+
 func TestAllowlist(t *testing.T) {
 	t.Parallel()
 	a := assert.New(t)
@@ -288,3 +361,107 @@ func TestAllowlist(t *testing.T) {
 	check(blockservice.GetBlock)
 	check(NewSession(ctx, blockservice).GetBlock)
 }
+
+func TestAllowlistEnforcement(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	ctx := context.Background()
+
+	newBlock := func(data []byte, code uint64) blocks.Block {
+		h, err := multihash.Sum(data, code, -1)
+		a.NoError(err)
+		b, err := blocks.NewBlockWithCid(data, cid.NewCidV1(cid.Raw, h))
+		a.NoError(err)
+		return b
+	}
+	checkRejected := func(err error, b blocks.Block, code uint64, sentinel error) {
+		var notAllowed ErrCidNotAllowed
+		a.ErrorAs(err, &notAllowed)
+		a.True(notAllowed.Cid.Equals(b.Cid()))
+		a.Equal(code, notAllowed.MhType)
+		a.ErrorIs(err, sentinel)
+	}
+
+	bigIdentity := newBlock(make([]byte, 129), multihash.IDENTITY)
+	smallIdentity := newBlock([]byte("inline"), multihash.IDENTITY)
+	sha1 := newBlock([]byte("sha1 block"), multihash.SHA1)
+	blake3 := newBlock([]byte("blake3 block"), multihash.BLAKE3)
+	sha2 := newBlock([]byte("sha2 block"), multihash.SHA2_256)
+
+	t.Run("identity over the inline limit", func(t *testing.T) {
+		exch := newStallingExchange(nil)
+		bserv := New(blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), exch)
+		checkRejected(bserv.AddBlock(ctx, bigIdentity), bigIdentity, multihash.IDENTITY, verifcid.ErrAboveMaximumHashLength)
+		_, err := bserv.GetBlock(ctx, bigIdentity.Cid())
+		checkRejected(err, bigIdentity, multihash.IDENTITY, verifcid.ErrAboveMaximumHashLength)
+		a.NoError(bserv.AddBlock(ctx, smallIdentity))
+		a.Empty(exch.getRequested())
+	})
+
+	t.Run("sha1 rejected", func(t *testing.T) {
+		exch := newStallingExchange([]blocks.Block{sha1})
+		allowlist := verifcid.NewOverridingAllowlist(verifcid.DefaultAllowlist, map[uint64]bool{multihash.SHA1: false})
+		bserv := New(blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), exch, WithAllowlist(allowlist))
+
+		checkRejected(bserv.AddBlock(ctx, sha1), sha1, multihash.SHA1, verifcid.ErrPossiblyInsecureHashFunction)
+		checkRejected(bserv.AddBlocks(ctx, []blocks.Block{sha2, sha1}), sha1, multihash.SHA1, verifcid.ErrPossiblyInsecureHashFunction)
+		_, err := bserv.GetBlock(ctx, sha1.Cid())
+		checkRejected(err, sha1, multihash.SHA1, verifcid.ErrPossiblyInsecureHashFunction)
+		for range bserv.GetBlocks(ctx, []cid.Cid{sha1.Cid()}) {
+			t.Fatal("no block expected")
+		}
+		out, errf := bserv.(OrderedBlockGetter).GetBlocksOrdered(ctx, []cid.Cid{sha1.Cid()})
+		for range out {
+		}
+		checkRejected(errf(), sha1, multihash.SHA1, verifcid.ErrPossiblyInsecureHashFunction)
+		// Rejected before reaching the exchange.
+		a.Empty(exch.getRequested())
+		a.Zero(exch.notified)
+	})
+
+	t.Run("default allowlist", func(t *testing.T) {
+		bserv := New(blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), nil)
+		for _, b := range []blocks.Block{blake3, sha2} {
+			a.NoError(bserv.AddBlock(ctx, b))
+			got, err := bserv.GetBlock(ctx, b.Cid())
+			a.NoError(err)
+			a.True(got.Cid().Equals(b.Cid()))
+		}
+	})
+
+	t.Run("policies side by side", func(t *testing.T) {
+		// a strict public gateway and an archival service reading legacy
+		// sha1 data, sharing a blockstore in one process
+		bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+		a.NoError(bstore.PutMany(ctx, []blocks.Block{sha1, sha2, smallIdentity}))
+
+		strict := verifcid.WithMaxIdentityDigestSize(verifcid.NewOverridingAllowlist(verifcid.DefaultAllowlist, map[uint64]bool{multihash.SHA1: false}), 4)
+		archival := verifcid.WithAdditional(verifcid.DefaultAllowlist, multihash.SHA1)
+		gateway := New(bstore, nil, WithAllowlist(strict))
+		archive := New(bstore, nil, WithAllowlist(archival))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := gateway.GetBlock(ctx, sha1.Cid())
+				checkRejected(err, sha1, multihash.SHA1, verifcid.ErrPossiblyInsecureHashFunction)
+				_, err = NewSession(ctx, gateway).GetBlock(ctx, smallIdentity.Cid())
+				checkRejected(err, smallIdentity, multihash.IDENTITY, verifcid.ErrAboveMaximumHashLength)
+				_, err = gateway.GetBlock(ctx, sha2.Cid())
+				a.NoError(err)
+			}()
+			go func() {
+				defer wg.Done()
+				for _, b := range []blocks.Block{sha1, sha2, smallIdentity} {
+					_, err := archive.GetBlock(ctx, b.Cid())
+					a.NoError(err)
+					_, err = NewSession(ctx, archive).GetBlock(ctx, b.Cid())
+					a.NoError(err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
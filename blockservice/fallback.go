@@ -0,0 +1,235 @@
+package blockservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// ExchangeError reports which exchange failed to fetch a block when a
+// fallback exchange is configured.
+type ExchangeError struct {
+	// Exchange is "primary" or "fallback".
+	Exchange string
+	Err      error
+}
+
+func (e *ExchangeError) Error() string {
+	return fmt.Sprintf("%s exchange: %s", e.Exchange, e.Err)
+}
+
+func (e *ExchangeError) Unwrap() error {
+	return e.Err
+}
+
+// WithFallbackExchange sets an exchange used for the blocks the main exchange
+// fails to fetch, for example an HTTP block fetcher behind bitswap.
+//
+// GetBlock tries the main exchange, then the fallback one, each attempt
+// being limited to perFetchTimeout. When both fail, the error joins the two
+// ExchangeErrors. GetBlocks hands the blocks not received yet over to the
+// fallback exchange once the main one stalls, that is when it did not return a
+// block for perFetchTimeout or ended early. New blocks are announced to
+// both exchanges. A non-positive perFetchTimeout disables the timeouts, the
+// fallback is then only used after errors.
+func WithFallbackExchange(fallback exchange.Interface, perFetchTimeout time.Duration) Option {
+	return func(bs *blockService) {
+		bs.fallback = fallback
+		bs.fallbackTimeout = perFetchTimeout
+	}
+}
+
+// fallbackExchange is the exchange.Interface used when a fallback exchange is
+// set. Sessions are supported when the main or fallback exchange supports
+// them.
+type fallbackExchange struct {
+	*fallbackFetcher
+	primary  exchange.Interface
+	fallback exchange.Interface
+}
+
+var _ exchange.SessionExchange = (*fallbackExchange)(nil)
+
+func newFallbackExchange(primary, fallback exchange.Interface, timeout time.Duration) *fallbackExchange {
+	return &fallbackExchange{
+		fallbackFetcher: &fallbackFetcher{primary: primary, fallback: fallback, timeout: timeout},
+		primary:         primary,
+		fallback:        fallback,
+	}
+}
+
+func (e *fallbackExchange) NotifyNewBlocks(ctx context.Context, blks ...blocks.Block) error {
+	return errors.Join(
+		wrapExchangeError("primary", e.primary.NotifyNewBlocks(ctx, blks...)),
+		wrapExchangeError("fallback", e.fallback.NotifyNewBlocks(ctx, blks...)),
+	)
+}
+
+func (e *fallbackExchange) Close() error {
+	return errors.Join(
+		wrapExchangeError("primary", e.primary.Close()),
+		wrapExchangeError("fallback", e.fallback.Close()),
+	)
+}
+
+// NewSession returns a fetcher using sessions of the exchanges which support
+// them. Closing it closes those sessions only.
+func (e *fallbackExchange) NewSession(ctx context.Context) exchange.Fetcher {
+	f := &fallbackFetcher{primary: e.primary, fallback: e.fallback, timeout: e.timeout}
+	if sx, ok := e.primary.(exchange.SessionExchange); ok {
+		f.primary = sx.NewSession(ctx)
+		if c, ok := f.primary.(io.Closer); ok {
+			f.closers = append(f.closers, c)
+		}
+	}
+	if sx, ok := e.fallback.(exchange.SessionExchange); ok {
+		f.fallback = sx.NewSession(ctx)
+		if c, ok := f.fallback.(io.Closer); ok {
+			f.closers = append(f.closers, c)
+		}
+	}
+	return f
+}
+
+type fallbackFetcher struct {
+	primary  exchange.Fetcher
+	fallback exchange.Fetcher
+	timeout  time.Duration
+	// closers are the sessions owned by this fetcher.
+	closers []io.Closer
+}
+
+func wrapExchangeError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ExchangeError{Exchange: name, Err: err}
+}
+
+func (f *fallbackFetcher) attempt(ctx context.Context, fetcher exchange.Fetcher, c cid.Cid) (blocks.Block, error) {
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+	return fetcher.GetBlock(ctx, c)
+}
+
+func (f *fallbackFetcher) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, perr := f.attempt(ctx, f.primary, c)
+	if perr == nil {
+		return blk, nil
+	}
+	if ctx.Err() != nil {
+		return nil, wrapExchangeError("primary", perr)
+	}
+	logger.Debugf("primary exchange failed for %s, trying the fallback: %s", c, perr)
+	blk, ferr := f.attempt(ctx, f.fallback, c)
+	if ferr == nil {
+		return blk, nil
+	}
+	return nil, errors.Join(wrapExchangeError("primary", perr), wrapExchangeError("fallback", ferr))
+}
+
+func (f *fallbackFetcher) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	remaining := make(map[cid.Cid]struct{}, len(ks))
+	keys := make([]cid.Cid, 0, len(ks))
+	for _, c := range ks {
+		if _, ok := remaining[c]; !ok {
+			remaining[c] = struct{}{}
+			keys = append(keys, c)
+		}
+	}
+
+	pctx, pcancel := context.WithCancel(ctx)
+	primary, err := f.primary.GetBlocks(pctx, keys)
+	if err != nil {
+		pcancel()
+		logger.Debugf("primary exchange failed, trying the fallback: %s", err)
+		fallback, ferr := f.fallback.GetBlocks(ctx, keys)
+		if ferr != nil {
+			return nil, errors.Join(wrapExchangeError("primary", err), wrapExchangeError("fallback", ferr))
+		}
+		return fallback, nil
+	}
+
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		f.forward(ctx, primary, remaining, out)
+		pcancel()
+		if len(remaining) == 0 || ctx.Err() != nil {
+			return
+		}
+
+		rest := make([]cid.Cid, 0, len(remaining))
+		for _, c := range keys {
+			if _, ok := remaining[c]; ok {
+				rest = append(rest, c)
+			}
+		}
+		logger.Debugf("primary exchange stalled, fetching %d blocks from the fallback", len(rest))
+		fallback, err := f.fallback.GetBlocks(ctx, rest)
+		if err != nil {
+			logger.Debugf("fallback exchange failed: %s", err)
+			return
+		}
+		f.forward(ctx, fallback, remaining, out)
+	}()
+	return out, nil
+}
+
+// forward sends the requested blocks received from in, until in is closed,
+// all blocks were received, or no block arrived for the timeout.
+func (f *fallbackFetcher) forward(ctx context.Context, in <-chan blocks.Block, remaining map[cid.Cid]struct{}, out chan<- blocks.Block) {
+	var stall <-chan time.Time
+	var timer *time.Timer
+	if f.timeout > 0 {
+		timer = time.NewTimer(f.timeout)
+		defer timer.Stop()
+		stall = timer.C
+	}
+
+	for len(remaining) > 0 {
+		select {
+		case blk, ok := <-in:
+			if !ok {
+				return
+			}
+			if _, ok := remaining[blk.Cid()]; !ok {
+				continue
+			}
+			delete(remaining, blk.Cid())
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+				return
+			}
+			if timer != nil {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(f.timeout)
+			}
+		case <-stall:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close closes the exchange sessions of the fetcher.
+func (f *fallbackFetcher) Close() error {
+	var errs []error
+	for _, c := range f.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,190 @@
+package blockservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	butil "github.com/ipfs/go-ipfs-blocksutil"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// stallingExchange returns the blocks it has and hangs on the others until
+// the request is canceled, like an exchange without providers would.
+type stallingExchange struct {
+	lk        sync.Mutex
+	have      map[cid.Cid]blocks.Block
+	requested []cid.Cid
+	notified  int
+}
+
+func newStallingExchange(blks []blocks.Block) *stallingExchange {
+	e := &stallingExchange{have: make(map[cid.Cid]blocks.Block)}
+	for _, b := range blks {
+		e.have[b.Cid()] = b
+	}
+	return e
+}
+
+func (e *stallingExchange) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	e.lk.Lock()
+	e.requested = append(e.requested, c)
+	b, ok := e.have[c]
+	e.lk.Unlock()
+	if ok {
+		return b, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (e *stallingExchange) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	e.lk.Lock()
+	e.requested = append(e.requested, ks...)
+	var found []blocks.Block
+	for _, c := range ks {
+		if b, ok := e.have[c]; ok {
+			found = append(found, b)
+		}
+	}
+	complete := len(found) == len(ks)
+	e.lk.Unlock()
+
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		for _, b := range found {
+			select {
+			case out <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !complete {
+			<-ctx.Done()
+		}
+	}()
+	return out, nil
+}
+
+func (e *stallingExchange) NotifyNewBlocks(ctx context.Context, blks ...blocks.Block) error {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+	e.notified += len(blks)
+	return nil
+}
+
+func (e *stallingExchange) Close() error {
+	return nil
+}
+
+func (e *stallingExchange) getRequested() []cid.Cid {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+	return append([]cid.Cid(nil), e.requested...)
+}
+
+func TestFallbackExchangeGetBlocks(t *testing.T) {
+	t.Parallel()
+
+	bgen := butil.NewBlockGenerator()
+	blks := bgen.Blocks(20)
+	var ks []cid.Cid
+	var even, odd []blocks.Block
+	for i, b := range blks {
+		ks = append(ks, b.Cid())
+		if i%2 == 0 {
+			even = append(even, b)
+		} else {
+			odd = append(odd, b)
+		}
+	}
+	primary := newStallingExchange(even)
+	fallback := newStallingExchange(blks)
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bserv := New(bs, primary, WithFallbackExchange(fallback, 50*time.Millisecond))
+
+	got := make(map[cid.Cid]struct{})
+	for b := range bserv.GetBlocks(context.Background(), ks) {
+		got[b.Cid()] = struct{}{}
+	}
+	if len(got) != len(blks) {
+		t.Fatalf("expected %d blocks, got %d", len(blks), len(got))
+	}
+
+	requested := fallback.getRequested()
+	if len(requested) != len(odd) {
+		t.Fatalf("expected the fallback to be asked for %d blocks, got %d", len(odd), len(requested))
+	}
+	for i, c := range requested {
+		if !c.Equals(odd[i].Cid()) {
+			t.Fatalf("fallback asked for %s, expected %s", c, odd[i].Cid())
+		}
+	}
+
+	// Both exchanges are told about the fetched blocks.
+	if primary.notified != len(blks) || fallback.notified != len(blks) {
+		t.Fatalf("expected %d notifications, got %d and %d", len(blks), primary.notified, fallback.notified)
+	}
+}
+
+func TestFallbackExchangeGetBlock(t *testing.T) {
+	t.Parallel()
+
+	bgen := butil.NewBlockGenerator()
+	blks := bgen.Blocks(3)
+	primary := newStallingExchange(blks[:1])
+	fallback := newStallingExchange(blks[:2])
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bserv := New(bs, primary, WithFallbackExchange(fallback, 20*time.Millisecond))
+	ctx := context.Background()
+
+	for _, b := range blks[:2] {
+		got, err := bserv.GetBlock(ctx, b.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Cid().Equals(b.Cid()) {
+			t.Fatal("got the wrong block")
+		}
+	}
+	if n := len(fallback.getRequested()); n != 1 {
+		t.Fatalf("expected one fallback request, got %d", n)
+	}
+
+	// Sessions use the fallback too.
+	sess := NewSession(ctx, New(blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), primary, WithFallbackExchange(fallback, 20*time.Millisecond)))
+	defer sess.Close()
+	if _, err := sess.GetBlock(ctx, blks[1].Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := bserv.GetBlock(ctx, blks[2].Cid())
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("expected the errors of both exchanges, got %v", err)
+	}
+	var names []string
+	for _, e := range joined.Unwrap() {
+		var xerr *ExchangeError
+		if !errors.As(e, &xerr) || !errors.Is(xerr, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error %v", e)
+		}
+		names = append(names, xerr.Exchange)
+	}
+	if len(names) != 2 || names[0] != "primary" || names[1] != "fallback" {
+		t.Fatalf("unexpected failed exchanges %v", names)
+	}
+	if ipld.IsNotFound(err) {
+		t.Fatal("timeouts are not not found errors")
+	}
+}
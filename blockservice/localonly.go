@@ -0,0 +1,23 @@
+package blockservice
+
+import "context"
+
+type localOnlyKey struct{}
+
+// ContextWithLocalOnly returns a context making GetBlock, GetBlocks and
+// GetBlocksOrdered, on the blockservice or its sessions, only read from the
+// blockstore. Missing blocks are reported as ipld.ErrNotFound (GetBlock) or
+// missing from the results (GetBlocks), without touching the exchange.
+//
+// This is meant for code paths which must never fetch from the network,
+// such as pin verification or only-if-cached gateway requests, but are given
+// an online blockservice.
+func ContextWithLocalOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localOnlyKey{}, true)
+}
+
+// IsLocalOnly reports whether ctx was returned by ContextWithLocalOnly.
+func IsLocalOnly(ctx context.Context) bool {
+	lo, _ := ctx.Value(localOnlyKey{}).(bool)
+	return lo
+}
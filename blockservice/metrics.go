@@ -0,0 +1,116 @@
+package blockservice
+
+import (
+	"time"
+
+	"github.com/ipfs/boxo/internal/promutil"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var exchangeFetchDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60}
+
+// WithMetrics registers the metrics of the blockservice with reg. The
+// metrics are labeled with name (label "service"), so several blockservices
+// can share a registry:
+//
+//   - ipfs_blockservice_served_blocks_total and
+//     ipfs_blockservice_served_bytes_total count the blocks returned by
+//     GetBlock(s), labeled by source: "blockstore" or "exchange".
+//   - ipfs_blockservice_exchange_fetch_duration_seconds is the time to
+//     receive each block from the exchange.
+//   - ipfs_blockservice_notified_blocks_total counts the blocks passed to
+//     the NotifyNewBlocks of the exchange.
+//
+// Sessions created from the blockservice are included.
+func WithMetrics(reg prometheus.Registerer, name string) Option {
+	return func(bs *blockService) {
+		bs.metrics = newMetrics(reg, name)
+	}
+}
+
+// metrics is nil when WithMetrics is not used, all its methods are no-ops
+// then.
+type metrics struct {
+	blocksBlockstore prometheus.Counter
+	bytesBlockstore  prometheus.Counter
+	blocksExchange   prometheus.Counter
+	bytesExchange    prometheus.Counter
+	fetchDuration    prometheus.Observer
+	notified         prometheus.Counter
+}
+
+func newMetrics(reg prometheus.Registerer, name string) *metrics {
+	blocks := promutil.Register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "blockservice",
+			Name:      "served_blocks_total",
+			Help:      "The number of blocks returned by the blockservice, by source.",
+		},
+		[]string{"service", "source"},
+	), logger)
+	bytes := promutil.Register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "blockservice",
+			Name:      "served_bytes_total",
+			Help:      "The size of the blocks returned by the blockservice, by source.",
+		},
+		[]string{"service", "source"},
+	), logger)
+	fetchDuration := promutil.Register(reg, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "ipfs",
+			Subsystem: "blockservice",
+			Name:      "exchange_fetch_duration_seconds",
+			Help:      "The time to receive a block from the exchange.",
+			Buckets:   exchangeFetchDurationBuckets,
+		},
+		[]string{"service"},
+	), logger)
+	notified := promutil.Register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "blockservice",
+			Name:      "notified_blocks_total",
+			Help:      "The number of blocks announced to the exchange.",
+		},
+		[]string{"service"},
+	), logger)
+
+	return &metrics{
+		blocksBlockstore: blocks.WithLabelValues(name, "blockstore"),
+		bytesBlockstore:  bytes.WithLabelValues(name, "blockstore"),
+		blocksExchange:   blocks.WithLabelValues(name, "exchange"),
+		bytesExchange:    bytes.WithLabelValues(name, "exchange"),
+		fetchDuration:    fetchDuration.WithLabelValues(name),
+		notified:         notified.WithLabelValues(name),
+	}
+}
+
+func (m *metrics) servedFromBlockstore(b blocks.Block) {
+	if m == nil {
+		return
+	}
+	m.blocksBlockstore.Inc()
+	m.bytesBlockstore.Add(float64(len(b.RawData())))
+}
+
+// servedFromExchange records a block received from the exchange for a
+// request started at start.
+func (m *metrics) servedFromExchange(b blocks.Block, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.blocksExchange.Inc()
+	m.bytesExchange.Add(float64(len(b.RawData())))
+	m.fetchDuration.Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) notifiedBlocks(n int) {
+	if m == nil {
+		return
+	}
+	m.notified.Add(float64(n))
+}
@@ -0,0 +1,128 @@
+package blockservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	butil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrape returns the value of the metric with the given labels, the sample
+// count for histograms.
+func scrape(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				return m.GetCounter().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	bs, exch, blks := orderedSetup(t, 6) // 0 and 3 are local
+	reg := prometheus.NewRegistry()
+	bserv := New(bs, exch, WithMetrics(reg, "test"))
+	other := New(bs, exch, WithMetrics(reg, "other"))
+	ctx := context.Background()
+
+	for _, i := range []int{0, 3, 1} {
+		if _, err := bserv.GetBlock(ctx, blks[i].Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range bserv.GetBlocks(ctx, []cid.Cid{blks[0].Cid(), blks[2].Cid()}) {
+	}
+	sess := NewSession(ctx, bserv)
+	defer sess.Close()
+	if _, err := sess.GetBlock(ctx, blks[4].Cid()); err != nil {
+		t.Fatal(err)
+	}
+	added := blocks.NewBlock([]byte("added"))
+	if err := bserv.AddBlocks(ctx, []blocks.Block{added}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.GetBlock(ctx, blks[0].Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	size := float64(len(blks[0].RawData()))
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		expect float64
+	}{
+		{"ipfs_blockservice_served_blocks_total", map[string]string{"service": "test", "source": "blockstore"}, 3},
+		{"ipfs_blockservice_served_blocks_total", map[string]string{"service": "test", "source": "exchange"}, 3},
+		{"ipfs_blockservice_served_bytes_total", map[string]string{"service": "test", "source": "blockstore"}, 3 * size},
+		{"ipfs_blockservice_served_bytes_total", map[string]string{"service": "test", "source": "exchange"}, 3 * size},
+		{"ipfs_blockservice_exchange_fetch_duration_seconds", map[string]string{"service": "test"}, 3},
+		{"ipfs_blockservice_notified_blocks_total", map[string]string{"service": "test"}, 4},
+		{"ipfs_blockservice_served_blocks_total", map[string]string{"service": "other", "source": "blockstore"}, 1},
+	} {
+		if got := scrape(t, reg, tc.name, tc.labels); got != tc.expect {
+			t.Errorf("%s%v: expected %v, got %v", tc.name, tc.labels, tc.expect, got)
+		}
+	}
+}
+
+func TestNoMetricsNoAllocs(t *testing.T) {
+	var m *metrics
+	bgen := butil.NewBlockGenerator()
+	b := bgen.Next()
+	start := time.Now()
+	allocs := testing.AllocsPerRun(100, func() {
+		m.servedFromBlockstore(b)
+		m.servedFromExchange(b, start)
+		m.notifiedBlocks(1)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
+
+func TestMetricsAllocs(t *testing.T) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bgen := butil.NewBlockGenerator()
+	b := bgen.Next()
+	if err := bs.Put(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	without := New(bs, nil)
+	with := New(bs, nil, WithMetrics(prometheus.NewRegistry(), "allocs"))
+
+	base := testing.AllocsPerRun(100, func() { without.GetBlock(ctx, b.Cid()) })
+	instrumented := testing.AllocsPerRun(100, func() { with.GetBlock(ctx, b.Cid()) })
+	if instrumented > base {
+		t.Fatalf("metrics add allocations: %v > %v", instrumented, base)
+	}
+}
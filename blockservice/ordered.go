@@ -0,0 +1,278 @@
+package blockservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice/internal"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/verifcid"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// DefaultOrderedBufferSize is the default maximum number of blocks fetched
+// from the exchange and not yet returned by GetBlocksOrdered.
+const DefaultOrderedBufferSize = 256
+
+// OrderedBlockGetter is implemented by the BlockService returned by New and
+// by Session.
+type OrderedBlockGetter interface {
+	// GetBlocksOrdered is like GetBlocks, but returns the blocks in the
+	// order of ks, including duplicates.
+	//
+	// The channel is closed after the last block, or as soon as a block
+	// cannot be returned. The returned function reports why the channel was
+	// closed early: ipld.ErrNotFound when a block could not be fetched, the
+	// context error on cancellation, or nil once all blocks were returned.
+	// It must only be called after the channel is closed.
+	GetBlocksOrdered(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, func() error)
+}
+
+var (
+	_ OrderedBlockGetter = (*blockService)(nil)
+	_ OrderedBlockGetter = (*Session)(nil)
+)
+
+// WithOrderedBufferSize sets the maximum number of blocks fetched from the
+// exchange ahead of their turn by GetBlocksOrdered. Blocks are requested from
+// the exchange in windows so that no more than n of them are in flight or
+// waiting to be returned. The default is DefaultOrderedBufferSize.
+func WithOrderedBufferSize(n int) Option {
+	return func(bs *blockService) {
+		if n < 1 {
+			n = 1
+		}
+		bs.orderedBufferSize = n
+	}
+}
+
+// GetBlocksOrdered implements OrderedBlockGetter.
+func (s *blockService) GetBlocksOrdered(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, func() error) {
+	ctx, span := internal.StartSpan(ctx, "blockService.GetBlocksOrdered")
+	defer span.End()
+
+	var f func() notifiableFetcher
+	if s.exchange != nil {
+		f = s.getExchange
+	}
+
+	return getBlocksOrdered(ctx, ks, s.blockstore, s.allowlist, f, s.orderedBufferSize, s.metrics)
+}
+
+// GetBlocksOrdered implements OrderedBlockGetter.
+func (s *Session) GetBlocksOrdered(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, func() error) {
+	ctx, span := internal.StartSpan(ctx, "Session.GetBlocksOrdered")
+	defer span.End()
+
+	if s.isClosed() {
+		out := make(chan blocks.Block)
+		close(out)
+		return out, func() error { return ErrSessionClosed }
+	}
+	return getBlocksOrdered(ctx, ks, s.bs, s.allowlist, s.getFetcherFactory(), s.orderedBufferSize, s.metrics)
+}
+
+func getBlocksOrdered(ctx context.Context, ks []cid.Cid, bs blockstore.Blockstore, allowlist verifcid.Allowlist, fget func() notifiableFetcher, bufferSize int, m *metrics) (<-chan blocks.Block, func() error) {
+	if bufferSize < 1 {
+		bufferSize = DefaultOrderedBufferSize
+	}
+	if IsLocalOnly(ctx) {
+		fget = nil
+	}
+	out := make(chan blocks.Block)
+	var err error
+
+	go func() {
+		defer close(out)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		o := &orderedFetch{
+			ctx:     ctx,
+			bs:      bs,
+			limit:   bufferSize,
+			metrics: m,
+			state:   make(map[cid.Cid]*remoteBlock),
+			events:  make(chan orderedEvent),
+		}
+		err = o.run(ks, allowlist, fget, out)
+	}()
+
+	return out, func() error { return err }
+}
+
+// remoteBlock tracks a block missing locally.
+type remoteBlock struct {
+	blk    blocks.Block // received and waiting for its turn
+	failed bool         // the exchange did not find it
+	done   bool         // returned once, now in the blockstore
+}
+
+// orderedEvent is either a block received from the exchange, or the end of
+// the request for the keys of a window.
+type orderedEvent struct {
+	blk   blocks.Block
+	start time.Time // of the request for blk
+	end   []cid.Cid
+}
+
+type orderedFetch struct {
+	ctx     context.Context
+	bs      blockstore.Blockstore
+	f       notifiableFetcher
+	limit   int
+	metrics *metrics
+
+	// misses are the keys missing locally, in order of first occurrence.
+	// misses[:requested] were requested from the exchange, outstanding of
+	// them are not returned yet.
+	misses      []cid.Cid
+	requested   int
+	outstanding int
+	state       map[cid.Cid]*remoteBlock
+	events      chan orderedEvent
+}
+
+func (o *orderedFetch) run(ks []cid.Cid, allowlist verifcid.Allowlist, fget func() notifiableFetcher, out chan<- blocks.Block) error {
+	for _, c := range ks {
+		// hash security
+		if err := validateCid(allowlist, c); err != nil {
+			return err
+		}
+	}
+
+	has, err := blockstore.HasMany(o.ctx, o.bs, ks)
+	if err != nil {
+		return err
+	}
+	for i, c := range ks {
+		if has[i] || o.state[c] != nil {
+			continue
+		}
+		st := &remoteBlock{failed: fget == nil}
+		o.state[c] = st
+		o.misses = append(o.misses, c)
+	}
+	if len(o.misses) > 0 && fget != nil {
+		o.f = fget() // don't load exchange unless we have to
+	}
+
+	if err := o.refill(); err != nil {
+		return err
+	}
+	for _, c := range ks {
+		var blk blocks.Block
+		st := o.state[c]
+		if st == nil || st.done {
+			blk, err = o.bs.Get(o.ctx, c)
+			if err != nil {
+				return err
+			}
+			o.metrics.servedFromBlockstore(blk)
+		} else {
+			for st.blk == nil && !st.failed {
+				if err := o.wait(); err != nil {
+					return err
+				}
+			}
+			if st.failed {
+				return ipld.ErrNotFound{Cid: c}
+			}
+			blk = st.blk
+			st.blk = nil // early gc
+			st.done = true
+			o.outstanding--
+			if err := o.refill(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case out <- blk:
+		case <-o.ctx.Done():
+			return o.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// refill requests the next window of missing blocks once at most half of
+// the buffer is in use.
+func (o *orderedFetch) refill() error {
+	if o.f == nil || o.outstanding > o.limit/2 {
+		return nil
+	}
+	n := o.limit - o.outstanding
+	if rest := len(o.misses) - o.requested; rest < n {
+		n = rest
+	}
+	if n == 0 {
+		return nil
+	}
+	window := o.misses[o.requested : o.requested+n]
+	o.requested += n
+	o.outstanding += n
+
+	start := time.Now()
+	rblocks, err := o.f.GetBlocks(o.ctx, window)
+	if err != nil {
+		logger.Debugf("Error with GetBlocks: %s", err)
+		return err
+	}
+	go func() {
+		for b := range rblocks {
+			select {
+			case o.events <- orderedEvent{blk: b, start: start}:
+			case <-o.ctx.Done():
+				return
+			}
+		}
+		select {
+		case o.events <- orderedEvent{end: window}:
+		case <-o.ctx.Done():
+		}
+	}()
+	return nil
+}
+
+// wait processes the next event from the exchange.
+func (o *orderedFetch) wait() error {
+	var ev orderedEvent
+	select {
+	case ev = <-o.events:
+	case <-o.ctx.Done():
+		return o.ctx.Err()
+	}
+
+	for _, c := range ev.end {
+		if st := o.state[c]; st.blk == nil && !st.done {
+			st.failed = true
+		}
+	}
+	if ev.blk == nil {
+		return nil
+	}
+
+	st := o.state[ev.blk.Cid()]
+	if st == nil || st.done || st.blk != nil {
+		return nil
+	}
+	o.metrics.servedFromExchange(ev.blk, ev.start)
+
+	// write in the blockstore for caching, later duplicates are read from
+	// there
+	if err := o.bs.Put(o.ctx, ev.blk); err != nil {
+		logger.Errorf("could not write blocks from the network to the blockstore: %s", err)
+		return err
+	}
+	// inform the exchange that the block is available
+	o.metrics.notifiedBlocks(1)
+	if err := o.f.NotifyNewBlocks(o.ctx, ev.blk); err != nil {
+		logger.Errorf("could not tell the exchange about new blocks: %s", err)
+		return err
+	}
+	st.blk = ev.blk
+	return nil
+}
@@ -0,0 +1,207 @@
+package blockservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	butil "github.com/ipfs/go-ipfs-blocksutil"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// reversingExchange returns the blocks of each GetBlocks request in the
+// reverse order of the request.
+type reversingExchange struct {
+	lk        sync.Mutex
+	remote    map[cid.Cid]blocks.Block
+	requested int
+}
+
+func (e *reversingExchange) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+	if b, ok := e.remote[c]; ok {
+		return b, nil
+	}
+	return nil, ipld.ErrNotFound{Cid: c}
+}
+
+func (e *reversingExchange) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	e.lk.Lock()
+	e.requested += len(ks)
+	var found []blocks.Block
+	for i := len(ks) - 1; i >= 0; i-- {
+		if b, ok := e.remote[ks[i]]; ok {
+			found = append(found, b)
+		}
+	}
+	e.lk.Unlock()
+
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		for _, b := range found {
+			select {
+			case out <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (e *reversingExchange) NotifyNewBlocks(ctx context.Context, blocks ...blocks.Block) error {
+	return nil
+}
+
+func (e *reversingExchange) Close() error {
+	return nil
+}
+
+func (e *reversingExchange) getRequested() int {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+	return e.requested
+}
+
+// orderedSetup stores every third block locally and the others in the
+// exchange.
+func orderedSetup(t *testing.T, n int) (blockstore.Blockstore, *reversingExchange, []blocks.Block) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	exch := &reversingExchange{remote: make(map[cid.Cid]blocks.Block)}
+	bgen := butil.NewBlockGenerator()
+	blks := bgen.Blocks(n)
+	for i, b := range blks {
+		if i%3 == 0 {
+			if err := bs.Put(context.Background(), b); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			exch.remote[b.Cid()] = b
+		}
+	}
+	return bs, exch, blks
+}
+
+func TestGetBlocksOrdered(t *testing.T) {
+	t.Parallel()
+
+	const bufferSize = 8
+	bs, exch, blks := orderedSetup(t, 100)
+	bserv := New(bs, exch, WithOrderedBufferSize(bufferSize))
+
+	var ks []cid.Cid
+	for _, b := range blks {
+		ks = append(ks, b.Cid())
+	}
+	// Duplicates of local and remote blocks.
+	ks = append(ks, blks[1].Cid(), blks[0].Cid(), blks[50].Cid())
+
+	out, errf := bserv.(OrderedBlockGetter).GetBlocksOrdered(context.Background(), ks)
+	var i, remote int
+	for b := range out {
+		if i >= len(ks) || !b.Cid().Equals(ks[i]) {
+			t.Fatalf("block %d out of order: %s", i, b.Cid())
+		}
+		if _, ok := exch.remote[b.Cid()]; ok {
+			remote++
+		}
+		// At most one more block was taken from the buffer while this one
+		// was being received.
+		if inFlight := exch.getRequested() - remote; inFlight > bufferSize+1 {
+			t.Fatalf("%d blocks in flight, the buffer is %d", inFlight, bufferSize)
+		}
+		i++
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(ks) {
+		t.Fatalf("expected %d blocks, got %d", len(ks), i)
+	}
+	// Remote blocks are requested once, duplicates come from the blockstore.
+	if n := exch.getRequested(); n != len(exch.remote) {
+		t.Fatalf("expected %d requested blocks, got %d", len(exch.remote), n)
+	}
+}
+
+func TestGetBlocksOrderedMissing(t *testing.T) {
+	t.Parallel()
+
+	bs, exch, blks := orderedSetup(t, 20)
+	missing := blks[10].Cid()
+	delete(exch.remote, missing)
+
+	var ks []cid.Cid
+	for _, b := range blks {
+		ks = append(ks, b.Cid())
+	}
+
+	for name, getter := range map[string]OrderedBlockGetter{
+		"blockservice": New(bs, exch, WithOrderedBufferSize(4)).(OrderedBlockGetter),
+		"session":      NewSession(context.Background(), New(bs, exch, WithOrderedBufferSize(4))),
+	} {
+		out, errf := getter.GetBlocksOrdered(context.Background(), ks)
+		var i int
+		for b := range out {
+			if !b.Cid().Equals(ks[i]) {
+				t.Fatalf("%s: block %d out of order", name, i)
+			}
+			i++
+		}
+		if i != 10 {
+			t.Fatalf("%s: expected the 10 blocks before the missing one, got %d", name, i)
+		}
+		var notFound ipld.ErrNotFound
+		if err := errf(); !errors.As(err, &notFound) || !notFound.Cid.Equals(missing) {
+			t.Fatalf("%s: expected not found for %s, got %v", name, missing, err)
+		}
+	}
+}
+
+func TestGetBlocksOrderedOffline(t *testing.T) {
+	t.Parallel()
+
+	bs, _, blks := orderedSetup(t, 6)
+	bserv := New(bs, nil)
+
+	out, errf := bserv.(OrderedBlockGetter).GetBlocksOrdered(context.Background(), []cid.Cid{blks[0].Cid(), blks[3].Cid(), blks[1].Cid()})
+	var got int
+	for range out {
+		got++
+	}
+	if got != 2 {
+		t.Fatalf("expected the 2 local blocks, got %d", got)
+	}
+	if err := errf(); !ipld.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestGetBlocksOrderedCancel(t *testing.T) {
+	t.Parallel()
+
+	bs, exch, blks := orderedSetup(t, 30)
+	bserv := New(bs, exch)
+
+	var ks []cid.Cid
+	for _, b := range blks {
+		ks = append(ks, b.Cid())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errf := bserv.(OrderedBlockGetter).GetBlocksOrdered(ctx, ks)
+	<-out
+	cancel()
+	for range out {
+	}
+	if err := errf(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package blockservice
+
+import (
+	"context"
+	"errors"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrSessionClosed is returned when using a Session after it was closed.
+var ErrSessionClosed = errors.New("blockservice session is closed")
+
+type sessionContextKey struct{}
+
+// ContextWithSession returns a context carrying s. Code receiving the context
+// can retrieve it with SessionFromContext instead of creating its own
+// session, so that all the fetches of a request share a single session.
+//
+// The session is still tied to the context it was created with: it is not
+// closed when the returned context is done, unless that context derives from
+// the session's one.
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, s)
+}
+
+// SessionFromContext returns the session attached to ctx by
+// ContextWithSession, or nil if there is none or it was closed.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionContextKey{}).(*Session)
+	if s == nil || s.isClosed() {
+		return nil
+	}
+	return s
+}
+
+// closedFetcher is used by closed sessions, in place of the exchange.
+type closedFetcher struct{}
+
+func (closedFetcher) GetBlock(context.Context, cid.Cid) (blocks.Block, error) {
+	return nil, ErrSessionClosed
+}
+
+func (closedFetcher) GetBlocks(context.Context, []cid.Cid) (<-chan blocks.Block, error) {
+	return nil, ErrSessionClosed
+}
+
+func (closedFetcher) NotifyNewBlocks(context.Context, ...blocks.Block) error {
+	return ErrSessionClosed
+}
@@ -0,0 +1,174 @@
+package blockservice
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	exchange "github.com/ipfs/boxo/exchange"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	butil "github.com/ipfs/go-ipfs-blocksutil"
+)
+
+// closeCountingExchange counts the sessions it created which are not closed.
+// Like the bitswap ones, its sessions are closed once their context is done.
+type closeCountingExchange struct {
+	exchange.Interface
+	open atomic.Int64
+}
+
+func (e *closeCountingExchange) NewSession(ctx context.Context) exchange.Fetcher {
+	e.open.Add(1)
+	f := &closeCountingFetcher{Fetcher: e.Interface, ex: e}
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	return f
+}
+
+type closeCountingFetcher struct {
+	exchange.Fetcher
+	ex   *closeCountingExchange
+	once sync.Once
+}
+
+func (f *closeCountingFetcher) Close() error {
+	f.once.Do(func() { f.ex.open.Add(-1) })
+	return nil
+}
+
+func newCloseCountingService() (BlockService, *closeCountingExchange) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	exch := &closeCountingExchange{Interface: offline.Exchange(bs)}
+	return New(bs, exch), exch
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	bserv, exch := newCloseCountingService()
+	bgen := butil.NewBlockGenerator()
+	block := bgen.Next()
+
+	sess := NewSession(context.Background(), bserv)
+	// The exchange session is only created once needed.
+	sess.GetBlock(context.Background(), block.Cid())
+	if n := exch.open.Load(); n != 1 {
+		t.Fatalf("expected 1 open exchange session, got %d", n)
+	}
+
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := exch.open.Load(); n != 0 {
+		t.Fatalf("expected the exchange session to be closed, %d open", n)
+	}
+
+	if _, err := sess.GetBlock(context.Background(), block.Cid()); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+	if _, ok := <-sess.GetBlocks(context.Background(), nil); ok {
+		t.Fatal("expected a closed channel")
+	}
+}
+
+func TestSessionClosedWithContext(t *testing.T) {
+	bserv, exch := newCloseCountingService()
+	bgen := butil.NewBlockGenerator()
+	block := bgen.Next()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := NewSession(ctx, bserv)
+	sess.GetBlock(ctx, block.Cid())
+	cancel()
+
+	waitFor(t, "the session to close", func() bool { return exch.open.Load() == 0 })
+	if _, err := sess.GetBlock(context.Background(), block.Cid()); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+}
+
+func TestSessionNoLeaks(t *testing.T) {
+	bserv, exch := newCloseCountingService()
+	bgen := butil.NewBlockGenerator()
+	block := bgen.Next()
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 1000; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		sess := NewSession(ctx, bserv)
+		rctx := ContextWithSession(ctx, sess)
+		SessionFromContext(rctx).GetBlock(rctx, block.Cid())
+		// Half the requests close explicitly, the others rely on the
+		// context.
+		if i%2 == 0 {
+			sess.Close()
+		}
+		cancel()
+	}
+
+	waitFor(t, "sessions to close", func() bool { return exch.open.Load() == 0 })
+	waitFor(t, "goroutines to exit", func() bool { return runtime.NumGoroutine() <= goroutines })
+
+	// the sessions of a long-lived context which are never used nor closed
+	// do not start goroutines
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 1000; i++ {
+		NewSession(ctx, bserv)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("%d goroutines started by the sessions", n-goroutines)
+	}
+}
+
+func TestSessionFromContext(t *testing.T) {
+	bserv, _ := newCloseCountingService()
+	ctx := context.Background()
+
+	if SessionFromContext(ctx) != nil {
+		t.Fatal("expected no session")
+	}
+
+	a := NewSession(ctx, bserv)
+	b := NewSession(ctx, bserv)
+	defer b.Close()
+	if a == b {
+		t.Fatal("expected distinct sessions")
+	}
+
+	shared := ContextWithSession(ctx, a)
+	if SessionFromContext(shared) != a {
+		t.Fatal("expected the attached session")
+	}
+	child, cancel := context.WithCancel(shared)
+	defer cancel()
+	if SessionFromContext(child) != a {
+		t.Fatal("expected the session to be inherited")
+	}
+
+	a.Close()
+	if SessionFromContext(shared) != nil {
+		t.Fatal("closed sessions must not be returned")
+	}
+}
@@ -0,0 +1,107 @@
+package bstest
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/ipfs/boxo/blockservice"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	exchange "github.com/ipfs/boxo/exchange"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// forbiddenExchange fails the test when used to fetch blocks.
+type forbiddenExchange struct {
+	t *testing.T
+}
+
+var _ exchange.SessionExchange = forbiddenExchange{}
+
+func (e forbiddenExchange) GetBlock(context.Context, cid.Cid) (blocks.Block, error) {
+	e.t.Error("the exchange must not be called")
+	return nil, ipld.ErrNotFound{}
+}
+
+func (e forbiddenExchange) GetBlocks(context.Context, []cid.Cid) (<-chan blocks.Block, error) {
+	e.t.Error("the exchange must not be called")
+	out := make(chan blocks.Block)
+	close(out)
+	return out, nil
+}
+
+func (e forbiddenExchange) NewSession(context.Context) exchange.Fetcher {
+	e.t.Error("no exchange session must be created")
+	return e
+}
+
+func (forbiddenExchange) NotifyNewBlocks(context.Context, ...blocks.Block) error {
+	return nil
+}
+
+func (forbiddenExchange) Close() error {
+	return nil
+}
+
+func TestLocalOnly(t *testing.T) {
+	bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bs := New(bstore, forbiddenExchange{t})
+	ctx := ContextWithLocalOnly(context.Background())
+
+	local := merkledag.NodeWithData([]byte("local"))
+	missing := merkledag.NodeWithData([]byte("missing"))
+	if err := bstore.Put(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	ks := []cid.Cid{local.Cid(), missing.Cid()}
+
+	for name, getter := range map[string]BlockGetter{
+		"blockservice": bs,
+		"session":      NewSession(ctx, bs),
+	} {
+		if _, err := getter.GetBlock(ctx, local.Cid()); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := getter.GetBlock(ctx, missing.Cid()); !ipld.IsNotFound(err) {
+			t.Fatalf("%s: expected not found, got %v", name, err)
+		}
+
+		var got int
+		for range getter.GetBlocks(ctx, ks) {
+			got++
+		}
+		if got != 1 {
+			t.Fatalf("%s: expected the local block only, got %d blocks", name, got)
+		}
+
+		out, errf := getter.(OrderedBlockGetter).GetBlocksOrdered(ctx, ks)
+		for range out {
+		}
+		if err := errf(); !ipld.IsNotFound(err) {
+			t.Fatalf("%s: expected not found, got %v", name, err)
+		}
+	}
+
+	// Through a DAGService, which uses a session for its batch operations.
+	dag := merkledag.NewDAGService(bs)
+	if _, err := dag.Get(ctx, local.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dag.Get(ctx, missing.Cid()); !ipld.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	var got int
+	for opt := range merkledag.NewSession(ctx, dag).GetMany(ctx, ks) {
+		if opt.Err == nil {
+			got++
+		}
+	}
+	if got != 1 {
+		t.Fatalf("expected the local node only, got %d nodes", got)
+	}
+}
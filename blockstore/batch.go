@@ -0,0 +1,387 @@
+package blockstore
+
+import (
+	"context"
+	"sort"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// BatchReader can be implemented by blockstores able to look up many blocks
+// at once more cheaply than with one call per block, like the caches which
+// skip the blocks they know to be missing. Use the HasMany, GetMany and
+// ViewMany functions to take advantage of it while falling back to one call
+// per block for other blockstores.
+type BatchReader interface {
+	// HasMany reports, for each key, whether the block is present. The
+	// returned slice has the same length and order as keys.
+	HasMany(ctx context.Context, keys []cid.Cid) ([]bool, error)
+
+	// GetMany streams the blocks found for keys, in no particular order.
+	// Missing blocks are skipped. The error channel receives at most one
+	// error and is closed, like the block channel, once the lookup is over.
+	GetMany(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, <-chan error)
+
+	// ViewMany calls callback for each block found, with the same
+	// restrictions on the byte slice as Viewer.View. Missing blocks are
+	// skipped. Errors returned by the callback abort the lookup and are
+	// returned.
+	ViewMany(ctx context.Context, keys []cid.Cid, callback func(cid.Cid, []byte) error) error
+}
+
+// HasMany reports, for each key, whether bs has the block, using
+// BatchReader when implemented.
+func HasMany(ctx context.Context, bs Blockstore, keys []cid.Cid) ([]bool, error) {
+	if br, ok := bs.(BatchReader); ok {
+		return br.HasMany(ctx, keys)
+	}
+	out := make([]bool, len(keys))
+	for i, k := range keys {
+		has, err := bs.Has(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = has
+	}
+	return out, nil
+}
+
+// GetMany streams the blocks of bs found for keys, using BatchReader when
+// implemented. See BatchReader.GetMany.
+func GetMany(ctx context.Context, bs Blockstore, keys []cid.Cid) (<-chan blocks.Block, <-chan error) {
+	if br, ok := bs.(BatchReader); ok {
+		return br.GetMany(ctx, keys)
+	}
+	return getManyFunc(ctx, keys, func(ctx context.Context, keys []cid.Cid, f func(blocks.Block) error) error {
+		for _, k := range keys {
+			blk, err := bs.Get(ctx, k)
+			if ipld.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := f(blk); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ViewMany calls callback for each block of bs found for keys, using
+// BatchReader or Viewer when implemented. See BatchReader.ViewMany.
+func ViewMany(ctx context.Context, bs Blockstore, keys []cid.Cid, callback func(cid.Cid, []byte) error) error {
+	if br, ok := bs.(BatchReader); ok {
+		return br.ViewMany(ctx, keys, callback)
+	}
+	v, isViewer := bs.(Viewer)
+	for _, k := range keys {
+		var err error
+		if isViewer {
+			err = v.View(ctx, k, func(data []byte) error {
+				return callback(k, data)
+			})
+		} else {
+			var blk blocks.Block
+			blk, err = bs.Get(ctx, k)
+			if err == nil {
+				err = callback(k, blk.RawData())
+			}
+		}
+		if err != nil && !ipld.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getManyFunc runs get in a goroutine, which calls its last argument for
+// every block found, and streams the results on the returned channels.
+func getManyFunc(ctx context.Context, keys []cid.Cid, get func(context.Context, []cid.Cid, func(blocks.Block) error) error) (<-chan blocks.Block, <-chan error) {
+	out := make(chan blocks.Block)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(out)
+		defer close(errs)
+		err := get(ctx, keys, func(blk blocks.Block) error {
+			select {
+			case out <- blk:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return out, errs
+}
+
+var _ BatchReader = (*tqcache)(nil)
+
+// HasMany answers from the cache when possible and looks the other keys up
+// with HasMany on the underlying blockstore, caching the results.
+func (b *tqcache) HasMany(ctx context.Context, keys []cid.Cid) ([]bool, error) {
+	out := make([]bool, len(keys))
+	var unknown []int
+	for i, k := range keys {
+		if !k.Defined() {
+			continue
+		}
+		if has, _, ok := b.queryCache(cacheKey(k)); ok {
+			if has {
+				b.hasStats.positiveHit()
+			} else {
+				b.hasStats.negativeHit()
+			}
+			out[i] = has
+			continue
+		}
+		b.hasStats.miss()
+		unknown = append(unknown, i)
+	}
+	if len(unknown) == 0 {
+		return out, nil
+	}
+
+	toQuery := make([]cid.Cid, len(unknown))
+	lockKeys := make([]string, len(unknown))
+	for j, i := range unknown {
+		toQuery[j] = keys[i]
+		lockKeys[j] = cacheKey(keys[i])
+	}
+	// Take the locks in order and only once per key to avoid deadlocks.
+	sort.Strings(lockKeys)
+	lockKeys = dedupSorted(lockKeys)
+	for _, key := range lockKeys {
+		b.lock(key, false)
+	}
+	defer func() {
+		for _, key := range lockKeys {
+			b.unlock(key, false)
+		}
+	}()
+
+	res, err := HasMany(ctx, b.blockstore, toQuery)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range unknown {
+		out[i] = res[j]
+		b.cacheHave(cacheKey(keys[i]), res[j])
+	}
+	return out, nil
+}
+
+// GetMany skips the keys the cache knows to be missing.
+func (b *tqcache) GetMany(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, <-chan error) {
+	return GetMany(ctx, b.blockstore, b.filterMissing(keys, b.getStats))
+}
+
+// ViewMany skips the keys the cache knows to be missing.
+func (b *tqcache) ViewMany(ctx context.Context, keys []cid.Cid, callback func(cid.Cid, []byte) error) error {
+	return ViewMany(ctx, b.blockstore, b.filterMissing(keys, b.getStats), callback)
+}
+
+func (b *tqcache) filterMissing(keys []cid.Cid, stats *cacheOpCounters) []cid.Cid {
+	out := make([]cid.Cid, 0, len(keys))
+	for _, k := range keys {
+		if !k.Defined() {
+			continue
+		}
+		has, _, ok := b.queryCache(cacheKey(k))
+		switch {
+		case !ok:
+			stats.miss()
+		case !has:
+			stats.negativeHit()
+			continue
+		default:
+			stats.positiveHit()
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+func dedupSorted(s []string) []string {
+	if len(s) == 0 {
+		return s
+	}
+	j := 0
+	for i := 1; i < len(s); i++ {
+		if s[j] == s[i] {
+			continue
+		}
+		j++
+		s[j] = s[i]
+	}
+	return s[:j+1]
+}
+
+var _ BatchReader = (*bloomcache)(nil)
+
+// HasMany answers from the bloom filter for the keys it knows to be missing
+// and looks the others up with HasMany on the underlying blockstore.
+func (b *bloomcache) HasMany(ctx context.Context, keys []cid.Cid) ([]bool, error) {
+	out := make([]bool, len(keys))
+	var unknown []int
+	for i, k := range keys {
+		if has, ok := b.hasCached(k); ok && !has {
+			continue
+		}
+		unknown = append(unknown, i)
+	}
+	if len(unknown) == 0 {
+		return out, nil
+	}
+	toQuery := make([]cid.Cid, len(unknown))
+	for j, i := range unknown {
+		toQuery[j] = keys[i]
+	}
+	res, err := HasMany(ctx, b.blockstore, toQuery)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range unknown {
+		out[i] = res[j]
+	}
+	return out, nil
+}
+
+// GetMany skips the keys the bloom filter knows to be missing.
+func (b *bloomcache) GetMany(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, <-chan error) {
+	return GetMany(ctx, b.blockstore, b.filterMissing(keys))
+}
+
+// ViewMany skips the keys the bloom filter knows to be missing.
+func (b *bloomcache) ViewMany(ctx context.Context, keys []cid.Cid, callback func(cid.Cid, []byte) error) error {
+	return ViewMany(ctx, b.blockstore, b.filterMissing(keys), callback)
+}
+
+func (b *bloomcache) filterMissing(keys []cid.Cid) []cid.Cid {
+	out := make([]cid.Cid, 0, len(keys))
+	for _, k := range keys {
+		if has, ok := b.hasCached(k); ok && !has {
+			continue
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+var _ BatchReader = (*lrucache)(nil)
+
+// HasMany answers from the cache when possible and looks the other keys up
+// with HasMany on the underlying blockstore, remembering the missing blocks.
+func (b *lrucache) HasMany(ctx context.Context, keys []cid.Cid) ([]bool, error) {
+	out := make([]bool, len(keys))
+	var unknown []int
+	for i, k := range keys {
+		if !k.Defined() {
+			continue
+		}
+		if blk, missing := b.lookup(cacheKey(k)); blk != nil {
+			b.hasStats.positiveHit()
+			out[i] = true
+			continue
+		} else if missing {
+			b.hasStats.negativeHit()
+			continue
+		}
+		b.hasStats.miss()
+		unknown = append(unknown, i)
+	}
+	if len(unknown) == 0 {
+		return out, nil
+	}
+
+	toQuery := make([]cid.Cid, len(unknown))
+	for j, i := range unknown {
+		toQuery[j] = keys[i]
+	}
+	epoch := b.epoch.Load()
+	res, err := HasMany(ctx, b.blockstore, toQuery)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range unknown {
+		out[i] = res[j]
+		if !res[j] {
+			b.addMissing(cacheKey(keys[i]), epoch)
+		}
+	}
+	return out, nil
+}
+
+// GetMany returns the cached blocks and looks the other keys up with GetMany
+// on the underlying blockstore, caching the results.
+func (b *lrucache) GetMany(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, <-chan error) {
+	return getManyFunc(ctx, keys, b.getMany)
+}
+
+// ViewMany is like GetMany.
+func (b *lrucache) ViewMany(ctx context.Context, keys []cid.Cid, callback func(cid.Cid, []byte) error) error {
+	return b.getMany(ctx, keys, func(blk blocks.Block) error {
+		return callback(blk.Cid(), blk.RawData())
+	})
+}
+
+func (b *lrucache) getMany(ctx context.Context, keys []cid.Cid, f func(blocks.Block) error) error {
+	var unknown []cid.Cid
+	for _, k := range keys {
+		if !k.Defined() {
+			continue
+		}
+		key := cacheKey(k)
+		blk, missing := b.lookup(key)
+		switch {
+		case blk != nil:
+			b.getStats.positiveHit()
+			blk, err := b.cached(key, k, blk)
+			if err != nil {
+				return err
+			}
+			if err := f(blk); err != nil {
+				return err
+			}
+		case missing:
+			b.getStats.negativeHit()
+		default:
+			b.getStats.miss()
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	// cancelling the lookup stops it on errors
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	epoch := b.epoch.Load()
+	found := make(map[string]struct{}, len(unknown))
+	blks, errs := GetMany(ctx, b.blockstore, unknown)
+	for blk := range blks {
+		key := cacheKey(blk.Cid())
+		found[key] = struct{}{}
+		b.add(key, blk, epoch)
+		if err := f(blk); err != nil {
+			return err
+		}
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	for _, k := range unknown {
+		key := cacheKey(k)
+		if _, ok := found[key]; !ok {
+			b.addMissing(key, epoch)
+		}
+	}
+	return nil
+}
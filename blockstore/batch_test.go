@@ -0,0 +1,215 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+)
+
+// countingDS counts the calls made to it.
+type countingDS struct {
+	ds.Batching
+	calls atomic.Int64
+}
+
+func newCountingDS() *countingDS {
+	return &countingDS{Batching: syncds.MutexWrap(ds.NewMapDatastore())}
+}
+
+func (d *countingDS) Has(ctx context.Context, key ds.Key) (bool, error) {
+	d.calls.Add(1)
+	return d.Batching.Has(ctx, key)
+}
+
+func (d *countingDS) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	d.calls.Add(1)
+	return d.Batching.Get(ctx, key)
+}
+
+// blockKey returns the datastore key of the block c in a blockstore
+// created with the default options.
+func blockKey(c cid.Cid) ds.Key {
+	return BlockPrefix.Child(dshelp.MultihashToDsKey(c.Hash()))
+}
+
+// mixedBatch stores n blocks in bs and returns 2n keys, alternating between
+// present and absent blocks.
+func mixedBatch(t testing.TB, bs Blockstore, n int) (keys []cid.Cid, present map[cid.Cid]bool) {
+	present = make(map[cid.Cid]bool)
+	for i := 0; i < n; i++ {
+		in := blocks.NewBlock([]byte(fmt.Sprint("present ", i)))
+		if err := bs.Put(bg, in); err != nil {
+			t.Fatal(err)
+		}
+		out := blocks.NewBlock([]byte(fmt.Sprint("absent ", i)))
+		keys = append(keys, in.Cid(), out.Cid())
+		present[in.Cid()] = true
+	}
+	return keys, present
+}
+
+func checkHasMany(t *testing.T, bs Blockstore, keys []cid.Cid, present map[cid.Cid]bool) {
+	t.Helper()
+	res, err := HasMany(bg, bs, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(keys) {
+		t.Fatalf("expected %d results, got %d", len(keys), len(res))
+	}
+	for i, k := range keys {
+		if res[i] != present[k] {
+			t.Fatalf("wrong answer for %s: %t", k, res[i])
+		}
+	}
+}
+
+func checkGetMany(t *testing.T, bs Blockstore, keys []cid.Cid, present map[cid.Cid]bool) {
+	t.Helper()
+	blks, errs := GetMany(bg, bs, keys)
+	got := make(map[cid.Cid]bool)
+	for blk := range blks {
+		if !present[blk.Cid()] {
+			t.Fatalf("unexpected block %s", blk.Cid())
+		}
+		got[blk.Cid()] = true
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(present) {
+		t.Fatalf("expected %d blocks, got %d", len(present), len(got))
+	}
+}
+
+func TestBatchReadMixed(t *testing.T) {
+	bs := NewBlockstore(newCountingDS())
+	keys, present := mixedBatch(t, bs, 20)
+	checkHasMany(t, bs, keys, present)
+	checkGetMany(t, bs, keys, present)
+
+	var views int
+	err := ViewMany(bg, bs, keys, func(k cid.Cid, data []byte) error {
+		if !present[k] {
+			t.Fatalf("unexpected view of %s", k)
+		}
+		views++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if views != len(present) {
+		t.Fatalf("expected %d views, got %d", len(present), views)
+	}
+}
+
+func TestBatchReadHashOnRead(t *testing.T) {
+	d := newCountingDS()
+	bs := NewBlockstore(d)
+	bs.HashOnRead(true)
+
+	blk := blocks.NewBlock([]byte("some data"))
+	corrupted := blocks.NewBlock([]byte("other data"))
+	if err := bs.Put(bg, blk); err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the block data behind the blockstore's back.
+	if err := d.Put(bg, blockKey(blk.Cid()), corrupted.RawData()); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ViewMany(bg, bs, []cid.Cid{blk.Cid()}, func(cid.Cid, []byte) error { return nil })
+	if err != ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+}
+
+func TestTwoQueueBatchReadSkipsCachedNegatives(t *testing.T) {
+	d := newCountingDS()
+	bs := NewBlockstore(d)
+	keys, present := mixedBatch(t, bs, 20)
+	c, err := testTwoQueueCached(context.TODO(), bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first lookup fills the cache.
+	checkHasMany(t, c, keys, present)
+
+	d.calls.Store(0)
+	checkHasMany(t, c, keys, present)
+	if n := d.calls.Load(); n != 0 {
+		t.Fatalf("expected cached answers only, got %d datastore calls", n)
+	}
+
+	var looked []cid.Cid
+	err = c.ViewMany(bg, keys, func(k cid.Cid, _ []byte) error {
+		looked = append(looked, k)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(looked) != len(present) {
+		t.Fatalf("expected %d blocks, got %d", len(present), len(looked))
+	}
+	if stats := c.Stats().Get; stats.NegativeHits != uint64(len(keys)-len(present)) {
+		t.Fatalf("expected absent blocks to be skipped from the cache, got %+v", stats)
+	}
+}
+
+func TestLRUBatchReadUsesCache(t *testing.T) {
+	d := newCountingDS()
+	bs := NewBlockstore(d)
+	keys, present := mixedBatch(t, bs, 20)
+	cbs, err := CachedBlockstore(context.TODO(), bs, CacheOpts{MaxCacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cbs.(*lrucache)
+
+	// The first lookups go to the datastore and fill the cache.
+	d.calls.Store(0)
+	checkGetMany(t, c, keys, present)
+	if n := d.calls.Load(); n != int64(len(keys)) {
+		t.Fatalf("expected one datastore call per block, got %d", n)
+	}
+
+	d.calls.Store(0)
+	checkHasMany(t, c, keys, present)
+	checkGetMany(t, c, keys, present)
+	if n := d.calls.Load(); n != 0 {
+		t.Fatalf("expected cached answers only, got %d datastore calls", n)
+	}
+	if stats := c.Stats().Blocks.Get; stats.PositiveHits != uint64(len(present)) || stats.NegativeHits != uint64(len(keys)-len(present)) {
+		t.Fatalf("unexpected cache statistics %+v", stats)
+	}
+}
+
+func TestBloomBatchReadSkipsNegatives(t *testing.T) {
+	d := newCountingDS()
+	bs := NewBlockstore(d)
+	keys, present := mixedBatch(t, bs, 20)
+
+	bc, err := testBloomCached(context.Background(), bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Wait(bg); err != nil {
+		t.Fatal(err)
+	}
+
+	checkHasMany(t, bc, keys, present)
+	checkGetMany(t, bc, keys, present)
+	if got := len(bc.filterMissing(keys)); got >= len(keys) {
+		t.Fatalf("bloom filter did not filter any absent block")
+	}
+}
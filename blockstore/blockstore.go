@@ -210,7 +210,7 @@ func (bs *blockstore) Put(ctx context.Context, block blocks.Block) error {
 	k := dshelp.MultihashToDsKey(block.Cid().Hash())
 
 	// Has is cheaper than Put, so see if we already have it
-	if !bs.writeThrough {
+	if !bs.writeThrough && !IsWriteThrough(ctx) {
 		exists, err := bs.datastore.Has(ctx, k)
 		if err == nil && exists {
 			return nil // already stored.
@@ -229,10 +229,11 @@ func (bs *blockstore) PutMany(ctx context.Context, blocks []blocks.Block) error
 	if err != nil {
 		return err
 	}
+	checkFirst := !bs.writeThrough && !IsWriteThrough(ctx)
 	for _, b := range blocks {
 		k := dshelp.MultihashToDsKey(b.Cid().Hash())
 
-		if !bs.writeThrough {
+		if checkFirst {
 			exists, err := bs.datastore.Has(ctx, k)
 			if err == nil && exists {
 				continue
@@ -268,8 +269,22 @@ func (bs *blockstore) DeleteBlock(ctx context.Context, k cid.Cid) error {
 //
 // AllKeysChan respects context.
 func (bs *blockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return bs.allKeys(ctx, KeyFilter{})
+}
+
+// AllKeysChanFiltered is like AllKeysChan but only returns the CIDs matching
+// filter. The multihash prefix of the filter is pushed down to the datastore
+// as a key prefix filter.
+func (bs *blockstore) AllKeysChanFiltered(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error) {
+	return bs.allKeys(ctx, filter)
+}
+
+func (bs *blockstore) allKeys(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error) {
 	// KeysOnly, because that would be _a lot_ of data.
 	q := dsq.Query{KeysOnly: true}
+	if prefix := filter.dsKeyPrefix(); prefix != "" {
+		q.Filters = []dsq.Filter{dsq.FilterKeyPrefix{Prefix: prefix}}
+	}
 	res, err := bs.datastore.Query(ctx, q)
 	if err != nil {
 		return nil, err
@@ -299,6 +314,14 @@ func (bs *blockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 				continue
 			}
 			k := cid.NewCidV1(cid.Raw, bk)
+			if !filter.Matches(k) {
+				// Check the context here too, as a selective filter could
+				// otherwise spin through the whole datastore.
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
 			select {
 			case <-ctx.Done():
 				return
@@ -3,6 +3,8 @@ package blockstore
 import (
 	"context"
 	"fmt"
+	"io"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,7 +18,10 @@ import (
 // bloomCached returns a Blockstore that caches Has requests using a Bloom
 // filter. bloomSize is size of bloom filter in bytes. hashCount specifies the
 // number of hashing functions in the bloom filter (usually known as k).
-func bloomCached(ctx context.Context, bs Blockstore, bloomSize, hashCount int) (*bloomcache, error) {
+//
+// When persist.datastore is set, a filter saved by a previous instance is
+// restored instead of being rebuilt, as long as it is consistent.
+func bloomCached(ctx context.Context, bs Blockstore, bloomSize, hashCount int, persist bloomPersistOpts) (*bloomcache, error) {
 	bl, err := bloom.New(float64(bloomSize), float64(hashCount))
 	if err != nil {
 		return nil, err
@@ -24,18 +29,33 @@ func bloomCached(ctx context.Context, bs Blockstore, bloomSize, hashCount int) (
 	bc := &bloomcache{
 		blockstore: bs,
 		bloom:      bl,
+		bloomSize:  bloomSize,
+		hashCount:  hashCount,
+		persist:    persist,
 		hits: metrics.NewCtx(ctx, "bloom.hits_total",
 			"Number of cache hits in bloom cache").Counter(),
 		total: metrics.NewCtx(ctx, "bloom_total",
 			"Total number of requests to bloom cache").Counter(),
+		buildTime: metrics.NewCtx(ctx, "bloom.build_duration_seconds",
+			"Time it took to build the bloom filter from AllKeysChan").Gauge(),
+		restoreTime: metrics.NewCtx(ctx, "bloom.restore_duration_seconds",
+			"Time it took to restore the bloom filter from the datastore").Gauge(),
 		buildChan: make(chan struct{}),
 	}
 	if v, ok := bs.(Viewer); ok {
 		bc.viewer = v
 	}
+
+	restored := false
+	if persist.datastore != nil {
+		restored = bc.restore(ctx)
+	}
+
 	go func() {
-		err := bc.build(ctx)
-		if err != nil {
+		if restored {
+			atomic.StoreInt32(&bc.active, 1)
+			close(bc.buildChan)
+		} else if err := bc.build(ctx); err != nil {
 			select {
 			case <-ctx.Done():
 				logger.Warn("Cache rebuild closed by context finishing: ", err)
@@ -44,18 +64,33 @@ func bloomCached(ctx context.Context, bs Blockstore, bloomSize, hashCount int) (
 			}
 			return
 		}
+
+		var fill metrics.Gauge
+		var fillC, saveC <-chan time.Time
 		if metrics.Active() {
-			fill := metrics.NewCtx(ctx, "bloom_fill_ratio",
+			fill = metrics.NewCtx(ctx, "bloom_fill_ratio",
 				"Ratio of bloom filter fullnes, (updated once a minute)").Gauge()
-
 			t := time.NewTicker(1 * time.Minute)
 			defer t.Stop()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-t.C:
-					fill.Set(bc.bloom.FillRatioTS())
+			fillC = t.C
+		}
+		if persist.datastore != nil && persist.interval > 0 {
+			t := time.NewTicker(persist.interval)
+			defer t.Stop()
+			saveC = t.C
+		}
+		if fillC == nil && saveC == nil {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-fillC:
+				fill.Set(bc.bloom.FillRatioTS())
+			case <-saveC:
+				if err := bc.save(ctx); err != nil {
+					logger.Errorf("failed to save bloom filter: %s", err)
 				}
 			}
 		}
@@ -66,21 +101,34 @@ func bloomCached(ctx context.Context, bs Blockstore, bloomSize, hashCount int) (
 type bloomcache struct {
 	active int32
 
-	bloom    *bloom.Bloom
-	buildErr error
+	bloom     *bloom.Bloom
+	bloomSize int
+	hashCount int
+	buildErr  error
+
+	// Persistence, see bloom_persist.go.
+	persist    bloomPersistOpts
+	persistLk  sync.RWMutex
+	dirtyLk    sync.Mutex
+	clean      atomic.Bool
+	generation atomic.Uint64
+	deletes    atomic.Uint64
 
 	buildChan  chan struct{}
 	blockstore Blockstore
 	viewer     Viewer
 
 	// Statistics
-	hits  metrics.Counter
-	total metrics.Counter
+	hits        metrics.Counter
+	total       metrics.Counter
+	buildTime   metrics.Gauge
+	restoreTime metrics.Gauge
 }
 
 var (
 	_ Blockstore = (*bloomcache)(nil)
 	_ Viewer     = (*bloomcache)(nil)
+	_ io.Closer  = (*bloomcache)(nil)
 )
 
 func (b *bloomcache) BloomActive() bool {
@@ -104,6 +152,15 @@ func (b *bloomcache) build(ctx context.Context) error {
 	}()
 	defer close(b.buildChan)
 
+	if b.persist.datastore != nil {
+		// Make sure a stale saved filter is never restored once we have
+		// decided to rebuild.
+		if err := b.persist.datastore.Delete(ctx, bloomGenerationKey); err != nil {
+			b.buildErr = fmt.Errorf("failed to invalidate saved bloom filter: %w", err)
+			return b.buildErr
+		}
+	}
+
 	ch, err := b.blockstore.AllKeysChan(ctx)
 	if err != nil {
 		b.buildErr = fmt.Errorf("AllKeysChan failed in bloomcache rebuild with: %v", err)
@@ -114,6 +171,7 @@ func (b *bloomcache) build(ctx context.Context) error {
 		case key, ok := <-ch:
 			if !ok {
 				atomic.StoreInt32(&b.active, 1)
+				b.buildTime.Set(time.Since(start).Seconds())
 				return nil
 			}
 			b.bloom.AddTS(key.Hash()) // Use binary key, the more compact the better
@@ -129,7 +187,17 @@ func (b *bloomcache) DeleteBlock(ctx context.Context, k cid.Cid) error {
 		return nil
 	}
 
-	return b.blockstore.DeleteBlock(ctx, k)
+	done, err := b.beginMutation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	err = b.blockstore.DeleteBlock(ctx, k)
+	if err == nil {
+		b.deletes.Add(1)
+	}
+	return err
 }
 
 // if ok == false has is inconclusive
@@ -192,8 +260,14 @@ func (b *bloomcache) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
 }
 
 func (b *bloomcache) Put(ctx context.Context, bl blocks.Block) error {
+	done, err := b.beginMutation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	// See comment in PutMany
-	err := b.blockstore.Put(ctx, bl)
+	err = b.blockstore.Put(ctx, bl)
 	if err == nil {
 		b.bloom.AddTS(bl.Cid().Hash())
 	}
@@ -205,7 +279,13 @@ func (b *bloomcache) PutMany(ctx context.Context, bs []blocks.Block) error {
 	// to reduce number of puts we need conclusive information if block is contained
 	// this means that PutMany can't be improved with bloom cache so we just
 	// just do a passthrough.
-	err := b.blockstore.PutMany(ctx, bs)
+	done, err := b.beginMutation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	err = b.blockstore.PutMany(ctx, bs)
 	if err != nil {
 		return err
 	}
@@ -223,6 +303,22 @@ func (b *bloomcache) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 	return b.blockstore.AllKeysChan(ctx)
 }
 
+// Stats forwards to the underlying blockstore if it is a CacheInspector.
+func (b *bloomcache) Stats() CacheStats {
+	if ci, ok := b.blockstore.(CacheInspector); ok {
+		return ci.Stats()
+	}
+	return CacheStats{}
+}
+
+// Contains forwards to the underlying blockstore if it is a CacheInspector.
+func (b *bloomcache) Contains(k cid.Cid) (cached bool, hasBlock bool) {
+	if ci, ok := b.blockstore.(CacheInspector); ok {
+		return ci.Contains(k)
+	}
+	return false, false
+}
+
 func (b *bloomcache) GCLock(ctx context.Context) Unlocker {
 	return b.blockstore.(GCBlockstore).GCLock(ctx)
 }
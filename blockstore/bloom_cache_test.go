@@ -88,7 +88,7 @@ func TestPutManyAddsToBloom(t *testing.T) {
 
 func TestReturnsErrorWhenSizeNegative(t *testing.T) {
 	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
-	_, err := bloomCached(context.Background(), bs, -1, 1)
+	_, err := bloomCached(context.Background(), bs, -1, 1, bloomPersistOpts{})
 	if err == nil {
 		t.Fail()
 	}
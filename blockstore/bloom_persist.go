@@ -0,0 +1,173 @@
+package blockstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	bloom "github.com/ipfs/bbloom"
+	ds "github.com/ipfs/go-datastore"
+)
+
+// BloomFilterKey is the datastore key under which the bloom filter of a
+// CachedBlockstore is saved when CacheOpts.HasBloomFilterPersist is set.
+var BloomFilterKey = ds.NewKey("/local/blockstore/bloom")
+
+// bloomGenerationKey holds the generation of the saved filter. It is removed
+// before the first mutation following a save, so a saved filter is only
+// restored if nothing was written or deleted after it was taken.
+var bloomGenerationKey = BloomFilterKey.ChildString("generation")
+
+type bloomPersistOpts struct {
+	datastore  ds.Datastore
+	interval   time.Duration
+	maxDeletes uint64
+}
+
+type bloomSnapshot struct {
+	// Generation counts the mutations seen by the filter over its lifetime.
+	Generation uint64
+	// Deletes counts the deletions since the filter was last rebuilt.
+	Deletes   uint64
+	BloomSize int
+	HashCount int
+	Filter    json.RawMessage
+}
+
+// beginMutation must be called before writing to or deleting from the
+// underlying blockstore, and the returned function once done. It invalidates
+// the saved filter so that a crash before the next save forces a rebuild.
+func (b *bloomcache) beginMutation(ctx context.Context) (func(), error) {
+	if b.persist.datastore == nil {
+		return func() {}, nil
+	}
+
+	b.persistLk.RLock()
+	if b.clean.Load() {
+		b.dirtyLk.Lock()
+		if b.clean.Load() {
+			if err := b.persist.datastore.Delete(ctx, bloomGenerationKey); err != nil {
+				b.dirtyLk.Unlock()
+				b.persistLk.RUnlock()
+				return nil, err
+			}
+			b.clean.Store(false)
+		}
+		b.dirtyLk.Unlock()
+	}
+	return func() {
+		b.generation.Add(1)
+		b.persistLk.RUnlock()
+	}, nil
+}
+
+// save writes the filter to the persistence datastore. Filters which have not
+// finished building are not saved.
+func (b *bloomcache) save(ctx context.Context) error {
+	if b.persist.datastore == nil || !b.BloomActive() {
+		return nil
+	}
+
+	b.persistLk.Lock()
+	defer b.persistLk.Unlock()
+
+	if b.clean.Load() {
+		// Nothing changed since the last save.
+		return nil
+	}
+
+	gen := b.generation.Load()
+	data, err := json.Marshal(bloomSnapshot{
+		Generation: gen,
+		Deletes:    b.deletes.Load(),
+		BloomSize:  b.bloomSize,
+		HashCount:  b.hashCount,
+		Filter:     b.bloom.JSONMarshalTS(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := b.persist.datastore.Put(ctx, BloomFilterKey, data); err != nil {
+		return err
+	}
+	var genBuf [8]byte
+	binary.BigEndian.PutUint64(genBuf[:], gen)
+	if err := b.persist.datastore.Put(ctx, bloomGenerationKey, genBuf[:]); err != nil {
+		return err
+	}
+	if err := b.persist.datastore.Sync(ctx, BloomFilterKey); err != nil {
+		return err
+	}
+	b.clean.Store(true)
+	return nil
+}
+
+// restore loads a previously saved filter. It returns false when the filter
+// needs to be rebuilt: nothing was saved, the saved filter does not match
+// the current generation or options, or too many blocks have been deleted
+// since it was built.
+func (b *bloomcache) restore(ctx context.Context) bool {
+	start := time.Now()
+
+	genBuf, err := b.persist.datastore.Get(ctx, bloomGenerationKey)
+	if err != nil {
+		if err != ds.ErrNotFound {
+			logger.Warnf("failed to read saved bloom filter generation: %s", err)
+		}
+		return false
+	}
+	if len(genBuf) != 8 {
+		logger.Warn("saved bloom filter generation is corrupted, rebuilding")
+		return false
+	}
+	data, err := b.persist.datastore.Get(ctx, BloomFilterKey)
+	if err != nil {
+		logger.Warnf("failed to read saved bloom filter: %s", err)
+		return false
+	}
+
+	var snap bloomSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		logger.Warnf("saved bloom filter is corrupted, rebuilding: %s", err)
+		return false
+	}
+	switch {
+	case snap.Generation != binary.BigEndian.Uint64(genBuf):
+		logger.Info("saved bloom filter is out of date, rebuilding")
+		return false
+	case snap.BloomSize != b.bloomSize || snap.HashCount != b.hashCount:
+		logger.Info("bloom filter options changed, rebuilding")
+		return false
+	case b.persist.maxDeletes > 0 && snap.Deletes > b.persist.maxDeletes:
+		logger.Infof("%d blocks deleted since the bloom filter was built, rebuilding", snap.Deletes)
+		return false
+	}
+
+	bl, err := bloom.JSONUnmarshal(snap.Filter)
+	if err != nil {
+		logger.Warnf("saved bloom filter is corrupted, rebuilding: %s", err)
+		return false
+	}
+
+	b.bloom = bl
+	b.generation.Store(snap.Generation)
+	b.deletes.Store(snap.Deletes)
+	b.clean.Store(true)
+	b.restoreTime.Set(time.Since(start).Seconds())
+	logger.Debugf("bloomcache restored in %s", time.Since(start))
+	return true
+}
+
+// Close saves the bloom filter, when persistence is enabled, and closes the
+// underlying blockstore if it is an io.Closer.
+func (b *bloomcache) Close() error {
+	err := b.save(context.Background())
+	if c, ok := b.blockstore.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
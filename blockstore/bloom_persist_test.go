@@ -0,0 +1,188 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+)
+
+// countingAKCBlockstore counts AllKeysChan calls, which the bloom filter
+// makes when it is rebuilt.
+type countingAKCBlockstore struct {
+	Blockstore
+	calls atomic.Int32
+}
+
+func (c *countingAKCBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	c.calls.Add(1)
+	return c.Blockstore.AllKeysChan(ctx)
+}
+
+func testPersistedBloomCached(t *testing.T, bs Blockstore, persist ds.Datastore, maxDeletes uint64) *bloomcache {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	opts := DefaultCacheOpts()
+	opts.HasTwoQueueCacheSize = 0
+	opts.HasBloomFilterPersist = persist
+	opts.HasBloomFilterMaxDeletes = maxDeletes
+	cbs, err := CachedBlockstore(ctx, bs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := cbs.(*bloomcache)
+	if err := bc.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func putTestBlocks(t *testing.T, bs Blockstore, prefix string, n int) []blocks.Block {
+	t.Helper()
+	var out []blocks.Block
+	for i := 0; i < n; i++ {
+		b := blocks.NewBlock([]byte(fmt.Sprintf("%s: %d", prefix, i)))
+		if err := bs.Put(bg, b); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func assertAllPresent(t *testing.T, bs Blockstore, blks []blocks.Block) {
+	t.Helper()
+	for _, b := range blks {
+		has, err := bs.Has(bg, b.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("false negative for %s", b.Cid())
+		}
+	}
+}
+
+func TestBloomPersistRestore(t *testing.T) {
+	persist := syncds.MutexWrap(ds.NewMapDatastore())
+	bs := &countingAKCBlockstore{Blockstore: newMemBlockstore()}
+	existing := putTestBlocks(t, bs, "existing", 500)
+
+	bc := testPersistedBloomCached(t, bs, persist, 0)
+	added := putTestBlocks(t, bc, "added", 500)
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := bs.calls.Load(); n != 1 {
+		t.Fatalf("expected one rebuild, got %d", n)
+	}
+
+	bc = testPersistedBloomCached(t, bs, persist, 0)
+	if n := bs.calls.Load(); n != 1 {
+		t.Fatal("filter was rebuilt instead of restored")
+	}
+	if !bc.BloomActive() {
+		t.Fatal("restored filter should be active right away")
+	}
+	assertAllPresent(t, bc, existing)
+	assertAllPresent(t, bc, added)
+
+	// Writes after the restore must invalidate the saved filter.
+	more := putTestBlocks(t, bc, "more", 10)
+	if has, _ := persist.Has(bg, bloomGenerationKey); has {
+		t.Fatal("saved filter generation was not invalidated by a write")
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bc = testPersistedBloomCached(t, bs, persist, 0)
+	if n := bs.calls.Load(); n != 1 {
+		t.Fatal("filter was rebuilt instead of restored")
+	}
+	assertAllPresent(t, bc, more)
+}
+
+func TestBloomPersistRebuildsAfterUncleanShutdown(t *testing.T) {
+	persist := syncds.MutexWrap(ds.NewMapDatastore())
+	bs := &countingAKCBlockstore{Blockstore: newMemBlockstore()}
+
+	bc := testPersistedBloomCached(t, bs, persist, 0)
+	saved := putTestBlocks(t, bc, "saved", 100)
+	if err := bc.save(bg); err != nil {
+		t.Fatal(err)
+	}
+	// Written after the last save, then the process "crashes" without
+	// calling Close.
+	unsaved := putTestBlocks(t, bc, "unsaved", 100)
+
+	bc = testPersistedBloomCached(t, bs, persist, 0)
+	if n := bs.calls.Load(); n != 2 {
+		t.Fatalf("expected the stale filter to be rebuilt, got %d builds", n)
+	}
+	assertAllPresent(t, bc, saved)
+	assertAllPresent(t, bc, unsaved)
+}
+
+func TestBloomPersistForcedRebuildOnDeletes(t *testing.T) {
+	persist := syncds.MutexWrap(ds.NewMapDatastore())
+	bs := &countingAKCBlockstore{Blockstore: newMemBlockstore()}
+
+	bc := testPersistedBloomCached(t, bs, persist, 2)
+	blks := putTestBlocks(t, bc, "block", 10)
+	for _, b := range blks[:3] {
+		if err := bc.DeleteBlock(bg, b.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bc = testPersistedBloomCached(t, bs, persist, 2)
+	if n := bs.calls.Load(); n != 2 {
+		t.Fatalf("expected a forced rebuild after too many deletions, got %d builds", n)
+	}
+	if bc.deletes.Load() != 0 {
+		t.Fatal("deletion count should be reset by a rebuild")
+	}
+	assertAllPresent(t, bc, blks[3:])
+	for _, b := range blks[:3] {
+		if has, _ := bc.Has(bg, b.Cid()); has {
+			t.Fatalf("deleted block %s reported present", b.Cid())
+		}
+	}
+}
+
+func TestBloomPersistOptionsChangeRebuilds(t *testing.T) {
+	persist := syncds.MutexWrap(ds.NewMapDatastore())
+	bs := &countingAKCBlockstore{Blockstore: newMemBlockstore()}
+
+	bc := testPersistedBloomCached(t, bs, persist, 0)
+	putTestBlocks(t, bc, "block", 10)
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultCacheOpts()
+	opts.HasTwoQueueCacheSize = 0
+	opts.HasBloomFilterSize *= 2
+	opts.HasBloomFilterPersist = persist
+	cbs, err := CachedBlockstore(bg, bs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cbs.(*bloomcache).Wait(bg); err != nil {
+		t.Fatal(err)
+	}
+	if n := bs.calls.Load(); n != 2 {
+		t.Fatalf("expected a rebuild after the filter size changed, got %d builds", n)
+	}
+}
@@ -0,0 +1,104 @@
+package blockstore
+
+import (
+	"context"
+	"sync/atomic"
+
+	cid "github.com/ipfs/go-cid"
+	metrics "github.com/ipfs/go-metrics-interface"
+)
+
+// CacheOpStats counts how the block metadata cache answered one kind of
+// request.
+type CacheOpStats struct {
+	// PositiveHits were answered from the cache as "block present". For
+	// Get, the block data is still read from the underlying blockstore as
+	// the cache only holds metadata.
+	PositiveHits uint64
+	// NegativeHits were answered from the cache as "block absent" without
+	// touching the underlying blockstore.
+	NegativeHits uint64
+	// Misses fell through to the underlying blockstore.
+	Misses uint64
+}
+
+// CacheStats is a snapshot of the state of the block metadata cache.
+type CacheStats struct {
+	// Entries is the number of entries currently cached.
+	Entries int
+	// ApproxBytes is an approximation of the memory used by the entries.
+	ApproxBytes int
+
+	Has     CacheOpStats
+	Get     CacheOpStats
+	GetSize CacheOpStats
+
+	// Blocks holds the statistics of the block data cache enabled with
+	// CacheOpts.MaxCacheBytes, or nil if it is disabled. Its ApproxBytes
+	// is the number of bytes of block data cached, entry overhead
+	// included.
+	Blocks *CacheStats
+}
+
+// CacheInspector is implemented by blockstores returned by
+// CachedBlockstore. When the two-queue cache is disabled, Stats returns zero
+// values and Contains always reports the CID as not cached.
+type CacheInspector interface {
+	// Stats returns the current cache counters and size.
+	Stats() CacheStats
+	// Contains reports whether the cache holds an entry for the CID and, if
+	// so, whether that entry says the block is present. It does not touch
+	// the underlying blockstore nor the cache statistics.
+	Contains(cid.Cid) (cached bool, hasBlock bool)
+}
+
+// cacheEntryOverhead approximates the memory used by a cache entry besides
+// its key, see CacheOpts.
+const cacheEntryOverhead = 32
+
+type cacheOpCounters struct {
+	positive atomic.Uint64
+	negative atomic.Uint64
+	misses   atomic.Uint64
+
+	positiveM metrics.Counter
+	negativeM metrics.Counter
+	missesM   metrics.Counter
+}
+
+// newCacheOpCounters counts the op requests of the cache, named like the
+// metrics of the bloom cache, for example "twoqueue.has_misses_total".
+func newCacheOpCounters(ctx context.Context, cache, op string) *cacheOpCounters {
+	name := cache + "." + op
+	return &cacheOpCounters{
+		positiveM: metrics.NewCtx(ctx, name+"_positive_hits_total",
+			"Number of "+op+" requests answered as present by the "+cache+" cache").Counter(),
+		negativeM: metrics.NewCtx(ctx, name+"_negative_hits_total",
+			"Number of "+op+" requests answered as absent by the "+cache+" cache").Counter(),
+		missesM: metrics.NewCtx(ctx, name+"_misses_total",
+			"Number of "+op+" requests not answered by the "+cache+" cache").Counter(),
+	}
+}
+
+func (c *cacheOpCounters) positiveHit() {
+	c.positive.Add(1)
+	c.positiveM.Inc()
+}
+
+func (c *cacheOpCounters) negativeHit() {
+	c.negative.Add(1)
+	c.negativeM.Inc()
+}
+
+func (c *cacheOpCounters) miss() {
+	c.misses.Add(1)
+	c.missesM.Inc()
+}
+
+func (c *cacheOpCounters) stats() CacheOpStats {
+	return CacheOpStats{
+		PositiveHits: c.positive.Load(),
+		NegativeHits: c.negative.Load(),
+		Misses:       c.misses.Load(),
+	}
+}
@@ -3,7 +3,9 @@ package blockstore
 import (
 	"context"
 	"errors"
+	"time"
 
+	ds "github.com/ipfs/go-datastore"
 	metrics "github.com/ipfs/go-metrics-interface"
 )
 
@@ -13,6 +15,27 @@ type CacheOpts struct {
 	HasBloomFilterSize   int // 1 byte
 	HasBloomFilterHashes int // No size, 7 is usually best, consult bloom papers
 	HasTwoQueueCacheSize int // 32 bytes
+
+	// MaxCacheBytes enables an LRU cache of block data holding at most this
+	// many bytes, entry overhead included. Unlike the two-queue cache,
+	// which only caches metadata and is bounded by number of entries, it
+	// serves Get requests from memory. Zero disables it.
+	MaxCacheBytes int64
+	// NegativeCacheSize is the number of missing CIDs remembered by the
+	// block data cache. Zero means a default of 1024 entries.
+	NegativeCacheSize int // 32 bytes
+
+	// HasBloomFilterPersist is the datastore the bloom filter is saved to
+	// (under BloomFilterKey) so it can be restored on the next start instead
+	// of being rebuilt from AllKeysChan. Nil disables persistence.
+	HasBloomFilterPersist ds.Datastore
+	// HasBloomFilterPersistInterval is how often the bloom filter is saved
+	// while running. Zero means it is only saved on Close.
+	HasBloomFilterPersistInterval time.Duration
+	// HasBloomFilterMaxDeletes is the number of deletions after which a
+	// saved filter is considered too polluted to be restored and is rebuilt
+	// instead. Zero means a saved filter is always restored.
+	HasBloomFilterMaxDeletes uint64
 }
 
 // DefaultCacheOpts returns a CacheOpts initialized with default values.
@@ -24,8 +47,9 @@ func DefaultCacheOpts() CacheOpts {
 	}
 }
 
-// CachedBlockstore returns a blockstore wrapped in an TwoQueueCache and
-// then in a bloom filter cache, if the options indicate it.
+// CachedBlockstore returns a blockstore wrapped in an LRU block data cache,
+// then in an TwoQueueCache and then in a bloom filter cache, if the options
+// indicate it.
 func CachedBlockstore(
 	ctx context.Context,
 	bs Blockstore,
@@ -34,22 +58,37 @@ func CachedBlockstore(
 	cbs = bs
 
 	if opts.HasBloomFilterSize < 0 || opts.HasBloomFilterHashes < 0 ||
-		opts.HasTwoQueueCacheSize < 0 {
+		opts.HasTwoQueueCacheSize < 0 || opts.MaxCacheBytes < 0 ||
+		opts.NegativeCacheSize < 0 {
 		return nil, errors.New("all options for cache need to be greater than zero")
 	}
 
+	if opts.HasBloomFilterPersistInterval < 0 {
+		return nil, errors.New("bloom filter persist interval can't be negative")
+	}
+
 	if opts.HasBloomFilterSize != 0 && opts.HasBloomFilterHashes == 0 {
 		return nil, errors.New("bloom filter hash count can't be 0 when there is size set")
 	}
 
 	ctx = metrics.CtxSubScope(ctx, "bs.cache")
 
+	if opts.MaxCacheBytes > 0 {
+		cbs, err = newLRUCachedBS(ctx, cbs, opts.MaxCacheBytes, opts.NegativeCacheSize)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if opts.HasTwoQueueCacheSize > 0 {
 		cbs, err = newTwoQueueCachedBS(ctx, cbs, opts.HasTwoQueueCacheSize)
 	}
 	if opts.HasBloomFilterSize != 0 {
 		// *8 because of bytes to bits conversion
-		cbs, err = bloomCached(ctx, cbs, opts.HasBloomFilterSize*8, opts.HasBloomFilterHashes)
+		cbs, err = bloomCached(ctx, cbs, opts.HasBloomFilterSize*8, opts.HasBloomFilterHashes, bloomPersistOpts{
+			datastore:  opts.HasBloomFilterPersist,
+			interval:   opts.HasBloomFilterPersistInterval,
+			maxDeletes: opts.HasBloomFilterMaxDeletes,
+		})
 	}
 
 	return cbs, err
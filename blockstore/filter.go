@@ -0,0 +1,121 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/multiformats/go-base32"
+)
+
+// KeyFilter selects the CIDs returned by AllKeysChanFiltered. Empty fields
+// match every CID, a CID is returned when it matches all non-empty fields.
+type KeyFilter struct {
+	// Codecs is the set of CID codecs to return. Blockstores which only
+	// store multihashes return every CID with the Raw codec, see
+	// Blockstore.AllKeysChan.
+	Codecs []uint64
+	// MhTypes is the set of multihash function codes to return.
+	MhTypes []uint64
+	// MhPrefix is a prefix of the binary multihash, including its function
+	// code and length, that returned CIDs must start with.
+	MhPrefix []byte
+}
+
+// Matches reports whether c is selected by the filter.
+func (f KeyFilter) Matches(c cid.Cid) bool {
+	if len(f.Codecs) > 0 && !containsCode(f.Codecs, c.Type()) {
+		return false
+	}
+	if len(f.MhTypes) > 0 && !containsCode(f.MhTypes, c.Prefix().MhType) {
+		return false
+	}
+	if len(f.MhPrefix) > 0 && !bytes.HasPrefix(c.Hash(), f.MhPrefix) {
+		return false
+	}
+	return true
+}
+
+func containsCode(codes []uint64, code uint64) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// dsKeyPrefix returns the prefix every datastore key of a matching CID starts
+// with, or "" if there is none. Keys are the base32 encoding of the
+// multihash, so only the characters fully determined by the prefix bytes are
+// kept.
+func (f KeyFilter) dsKeyPrefix() string {
+	prefix := f.MhPrefix
+	if len(prefix) == 0 && len(f.MhTypes) == 1 {
+		prefix = binary.AppendUvarint(nil, f.MhTypes[0])
+	}
+	if len(prefix) == 0 {
+		return ""
+	}
+	complete := len(prefix) * 8 / 5
+	if complete == 0 {
+		return ""
+	}
+	return "/" + base32.RawStdEncoding.EncodeToString(prefix)[:complete]
+}
+
+// FilteredKeyLister can be implemented by blockstores able to list a subset
+// of their keys more efficiently than by filtering AllKeysChan.
+type FilteredKeyLister interface {
+	// AllKeysChanFiltered is like AllKeysChan but only returns the CIDs
+	// matching filter.
+	AllKeysChanFiltered(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error)
+}
+
+// AllKeysChanFiltered returns the CIDs of bs matching filter, using
+// FilteredKeyLister when implemented and filtering AllKeysChan otherwise.
+func AllKeysChanFiltered(ctx context.Context, bs Blockstore, filter KeyFilter) (<-chan cid.Cid, error) {
+	if fl, ok := bs.(FilteredKeyLister); ok {
+		return fl.AllKeysChanFiltered(ctx, filter)
+	}
+
+	ch, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output := make(chan cid.Cid)
+	go func() {
+		defer close(output)
+		for k := range ch {
+			if !filter.Matches(k) {
+				continue
+			}
+			select {
+			case output <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+var (
+	_ FilteredKeyLister = (*blockstore)(nil)
+	_ FilteredKeyLister = (*bloomcache)(nil)
+	_ FilteredKeyLister = (*tqcache)(nil)
+	_ FilteredKeyLister = (*idstore)(nil)
+)
+
+func (b *bloomcache) AllKeysChanFiltered(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error) {
+	return AllKeysChanFiltered(ctx, b.blockstore, filter)
+}
+
+func (b *tqcache) AllKeysChanFiltered(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error) {
+	return AllKeysChanFiltered(ctx, b.blockstore, filter)
+}
+
+func (b *idstore) AllKeysChanFiltered(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error) {
+	return AllKeysChanFiltered(ctx, b.bs, filter)
+}
@@ -0,0 +1,158 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	syncds "github.com/ipfs/go-datastore/sync"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// recordingQueryDS records the last query it received.
+type recordingQueryDS struct {
+	ds.Batching
+	last dsq.Query
+}
+
+func (d *recordingQueryDS) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	d.last = q
+	return d.Batching.Query(ctx, q)
+}
+
+// mixedPopulation stores n blocks for each of the multihash functions and
+// returns their CIDs by function.
+func mixedPopulation(t *testing.T, bs Blockstore, n int) map[uint64][]cid.Cid {
+	out := make(map[uint64][]cid.Cid)
+	for _, code := range []uint64{mh.SHA2_256, mh.SHA2_512, mh.BLAKE2B_MIN + 31} {
+		for i := 0; i < n; i++ {
+			c, err := cid.NewPrefixV1(cid.DagCBOR, code).Sum([]byte(fmt.Sprint("block ", code, i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			blk, err := blocks.NewBlockWithCid([]byte(fmt.Sprint("block ", code, i)), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bs.Put(bg, blk); err != nil {
+				t.Fatal(err)
+			}
+			out[code] = append(out[code], c)
+		}
+	}
+	return out
+}
+
+func collectFiltered(t *testing.T, bs Blockstore, f KeyFilter) []cid.Cid {
+	t.Helper()
+	ch, err := AllKeysChanFiltered(bg, bs, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collect(ch)
+}
+
+func asRaw(cids []cid.Cid) []cid.Cid {
+	out := make([]cid.Cid, len(cids))
+	for i, c := range cids {
+		out[i] = cid.NewCidV1(cid.Raw, c.Hash())
+	}
+	return out
+}
+
+func TestAllKeysChanFilteredByMhType(t *testing.T) {
+	d := &recordingQueryDS{Batching: syncds.MutexWrap(ds.NewMapDatastore())}
+	bs := NewBlockstore(d)
+	pop := mixedPopulation(t, bs, 20)
+
+	got := collectFiltered(t, bs, KeyFilter{MhTypes: []uint64{mh.SHA2_512}})
+	expectMatches(t, asRaw(pop[mh.SHA2_512]), got)
+
+	// A single multihash function is pushed down as a key prefix.
+	if len(d.last.Filters) != 1 {
+		t.Fatalf("expected the prefix to be pushed down, got %v", d.last)
+	}
+	if _, ok := d.last.Filters[0].(dsq.FilterKeyPrefix); !ok {
+		t.Fatalf("expected a key prefix filter, got %T", d.last.Filters[0])
+	}
+
+	got = collectFiltered(t, bs, KeyFilter{MhTypes: []uint64{mh.SHA2_256, mh.BLAKE2B_MIN + 31}})
+	expectMatches(t, asRaw(append(pop[mh.SHA2_256], pop[mh.BLAKE2B_MIN+31]...)), got)
+}
+
+func TestAllKeysChanFilteredByMhPrefix(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	pop := mixedPopulation(t, bs, 20)
+
+	target := pop[mh.SHA2_256][3]
+	// Code, length and the first 4 digest bytes.
+	prefix := target.Hash()[:6]
+	var expect []cid.Cid
+	for _, cids := range pop {
+		for _, c := range cids {
+			if string(c.Hash()[:6]) == string(prefix) {
+				expect = append(expect, c)
+			}
+		}
+	}
+
+	got := collectFiltered(t, bs, KeyFilter{MhPrefix: prefix})
+	expectMatches(t, asRaw(expect), got)
+}
+
+func TestAllKeysChanFilteredByCodec(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	mixedPopulation(t, bs, 5)
+
+	// The base blockstore does not preserve codecs.
+	if got := collectFiltered(t, bs, KeyFilter{Codecs: []uint64{cid.DagCBOR}}); len(got) != 0 {
+		t.Fatalf("expected no dag-cbor CIDs, got %d", len(got))
+	}
+	if got := collectFiltered(t, bs, KeyFilter{Codecs: []uint64{cid.Raw}}); len(got) != 15 {
+		t.Fatalf("expected 15 raw CIDs, got %d", len(got))
+	}
+}
+
+func TestAllKeysChanFilteredThroughCaches(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	pop := mixedPopulation(t, bs, 10)
+
+	cbs, err := CachedBlockstore(bg, NewIdStore(bs), DefaultCacheOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := collectFiltered(t, cbs, KeyFilter{MhTypes: []uint64{mh.SHA2_256}})
+	expectMatches(t, asRaw(pop[mh.SHA2_256]), got)
+}
+
+func TestAllKeysChanFilteredRespectsContext(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	mixedPopulation(t, bs, 100)
+
+	ctx, cancel := context.WithCancel(bg)
+	// A filter matching nearly everything, so the producer is blocked on
+	// the unread channel when we cancel.
+	ch, err := AllKeysChanFiltered(ctx, bs, KeyFilter{Codecs: []uint64{cid.Raw}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	cancel()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("scan did not stop after cancellation")
+		}
+	}
+}
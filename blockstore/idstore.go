@@ -2,6 +2,7 @@ package blockstore
 
 import (
 	"context"
+	"fmt"
 	"io"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -9,10 +10,64 @@ import (
 	mh "github.com/multiformats/go-multihash"
 )
 
+// ErrIdentityTooLarge is returned by the identity store when the digest of an
+// identity CID exceeds the limit set with IdStoreMaxSize.
+type ErrIdentityTooLarge struct {
+	Cid   cid.Cid
+	Size  int
+	Limit int
+}
+
+func (e ErrIdentityTooLarge) Error() string {
+	return fmt.Sprintf("identity CID %s inlines %d bytes, more than the %d allowed", e.Cid, e.Size, e.Limit)
+}
+
+// ErrIdentityCodecNotAllowed is returned by the identity store when the codec
+// of an identity CID is refused by the policy set with IdStoreCodecPolicy.
+type ErrIdentityCodecNotAllowed struct {
+	Cid   cid.Cid
+	Codec uint64
+}
+
+func (e ErrIdentityCodecNotAllowed) Error() string {
+	return fmt.Sprintf("identity CID %s uses codec 0x%x which is not allowed", e.Cid, e.Codec)
+}
+
+// IdStoreOption is an option for NewIdStore.
+type IdStoreOption struct {
+	f func(*idstore)
+}
+
+// IdStoreMaxSize sets the maximum number of bytes an identity CID may inline.
+// Operations on larger identity CIDs fail with ErrIdentityTooLarge. Zero, the
+// default, means no limit.
+func IdStoreMaxSize(n int) IdStoreOption {
+	return IdStoreOption{
+		func(ids *idstore) {
+			ids.maxSize = n
+		},
+	}
+}
+
+// IdStoreCodecPolicy sets a function deciding which codecs identity CIDs may
+// use. Operations on identity CIDs with a codec for which allowed returns
+// false fail with ErrIdentityCodecNotAllowed. By default all codecs are
+// allowed.
+func IdStoreCodecPolicy(allowed func(codec uint64) bool) IdStoreOption {
+	return IdStoreOption{
+		func(ids *idstore) {
+			ids.codecAllowed = allowed
+		},
+	}
+}
+
 // idstore wraps a BlockStore to add support for identity hashes
 type idstore struct {
 	bs     Blockstore
 	viewer Viewer
+
+	maxSize      int
+	codecAllowed func(codec uint64) bool
 }
 
 var (
@@ -21,8 +76,13 @@ var (
 	_ io.Closer  = (*idstore)(nil)
 )
 
-func NewIdStore(bs Blockstore) Blockstore {
+// NewIdStore returns a Blockstore which answers for identity CIDs from the
+// CID itself and never stores them in bs.
+func NewIdStore(bs Blockstore, opts ...IdStoreOption) Blockstore {
 	ids := &idstore{bs: bs}
+	for _, o := range opts {
+		o.f(ids)
+	}
 	if v, ok := bs.(Viewer); ok {
 		ids.viewer = v
 	}
@@ -42,18 +102,33 @@ func extractContents(k cid.Cid) (bool, []byte) {
 	return true, dmh.Digest
 }
 
+// extract is extractContents with the limits of the store applied.
+func (b *idstore) extract(k cid.Cid) (bool, []byte, error) {
+	isId, bdata := extractContents(k)
+	if !isId {
+		return false, nil, nil
+	}
+	if b.maxSize > 0 && len(bdata) > b.maxSize {
+		return true, nil, ErrIdentityTooLarge{Cid: k, Size: len(bdata), Limit: b.maxSize}
+	}
+	if b.codecAllowed != nil && !b.codecAllowed(k.Type()) {
+		return true, nil, ErrIdentityCodecNotAllowed{Cid: k, Codec: k.Type()}
+	}
+	return true, bdata, nil
+}
+
 func (b *idstore) DeleteBlock(ctx context.Context, k cid.Cid) error {
-	isId, _ := extractContents(k)
+	isId, _, err := b.extract(k)
 	if isId {
-		return nil
+		return err
 	}
 	return b.bs.DeleteBlock(ctx, k)
 }
 
 func (b *idstore) Has(ctx context.Context, k cid.Cid) (bool, error) {
-	isId, _ := extractContents(k)
+	isId, _, err := b.extract(k)
 	if isId {
-		return true, nil
+		return err == nil, err
 	}
 	return b.bs.Has(ctx, k)
 }
@@ -66,33 +141,42 @@ func (b *idstore) View(ctx context.Context, k cid.Cid, callback func([]byte) err
 		}
 		return callback(blk.RawData())
 	}
-	isId, bdata := extractContents(k)
+	isId, bdata, err := b.extract(k)
 	if isId {
+		if err != nil {
+			return err
+		}
 		return callback(bdata)
 	}
 	return b.viewer.View(ctx, k, callback)
 }
 
 func (b *idstore) GetSize(ctx context.Context, k cid.Cid) (int, error) {
-	isId, bdata := extractContents(k)
+	isId, bdata, err := b.extract(k)
 	if isId {
+		if err != nil {
+			return -1, err
+		}
 		return len(bdata), nil
 	}
 	return b.bs.GetSize(ctx, k)
 }
 
 func (b *idstore) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
-	isId, bdata := extractContents(k)
+	isId, bdata, err := b.extract(k)
 	if isId {
+		if err != nil {
+			return nil, err
+		}
 		return blocks.NewBlockWithCid(bdata, k)
 	}
 	return b.bs.Get(ctx, k)
 }
 
 func (b *idstore) Put(ctx context.Context, bl blocks.Block) error {
-	isId, _ := extractContents(bl.Cid())
+	isId, _, err := b.extract(bl.Cid())
 	if isId {
-		return nil
+		return err
 	}
 	return b.bs.Put(ctx, bl)
 }
@@ -100,7 +184,10 @@ func (b *idstore) Put(ctx context.Context, bl blocks.Block) error {
 func (b *idstore) PutMany(ctx context.Context, bs []blocks.Block) error {
 	toPut := make([]blocks.Block, 0, len(bs))
 	for _, bl := range bs {
-		isId, _ := extractContents(bl.Cid())
+		isId, _, err := b.extract(bl.Cid())
+		if err != nil {
+			return err
+		}
 		if isId {
 			continue
 		}
@@ -2,6 +2,7 @@ package blockstore
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	blk "github.com/ipfs/go-block-format"
@@ -160,3 +161,83 @@ func TestIdStore(t *testing.T) {
 		t.Fatalf("expected exactly two keys returned by AllKeysChan got %d", cnt)
 	}
 }
+
+func TestIdStoreMaxSize(t *testing.T) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	ids := NewIdStore(NewBlockstore(cd), IdStoreMaxSize(8))
+
+	atLimit, _ := cid.NewPrefixV1(cid.Raw, mh.IDENTITY).Sum([]byte("12345678"))
+	overLimit, _ := cid.NewPrefixV1(cid.Raw, mh.IDENTITY).Sum([]byte("123456789"))
+	atLimitBlock, _ := blk.NewBlockWithCid([]byte("12345678"), atLimit)
+	overLimitBlock, _ := blk.NewBlockWithCid([]byte("123456789"), overLimit)
+
+	cd.f = func() {
+		t.Fatal("operation on identity hash passed though to datastore")
+	}
+
+	if err := ids.Put(bg, atLimitBlock); err != nil {
+		t.Fatalf("Put() failed at the size limit: %v", err)
+	}
+	if have, err := ids.Has(bg, atLimit); !have || err != nil {
+		t.Fatalf("Has() failed at the size limit: %v", err)
+	}
+
+	var tooLarge ErrIdentityTooLarge
+	if err := ids.Put(bg, overLimitBlock); !errors.As(err, &tooLarge) {
+		t.Fatalf("expected ErrIdentityTooLarge from Put(), got %v", err)
+	}
+	if tooLarge.Size != 9 || tooLarge.Limit != 8 || !tooLarge.Cid.Equals(overLimit) {
+		t.Fatalf("unexpected error content: %+v", tooLarge)
+	}
+	if err := ids.PutMany(bg, []blk.Block{atLimitBlock, overLimitBlock}); !errors.As(err, &tooLarge) {
+		t.Fatalf("expected ErrIdentityTooLarge from PutMany(), got %v", err)
+	}
+	if have, err := ids.Has(bg, overLimit); have || !errors.As(err, &tooLarge) {
+		t.Fatalf("expected ErrIdentityTooLarge from Has(), got %v", err)
+	}
+	if _, err := ids.Get(bg, overLimit); !errors.As(err, &tooLarge) {
+		t.Fatalf("expected ErrIdentityTooLarge from Get(), got %v", err)
+	}
+	if _, err := ids.GetSize(bg, overLimit); !errors.As(err, &tooLarge) {
+		t.Fatalf("expected ErrIdentityTooLarge from GetSize(), got %v", err)
+	}
+
+	// Normal CIDs are not affected by the limit.
+	cd.f = func() {}
+	hash, _ := cid.NewPrefixV1(cid.Raw, mh.SHA2_256).Sum([]byte("a much larger block than the limit"))
+	block, _ := blk.NewBlockWithCid([]byte("a much larger block than the limit"), hash)
+	if err := ids.Put(bg, block); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ids.Get(bg, hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdStoreCodecPolicy(t *testing.T) {
+	ids := NewIdStore(NewBlockstore(ds.NewMapDatastore()), IdStoreCodecPolicy(func(codec uint64) bool {
+		return codec == cid.Raw
+	}))
+
+	raw, _ := cid.NewPrefixV1(cid.Raw, mh.IDENTITY).Sum([]byte("raw"))
+	if _, err := ids.Get(bg, raw); err != nil {
+		t.Fatalf("allowed codec was refused: %v", err)
+	}
+
+	cbor, _ := cid.NewPrefixV1(cid.DagCBOR, mh.IDENTITY).Sum([]byte("cbor"))
+	cborBlock, _ := blk.NewBlockWithCid([]byte("cbor"), cbor)
+	var notAllowed ErrIdentityCodecNotAllowed
+	if err := ids.Put(bg, cborBlock); !errors.As(err, &notAllowed) || notAllowed.Codec != cid.DagCBOR {
+		t.Fatalf("expected ErrIdentityCodecNotAllowed, got %v", err)
+	}
+	if _, err := ids.Get(bg, cbor); !errors.As(err, &notAllowed) {
+		t.Fatalf("expected ErrIdentityCodecNotAllowed, got %v", err)
+	}
+
+	// The policy only applies to identity CIDs.
+	hash, _ := cid.NewPrefixV1(cid.DagCBOR, mh.SHA2_256).Sum([]byte("cbor"))
+	block, _ := blk.NewBlockWithCid([]byte("cbor"), hash)
+	if err := ids.Put(bg, block); err != nil {
+		t.Fatal(err)
+	}
+}
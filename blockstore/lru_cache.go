@@ -0,0 +1,339 @@
+package blockstore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// blockCacheEntryOverhead approximates the memory used by a block cache entry
+// besides its key and data: the list element, the map slot and the block.
+const blockCacheEntryOverhead = 128
+
+// defaultNegativeCacheSize is the number of missing CIDs remembered by the
+// block cache when CacheOpts.NegativeCacheSize is zero.
+const defaultNegativeCacheSize = 1024
+
+type lruEntry struct {
+	key string
+	blk blocks.Block
+}
+
+// lrucache wraps a Blockstore with an LRU cache of block data bounded by the
+// total number of bytes cached rather than by the number of entries. Missing
+// blocks are remembered in a separate, entry-bounded, cache.
+type lrucache struct {
+	blockstore Blockstore
+
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	ll       *list.List
+	items    map[string]*list.Element
+	missing  *lru.Cache[string, struct{}]
+
+	// epoch is increased before every write or delete reaching the
+	// underlying blockstore. Reads only populate the cache when no write
+	// happened while they were running, so they never cache stale answers.
+	epoch atomic.Uint64
+	// rehash is set by HashOnRead, the cached blocks are then checked on
+	// every read like the stored ones.
+	rehash atomic.Bool
+
+	hasStats     *cacheOpCounters
+	getStats     *cacheOpCounters
+	getSizeStats *cacheOpCounters
+}
+
+var (
+	_ Blockstore        = (*lrucache)(nil)
+	_ Viewer            = (*lrucache)(nil)
+	_ CacheInspector    = (*lrucache)(nil)
+	_ FilteredKeyLister = (*lrucache)(nil)
+)
+
+func newLRUCachedBS(ctx context.Context, bs Blockstore, maxBytes int64, negativeSize int) (*lrucache, error) {
+	if negativeSize == 0 {
+		negativeSize = defaultNegativeCacheSize
+	}
+	missing, err := lru.New[string, struct{}](negativeSize)
+	if err != nil {
+		return nil, err
+	}
+	return &lrucache{
+		blockstore:   bs,
+		maxBytes:     maxBytes,
+		ll:           list.New(),
+		items:        make(map[string]*list.Element),
+		missing:      missing,
+		hasStats:     newCacheOpCounters(ctx, "lru", "has"),
+		getStats:     newCacheOpCounters(ctx, "lru", "get"),
+		getSizeStats: newCacheOpCounters(ctx, "lru", "getsize"),
+	}, nil
+}
+
+func entrySize(key string, blk blocks.Block) int64 {
+	return int64(len(key) + len(blk.RawData()) + blockCacheEntryOverhead)
+}
+
+// lookup returns the cached block, or whether the block is known to be
+// missing.
+func (b *lrucache) lookup(key string) (blk blocks.Block, missing bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.items[key]; ok {
+		b.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).blk, false
+	}
+	return nil, b.missing.Contains(key)
+}
+
+// add caches blk, unless a write happened since epoch was read.
+func (b *lrucache) add(key string, blk blocks.Block, epoch uint64) {
+	size := entrySize(key, blk)
+	if size > b.maxBytes {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if epoch != b.epoch.Load() {
+		return
+	}
+	b.insert(key, blk, size)
+}
+
+// insert must be called with b.mu held.
+func (b *lrucache) insert(key string, blk blocks.Block, size int64) {
+	b.missing.Remove(key)
+	if e, ok := b.items[key]; ok {
+		b.ll.MoveToFront(e)
+		return
+	}
+	b.items[key] = b.ll.PushFront(&lruEntry{key: key, blk: blk})
+	b.used += size
+	for b.used > b.maxBytes {
+		e := b.ll.Back()
+		ent := e.Value.(*lruEntry)
+		b.ll.Remove(e)
+		delete(b.items, ent.key)
+		b.used -= entrySize(ent.key, ent.blk)
+	}
+}
+
+// addMissing remembers the block is missing, unless a write happened since
+// epoch was read.
+func (b *lrucache) addMissing(key string, epoch uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if epoch != b.epoch.Load() {
+		return
+	}
+	b.missing.Add(key, struct{}{})
+}
+
+func (b *lrucache) remove(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.items[key]; ok {
+		ent := e.Value.(*lruEntry)
+		b.ll.Remove(e)
+		delete(b.items, key)
+		b.used -= entrySize(ent.key, ent.blk)
+	}
+	b.missing.Remove(key)
+}
+
+// cached returns the cached block as k, checking it when HashOnRead is
+// enabled. A block failing the check is dropped from the cache.
+func (b *lrucache) cached(key string, k cid.Cid, blk blocks.Block) (blocks.Block, error) {
+	if b.rehash.Load() {
+		rbcid, err := k.Prefix().Sum(blk.RawData())
+		if err != nil {
+			return nil, err
+		}
+		if !rbcid.Equals(k) {
+			b.remove(key)
+			return nil, ErrHashMismatch
+		}
+	}
+	// The cache is keyed by multihash, return the block with the CID that
+	// was asked for.
+	if !blk.Cid().Equals(k) {
+		return blocks.NewBlockWithCid(blk.RawData(), k)
+	}
+	return blk, nil
+}
+
+func (b *lrucache) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	if !k.Defined() {
+		return nil, ipld.ErrNotFound{Cid: k}
+	}
+	key := cacheKey(k)
+	if blk, missing := b.lookup(key); blk != nil {
+		b.getStats.positiveHit()
+		return b.cached(key, k, blk)
+	} else if missing {
+		b.getStats.negativeHit()
+		return nil, ipld.ErrNotFound{Cid: k}
+	}
+	b.getStats.miss()
+
+	epoch := b.epoch.Load()
+	blk, err := b.blockstore.Get(ctx, k)
+	switch {
+	case err == nil:
+		b.add(key, blk, epoch)
+	case ipld.IsNotFound(err):
+		b.addMissing(key, epoch)
+	}
+	return blk, err
+}
+
+func (b *lrucache) View(ctx context.Context, k cid.Cid, callback func([]byte) error) error {
+	blk, err := b.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	return callback(blk.RawData())
+}
+
+func (b *lrucache) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	if !k.Defined() {
+		return false, nil
+	}
+	key := cacheKey(k)
+	if blk, missing := b.lookup(key); blk != nil {
+		b.hasStats.positiveHit()
+		return true, nil
+	} else if missing {
+		b.hasStats.negativeHit()
+		return false, nil
+	}
+	b.hasStats.miss()
+
+	epoch := b.epoch.Load()
+	has, err := b.blockstore.Has(ctx, k)
+	if err == nil && !has {
+		b.addMissing(key, epoch)
+	}
+	return has, err
+}
+
+func (b *lrucache) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	if !k.Defined() {
+		return -1, ipld.ErrNotFound{Cid: k}
+	}
+	key := cacheKey(k)
+	if blk, missing := b.lookup(key); blk != nil {
+		b.getSizeStats.positiveHit()
+		return len(blk.RawData()), nil
+	} else if missing {
+		b.getSizeStats.negativeHit()
+		return -1, ipld.ErrNotFound{Cid: k}
+	}
+	b.getSizeStats.miss()
+
+	epoch := b.epoch.Load()
+	size, err := b.blockstore.GetSize(ctx, k)
+	if ipld.IsNotFound(err) {
+		b.addMissing(key, epoch)
+	}
+	return size, err
+}
+
+func (b *lrucache) Put(ctx context.Context, blk blocks.Block) error {
+	return b.PutMany(ctx, []blocks.Block{blk})
+}
+
+func (b *lrucache) PutMany(ctx context.Context, bs []blocks.Block) error {
+	b.epoch.Add(1)
+	var err error
+	if len(bs) == 1 {
+		err = b.blockstore.Put(ctx, bs[0])
+	} else {
+		err = b.blockstore.PutMany(ctx, bs)
+	}
+	if err != nil {
+		for _, blk := range bs {
+			b.remove(cacheKey(blk.Cid()))
+		}
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, blk := range bs {
+		key := cacheKey(blk.Cid())
+		if size := entrySize(key, blk); size <= b.maxBytes {
+			b.insert(key, blk, size)
+		} else {
+			b.missing.Remove(key)
+		}
+	}
+	return nil
+}
+
+func (b *lrucache) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	b.epoch.Add(1)
+	err := b.blockstore.DeleteBlock(ctx, k)
+	b.remove(cacheKey(k))
+	return err
+}
+
+func (b *lrucache) HashOnRead(enabled bool) {
+	b.rehash.Store(enabled)
+	b.blockstore.HashOnRead(enabled)
+}
+
+func (b *lrucache) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return b.blockstore.AllKeysChan(ctx)
+}
+
+func (b *lrucache) AllKeysChanFiltered(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error) {
+	return AllKeysChanFiltered(ctx, b.blockstore, filter)
+}
+
+// Stats implements CacheInspector. The statistics of the block cache are
+// reported in the Blocks field.
+func (b *lrucache) Stats() CacheStats {
+	b.mu.Lock()
+	st := CacheStats{
+		Entries:     len(b.items),
+		ApproxBytes: int(b.used),
+		Has:         b.hasStats.stats(),
+		Get:         b.getStats.stats(),
+		GetSize:     b.getSizeStats.stats(),
+	}
+	b.mu.Unlock()
+	return CacheStats{Blocks: &st}
+}
+
+// Contains implements CacheInspector.
+func (b *lrucache) Contains(k cid.Cid) (cached bool, hasBlock bool) {
+	key := cacheKey(k)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.items[key]; ok {
+		return true, true
+	}
+	return b.missing.Contains(key), false
+}
+
+func (b *lrucache) GCLock(ctx context.Context) Unlocker {
+	return b.blockstore.(GCBlockstore).GCLock(ctx)
+}
+
+func (b *lrucache) PinLock(ctx context.Context) Unlocker {
+	return b.blockstore.(GCBlockstore).PinLock(ctx)
+}
+
+func (b *lrucache) GCRequested(ctx context.Context) bool {
+	return b.blockstore.(GCBlockstore).GCRequested(ctx)
+}
@@ -0,0 +1,302 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func createLRUStores(t testing.TB, maxBytes int64) (*lrucache, Blockstore, *callbackDatastore) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+	opts := CacheOpts{MaxCacheBytes: maxBytes}
+	cbs, err := CachedBlockstore(context.TODO(), bs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cbs.(*lrucache), bs, cd
+}
+
+func TestLRUCacheServesDatastoreData(t *testing.T) {
+	c, bs, cd := createLRUStores(t, 1<<20)
+
+	blk := blocks.NewBlock([]byte("some data"))
+	if err := bs.Put(bg, blk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(bg, blk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	trap("get hit datastore", cd, t)
+	got, err := c.Get(bg, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromDs, err := cd.ds.Get(bg, blockKey(blk.Cid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.RawData(), fromDs) {
+		t.Fatal("cached data differs from the datastore copy")
+	}
+	if size, err := c.GetSize(bg, blk.Cid()); err != nil || size != len(blk.RawData()) {
+		t.Fatalf("wrong cached size %d: %v", size, err)
+	}
+
+	// The cache is keyed by multihash, the CID asked for must be preserved.
+	v1 := cid.NewCidV1(cid.DagProtobuf, blk.Cid().Hash())
+	got, err = c.Get(bg, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Cid().Equals(v1) {
+		t.Fatalf("expected %s, got %s", v1, got.Cid())
+	}
+	if err := c.View(bg, v1, func(data []byte) error {
+		if !bytes.Equal(data, blk.RawData()) {
+			t.Fatal("viewed data differs from the stored block")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLRUCacheHashOnRead(t *testing.T) {
+	c, _, _ := createLRUStores(t, 1<<20)
+	c.HashOnRead(true)
+
+	// a block whose data does not match its CID, put by the caller
+	blk := blocks.NewBlock([]byte("some data"))
+	corrupted, err := blocks.NewBlockWithCid([]byte("other data"), blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(bg, corrupted); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := c.Contains(blk.Cid()); !has {
+		t.Fatal("expected the block to be cached")
+	}
+
+	if _, err := c.Get(bg, blk.Cid()); err != ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+	if err := c.ViewMany(bg, []cid.Cid{blk.Cid()}, func(cid.Cid, []byte) error { return nil }); err != ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch from a batch, got %v", err)
+	}
+}
+
+func TestLRUCacheEvictsByBytes(t *testing.T) {
+	var bs []blocks.Block
+	for i := 0; i < 4; i++ {
+		bs = append(bs, blocks.NewBlock(bytes.Repeat([]byte{byte(i)}, 1000)))
+	}
+	perEntry := entrySize(cacheKey(bs[0].Cid()), bs[0])
+	c, _, cd := createLRUStores(t, 3*perEntry)
+
+	for _, blk := range bs[:3] {
+		if err := c.Put(bg, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Use the first block so the second becomes the least recently used.
+	if _, err := c.Get(bg, bs[0].Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(bg, bs[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	st := c.Stats().Blocks
+	if st.Entries != 3 || int64(st.ApproxBytes) != 3*perEntry {
+		t.Fatalf("expected 3 entries using %d bytes, got %+v", 3*perEntry, st)
+	}
+	if cached, _ := c.Contains(bs[1].Cid()); cached {
+		t.Fatal("least recently used block was not evicted")
+	}
+
+	trap("get hit datastore", cd, t)
+	for _, blk := range []blocks.Block{bs[0], bs[2], bs[3]} {
+		if _, err := c.Get(bg, blk.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLRUCacheSkipsLargeBlocks(t *testing.T) {
+	c, _, cd := createLRUStores(t, 1000)
+
+	large := blocks.NewBlock(bytes.Repeat([]byte{1}, 2000))
+	if err := c.Put(bg, large); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := c.Contains(large.Cid()); cached {
+		t.Fatal("block larger than the cache was cached")
+	}
+	writeHit := false
+	cd.SetFunc(func() { writeHit = true })
+	if _, err := c.Get(bg, large.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if !writeHit {
+		t.Fatal("large block should be read from the datastore")
+	}
+}
+
+func TestLRUCacheNegative(t *testing.T) {
+	c, _, cd := createLRUStores(t, 1<<20)
+
+	if has, err := c.Has(bg, exampleBlock.Cid()); has || err != nil {
+		t.Fatal("has was true but there is no such block")
+	}
+	trap("has hit datastore", cd, t)
+	if has, err := c.Has(bg, exampleBlock.Cid()); has || err != nil {
+		t.Fatal("has was true but there is no such block")
+	}
+	if _, err := c.Get(bg, exampleBlock.Cid()); !ipld.IsNotFound(err) {
+		t.Fatal("get returned invalid result")
+	}
+	if _, err := c.GetSize(bg, exampleBlock.Cid()); !ipld.IsNotFound(err) {
+		t.Fatal("getsize returned invalid result")
+	}
+
+	untrap(cd)
+	if err := c.Put(bg, exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+	trap("has hit datastore", cd, t)
+	if has, err := c.Has(bg, exampleBlock.Cid()); !has || err != nil {
+		t.Fatal("has returned invalid result after put")
+	}
+
+	untrap(cd)
+	if err := c.DeleteBlock(bg, exampleBlock.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := c.Has(bg, exampleBlock.Cid()); has || err != nil {
+		t.Fatal("has returned invalid result after delete")
+	}
+
+	st := c.Stats().Blocks
+	if st.Has.NegativeHits != 1 || st.Has.PositiveHits != 1 || st.Get.NegativeHits != 1 {
+		t.Fatalf("unexpected statistics %+v", st)
+	}
+}
+
+func TestLRUCacheStatsThroughTwoQueue(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	opts := DefaultCacheOpts()
+	opts.MaxCacheBytes = 1 << 20
+	cbs, err := CachedBlockstore(context.TODO(), bs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cbs.Put(bg, exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+	st := cbs.(CacheInspector).Stats()
+	if st.Blocks == nil || st.Blocks.Entries != 1 {
+		t.Fatalf("expected the block cache statistics, got %+v", st.Blocks)
+	}
+}
+
+// skewedWorkload returns blocks of very different sizes and a sequence of
+// accesses favoring the small ones.
+func skewedWorkload(n, accesses int) ([]blocks.Block, []int) {
+	rng := rand.New(rand.NewSource(1))
+	var bs []blocks.Block
+	for i := 0; i < n; i++ {
+		size := 100 + rng.Intn(400)
+		if i%50 == 0 {
+			size = 1 << 20
+		}
+		data := make([]byte, size)
+		rng.Read(data)
+		bs = append(bs, blocks.NewBlock(data))
+	}
+	zipf := rand.NewZipf(rng, 1.1, 1, uint64(n-1))
+	seq := make([]int, accesses)
+	for i := range seq {
+		seq[i] = int(zipf.Uint64())
+	}
+	// Make the large blocks show up in the access stream too.
+	for i := 0; i < accesses; i += 20 {
+		seq[i] = rng.Intn(n/50) * 50
+	}
+	return bs, seq
+}
+
+func BenchmarkBlockCacheSkewedSizes(b *testing.B) {
+	const budget = 4 << 20
+	bs, seq := skewedWorkload(5000, 50000)
+	var total int
+	for _, blk := range bs {
+		total += len(blk.RawData())
+	}
+	avg := total / len(bs)
+
+	b.Run("bytes", func(b *testing.B) {
+		var hits, reqs int
+		for i := 0; i < b.N; i++ {
+			base := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+			if err := base.PutMany(bg, bs); err != nil {
+				b.Fatal(err)
+			}
+			c, err := newLRUCachedBS(bg, base, budget, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, j := range seq {
+				if _, err := c.Get(bg, bs[j].Cid()); err != nil {
+					b.Fatal(err)
+				}
+			}
+			st := c.Stats().Blocks.Get
+			hits += int(st.PositiveHits)
+			reqs += len(seq)
+		}
+		b.ReportMetric(100*float64(hits)/float64(reqs), "hit%")
+		b.ReportMetric(float64(budget)/(1<<20), "peak-MiB")
+	})
+
+	b.Run(fmt.Sprintf("count-%d", budget/avg), func(b *testing.B) {
+		// Sized for the budget with the average block size, the memory
+		// actually used depends on which blocks are cached.
+		var hits, reqs, used, peak int
+		for i := 0; i < b.N; i++ {
+			used = 0
+			c, err := lru.NewWithEvict[string, blocks.Block](budget/avg, func(key string, blk blocks.Block) {
+				used -= len(key) + len(blk.RawData()) + blockCacheEntryOverhead
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, j := range seq {
+				key := cacheKey(bs[j].Cid())
+				if _, ok := c.Get(key); ok {
+					hits++
+					continue
+				}
+				used += len(key) + len(bs[j].RawData()) + blockCacheEntryOverhead
+				c.Add(key, bs[j])
+				if used > peak {
+					peak = used
+				}
+			}
+			reqs += len(seq)
+		}
+		b.ReportMetric(100*float64(hits)/float64(reqs), "hit%")
+		b.ReportMetric(float64(peak)/(1<<20), "peak-MiB")
+	})
+}
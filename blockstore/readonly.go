@@ -0,0 +1,143 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrReadOnly is returned by the blockstores of NewReadOnly when they are
+// written to.
+type ErrReadOnly struct {
+	// Op is the refused operation: "Put", "PutMany" or "DeleteBlock".
+	Op string
+	// Cid is the CID that was going to be written or deleted. For PutMany,
+	// it is the CID of the first block.
+	Cid cid.Cid
+}
+
+func (e ErrReadOnly) Error() string {
+	return fmt.Sprintf("cannot %s %s: blockstore is read-only", e.Op, e.Cid)
+}
+
+// ReadOnlyOption is an option for NewReadOnly.
+type ReadOnlyOption struct {
+	f func(*readonly)
+}
+
+// ReadOnlyPanic makes writes panic with an ErrReadOnly instead of returning
+// it. It is meant for tests and debugging, to catch accidental writes where
+// they happen.
+func ReadOnlyPanic() ReadOnlyOption {
+	return ReadOnlyOption{
+		func(ro *readonly) {
+			ro.panic = true
+		},
+	}
+}
+
+// NewReadOnly returns a Blockstore reading from bs which refuses all writes
+// with an ErrReadOnly.
+func NewReadOnly(bs Blockstore, opts ...ReadOnlyOption) Blockstore {
+	ro := &readonly{bs: bs}
+	for _, o := range opts {
+		o.f(ro)
+	}
+	if v, ok := bs.(Viewer); ok {
+		ro.viewer = v
+	}
+	return ro
+}
+
+// NewReadOnlyGCBlockstore is like NewReadOnly for GCBlockstores. Locking is
+// delegated to bs, so the result can be given to constructors expecting a
+// GCBlockstore.
+func NewReadOnlyGCBlockstore(bs GCBlockstore, opts ...ReadOnlyOption) GCBlockstore {
+	return NewGCBlockstore(NewReadOnly(bs, opts...), bs)
+}
+
+type readonly struct {
+	bs     Blockstore
+	viewer Viewer
+	panic  bool
+}
+
+var (
+	_ Blockstore        = (*readonly)(nil)
+	_ Viewer            = (*readonly)(nil)
+	_ BatchReader       = (*readonly)(nil)
+	_ FilteredKeyLister = (*readonly)(nil)
+)
+
+func (ro *readonly) refuse(op string, c cid.Cid) error {
+	err := ErrReadOnly{Op: op, Cid: c}
+	if ro.panic {
+		panic(err)
+	}
+	return err
+}
+
+func (ro *readonly) Put(_ context.Context, b blocks.Block) error {
+	return ro.refuse("Put", b.Cid())
+}
+
+func (ro *readonly) PutMany(_ context.Context, bs []blocks.Block) error {
+	var c cid.Cid
+	if len(bs) > 0 {
+		c = bs[0].Cid()
+	}
+	return ro.refuse("PutMany", c)
+}
+
+func (ro *readonly) DeleteBlock(_ context.Context, c cid.Cid) error {
+	return ro.refuse("DeleteBlock", c)
+}
+
+func (ro *readonly) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return ro.bs.Has(ctx, c)
+}
+
+func (ro *readonly) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return ro.bs.Get(ctx, c)
+}
+
+func (ro *readonly) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	return ro.bs.GetSize(ctx, c)
+}
+
+func (ro *readonly) View(ctx context.Context, c cid.Cid, callback func([]byte) error) error {
+	if ro.viewer == nil {
+		blk, err := ro.bs.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		return callback(blk.RawData())
+	}
+	return ro.viewer.View(ctx, c, callback)
+}
+
+func (ro *readonly) HasMany(ctx context.Context, keys []cid.Cid) ([]bool, error) {
+	return HasMany(ctx, ro.bs, keys)
+}
+
+func (ro *readonly) GetMany(ctx context.Context, keys []cid.Cid) (<-chan blocks.Block, <-chan error) {
+	return GetMany(ctx, ro.bs, keys)
+}
+
+func (ro *readonly) ViewMany(ctx context.Context, keys []cid.Cid, callback func(cid.Cid, []byte) error) error {
+	return ViewMany(ctx, ro.bs, keys, callback)
+}
+
+func (ro *readonly) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return ro.bs.AllKeysChan(ctx)
+}
+
+func (ro *readonly) AllKeysChanFiltered(ctx context.Context, filter KeyFilter) (<-chan cid.Cid, error) {
+	return AllKeysChanFiltered(ctx, ro.bs, filter)
+}
+
+func (ro *readonly) HashOnRead(enabled bool) {
+	ro.bs.HashOnRead(enabled)
+}
@@ -0,0 +1,144 @@
+package blockstore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// hashOnReadRecorder records the last HashOnRead call.
+type hashOnReadRecorder struct {
+	Blockstore
+	enabled bool
+}
+
+func (h *hashOnReadRecorder) HashOnRead(enabled bool) {
+	h.enabled = enabled
+	h.Blockstore.HashOnRead(enabled)
+}
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	base := newMemBlockstore()
+	if err := base.Put(bg, exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+	ro := NewReadOnly(base)
+	other := blocks.NewBlock([]byte("other"))
+
+	writes := map[string]func() error{
+		"Put":         func() error { return ro.Put(bg, other) },
+		"PutMany":     func() error { return ro.PutMany(bg, []blocks.Block{other, exampleBlock}) },
+		"DeleteBlock": func() error { return ro.DeleteBlock(bg, exampleBlock.Cid()) },
+	}
+	expectCid := map[string]cid.Cid{
+		"Put":         other.Cid(),
+		"PutMany":     other.Cid(),
+		"DeleteBlock": exampleBlock.Cid(),
+	}
+	for op, write := range writes {
+		var roErr ErrReadOnly
+		if err := write(); !errors.As(err, &roErr) {
+			t.Fatalf("%s: expected ErrReadOnly, got %v", op, err)
+		}
+		if roErr.Op != op || !roErr.Cid.Equals(expectCid[op]) {
+			t.Fatalf("%s: unexpected error content %+v", op, roErr)
+		}
+	}
+
+	if has, _ := base.Has(bg, other.Cid()); has {
+		t.Fatal("write reached the underlying blockstore")
+	}
+	if has, _ := base.Has(bg, exampleBlock.Cid()); !has {
+		t.Fatal("delete reached the underlying blockstore")
+	}
+}
+
+func TestReadOnlyPanics(t *testing.T) {
+	ro := NewReadOnly(newMemBlockstore(), ReadOnlyPanic())
+	for op, write := range map[string]func(){
+		"Put":         func() { ro.Put(bg, exampleBlock) },
+		"PutMany":     func() { ro.PutMany(bg, []blocks.Block{exampleBlock}) },
+		"DeleteBlock": func() { ro.DeleteBlock(bg, exampleBlock.Cid()) },
+	} {
+		func() {
+			defer func() {
+				r := recover()
+				if _, ok := r.(ErrReadOnly); !ok {
+					t.Fatalf("%s: expected a panic with ErrReadOnly, got %v", op, r)
+				}
+			}()
+			write()
+		}()
+	}
+}
+
+func TestReadOnlyReads(t *testing.T) {
+	rec := &hashOnReadRecorder{Blockstore: newMemBlockstore()}
+	if err := rec.Put(bg, exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+	missing := blocks.NewBlock([]byte("missing"))
+	ro := NewReadOnly(rec)
+
+	if has, err := ro.Has(bg, exampleBlock.Cid()); !has || err != nil {
+		t.Fatal("Has failed")
+	}
+	if has, err := ro.Has(bg, missing.Cid()); has || err != nil {
+		t.Fatal("Has reported a missing block")
+	}
+	blk, err := ro.Get(bg, exampleBlock.Cid())
+	if err != nil || !bytes.Equal(blk.RawData(), exampleBlock.RawData()) {
+		t.Fatal("Get failed")
+	}
+	if _, err := ro.Get(bg, missing.Cid()); !ipld.IsNotFound(err) {
+		t.Fatal("Get should return not found")
+	}
+	if size, err := ro.GetSize(bg, exampleBlock.Cid()); err != nil || size != len(exampleBlock.RawData()) {
+		t.Fatal("GetSize failed")
+	}
+	err = ro.(Viewer).View(bg, exampleBlock.Cid(), func(data []byte) error {
+		if !bytes.Equal(data, exampleBlock.RawData()) {
+			t.Fatal("View returned the wrong data")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := ro.AllKeysChan(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := collect(ch); len(keys) != 1 {
+		t.Fatalf("expected one key, got %d", len(keys))
+	}
+	res, err := HasMany(bg, ro, []cid.Cid{exampleBlock.Cid(), missing.Cid()})
+	if err != nil || !res[0] || res[1] {
+		t.Fatalf("HasMany failed: %v %v", res, err)
+	}
+
+	ro.HashOnRead(true)
+	if !rec.enabled {
+		t.Fatal("HashOnRead was not passed through")
+	}
+}
+
+func TestReadOnlyGCBlockstore(t *testing.T) {
+	gcbs := NewGCBlockstore(newMemBlockstore(), NewGCLocker())
+	ro := NewReadOnlyGCBlockstore(gcbs)
+
+	unlocker := ro.PinLock(bg)
+	if ro.GCRequested(bg) {
+		t.Fatal("no GC was requested")
+	}
+	unlocker.Unlock(bg)
+
+	var roErr ErrReadOnly
+	if err := ro.Put(bg, exampleBlock); !errors.As(err, &roErr) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
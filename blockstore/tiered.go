@@ -0,0 +1,495 @@
+package blockstore
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// ErrTieredClosed is returned by writes to a TieredBlockstore after Close
+// has been called.
+var ErrTieredClosed = errors.New("tiered blockstore is closed")
+
+// TieredOpts wraps options for NewTieredBlockstore.
+type TieredOpts struct {
+	// WriteBackConcurrency is the number of workers flushing dirty blocks
+	// from the fast tier to the slow tier.
+	WriteBackConcurrency int
+	// WriteBackBatchSize is the maximum number of blocks written to the
+	// slow tier in a single PutMany call.
+	WriteBackBatchSize int
+	// DirtyQueueSize bounds the number of blocks waiting to be written
+	// back. Puts block once the queue is full, until a slot frees up or
+	// their context is cancelled.
+	DirtyQueueSize int
+	// FastTierMaxBytes is the maximum number of bytes of clean (already
+	// written back or promoted) blocks kept in the fast tier. Least
+	// recently used clean blocks are deleted from the fast tier once the cap
+	// is exceeded. Zero disables eviction.
+	FastTierMaxBytes int64
+}
+
+// DefaultTieredOpts returns a TieredOpts initialized with default values.
+func DefaultTieredOpts() TieredOpts {
+	return TieredOpts{
+		WriteBackConcurrency: 4,
+		WriteBackBatchSize:   64,
+		DirtyQueueSize:       1024,
+		FastTierMaxBytes:     0,
+	}
+}
+
+// TieredBlockstore is a Blockstore backed by a fast and a slow tier. See
+// NewTieredBlockstore.
+type TieredBlockstore interface {
+	Blockstore
+	io.Closer
+
+	// Flush blocks until every write acknowledged before the call has been
+	// written back to the slow tier, or the context is cancelled. It returns
+	// the first write-back error encountered since the previous Flush.
+	Flush(ctx context.Context) error
+}
+
+// NewTieredBlockstore returns a Blockstore which reads from fast and falls
+// back to slow, copying blocks found only in the slow tier into the fast
+// tier. Writes go to the fast tier and are written back to the slow tier
+// asynchronously, in batches.
+//
+// A Put or PutMany returns once the blocks are stored in the fast tier: this
+// is the only durability guarantee given. Pending write-backs are kept in
+// memory, so blocks acknowledged but not yet flushed when the process dies
+// are present in the fast tier only and will not be copied to the slow tier
+// after a restart. Dirty blocks are never evicted from the fast tier. Call
+// Flush or Close before shutting down to drain the queue.
+func NewTieredBlockstore(fast, slow Blockstore, opts TieredOpts) (TieredBlockstore, error) {
+	if opts.WriteBackConcurrency <= 0 || opts.WriteBackBatchSize <= 0 || opts.DirtyQueueSize <= 0 {
+		return nil, errors.New("write-back concurrency, batch size and queue size need to be greater than zero")
+	}
+	if opts.FastTierMaxBytes < 0 {
+		return nil, errors.New("fast tier max bytes can't be negative")
+	}
+
+	t := &tiered{
+		fast:     fast,
+		slow:     slow,
+		opts:     opts,
+		queue:    make(chan blocks.Block, opts.DirtyQueueSize),
+		closing:  make(chan struct{}),
+		dirty:    make(map[string]int),
+		lru:      list.New(),
+		lruIndex: make(map[string]*list.Element),
+	}
+	t.drained = sync.NewCond(&t.mu)
+	t.workers.Add(opts.WriteBackConcurrency)
+	for i := 0; i < opts.WriteBackConcurrency; i++ {
+		go t.writeBack()
+	}
+	return t, nil
+}
+
+type tieredEntry struct {
+	c    cid.Cid
+	size int64
+}
+
+type tiered struct {
+	fast Blockstore
+	slow Blockstore
+	opts TieredOpts
+
+	queue   chan blocks.Block
+	workers sync.WaitGroup
+
+	closeOnce sync.Once
+	closing   chan struct{}
+
+	// deleteLk is held for writing by DeleteBlock and for reading by the
+	// write-back workers, so a deleted block is never resurrected in the
+	// slow tier by a concurrent write-back.
+	deleteLk sync.RWMutex
+
+	mu       sync.Mutex
+	pending  int
+	drained  *sync.Cond
+	wbErr    error
+	dirty    map[string]int
+	lru      *list.List
+	lruIndex map[string]*list.Element
+	lruBytes int64
+}
+
+var (
+	_ Blockstore = (*tiered)(nil)
+	_ io.Closer  = (*tiered)(nil)
+)
+
+func (t *tiered) writeBack() {
+	defer t.workers.Done()
+
+	for {
+		var batch []blocks.Block
+		select {
+		case b := <-t.queue:
+			batch = append(batch, b)
+		case <-t.closing:
+			return
+		}
+	fill:
+		for len(batch) < t.opts.WriteBackBatchSize {
+			select {
+			case b := <-t.queue:
+				batch = append(batch, b)
+			default:
+				break fill
+			}
+		}
+		t.writeBatch(batch)
+	}
+}
+
+func (t *tiered) writeBatch(batch []blocks.Block) {
+	t.deleteLk.RLock()
+	defer t.deleteLk.RUnlock()
+
+	// Skip blocks deleted while they were waiting in the queue.
+	t.mu.Lock()
+	toWrite := make([]blocks.Block, 0, len(batch))
+	for _, b := range batch {
+		if t.dirty[cacheKey(b.Cid())] > 0 {
+			toWrite = append(toWrite, b)
+		}
+	}
+	t.mu.Unlock()
+
+	var err error
+	if len(toWrite) > 0 {
+		err = t.slow.PutMany(context.Background(), toWrite)
+	}
+	if err != nil {
+		logger.Errorf("tiered blockstore write-back of %d blocks failed: %s", len(toWrite), err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil && t.wbErr == nil {
+		t.wbErr = err
+	}
+	var evict []cid.Cid
+	for _, b := range batch {
+		key := cacheKey(b.Cid())
+		if n, ok := t.dirty[key]; ok {
+			if n <= 1 {
+				delete(t.dirty, key)
+				if err == nil {
+					// Failed write-backs stay out of the LRU so they are
+					// never evicted from the fast tier.
+					evict = t.touch(key, b.Cid(), int64(len(b.RawData())), evict)
+				}
+			} else {
+				t.dirty[key] = n - 1
+			}
+		}
+	}
+	t.pending -= len(batch)
+	if t.pending == 0 {
+		t.drained.Broadcast()
+	}
+	t.evict(evict)
+}
+
+// touch marks a clean block as most recently used and returns the blocks that
+// need to be evicted to honour FastTierMaxBytes. Must be called with t.mu
+// held.
+func (t *tiered) touch(key string, c cid.Cid, size int64, evict []cid.Cid) []cid.Cid {
+	if t.opts.FastTierMaxBytes == 0 || t.dirty[key] > 0 {
+		return evict
+	}
+	if e, ok := t.lruIndex[key]; ok {
+		t.lru.MoveToFront(e)
+		return evict
+	}
+	t.lruIndex[key] = t.lru.PushFront(&tieredEntry{c: c, size: size})
+	t.lruBytes += size
+	for t.lruBytes > t.opts.FastTierMaxBytes && t.lru.Len() > 1 {
+		e := t.lru.Back()
+		ent := e.Value.(*tieredEntry)
+		t.lru.Remove(e)
+		delete(t.lruIndex, cacheKey(ent.c))
+		t.lruBytes -= ent.size
+		evict = append(evict, ent.c)
+	}
+	return evict
+}
+
+// forget drops a block from the LRU. Must be called with t.mu held.
+func (t *tiered) forget(key string) {
+	if e, ok := t.lruIndex[key]; ok {
+		t.lru.Remove(e)
+		delete(t.lruIndex, key)
+		t.lruBytes -= e.Value.(*tieredEntry).size
+	}
+}
+
+// evict deletes blocks from the fast tier. It is called with t.mu held, the
+// deletes are cheap on a fast tier and doing them under the lock prevents a
+// concurrent Put from being undone.
+func (t *tiered) evict(cids []cid.Cid) {
+	for _, c := range cids {
+		if err := t.fast.DeleteBlock(context.Background(), c); err != nil {
+			logger.Warnf("tiered blockstore failed to evict %s from the fast tier: %s", c, err)
+		}
+	}
+}
+
+// markDirty records that the blocks are about to be written to the fast tier
+// and must not be evicted from it until they have been written back.
+func (t *tiered) markDirty(bs []blocks.Block) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range bs {
+		key := cacheKey(b.Cid())
+		t.forget(key)
+		t.dirty[key]++
+		t.pending++
+	}
+}
+
+// unmarkDirty reverts markDirty for blocks which could not be written to the
+// fast tier.
+func (t *tiered) unmarkDirty(bs []blocks.Block) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range bs {
+		key := cacheKey(b.Cid())
+		if t.dirty[key] <= 1 {
+			delete(t.dirty, key)
+		} else {
+			t.dirty[key]--
+		}
+		t.pending--
+	}
+	if t.pending == 0 {
+		t.drained.Broadcast()
+	}
+}
+
+func (t *tiered) enqueue(ctx context.Context, bs []blocks.Block) error {
+	for i, b := range bs {
+		var err error
+		select {
+		case t.queue <- b:
+			continue
+		case <-t.closing:
+			err = ErrTieredClosed
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		// Keep the dirty marks: the remaining blocks are in the fast tier
+		// but not in the slow one, so they must not be evicted.
+		t.mu.Lock()
+		t.pending -= len(bs) - i
+		if t.pending == 0 {
+			t.drained.Broadcast()
+		}
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t *tiered) isClosed() bool {
+	select {
+	case <-t.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *tiered) Put(ctx context.Context, b blocks.Block) error {
+	return t.PutMany(ctx, []blocks.Block{b})
+}
+
+func (t *tiered) PutMany(ctx context.Context, bs []blocks.Block) error {
+	if t.isClosed() {
+		return ErrTieredClosed
+	}
+	t.markDirty(bs)
+	var err error
+	if len(bs) == 1 {
+		err = t.fast.Put(ctx, bs[0])
+	} else {
+		err = t.fast.PutMany(ctx, bs)
+	}
+	if err != nil {
+		t.unmarkDirty(bs)
+		return err
+	}
+	return t.enqueue(ctx, bs)
+}
+
+func (t *tiered) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	has, err := t.fast.Has(ctx, k)
+	if err == nil && has {
+		return true, nil
+	}
+	return t.slow.Has(ctx, k)
+}
+
+func (t *tiered) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	size, err := t.fast.GetSize(ctx, k)
+	if err == nil {
+		return size, nil
+	}
+	if !ipld.IsNotFound(err) {
+		return -1, err
+	}
+	return t.slow.GetSize(ctx, k)
+}
+
+func (t *tiered) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	blk, err := t.fast.Get(ctx, k)
+	if err == nil {
+		t.mu.Lock()
+		t.evict(t.touch(cacheKey(k), k, int64(len(blk.RawData())), nil))
+		t.mu.Unlock()
+		return blk, nil
+	}
+	if !ipld.IsNotFound(err) {
+		return nil, err
+	}
+
+	blk, err = t.slow.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	// Promote the block into the fast tier.
+	if err := t.fast.Put(ctx, blk); err != nil {
+		logger.Warnf("tiered blockstore failed to promote %s to the fast tier: %s", k, err)
+		return blk, nil
+	}
+	t.mu.Lock()
+	t.evict(t.touch(cacheKey(k), k, int64(len(blk.RawData())), nil))
+	t.mu.Unlock()
+	return blk, nil
+}
+
+func (t *tiered) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	t.deleteLk.Lock()
+	defer t.deleteLk.Unlock()
+
+	key := cacheKey(k)
+	t.mu.Lock()
+	delete(t.dirty, key)
+	t.forget(key)
+	t.mu.Unlock()
+
+	if err := t.fast.DeleteBlock(ctx, k); err != nil {
+		return err
+	}
+	return t.slow.DeleteBlock(ctx, k)
+}
+
+// AllKeysChan returns the keys of the fast tier followed by the keys of the
+// slow tier. Keys present in both tiers are returned twice.
+func (t *tiered) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	// cancelling the tier queries stops their goroutines
+	ctx, cancel := context.WithCancel(ctx)
+	fastCh, err := t.fast.AllKeysChan(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	slowCh, err := t.slow.AllKeysChan(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	output := make(chan cid.Cid)
+	go func() {
+		defer close(output)
+		defer cancel()
+		for _, ch := range []<-chan cid.Cid{fastCh, slowCh} {
+			for {
+				var k cid.Cid
+				var ok bool
+				select {
+				case k, ok = <-ch:
+				case <-ctx.Done():
+					return
+				}
+				if !ok {
+					break
+				}
+				select {
+				case output <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return output, nil
+}
+
+func (t *tiered) HashOnRead(enabled bool) {
+	t.fast.HashOnRead(enabled)
+	t.slow.HashOnRead(enabled)
+}
+
+func (t *tiered) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer close(done)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for t.pending > 0 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			t.drained.Wait()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// wake the waiter up so that it sees stop
+		close(stop)
+		t.mu.Lock()
+		t.drained.Broadcast()
+		t.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.wbErr
+	t.wbErr = nil
+	return err
+}
+
+// Close flushes pending write-backs and stops the write-back workers. It does
+// not close the underlying tiers.
+func (t *tiered) Close() error {
+	err := t.Flush(context.Background())
+	t.closeOnce.Do(func() {
+		close(t.closing)
+	})
+	t.workers.Wait()
+	return err
+}
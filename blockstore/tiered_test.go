@@ -0,0 +1,348 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+)
+
+// gatedBlockstore blocks PutMany calls until the gate is opened.
+type gatedBlockstore struct {
+	Blockstore
+	gate chan struct{}
+}
+
+func (g *gatedBlockstore) PutMany(ctx context.Context, bs []blocks.Block) error {
+	<-g.gate
+	return g.Blockstore.PutMany(ctx, bs)
+}
+
+type failingPutBlockstore struct {
+	Blockstore
+}
+
+func (failingPutBlockstore) Put(context.Context, blocks.Block) error {
+	return errors.New("disk full")
+}
+
+func (failingPutBlockstore) PutMany(context.Context, []blocks.Block) error {
+	return errors.New("disk full")
+}
+
+type failingKeysBlockstore struct {
+	Blockstore
+}
+
+func (failingKeysBlockstore) AllKeysChan(context.Context) (<-chan cid.Cid, error) {
+	return nil, errors.New("query failed")
+}
+
+// waitGoroutines fails the test unless the number of goroutines drops back
+// to n.
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines leaked", runtime.NumGoroutine()-n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newMemBlockstore() Blockstore {
+	return NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+}
+
+func newTestTiered(t *testing.T, fast, slow Blockstore, opts TieredOpts) TieredBlockstore {
+	tbs, err := NewTieredBlockstore(fast, slow, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tbs.Close() })
+	return tbs
+}
+
+func TestTieredWriteBack(t *testing.T) {
+	fast, slow := newMemBlockstore(), newMemBlockstore()
+	tbs := newTestTiered(t, fast, slow, DefaultTieredOpts())
+
+	var bs []blocks.Block
+	for i := 0; i < 100; i++ {
+		bs = append(bs, blocks.NewBlock([]byte(fmt.Sprint("block ", i))))
+	}
+	if err := tbs.Put(bg, bs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.PutMany(bg, bs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.Flush(bg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range bs {
+		for name, tier := range map[string]Blockstore{"fast": fast, "slow": slow} {
+			has, err := tier.Has(bg, b.Cid())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !has {
+				t.Fatalf("%s tier is missing %s after flush", name, b.Cid())
+			}
+		}
+	}
+}
+
+func TestTieredAckAfterFastWrite(t *testing.T) {
+	fast := newMemBlockstore()
+	slow := &gatedBlockstore{Blockstore: newMemBlockstore(), gate: make(chan struct{})}
+	tbs := newTestTiered(t, fast, slow, DefaultTieredOpts())
+
+	b := blocks.NewBlock([]byte("acknowledged"))
+	if err := tbs.Put(bg, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write-back is stuck, as it would be if the process crashed right
+	// now: the acknowledged block must already be in the fast tier.
+	if has, _ := fast.Has(bg, b.Cid()); !has {
+		t.Fatal("acknowledged block is not in the fast tier")
+	}
+	if has, _ := slow.Has(bg, b.Cid()); has {
+		t.Fatal("block reached the slow tier before the write-back")
+	}
+
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	if err := tbs.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected flush to time out, got %v", err)
+	}
+
+	close(slow.gate)
+	if err := tbs.Flush(bg); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := slow.Has(bg, b.Cid()); !has {
+		t.Fatal("block missing from the slow tier after flush")
+	}
+}
+
+func TestTieredFastWriteFailureIsNotAcked(t *testing.T) {
+	slow := newMemBlockstore()
+	tbs := newTestTiered(t, failingPutBlockstore{newMemBlockstore()}, slow, DefaultTieredOpts())
+
+	b := blocks.NewBlock([]byte("not acknowledged"))
+	if err := tbs.Put(bg, b); err == nil {
+		t.Fatal("expected put to fail")
+	}
+	if err := tbs.Flush(bg); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := slow.Has(bg, b.Cid()); has {
+		t.Fatal("failed write was written back")
+	}
+}
+
+func TestTieredBackpressure(t *testing.T) {
+	opts := DefaultTieredOpts()
+	opts.WriteBackConcurrency = 1
+	opts.WriteBackBatchSize = 1
+	opts.DirtyQueueSize = 1
+	slow := &gatedBlockstore{Blockstore: newMemBlockstore(), gate: make(chan struct{})}
+	tbs := newTestTiered(t, newMemBlockstore(), slow, opts)
+
+	// One block is held by the worker, one fills the queue.
+	for i := 0; i < 2; i++ {
+		if err := tbs.Put(bg, blocks.NewBlock([]byte(fmt.Sprint("queued ", i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	if err := tbs.Put(ctx, blocks.NewBlock([]byte("blocked"))); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected put to block on a full queue, got %v", err)
+	}
+
+	close(slow.gate)
+	if err := tbs.Flush(bg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTieredMissPromotion(t *testing.T) {
+	fast, slow := newMemBlockstore(), newMemBlockstore()
+	tbs := newTestTiered(t, fast, slow, DefaultTieredOpts())
+
+	b := blocks.NewBlock([]byte("only in the slow tier"))
+	if err := slow.Put(bg, b); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := tbs.Has(bg, b.Cid()); !has {
+		t.Fatal("Has should fall back to the slow tier")
+	}
+	if has, _ := fast.Has(bg, b.Cid()); has {
+		t.Fatal("Has should not promote")
+	}
+
+	got, err := tbs.Get(bg, b.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Cid().Equals(b.Cid()) {
+		t.Fatal("got the wrong block")
+	}
+	if has, _ := fast.Has(bg, b.Cid()); !has {
+		t.Fatal("block was not promoted to the fast tier")
+	}
+}
+
+func TestTieredEviction(t *testing.T) {
+	opts := DefaultTieredOpts()
+	opts.WriteBackConcurrency = 1
+	opts.FastTierMaxBytes = 20
+	fast, slow := newMemBlockstore(), newMemBlockstore()
+	tbs := newTestTiered(t, fast, slow, opts)
+
+	// 10 bytes each.
+	b1 := blocks.NewBlock([]byte("block 0001"))
+	b2 := blocks.NewBlock([]byte("block 0002"))
+	b3 := blocks.NewBlock([]byte("block 0003"))
+	for _, b := range []blocks.Block{b1, b2} {
+		if err := tbs.Put(bg, b); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbs.Flush(bg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Use b1 so that b2 becomes the least recently used.
+	if _, err := tbs.Get(bg, b1.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.Put(bg, b3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.Flush(bg); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := fast.Has(bg, b2.Cid()); has {
+		t.Fatal("least recently used block was not evicted")
+	}
+	for _, b := range []blocks.Block{b1, b3} {
+		if has, _ := fast.Has(bg, b.Cid()); !has {
+			t.Fatalf("%s should still be in the fast tier", b.Cid())
+		}
+	}
+	if has, _ := tbs.Has(bg, b2.Cid()); !has {
+		t.Fatal("evicted block must still be served from the slow tier")
+	}
+}
+
+func TestTieredDirtyBlocksAreNotEvicted(t *testing.T) {
+	opts := DefaultTieredOpts()
+	opts.FastTierMaxBytes = 1
+	fast := newMemBlockstore()
+	slow := &gatedBlockstore{Blockstore: newMemBlockstore(), gate: make(chan struct{})}
+	tbs := newTestTiered(t, fast, slow, opts)
+
+	promoted := blocks.NewBlock([]byte("promoted"))
+	if err := slow.Blockstore.Put(bg, promoted); err != nil {
+		t.Fatal(err)
+	}
+	dirty := blocks.NewBlock([]byte("dirty"))
+	if err := tbs.Put(bg, dirty); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := tbs.Get(bg, promoted.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if has, _ := fast.Has(bg, dirty.Cid()); !has {
+		t.Fatal("dirty block was evicted before being written back")
+	}
+	close(slow.gate)
+}
+
+func TestTieredDeleteBlock(t *testing.T) {
+	fast, slow := newMemBlockstore(), newMemBlockstore()
+	tbs := newTestTiered(t, fast, slow, DefaultTieredOpts())
+
+	b := blocks.NewBlock([]byte("to be deleted"))
+	if err := tbs.Put(bg, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.Flush(bg); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.DeleteBlock(bg, b.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	for name, tier := range map[string]Blockstore{"fast": fast, "slow": slow} {
+		if has, _ := tier.Has(bg, b.Cid()); has {
+			t.Fatalf("block still in the %s tier", name)
+		}
+	}
+}
+
+func TestTieredClosed(t *testing.T) {
+	tbs, err := NewTieredBlockstore(newMemBlockstore(), newMemBlockstore(), DefaultTieredOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbs.Put(bg, exampleBlock); err != ErrTieredClosed {
+		t.Fatalf("expected ErrTieredClosed, got %v", err)
+	}
+}
+
+func TestTieredFlushCancelledNoLeak(t *testing.T) {
+	slow := &gatedBlockstore{Blockstore: newMemBlockstore(), gate: make(chan struct{})}
+	tbs := newTestTiered(t, newMemBlockstore(), slow, DefaultTieredOpts())
+	// let Close drain the queue
+	t.Cleanup(func() { close(slow.gate) })
+	if err := tbs.Put(bg, exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(bg)
+		cancel()
+		if err := tbs.Flush(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected flush to be cancelled, got %v", err)
+		}
+	}
+	waitGoroutines(t, goroutines)
+}
+
+func TestTieredAllKeysChanErrorNoLeak(t *testing.T) {
+	// more keys than the channels of the fast tier can buffer
+	fast := newMemBlockstore()
+	for i := 0; i < 1000; i++ {
+		if err := fast.Put(bg, blocks.NewBlock([]byte(fmt.Sprint("key ", i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tbs := newTestTiered(t, fast, failingKeysBlockstore{newMemBlockstore()}, DefaultTieredOpts())
+
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if _, err := tbs.AllKeysChan(bg); err == nil {
+			t.Fatal("expected the slow tier query to fail")
+		}
+	}
+	waitGoroutines(t, goroutines)
+}
@@ -39,11 +39,16 @@ type tqcache struct {
 
 	hits  metrics.Counter
 	total metrics.Counter
+
+	hasStats     *cacheOpCounters
+	getStats     *cacheOpCounters
+	getSizeStats *cacheOpCounters
 }
 
 var (
-	_ Blockstore = (*tqcache)(nil)
-	_ Viewer     = (*tqcache)(nil)
+	_ Blockstore     = (*tqcache)(nil)
+	_ Viewer         = (*tqcache)(nil)
+	_ CacheInspector = (*tqcache)(nil)
 )
 
 func newTwoQueueCachedBS(ctx context.Context, bs Blockstore, lruSize int) (*tqcache, error) {
@@ -55,6 +60,9 @@ func newTwoQueueCachedBS(ctx context.Context, bs Blockstore, lruSize int) (*tqca
 	c := &tqcache{cache: cache, blockstore: bs, lks: make(map[string]*lock)}
 	c.hits = metrics.NewCtx(ctx, "boxo_blockstore.cache_hits", "Number of blockstore cache hits").Counter()
 	c.total = metrics.NewCtx(ctx, "boxo_blockstore.cache_total", "Total number of blockstore cache requests").Counter()
+	c.hasStats = newCacheOpCounters(ctx, "twoqueue", "has")
+	c.getStats = newCacheOpCounters(ctx, "twoqueue", "get")
+	c.getSizeStats = newCacheOpCounters(ctx, "twoqueue", "getsize")
 	if v, ok := bs.(Viewer); ok {
 		c.viewer = v
 	}
@@ -130,8 +138,14 @@ func (b *tqcache) Has(ctx context.Context, k cid.Cid) (bool, error) {
 	key := cacheKey(k)
 
 	if has, _, ok := b.queryCache(key); ok {
+		if has {
+			b.hasStats.positiveHit()
+		} else {
+			b.hasStats.negativeHit()
+		}
 		return has, nil
 	}
+	b.hasStats.miss()
 
 	b.lock(key, false)
 	defer b.unlock(key, false)
@@ -154,14 +168,17 @@ func (b *tqcache) GetSize(ctx context.Context, k cid.Cid) (int, error) {
 	if has, blockSize, ok := b.queryCache(key); ok {
 		if !has {
 			// don't have it, return
+			b.getSizeStats.negativeHit()
 			return -1, ipld.ErrNotFound{Cid: k}
 		}
 		if blockSize >= 0 {
 			// have it and we know the size
+			b.getSizeStats.positiveHit()
 			return blockSize, nil
 		}
 		// we have it but don't know the size, ask the datastore.
 	}
+	b.getSizeStats.miss()
 
 	b.lock(key, false)
 	defer b.unlock(key, false)
@@ -226,8 +243,14 @@ func (b *tqcache) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
 
 	key := cacheKey(k)
 
-	if has, _, ok := b.queryCache(key); ok && !has {
-		return nil, ipld.ErrNotFound{Cid: k}
+	if has, _, ok := b.queryCache(key); ok {
+		if !has {
+			b.getStats.negativeHit()
+			return nil, ipld.ErrNotFound{Cid: k}
+		}
+		b.getStats.positiveHit()
+	} else {
+		b.getStats.miss()
 	}
 
 	b.lock(key, false)
@@ -245,7 +268,7 @@ func (b *tqcache) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
 func (b *tqcache) Put(ctx context.Context, bl blocks.Block) error {
 	key := cacheKey(bl.Cid())
 
-	if has, _, ok := b.queryCache(key); ok && has {
+	if has, _, ok := b.queryCache(key); ok && has && !IsWriteThrough(ctx) {
 		return nil
 	}
 
@@ -319,11 +342,12 @@ func newKeyedBlocks(cap int) *keyedBlocks {
 
 func (b *tqcache) PutMany(ctx context.Context, bs []blocks.Block) error {
 	good := newKeyedBlocks(len(bs))
+	writeThrough := IsWriteThrough(ctx)
 	for _, blk := range bs {
 		// call put on block if result is inconclusive or we are sure that
 		// the block isn't in storage
 		key := cacheKey(blk.Cid())
-		if has, _, ok := b.queryCache(key); !ok || (ok && !has) {
+		if has, _, ok := b.queryCache(key); writeThrough || !ok || (ok && !has) {
 			good.append(key, blk)
 		}
 	}
@@ -398,6 +422,44 @@ func (b *tqcache) queryCache(k string) (exists bool, size int, ok bool) {
 	return false, -1, false
 }
 
+// Stats implements CacheInspector.
+func (b *tqcache) Stats() CacheStats {
+	keys := b.cache.Keys()
+	approx := 0
+	for _, k := range keys {
+		approx += len(k) + cacheEntryOverhead
+	}
+	st := CacheStats{
+		Entries:     len(keys),
+		ApproxBytes: approx,
+		Has:         b.hasStats.stats(),
+		Get:         b.getStats.stats(),
+		GetSize:     b.getSizeStats.stats(),
+	}
+	if ci, ok := b.blockstore.(CacheInspector); ok {
+		st.Blocks = ci.Stats().Blocks
+	}
+	return st
+}
+
+// Contains implements CacheInspector.
+func (b *tqcache) Contains(k cid.Cid) (cached bool, hasBlock bool) {
+	if !k.Defined() {
+		return false, false
+	}
+	h, ok := b.cache.Peek(cacheKey(k))
+	if !ok {
+		return false, false
+	}
+	switch h := h.(type) {
+	case cacheHave:
+		return true, bool(h)
+	case cacheSize:
+		return true, true
+	}
+	return false, false
+}
+
 func (b *tqcache) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 	return b.blockstore.AllKeysChan(ctx)
 }
@@ -397,3 +397,85 @@ func BenchmarkTwoQueueCacheConcurrentOps(b *testing.B) {
 		})
 	}
 }
+
+func TestTwoQueueCacheStats(t *testing.T) {
+	c, _, _ := createStores(t)
+	missing := blocks.NewBlock([]byte("missing"))
+
+	if cached, _ := c.Contains(exampleBlock.Cid()); cached {
+		t.Fatal("empty cache reports an entry")
+	}
+
+	// Misses, each populates the cache.
+	if err := c.Put(bg, exampleBlock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Has(bg, missing.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Positive hits.
+	for i := 0; i < 3; i++ {
+		if _, err := c.Has(bg, exampleBlock.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.GetSize(bg, exampleBlock.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(bg, exampleBlock.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Negative hits.
+	for i := 0; i < 2; i++ {
+		if _, err := c.Has(bg, missing.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Get(bg, missing.Cid()); !ipld.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSize(bg, missing.Cid()); !ipld.IsNotFound(err) {
+		t.Fatal(err)
+	}
+
+	// Misses of an unknown block.
+	other := blocks.NewBlock([]byte("other"))
+	if _, err := c.GetSize(bg, other.Cid()); !ipld.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	other2 := blocks.NewBlock([]byte("other2"))
+	if _, err := c.Get(bg, other2.Cid()); !ipld.IsNotFound(err) {
+		t.Fatal(err)
+	}
+
+	stats := c.Stats()
+	expect := map[string][2]CacheOpStats{
+		"Has":     {stats.Has, {PositiveHits: 3, NegativeHits: 2, Misses: 1}},
+		"Get":     {stats.Get, {PositiveHits: 1, NegativeHits: 1, Misses: 1}},
+		"GetSize": {stats.GetSize, {PositiveHits: 1, NegativeHits: 1, Misses: 1}},
+	}
+	for op, e := range expect {
+		if e[0] != e[1] {
+			t.Errorf("%s: expected %+v, got %+v", op, e[1], e[0])
+		}
+	}
+	// exampleBlock, missing, other and other2.
+	if stats.Entries != 4 {
+		t.Fatalf("expected 4 entries, got %d", stats.Entries)
+	}
+	if stats.ApproxBytes < stats.Entries*cacheEntryOverhead {
+		t.Fatalf("approximate size %d is too small", stats.ApproxBytes)
+	}
+
+	if cached, has := c.Contains(exampleBlock.Cid()); !cached || !has {
+		t.Fatal("expected a positive cache entry")
+	}
+	if cached, has := c.Contains(missing.Cid()); !cached || has {
+		t.Fatal("expected a negative cache entry")
+	}
+	if c.Stats().Has != stats.Has {
+		t.Fatal("Contains should not update the statistics")
+	}
+}
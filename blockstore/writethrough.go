@@ -0,0 +1,23 @@
+package blockstore
+
+import "context"
+
+type writeThroughKey struct{}
+
+// WithWriteThrough returns a context making the Put and PutMany calls using
+// it behave as if the blockstore was created with the WriteThrough option:
+// blocks are written without first checking whether they are already stored.
+// This is meant for imports of blocks known to be new, without giving up the
+// checks for the other users of the blockstore.
+//
+// The context is honored by the default blockstore, the caching wrappers and
+// blockservice.AddBlock(s).
+func WithWriteThrough(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeThroughKey{}, true)
+}
+
+// IsWriteThrough reports whether ctx was returned by WithWriteThrough.
+func IsWriteThrough(ctx context.Context) bool {
+	wt, _ := ctx.Value(writeThroughKey{}).(bool)
+	return wt
+}
@@ -0,0 +1,144 @@
+package blockstore
+
+import (
+	"fmt"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func newTestBlocks(n int) []blocks.Block {
+	out := make([]blocks.Block, n)
+	for i := range out {
+		out[i] = blocks.NewBlock([]byte(fmt.Sprint("write-through ", i)))
+	}
+	return out
+}
+
+func TestWithWriteThroughSkipsHas(t *testing.T) {
+	d := newCountingDS()
+	bs := NewBlockstore(d)
+	blks := newTestBlocks(10)
+
+	if err := bs.Put(bg, blks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.PutMany(bg, blks[1:5]); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.calls.Load(); n != 5 {
+		t.Fatalf("expected 5 Has calls without the option, got %d", n)
+	}
+
+	d.calls.Store(0)
+	ctx := WithWriteThrough(bg)
+	if err := bs.Put(ctx, blks[5]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.PutMany(ctx, blks[6:]); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.calls.Load(); n != 0 {
+		t.Fatalf("expected no Has calls with the option, got %d", n)
+	}
+	assertAllPresent(t, bs, blks)
+}
+
+func TestWithWriteThroughCachesStayConsistent(t *testing.T) {
+	for _, mode := range []string{"check", "write-through"} {
+		t.Run(mode, func(t *testing.T) {
+			ctx := bg
+			if mode == "write-through" {
+				ctx = WithWriteThrough(bg)
+			}
+			base := NewBlockstore(newCountingDS())
+			cbs, err := CachedBlockstore(bg, base, DefaultCacheOpts())
+			if err != nil {
+				t.Fatal(err)
+			}
+			bloom := cbs.(*bloomcache)
+			bloom.Wait(bg)
+			blks := newTestBlocks(20)
+
+			// Cache the blocks as missing first.
+			for _, blk := range blks {
+				if has, _ := cbs.Has(bg, blk.Cid()); has {
+					t.Fatal("block should be missing")
+				}
+			}
+			if err := cbs.Put(ctx, blks[0]); err != nil {
+				t.Fatal(err)
+			}
+			if err := cbs.PutMany(ctx, blks[1:]); err != nil {
+				t.Fatal(err)
+			}
+			assertAllPresent(t, cbs, blks)
+			for _, blk := range blks {
+				if !bloom.bloom.HasTS(blk.Cid().Hash()) {
+					t.Fatal("block missing from the bloom filter")
+				}
+			}
+
+			// The underlying store loses blocks the caches think are there.
+			for _, blk := range blks[:10] {
+				if err := base.DeleteBlock(bg, blk.Cid()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := cbs.PutMany(ctx, blks[:10]); err != nil {
+				t.Fatal(err)
+			}
+			for _, blk := range blks[:10] {
+				has, err := base.Has(bg, blk.Cid())
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Only write-through Puts go past the caches.
+				if has != (mode == "write-through") {
+					t.Fatalf("unexpected presence %t in the underlying store", has)
+				}
+			}
+
+			// Deletes still invalidate, and later Puts are cached again.
+			if err := cbs.DeleteBlock(bg, blks[15].Cid()); err != nil {
+				t.Fatal(err)
+			}
+			if has, _ := cbs.Has(bg, blks[15].Cid()); has {
+				t.Fatal("deleted block is still reported")
+			}
+			if err := cbs.Put(ctx, blks[15]); err != nil {
+				t.Fatal(err)
+			}
+			assertAllPresent(t, cbs, blks[10:])
+		})
+	}
+}
+
+func BenchmarkImportWriteThrough(b *testing.B) {
+	const n = 100_000
+	blks := newTestBlocks(n)
+	for _, mode := range []string{"check", "write-through"} {
+		b.Run(mode, func(b *testing.B) {
+			ctx := bg
+			if mode == "write-through" {
+				ctx = WithWriteThrough(bg)
+			}
+			var calls int64
+			for i := 0; i < b.N; i++ {
+				d := newCountingDS()
+				bs := NewBlockstore(d)
+				for j := 0; j < n; j += 256 {
+					end := j + 256
+					if end > n {
+						end = n
+					}
+					if err := bs.PutMany(ctx, blks[j:end]); err != nil {
+						b.Fatal(err)
+					}
+				}
+				calls += d.calls.Load()
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "has/op")
+		})
+	}
+}
@@ -2,13 +2,18 @@ package bootstrap
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"math/rand"
+	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/benbjohnson/clock"
+	ds "github.com/ipfs/go-datastore"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/jbenet/goprocess"
 	goprocessctx "github.com/jbenet/goprocess/context"
@@ -18,6 +23,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 var log = logging.Logger("bootstrap")
@@ -56,11 +63,38 @@ type BootstrapConfig struct {
 	BackupBootstrapInterval time.Duration
 
 	// MaxBackupBootstrapSize controls the maximum number of peers we're saving
-	// as backup bootstrap peers.
+	// as backup bootstrap peers. The peers connected for the longest time are
+	// saved first, one per IP prefix before the others.
 	MaxBackupBootstrapSize int
 
+	// BackoffInitial enables an exponential backoff of the rounds which fail
+	// to reach MinPeerThreshold: the round following a failed one is run
+	// after BackoffInitial, and the delay is multiplied by BackoffMultiplier
+	// after each further failure, up to BackoffMax. A successful round resets
+	// the backoff, the rounds are then run every Period again. Zero disables
+	// the backoff, all the rounds are run every Period.
+	BackoffInitial time.Duration
+
+	// BackoffMax is the maximum delay of the backoff. Zero means Period, or
+	// BackoffInitial if greater.
+	BackoffMax time.Duration
+
+	// BackoffMultiplier is the factor of the delays of the backoff. Zero
+	// means 2.
+	BackoffMultiplier float64
+
+	// MaxConcurrentDials caps the number of connections attempted at once in
+	// a round. Zero means no limit.
+	MaxConcurrentDials int
+
+	// SampleSize is the number of bootstrap peers a round dials, picked at
+	// random among the BootstrapPeers for each round. Zero means all of them.
+	SampleSize int
+
 	saveBackupBootstrapPeers func(context.Context, []peer.AddrInfo)
 	loadBackupBootstrapPeers func(context.Context) []peer.AddrInfo
+
+	clock clock.Clock
 }
 
 // DefaultBootstrapConfig specifies default sane parameters for bootstrapping.
@@ -96,6 +130,41 @@ func WithBackupPeers(load func(context.Context) []peer.AddrInfo, save func(conte
 	}
 }
 
+// BackupPeersKey is the datastore key under which WithBackupPeersDatastore
+// saves the backup bootstrap peers.
+var BackupPeersKey = ds.NewKey("/local/bootstrap/backup-peers")
+
+// WithBackupPeersDatastore configures the backup bootstrap peers to be loaded
+// from and saved to d, under BackupPeersKey, as JSON.
+func WithBackupPeersDatastore(d ds.Datastore) func(*BootstrapConfig) {
+	load := func(ctx context.Context) []peer.AddrInfo {
+		data, err := d.Get(ctx, BackupPeersKey)
+		if err != nil {
+			if !errors.Is(err, ds.ErrNotFound) {
+				log.Errorf("failed to load the backup bootstrap peers: %s", err)
+			}
+			return nil
+		}
+		var peers []peer.AddrInfo
+		if err := json.Unmarshal(data, &peers); err != nil {
+			log.Errorf("invalid backup bootstrap peers: %s", err)
+			return nil
+		}
+		return peers
+	}
+	save := func(ctx context.Context, peers []peer.AddrInfo) {
+		data, err := json.Marshal(peers)
+		if err != nil {
+			log.Errorf("failed to marshal the backup bootstrap peers: %s", err)
+			return
+		}
+		if err := d.Put(ctx, BackupPeersKey, data); err != nil {
+			log.Errorf("failed to save the backup bootstrap peers: %s", err)
+		}
+	}
+	return WithBackupPeers(load, save)
+}
+
 // BackupPeers returns the load and save backup peers functions.
 func (cfg *BootstrapConfig) BackupPeers() (func(context.Context) []peer.AddrInfo, func(context.Context, []peer.AddrInfo)) {
 	return cfg.loadBackupBootstrapPeers, cfg.saveBackupBootstrapPeers
@@ -121,23 +190,41 @@ func Bootstrap(id peer.ID, host host.Host, rt routing.Routing, cfg BootstrapConf
 		log.Warn("no bootstrap nodes configured: go-ipfs may have difficulty connecting to the network")
 	}
 
-	// the periodic bootstrap function -- the connection supervisor
-	periodic := func(worker goprocess.Process) {
-		ctx := goprocessctx.OnClosingContext(worker)
+	clk := cfg.clock
+	if clk == nil {
+		clk = clock.New()
+	}
 
-		if err := bootstrapRound(ctx, host, cfg); err != nil {
-			log.Debugf("%s bootstrap error: %s", id, err)
-		}
+	// the bootstrap loop -- the connection supervisor, which runs one round
+	// right now and the others after the delays of roundBackoff
+	proc := goprocess.Go(func(worker goprocess.Process) {
+		ctx := goprocessctx.OnClosingContext(worker)
+		backoff := roundBackoff{cfg: &cfg}
+		for {
+			start := clk.Now()
+			err := bootstrapRound(ctx, host, cfg)
+			if err != nil {
+				log.Debugf("%s bootstrap error: %s", id, err)
+			}
 
-		// Exit the first call (triggered independently by `proc.Go`, not `Tick`)
-		// only after being done with the *single* Routing.Bootstrap call. Following
-		// periodic calls (`Tick`) will not block on this.
-		<-doneWithRound
-	}
+			// Wait after the first round only until being done with the
+			// *single* Routing.Bootstrap call. The following rounds will not
+			// block on this.
+			select {
+			case <-doneWithRound:
+			case <-worker.Closing():
+				return
+			}
 
-	// kick off the node's periodic bootstrapping
-	proc := periodicproc.Tick(cfg.Period, periodic)
-	proc.Go(periodic) // run one right now.
+			timer := clk.Timer(backoff.next(clk.Since(start), err))
+			select {
+			case <-timer.C:
+			case <-worker.Closing():
+				timer.Stop()
+				return
+			}
+		}
+	})
 
 	// kick off Routing.Bootstrap
 	if rt != nil {
@@ -149,7 +236,7 @@ func Bootstrap(id peer.ID, host host.Host, rt routing.Routing, cfg BootstrapConf
 	}
 
 	doneWithRound <- struct{}{}
-	close(doneWithRound) // it no longer blocks periodic
+	close(doneWithRound) // it no longer blocks the bootstrap loop
 
 	// If loadBackupBootstrapPeers is not nil then saveBackupBootstrapPeers
 	// must also not be nil.
@@ -187,11 +274,7 @@ func startSavePeersAsTemporaryBootstrapProc(cfg BootstrapConfig, host host.Host,
 }
 
 func saveConnectedPeersAsTemporaryBootstrap(ctx context.Context, host host.Host, cfg BootstrapConfig) error {
-	// Randomize the list of connected peers, we don't prioritize anyone.
-	connectedPeers := randomizeList(host.Network().Peers())
-
 	bootstrapPeers := cfg.BootstrapPeers()
-	backupPeers := make([]peer.AddrInfo, 0, cfg.MaxBackupBootstrapSize)
 	foundPeers := make(map[peer.ID]struct{}, cfg.MaxBackupBootstrapSize+len(bootstrapPeers))
 
 	// Don't record bootstrap peers
@@ -200,20 +283,16 @@ func saveConnectedPeersAsTemporaryBootstrap(ctx context.Context, host host.Host,
 	}
 
 	// Choose peers to save and filter out the ones that are already bootstrap nodes.
-	for _, p := range connectedPeers {
+	candidates := make([]backupCandidate, 0, len(host.Network().Peers()))
+	for _, p := range host.Network().Peers() {
 		if _, found := foundPeers[p]; found {
 			continue
 		}
-		foundPeers[p] = struct{}{}
-
-		backupPeers = append(backupPeers, peer.AddrInfo{
-			ID:    p,
-			Addrs: host.Network().Peerstore().Addrs(p),
-		})
-
-		if len(backupPeers) >= cfg.MaxBackupBootstrapSize {
-			break
-		}
+		candidates = append(candidates, newBackupCandidate(host, p))
+	}
+	backupPeers := selectBackupPeers(candidates, cfg.MaxBackupBootstrapSize)
+	for _, p := range backupPeers {
+		foundPeers[p.ID] = struct{}{}
 	}
 
 	// If we didn't reach the target number use previously stored connected peers.
@@ -242,6 +321,125 @@ func saveConnectedPeersAsTemporaryBootstrap(ctx context.Context, host host.Host,
 	return nil
 }
 
+// roundBackoff computes the delays between the bootstrap rounds.
+type roundBackoff struct {
+	cfg *BootstrapConfig
+	// delay is the last delay of the backoff, zero after a successful round.
+	delay time.Duration
+}
+
+// next returns the delay before the round following one which took elapsed,
+// and failed if err is not nil.
+func (b *roundBackoff) next(elapsed time.Duration, err error) time.Duration {
+	if err == nil || b.cfg.BackoffInitial <= 0 {
+		b.delay = 0
+		if elapsed >= b.cfg.Period {
+			return 0
+		}
+		return b.cfg.Period - elapsed
+	}
+
+	if b.delay == 0 {
+		b.delay = b.cfg.BackoffInitial
+		return b.delay
+	}
+	maxDelay := b.cfg.BackoffMax
+	if maxDelay <= 0 {
+		maxDelay = b.cfg.Period
+	}
+	if maxDelay < b.cfg.BackoffInitial {
+		maxDelay = b.cfg.BackoffInitial
+	}
+	multiplier := b.cfg.BackoffMultiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	if next := float64(b.delay) * multiplier; next < float64(maxDelay) {
+		b.delay = time.Duration(next)
+	} else {
+		b.delay = maxDelay
+	}
+	return b.delay
+}
+
+// backupCandidate is a connected peer which may be saved as a backup bootstrap
+// peer.
+type backupCandidate struct {
+	info peer.AddrInfo
+	// opened is the time the oldest connection to the peer was opened, zero
+	// if unknown.
+	opened time.Time
+	// prefix is the IP prefix of the remote address of that connection,
+	// empty if it is not an IP address.
+	prefix string
+}
+
+func newBackupCandidate(host host.Host, p peer.ID) backupCandidate {
+	c := backupCandidate{info: peer.AddrInfo{ID: p, Addrs: host.Network().Peerstore().Addrs(p)}}
+	var oldest network.Conn
+	for _, conn := range host.Network().ConnsToPeer(p) {
+		opened := conn.Stat().Opened
+		if oldest == nil || !opened.IsZero() && (c.opened.IsZero() || opened.Before(c.opened)) {
+			oldest = conn
+			c.opened = opened
+		}
+	}
+	if oldest != nil {
+		c.prefix = ipPrefix(oldest.RemoteMultiaddr())
+	}
+	return c
+}
+
+// ipPrefix returns the /16 prefix of the IPv4 address of addr, or the /32 one
+// of its IPv6 address, and an empty string if addr has no IP address.
+func ipPrefix(addr ma.Multiaddr) string {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String() + "/16"
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String() + "/32"
+}
+
+// selectBackupPeers picks up to n of the candidates, preferring the ones
+// connected for the longest time, and among those one peer per IP prefix
+// before the others.
+func selectBackupPeers(candidates []backupCandidate, n int) []peer.AddrInfo {
+	// Randomize the candidates first, we don't prioritize anyone among the
+	// peers connected for as long.
+	candidates = randomizeList(candidates)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		oi, oj := candidates[i].opened, candidates[j].opened
+		return !oi.IsZero() && (oj.IsZero() || oi.Before(oj))
+	})
+
+	picked := make([]peer.AddrInfo, 0, n)
+	prefixes := make(map[string]struct{})
+	var others []backupCandidate
+	for _, c := range candidates {
+		if len(picked) >= n {
+			return picked
+		}
+		if c.prefix != "" {
+			if _, found := prefixes[c.prefix]; found {
+				others = append(others, c)
+				continue
+			}
+			prefixes[c.prefix] = struct{}{}
+		}
+		picked = append(picked, c.info)
+	}
+	for _, c := range others {
+		if len(picked) >= n {
+			break
+		}
+		picked = append(picked, c.info)
+	}
+	return picked
+}
+
 // Connect to as many peers needed to reach the BootstrapConfig.MinPeerThreshold.
 // Peers can be original bootstrap or temporary ones (drawn from a list of
 // persisted previously connected peers).
@@ -262,8 +460,11 @@ func bootstrapRound(ctx context.Context, host host.Host, cfg BootstrapConfig) er
 	}
 	numToDial := cfg.MinPeerThreshold - len(connected) // numToDial > 0
 
+	if cfg.SampleSize > 0 && len(peers) > cfg.SampleSize {
+		peers = randomizeList(peers)[:cfg.SampleSize]
+	}
 	if len(peers) > 0 {
-		numToDial -= int(peersConnect(ctx, host, peers, numToDial, true))
+		numToDial -= int(peersConnect(ctx, host, peers, numToDial, cfg.MaxConcurrentDials, true))
 		if numToDial <= 0 {
 			return nil
 		}
@@ -278,7 +479,7 @@ func bootstrapRound(ctx context.Context, host host.Host, cfg BootstrapConfig) er
 
 	tempBootstrapPeers := cfg.loadBackupBootstrapPeers(ctx)
 	if len(tempBootstrapPeers) > 0 {
-		numToDial -= int(peersConnect(ctx, host, tempBootstrapPeers, numToDial, false))
+		numToDial -= int(peersConnect(ctx, host, tempBootstrapPeers, numToDial, cfg.MaxConcurrentDials, false))
 		if numToDial <= 0 {
 			return nil
 		}
@@ -294,9 +495,9 @@ func bootstrapRound(ctx context.Context, host host.Host, cfg BootstrapConfig) er
 // Return the number of connections completed. We eagerly over-connect in parallel,
 // so we might connect to more than needed.
 // (We spawn as many routines and attempt connections as the number of availablePeers,
-// but this list comes from restricted sets of original or temporary bootstrap
-// nodes which will keep it under a sane value.)
-func peersConnect(ctx context.Context, ph host.Host, availablePeers []peer.AddrInfo, needed int, permanent bool) uint64 {
+// unless maxDials is positive, but this list comes from restricted sets of original
+// or temporary bootstrap nodes which will keep it under a sane value.)
+func peersConnect(ctx context.Context, ph host.Host, availablePeers []peer.AddrInfo, needed, maxDials int, permanent bool) uint64 {
 	peers := randomizeList(availablePeers)
 
 	// Monitor the number of connections and stop if we reach the target.
@@ -317,6 +518,11 @@ func peersConnect(ctx context.Context, ph host.Host, availablePeers []peer.AddrI
 		}
 	}()
 
+	var dials chan struct{}
+	if maxDials > 0 {
+		dials = make(chan struct{}, maxDials)
+	}
+
 	var wg sync.WaitGroup
 	for _, p := range peers {
 
@@ -325,6 +531,16 @@ func peersConnect(ctx context.Context, ph host.Host, availablePeers []peer.AddrI
 		// fail/abort due to an expiring context.
 		// Also, performed asynchronously for dial speed.
 
+		if dials != nil {
+			select {
+			case dials <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
 		if int(atomic.LoadUint64(&connected)) >= needed {
 			cancel()
 			break
@@ -333,6 +549,9 @@ func peersConnect(ctx context.Context, ph host.Host, availablePeers []peer.AddrI
 		wg.Add(1)
 		go func(p peer.AddrInfo) {
 			defer wg.Done()
+			if dials != nil {
+				defer func() { <-dials }()
+			}
 
 			// Skip addresses belonging to a peer we're already connected to.
 			// (Not a guarantee but a best-effort policy.)
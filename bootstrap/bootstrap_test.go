@@ -3,14 +3,25 @@ package bootstrap
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/benbjohnson/clock"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 func TestRandomizeAddressList(t *testing.T) {
@@ -137,3 +148,456 @@ func assertPanics(t *testing.T, name string, f func()) {
 
 	f()
 }
+
+// fakeHost is a host whose connections are made by connect, called with the
+// number of the round, which records the rounds and the dials of the
+// bootstrap process. It disconnects from all its peers at the start of the
+// rounds for which disconnect returns true.
+type fakeHost struct {
+	host.Host
+	ps         peerstore.Peerstore
+	connect    func(round int) error
+	disconnect func(round int) bool
+	clock      clock.Clock
+
+	mu       sync.Mutex
+	peers    map[peer.ID]struct{}
+	rounds   []time.Time
+	dialed   []peer.ID
+	dials    int
+	maxDials int
+}
+
+func newFakeHost(t *testing.T, clk clock.Clock, connect func(round int) error) *fakeHost {
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return &fakeHost{ps: ps, connect: connect, clock: clk, peers: make(map[peer.ID]struct{})}
+}
+
+func (h *fakeHost) ID() peer.ID                    { return "" }
+func (h *fakeHost) Peerstore() peerstore.Peerstore { return h.ps }
+func (h *fakeHost) Network() network.Network       { return fakeNetwork{h: h} }
+
+func (h *fakeHost) Connect(_ context.Context, p peer.AddrInfo) error {
+	h.mu.Lock()
+	round := len(h.rounds) - 1
+	h.dialed = append(h.dialed, p.ID)
+	h.dials++
+	if h.dials > h.maxDials {
+		h.maxDials = h.dials
+	}
+	h.mu.Unlock()
+
+	err := h.connect(round)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dials--
+	if err == nil {
+		h.peers[p.ID] = struct{}{}
+	}
+	return err
+}
+
+// delays returns the delays between the rounds.
+func (h *fakeHost) delays() []time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []time.Duration
+	for i := 1; i < len(h.rounds); i++ {
+		out = append(out, h.rounds[i].Sub(h.rounds[i-1]))
+	}
+	return out
+}
+
+type fakeNetwork struct {
+	network.Network
+	h *fakeHost
+}
+
+// Peers is called once at the start of each round.
+func (n fakeNetwork) Peers() []peer.ID {
+	h := n.h
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rounds = append(h.rounds, h.clock.Now())
+	if h.disconnect != nil && h.disconnect(len(h.rounds)-1) {
+		h.peers = make(map[peer.ID]struct{})
+	}
+	out := make([]peer.ID, 0, len(h.peers))
+	for p := range h.peers {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (n fakeNetwork) Connectedness(p peer.ID) network.Connectedness {
+	h := n.h
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.peers[p]; ok {
+		return network.Connected
+	}
+	return network.NotConnected
+}
+
+func randPeers(t *testing.T, n int) []peer.AddrInfo {
+	var out []peer.AddrInfo
+	for i := 0; i < n; i++ {
+		pid, err := test.RandPeerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, peer.AddrInfo{ID: pid})
+	}
+	return out
+}
+
+var errDialFailed = errors.New("dial failed")
+
+// runRounds advances clk until h has run n rounds, and returns the delays
+// between them, to the granularity of step.
+func runRounds(t *testing.T, h *fakeHost, clk *clock.Mock, n int, step time.Duration) []time.Duration {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		h.mu.Lock()
+		done := len(h.rounds) >= n
+		h.mu.Unlock()
+		if done {
+			return h.delays()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d rounds", n)
+		}
+		clk.Add(step)
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+func assertDelays(t *testing.T, expected, delays []time.Duration, step time.Duration) {
+	t.Helper()
+	if len(delays) < len(expected) {
+		t.Fatalf("expected %d delays, got %v", len(expected), delays)
+	}
+	for i, d := range expected {
+		// the timers are set one step late at most
+		if delays[i] < d || delays[i] > d+step {
+			t.Fatalf("expected the delays %v, got %v", expected, delays)
+		}
+	}
+}
+
+func TestBootstrapBackoff(t *testing.T) {
+	const step = 10 * time.Millisecond
+	clk := clock.NewMock()
+	// the rounds 0 to 5 fail, 6 connects, 7 is skipped as connected, and
+	// the following ones fail after a disconnection
+	h := newFakeHost(t, clk, func(round int) error {
+		if round == 6 {
+			return nil
+		}
+		return errDialFailed
+	})
+	h.disconnect = func(round int) bool { return round == 8 }
+
+	cfg := BootstrapConfigWithPeers(randPeers(t, 1))
+	cfg.MinPeerThreshold = 1
+	cfg.Period = time.Second
+	cfg.BackoffInitial = 100 * time.Millisecond
+	cfg.BackoffMax = 500 * time.Millisecond
+	cfg.BackoffMultiplier = 2
+	cfg.clock = clk
+
+	bootstrapper, err := Bootstrap("", h, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bootstrapper.Close()
+
+	// the failures back off up to BackoffMax, and the successes reset the
+	// backoff: the rounds are then run every Period
+	delays := runRounds(t, h, clk, 11, step)
+	ms := time.Millisecond
+	assertDelays(t, []time.Duration{
+		100 * ms, 200 * ms, 400 * ms, 500 * ms, 500 * ms, 500 * ms,
+		time.Second, time.Second,
+		100 * ms, 200 * ms,
+	}, delays, step)
+}
+
+func TestBootstrapDefaultPeriod(t *testing.T) {
+	const step = 10 * time.Millisecond
+	clk := clock.NewMock()
+	h := newFakeHost(t, clk, func(int) error { return errDialFailed })
+
+	cfg := BootstrapConfigWithPeers(randPeers(t, 1))
+	cfg.Period = time.Second
+	cfg.clock = clk
+
+	bootstrapper, err := Bootstrap("", h, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bootstrapper.Close()
+
+	// the failed rounds are run every Period without the backoff
+	delays := runRounds(t, h, clk, 4, step)
+	assertDelays(t, []time.Duration{time.Second, time.Second, time.Second}, delays, step)
+}
+
+func TestRoundBackoff(t *testing.T) {
+	cfg := BootstrapConfig{Period: 30 * time.Second, BackoffInitial: 10 * time.Second}
+	b := roundBackoff{cfg: &cfg}
+
+	// the multiplier defaults to 2 and the maximum to Period
+	for i, expected := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		if d := b.next(time.Second, errDialFailed); d != expected {
+			t.Fatalf("round %d: expected a delay of %s, got %s", i, expected, d)
+		}
+	}
+	// the successful rounds wait what is left of Period
+	if d := b.next(time.Second, nil); d != 29*time.Second {
+		t.Fatalf("expected a delay of 29s, got %s", d)
+	}
+	if d := b.next(time.Minute, nil); d != 0 {
+		t.Fatalf("expected no delay, got %s", d)
+	}
+	if d := b.next(time.Second, errDialFailed); d != 10*time.Second {
+		t.Fatalf("expected the backoff to be reset, got %s", d)
+	}
+}
+
+func TestPeersConnectConcurrency(t *testing.T) {
+	h := newFakeHost(t, clock.New(), func(int) error {
+		time.Sleep(5 * time.Millisecond)
+		return errDialFailed
+	})
+
+	peers := randPeers(t, 10)
+	if n := peersConnect(context.Background(), h, peers, len(peers), 3, true); n != 0 {
+		t.Fatalf("expected no connection, got %d", n)
+	}
+	if len(h.dialed) != len(peers) {
+		t.Fatalf("expected %d dials, got %d", len(peers), len(h.dialed))
+	}
+	if h.maxDials != 3 {
+		t.Fatalf("expected at most 3 concurrent dials, got %d", h.maxDials)
+	}
+
+	// the dials stop once enough peers are connected
+	h = newFakeHost(t, clock.New(), func(int) error { return nil })
+	if n := peersConnect(context.Background(), h, peers, 2, 1, true); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+	if len(h.dialed) != 2 {
+		t.Fatalf("expected 2 dials, got %d", len(h.dialed))
+	}
+}
+
+func TestBootstrapRoundSampling(t *testing.T) {
+	h := newFakeHost(t, clock.New(), func(int) error { return errDialFailed })
+
+	peers := randPeers(t, 10)
+	cfg := BootstrapConfigWithPeers(peers)
+	cfg.MinPeerThreshold = 10
+	cfg.SampleSize = 3
+
+	sampled := make(map[peer.ID]struct{})
+	for round := 0; round < 50; round++ {
+		h.dialed = nil
+		if err := bootstrapRound(context.Background(), h, cfg); err != ErrNotEnoughBootstrapPeers {
+			t.Fatalf("expected ErrNotEnoughBootstrapPeers, got %v", err)
+		}
+		if len(h.dialed) != 3 {
+			t.Fatalf("expected 3 dials, got %d", len(h.dialed))
+		}
+		inRound := make(map[peer.ID]struct{})
+		for _, p := range h.dialed {
+			if _, ok := inRound[p]; ok {
+				t.Fatalf("%s dialed twice in a round", p)
+			}
+			inRound[p] = struct{}{}
+			sampled[p] = struct{}{}
+		}
+	}
+	if len(sampled) != len(peers) {
+		t.Fatalf("expected all the peers to be sampled, got %d of %d", len(sampled), len(peers))
+	}
+}
+
+func TestSelectBackupPeers(t *testing.T) {
+	peers := randPeers(t, 6)
+	now := time.Now()
+	candidates := []backupCandidate{
+		{info: peers[0], opened: now.Add(-time.Minute), prefix: "10.1.0.0/16"},
+		{info: peers[1], opened: now.Add(-time.Hour), prefix: "10.1.0.0/16"},
+		{info: peers[2], opened: now.Add(-2 * time.Hour), prefix: "10.1.0.0/16"},
+		{info: peers[3], opened: now.Add(-time.Second), prefix: "10.2.0.0/16"},
+		{info: peers[4], prefix: "10.3.0.0/16"},
+		{info: peers[5], opened: now.Add(-3 * time.Second)},
+	}
+
+	// the longest connected peer of each prefix first, then the others by
+	// connection duration
+	for n, expected := range map[int][]peer.AddrInfo{
+		2: {peers[2], peers[5]},
+		4: {peers[2], peers[5], peers[3], peers[4]},
+		6: {peers[2], peers[5], peers[3], peers[4], peers[1], peers[0]},
+		8: {peers[2], peers[5], peers[3], peers[4], peers[1], peers[0]},
+	} {
+		if picked := selectBackupPeers(candidates, n); !reflect.DeepEqual(picked, expected) {
+			t.Fatalf("%d peers: expected %v, got %v", n, expected, picked)
+		}
+	}
+}
+
+func TestIPPrefix(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"/ip4/10.1.2.3/tcp/4001":                "10.1.0.0/16",
+		"/ip6/2001:db8:1:2::1/udp/4001/quic-v1": "2001:db8::/32",
+		"/dns4/example.com/tcp/4001":            "",
+	} {
+		if prefix := ipPrefix(ma.StringCast(addr)); prefix != expected {
+			t.Fatalf("%s: expected the prefix %q, got %q", addr, expected, prefix)
+		}
+	}
+}
+
+// newMockPeer adds a peer at addr to mn.
+func newMockPeer(t *testing.T, mn mocknet.Mocknet, addr string) host.Host {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := mn.AddPeer(sk, ma.StringCast(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func mockAddrInfo(h host.Host) peer.AddrInfo {
+	return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+}
+
+func TestBackupPeersRecovery(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	node := newMockPeer(t, mn, "/ip4/10.0.0.1/tcp/4001")
+
+	// the bootstrap peers are down: they are not linked to the node
+	bootstrappers := []host.Host{
+		newMockPeer(t, mn, "/ip4/10.9.0.1/tcp/4001"),
+		newMockPeer(t, mn, "/ip4/10.9.0.2/tcp/4001"),
+	}
+	backups := []host.Host{
+		newMockPeer(t, mn, "/ip4/10.1.0.1/tcp/4001"),
+		newMockPeer(t, mn, "/ip4/10.2.0.1/tcp/4001"),
+	}
+	for _, b := range backups {
+		if _, err := mn.LinkPeers(node.ID(), b.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	cfg := BootstrapConfigWithPeers(
+		[]peer.AddrInfo{mockAddrInfo(bootstrappers[0]), mockAddrInfo(bootstrappers[1])},
+		WithBackupPeersDatastore(d),
+	)
+	cfg.MinPeerThreshold = 2
+	load, save := cfg.BackupPeers()
+	save(context.Background(), []peer.AddrInfo{mockAddrInfo(backups[0]), mockAddrInfo(backups[1])})
+
+	bootstrapper, err := Bootstrap(node.ID(), node, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bootstrapper.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, b := range backups {
+		for node.Network().Connectedness(b.ID()) != network.Connected {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the connections to the backup peers")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the connected peers are saved in turn, the bootstrap peers excluded
+	if err := saveConnectedPeersAsTemporaryBootstrap(context.Background(), node, cfg); err != nil {
+		t.Fatal(err)
+	}
+	saved := load(context.Background())
+	if len(saved) != 2 {
+		t.Fatalf("expected 2 saved peers, got %v", saved)
+	}
+	for _, p := range saved {
+		if p.ID != backups[0].ID() && p.ID != backups[1].ID() {
+			t.Fatalf("unexpected saved peer %s", p.ID)
+		}
+		if len(p.Addrs) == 0 {
+			t.Fatalf("expected the addresses of %s to be saved", p.ID)
+		}
+	}
+}
+
+func TestSaveBackupPeersDiversity(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	node := newMockPeer(t, mn, "/ip4/10.0.0.1/tcp/4001")
+	var peers []host.Host
+	for _, addr := range []string{
+		"/ip4/10.1.0.1/tcp/4001",
+		"/ip4/10.1.0.2/tcp/4001",
+		"/ip4/10.1.0.3/tcp/4001",
+		"/ip4/10.2.0.1/tcp/4001",
+		"/ip4/10.3.0.1/tcp/4001",
+	} {
+		peers = append(peers, newMockPeer(t, mn, addr))
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range peers {
+		if _, err := mn.ConnectPeers(node.ID(), p.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	cfg := BootstrapConfigWithPeers([]peer.AddrInfo{mockAddrInfo(peers[4])}, WithBackupPeersDatastore(d))
+	cfg.MaxBackupBootstrapSize = 3
+	if err := saveConnectedPeersAsTemporaryBootstrap(context.Background(), node, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// one peer of 10.1 and the one of 10.2 are saved before another one of
+	// 10.1, the bootstrap peer of 10.3 is not saved
+	load, _ := cfg.BackupPeers()
+	saved := load(context.Background())
+	if len(saved) != 3 {
+		t.Fatalf("expected 3 saved peers, got %v", saved)
+	}
+	var in10_1, in10_2 int
+	for _, p := range saved {
+		switch p.ID {
+		case peers[0].ID(), peers[1].ID(), peers[2].ID():
+			in10_1++
+		case peers[3].ID():
+			in10_2++
+		default:
+			t.Fatalf("unexpected saved peer %s", p.ID)
+		}
+	}
+	if in10_1 != 2 || in10_2 != 1 {
+		t.Fatalf("expected 2 peers of 10.1 and 1 of 10.2, got %d and %d", in10_1, in10_2)
+	}
+	if saved[2].ID == peers[3].ID() {
+		t.Fatal("expected the peer of 10.2 to be saved before the second one of 10.1")
+	}
+}
@@ -1,6 +1,7 @@
 package chunk
 
 import (
+	"errors"
 	"io"
 	"math/bits"
 
@@ -11,21 +12,75 @@ const (
 	buzMin  = 128 << 10
 	buzMax  = 512 << 10
 	buzMask = 1<<17 - 1
+
+	// BuzhashWindowSize is the size of the window of the rolling hash of
+	// Buzhash, and so its smallest minimum chunk size.
+	BuzhashWindowSize = 32
 )
 
 type Buzhash struct {
-	r   io.Reader
-	buf []byte
-	n   int
+	r      io.Reader
+	buf    []byte
+	n      int
+	offset uint64
+
+	min  int
+	mask uint32
 
 	err error
 }
 
+// NewBuzhash returns a Buzhash splitter with chunks of 128KiB to 512KiB, and
+// of 256KiB on average.
 func NewBuzhash(r io.Reader) *Buzhash {
 	return &Buzhash{
-		r:   r,
-		buf: pool.Get(buzMax),
+		r:    r,
+		buf:  pool.Get(buzMax),
+		min:  buzMin,
+		mask: buzMask,
+	}
+}
+
+// NewBuzhashWithParams returns a Buzhash splitter which produces chunks of at
+// least minSize and at most maxSize bytes, aiming at avgSize bytes. The
+// parameters must satisfy BuzhashWindowSize <= minSize < avgSize < maxSize <=
+// ChunkSizeLimit.
+//
+// As the boundaries are only looked for past minSize, the mask of the
+// boundaries is the one of the power of two nearest to avgSize - minSize: the
+// sizes of NewBuzhash are 128KiB, 256KiB and 512KiB. The average is lower when
+// maxSize is not far enough above avgSize, as more chunks are cut at maxSize.
+func NewBuzhashWithParams(r io.Reader, minSize, avgSize, maxSize uint32) (*Buzhash, error) {
+	if err := validateBuzhash(int64(minSize), int64(avgSize), int64(maxSize)); err != nil {
+		return nil, err
+	}
+
+	// log2(avgSize - minSize) rounded to the nearest integer
+	span := avgSize - minSize
+	maskBits := bits.Len32(span) - 1
+	if uint64(span)-1<<maskBits > 1<<(maskBits+1)-uint64(span) {
+		maskBits++
+	}
+
+	return &Buzhash{
+		r:    r,
+		buf:  pool.Get(int(maxSize)),
+		min:  int(minSize),
+		mask: uint32(1<<maskBits - 1),
+	}, nil
+}
+
+func validateBuzhash(min, avg, max int64) error {
+	if min < BuzhashWindowSize {
+		return ErrBuzhashMin
+	} else if min >= avg {
+		return errors.New("incorrect format: buzhash-min must be smaller than buzhash-avg")
+	} else if avg >= max {
+		return errors.New("incorrect format: buzhash-avg must be smaller than buzhash-max")
+	} else if max > int64(ChunkSizeLimit) {
+		return ErrSizeMax
 	}
+	return nil
 }
 
 func (b *Buzhash) Reader() io.Reader {
@@ -41,7 +96,8 @@ func (b *Buzhash) NextBytes() ([]byte, error) {
 	if err != nil {
 		if err == io.ErrUnexpectedEOF || err == io.EOF {
 			buffered := b.n + n
-			if buffered < buzMin {
+			// no boundary can be found within min bytes
+			if buffered <= b.min {
 				b.err = io.EOF
 				// Read nothing? Don't return an empty block.
 				if buffered == 0 {
@@ -54,6 +110,7 @@ func (b *Buzhash) NextBytes() ([]byte, error) {
 
 				pool.Put(b.buf)
 				b.buf = nil
+				b.offset += uint64(buffered)
 				return res, nil
 			}
 		} else {
@@ -64,15 +121,15 @@ func (b *Buzhash) NextBytes() ([]byte, error) {
 		}
 	}
 
-	i := buzMin - 32
+	i := b.min - 32
 
 	var state uint32 = 0
 
-	if buzMin > len(b.buf) {
+	if b.min > len(b.buf) {
 		panic("this is impossible")
 	}
 
-	for ; i < buzMin; i++ {
+	for ; i < b.min; i++ {
 		state = bits.RotateLeft32(state, 1)
 		state = state ^ bytehash[b.buf[i]]
 	}
@@ -82,12 +139,13 @@ func (b *Buzhash) NextBytes() ([]byte, error) {
 
 		buf := b.buf
 		bufshf := b.buf[32:]
-		i = buzMin - 32
+		mask := b.mask
+		i = b.min - 32
 		_ = buf[max]
 		_ = bufshf[max]
 
 		for ; i <= max; i++ {
-			if state&buzMask == 0 {
+			if state&mask == 0 {
 				break
 			}
 			state = bits.RotateLeft32(state, 1) ^
@@ -101,10 +159,18 @@ func (b *Buzhash) NextBytes() ([]byte, error) {
 	copy(res, b.buf)
 
 	b.n = copy(b.buf, b.buf[i:b.n+n])
+	b.offset += uint64(i)
 
 	return res, nil
 }
 
+// NextBytesAt returns the next chunk as NextBytes, with its offset.
+func (b *Buzhash) NextBytesAt() ([]byte, uint64, error) {
+	offset := b.offset
+	data, err := b.NextBytes()
+	return data, offset, err
+}
+
 var bytehash = [256]uint32{
 	0x6236e7d5, 0x10279b0b, 0x72818182, 0xdc526514, 0x2fd41e3d, 0x777ef8c8,
 	0x83ee5285, 0x2c8f3637, 0x2f049c1a, 0x57df9791, 0x9207151f, 0x9b544818,
@@ -2,6 +2,7 @@ package chunk
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"testing"
 
@@ -60,6 +61,109 @@ func TestBuzhashChunking(t *testing.T) {
 	t.Logf("average block size: %d\n", len(buf)/count)
 }
 
+func TestBuzhashGolden(t *testing.T) {
+	expected := []int{
+		524288, 316891, 221608, 403453, 198694, 524288, 197762, 386725, 319161,
+		273101, 318018, 448346, 61969,
+	}
+
+	data := xorshiftData(4<<20, 1)
+	params, err := NewBuzhashWithParams(bytes.NewReader(data), buzMin, 256<<10, buzMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default parameters keep the chunks of NewBuzhash
+	for _, s := range []Splitter{NewBuzhash(bytes.NewReader(data)), params} {
+		var lengths []int
+		for {
+			chunk, err := s.NextBytes()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			lengths = append(lengths, len(chunk))
+		}
+		if fmt.Sprint(lengths) != fmt.Sprint(expected) {
+			t.Fatalf("expected the chunks %v, got %v", expected, lengths)
+		}
+	}
+}
+
+func TestBuzhashWithParams(t *testing.T) {
+	// the same data for each run, for the averages not to be flaky
+	data := xorshiftData(1024*1024*32, 1)
+	for _, sizes := range [][3]uint32{
+		{32, 32 + 4096, 64 << 10},
+		{16 << 10, 48 << 10, 256 << 10},
+		{64 << 10, 192 << 10, 1 << 20},
+		{128 << 10, 384 << 10, 1 << 20},
+	} {
+		min, avg, max := sizes[0], sizes[1], sizes[2]
+		b, err := NewBuzhashWithParams(bytes.NewReader(data), min, avg, max)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var chunks [][]byte
+		for {
+			chunk, err := b.NextBytes()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			chunks = append(chunks, chunk)
+		}
+		for i, chunk := range chunks {
+			if len(chunk) > int(max) {
+				t.Fatalf("%v: chunk %d/%d is more than the maximum size", sizes, i+1, len(chunks))
+			}
+			if i < len(chunks)-1 && len(chunk) < int(min) {
+				t.Fatalf("%v: chunk %d/%d is less than the minimum size", sizes, i+1, len(chunks))
+			}
+		}
+		if !bytes.Equal(bytes.Join(chunks, nil), data) {
+			t.Fatalf("%v: data was chunked incorrectly", sizes)
+		}
+
+		average := len(data) / len(chunks)
+		if average < int(avg)*85/100 || average > int(avg)*115/100 {
+			t.Errorf("%v: average block size %d is not within 15%% of %d", sizes, average, avg)
+		}
+		t.Logf("%v: average block size %d", sizes, average)
+	}
+}
+
+func TestBuzhashWithParamsBounds(t *testing.T) {
+	r := bytes.NewReader(nil)
+	for _, sizes := range [][3]uint32{
+		{0, 256, 1024},
+		{BuzhashWindowSize - 1, 256, 1024},
+		{256, 256, 1024},
+		{64, 1024, 1024},
+		{64, 256, uint32(ChunkSizeLimit) + 1},
+	} {
+		if _, err := NewBuzhashWithParams(r, sizes[0], sizes[1], sizes[2]); err == nil {
+			t.Errorf("expected an error for the sizes %v", sizes)
+		}
+	}
+	if _, err := NewBuzhashWithParams(r, BuzhashWindowSize, BuzhashWindowSize+1, uint32(ChunkSizeLimit)); err != nil {
+		t.Errorf("expected success, got: %#v", err)
+	}
+
+	// a chunk of exactly min bytes
+	data := randBuf(t, BuzhashWindowSize)
+	b, err := NewBuzhashWithParams(bytes.NewReader(data), BuzhashWindowSize, 64, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := b.NextBytes()
+	if err != nil || !bytes.Equal(chunk, data) {
+		t.Fatalf("expected the whole data, got %d bytes and %v", len(chunk), err)
+	}
+}
+
 func TestBuzhashChunkReuse(t *testing.T) {
 	newBuzhash := func(r io.Reader) Splitter {
 		return NewBuzhash(r)
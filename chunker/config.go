@@ -0,0 +1,237 @@
+package chunk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/whyrusleeping/chunker"
+)
+
+// Kind is the kind of splitter of a [Config].
+type Kind int
+
+const (
+	// KindDefault is the splitter of [DefaultSplitter].
+	KindDefault Kind = iota
+	// KindSize is the splitter of [NewSizeSplitter].
+	KindSize
+	// KindRabin is the splitter of [NewRabin] and [NewRabinMinMax].
+	KindRabin
+	// KindBuzhash is the splitter of [NewBuzhash] and [NewBuzhashWithParams].
+	KindBuzhash
+	// KindFastCDC is the splitter of [NewFastCDC].
+	KindFastCDC
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindDefault:
+		return "default"
+	case KindSize:
+		return "size"
+	case KindRabin:
+		return "rabin"
+	case KindBuzhash:
+		return "buzhash"
+	case KindFastCDC:
+		return "fastcdc"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Config is the configuration of a splitter, as parsed by [ParseString] and
+// used by [FromConfig]. The zero Config is the one of [DefaultSplitter].
+type Config struct {
+	Kind Kind
+
+	// Size is the size of the chunks of KindSize.
+	Size int
+
+	// MinSize, AvgSize and MaxSize are the sizes of the chunks of KindRabin,
+	// KindBuzhash and KindFastCDC. For KindRabin, MinSize and MaxSize may be zero to be
+	// derived from AvgSize, as [NewRabin] does. Zero sizes are the defaults.
+	MinSize int
+	AvgSize int
+	MaxSize int
+
+	// Polynomial is the irreducible polynomial of degree 53 of KindRabin, or
+	// zero for IpfsRabinPoly.
+	Polynomial uint64
+}
+
+// Validate returns an error if the parameters of c are not the ones of a
+// valid splitter of its kind.
+func (c Config) Validate() error {
+	switch c.Kind {
+	case KindDefault:
+		return c.checkUnused(c.Size != 0, c.MinSize != 0, c.AvgSize != 0, c.MaxSize != 0, c.Polynomial != 0)
+
+	case KindSize:
+		if err := c.checkUnused(false, c.MinSize != 0, c.AvgSize != 0, c.MaxSize != 0, c.Polynomial != 0); err != nil {
+			return err
+		}
+		if c.Size <= 0 {
+			return ErrSize
+		} else if c.Size > ChunkSizeLimit {
+			return ErrSizeMax
+		}
+		return nil
+
+	case KindRabin:
+		if err := c.checkUnused(c.Size != 0, false, false, false, false); err != nil {
+			return err
+		}
+		if c.Polynomial != 0 {
+			if pol := chunker.Pol(c.Polynomial); pol.Deg() != 53 || !pol.Irreducible() {
+				return errors.New("rabin polynomial must be an irreducible polynomial of degree 53")
+			}
+		}
+		if c.MinSize == 0 && c.MaxSize == 0 {
+			if c.AvgSize < 0 {
+				return ErrSize
+			} else if int(float32(c.AvgSize)*1.5) > ChunkSizeLimit { // FIXME - this will be addressed in a subsequent PR
+				return ErrSizeMax
+			}
+			return nil
+		}
+		if c.MinSize < 16 {
+			return ErrRabinMin
+		} else if c.MinSize >= c.AvgSize {
+			return errors.New("incorrect format: rabin-min must be smaller than rabin-avg")
+		} else if c.AvgSize >= c.MaxSize {
+			return errors.New("incorrect format: rabin-avg must be smaller than rabin-max")
+		} else if c.MaxSize > ChunkSizeLimit {
+			return ErrSizeMax
+		}
+		return nil
+
+	case KindBuzhash:
+		if err := c.checkUnused(c.Size != 0, false, false, false, c.Polynomial != 0); err != nil {
+			return err
+		}
+		if c.MinSize == 0 && c.AvgSize == 0 && c.MaxSize == 0 {
+			return nil
+		}
+		return validateBuzhash(int64(c.MinSize), int64(c.AvgSize), int64(c.MaxSize))
+
+	case KindFastCDC:
+		if err := c.checkUnused(c.Size != 0, false, false, false, c.Polynomial != 0); err != nil {
+			return err
+		}
+		if c.MinSize == 0 && c.AvgSize == 0 && c.MaxSize == 0 {
+			return nil
+		}
+		return validateFastCDC(int64(c.MinSize), int64(c.AvgSize), int64(c.MaxSize))
+
+	default:
+		return fmt.Errorf("unrecognized chunker kind: %s", c.Kind)
+	}
+}
+
+// checkUnused returns an error if one of the parameters which are not used by
+// the kind of c is set.
+func (c Config) checkUnused(size, minSize, avgSize, maxSize, polynomial bool) error {
+	for _, param := range []struct {
+		set  bool
+		name string
+	}{
+		{size, "size"},
+		{minSize, "min size"},
+		{avgSize, "avg size"},
+		{maxSize, "max size"},
+		{polynomial, "polynomial"},
+	} {
+		if param.set {
+			return fmt.Errorf("incorrect format: the %s chunker has no %s", c.Kind, param.name)
+		}
+	}
+	return nil
+}
+
+// String returns the string of c, as parsed by [ParseString] and [FromString].
+func (c Config) String() string {
+	switch c.Kind {
+	case KindDefault:
+		return "default"
+	case KindSize:
+		return fmt.Sprintf("size-%d", c.Size)
+	case KindRabin:
+		var s string
+		switch {
+		case c.MinSize != 0 || c.MaxSize != 0:
+			s = fmt.Sprintf("rabin-%d-%d-%d", c.MinSize, c.AvgSize, c.MaxSize)
+		case c.AvgSize != 0:
+			s = fmt.Sprintf("rabin-%d", c.AvgSize)
+		default:
+			s = "rabin"
+		}
+		if c.Polynomial != 0 {
+			s += fmt.Sprintf("-poly:%#x", c.Polynomial)
+		}
+		return s
+	case KindBuzhash:
+		if c.MinSize == 0 && c.AvgSize == 0 && c.MaxSize == 0 {
+			return "buzhash"
+		}
+		return fmt.Sprintf("buzhash-%d-%d-%d", c.MinSize, c.AvgSize, c.MaxSize)
+	case KindFastCDC:
+		if c.MinSize == 0 && c.AvgSize == 0 && c.MaxSize == 0 {
+			return "fastcdc"
+		}
+		return fmt.Sprintf("fastcdc-%d-%d-%d", c.MinSize, c.AvgSize, c.MaxSize)
+	default:
+		return c.Kind.String()
+	}
+}
+
+// FromConfig returns the Splitter of c, once validated.
+func FromConfig(r io.Reader, c Config) (Splitter, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.Kind {
+	case KindSize:
+		return NewSizeSplitter(r, int64(c.Size)), nil
+
+	case KindRabin:
+		pol := IpfsRabinPoly
+		if c.Polynomial != 0 {
+			pol = chunker.Pol(c.Polynomial)
+		}
+		if c.MinSize == 0 && c.MaxSize == 0 {
+			avg := uint64(c.AvgSize)
+			if avg == 0 {
+				avg = uint64(DefaultBlockSize)
+			}
+			return newRabin(r, pol, avg/3, avg, avg+(avg/2)), nil
+		}
+		return newRabin(r, pol, uint64(c.MinSize), uint64(c.AvgSize), uint64(c.MaxSize)), nil
+
+	case KindBuzhash:
+		if c.MinSize == 0 && c.AvgSize == 0 && c.MaxSize == 0 {
+			return NewBuzhash(r), nil
+		}
+		b, err := NewBuzhashWithParams(r, uint32(c.MinSize), uint32(c.AvgSize), uint32(c.MaxSize))
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+
+	case KindFastCDC:
+		min, avg, max := c.MinSize, c.AvgSize, c.MaxSize
+		if min == 0 && avg == 0 && max == 0 {
+			min, avg, max = int(DefaultBlockSize/4), int(DefaultBlockSize), ChunkSizeLimit
+		}
+		f, err := NewFastCDC(r, uint32(min), uint32(avg), uint32(max))
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+
+	default:
+		return DefaultSplitter(r), nil
+	}
+}
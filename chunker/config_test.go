@@ -0,0 +1,155 @@
+package chunk
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	testCases := []struct {
+		str      string
+		config   Config
+		splitter Splitter
+	}{
+		{"default", Config{}, &sizeSplitterv2{}},
+		{"size-32", Config{Kind: KindSize, Size: 32}, &sizeSplitterv2{}},
+		{"rabin", Config{Kind: KindRabin}, &Rabin{}},
+		{"rabin-65536", Config{Kind: KindRabin, AvgSize: 65536}, &Rabin{}},
+		{"rabin-18-25-32", Config{Kind: KindRabin, MinSize: 18, AvgSize: 25, MaxSize: 32}, &Rabin{}},
+		{"rabin-18-25-32-poly:0x3df305dfb2a805", Config{Kind: KindRabin, MinSize: 18, AvgSize: 25, MaxSize: 32, Polynomial: uint64(IpfsRabinPoly)}, &Rabin{}},
+		{"rabin-poly:0x3df305dfb2a805", Config{Kind: KindRabin, Polynomial: uint64(IpfsRabinPoly)}, &Rabin{}},
+		{"buzhash", Config{Kind: KindBuzhash}, &Buzhash{}},
+		{"buzhash-32-256-1024", Config{Kind: KindBuzhash, MinSize: 32, AvgSize: 256, MaxSize: 1024}, &Buzhash{}},
+		{"fastcdc", Config{Kind: KindFastCDC}, &FastCDC{}},
+		{"fastcdc-64-256-1024", Config{Kind: KindFastCDC, MinSize: 64, AvgSize: 256, MaxSize: 1024}, &FastCDC{}},
+	}
+
+	for _, tc := range testCases {
+		c, err := ParseString(tc.str)
+		if err != nil {
+			t.Fatalf("Expected success for %q, got: %#v", tc.str, err)
+		}
+		if c != tc.config {
+			t.Fatalf("Expected the config %#v for %q, got: %#v", tc.config, tc.str, c)
+		}
+		if c.String() != tc.str {
+			t.Fatalf("Expected the string %q, got: %q", tc.str, c.String())
+		}
+
+		s, err := FromConfig(bytes.NewReader(nil), c)
+		if err != nil {
+			t.Fatalf("Expected success for %q, got: %#v", tc.str, err)
+		}
+		if fmt.Sprintf("%T", s) != fmt.Sprintf("%T", tc.splitter) {
+			t.Fatalf("Expected a %T splitter for %q, got: %T", tc.splitter, tc.str, s)
+		}
+	}
+
+	// the other strings of the configs are parsed to the same configs
+	for str, expected := range map[string]string{
+		"":                                      "default",
+		"rabin-min:18-avg:25-max:32":            "rabin-18-25-32",
+		"rabin-18-25-32-poly:17437180132763653": "rabin-18-25-32-poly:0x3df305dfb2a805",
+	} {
+		c, err := ParseString(str)
+		if err != nil {
+			t.Fatalf("Expected success for %q, got: %#v", str, err)
+		}
+		if c.String() != expected {
+			t.Fatalf("Expected the string %q for %q, got: %q", expected, str, c.String())
+		}
+	}
+}
+
+func TestConfigChunks(t *testing.T) {
+	data := randBuf(t, 1<<20)
+	for _, str := range []string{"default", "size-4096", "rabin-4096", "rabin-1024-4096-16384", "buzhash", "buzhash-1024-4096-16384", "fastcdc-1024-4096-16384"} {
+		c, err := ParseString(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromString, err := FromString(bytes.NewReader(data), str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromConfig, err := FromConfig(bytes.NewReader(data), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chunksA, _ := Chan(fromString)
+		chunksB, _ := Chan(fromConfig)
+		for n := 0; ; n++ {
+			a, moreA := <-chunksA
+			b, moreB := <-chunksB
+			if moreA != moreB {
+				t.Fatalf("%s: the chunks of the string and the config differ in number", str)
+			}
+			if !moreA {
+				break
+			}
+			if !bytes.Equal(a, b) {
+				t.Fatalf("%s: chunk %d not equal", str, n)
+			}
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		config Config
+		err    string
+	}{
+		{Config{Kind: KindSize}, ErrSize.Error()},
+		{Config{Kind: KindSize, Size: -1}, ErrSize.Error()},
+		{Config{Kind: KindSize, Size: ChunkSizeLimit + 1}, ErrSizeMax.Error()},
+		{Config{Kind: KindRabin, MinSize: 15, AvgSize: 23, MaxSize: 31}, ErrRabinMin.Error()},
+		{Config{Kind: KindRabin, MinSize: 20, AvgSize: 20, MaxSize: 21}, "incorrect format: rabin-min must be smaller than rabin-avg"},
+		{Config{Kind: KindRabin, MinSize: 19, AvgSize: 21, MaxSize: 21}, "incorrect format: rabin-avg must be smaller than rabin-max"},
+		{Config{Kind: KindRabin, MinSize: 19, AvgSize: 21, MaxSize: ChunkSizeLimit + 1}, ErrSizeMax.Error()},
+		{Config{Kind: KindRabin, AvgSize: ChunkSizeLimit}, ErrSizeMax.Error()},
+		{Config{Kind: KindRabin, Polynomial: 1<<53 | 1<<1}, "rabin polynomial must be an irreducible polynomial of degree 53"},
+		{Config{Kind: KindRabin, Polynomial: 0x13}, "rabin polynomial must be an irreducible polynomial of degree 53"},
+		{Config{Kind: KindFastCDC, MinSize: 63, AvgSize: 256, MaxSize: 1024}, ErrFastCDCMin.Error()},
+		{Config{Kind: KindFastCDC, MinSize: 256, AvgSize: 256, MaxSize: 1024}, "incorrect format: fastcdc-min must be smaller than fastcdc-avg"},
+		{Config{Kind: KindFastCDC, MinSize: 64, AvgSize: 1024, MaxSize: 1024}, "incorrect format: fastcdc-avg must be smaller than fastcdc-max"},
+		{Config{Kind: KindFastCDC, MinSize: 64, AvgSize: 256, MaxSize: ChunkSizeLimit + 1}, ErrSizeMax.Error()},
+		{Config{Kind: KindFastCDC, AvgSize: 256}, ErrFastCDCMin.Error()},
+		{Config{Size: 32}, "incorrect format: the default chunker has no size"},
+		{Config{Kind: KindBuzhash, Size: 32}, "incorrect format: the buzhash chunker has no size"},
+		{Config{Kind: KindBuzhash, MinSize: 31, AvgSize: 256, MaxSize: 1024}, ErrBuzhashMin.Error()},
+		{Config{Kind: KindBuzhash, MinSize: 256, AvgSize: 256, MaxSize: 1024}, "incorrect format: buzhash-min must be smaller than buzhash-avg"},
+		{Config{Kind: KindBuzhash, MinSize: 64, AvgSize: 1024, MaxSize: 1024}, "incorrect format: buzhash-avg must be smaller than buzhash-max"},
+		{Config{Kind: KindBuzhash, MinSize: 64, AvgSize: 256, MaxSize: ChunkSizeLimit + 1}, ErrSizeMax.Error()},
+		{Config{Kind: KindSize, Size: 32, MinSize: 16}, "incorrect format: the size chunker has no min size"},
+		{Config{Kind: KindFastCDC, Polynomial: uint64(IpfsRabinPoly)}, "incorrect format: the fastcdc chunker has no polynomial"},
+		{Config{Kind: Kind(42)}, "unrecognized chunker kind: Kind(42)"},
+	}
+
+	for _, tc := range testCases {
+		err := tc.config.Validate()
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("Expected the error %q for %#v, got: %v", tc.err, tc.config, err)
+		}
+		if _, err := FromConfig(bytes.NewReader(nil), tc.config); err == nil || err.Error() != tc.err {
+			t.Errorf("Expected the error %q from FromConfig for %#v, got: %v", tc.err, tc.config, err)
+		}
+	}
+}
+
+func TestParseStringErrors(t *testing.T) {
+	for str, expected := range map[string]string{
+		"unknown":               "unrecognized chunker option: unknown",
+		"rabin-0":               ErrSize.Error(),
+		"rabin-1-2":             "incorrect format (expected 'rabin' 'rabin-[avg]' or 'rabin-[min]-[avg]-[max]'",
+		"rabin-avg:18-25-32":    "first label must be min",
+		"rabin-18-25-32-poly:0": "rabin polynomial must be an irreducible polynomial of degree 53",
+		"fastcdc-64-256":        "incorrect format (expected 'fastcdc' or 'fastcdc-[min]-[avg]-[max]'",
+		"buzhash-64":            "incorrect format (expected 'buzhash' or 'buzhash-[min]-[avg]-[max]'",
+	} {
+		if _, err := ParseString(str); err == nil || err.Error() != expected {
+			t.Errorf("Expected the error %q for %q, got: %v", expected, str, err)
+		}
+	}
+}
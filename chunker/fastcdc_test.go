@@ -0,0 +1,170 @@
+package chunk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// xorshiftData returns size pseudo-random bytes from a xorshift generator,
+// which does not depend on the implementation of math/rand.
+func xorshiftData(size int, seed uint64) []byte {
+	data := make([]byte, size)
+	x := seed
+	for i := range data {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		data[i] = byte(x >> 56)
+	}
+	return data
+}
+
+// fastCDCChunks splits data with FastCDC and checks that the chunks are within
+// the sizes and make up data.
+func fastCDCChunks(t testing.TB, r io.Reader, data []byte, min, avg, max uint32) []int {
+	f, err := NewFastCDC(r, min, avg, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks [][]byte
+	for {
+		chunk, err := f.NextBytes()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	lengths := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		lengths[i] = len(chunk)
+		if len(chunk) == 0 {
+			t.Fatalf("chunk %d/%d is empty", i+1, len(chunks))
+		}
+		if len(chunk) > int(max) {
+			t.Fatalf("chunk %d/%d is more than the maximum size: %d", i+1, len(chunks), len(chunk))
+		}
+		if i < len(chunks)-1 && len(chunk) < int(min) {
+			t.Fatalf("chunk %d/%d is less than the minimum size: %d", i+1, len(chunks), len(chunk))
+		}
+	}
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("data was chunked incorrectly")
+	}
+	return lengths
+}
+
+func TestFastCDCGolden(t *testing.T) {
+	testCases := []struct {
+		size          int
+		min, avg, max uint32
+		lengths       []int
+	}{
+		{0, 64, 256, 1024, []int{}},
+		{100, 64, 256, 1024, []int{100}},
+		{1 << 14, 64, 256, 1024, []int{
+			295, 416, 304, 309, 277, 266, 142, 308, 506, 104, 355, 283, 285, 372,
+			276, 266, 423, 318, 284, 566, 244, 263, 435, 585, 160, 288, 301, 280,
+			235, 302, 291, 265, 91, 300, 285, 314, 297, 314, 121, 500, 264, 314,
+			301, 281, 323, 355, 259, 376, 87, 263, 401, 229, 278, 268, 159,
+		}},
+		{1 << 16, 1024, 4096, 16384, []int{
+			6599, 5923, 2624, 5406, 4668, 5899, 4114, 4126, 4229, 5555, 4439, 4340,
+			4662, 2952,
+		}},
+		// the parameters of "fastcdc"
+		{4 << 20, 64 << 10, 256 << 10, 1 << 20, []int{
+			306908, 275816, 296530, 361891, 281066, 300101, 445893, 272320, 287930,
+			275627, 284593, 228462, 384461, 192706,
+		}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%d-%d-%d-%d", tc.size, tc.min, tc.avg, tc.max), func(t *testing.T) {
+			data := xorshiftData(tc.size, 1)
+			lengths := fastCDCChunks(t, bytes.NewReader(data), data, tc.min, tc.avg, tc.max)
+			if fmt.Sprint(lengths) != fmt.Sprint(tc.lengths) {
+				t.Fatalf("expected the chunks %v, got %v", tc.lengths, lengths)
+			}
+
+			// the chunks do not depend on the reads
+			lengths = fastCDCChunks(t, iotest.OneByteReader(bytes.NewReader(data)), data, tc.min, tc.avg, tc.max)
+			if fmt.Sprint(lengths) != fmt.Sprint(tc.lengths) {
+				t.Fatalf("expected the chunks %v with one byte reads, got %v", tc.lengths, lengths)
+			}
+		})
+	}
+}
+
+func TestFastCDCChunking(t *testing.T) {
+	data := randBuf(t, 1024*1024*16)
+	lengths := fastCDCChunks(t, bytes.NewReader(data), data, 64<<10, 256<<10, 1<<20)
+	t.Logf("average block size: %d\n", len(data)/len(lengths))
+}
+
+func TestFastCDCChunkReuse(t *testing.T) {
+	newFastCDC := func(r io.Reader) Splitter {
+		f, err := NewFastCDC(r, 64<<10, 256<<10, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	testReuse(t, newFastCDC)
+}
+
+func TestNewFastCDCParameters(t *testing.T) {
+	r := bytes.NewReader(nil)
+	for _, sizes := range [][3]uint32{
+		{0, 256, 1024},
+		{FastCDCMinSize - 1, 256, 1024},
+		{256, 256, 1024},
+		{512, 256, 1024},
+		{64, 1024, 1024},
+		{64, 256, uint32(ChunkSizeLimit) + 1},
+	} {
+		if _, err := NewFastCDC(r, sizes[0], sizes[1], sizes[2]); err == nil {
+			t.Errorf("expected an error for the sizes %v", sizes)
+		}
+	}
+	if _, err := NewFastCDC(r, FastCDCMinSize, FastCDCMinSize+1, uint32(ChunkSizeLimit)); err != nil {
+		t.Errorf("expected success, got: %#v", err)
+	}
+}
+
+func FuzzFastCDC(f *testing.F) {
+	f.Add(xorshiftData(1<<14, 1), uint32(64), uint32(256), uint32(1024))
+	f.Add(xorshiftData(1<<12, 2), uint32(64), uint32(65), uint32(66))
+	f.Add(bytes.Repeat([]byte{0}, 1<<12), uint32(64), uint32(128), uint32(512))
+	f.Fuzz(func(t *testing.T, data []byte, min, avg, max uint32) {
+		// small sizes, so that the data spans several chunks
+		min = FastCDCMinSize + min%1024
+		avg = min + 1 + avg%4096
+		max = avg + 1 + max%8192
+
+		lengths := fastCDCChunks(t, bytes.NewReader(data), data, min, avg, max)
+		oneByte := fastCDCChunks(t, iotest.OneByteReader(bytes.NewReader(data)), data, min, avg, max)
+		if fmt.Sprint(lengths) != fmt.Sprint(oneByte) {
+			t.Fatalf("the chunks %v depend on the reads, got %v", lengths, oneByte)
+		}
+	})
+}
+
+func BenchmarkFastCDC(b *testing.B) {
+	benchmarkChunker(b, func(r io.Reader) Splitter {
+		f, err := NewFastCDC(r, 64<<10, 256<<10, 1<<20)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return f
+	})
+}
@@ -1,7 +1,8 @@
-// This file generates bytehash LUT
+// This file generates bytehash LUT, or the gear LUT of FastCDC with -gear
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
 )
@@ -9,6 +10,13 @@ import (
 const nRounds = 200
 
 func main() {
+	gear := flag.Bool("gear", false, "generate the gear LUT of FastCDC")
+	flag.Parse()
+	if *gear {
+		fmt.Printf("%#v", gearLUT())
+		return
+	}
+
 	rnd := rand.New(rand.NewSource(0))
 
 	lut := make([]uint32, 256)
@@ -31,3 +39,18 @@ func main() {
 
 	fmt.Printf("%#v", lut)
 }
+
+// gearLUT returns 256 values of the splitmix64 generator seeded with 0, which
+// does not depend on the implementation of math/rand.
+func gearLUT() []uint64 {
+	lut := make([]uint64, 256)
+	var state uint64
+	for i := range lut {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		lut[i] = z ^ (z >> 31)
+	}
+	return lut
+}
@@ -19,96 +19,152 @@ const (
 )
 
 var (
-	ErrRabinMin = errors.New("rabin min must be greater than 16")
-	ErrSize     = errors.New("chunker size must be greater than 0")
-	ErrSizeMax  = fmt.Errorf("chunker parameters may not exceed the maximum chunk size of %d", ChunkSizeLimit)
+	ErrRabinMin   = errors.New("rabin min must be greater than 16")
+	ErrBuzhashMin = fmt.Errorf("buzhash min must be at least %d", BuzhashWindowSize)
+	ErrFastCDCMin = fmt.Errorf("fastcdc min must be at least %d", FastCDCMinSize)
+	ErrSize       = errors.New("chunker size must be greater than 0")
+	ErrSizeMax    = fmt.Errorf("chunker parameters may not exceed the maximum chunk size of %d", ChunkSizeLimit)
 )
 
-// FromString returns a Splitter depending on the given string:
-// it supports "default" (""), "size-{size}", "rabin", "rabin-{blocksize}",
-// "rabin-{min}-{avg}-{max}" and "buzhash".
+// FromString returns a Splitter depending on the given string, parsed with
+// [ParseString].
 func FromString(r io.Reader, chunker string) (Splitter, error) {
+	c, err := ParseString(chunker)
+	if err != nil {
+		return nil, err
+	}
+	return FromConfig(r, c)
+}
+
+// ParseString returns the validated [Config] of the given string:
+// it supports "default" (""), "size-{size}", "rabin", "rabin-{blocksize}",
+// "rabin-{min}-{avg}-{max}", "buzhash", "buzhash-{min}-{avg}-{max}", "fastcdc"
+// and "fastcdc-{min}-{avg}-{max}".
+// The rabin strings may end with "-poly:{polynomial}". It is the inverse of
+// [Config.String].
+func ParseString(chunker string) (Config, error) {
+	var c Config
 	switch {
 	case chunker == "" || chunker == "default":
-		return DefaultSplitter(r), nil
 
 	case strings.HasPrefix(chunker, "size-"):
 		sizeStr := strings.Split(chunker, "-")[1]
 		size, err := strconv.Atoi(sizeStr)
 		if err != nil {
-			return nil, err
-		} else if size <= 0 {
-			return nil, ErrSize
-		} else if size > ChunkSizeLimit {
-			return nil, ErrSizeMax
+			return Config{}, err
 		}
-		return NewSizeSplitter(r, int64(size)), nil
+		c = Config{Kind: KindSize, Size: size}
 
 	case strings.HasPrefix(chunker, "rabin"):
-		return parseRabinString(r, chunker)
+		var err error
+		if c, err = parseRabinString(chunker); err != nil {
+			return Config{}, err
+		}
 
-	case chunker == "buzhash":
-		return NewBuzhash(r), nil
+	case chunker == "buzhash" || strings.HasPrefix(chunker, "buzhash-"):
+		var err error
+		if c, err = parseSizesString(chunker, KindBuzhash); err != nil {
+			return Config{}, err
+		}
+
+	case chunker == "fastcdc" || strings.HasPrefix(chunker, "fastcdc-"):
+		var err error
+		if c, err = parseSizesString(chunker, KindFastCDC); err != nil {
+			return Config{}, err
+		}
 
 	default:
-		return nil, fmt.Errorf("unrecognized chunker option: %s", chunker)
+		return Config{}, fmt.Errorf("unrecognized chunker option: %s", chunker)
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
 	}
+	return c, nil
 }
 
-func parseRabinString(r io.Reader, chunker string) (Splitter, error) {
+// parseSizesString parses the strings "{kind}" and "{kind}-{min}-{avg}-{max}".
+func parseSizesString(chunker string, kind Kind) (Config, error) {
 	parts := strings.Split(chunker, "-")
 	switch len(parts) {
 	case 1:
-		return NewRabin(r, uint64(DefaultBlockSize)), nil
+		return Config{Kind: kind}, nil
+	case 4:
+		var sizes [3]int
+		for i, part := range parts[1:] {
+			size, err := strconv.Atoi(part)
+			if err != nil {
+				return Config{}, err
+			}
+			sizes[i] = size
+		}
+		return Config{Kind: kind, MinSize: sizes[0], AvgSize: sizes[1], MaxSize: sizes[2]}, nil
+	default:
+		return Config{}, fmt.Errorf("incorrect format (expected '%s' or '%s-[min]-[avg]-[max]'", kind, kind)
+	}
+}
+
+func parseRabinString(chunker string) (Config, error) {
+	c := Config{Kind: KindRabin}
+	parts := strings.Split(chunker, "-")
+	if last := parts[len(parts)-1]; len(parts) > 1 && strings.HasPrefix(last, "poly:") {
+		pol, err := strconv.ParseUint(strings.TrimPrefix(last, "poly:"), 0, 64)
+		if err != nil {
+			return Config{}, err
+		}
+		if pol == 0 {
+			return Config{}, errors.New("rabin polynomial must be an irreducible polynomial of degree 53")
+		}
+		c.Polynomial = pol
+		parts = parts[:len(parts)-1]
+	}
+
+	switch len(parts) {
+	case 1:
+		return c, nil
 	case 2:
 		size, err := strconv.Atoi(parts[1])
 		if err != nil {
-			return nil, err
-		} else if int(float32(size)*1.5) > ChunkSizeLimit { // FIXME - this will be addressed in a subsequent PR
-			return nil, ErrSizeMax
+			return Config{}, err
+		} else if size <= 0 {
+			return Config{}, ErrSize
 		}
-		return NewRabin(r, uint64(size)), nil
+		c.AvgSize = size
+		return c, nil
 	case 4:
 		sub := strings.Split(parts[1], ":")
 		if len(sub) > 1 && sub[0] != "min" {
-			return nil, errors.New("first label must be min")
+			return Config{}, errors.New("first label must be min")
 		}
 		min, err := strconv.Atoi(sub[len(sub)-1])
 		if err != nil {
-			return nil, err
+			return Config{}, err
 		}
 		if min < 16 {
-			return nil, ErrRabinMin
+			return Config{}, ErrRabinMin
 		}
 		sub = strings.Split(parts[2], ":")
 		if len(sub) > 1 && sub[0] != "avg" {
 			log.Error("sub == ", sub)
-			return nil, errors.New("second label must be avg")
+			return Config{}, errors.New("second label must be avg")
 		}
 		avg, err := strconv.Atoi(sub[len(sub)-1])
 		if err != nil {
-			return nil, err
+			return Config{}, err
 		}
 
 		sub = strings.Split(parts[3], ":")
 		if len(sub) > 1 && sub[0] != "max" {
-			return nil, errors.New("final label must be max")
+			return Config{}, errors.New("final label must be max")
 		}
 		max, err := strconv.Atoi(sub[len(sub)-1])
 		if err != nil {
-			return nil, err
-		}
-
-		if min >= avg {
-			return nil, errors.New("incorrect format: rabin-min must be smaller than rabin-avg")
-		} else if avg >= max {
-			return nil, errors.New("incorrect format: rabin-avg must be smaller than rabin-max")
-		} else if max > ChunkSizeLimit {
-			return nil, ErrSizeMax
+			return Config{}, err
 		}
 
-		return NewRabinMinMax(r, uint64(min), uint64(avg), uint64(max)), nil
+		c.MinSize, c.AvgSize, c.MaxSize = min, avg, max
+		return c, nil
 	default:
-		return nil, errors.New("incorrect format (expected 'rabin' 'rabin-[avg]' or 'rabin-[min]-[avg]-[max]'")
+		return Config{}, errors.New("incorrect format (expected 'rabin' 'rabin-[avg]' or 'rabin-[min]-[avg]-[max]'")
 	}
 }
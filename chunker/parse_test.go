@@ -54,6 +54,46 @@ func TestParseRabin(t *testing.T) {
 	}
 }
 
+func TestParseFastCDC(t *testing.T) {
+	r := bytes.NewReader(randBuf(t, 1000))
+
+	for _, chunker := range []string{"fastcdc", "fastcdc-64-256-1024", fmt.Sprintf("fastcdc-64-256-%d", ChunkSizeLimit)} {
+		s, err := FromString(r, chunker)
+		if err != nil {
+			t.Fatalf("Expected success for %q, got: %#v", chunker, err)
+		}
+		if _, ok := s.(*FastCDC); !ok {
+			t.Fatalf("Expected a FastCDC splitter for %q, got: %T", chunker, s)
+		}
+	}
+
+	_, err := FromString(r, "fastcdc-63-256-1024")
+	if err != ErrFastCDCMin {
+		t.Fatalf("Expected an 'ErrFastCDCMin' error, got: %#v", err)
+	}
+
+	_, err = FromString(r, "fastcdc-256-256-1024")
+	if err == nil || err.Error() != "incorrect format: fastcdc-min must be smaller than fastcdc-avg" {
+		t.Fatalf("Expected an arg-out-of-order error, got: %#v", err)
+	}
+
+	_, err = FromString(r, "fastcdc-64-1024-1024")
+	if err == nil || err.Error() != "incorrect format: fastcdc-avg must be smaller than fastcdc-max" {
+		t.Fatalf("Expected an arg-out-of-order error, got: %#v", err)
+	}
+
+	_, err = FromString(r, fmt.Sprintf("fastcdc-64-256-%d", 1+ChunkSizeLimit))
+	if err != ErrSizeMax {
+		t.Fatalf("Expected 'ErrSizeMax', got: %#v", err)
+	}
+
+	for _, chunker := range []string{"fastcdc-64-256", "fastcdc-a-256-1024", "fastcdc--1-256-1024", "fastcdcx"} {
+		if _, err := FromString(r, chunker); err == nil {
+			t.Fatalf("Expected an error for %q", chunker)
+		}
+	}
+}
+
 func TestParseSize(t *testing.T) {
 	r := bytes.NewReader(randBuf(t, 1000))
 
@@ -0,0 +1,125 @@
+package chunk
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Pipelined is a Splitter which runs another Splitter in a goroutine, reading
+// ahead a bounded number of chunks, so that the reads of the data overlap the
+// processing of the chunks by the consumer.
+//
+// The chunks and the errors are the ones of the underlying Splitter, in the
+// same order: once it returns an error, io.EOF included, the next calls are
+// passed to it directly. The read ahead is stopped by Close, or once the
+// Pipelined is not referenced anymore.
+//
+// A Pipelined is not safe for concurrent use, and the underlying Splitter must
+// not be used once wrapped.
+type Pipelined struct {
+	p *pipeline
+
+	// drained is set once the goroutine has returned the first error.
+	drained bool
+	closed  bool
+	end     uint64
+}
+
+// pipeline is the part of a Pipelined shared with its goroutine, so that the
+// Pipelined can be collected while the goroutine waits.
+type pipeline struct {
+	s       PositionalSplitter
+	results chan pipelinedResult
+
+	done      chan struct{}
+	closeOnce sync.Once
+	// stopped is closed once the goroutine returns.
+	stopped chan struct{}
+}
+
+type pipelinedResult struct {
+	data   []byte
+	offset uint64
+	err    error
+}
+
+// NewPipelined returns a Pipelined which reads up to readAhead chunks of s
+// ahead of the consumer, and at least one.
+func NewPipelined(s Splitter, readAhead int) *Pipelined {
+	if readAhead < 1 {
+		readAhead = 1
+	}
+	p := &pipeline{
+		s: WithPositions(s),
+		// the goroutine holds one more chunk until it is received
+		results: make(chan pipelinedResult, readAhead-1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.run()
+
+	pl := &Pipelined{p: p}
+	runtime.SetFinalizer(pl, func(pl *Pipelined) {
+		pl.p.close()
+	})
+	return pl
+}
+
+func (p *pipeline) run() {
+	defer close(p.stopped)
+	for {
+		data, offset, err := p.s.NextBytesAt()
+		select {
+		case p.results <- pipelinedResult{data: data, offset: offset, err: err}:
+		case <-p.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *pipeline) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// Reader returns the io.Reader associated to this Splitter.
+func (pl *Pipelined) Reader() io.Reader {
+	return pl.p.s.Reader()
+}
+
+// NextBytes returns the next chunk of the underlying Splitter.
+func (pl *Pipelined) NextBytes() ([]byte, error) {
+	data, _, err := pl.NextBytesAt()
+	return data, err
+}
+
+// NextBytesAt returns the next chunk of the underlying Splitter, with its
+// offset.
+func (pl *Pipelined) NextBytesAt() ([]byte, uint64, error) {
+	if pl.closed {
+		return nil, pl.end, io.ErrClosedPipe
+	}
+	if pl.drained {
+		return pl.p.s.NextBytesAt()
+	}
+
+	r := <-pl.p.results
+	if r.err != nil {
+		pl.drained = true
+	}
+	pl.end = r.offset + uint64(len(r.data))
+	return r.data, r.offset, r.err
+}
+
+// Close stops the read ahead, and makes the next calls of NextBytes fail with
+// io.ErrClosedPipe. The read in progress, if any, is not interrupted.
+func (pl *Pipelined) Close() error {
+	pl.closed = true
+	pl.p.close()
+	return nil
+}
@@ -0,0 +1,161 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// failingReader returns the data of r, then err.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (fr *failingReader) Read(buf []byte) (int, error) {
+	n, err := fr.r.Read(buf)
+	if err == io.EOF {
+		return n, fr.err
+	}
+	return n, err
+}
+
+// splitterCalls returns the lengths and the errors of n calls of s.
+func splitterCalls(s Splitter, n int) []string {
+	calls := make([]string, n)
+	for i := range calls {
+		data, err := s.NextBytes()
+		calls[i] = fmt.Sprintf("%d %v", len(data), err)
+	}
+	return calls
+}
+
+func TestPipelined(t *testing.T) {
+	data := randBuf(t, 4<<20)
+	for _, readAhead := range []int{0, 1, 4, 100} {
+		s := NewPipelined(NewSizeSplitter(bytes.NewReader(data), 100000), readAhead)
+
+		var expected uint64
+		for {
+			chunk, offset, err := s.NextBytesAt()
+			if offset != expected {
+				t.Fatalf("expected the offset %d, got %d", expected, offset)
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data[offset:offset+uint64(len(chunk))], chunk) {
+				t.Fatalf("chunk at %d is not the data at its offset", offset)
+			}
+			expected += uint64(len(chunk))
+		}
+		if expected != uint64(len(data)) {
+			t.Fatalf("the chunks end at %d", expected)
+		}
+	}
+}
+
+func TestPipelinedErrors(t *testing.T) {
+	data := randBuf(t, 1<<20)
+	errRead := errors.New("read error")
+	for _, newSplitter := range []func(io.Reader) Splitter{
+		func(r io.Reader) Splitter { return NewSizeSplitter(r, 100000) },
+		func(r io.Reader) Splitter { return NewBuzhash(r) },
+		func(r io.Reader) Splitter { return NewRabin(r, 256<<10) },
+	} {
+		for _, err := range []error{io.EOF, errRead} {
+			expected := splitterCalls(newSplitter(&failingReader{r: bytes.NewReader(data), err: err}), 16)
+			calls := splitterCalls(NewPipelined(newSplitter(&failingReader{r: bytes.NewReader(data), err: err}), 4), 16)
+			if fmt.Sprint(calls) != fmt.Sprint(expected) {
+				t.Fatalf("expected the calls %v, got %v", expected, calls)
+			}
+		}
+	}
+}
+
+func waitStopped(t *testing.T, p *pipeline) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case <-p.stopped:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the goroutine of the pipeline did not stop")
+		}
+	}
+}
+
+func TestPipelinedClose(t *testing.T) {
+	s := NewPipelined(NewSizeSplitter(bytes.NewReader(randBuf(t, 4<<20)), 1000), 2)
+	if _, err := s.NextBytes(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitStopped(t, s.p)
+	if _, err := s.NextBytes(); err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe, got: %v", err)
+	}
+}
+
+func TestPipelinedAbandoned(t *testing.T) {
+	s := NewPipelined(NewSizeSplitter(bytes.NewReader(randBuf(t, 4<<20)), 1000), 2)
+	if _, err := s.NextBytes(); err != nil {
+		t.Fatal(err)
+	}
+	p := s.p
+	s = nil
+	waitStopped(t, p)
+}
+
+// slowReader sleeps before each read.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (sr *slowReader) Read(buf []byte) (int, error) {
+	time.Sleep(sr.delay)
+	return sr.r.Read(buf)
+}
+
+func BenchmarkPipelined(b *testing.B) {
+	data := make([]byte, 32<<20)
+	for _, bc := range []struct {
+		name        string
+		newSplitter func(Splitter) Splitter
+	}{
+		{"direct", func(s Splitter) Splitter { return s }},
+		{"pipelined", func(s Splitter) Splitter { return NewPipelined(s, 4) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				r := &slowReader{r: bytes.NewReader(data), delay: 2 * time.Millisecond}
+				s := bc.newSplitter(NewSizeSplitter(r, DefaultBlockSize))
+				for {
+					chunk, err := s.NextBytes()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+					// hash and write the chunk
+					sha256.Sum256(chunk)
+					time.Sleep(2 * time.Millisecond)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package chunk
+
+import "io"
+
+// A PositionalSplitter is a Splitter which also returns the positions of the
+// chunks within the data of its reader.
+type PositionalSplitter interface {
+	Splitter
+
+	// NextBytesAt returns the next chunk as NextBytes, with its offset within
+	// the data of the reader. The chunks are contiguous: the offset of a chunk
+	// is the one of the previous chunk plus its length. With an error, and
+	// io.EOF, the offset is the one of the end of the chunks returned.
+	NextBytesAt() (data []byte, offset uint64, err error)
+}
+
+// WithPositions returns s as a PositionalSplitter: s itself if it implements
+// it, or a wrapper which counts the bytes of the chunks returned by s. s must
+// not be used directly once wrapped.
+func WithPositions(s Splitter) PositionalSplitter {
+	if ps, ok := s.(PositionalSplitter); ok {
+		return ps
+	}
+	return &positionalSplitter{Splitter: s}
+}
+
+type positionalSplitter struct {
+	Splitter
+	offset uint64
+}
+
+func (ps *positionalSplitter) NextBytes() ([]byte, error) {
+	data, _, err := ps.NextBytesAt()
+	return data, err
+}
+
+func (ps *positionalSplitter) NextBytesAt() ([]byte, uint64, error) {
+	offset := ps.offset
+	data, err := ps.Splitter.NextBytes()
+	ps.offset += uint64(len(data))
+	return data, offset, err
+}
+
+// Reader returns the io.Reader associated to this Splitter.
+func (ps *positionalSplitter) Reader() io.Reader {
+	return ps.Splitter.Reader()
+}
@@ -0,0 +1,76 @@
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var (
+	_ PositionalSplitter = (*sizeSplitterv2)(nil)
+	_ PositionalSplitter = (*Rabin)(nil)
+	_ PositionalSplitter = (*Buzhash)(nil)
+	_ PositionalSplitter = (*FastCDC)(nil)
+)
+
+// splitterOnly hides the NextBytesAt of a Splitter.
+type splitterOnly struct {
+	Splitter
+}
+
+func TestPositionalSplitters(t *testing.T) {
+	splitters := map[string]func(io.Reader) Splitter{
+		"size": func(r io.Reader) Splitter {
+			return NewSizeSplitter(r, 4096)
+		},
+		"rabin": func(r io.Reader) Splitter {
+			return NewRabinMinMax(r, 1024, 4096, 16384)
+		},
+		"buzhash": func(r io.Reader) Splitter {
+			return NewBuzhash(r)
+		},
+		"fastcdc": func(r io.Reader) Splitter {
+			f, err := NewFastCDC(r, 1024, 4096, 16384)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		},
+		"wrapped": func(r io.Reader) Splitter {
+			return splitterOnly{NewSizeSplitter(r, 4096)}
+		},
+	}
+
+	for name, newSplitter := range splitters {
+		for _, size := range []int{0, 100, 2 << 20} {
+			data := randBuf(t, size)
+			s := WithPositions(newSplitter(bytes.NewReader(data)))
+			if _, ok := s.(*positionalSplitter); ok != (name == "wrapped") {
+				t.Fatalf("%s: unexpected wrapper %T", name, s)
+			}
+
+			var expected uint64
+			for n := 0; ; n++ {
+				chunk, offset, err := s.NextBytesAt()
+				if offset != expected {
+					t.Fatalf("%s/%d: expected the offset %d for chunk %d, got %d", name, size, expected, n, offset)
+				}
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if len(chunk) == 0 {
+					t.Fatalf("%s/%d: chunk %d is empty", name, size, n)
+				}
+				if !bytes.Equal(data[offset:offset+uint64(len(chunk))], chunk) {
+					t.Fatalf("%s/%d: chunk %d is not the data at its offset", name, size, n)
+				}
+				expected += uint64(len(chunk))
+			}
+			if expected != uint64(size) {
+				t.Fatalf("%s/%d: the chunks end at %d", name, size, expected)
+			}
+		}
+	}
+}
@@ -15,6 +15,7 @@ var IpfsRabinPoly = chunker.Pol(17437180132763653)
 type Rabin struct {
 	r      *chunker.Chunker
 	reader io.Reader
+	end    uint64
 }
 
 // NewRabin creates a new Rabin splitter with the given
@@ -29,8 +30,12 @@ func NewRabin(r io.Reader, avgBlkSize uint64) *Rabin {
 // NewRabinMinMax returns a new Rabin splitter which uses
 // the given min, average and max block sizes.
 func NewRabinMinMax(r io.Reader, min, avg, max uint64) *Rabin {
+	return newRabin(r, IpfsRabinPoly, min, avg, max)
+}
+
+func newRabin(r io.Reader, pol chunker.Pol, min, avg, max uint64) *Rabin {
 	h := fnv.New32a()
-	ch := chunker.New(r, IpfsRabinPoly, h, avg, min, max)
+	ch := chunker.New(r, pol, h, avg, min, max)
 
 	return &Rabin{
 		r:      ch,
@@ -40,12 +45,20 @@ func NewRabinMinMax(r io.Reader, min, avg, max uint64) *Rabin {
 
 // NextBytes reads the next bytes from the reader and returns a slice.
 func (r *Rabin) NextBytes() ([]byte, error) {
+	data, _, err := r.NextBytesAt()
+	return data, err
+}
+
+// NextBytesAt reads the next bytes from the reader and returns a slice, with
+// its offset.
+func (r *Rabin) NextBytesAt() ([]byte, uint64, error) {
 	ch, err := r.r.Next()
 	if err != nil {
-		return nil, err
+		return nil, r.end, err
 	}
 
-	return ch.Data, nil
+	r.end = ch.Start + ch.Length
+	return ch.Data, ch.Start, nil
 }
 
 // Reader returns the io.Reader associated to this Splitter.
@@ -60,9 +60,10 @@ func Chan(s Splitter) (<-chan []byte, <-chan error) {
 }
 
 type sizeSplitterv2 struct {
-	r    io.Reader
-	size uint32
-	err  error
+	r      io.Reader
+	size   uint32
+	offset uint64
+	err    error
 }
 
 // NewSizeSplitter returns a new size-based Splitter with the given block size.
@@ -87,8 +88,10 @@ func (ss *sizeSplitterv2) NextBytes() ([]byte, error) {
 		small := make([]byte, n)
 		copy(small, full)
 		pool.Put(full)
+		ss.offset += uint64(n)
 		return small, nil
 	case nil:
+		ss.offset += uint64(len(full))
 		return full, nil
 	default:
 		pool.Put(full)
@@ -96,6 +99,13 @@ func (ss *sizeSplitterv2) NextBytes() ([]byte, error) {
 	}
 }
 
+// NextBytesAt produces a new chunk, with its offset.
+func (ss *sizeSplitterv2) NextBytesAt() ([]byte, uint64, error) {
+	offset := ss.offset
+	data, err := ss.NextBytes()
+	return data, offset, err
+}
+
 // Reader returns the io.Reader associated to this Splitter.
 func (ss *sizeSplitterv2) Reader() io.Reader {
 	return ss.r
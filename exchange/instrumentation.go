@@ -0,0 +1,276 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ipfs/boxo/internal/promutil"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var logger = logging.Logger("exchange")
+
+// outcomes of the instrumented calls, for the "outcome" label and span
+// attribute
+const (
+	outcomeFound    = "found"
+	outcomeNotFound = "not-found"
+	outcomeError    = "error"
+	outcomeCanceled = "canceled"
+	outcomeOK       = "ok"
+)
+
+var requestDurationBuckets = []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60}
+
+// WithInstrumentation wraps ex to trace and measure its calls, so the layers
+// of a stack of exchanges can be told apart. Spans are named after name, as
+// in "<name>.GetBlock", and carry the "exchange" attribute. The metrics,
+// labeled with name (label "exchange") and method, are:
+//
+//   - ipfs_exchange_requests_total counts calls by outcome: "found",
+//     "not-found", "error" or "canceled" for GetBlock and GetBlocks, "ok" or
+//     "error" for NotifyNewBlocks and Close.
+//   - ipfs_exchange_request_duration_seconds is the duration of the calls,
+//     until the channel is closed for GetBlocks.
+//   - ipfs_exchange_first_block_duration_seconds is the time to the first
+//     block of GetBlocks.
+//
+// GetBlocks is found when all the blocks were returned; not-found when the
+// channel was closed early.
+//
+// A nil or no-op tp disables tracing and a nil reg disables the metrics; ex
+// is returned as is when both are. Sessions of a SessionExchange are
+// instrumented too.
+func WithInstrumentation(name string, ex Interface, tp trace.TracerProvider, reg prometheus.Registerer) Interface {
+	if tp == trace.NewNoopTracerProvider() {
+		tp = nil
+	}
+	if tp == nil && reg == nil {
+		return ex
+	}
+
+	ins := &instrumentation{name: name}
+	if tp != nil {
+		ins.tracer = tp.Tracer("boxo/exchange")
+	}
+	if reg != nil {
+		ins.metrics = newInstrumentationMetrics(reg, name)
+	}
+
+	e := &instrumentedExchange{
+		instrumentedFetcher: &instrumentedFetcher{Fetcher: ex, ins: ins},
+		ex:                  ex,
+	}
+	if sx, ok := ex.(SessionExchange); ok {
+		return &instrumentedSessionExchange{instrumentedExchange: e, sx: sx}
+	}
+	return e
+}
+
+type instrumentation struct {
+	name    string
+	tracer  trace.Tracer // nil when tracing is disabled
+	metrics *instrumentationMetrics
+}
+
+type instrumentationMetrics struct {
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	firstBlock    prometheus.Observer
+	exchangeLabel string
+}
+
+func newInstrumentationMetrics(reg prometheus.Registerer, name string) *instrumentationMetrics {
+	requests := promutil.Register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "exchange",
+			Name:      "requests_total",
+			Help:      "The number of calls to the exchange, by method and outcome.",
+		},
+		[]string{"exchange", "method", "outcome"},
+	), logger)
+	duration := promutil.Register(reg, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "ipfs",
+			Subsystem: "exchange",
+			Name:      "request_duration_seconds",
+			Help:      "The duration of the calls to the exchange, by method.",
+			Buckets:   requestDurationBuckets,
+		},
+		[]string{"exchange", "method"},
+	), logger)
+	firstBlock := promutil.Register(reg, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "ipfs",
+			Subsystem: "exchange",
+			Name:      "first_block_duration_seconds",
+			Help:      "The time to receive the first block from GetBlocks.",
+			Buckets:   requestDurationBuckets,
+		},
+		[]string{"exchange"},
+	), logger)
+	return &instrumentationMetrics{
+		requests:      requests,
+		duration:      duration,
+		firstBlock:    firstBlock.WithLabelValues(name),
+		exchangeLabel: name,
+	}
+}
+
+// call is an instrumented call in progress.
+type call struct {
+	ins    *instrumentation
+	method string
+	start  time.Time
+	span   trace.Span // nil when tracing is disabled
+}
+
+func (ins *instrumentation) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, *call) {
+	c := &call{ins: ins, method: method, start: time.Now()}
+	if ins.tracer != nil {
+		attrs = append(attrs, attribute.String("exchange", ins.name))
+		ctx, c.span = ins.tracer.Start(ctx, ins.name+"."+method, trace.WithAttributes(attrs...))
+	}
+	return ctx, c
+}
+
+func (c *call) end(outcome string, err error, attrs ...attribute.KeyValue) {
+	if m := c.ins.metrics; m != nil {
+		m.requests.WithLabelValues(m.exchangeLabel, c.method, outcome).Inc()
+		m.duration.WithLabelValues(m.exchangeLabel, c.method).Observe(time.Since(c.start).Seconds())
+	}
+	if c.span != nil {
+		c.span.SetAttributes(append(attrs, attribute.String("outcome", outcome))...)
+		if err != nil {
+			c.span.RecordError(err)
+			if outcome == outcomeError {
+				c.span.SetStatus(codes.Error, err.Error())
+			}
+		}
+		c.span.End()
+	}
+}
+
+func fetchOutcome(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return outcomeFound
+	case ctx.Err() != nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return outcomeCanceled
+	case ipld.IsNotFound(err):
+		return outcomeNotFound
+	default:
+		return outcomeError
+	}
+}
+
+type instrumentedFetcher struct {
+	Fetcher
+	ins *instrumentation
+}
+
+func (f *instrumentedFetcher) GetBlock(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	ctx, c := f.ins.start(ctx, "GetBlock", attribute.Stringer("cid", k))
+	blk, err := f.Fetcher.GetBlock(ctx, k)
+	c.end(fetchOutcome(ctx, err), err)
+	return blk, err
+}
+
+func (f *instrumentedFetcher) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	ctx, c := f.ins.start(ctx, "GetBlocks", attribute.Int("requested", len(ks)))
+	in, err := f.Fetcher.GetBlocks(ctx, ks)
+	if err != nil {
+		c.end(fetchOutcome(ctx, err), err)
+		return nil, err
+	}
+
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		var received int
+		for blk := range in {
+			if received == 0 {
+				if m := f.ins.metrics; m != nil {
+					m.firstBlock.Observe(time.Since(c.start).Seconds())
+				}
+				if c.span != nil {
+					c.span.AddEvent("first block")
+				}
+			}
+			received++
+			select {
+			case out <- blk:
+			case <-ctx.Done():
+			}
+		}
+
+		outcome := outcomeFound
+		switch {
+		case ctx.Err() != nil:
+			outcome = outcomeCanceled
+		case received < len(ks):
+			outcome = outcomeNotFound
+		}
+		c.end(outcome, ctx.Err(), attribute.Int("received", received))
+	}()
+	return out, nil
+}
+
+type instrumentedExchange struct {
+	*instrumentedFetcher
+	ex Interface
+}
+
+func resultOutcome(err error) string {
+	if err != nil {
+		return outcomeError
+	}
+	return outcomeOK
+}
+
+func (e *instrumentedExchange) NotifyNewBlocks(ctx context.Context, blks ...blocks.Block) error {
+	ctx, c := e.ins.start(ctx, "NotifyNewBlocks", attribute.Int("blocks", len(blks)))
+	err := e.ex.NotifyNewBlocks(ctx, blks...)
+	c.end(resultOutcome(err), err)
+	return err
+}
+
+func (e *instrumentedExchange) Close() error {
+	_, c := e.ins.start(context.Background(), "Close")
+	err := e.ex.Close()
+	c.end(resultOutcome(err), err)
+	return err
+}
+
+type instrumentedSessionExchange struct {
+	*instrumentedExchange
+	sx SessionExchange
+}
+
+var _ SessionExchange = (*instrumentedSessionExchange)(nil)
+
+// NewSession returns an instrumented session of the wrapped exchange. The
+// session is closeable when the wrapped one is.
+func (e *instrumentedSessionExchange) NewSession(ctx context.Context) Fetcher {
+	sess := e.sx.NewSession(ctx)
+	f := &instrumentedFetcher{Fetcher: sess, ins: e.ins}
+	if closer, ok := sess.(io.Closer); ok {
+		return &closeableInstrumentedFetcher{instrumentedFetcher: f, Closer: closer}
+	}
+	return f
+}
+
+type closeableInstrumentedFetcher struct {
+	*instrumentedFetcher
+	io.Closer
+}
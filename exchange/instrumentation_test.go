@@ -0,0 +1,148 @@
+package exchange_test
+
+import (
+	"context"
+	"testing"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/exchange/providing"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type nopProvider struct{}
+
+func (nopProvider) Provide(cid.Cid) error { return nil }
+
+func spanAttrs(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range s.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestInstrumentationTwoLayers(t *testing.T) {
+	ctx := context.Background()
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	reg := prometheus.NewRegistry()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bgen := blocksutil.NewBlockGenerator()
+	blks := bgen.Blocks(3)
+	for _, b := range blks[:2] {
+		if err := bs.Put(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inner := exchange.WithInstrumentation("offline", offline.Exchange(bs), tp, reg)
+	ex := exchange.WithInstrumentation("providing", providing.New(inner, nopProvider{}), tp, reg)
+
+	if _, err := ex.GetBlock(ctx, blks[0].Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ex.GetBlock(ctx, blks[2].Cid()); err == nil {
+		t.Fatal("expected a not found error")
+	}
+	ch, err := ex.GetBlocks(ctx, []cid.Cid{blks[0].Cid(), blks[1].Cid(), blks[2].Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for range ch {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 blocks, got %d", n)
+	}
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := sr.Ended()
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		byName[s.Name()] = append(byName[s.Name()], s)
+	}
+	for _, layer := range []string{"offline", "providing"} {
+		gets := byName[layer+".GetBlock"]
+		if len(gets) != 2 {
+			t.Fatalf("expected 2 %s.GetBlock spans, got %d", layer, len(gets))
+		}
+		for i, outcome := range []string{"found", "not-found"} {
+			attrs := spanAttrs(gets[i])
+			if attrs["exchange"].AsString() != layer || attrs["outcome"].AsString() != outcome {
+				t.Fatalf("unexpected %s.GetBlock attributes %v", layer, attrs)
+			}
+		}
+
+		getMany := byName[layer+".GetBlocks"]
+		if len(getMany) != 1 {
+			t.Fatalf("expected 1 %s.GetBlocks span, got %d", layer, len(getMany))
+		}
+		attrs := spanAttrs(getMany[0])
+		if attrs["outcome"].AsString() != "not-found" || attrs["requested"].AsInt64() != 3 || attrs["received"].AsInt64() != 2 {
+			t.Fatalf("unexpected %s.GetBlocks attributes %v", layer, attrs)
+		}
+		if events := getMany[0].Events(); len(events) != 1 || events[0].Name != "first block" {
+			t.Fatalf("expected a first block event, got %v", events)
+		}
+
+		if len(byName[layer+".Close"]) != 1 {
+			t.Fatalf("expected 1 %s.Close span", layer)
+		}
+	}
+
+	// The inner spans are children of the outer ones.
+	outer := byName["providing.GetBlock"][0]
+	if byName["offline.GetBlock"][0].Parent().SpanID() != outer.SpanContext().SpanID() {
+		t.Fatal("expected the offline span to be a child of the providing span")
+	}
+
+	if v := testutil.ToFloat64(counter(t, reg, "offline", "GetBlock", "not-found")); v != 1 {
+		t.Fatalf("expected 1 not found GetBlock, got %v", v)
+	}
+	if v := testutil.ToFloat64(counter(t, reg, "providing", "GetBlocks", "not-found")); v != 1 {
+		t.Fatalf("expected 1 not found GetBlocks, got %v", v)
+	}
+}
+
+// counter returns the requests counter with the given labels from reg.
+func counter(t *testing.T, reg *prometheus.Registry, labels ...string) prometheus.Counter {
+	t.Helper()
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "exchange",
+		Name:      "requests_total",
+		Help:      "The number of calls to the exchange, by method and outcome.",
+	}, []string{"exchange", "method", "outcome"})
+	err := reg.Register(vec)
+	are, ok := err.(prometheus.AlreadyRegisteredError)
+	if !ok {
+		t.Fatalf("expected the counter to be registered, got %v", err)
+	}
+	return are.ExistingCollector.(*prometheus.CounterVec).WithLabelValues(labels...)
+}
+
+func TestInstrumentationNoop(t *testing.T) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	ex := offline.Exchange(bs)
+	if exchange.WithInstrumentation("offline", ex, nil, nil) != ex {
+		t.Fatal("expected the exchange to be returned as is")
+	}
+	if exchange.WithInstrumentation("offline", ex, trace.NewNoopTracerProvider(), nil) != ex {
+		t.Fatal("expected the exchange to be returned as is")
+	}
+}
@@ -11,8 +11,32 @@ import (
 	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log/v2"
 )
 
+var log = logging.Logger("offline")
+
+// ErrNotFound is returned by GetBlock when the block is not in the local
+// blockstore. It wraps an ipld.ErrNotFound for the same CID, so
+// ipld.IsNotFound and errors.As with an ipld.ErrNotFound work on it.
+type ErrNotFound struct {
+	Cid cid.Cid
+}
+
+func (e ErrNotFound) Error() string {
+	return fmt.Sprintf("block was not found locally (offline): %s", e.Unwrap())
+}
+
+// Is reports whether err is an ErrNotFound, whatever its CID.
+func (e ErrNotFound) Is(err error) bool {
+	_, ok := err.(ErrNotFound)
+	return ok
+}
+
+func (e ErrNotFound) Unwrap() error {
+	return ipld.ErrNotFound{Cid: e.Cid}
+}
+
 func Exchange(bs blockstore.Blockstore) exchange.Interface {
 	return &offlineExchange{bs: bs}
 }
@@ -23,13 +47,13 @@ type offlineExchange struct {
 	bs blockstore.Blockstore
 }
 
-// GetBlock returns nil to signal that a block could not be retrieved for the
-// given key.
+// GetBlock returns an ErrNotFound to signal that a block could not be
+// retrieved for the given key. Other errors come from the blockstore.
 // NB: This function may return before the timeout expires.
 func (e *offlineExchange) GetBlock(ctx context.Context, k cid.Cid) (blocks.Block, error) {
 	blk, err := e.bs.Get(ctx, k)
 	if ipld.IsNotFound(err) {
-		return nil, fmt.Errorf("block was not found locally (offline): %w", err)
+		return nil, ErrNotFound{Cid: k}
 	}
 	return blk, err
 }
@@ -47,6 +71,8 @@ func (e *offlineExchange) Close() error {
 	return nil
 }
 
+// GetBlocks returns the blocks found locally. Missing blocks are skipped, the
+// channel is closed once all the local ones were sent.
 func (e *offlineExchange) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
 	out := make(chan blocks.Block)
 	go func() {
@@ -54,6 +80,9 @@ func (e *offlineExchange) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan b
 		for _, k := range ks {
 			hit, err := e.bs.Get(ctx, k)
 			if err != nil {
+				if !ipld.IsNotFound(err) {
+					log.Errorf("failed to read %s from the blockstore: %s", k, err)
+				}
 				// a long line of misses should abort when context is cancelled.
 				select {
 				// TODO case send misses down channel
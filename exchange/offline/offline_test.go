@@ -2,6 +2,7 @@ package offline
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	blockstore "github.com/ipfs/boxo/blockstore"
@@ -10,6 +11,7 @@ import (
 	ds "github.com/ipfs/go-datastore"
 	ds_sync "github.com/ipfs/go-datastore/sync"
 	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	ipld "github.com/ipfs/go-ipld-format"
 )
 
 func TestBlockReturnsErr(t *testing.T) {
@@ -22,6 +24,61 @@ func TestBlockReturnsErr(t *testing.T) {
 	t.Fail()
 }
 
+func TestBlockReturnsNotFound(t *testing.T) {
+	off := Exchange(bstore())
+	c := cid.NewCidV0(u.Hash([]byte("foo")))
+	_, err := off.GetBlock(context.Background(), c)
+
+	if !ipld.IsNotFound(err) {
+		t.Fatalf("expected an ipld.ErrNotFound, got %v", err)
+	}
+	if !errors.Is(err, ErrNotFound{}) {
+		t.Fatalf("expected an ErrNotFound, got %v", err)
+	}
+	var nf ErrNotFound
+	if !errors.As(err, &nf) || !nf.Cid.Equals(c) {
+		t.Fatalf("expected an ErrNotFound for %s, got %v", c, err)
+	}
+	var inf ipld.ErrNotFound
+	if !errors.As(err, &inf) || !inf.Cid.Equals(c) {
+		t.Fatalf("expected an ipld.ErrNotFound for %s, got %v", c, err)
+	}
+}
+
+func TestGetBlocksSkipsMissing(t *testing.T) {
+	store := bstore()
+	ex := Exchange(store)
+	g := blocksutil.NewBlockGenerator()
+
+	var ks []cid.Cid
+	present := make(map[cid.Cid]bool)
+	for i, b := range g.Blocks(10) {
+		ks = append(ks, b.Cid())
+		if i%3 == 0 {
+			continue
+		}
+		if err := store.Put(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+		present[b.Cid()] = true
+	}
+
+	received, err := ex.GetBlocks(context.Background(), ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for b := range received {
+		if !present[b.Cid()] {
+			t.Fatalf("unexpected block %s", b.Cid())
+		}
+		count++
+	}
+	if count != len(present) {
+		t.Fatalf("expected %d blocks, got %d", len(present), count)
+	}
+}
+
 func TestGetBlocks(t *testing.T) {
 	store := bstore()
 	ex := Exchange(store)
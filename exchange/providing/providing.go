@@ -0,0 +1,370 @@
+// Package providing implements an exchange wrapper which announces the blocks
+// it is notified about to a provider.
+package providing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/exchange"
+	"github.com/ipfs/boxo/provider"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/multiformats/go-multihash"
+)
+
+var logger = logging.Logger("providing")
+
+const (
+	defaultQueueSize    = 4096
+	defaultBatchSize    = 128
+	defaultDedupWindow  = time.Minute
+	defaultDrainTimeout = 5 * time.Second
+)
+
+// Option configures the Exchange returned by New.
+type Option func(*Exchange)
+
+// WithQueueSize sets the maximum number of CIDs waiting to be provided. When
+// the queue is full the oldest CIDs are dropped, so NotifyNewBlocks never
+// waits for the provider. The default is 4096.
+func WithQueueSize(n int) Option {
+	return func(ex *Exchange) {
+		if n < 1 {
+			n = 1
+		}
+		ex.queueSize = n
+	}
+}
+
+// WithBatchSize sets the maximum number of CIDs passed to the provider at a
+// time. The default is 128.
+func WithBatchSize(n int) Option {
+	return func(ex *Exchange) {
+		if n < 1 {
+			n = 1
+		}
+		ex.batchSize = n
+	}
+}
+
+// WithBatchInterval sets the minimum time between the start of two batches,
+// limiting the rate of provides. By default batches are sent as fast as the
+// provider accepts them.
+func WithBatchInterval(d time.Duration) Option {
+	return func(ex *Exchange) {
+		ex.batchInterval = d
+	}
+}
+
+// WithDedupWindow sets for how long a CID is not provided again after it was
+// queued. The default is one minute.
+func WithDedupWindow(d time.Duration) Option {
+	return func(ex *Exchange) {
+		ex.dedupWindow = d
+	}
+}
+
+// WithDrainTimeout sets for how long Close keeps providing the queued CIDs
+// before abandoning them. Zero abandons them right away. The default is 5
+// seconds.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(ex *Exchange) {
+		ex.drainTimeout = d
+	}
+}
+
+// Filter reports whether the CID of a block of the given size should be
+// provided.
+type Filter func(c cid.Cid, blockSize int) bool
+
+// DefaultFilter skips the CIDs with an identity multihash, which carry their
+// data and are never worth announcing.
+func DefaultFilter(c cid.Cid, blockSize int) bool {
+	return c.Prefix().MhType != multihash.IDENTITY
+}
+
+// WithFilter sets a filter deciding which CIDs are provided, for example to
+// skip tiny blocks. It composes with DefaultFilter, which is always applied
+// first: the CIDs it skips are never passed to f. Unlike DefaultFilter, f is
+// called from the provide worker so that a slow filter does not delay
+// NotifyNewBlocks. A nil filter only applies DefaultFilter.
+func WithFilter(f Filter) Option {
+	return func(ex *Exchange) {
+		ex.filter = f
+	}
+}
+
+// Stats are statistics about the provides of an Exchange.
+type Stats struct {
+	// Queued is the number of CIDs waiting to be provided.
+	Queued int
+	// Provided is the number of CIDs passed to the provider successfully.
+	Provided uint64
+	// Failed is the number of CIDs the provider returned an error for.
+	Failed uint64
+	// Duplicates is the number of CIDs not queued because they were queued
+	// recently.
+	Duplicates uint64
+	// Dropped is the number of CIDs dropped because the queue was full.
+	Dropped uint64
+	// Abandoned is the number of CIDs left in the queue at Close.
+	Abandoned uint64
+	// Filtered is the number of CIDs the filter skipped.
+	Filtered uint64
+}
+
+type queued struct {
+	c    cid.Cid
+	size int
+}
+
+type seenEntry struct {
+	c  cid.Cid
+	at time.Time
+}
+
+// Exchange is an exchange.Interface which also provides the CIDs of the
+// blocks passed to NotifyNewBlocks. Provides happen asynchronously, in
+// batches, from a bounded queue.
+type Exchange struct {
+	exchange.Interface
+	provider provider.Provider
+
+	queueSize     int
+	batchSize     int
+	batchInterval time.Duration
+	dedupWindow   time.Duration
+	drainTimeout  time.Duration
+	// filter is applied by the provide worker, after DefaultFilter which is
+	// applied before queuing.
+	filter Filter
+
+	lk sync.Mutex
+	// queue is a ring buffer of queueLen CIDs starting at queueHead.
+	queue     []queued
+	queueHead int
+	queueLen  int
+	// seen maps recently queued CIDs to when they were queued, seenOrder
+	// lists them in that order for expiry.
+	seen      map[cid.Cid]time.Time
+	seenOrder []seenEntry
+	stats     Stats
+	closed    bool
+
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+var _ exchange.Interface = (*Exchange)(nil)
+
+// New returns an Exchange wrapping base and announcing new blocks to
+// provider. It must be closed to stop the provide worker.
+func New(base exchange.Interface, provider provider.Provider, opts ...Option) *Exchange {
+	ex := &Exchange{
+		Interface:    base,
+		provider:     provider,
+		queueSize:    defaultQueueSize,
+		batchSize:    defaultBatchSize,
+		dedupWindow:  defaultDedupWindow,
+		drainTimeout: defaultDrainTimeout,
+		seen:         make(map[cid.Cid]time.Time),
+		wake:         make(chan struct{}, 1),
+		closing:      make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(ex)
+	}
+	ex.queue = make([]queued, ex.queueSize)
+
+	go ex.run()
+	return ex
+}
+
+// NotifyNewBlocks notifies the wrapped exchange and queues the CIDs of the
+// blocks to be provided. It does not wait for the provider.
+func (ex *Exchange) NotifyNewBlocks(ctx context.Context, blks ...blocks.Block) error {
+	err := ex.Interface.NotifyNewBlocks(ctx, blks...)
+
+	now := time.Now()
+	ex.lk.Lock()
+	if !ex.closed {
+		ex.expireSeen(now)
+		for _, b := range blks {
+			ex.enqueue(b.Cid(), len(b.RawData()), now)
+		}
+	}
+	ex.lk.Unlock()
+
+	select {
+	case ex.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// enqueue must be called with ex.lk held.
+func (ex *Exchange) enqueue(c cid.Cid, size int, now time.Time) {
+	if !DefaultFilter(c, size) {
+		ex.stats.Filtered++
+		return
+	}
+	if ex.dedupWindow > 0 {
+		if _, ok := ex.seen[c]; ok {
+			ex.stats.Duplicates++
+			return
+		}
+		ex.seen[c] = now
+		ex.seenOrder = append(ex.seenOrder, seenEntry{c, now})
+	}
+
+	if ex.queueLen == ex.queueSize {
+		// drop the oldest
+		ex.queue[ex.queueHead] = queued{}
+		ex.queueHead = (ex.queueHead + 1) % ex.queueSize
+		ex.queueLen--
+		ex.stats.Dropped++
+	}
+	ex.queue[(ex.queueHead+ex.queueLen)%ex.queueSize] = queued{c, size}
+	ex.queueLen++
+}
+
+// expireSeen forgets the CIDs queued before the dedup window, it must be
+// called with ex.lk held.
+func (ex *Exchange) expireSeen(now time.Time) {
+	var i int
+	for i < len(ex.seenOrder) && now.Sub(ex.seenOrder[i].at) >= ex.dedupWindow {
+		delete(ex.seen, ex.seenOrder[i].c)
+		i++
+	}
+	if i > 0 {
+		ex.seenOrder = append(ex.seenOrder[:0], ex.seenOrder[i:]...)
+	}
+}
+
+// dequeue returns up to batchSize CIDs.
+func (ex *Exchange) dequeue() []queued {
+	ex.lk.Lock()
+	defer ex.lk.Unlock()
+	n := ex.queueLen
+	if n > ex.batchSize {
+		n = ex.batchSize
+	}
+	batch := make([]queued, n)
+	for i := range batch {
+		batch[i] = ex.queue[ex.queueHead]
+		ex.queue[ex.queueHead] = queued{}
+		ex.queueHead = (ex.queueHead + 1) % ex.queueSize
+	}
+	ex.queueLen -= n
+	return batch
+}
+
+func (ex *Exchange) run() {
+	defer close(ex.done)
+
+	closing := ex.closing
+	var drain <-chan time.Time
+	for {
+		// After Close, keep providing until the queue is empty or the drain
+		// timeout expires.
+		select {
+		case <-closing:
+			if ex.drainTimeout <= 0 {
+				return
+			}
+			timer := time.NewTimer(ex.drainTimeout)
+			defer timer.Stop()
+			drain, closing = timer.C, nil
+		case <-drain:
+			return
+		default:
+		}
+
+		batch := ex.dequeue()
+		if len(batch) == 0 {
+			if drain != nil {
+				return
+			}
+			select {
+			case <-ex.wake:
+			case <-closing:
+			}
+			continue
+		}
+
+		start := time.Now()
+		ex.provide(batch)
+		if wait := ex.batchInterval - time.Since(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-closing:
+				// start draining right away
+				timer.Stop()
+			case <-drain:
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+func (ex *Exchange) provide(batch []queued) {
+	for _, q := range batch {
+		if ex.filter != nil && !ex.filter(q.c, q.size) {
+			ex.lk.Lock()
+			ex.stats.Filtered++
+			ex.lk.Unlock()
+			continue
+		}
+		err := ex.provider.Provide(q.c)
+		if err != nil {
+			logger.Warnf("failed to provide %s: %s", q.c, err)
+		}
+		ex.lk.Lock()
+		if err != nil {
+			ex.stats.Failed++
+		} else {
+			ex.stats.Provided++
+		}
+		ex.lk.Unlock()
+	}
+}
+
+// Stats returns the provide statistics of the exchange.
+func (ex *Exchange) Stats() Stats {
+	ex.lk.Lock()
+	defer ex.lk.Unlock()
+	st := ex.stats
+	st.Queued = ex.queueLen
+	return st
+}
+
+// Close stops accepting new CIDs, provides the queued ones for up to the
+// drain timeout, then closes the wrapped exchange.
+func (ex *Exchange) Close() error {
+	ex.lk.Lock()
+	if ex.closed {
+		ex.lk.Unlock()
+		return nil
+	}
+	ex.closed = true
+	close(ex.closing)
+	ex.lk.Unlock()
+
+	<-ex.done
+
+	ex.lk.Lock()
+	abandoned := ex.queueLen
+	ex.stats.Abandoned = uint64(abandoned)
+	ex.lk.Unlock()
+	if abandoned > 0 {
+		logger.Infof("abandoned %d queued provides", abandoned)
+	}
+	return ex.Interface.Close()
+}
@@ -0,0 +1,300 @@
+package providing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/multiformats/go-multihash"
+)
+
+// fakeProvider records the provided CIDs. Provide waits for delay, and until
+// gate is closed when set.
+type fakeProvider struct {
+	delay time.Duration
+	gate  chan struct{}
+
+	lk       sync.Mutex
+	provided []cid.Cid
+	started  chan struct{}
+}
+
+func newFakeProvider(delay time.Duration) *fakeProvider {
+	return &fakeProvider{delay: delay, started: make(chan struct{}, 1)}
+}
+
+func (p *fakeProvider) Provide(c cid.Cid) error {
+	select {
+	case p.started <- struct{}{}:
+	default:
+	}
+	if p.gate != nil {
+		<-p.gate
+	}
+	time.Sleep(p.delay)
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.provided = append(p.provided, c)
+	return nil
+}
+
+func (p *fakeProvider) getProvided() []cid.Cid {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return append([]cid.Cid(nil), p.provided...)
+}
+
+func newExchange(p *fakeProvider, opts ...Option) *Exchange {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	return New(offline.Exchange(bs), p, opts...)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNotifyDoesNotWaitForProvider(t *testing.T) {
+	p := newFakeProvider(50 * time.Millisecond)
+	ex := newExchange(p, WithDrainTimeout(0))
+	defer ex.Close()
+
+	bgen := blocksutil.NewBlockGenerator()
+	blks := bgen.Blocks(100)
+	start := time.Now()
+	for _, b := range blks {
+		if err := ex.NotifyNewBlocks(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Providing all of them inline would take 5s.
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("notifying took %s", elapsed)
+	}
+	if st := ex.Stats(); st.Queued+int(st.Provided) > len(blks) || st.Queued == 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestDuplicatesProvidedOnce(t *testing.T) {
+	p := newFakeProvider(time.Millisecond)
+	ex := newExchange(p)
+
+	bgen := blocksutil.NewBlockGenerator()
+	blks := bgen.Blocks(10)
+	for i := 0; i < 5; i++ {
+		if err := ex.NotifyNewBlocks(context.Background(), blks...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	provided := p.getProvided()
+	if len(provided) != len(blks) {
+		t.Fatalf("expected %d provides, got %d", len(blks), len(provided))
+	}
+	st := ex.Stats()
+	if st.Provided != uint64(len(blks)) || st.Duplicates != uint64(4*len(blks)) || st.Abandoned != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestDedupWindowExpires(t *testing.T) {
+	p := newFakeProvider(0)
+	ex := newExchange(p, WithDedupWindow(10*time.Millisecond))
+	defer ex.Close()
+
+	bgen := blocksutil.NewBlockGenerator()
+	b := bgen.Next()
+	ex.NotifyNewBlocks(context.Background(), b)
+	waitFor(t, "the first provide", func() bool { return len(p.getProvided()) == 1 })
+	time.Sleep(20 * time.Millisecond)
+	ex.NotifyNewBlocks(context.Background(), b)
+	waitFor(t, "the second provide", func() bool { return len(p.getProvided()) == 2 })
+}
+
+func TestQueueDropsOldest(t *testing.T) {
+	p := newFakeProvider(0)
+	p.gate = make(chan struct{})
+	ex := newExchange(p, WithQueueSize(10), WithBatchSize(1))
+
+	bgen := blocksutil.NewBlockGenerator()
+	blks := bgen.Blocks(30)
+	ex.NotifyNewBlocks(context.Background(), blks[0])
+	// The worker holds the first CID, the queue is empty.
+	<-p.started
+	ex.NotifyNewBlocks(context.Background(), blks[1:]...)
+
+	st := ex.Stats()
+	if st.Queued != 10 || st.Dropped != 19 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	close(p.gate)
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]blocks.Block{blks[0]}, blks[20:]...)
+	provided := p.getProvided()
+	if len(provided) != len(expected) {
+		t.Fatalf("expected %d provides, got %d", len(expected), len(provided))
+	}
+	for i, c := range provided {
+		if !c.Equals(expected[i].Cid()) {
+			t.Fatalf("provide %d is %s, expected %s", i, c, expected[i].Cid())
+		}
+	}
+}
+
+func TestCloseAbandons(t *testing.T) {
+	p := newFakeProvider(0)
+	p.gate = make(chan struct{})
+	ex := newExchange(p, WithBatchSize(1), WithDrainTimeout(0))
+
+	bgen := blocksutil.NewBlockGenerator()
+	ex.NotifyNewBlocks(context.Background(), bgen.Blocks(5)...)
+	<-p.started
+
+	closed := make(chan error)
+	go func() { closed <- ex.Close() }()
+	waitFor(t, "close", func() bool {
+		ex.lk.Lock()
+		defer ex.lk.Unlock()
+		return ex.closed
+	})
+	close(p.gate)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	st := ex.Stats()
+	if st.Provided != 1 || st.Abandoned != 4 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	// Blocks notified after Close are not queued.
+	ex.NotifyNewBlocks(context.Background(), bgen.Next())
+	if st := ex.Stats(); st.Queued != 4 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestCloseDrainTimeout(t *testing.T) {
+	p := newFakeProvider(20 * time.Millisecond)
+	ex := newExchange(p, WithBatchSize(1), WithDrainTimeout(100*time.Millisecond))
+
+	bgen := blocksutil.NewBlockGenerator()
+	ex.NotifyNewBlocks(context.Background(), bgen.Blocks(100)...)
+
+	start := time.Now()
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close took %s", elapsed)
+	}
+	st := ex.Stats()
+	if st.Provided == 0 || st.Abandoned == 0 || st.Provided+st.Abandoned != 100 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestCloseDuringBatchInterval(t *testing.T) {
+	p := newFakeProvider(0)
+	ex := newExchange(p, WithBatchSize(1), WithBatchInterval(time.Hour), WithDrainTimeout(100*time.Millisecond))
+
+	bgen := blocksutil.NewBlockGenerator()
+	ex.NotifyNewBlocks(context.Background(), bgen.Blocks(5)...)
+	waitFor(t, "the first provide", func() bool { return len(p.getProvided()) == 1 })
+
+	// Close does not wait for the end of the interval.
+	start := time.Now()
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close took %s", elapsed)
+	}
+	if st := ex.Stats(); st.Provided != 2 || st.Abandoned != 3 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	bgen := blocksutil.NewBlockGenerator()
+	small := bgen.Next()
+	large := blocks.NewBlock(make([]byte, 1024))
+	ident, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}.Sum([]byte("identity"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := blocks.NewBlockWithCid([]byte("identity"), ident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixed := []blocks.Block{small, identity, large}
+
+	t.Run("default", func(t *testing.T) {
+		p := newFakeProvider(0)
+		ex := newExchange(p)
+		ex.NotifyNewBlocks(context.Background(), mixed...)
+		if err := ex.Close(); err != nil {
+			t.Fatal(err)
+		}
+		provided := p.getProvided()
+		if len(provided) != 2 || !provided[0].Equals(small.Cid()) || !provided[1].Equals(large.Cid()) {
+			t.Fatalf("unexpected provides %v", provided)
+		}
+		if st := ex.Stats(); st.Filtered != 1 || st.Provided != 2 {
+			t.Fatalf("unexpected stats %+v", st)
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		p := newFakeProvider(0)
+		ex := newExchange(p, WithFilter(func(c cid.Cid, size int) bool {
+			if c.Prefix().MhType == multihash.IDENTITY {
+				t.Errorf("identity CID %s passed to the filter", c)
+			}
+			return size >= 256
+		}))
+		ex.NotifyNewBlocks(context.Background(), mixed...)
+		if err := ex.Close(); err != nil {
+			t.Fatal(err)
+		}
+		provided := p.getProvided()
+		if len(provided) != 1 || !provided[0].Equals(large.Cid()) {
+			t.Fatalf("unexpected provides %v", provided)
+		}
+		if st := ex.Stats(); st.Filtered != 2 || st.Provided != 1 {
+			t.Fatalf("unexpected stats %+v", st)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		p := newFakeProvider(0)
+		ex := newExchange(p, WithFilter(nil))
+		ex.NotifyNewBlocks(context.Background(), mixed...)
+		if err := ex.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if provided := p.getProvided(); len(provided) != 2 {
+			t.Fatalf("unexpected provides %v", provided)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package helpers
+
+import (
+	"github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+// DepthLimitedSelector returns a selector which matches the nodes of the graph
+// up to depth levels below the root, crossing block boundaries: 0 matches the
+// root only. A negative depth has no limit.
+//
+// The levels are the ones of the nodes of the data model, or of the nodes
+// reified by the fetcher: the links which are the values of a map are one
+// level below it, the ones in a list in a map two levels. With the UnixFS
+// reifier, the entries of a directory are one level below it.
+func DepthLimitedSelector(depth int64) ipld.Node {
+	limit := selector.RecursionLimitNone()
+	if depth >= 0 {
+		// the limit counts the root
+		limit = selector.RecursionLimitDepth(depth + 1)
+	}
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	return ssb.ExploreRecursive(limit, ssb.ExploreUnion(
+		ssb.Matcher(),
+		ssb.ExploreAll(ssb.ExploreRecursiveEdge()),
+	)).Node()
+}
+
+// PathSelector returns a selector which matches the node at the given path
+// from the root, crossing block boundaries, and the whole graph below it if
+// thenRecursive is set. The nodes along the path are visited but not matched.
+//
+// The segments of the path are looked up as the fields of the selectors are,
+// in the nodes as built by the prototype chooser and the reifier of the
+// fetcher: "Links/0/Hash" for the first link of a raw dag-pb node, or the name
+// of an entry with the UnixFS reifier. The UnixFS reifier only reifies the
+// nodes built with the dag-pb prototype, so the prototype chooser of the
+// fetcher must support it, as dagpb.AddSupportToChooser does.
+func PathSelector(path string, thenRecursive bool) ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	spec := ssb.Matcher()
+	if thenRecursive {
+		spec = ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreUnion(
+			ssb.Matcher(),
+			ssb.ExploreAll(ssb.ExploreRecursiveEdge()),
+		))
+	}
+
+	segments := ipld.ParsePath(path).Segments()
+	for i := len(segments) - 1; i >= 0; i-- {
+		next, field := spec, segments[i].String()
+		spec = ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert(field, next)
+		})
+	}
+	return spec.Node()
+}
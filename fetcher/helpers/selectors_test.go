@@ -0,0 +1,157 @@
+package helpers_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/fetcher"
+	"github.com/ipfs/boxo/fetcher/helpers"
+	bsfetcher "github.com/ipfs/boxo/fetcher/impl/blockservice"
+	"github.com/ipfs/boxo/fetcher/testutil"
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	mdtest "github.com/ipfs/boxo/ipld/merkledag/test"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/stretchr/testify/require"
+)
+
+// visits records the blocks loaded and the blocks matched by a fetch.
+type visits struct {
+	mu      sync.Mutex
+	loaded  []cid.Cid
+	matched []cid.Cid
+}
+
+func (v *visits) ctx() context.Context {
+	return fetcher.ContextWithBlockLoadHook(context.Background(), func(lnk ipld.Link) error {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.loaded = append(v.loaded, lnk.(cidlink.Link).Cid)
+		return nil
+	})
+}
+
+func (v *visits) onBlocks() fetcher.FetchCallback {
+	return helpers.OnBlocks(func(res helpers.BlockResult) error {
+		v.matched = append(v.matched, res.Link.(cidlink.Link).Cid)
+		return nil
+	})
+}
+
+func TestSelectorHelpers(t *testing.T) {
+	bsrv := mdtest.Bserv()
+	block := func(build func(na fluent.MapAssembler)) cid.Cid {
+		blk, _, _ := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 2, build))
+		require.NoError(t, bsrv.AddBlock(context.Background(), blk))
+		return blk.Cid()
+	}
+	link := func(c cid.Cid) ipld.Link {
+		return cidlink.Link{Cid: c}
+	}
+
+	// root -> {a, b, list: [a]}, a -> c, b -> {d, e}, c -> f, f -> g
+	g := block(func(na fluent.MapAssembler) { na.AssembleEntry("g").AssignBool(true) })
+	f := block(func(na fluent.MapAssembler) { na.AssembleEntry("g").AssignLink(link(g)) })
+	e := block(func(na fluent.MapAssembler) { na.AssembleEntry("e").AssignBool(true) })
+	d := block(func(na fluent.MapAssembler) { na.AssembleEntry("d").AssignBool(true) })
+	c := block(func(na fluent.MapAssembler) { na.AssembleEntry("f").AssignLink(link(f)) })
+	b := block(func(na fluent.MapAssembler) {
+		na.AssembleEntry("d").AssignLink(link(d))
+		na.AssembleEntry("e").AssignLink(link(e))
+	})
+	a := block(func(na fluent.MapAssembler) { na.AssembleEntry("c").AssignLink(link(c)) })
+	root := block(func(na fluent.MapAssembler) {
+		na.AssembleEntry("a").AssignLink(link(a))
+		na.AssembleEntry("b").AssignLink(link(b))
+		na.AssembleEntry("name").AssignString("root")
+	})
+
+	fc := bsfetcher.NewFetcherConfig(bsrv)
+	for _, tc := range []struct {
+		depth  int64
+		blocks []cid.Cid
+	}{
+		{0, []cid.Cid{root}},
+		{1, []cid.Cid{root, a, b}},
+		{2, []cid.Cid{root, a, c, b, d, e}},
+		{3, []cid.Cid{root, a, c, f, b, d, e}},
+		{-1, []cid.Cid{root, a, c, f, g, b, d, e}},
+	} {
+		var v visits
+		ctx := v.ctx()
+		require.NoError(t, helpers.BlockToDepth(ctx, fc.NewSession(ctx), link(root), tc.depth, v.onBlocks()))
+		require.Equal(t, tc.blocks, v.matched, "depth %d", tc.depth)
+		require.Equal(t, tc.blocks, v.loaded, "depth %d", tc.depth)
+	}
+
+	for _, tc := range []struct {
+		path          string
+		thenRecursive bool
+		matched       []cid.Cid
+		loaded        []cid.Cid
+	}{
+		{"", false, []cid.Cid{root}, []cid.Cid{root}},
+		{"a/c/f", false, []cid.Cid{f}, []cid.Cid{root, a, c, f}},
+		{"a/c/f", true, []cid.Cid{f, g}, []cid.Cid{root, a, c, f, g}},
+		{"/b/", true, []cid.Cid{b, d, e}, []cid.Cid{root, b, d, e}},
+		{"a/missing", true, nil, []cid.Cid{root, a}},
+		{"name", false, nil, []cid.Cid{root}},
+	} {
+		var v visits
+		ctx := v.ctx()
+		require.NoError(t, helpers.BlockAtPath(ctx, fc.NewSession(ctx), link(root), tc.path, tc.thenRecursive, v.onBlocks()))
+		require.Equal(t, tc.matched, v.matched, tc.path)
+		require.Equal(t, tc.loaded, v.loaded, tc.path)
+	}
+}
+
+func TestPathSelectorUnixFS(t *testing.T) {
+	ctx := context.Background()
+	bsrv := mdtest.Bserv()
+	dserv := merkledag.NewDAGService(blockservice.New(bsrv.Blockstore(), bsrv.Exchange()))
+
+	file := merkledag.NodeWithData(ft.FilePBData([]byte("file"), 4))
+	require.NoError(t, dserv.Add(ctx, file))
+	sub := ft.EmptyDirNode()
+	require.NoError(t, sub.AddNodeLink("file", file))
+	require.NoError(t, dserv.Add(ctx, sub))
+	root := ft.EmptyDirNode()
+	require.NoError(t, root.AddNodeLink("sub", sub))
+	require.NoError(t, dserv.Add(ctx, root))
+
+	// the fields of raw dag-pb, or the names of the entries once reified
+	fc := bsfetcher.NewFetcherConfig(bsrv)
+	pbfc := bsfetcher.NewFetcherConfig(bsrv)
+	pbfc.PrototypeChooser = dagpb.AddSupportToChooser(bsfetcher.DefaultPrototypeChooser)
+	for _, tc := range []struct {
+		factory fetcher.Factory
+		path    string
+	}{
+		{fc, "Links/0/Hash/Links/0/Hash"},
+		{pbfc, "Links/0/Hash/Links/0/Hash"},
+		{pbfc.WithReifier(unixfsnode.Reify), "sub/file"},
+	} {
+		var v visits
+		ctx := v.ctx()
+		require.NoError(t, helpers.BlockAtPath(ctx, tc.factory.NewSession(ctx), cidlink.Link{Cid: root.Cid()}, tc.path, false, v.onBlocks()))
+		require.Equal(t, []cid.Cid{file.Cid()}, v.matched, tc.path)
+		require.Equal(t, []cid.Cid{root.Cid(), sub.Cid(), file.Cid()}, v.loaded, tc.path)
+	}
+
+	// the names are not the fields of raw dag-pb, and the nodes are only
+	// reified with the dag-pb prototype
+	for _, factory := range []fetcher.Factory{pbfc, fc.WithReifier(unixfsnode.Reify)} {
+		var v visits
+		ctx := v.ctx()
+		require.NoError(t, helpers.BlockAtPath(ctx, factory.NewSession(ctx), cidlink.Link{Cid: root.Cid()}, "sub/file", false, v.onBlocks()))
+		require.Empty(t, v.matched)
+	}
+}
@@ -5,20 +5,9 @@ import (
 
 	"github.com/ipfs/boxo/fetcher"
 	"github.com/ipld/go-ipld-prime"
-	basicnode "github.com/ipld/go-ipld-prime/node/basic"
-	"github.com/ipld/go-ipld-prime/traversal/selector"
-	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 )
 
-var matchAllSelector ipld.Node
-
-func init() {
-	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
-	matchAllSelector = ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreUnion(
-		ssb.Matcher(),
-		ssb.ExploreAll(ssb.ExploreRecursiveEdge()),
-	)).Node()
-}
+var matchAllSelector = DepthLimitedSelector(-1)
 
 // Block fetches a schemaless node graph corresponding to single block by link.
 func Block(ctx context.Context, f fetcher.Fetcher, link ipld.Link) (ipld.Node, error) {
@@ -40,3 +29,15 @@ func BlockMatching(ctx context.Context, f fetcher.Fetcher, root ipld.Link, match
 func BlockAll(ctx context.Context, f fetcher.Fetcher, root ipld.Link, cb fetcher.FetchCallback) error {
 	return f.BlockMatchingOfType(ctx, root, matchAllSelector, nil, cb)
 }
+
+// BlockToDepth traverses the nodes in the graph linked by root up to depth levels below it, as matched by
+// [DepthLimitedSelector]. The nodes will be untyped and send over the results channel.
+func BlockToDepth(ctx context.Context, f fetcher.Fetcher, root ipld.Link, depth int64, cb fetcher.FetchCallback) error {
+	return f.BlockMatchingOfType(ctx, root, DepthLimitedSelector(depth), nil, cb)
+}
+
+// BlockAtPath traverses the node at path from root, and the graph below it if thenRecursive is set, as matched by
+// [PathSelector]. The nodes will be untyped and send over the results channel.
+func BlockAtPath(ctx context.Context, f fetcher.Fetcher, root ipld.Link, path string, thenRecursive bool, cb fetcher.FetchCallback) error {
+	return f.BlockMatchingOfType(ctx, root, PathSelector(path, thenRecursive), nil, cb)
+}
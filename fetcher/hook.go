@@ -0,0 +1,27 @@
+package fetcher
+
+import (
+	"context"
+
+	"github.com/ipld/go-ipld-prime"
+)
+
+type blockLoadHookKey struct{}
+
+// BlockLoadHook is called by the fetchers before loading each block. The load
+// fails with its error if it returns one.
+type BlockLoadHook func(ipld.Link) error
+
+// ContextWithBlockLoadHook returns a context under which the sessions created
+// by the fetcher factories call hook before loading each block, so that the
+// blocks loaded on behalf of a caller can be counted or limited.
+func ContextWithBlockLoadHook(ctx context.Context, hook BlockLoadHook) context.Context {
+	return context.WithValue(ctx, blockLoadHookKey{}, hook)
+}
+
+// BlockLoadHookFromContext returns the hook set with ContextWithBlockLoadHook,
+// or nil if there is none.
+func BlockLoadHookFromContext(ctx context.Context) BlockLoadHook {
+	hook, _ := ctx.Value(blockLoadHookKey{}).(BlockLoadHook)
+	return hook
+}
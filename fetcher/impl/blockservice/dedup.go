@@ -0,0 +1,277 @@
+package bsfetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/fetcher"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+)
+
+// DefaultDeduplicationBuffer is the default number of results of a shared
+// traversal buffered for its subscribers, see [FetcherConfig.WithDeduplication].
+const DefaultDeduplicationBuffer = 1024
+
+// WithDeduplication derives a fetcher factory from the same source whose
+// sessions share the concurrent identical traversals of BlockMatchingOfType:
+// the ones of the same root with the same selector run a single walk, and so
+// fetch each block once, whose results are passed to the callback of each
+// subscriber.
+//
+// The results are buffered, up to buffer results or DefaultDeduplicationBuffer
+// if buffer is not positive, so that each subscriber consumes them at its own
+// pace: the walk waits for the slowest subscriber once the buffer is full. A
+// traversal joins a walk in progress only if none of its results has been
+// dropped from the buffer yet, in which case they are replayed to it; it runs
+// its own walk otherwise.
+//
+// The shared walk runs with its own blockservice session, and is canceled
+// once all its subscribers have returned. The [fetcher.BlockLoadHook] of each
+// subscriber is called for the blocks loaded by the walk as the results are
+// replayed to it, before the results following them, and an error of the
+// hook ends the traversal of its subscriber alone. The traversals with a
+// context carrying a [fetcher.Progress] are not shared, as the blocks are
+// only fetched once, nor the ones with a context made by
+// [blockservice.ContextWithLocalOnly] or [blockservice.ContextWithSession],
+// which the shared walk would not honour.
+func (fc FetcherConfig) WithDeduplication(buffer int) FetcherConfig {
+	if buffer <= 0 {
+		buffer = DefaultDeduplicationBuffer
+	}
+	fc.walks = &walkGroup{
+		buffer: buffer,
+		walks:  make(map[walkKey]*sharedWalk),
+	}
+	return fc
+}
+
+// shareable reports whether the traversal of ctx can be shared.
+func shareable(ctx context.Context) bool {
+	return fetcher.ProgressFromContext(ctx) == nil &&
+		!blockservice.IsLocalOnly(ctx) &&
+		blockservice.SessionFromContext(ctx) == nil
+}
+
+type walkKey struct {
+	root     string
+	selector [sha256.Size]byte
+}
+
+// walkGroup holds the shared walks in progress of a FetcherConfig.
+type walkGroup struct {
+	buffer int
+
+	mu    sync.Mutex
+	walks map[walkKey]*sharedWalk
+}
+
+// sharedWalk is a walk whose results are passed to several subscribers.
+type sharedWalk struct {
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// results holds the results from the index base.
+	results []walkResult
+	base    int
+	// loaded holds the blocks loaded since the last result, which are
+	// passed with the next one, or with the end of the walk.
+	loaded []ipld.Link
+	// subscribers holds the index of the next result of each subscriber.
+	subscribers map[*int]struct{}
+	finished    bool
+	err         error
+	// changed is closed, and replaced, on each change of the walk.
+	changed chan struct{}
+	// stopped is closed once the walk returns.
+	stopped chan struct{}
+}
+
+// walkResult is a result of a shared walk, with the blocks loaded before it.
+type walkResult struct {
+	loaded []ipld.Link
+	res    fetcher.FetchResult
+}
+
+func (w *sharedWalk) notifyLocked() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// blockMatching runs the traversal of BlockMatchingOfType in the shared walk
+// of root and match, or in a new one. own runs the traversal without sharing.
+func (g *walkGroup) blockMatching(ctx context.Context, fc FetcherConfig, root ipld.Link, match ipld.Node, cb fetcher.FetchCallback, own func() error) error {
+	encoded, err := ipld.Encode(match, dagcbor.Encode)
+	if err != nil {
+		return own()
+	}
+	key := walkKey{root: root.Binary(), selector: sha256.Sum256(encoded)}
+
+	next := new(int)
+	g.mu.Lock()
+	w, ok := g.walks[key]
+	if ok {
+		w.mu.Lock()
+		joined := w.base == 0 && !w.finished
+		if joined {
+			w.subscribers[next] = struct{}{}
+		}
+		w.mu.Unlock()
+		if !joined {
+			g.mu.Unlock()
+			return own()
+		}
+	} else {
+		w = g.startLocked(fc, key, root, match, next)
+	}
+	g.mu.Unlock()
+
+	return g.subscribe(ctx, key, w, next, cb)
+}
+
+// startLocked starts the shared walk of key, with sub as its first subscriber.
+func (g *walkGroup) startLocked(fc FetcherConfig, key walkKey, root ipld.Link, match ipld.Node, sub *int) *sharedWalk {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &sharedWalk{
+		cancel:      cancel,
+		subscribers: map[*int]struct{}{sub: {}},
+		changed:     make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	g.walks[key] = w
+
+	// the walk itself is not shared
+	fc.walks = nil
+	f := fc.NewSession(fetcher.ContextWithBlockLoadHook(ctx, func(lnk ipld.Link) error {
+		w.mu.Lock()
+		w.loaded = append(w.loaded, lnk)
+		w.mu.Unlock()
+		return nil
+	}))
+	go func() {
+		defer close(w.stopped)
+		defer cancel()
+		err := f.BlockMatchingOfType(ctx, root, match, nil, func(res fetcher.FetchResult) error {
+			return g.publish(ctx, w, res)
+		})
+
+		g.mu.Lock()
+		if g.walks[key] == w {
+			delete(g.walks, key)
+		}
+		g.mu.Unlock()
+		w.mu.Lock()
+		w.finished = true
+		w.err = err
+		w.notifyLocked()
+		w.mu.Unlock()
+	}()
+	return w
+}
+
+// publish adds res to the results of w, once the buffer has room for it.
+func (g *walkGroup) publish(ctx context.Context, w *sharedWalk, res fetcher.FetchResult) error {
+	w.mu.Lock()
+	for len(w.results) == g.buffer {
+		// drop the results consumed by all the subscribers
+		consumed := w.base + len(w.results)
+		for sub := range w.subscribers {
+			if *sub < consumed {
+				consumed = *sub
+			}
+		}
+		if consumed > w.base {
+			// the results are not replayed to late subscribers anymore
+			w.results = append(w.results[:0], w.results[consumed-w.base:]...)
+			w.base = consumed
+			break
+		}
+
+		changed := w.changed
+		w.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		w.mu.Lock()
+	}
+	w.results = append(w.results, walkResult{loaded: w.loaded, res: res})
+	w.loaded = nil
+	w.notifyLocked()
+	w.mu.Unlock()
+	return nil
+}
+
+// subscribe passes the results of w to cb, from the index next, until the end
+// of the walk, an error of cb or of the block load hook of ctx, or the
+// cancellation of ctx.
+func (g *walkGroup) subscribe(ctx context.Context, key walkKey, w *sharedWalk, next *int, cb fetcher.FetchCallback) error {
+	defer g.unsubscribe(key, w, next)
+
+	hook := fetcher.BlockLoadHookFromContext(ctx)
+	loaded := func(lnks []ipld.Link) error {
+		if hook == nil {
+			return nil
+		}
+		for _, lnk := range lnks {
+			if err := hook(lnk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		w.mu.Lock()
+		if *next < w.base+len(w.results) {
+			wr := w.results[*next-w.base]
+			*next++
+			w.notifyLocked()
+			w.mu.Unlock()
+			if err := loaded(wr.loaded); err != nil {
+				return err
+			}
+			if err := cb(wr.res); err != nil {
+				return err
+			}
+			continue
+		}
+		if w.finished {
+			lnks, err := w.loaded, w.err
+			w.mu.Unlock()
+			if herr := loaded(lnks); herr != nil {
+				return herr
+			}
+			return err
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// unsubscribe removes next from the subscribers of w, and cancels w if it was
+// the last one.
+func (g *walkGroup) unsubscribe(key walkKey, w *sharedWalk, next *int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.subscribers, next)
+	if len(w.subscribers) == 0 && !w.finished {
+		if g.walks[key] == w {
+			delete(g.walks, key)
+		}
+		w.cancel()
+	}
+	w.notifyLocked()
+}
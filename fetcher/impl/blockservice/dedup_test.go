@@ -0,0 +1,355 @@
+package bsfetcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/fetcher"
+	"github.com/ipfs/boxo/fetcher/helpers"
+	"github.com/ipfs/boxo/fetcher/testutil"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedBlockstore counts the blocks read, which wait for the gate to open.
+type gatedBlockstore struct {
+	blockstore.Blockstore
+	gate chan struct{}
+
+	mu   sync.Mutex
+	gets map[cid.Cid]int
+}
+
+func (bs *gatedBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	bs.mu.Lock()
+	bs.gets[c]++
+	bs.mu.Unlock()
+	select {
+	case <-bs.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func (bs *gatedBlockstore) counts() map[cid.Cid]int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	counts := make(map[cid.Cid]int, len(bs.gets))
+	for c, n := range bs.gets {
+		counts[c] = n
+	}
+	return counts
+}
+
+// dedupFixture returns a gated blockstore with a root linking to n blocks.
+func dedupFixture(t *testing.T, n int) (*gatedBlockstore, ipld.Link, []cid.Cid) {
+	bs := &gatedBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())),
+		gate:       make(chan struct{}),
+		gets:       make(map[cid.Cid]int),
+	}
+	var leaves []ipld.Link
+	for i := 0; i < n; i++ {
+		blk, _, lnk := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 1, func(na fluent.MapAssembler) {
+			na.AssembleEntry("leaf").AssignInt(int64(i))
+		}))
+		require.NoError(t, bs.Blockstore.Put(context.Background(), blk))
+		leaves = append(leaves, lnk)
+	}
+	blk, _, root := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 1, func(na fluent.MapAssembler) {
+		na.AssembleEntry("leaves").CreateList(int64(n), func(la fluent.ListAssembler) {
+			for _, lnk := range leaves {
+				la.AssembleValue().AssignLink(lnk)
+			}
+		})
+	}))
+	require.NoError(t, bs.Blockstore.Put(context.Background(), blk))
+
+	cids := []cid.Cid{root.(cidlink.Link).Cid}
+	for _, lnk := range leaves {
+		cids = append(cids, lnk.(cidlink.Link).Cid)
+	}
+	return bs, root, cids
+}
+
+// waitSubscribers waits for the shared walk of root to have n subscribers.
+func waitSubscribers(t *testing.T, g *walkGroup, n int) *sharedWalk {
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, w := range g.walks {
+			w.mu.Lock()
+			count := len(w.subscribers)
+			w.mu.Unlock()
+			if count == n {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, time.Millisecond)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, w := range g.walks {
+		return w
+	}
+	return nil
+}
+
+// fetchBlocks returns the CIDs of the blocks matched by BlockAll.
+func fetchBlocks(ctx context.Context, f fetcher.Fetcher, root ipld.Link) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	err := helpers.BlockAll(ctx, f, root, helpers.OnBlocks(func(res helpers.BlockResult) error {
+		cids = append(cids, res.Link.(cidlink.Link).Cid)
+		return nil
+	}))
+	return cids, err
+}
+
+func TestDeduplication(t *testing.T) {
+	bs, root, cids := dedupFixture(t, 10)
+	fc := NewFetcherConfig(blockservice.New(bs, offline.Exchange(bs))).WithDeduplication(0)
+
+	const n = 50
+	var wg sync.WaitGroup
+	results := make([][]cid.Cid, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			results[i], errs[i] = fetchBlocks(ctx, fc.NewSession(ctx), root)
+		}(i)
+	}
+	w := waitSubscribers(t, fc.walks, n)
+	close(bs.gate)
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, cids, results[i])
+	}
+	// a single set of block fetches
+	for _, c := range cids {
+		require.Equal(t, 1, bs.counts()[c], c)
+	}
+	<-w.stopped
+	require.Empty(t, fc.walks.walks)
+
+	// the traversals with a progress are not shared
+	ctx := fetcher.ContextWithProgress(context.Background(), &fetcher.Progress{})
+	got, err := fetchBlocks(ctx, fc.NewSession(ctx), root)
+	require.NoError(t, err)
+	require.Equal(t, cids, got)
+	require.Equal(t, 2, bs.counts()[cids[0]])
+}
+
+func TestDeduplicationBlockLoadHook(t *testing.T) {
+	bs, root, cids := dedupFixture(t, 10)
+	fc := NewFetcherConfig(blockservice.New(bs, offline.Exchange(bs))).WithDeduplication(0)
+
+	// each subscriber sees the blocks loaded by the shared walk, and the
+	// one whose hook fails stops alone
+	errBudget := errors.New("budget exceeded")
+	const n = 5
+	var wg sync.WaitGroup
+	loaded := make([][]cid.Cid, n)
+	results := make([][]cid.Cid, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := fetcher.ContextWithBlockLoadHook(context.Background(), func(lnk ipld.Link) error {
+				if i == 0 && len(loaded[i]) == 3 {
+					return errBudget
+				}
+				loaded[i] = append(loaded[i], lnk.(cidlink.Link).Cid)
+				return nil
+			})
+			results[i], errs[i] = fetchBlocks(ctx, fc.NewSession(ctx), root)
+		}(i)
+	}
+	waitSubscribers(t, fc.walks, n)
+	close(bs.gate)
+	wg.Wait()
+
+	require.ErrorIs(t, errs[0], errBudget)
+	require.Equal(t, cids[:3], loaded[0])
+	require.Len(t, results[0], 3)
+	for i := 1; i < n; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, cids, loaded[i])
+		require.Equal(t, cids, results[i])
+	}
+	for _, c := range cids {
+		require.Equal(t, 1, bs.counts()[c], c)
+	}
+}
+
+func TestDeduplicationLateSubscriber(t *testing.T) {
+	bs, root, cids := dedupFixture(t, 10)
+	// room for the results of the root and two leaves
+	fc := NewFetcherConfig(blockservice.New(bs, offline.Exchange(bs))).WithDeduplication(5)
+
+	// a slow subscriber which consumes the results one by one
+	step := make(chan struct{})
+	first := make(chan []cid.Cid)
+	go func() {
+		var got []cid.Cid
+		ctx := context.Background()
+		_ = helpers.BlockAll(ctx, fc.NewSession(ctx), root, func(res fetcher.FetchResult) error {
+			<-step
+			if res.LastBlockPath.String() == res.Path.String() {
+				got = append(got, res.LastBlockLink.(cidlink.Link).Cid)
+			}
+			return nil
+		})
+		first <- got
+	}()
+	w := waitSubscribers(t, fc.walks, 1)
+	close(bs.gate)
+
+	// the buffer is full, then the first result is consumed and dropped
+	require.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return len(w.results) == 5
+	}, 10*time.Second, time.Millisecond)
+	step <- struct{}{}
+	require.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.base > 0
+	}, 10*time.Second, time.Millisecond)
+
+	// the late subscriber runs its own walk
+	ctx := context.Background()
+	got, err := fetchBlocks(ctx, fc.NewSession(ctx), root)
+	require.NoError(t, err)
+	require.Equal(t, cids, got)
+	require.Equal(t, 2, bs.counts()[cids[0]])
+
+	close(step)
+	require.Equal(t, cids, <-first)
+}
+
+func TestDeduplicationCancellation(t *testing.T) {
+	bs, root, cids := dedupFixture(t, 10)
+	fc := NewFetcherConfig(blockservice.New(bs, offline.Exchange(bs))).WithDeduplication(0)
+
+	// a subscriber leaving does not stop the walk of the others
+	errCallback := errors.New("callback error")
+	leaving := make(chan error)
+	staying := make(chan []cid.Cid)
+	go func() {
+		ctx := context.Background()
+		leaving <- helpers.BlockAll(ctx, fc.NewSession(ctx), root, func(fetcher.FetchResult) error {
+			return errCallback
+		})
+	}()
+	go func() {
+		ctx := context.Background()
+		got, err := fetchBlocks(ctx, fc.NewSession(ctx), root)
+		require.NoError(t, err)
+		staying <- got
+	}()
+	waitSubscribers(t, fc.walks, 2)
+	close(bs.gate)
+	require.ErrorIs(t, <-leaving, errCallback)
+	require.Equal(t, cids, <-staying)
+
+	// the walk is canceled once its last subscriber is
+	bs.gate = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := fetchBlocks(ctx, fc.NewSession(ctx), root)
+		canceled <- err
+	}()
+	w := waitSubscribers(t, fc.walks, 1)
+	cancel()
+	require.ErrorIs(t, <-canceled, context.Canceled)
+	select {
+	case <-w.stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the walk was not canceled")
+	}
+	require.Empty(t, fc.walks.walks)
+}
+
+// countingExchange counts the blocks requested from the exchange.
+type countingExchange struct {
+	exchange.Interface
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (e *countingExchange) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	e.mu.Lock()
+	e.calls++
+	e.mu.Unlock()
+	return e.Interface.GetBlock(ctx, c)
+}
+
+func (e *countingExchange) GetBlocks(ctx context.Context, cids []cid.Cid) (<-chan blocks.Block, error) {
+	e.mu.Lock()
+	e.calls++
+	e.mu.Unlock()
+	return e.Interface.GetBlocks(ctx, cids)
+}
+
+func TestDeduplicationNotShared(t *testing.T) {
+	t.Run("local only", func(t *testing.T) {
+		bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+		ex := &countingExchange{Interface: offline.Exchange(bs)}
+		fc := NewFetcherConfig(blockservice.New(bs, ex)).WithDeduplication(0)
+
+		_, _, missing := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 1, func(na fluent.MapAssembler) {
+			na.AssembleEntry("missing").AssignBool(true)
+		}))
+		ctx := blockservice.ContextWithLocalOnly(context.Background())
+		_, err := fetchBlocks(ctx, fc.NewSession(ctx), missing)
+		require.Error(t, err)
+		require.Zero(t, ex.calls)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		bs, root, cids := dedupFixture(t, 10)
+		bsrv := blockservice.New(bs, offline.Exchange(bs))
+		fc := NewFetcherConfig(bsrv).WithDeduplication(0)
+
+		ctx := blockservice.ContextWithSession(context.Background(), blockservice.NewSession(context.Background(), bsrv))
+		done := make(chan []cid.Cid)
+		go func() {
+			got, err := fetchBlocks(ctx, fc.NewSession(ctx), root)
+			require.NoError(t, err)
+			done <- got
+		}()
+		require.Eventually(t, func() bool {
+			return bs.counts()[root.(cidlink.Link).Cid] > 0
+		}, 10*time.Second, time.Millisecond)
+		fc.walks.mu.Lock()
+		require.Empty(t, fc.walks.walks)
+		fc.walks.mu.Unlock()
+		close(bs.gate)
+		require.Equal(t, cids, <-done)
+	})
+}
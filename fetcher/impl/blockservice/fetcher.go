@@ -5,9 +5,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/ipfs/boxo/blockservice"
 	"github.com/ipfs/boxo/fetcher"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
@@ -19,6 +23,9 @@ import (
 type fetcherSession struct {
 	linkSystem   ipld.LinkSystem
 	protoChooser traversal.LinkTargetNodePrototypeChooser
+
+	fc    FetcherConfig
+	walks *walkGroup
 }
 
 // FetcherConfig defines a configuration object from which Fetcher instances are constructed
@@ -26,6 +33,8 @@ type FetcherConfig struct {
 	blockService     blockservice.BlockService
 	NodeReifier      ipld.NodeReifier
 	PrototypeChooser traversal.LinkTargetNodePrototypeChooser
+
+	walks *walkGroup
 }
 
 // NewFetcherConfig creates a FetchConfig from which session may be created and nodes retrieved.
@@ -37,9 +46,19 @@ func NewFetcherConfig(blockService blockservice.BlockService) FetcherConfig {
 }
 
 // NewSession creates a session from which nodes may be retrieved.
-// The session ends when the provided context is canceled.
+// The session ends when the provided context is canceled. The blockservice
+// session attached to ctx with [blockservice.ContextWithSession] is used if
+// there is one, and the hook attached with [fetcher.ContextWithBlockLoadHook]
+// is called before loading each block. The blocks loaded are reported to the
+// [fetcher.Progress] attached with [fetcher.ContextWithProgress], if there is
+// one, which asks the blockservice for a local copy of each block before
+// fetching it, to tell the cache hits apart.
 func (fc FetcherConfig) NewSession(ctx context.Context) fetcher.Fetcher {
-	return fc.FetcherWithSession(ctx, blockservice.NewSession(ctx, fc.blockService))
+	s := blockservice.SessionFromContext(ctx)
+	if s == nil {
+		s = blockservice.NewSession(ctx, fc.blockService)
+	}
+	return fc.FetcherWithSession(ctx, s)
 }
 
 func (fc FetcherConfig) FetcherWithSession(ctx context.Context, s *blockservice.Session) fetcher.Fetcher {
@@ -51,17 +70,22 @@ func (fc FetcherConfig) FetcherWithSession(ctx context.Context, s *blockservice.
 	ls.NodeReifier = fc.NodeReifier
 
 	protoChooser := fc.PrototypeChooser
-	return &fetcherSession{linkSystem: ls, protoChooser: protoChooser}
+	return &fetcherSession{linkSystem: ls, protoChooser: protoChooser, fc: fc, walks: fc.walks}
 }
 
 // WithReifier derives a different fetcher factory from the same source but
 // with a chosen NodeReifier for pathing semantics.
 func (fc FetcherConfig) WithReifier(nr ipld.NodeReifier) fetcher.Factory {
-	return FetcherConfig{
+	nfc := FetcherConfig{
 		blockService:     fc.blockService,
 		NodeReifier:      nr,
 		PrototypeChooser: fc.PrototypeChooser,
 	}
+	// the walks of another reifier are not the same
+	if fc.walks != nil {
+		nfc = nfc.WithDeduplication(fc.walks.buffer)
+	}
+	return nfc
 }
 
 // interface check
@@ -103,6 +127,15 @@ func (f *fetcherSession) NodeMatching(ctx context.Context, node ipld.Node, match
 func (f *fetcherSession) BlockMatchingOfType(ctx context.Context, root ipld.Link, match ipld.Node,
 	_ ipld.NodePrototype, cb fetcher.FetchCallback,
 ) error {
+	if f.walks != nil && shareable(ctx) {
+		return f.walks.blockMatching(ctx, f.fc, root, match, cb, func() error {
+			return f.blockMatchingOfType(ctx, root, match, cb)
+		})
+	}
+	return f.blockMatchingOfType(ctx, root, match, cb)
+}
+
+func (f *fetcherSession) blockMatchingOfType(ctx context.Context, root ipld.Link, match ipld.Node, cb fetcher.FetchCallback) error {
 	// retrieve first node
 	prototype, err := f.PrototypeFromLink(root)
 	if err != nil {
@@ -132,11 +165,26 @@ var DefaultPrototypeChooser = func(lnk ipld.Link, lnkCtx ipld.LinkContext) (ipld
 }
 
 func blockOpener(ctx context.Context, bs *blockservice.Session) ipld.BlockReadOpener {
+	hook := fetcher.BlockLoadHookFromContext(ctx)
+	progress := fetcher.ProgressFromContext(ctx)
 	return func(_ ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
 		cidLink, ok := lnk.(cidlink.Link)
 		if !ok {
 			return nil, fmt.Errorf("invalid link type for loading: %v", lnk)
 		}
+		if hook != nil {
+			if err := hook(lnk); err != nil {
+				return nil, err
+			}
+		}
+
+		if progress != nil {
+			blk, err := getBlockWithProgress(ctx, bs, cidLink.Cid, progress)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(blk.RawData()), nil
+		}
 
 		blk, err := bs.GetBlock(ctx, cidLink.Cid)
 		if err != nil {
@@ -146,3 +194,20 @@ func blockOpener(ctx context.Context, bs *blockservice.Session) ipld.BlockReadOp
 		return bytes.NewReader(blk.RawData()), nil
 	}
 }
+
+// getBlockWithProgress gets the block of c, from the blockstore if it has it
+// or else from the exchange, and reports it to progress.
+func getBlockWithProgress(ctx context.Context, bs *blockservice.Session, c cid.Cid, progress *fetcher.Progress) (blocks.Block, error) {
+	start := time.Now()
+	cached := true
+	blk, err := bs.GetBlock(blockservice.ContextWithLocalOnly(ctx), c)
+	if format.IsNotFound(err) && !blockservice.IsLocalOnly(ctx) {
+		cached = false
+		blk, err = bs.GetBlock(ctx, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	progress.BlockLoaded(c, len(blk.RawData()), cached, time.Since(start))
+	return blk, nil
+}
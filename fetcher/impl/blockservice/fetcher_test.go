@@ -18,6 +18,7 @@ import (
 	"github.com/ipfs/boxo/fetcher/testutil"
 	mockrouting "github.com/ipfs/boxo/routing/mock"
 	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	delay "github.com/ipfs/go-ipfs-delay"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/fluent"
@@ -363,3 +364,79 @@ func TestNodeReification(t *testing.T) {
 	underlying4 := retrievedNode4.(*selfLoader).Node
 	assert.Equal(t, node4, underlying4)
 }
+
+func TestFetchProgress(t *testing.T) {
+	block3, _, link3 := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 1, func(na fluent.MapAssembler) {
+		na.AssembleEntry("three").AssignBool(true)
+	}))
+	block4, _, link4 := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 1, func(na fluent.MapAssembler) {
+		na.AssembleEntry("four").AssignString("four")
+	}))
+	block2, _, link2 := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 2, func(na fluent.MapAssembler) {
+		na.AssembleEntry("link3").AssignLink(link3)
+		na.AssembleEntry("link4").AssignLink(link4)
+	}))
+	block1, _, _ := testutil.EncodeBlock(fluent.MustBuildMap(basicnode.Prototype__Map{}, 2, func(na fluent.MapAssembler) {
+		na.AssembleEntry("link2").AssignLink(link2)
+		na.AssembleEntry("nonlink").AssignString("zoo")
+	}))
+
+	net := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(0*time.Millisecond))
+	ig := testinstance.NewTestInstanceGenerator(net, nil, nil)
+	defer ig.Close()
+
+	peers := ig.Instances(2)
+	hasBlock := peers[0]
+	defer hasBlock.Exchange.Close()
+
+	all := []blocks.Block{block1, block2, block3, block4}
+	err := hasBlock.Blockstore().PutMany(bg, all)
+	require.NoError(t, err)
+	err = hasBlock.Exchange.NotifyNewBlocks(bg, all...)
+	require.NoError(t, err)
+
+	wantsBlock := peers[1]
+	defer wantsBlock.Exchange.Close()
+
+	// the root is a cache hit, the other blocks are fetched from the exchange
+	err = wantsBlock.Blockstore().Put(bg, block1)
+	require.NoError(t, err)
+
+	wantsGetter := blockservice.New(wantsBlock.Blockstore(), wantsBlock.Exchange)
+	fetcherConfig := bsfetcher.NewFetcherConfig(wantsGetter)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var loaded []blocks.Block
+	progress := &fetcher.Progress{}
+	progress.OnBlock = func(c cid.Cid, size int) {
+		// the blocks are reported from the traversal, before they are visited
+		require.Equal(t, len(loaded)+1, progress.Stats().BlocksFetched)
+		blk, err := wantsBlock.Blockstore().Get(bg, c)
+		require.NoError(t, err)
+		require.Equal(t, len(blk.RawData()), size)
+		loaded = append(loaded, blk)
+	}
+	ctx = fetcher.ContextWithProgress(ctx, progress)
+	session := fetcherConfig.NewSession(ctx)
+
+	var visited []blocks.Block
+	err = helpers.BlockAll(ctx, session, cidlink.Link{Cid: block1.Cid()}, helpers.OnBlocks(func(res helpers.BlockResult) error {
+		visited = append(visited, all[len(visited)])
+		require.Equal(t, visited[len(visited)-1].Cid(), res.Link.(cidlink.Link).Cid)
+		require.Len(t, loaded, len(visited))
+		return nil
+	}))
+	require.NoError(t, err)
+	require.Equal(t, all, loaded)
+
+	var size int64
+	for _, blk := range all {
+		size += int64(len(blk.RawData()))
+	}
+	stats := progress.Stats()
+	require.Equal(t, 4, stats.BlocksFetched)
+	require.Equal(t, size, stats.BytesFetched)
+	require.Equal(t, 1, stats.CacheHits)
+	require.Positive(t, stats.Duration)
+}
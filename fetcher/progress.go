@@ -0,0 +1,79 @@
+package fetcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+type progressKey struct{}
+
+// Stats are the statistics of the blocks loaded under a context of
+// [ContextWithProgress].
+type Stats struct {
+	// BlocksFetched is the number of blocks loaded.
+	BlocksFetched int
+	// BytesFetched is the total size of the blocks loaded.
+	BytesFetched int64
+	// Duration is the time spent loading the blocks.
+	Duration time.Duration
+	// CacheHits is the number of blocks loaded which were already stored
+	// locally, as opposed to fetched from the exchange.
+	CacheHits int
+}
+
+// Progress reports the blocks loaded by the fetcher sessions under the
+// contexts of [ContextWithProgress]. The zero Progress only collects the
+// [Stats]. A Progress may be reused for several traversals, whose statistics
+// then add up.
+type Progress struct {
+	// OnBlock, if not nil, is called with the CID and the size of each block
+	// loaded, in traversal order. It is called synchronously from the
+	// traversal, which it holds up: it must be fast.
+	OnBlock func(c cid.Cid, size int)
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// BlockLoaded records a block of size bytes loaded in d, cached if it was
+// already stored locally. It is called by the fetchers after loading each
+// block, and calls OnBlock.
+func (p *Progress) BlockLoaded(c cid.Cid, size int, cached bool, d time.Duration) {
+	p.mu.Lock()
+	p.stats.BlocksFetched++
+	p.stats.BytesFetched += int64(size)
+	p.stats.Duration += d
+	if cached {
+		p.stats.CacheHits++
+	}
+	p.mu.Unlock()
+
+	if p.OnBlock != nil {
+		p.OnBlock(c, size)
+	}
+}
+
+// Stats returns the statistics of the blocks loaded so far.
+func (p *Progress) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// ContextWithProgress returns a context under which the sessions created by
+// the fetcher factories report each block loaded to p, so that the progress
+// of long traversals can be followed, and their statistics retrieved with
+// [Progress.Stats] once they return.
+func ContextWithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// ProgressFromContext returns the Progress set with ContextWithProgress, or
+// nil if there is none.
+func ProgressFromContext(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 
@@ -32,8 +31,17 @@ var FilestorePrefix = ds.NewKey("filestore")
 type FileManager struct {
 	AllowFiles bool
 	AllowUrls  bool
-	ds         ds.Batching
-	root       string
+
+	// URLConfig configures the HTTP requests reading the blocks backed
+	// by URLs.
+	URLConfig URLConfig
+
+	// URLPrefixConfigs replaces URLConfig for the URLs starting with one
+	// of its keys. The longest matching prefix is used.
+	URLPrefixConfigs map[string]URLConfig
+
+	ds   ds.Batching
+	root string
 }
 
 // CorruptReferenceError implements the error interface.
@@ -51,6 +59,11 @@ func (c CorruptReferenceError) Error() string {
 	return c.Err.Error()
 }
 
+// Unwrap returns the error in the CorruptReferenceError.
+func (c CorruptReferenceError) Unwrap() error {
+	return c.Err
+}
+
 // NewFileManager initializes a new file manager with the given
 // datastore and root. All FilestoreNodes paths are relative to the
 // root path given here, which is prepended for any operations.
@@ -220,33 +233,11 @@ func (f *FileManager) readURLDataObj(ctx context.Context, m mh.Multihash, d *pb.
 		return nil, ErrUrlstoreNotEnabled
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", d.GetFilePath(), nil)
+	outbuf, err := f.urlConfig(d.GetFilePath()).fetchRange(ctx, d.GetFilePath(), d.GetOffset(), d.GetSize_())
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", d.GetOffset(), d.GetOffset()+d.GetSize_()-1))
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, &CorruptReferenceError{StatusFileError, err}
-	}
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
-		return nil, &CorruptReferenceError{
-			StatusFileError,
-			fmt.Errorf("expected HTTP 200 or 206 got %d", res.StatusCode),
-		}
-	}
-
-	outbuf := make([]byte, d.GetSize_())
-	_, err = io.ReadFull(res.Body, outbuf)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return nil, &CorruptReferenceError{StatusFileChanged, err}
-	} else if err != nil {
-		return nil, &CorruptReferenceError{StatusFileError, err}
-	}
-	res.Body.Close()
-
 	// Work with CIDs for this, as they are a nice wrapper and things
 	// will not break if multihashes underlying types change.
 	origCid := cid.NewCidV1(cid.Raw, m)
@@ -265,6 +256,17 @@ func (f *FileManager) readURLDataObj(ctx context.Context, m mh.Multihash, d *pb.
 	return outbuf, nil
 }
 
+func readFull(r io.Reader, size uint64) ([]byte, error) {
+	outbuf := make([]byte, size)
+	_, err := io.ReadFull(r, outbuf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, &CorruptReferenceError{StatusFileChanged, err}
+	} else if err != nil {
+		return nil, &CorruptReferenceError{StatusFileError, err}
+	}
+	return outbuf, nil
+}
+
 // Has returns if the FileManager is storing a block reference. It does not
 // validate the data, nor checks if the reference is valid.
 func (f *FileManager) Has(ctx context.Context, c cid.Cid) (bool, error) {
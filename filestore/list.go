@@ -0,0 +1,184 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// listPageSize is the number of references read by each datastore query of
+// Filestore.List.
+const listPageSize = 1024
+
+// ListOptions configures Filestore.List.
+type ListOptions struct {
+	// Verify reads and hashes the data of the references to report their
+	// status. Otherwise the status is StatusOk, unless the reference
+	// itself is corrupt.
+	Verify bool
+
+	// PathPrefix only lists the references to the files under this path,
+	// or to the URLs starting with it. An absolute path under the root of
+	// the FileManager is made relative to it, as the paths are stored.
+	PathPrefix string
+
+	// Status only lists the references with one of these statuses.
+	Status []Status
+
+	// Limit is the maximum number of references listed. There is no limit
+	// if zero.
+	Limit int
+
+	// Cursor resumes a listing after the ListEntry of a previous one,
+	// given its Cursor. The references are listed in the order of their
+	// keys.
+	Cursor string
+}
+
+// ListEntry is a reference of the FileManager listed by Filestore.List.
+type ListEntry struct {
+	ListRes
+
+	// Cursor is given to ListOptions.Cursor to resume the listing after
+	// this reference.
+	Cursor string
+}
+
+// List streams the references of the FileManager in the order of their
+// keys, filtered as the options say. The datastore is queried one page of
+// references at a time, so that the memory used does not grow with the
+// number of references. The channel is closed once all the references were
+// listed, or when the context is cancelled.
+//
+// The references filtered out by PathPrefix are not verified.
+func (f *Filestore) List(ctx context.Context, opts ListOptions) (<-chan ListEntry, error) {
+	prefix := opts.PathPrefix
+	if prefix != "" && !IsURL(prefix) {
+		if filepath.IsAbs(prefix) {
+			rel, err := filepath.Rel(f.fm.root, prefix)
+			if err != nil {
+				return nil, err
+			}
+			prefix = rel
+		}
+		prefix = strings.TrimSuffix(filepath.ToSlash(prefix), "/")
+		if prefix == "." {
+			prefix = ""
+		}
+	}
+
+	var statuses map[Status]bool
+	if len(opts.Status) > 0 {
+		statuses = make(map[Status]bool, len(opts.Status))
+		for _, s := range opts.Status {
+			statuses[s] = true
+		}
+	}
+
+	// check the first page now, to return the errors of the query
+	qr, err := f.listPage(ctx, opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan ListEntry, dsq.KeysOnlyBufSize)
+	go func() {
+		defer close(out)
+
+		var listed int
+		for {
+			var read int
+			var last string
+			for v := range qr.Next() {
+				read++
+				last = v.Key
+
+				if v.Error != nil {
+					select {
+					case out <- ListEntry{ListRes: ListRes{Status: StatusOtherError, ErrorMsg: v.Error.Error()}}:
+					case <-ctx.Done():
+					}
+					qr.Close()
+					return
+				}
+
+				res, ok := f.listEntry(ctx, v, prefix, opts.Verify)
+				if !ok || (statuses != nil && !statuses[res.Status]) {
+					continue
+				}
+
+				select {
+				case out <- ListEntry{ListRes: *res, Cursor: v.Key}:
+				case <-ctx.Done():
+					qr.Close()
+					return
+				}
+
+				listed++
+				if opts.Limit > 0 && listed >= opts.Limit {
+					qr.Close()
+					return
+				}
+			}
+			qr.Close()
+
+			if read < listPageSize {
+				return
+			}
+			qr, err = f.listPage(ctx, last)
+			if err != nil {
+				logger.Error("error querying filestore: ", err)
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// listPage queries a page of references after the cursor.
+func (f *Filestore) listPage(ctx context.Context, cursor string) (dsq.Results, error) {
+	q := dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+		Limit:  listPageSize,
+	}
+	if cursor != "" {
+		q.Filters = []dsq.Filter{dsq.FilterKeyCompare{Op: dsq.GreaterThan, Key: cursor}}
+	}
+	return f.fm.ds.Query(ctx, q)
+}
+
+// listEntry returns the ListRes of the reference of the query result, or
+// false if it is not under the prefix.
+func (f *Filestore) listEntry(ctx context.Context, v dsq.Result, prefix string, verify bool) (*ListRes, bool) {
+	mhash, err := dshelp.DsKeyToMultihash(ds.RawKey(v.Key))
+	if err != nil {
+		return mkListRes(mhash, nil, fmt.Errorf("decoding multihash from filestore: %s", err)), prefix == ""
+	}
+	dobj, err := unmarshalDataObj(v.Value)
+	if err != nil {
+		return mkListRes(mhash, nil, err), prefix == ""
+	}
+
+	if prefix != "" && !hasPathPrefix(dobj.GetFilePath(), prefix) {
+		return nil, false
+	}
+	if verify {
+		err = f.fm.verifyDataObj(ctx, mhash, dobj)
+	}
+	return mkListRes(mhash, dobj, err), true
+}
+
+// hasPathPrefix returns whether the stored path p is prefix or under it, or
+// starts with it for URLs.
+func hasPathPrefix(p, prefix string) bool {
+	if IsURL(prefix) {
+		return strings.HasPrefix(p, prefix)
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
@@ -0,0 +1,109 @@
+package filestore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func collectList(t *testing.T, fs *Filestore, opts ListOptions) []ListEntry {
+	entries, err := fs.List(bg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []ListEntry
+	for e := range entries {
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestFilestoreList(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	defer os.RemoveAll(dir)
+
+	for _, sub := range []string{"a", "ab"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	randomFileAdd(t, fs, filepath.Join(dir, "a"), 10000)
+	randomFileAdd(t, fs, filepath.Join(dir, "a"), 10000)
+	shortName, _ := randomFileAdd(t, fs, filepath.Join(dir, "ab"), 10000)
+	if err := os.Truncate(shortName, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	all := collectList(t, fs, ListOptions{})
+	if len(all) != 3000 {
+		t.Fatalf("expected 3000 entries, got %d", len(all))
+	}
+	for i, e := range all {
+		if e.Status != StatusOk {
+			t.Fatalf("expected %s without verification, got %s", StatusOk, e.Status)
+		}
+		if i > 0 && e.Cursor <= all[i-1].Cursor {
+			t.Fatal("entries not in the order of their keys")
+		}
+	}
+
+	t.Run("path prefix", func(t *testing.T) {
+		if n := len(collectList(t, fs, ListOptions{PathPrefix: "a"})); n != 2000 {
+			t.Fatalf("expected 2000 entries under a, got %d", n)
+		}
+		if n := len(collectList(t, fs, ListOptions{PathPrefix: filepath.Join(dir, "ab") + "/"})); n != 1000 {
+			t.Fatalf("expected 1000 entries under ab, got %d", n)
+		}
+		if n := len(collectList(t, fs, ListOptions{PathPrefix: dir})); n != 3000 {
+			t.Fatalf("expected 3000 entries under the root, got %d", n)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		var pages int
+		var cursor string
+		seen := make(map[string]bool)
+		for {
+			page := collectList(t, fs, ListOptions{Limit: 700, Cursor: cursor})
+			if len(page) == 0 {
+				break
+			}
+			pages++
+			for _, e := range page {
+				if seen[e.Cursor] {
+					t.Fatalf("entry %s listed twice", e.Key)
+				}
+				seen[e.Cursor] = true
+			}
+			cursor = page[len(page)-1].Cursor
+		}
+		if pages != 5 || len(seen) != 3000 {
+			t.Fatalf("expected 3000 entries in 5 pages, got %d in %d", len(seen), pages)
+		}
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		counts := make(map[Status]int)
+		for _, e := range collectList(t, fs, ListOptions{Verify: true}) {
+			counts[e.Status]++
+		}
+		if counts[StatusOk] != 2050 || counts[StatusSizeMismatch] != 950 {
+			t.Fatalf("unexpected statuses: %v", counts)
+		}
+
+		broken := collectList(t, fs, ListOptions{Verify: true, Status: []Status{StatusSizeMismatch, StatusFileNotFound}})
+		if len(broken) != 950 {
+			t.Fatalf("expected 950 broken entries, got %d", len(broken))
+		}
+		for _, e := range broken {
+			if filepath.Dir(e.FilePath) != "ab" {
+				t.Fatalf("unexpected broken entry at %s", e.FilePath)
+			}
+		}
+
+		limited := collectList(t, fs, ListOptions{Verify: true, Status: []Status{StatusSizeMismatch}, Limit: 100, Cursor: broken[99].Cursor})
+		if len(limited) != 100 || limited[0].Key != broken[100].Key {
+			t.Fatal("resumed filtered listing does not continue after the cursor")
+		}
+	})
+}
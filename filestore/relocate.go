@@ -0,0 +1,156 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+
+	proto "github.com/gogo/protobuf/proto"
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// DefaultRelocateBatchSize is the number of references rewritten by each
+// datastore batch of RelocatePaths.
+const DefaultRelocateBatchSize = 1024
+
+// RelocateReport counts the references of the FileManager handled by
+// RelocatePaths.
+type RelocateReport struct {
+	// Total is the number of references.
+	Total int
+	// Rewritten is the number of references pointed to a new path. The
+	// references of a batch which failed to commit are not counted.
+	Rewritten int
+	// Skipped is the number of references the mapping did not match.
+	Skipped int
+	// VerifyFailed is the number of references the mapping matched but
+	// whose data at the new path did not verify, which were left as they
+	// were.
+	VerifyFailed int
+}
+
+type relocateOptions struct {
+	verify    bool
+	batchSize int
+}
+
+// RelocateOption configures RelocatePaths.
+type RelocateOption func(*relocateOptions)
+
+// RelocateVerify makes RelocatePaths read and hash the data at the new path
+// of each reference before rewriting it.
+func RelocateVerify(verify bool) RelocateOption {
+	return func(o *relocateOptions) {
+		o.verify = verify
+	}
+}
+
+// RelocateBatchSize sets the number of references rewritten by each
+// datastore batch. It defaults to DefaultRelocateBatchSize.
+func RelocateBatchSize(n int) RelocateOption {
+	return func(o *relocateOptions) {
+		o.batchSize = n
+	}
+}
+
+// RelocatePaths points the references of the FileManager to the paths the
+// mapping returns for their current ones, such as when the directory of
+// the backing files was moved. The paths are the ones stored: relative to
+// the root of the FileManager, with forward slashes, or URLs. The
+// references the mapping returns false for are skipped.
+//
+// The rewrites are written in datastore batches. With dryRun, nothing is
+// written, and the report is the one of the relocation that would be done.
+func (f *FileManager) RelocatePaths(ctx context.Context, mapping func(oldPath string) (newPath string, ok bool), dryRun bool, opts ...RelocateOption) (RelocateReport, error) {
+	o := relocateOptions{batchSize: DefaultRelocateBatchSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize <= 0 {
+		o.batchSize = DefaultRelocateBatchSize
+	}
+
+	var report RelocateReport
+
+	qr, err := f.ds.Query(ctx, dsq.Query{})
+	if err != nil {
+		return report, err
+	}
+	defer qr.Close()
+
+	var batch ds.Batch
+	var pending int
+	commit := func() error {
+		if batch == nil {
+			return nil
+		}
+		err := batch.Commit(ctx)
+		if err == nil {
+			report.Rewritten += pending
+		}
+		batch, pending = nil, 0
+		return err
+	}
+
+	for v := range qr.Next() {
+		if v.Error != nil {
+			return report, v.Error
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Total++
+
+		dobj, err := unmarshalDataObj(v.Value)
+		if err != nil {
+			return report, fmt.Errorf("decoding filestore entry %s: %w", v.Key, err)
+		}
+
+		newPath, ok := mapping(dobj.GetFilePath())
+		if !ok || newPath == dobj.GetFilePath() {
+			report.Skipped++
+			continue
+		}
+		dobj.FilePath = newPath
+
+		if o.verify {
+			mhash, err := dshelp.DsKeyToMultihash(ds.RawKey(v.Key))
+			if err != nil {
+				return report, fmt.Errorf("decoding multihash from filestore: %w", err)
+			}
+			if err := f.verifyDataObj(ctx, mhash, dobj); err != nil {
+				logger.Debugf("relocating filestore entry %s to %s: %s", v.Key, newPath, err)
+				report.VerifyFailed++
+				continue
+			}
+		}
+
+		if dryRun {
+			report.Rewritten++
+			continue
+		}
+
+		data, err := proto.Marshal(dobj)
+		if err != nil {
+			return report, err
+		}
+		if batch == nil {
+			batch, err = f.ds.Batch(ctx)
+			if err != nil {
+				return report, err
+			}
+		}
+		if err := batch.Put(ctx, ds.RawKey(v.Key), data); err != nil {
+			return report, err
+		}
+		pending++
+		if pending >= o.batchSize {
+			if err := commit(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, commit()
+}
@@ -0,0 +1,156 @@
+package filestore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+var errCommit = errors.New("commit failed")
+
+// failingCommitDatastore fails the commits of its batches once the first
+// commits succeeded.
+type failingCommitDatastore struct {
+	ds.Batching
+	commits int
+}
+
+func (d *failingCommitDatastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.Batching.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &failingCommitBatch{Batch: b, d: d}, nil
+}
+
+type failingCommitBatch struct {
+	ds.Batch
+	d *failingCommitDatastore
+}
+
+func (b *failingCommitBatch) Commit(ctx context.Context) error {
+	if b.d.commits == 0 {
+		return errCommit
+	}
+	b.d.commits--
+	return b.Batch.Commit(ctx)
+}
+
+func TestRelocatePaths(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	defer os.RemoveAll(dir)
+
+	oldDir := filepath.Join(dir, "old")
+	if err := os.Mkdir(oldDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, cids := randomFileAdd(t, fs, oldDir, 100)
+	changedName, changed := randomFileAdd(t, fs, oldDir, 100)
+	_, outside := randomFileAdd(t, fs, dir, 100)
+
+	newDir := filepath.Join(dir, "new")
+	if err := os.Rename(oldDir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	// the data of the other file was also modified after it was moved
+	changedName = filepath.Join(newDir, filepath.Base(changedName))
+	if err := os.WriteFile(changedName, make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cids {
+		if _, err := fs.Get(bg, c); err == nil {
+			t.Fatal("expected the moved file to be missing")
+		}
+	}
+
+	mapping := func(p string) (string, bool) {
+		rest, ok := strings.CutPrefix(p, "old/")
+		if !ok {
+			return "", false
+		}
+		return "new/" + rest, true
+	}
+
+	expected := RelocateReport{Total: 30, Rewritten: 10, Skipped: 10, VerifyFailed: 10}
+	report, err := fs.FileManager().RelocatePaths(bg, mapping, true, RelocateVerify(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != expected {
+		t.Fatalf("expected report %+v, got %+v", expected, report)
+	}
+	for _, c := range cids {
+		if res := List(bg, fs, c); !strings.HasPrefix(res.FilePath, "old/") {
+			t.Fatalf("dry run rewrote the reference to %s", res.FilePath)
+		}
+	}
+
+	report, err = fs.FileManager().RelocatePaths(bg, mapping, false, RelocateVerify(true), RelocateBatchSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != expected {
+		t.Fatalf("expected report %+v, got %+v", expected, report)
+	}
+	for _, c := range append(cids, outside...) {
+		if _, err := fs.Get(bg, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range changed {
+		if res := List(bg, fs, c); !strings.HasPrefix(res.FilePath, "old/") {
+			t.Fatalf("reference failing verification rewritten to %s", res.FilePath)
+		}
+	}
+
+	// without verification, the references of the modified file are
+	// rewritten too
+	report, err = fs.FileManager().RelocatePaths(bg, mapping, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rewritten != 10 || report.VerifyFailed != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, c := range changed {
+		if res := Verify(bg, fs, c); res.Status != StatusFileChanged {
+			t.Fatalf("expected %s, got %s", StatusFileChanged, res.Status)
+		}
+	}
+}
+
+func TestRelocatePathsCommitError(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	defer os.RemoveAll(dir)
+
+	_, cids := randomFileAdd(t, fs, dir, 100)
+	fm := fs.FileManager()
+	failing := &FileManager{ds: &failingCommitDatastore{Batching: fm.ds, commits: 2}, root: fm.root, AllowFiles: true}
+
+	mapping := func(p string) (string, bool) {
+		return "moved/" + p, true
+	}
+	report, err := failing.RelocatePaths(bg, mapping, false, RelocateBatchSize(3))
+	if !errors.Is(err, errCommit) {
+		t.Fatalf("expected the commit error, got %v", err)
+	}
+	// the references of the two batches committed
+	if report.Rewritten != 6 {
+		t.Fatalf("expected 6 references rewritten, got %+v", report)
+	}
+	var moved int
+	for _, c := range cids {
+		if strings.HasPrefix(List(bg, fs, c).FilePath, "moved/") {
+			moved++
+		}
+	}
+	if moved != report.Rewritten {
+		t.Fatalf("%d references rewritten, %d reported", moved, report.Rewritten)
+	}
+}
@@ -0,0 +1,298 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	pb "github.com/ipfs/boxo/filestore/pb"
+
+	proto "github.com/gogo/protobuf/proto"
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DefaultVerifyConcurrency is the number of references verified at a time
+// when VerifyOptions.Concurrency is not set.
+const DefaultVerifyConcurrency = 4
+
+// VerifyOptions configures Filestore.Verify.
+type VerifyOptions struct {
+	// Concurrency is the number of references read and hashed at a time.
+	Concurrency int
+
+	// Cursor resumes a verification after the reference of a VerifyEvent
+	// of a previous one, given its Cursor. The references are verified in
+	// the order of their keys.
+	Cursor string
+}
+
+// VerifyProgress counts the references verified so far.
+type VerifyProgress struct {
+	Checked int
+	Failed  int
+}
+
+// VerifyEvent reports the status of a reference of the FileManager, as
+// Verify() would. A reference whose backing file is smaller than the range
+// it points to has the StatusSizeMismatch status.
+type VerifyEvent struct {
+	ListRes
+
+	// Cursor is given to VerifyOptions.Cursor to resume the verification
+	// after this reference.
+	Cursor string
+
+	// Progress counts the references verified up to this one included.
+	Progress VerifyProgress
+}
+
+// Verify re-reads and re-hashes the data of every reference of the
+// FileManager and streams their status, in the order of their keys. The
+// channel is closed once all the references were verified, or when the
+// context is cancelled.
+func (f *Filestore) Verify(ctx context.Context, opts VerifyOptions) (<-chan VerifyEvent, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultVerifyConcurrency
+	}
+
+	q := dsq.Query{Orders: []dsq.Order{dsq.OrderByKey{}}}
+	if opts.Cursor != "" {
+		q.Filters = []dsq.Filter{dsq.FilterKeyCompare{Op: dsq.GreaterThan, Key: opts.Cursor}}
+	}
+	qr, err := f.fm.ds.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	type verifyJob struct {
+		key string
+		res chan *ListRes
+	}
+
+	// The references are verified concurrently but reported in order, so
+	// that the cursor of an event never skips an unverified reference.
+	jobs := make(chan verifyJob, concurrency)
+	go func() {
+		defer close(jobs)
+		defer qr.Close()
+
+		sem := make(chan struct{}, concurrency)
+		for {
+			v, ok := qr.NextSync()
+			if !ok {
+				return
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			job := verifyJob{key: v.Key, res: make(chan *ListRes, 1)}
+			go func() {
+				defer func() { <-sem }()
+				job.res <- f.verifyResult(ctx, v)
+			}()
+
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	out := make(chan VerifyEvent)
+	go func() {
+		defer close(out)
+
+		var progress VerifyProgress
+		for job := range jobs {
+			var res *ListRes
+			select {
+			case res = <-job.res:
+			case <-ctx.Done():
+				return
+			}
+
+			progress.Checked++
+			if res.Status != StatusOk {
+				progress.Failed++
+			}
+
+			select {
+			case out <- VerifyEvent{ListRes: *res, Cursor: job.key, Progress: progress}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (f *Filestore) verifyResult(ctx context.Context, v dsq.Result) *ListRes {
+	if v.Error != nil {
+		return &ListRes{Status: StatusOtherError, ErrorMsg: v.Error.Error()}
+	}
+
+	mhash, err := dshelp.DsKeyToMultihash(ds.RawKey(v.Key))
+	if err != nil {
+		return mkListRes(mhash, nil, fmt.Errorf("decoding multihash from filestore: %s", err))
+	}
+
+	dobj, err := unmarshalDataObj(v.Value)
+	if err != nil {
+		return mkListRes(mhash, nil, err)
+	}
+
+	return mkListRes(mhash, dobj, f.fm.verifyDataObj(ctx, mhash, dobj))
+}
+
+// verifyDataObj reads and verifies the data of the reference, like
+// readDataObj, but tells the backing files too small for the reference apart.
+func (f *FileManager) verifyDataObj(ctx context.Context, m mh.Multihash, d *pb.DataObj) error {
+	if f.AllowFiles && !IsURL(d.GetFilePath()) {
+		abspath := filepath.Join(f.root, filepath.FromSlash(d.GetFilePath()))
+		fi, err := os.Stat(abspath)
+		if err == nil && uint64(fi.Size()) < d.GetOffset()+d.GetSize_() {
+			return &CorruptReferenceError{
+				StatusSizeMismatch,
+				fmt.Errorf("file too small for the data. %s is %d bytes, offset %d size %d", d.GetFilePath(), fi.Size(), d.GetOffset(), d.GetSize_()),
+			}
+		}
+	}
+
+	_, err := f.readDataObj(ctx, m, d)
+	return err
+}
+
+// RepairPolicy tells Filestore.Repair what to do with the references of the
+// FileManager.
+type RepairPolicy struct {
+	VerifyOptions
+
+	// Remap is given the path of each reference whose backing file is
+	// missing, changed or too small, as it is stored: relative to the root
+	// of the FileManager, or a URL. When it returns a path and true, the
+	// reference is re-pointed to it if the data there verifies.
+	Remap func(filePath string) (string, bool)
+
+	// DeleteDangling deletes the references whose backing file is missing,
+	// changed or too small, and which were not re-pointed.
+	DeleteDangling bool
+
+	// Convert stores the data of the valid references as regular blocks
+	// in the main blockstore, and deletes the references.
+	Convert bool
+
+	// OnProgress, if set, is called after each reference.
+	OnProgress func(RepairProgress)
+}
+
+// RepairProgress counts the references repaired so far.
+type RepairProgress struct {
+	VerifyProgress
+
+	Repointed int
+	Deleted   int
+	Converted int
+
+	// Cursor is given to RepairPolicy.Cursor to resume the repair after
+	// the last reference handled.
+	Cursor string
+}
+
+// Repair verifies the references of the FileManager, like Verify, and
+// repairs them as the policy says. The references whose data is dangling
+// are re-pointed with Remap first, and deleted otherwise if DeleteDangling
+// is set. The progress is returned along with any error, so that an
+// interrupted repair can be resumed from its cursor.
+func (f *Filestore) Repair(ctx context.Context, policy RepairPolicy) (RepairProgress, error) {
+	progress := RepairProgress{Cursor: policy.Cursor}
+
+	events, err := f.Verify(ctx, policy.VerifyOptions)
+	if err != nil {
+		return progress, err
+	}
+
+	for ev := range events {
+		if err := f.repair(ctx, policy, &progress, &ev); err != nil {
+			return progress, err
+		}
+
+		progress.VerifyProgress = ev.Progress
+		progress.Cursor = ev.Cursor
+		if policy.OnProgress != nil {
+			policy.OnProgress(progress)
+		}
+	}
+
+	return progress, ctx.Err()
+}
+
+func (f *Filestore) repair(ctx context.Context, policy RepairPolicy, progress *RepairProgress, ev *VerifyEvent) error {
+	dobj := &pb.DataObj{
+		FilePath: ev.FilePath,
+		Offset:   ev.Offset,
+		Size_:    ev.Size,
+	}
+	m := ev.Key.Hash()
+
+	switch ev.Status {
+	case StatusOk:
+		if !policy.Convert {
+			return nil
+		}
+		data, err := f.fm.readDataObj(ctx, m, dobj)
+		if err != nil {
+			// changed since it was verified, leave it for the next repair
+			logger.Warnf("converting filestore reference %s: %s", ev.Key, err)
+			return nil
+		}
+		blk, err := blocks.NewBlockWithCid(data, ev.Key)
+		if err != nil {
+			return err
+		}
+		if err := f.bs.Put(ctx, blk); err != nil {
+			return err
+		}
+		if err := f.fm.DeleteBlock(ctx, ev.Key); err != nil {
+			return err
+		}
+		progress.Converted++
+
+	case StatusFileNotFound, StatusFileChanged, StatusSizeMismatch:
+		if policy.Remap != nil {
+			if p, ok := policy.Remap(ev.FilePath); ok {
+				remapped := *dobj
+				remapped.FilePath = p
+				if f.fm.verifyDataObj(ctx, m, &remapped) == nil {
+					data, err := proto.Marshal(&remapped)
+					if err != nil {
+						return err
+					}
+					if err := f.fm.ds.Put(ctx, dshelp.MultihashToDsKey(m), data); err != nil {
+						return err
+					}
+					progress.Repointed++
+					return nil
+				}
+			}
+		}
+		if policy.DeleteDangling {
+			if err := f.fm.DeleteBlock(ctx, ev.Key); err != nil {
+				return err
+			}
+			progress.Deleted++
+		}
+	}
+
+	return nil
+}
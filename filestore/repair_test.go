@@ -0,0 +1,237 @@
+package filestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+type repairFixture struct {
+	dir     string
+	fs      *Filestore
+	ok      []cid.Cid
+	missing []cid.Cid
+	short   []cid.Cid
+	changed []cid.Cid
+
+	changedData []byte
+	changedName string
+}
+
+// newRepairFixture adds four files to a filestore, then deletes the second,
+// truncates the third and modifies the fourth.
+func newRepairFixture(t *testing.T) *repairFixture {
+	dir, fs := newTestFilestore(t)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	rf := &repairFixture{dir: dir, fs: fs}
+	_, rf.ok = randomFileAdd(t, fs, dir, 100)
+
+	var missingName, shortName string
+	missingName, rf.missing = randomFileAdd(t, fs, dir, 100)
+	shortName, rf.short = randomFileAdd(t, fs, dir, 100)
+	rf.changedName, rf.changed = randomFileAdd(t, fs, dir, 100)
+
+	var err error
+	rf.changedData, err = os.ReadFile(rf.changedName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(missingName); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(shortName, 55); err != nil {
+		t.Fatal(err)
+	}
+	modified := append([]byte(nil), rf.changedData...)
+	for i := range modified {
+		modified[i] ^= 0xff
+	}
+	if err := os.WriteFile(rf.changedName, modified, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return rf
+}
+
+func collectVerify(t *testing.T, fs *Filestore, opts VerifyOptions) []VerifyEvent {
+	events, err := fs.Verify(bg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []VerifyEvent
+	for ev := range events {
+		out = append(out, ev)
+	}
+	return out
+}
+
+func checkStatus(t *testing.T, statuses map[cid.Cid]Status, cids []cid.Cid, expected Status) {
+	t.Helper()
+	for _, c := range cids {
+		if s := statuses[c]; s != expected {
+			t.Fatalf("status of %s: expected %s, got %s", c, expected, s)
+		}
+	}
+}
+
+func TestFilestoreVerify(t *testing.T) {
+	rf := newRepairFixture(t)
+
+	events := collectVerify(t, rf.fs, VerifyOptions{Concurrency: 3})
+	if len(events) != 40 {
+		t.Fatalf("expected 40 events, got %d", len(events))
+	}
+
+	statuses := make(map[cid.Cid]Status)
+	for i, ev := range events {
+		statuses[ev.Key] = ev.Status
+		if ev.Progress.Checked != i+1 {
+			t.Fatalf("expected %d checked, got %d", i+1, ev.Progress.Checked)
+		}
+		if i > 0 && ev.Cursor <= events[i-1].Cursor {
+			t.Fatal("events not in the order of their keys")
+		}
+	}
+	checkStatus(t, statuses, rf.ok, StatusOk)
+	checkStatus(t, statuses, rf.missing, StatusFileNotFound)
+	// the first 5 blocks of the truncated file are still there
+	checkStatus(t, statuses, rf.short[:5], StatusOk)
+	checkStatus(t, statuses, rf.short[5:], StatusSizeMismatch)
+	checkStatus(t, statuses, rf.changed, StatusFileChanged)
+
+	if failed := events[len(events)-1].Progress.Failed; failed != 25 {
+		t.Fatalf("expected 25 failed, got %d", failed)
+	}
+
+	// resume after the 15th reference
+	rest := collectVerify(t, rf.fs, VerifyOptions{Cursor: events[14].Cursor})
+	if len(rest) != 25 {
+		t.Fatalf("expected 25 events after the cursor, got %d", len(rest))
+	}
+	for i, ev := range rest {
+		if ev.Key != events[15+i].Key {
+			t.Fatal("resumed verification does not continue after the cursor")
+		}
+	}
+}
+
+func TestFilestoreRepairDeleteDangling(t *testing.T) {
+	rf := newRepairFixture(t)
+
+	progress, err := rf.fs.Repair(bg, RepairPolicy{DeleteDangling: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Checked != 40 || progress.Failed != 25 || progress.Deleted != 25 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+
+	for _, c := range append(append(rf.missing, rf.short[5:]...), rf.changed...) {
+		if has, _ := rf.fs.Has(bg, c); has {
+			t.Fatalf("dangling reference %s not deleted", c)
+		}
+	}
+	for _, c := range append(rf.ok, rf.short[:5]...) {
+		if _, err := rf.fs.Get(bg, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFilestoreRepairRemap(t *testing.T) {
+	rf := newRepairFixture(t)
+
+	moved := filepath.Join(rf.dir, "moved")
+	if err := os.WriteFile(moved, rf.changedData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// only the data of the changed file is at the new path, the other
+	// dangling references are not re-pointed
+	progress, err := rf.fs.Repair(bg, RepairPolicy{
+		Remap: func(string) (string, bool) { return "moved", true },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Repointed != 10 || progress.Deleted != 0 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+
+	for _, c := range rf.changed {
+		res := List(bg, rf.fs, c)
+		if res.FilePath != "moved" {
+			t.Fatalf("expected reference re-pointed to moved, got %s", res.FilePath)
+		}
+		if _, err := rf.fs.Get(bg, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range rf.missing {
+		if res := Verify(bg, rf.fs, c); res.Status != StatusFileNotFound {
+			t.Fatalf("expected %s, got %s", StatusFileNotFound, res.Status)
+		}
+	}
+}
+
+func TestFilestoreRepairConvert(t *testing.T) {
+	rf := newRepairFixture(t)
+
+	var calls int
+	progress, err := rf.fs.Repair(bg, RepairPolicy{
+		Convert:    true,
+		OnProgress: func(RepairProgress) { calls++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Converted != 15 || calls != 40 {
+		t.Fatalf("unexpected progress: %+v after %d calls", progress, calls)
+	}
+
+	for _, c := range append(rf.ok, rf.short[:5]...) {
+		if has, _ := rf.fs.FileManager().Has(bg, c); has {
+			t.Fatalf("reference %s not converted", c)
+		}
+		if has, _ := rf.fs.MainBlockstore().Has(bg, c); !has {
+			t.Fatalf("block %s not in the main blockstore", c)
+		}
+	}
+	for _, c := range rf.changed {
+		if has, _ := rf.fs.FileManager().Has(bg, c); !has {
+			t.Fatalf("changed reference %s converted", c)
+		}
+	}
+}
+
+func TestFilestoreRepairResume(t *testing.T) {
+	rf := newRepairFixture(t)
+
+	ctx, cancel := context.WithCancel(bg)
+	progress, err := rf.fs.Repair(ctx, RepairPolicy{
+		DeleteDangling: true,
+		OnProgress: func(p RepairProgress) {
+			if p.Checked == 20 {
+				cancel()
+			}
+		},
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected %s, got %v", context.Canceled, err)
+	}
+
+	rest, err := rf.fs.Repair(bg, RepairPolicy{
+		VerifyOptions:  VerifyOptions{Cursor: progress.Cursor},
+		DeleteDangling: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Checked+rest.Checked != 40 || progress.Deleted+rest.Deleted != 25 {
+		t.Fatalf("unexpected progress: %+v then %+v", progress, rest)
+	}
+}
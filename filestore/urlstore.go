@@ -0,0 +1,179 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultURLRetryBackoff is the delay before the first retry of a request
+// of the urlstore when URLConfig.RetryBackoff is not set. It doubles with
+// each retry.
+const DefaultURLRetryBackoff = 100 * time.Millisecond
+
+// URLConfig configures the HTTP requests the FileManager makes to read the
+// blocks backed by URLs.
+type URLConfig struct {
+	// Client sends the requests. http.DefaultClient is used if nil.
+	Client *http.Client
+
+	// Headers are added to every request, such as an Authorization or a
+	// User-Agent header.
+	Headers http.Header
+
+	// ModifyRequest, if set, is called with each request before it is sent,
+	// after the Headers and the Range header are set.
+	ModifyRequest func(*http.Request)
+
+	// Timeout bounds each attempt of a request, including the read of the
+	// response body. There is no timeout if zero.
+	Timeout time.Duration
+
+	// Retries is the number of times a request answered with a 5xx status
+	// or failing to be sent is retried.
+	Retries int
+
+	// RetryBackoff is the delay before the first retry.
+	RetryBackoff time.Duration
+}
+
+// RangeError is the error of the reads of the blocks backed by URLs whose
+// server did not answer the Range request with the requested bytes. It is
+// wrapped in a CorruptReferenceError.
+type RangeError struct {
+	URL          string
+	Offset       uint64
+	Size         uint64
+	StatusCode   int
+	ContentRange string
+}
+
+func (e *RangeError) Error() string {
+	if e.StatusCode != http.StatusPartialContent {
+		return fmt.Sprintf("range request of %s: expected HTTP 206 got %d", e.URL, e.StatusCode)
+	}
+	return fmt.Sprintf("range request of %s: expected bytes %d-%d got Content-Range %q",
+		e.URL, e.Offset, e.Offset+e.Size-1, e.ContentRange)
+}
+
+// urlConfig returns the configuration of the requests of url: the one of
+// the longest prefix of URLPrefixConfigs matching it, or URLConfig.
+func (f *FileManager) urlConfig(url string) *URLConfig {
+	cfg := &f.URLConfig
+	var longest int
+	for prefix := range f.URLPrefixConfigs {
+		if len(prefix) > longest && strings.HasPrefix(url, prefix) {
+			c := f.URLPrefixConfigs[prefix]
+			cfg, longest = &c, len(prefix)
+		}
+	}
+	return cfg
+}
+
+// fetchRange reads size bytes at offset of url, retrying as configured.
+func (cfg *URLConfig) fetchRange(ctx context.Context, url string, offset, size uint64) ([]byte, error) {
+	backoff := cfg.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultURLRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		data, retry, err := cfg.fetchRangeOnce(ctx, url, offset, size)
+		if err == nil || !retry || attempt >= cfg.Retries {
+			return data, err
+		}
+		logger.Debugf("retrying range request of %s: %s", url, err)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (cfg *URLConfig) fetchRangeOnce(ctx context.Context, url string, offset, size uint64) (data []byte, retry bool, err error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for k, vs := range cfg.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	if cfg.ModifyRequest != nil {
+		cfg.ModifyRequest(req)
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, &CorruptReferenceError{StatusFileError, err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return nil, true, &CorruptReferenceError{
+			StatusFileError,
+			fmt.Errorf("expected HTTP 206 got %d", res.StatusCode),
+		}
+	}
+	if res.StatusCode != http.StatusPartialContent || !contentRangeMatches(res.Header.Get("Content-Range"), offset, size) {
+		return nil, false, &CorruptReferenceError{
+			StatusFileError,
+			&RangeError{
+				URL:          url,
+				Offset:       offset,
+				Size:         size,
+				StatusCode:   res.StatusCode,
+				ContentRange: res.Header.Get("Content-Range"),
+			},
+		}
+	}
+
+	data, err = readFull(res.Body, size)
+	return data, false, err
+}
+
+// contentRangeMatches returns whether the Content-Range header is the one of
+// size bytes at offset.
+func contentRangeMatches(contentRange string, offset, size uint64) bool {
+	r, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return false
+	}
+	r, _, ok = strings.Cut(r, "/")
+	if !ok {
+		return false
+	}
+	first, last, ok := strings.Cut(r, "-")
+	if !ok {
+		return false
+	}
+	start, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return false
+	}
+	end, err := strconv.ParseUint(last, 10, 64)
+	if err != nil {
+		return false
+	}
+	return start == offset && end == offset+size-1
+}
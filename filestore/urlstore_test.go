@@ -0,0 +1,166 @@
+package filestore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+
+	posinfo "github.com/ipfs/boxo/filestore/posinfo"
+	cid "github.com/ipfs/go-cid"
+)
+
+func newURLTestFilestore(t *testing.T, handler http.HandlerFunc) (*Filestore, string, []byte, []cid.Cid) {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	_, fs := newTestFilestore(t)
+	fs.FileManager().AllowUrls = true
+
+	buf := make([]byte, 100)
+	rand.Read(buf)
+
+	var cids []cid.Cid
+	for i := 0; i < 10; i++ {
+		n := &posinfo.FilestoreNode{
+			PosInfo: &posinfo.PosInfo{
+				FullPath: srv.URL + "/file",
+				Offset:   uint64(i * 10),
+			},
+			Node: dag.NewRawNode(buf[i*10 : (i+1)*10]),
+		}
+		if err := fs.Put(bg, n); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, n.Cid())
+	}
+	return fs, srv.URL, buf, cids
+}
+
+func serveContent(data *[]byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(*data))
+	}
+}
+
+func TestURLStoreHeaders(t *testing.T) {
+	var data []byte
+	var auth, agent atomic.Value
+	fs, url, buf, cids := newURLTestFilestore(t, func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		agent.Store(r.Header.Get("User-Agent"))
+		serveContent(&data)(w, r)
+	})
+	data = buf
+
+	fs.FileManager().URLConfig = URLConfig{
+		Headers:       http.Header{"Authorization": []string{"Bearer default"}},
+		ModifyRequest: func(r *http.Request) { r.Header.Set("User-Agent", "filestore-test") },
+	}
+	blk, err := fs.Get(bg, cids[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blk.RawData(), buf[30:40]) {
+		t.Fatal("data didnt match on the way out")
+	}
+	if auth.Load() != "Bearer default" || agent.Load() != "filestore-test" {
+		t.Fatalf("unexpected headers: %v %v", auth.Load(), agent.Load())
+	}
+
+	fs.FileManager().URLPrefixConfigs = map[string]URLConfig{
+		url:               {Headers: http.Header{"Authorization": []string{"Bearer prefix"}}},
+		"http://example/": {Headers: http.Header{"Authorization": []string{"Bearer other"}}},
+	}
+	if _, err := fs.Get(bg, cids[3]); err != nil {
+		t.Fatal(err)
+	}
+	if auth.Load() != "Bearer prefix" {
+		t.Fatalf("expected the header of the prefix, got %v", auth.Load())
+	}
+}
+
+func TestURLStoreRangeIgnored(t *testing.T) {
+	var data []byte
+	fs, _, buf, cids := newURLTestFilestore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	data = buf
+
+	_, err := fs.Get(bg, cids[3])
+	var rerr *RangeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected a RangeError, got %v", err)
+	}
+	if rerr.StatusCode != http.StatusOK || rerr.Offset != 30 || rerr.Size != 10 {
+		t.Fatalf("unexpected RangeError: %+v", rerr)
+	}
+}
+
+func TestURLStoreWrongContentRange(t *testing.T) {
+	var data []byte
+	fs, _, buf, cids := newURLTestFilestore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-9/100")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[:10])
+	})
+	data = buf
+
+	_, err := fs.Get(bg, cids[3])
+	var rerr *RangeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected a RangeError, got %v", err)
+	}
+	if rerr.ContentRange != "bytes 0-9/100" {
+		t.Fatalf("unexpected RangeError: %+v", rerr)
+	}
+}
+
+func TestURLStoreTampered(t *testing.T) {
+	var data []byte
+	fs, _, buf, cids := newURLTestFilestore(t, serveContent(&data))
+	data = append([]byte(nil), buf...)
+	data[35] ^= 0xff
+
+	if _, err := fs.Get(bg, cids[2]); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fs.Get(bg, cids[3])
+	var cerr *CorruptReferenceError
+	if !errors.As(err, &cerr) || cerr.Code != StatusFileChanged {
+		t.Fatalf("expected a changed reference, got %v", err)
+	}
+}
+
+func TestURLStoreRetry(t *testing.T) {
+	var data []byte
+	var requests atomic.Int32
+	fs, _, buf, cids := newURLTestFilestore(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		serveContent(&data)(w, r)
+	})
+	data = buf
+
+	fs.FileManager().URLConfig = URLConfig{Retries: 1, RetryBackoff: time.Millisecond}
+	if _, err := fs.Get(bg, cids[3]); err == nil {
+		t.Fatal("expected an error after one retry")
+	}
+
+	requests.Store(0)
+	fs.FileManager().URLConfig.Retries = 2
+	if _, err := fs.Get(bg, cids[3]); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
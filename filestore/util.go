@@ -25,6 +25,7 @@ const (
 	StatusFileError    Status = 10 // Backing File Error
 	StatusFileNotFound Status = 11 // Backing File Not Found
 	StatusFileChanged  Status = 12 // Contents of the file changed
+	StatusSizeMismatch Status = 13 // Backing File too small for the referenced range
 	StatusOtherError   Status = 20 // Internal Error, likely corrupt entry
 	StatusKeyNotFound  Status = 30
 )
@@ -40,6 +41,8 @@ func (s Status) String() string {
 		return "no-file"
 	case StatusFileChanged:
 		return "changed"
+	case StatusSizeMismatch:
+		return "size"
 	case StatusOtherError:
 		return "ERROR"
 	case StatusKeyNotFound:
@@ -95,6 +98,9 @@ func List(ctx context.Context, fs *Filestore, key cid.Cid) *ListRes {
 // one by one each block in the Filestore's FileManager.
 // ListAll does not verify that the references are valid or whether
 // the raw data is accessible. See VerifyAll().
+//
+// Ordering by file loads all the references in memory. See Filestore.List
+// to stream them, filtered and resumable.
 func ListAll(ctx context.Context, fs *Filestore, fileOrder bool) (func(context.Context) *ListRes, error) {
 	if fileOrder {
 		return listAllFileOrder(ctx, fs, false)
@@ -114,6 +120,9 @@ func Verify(ctx context.Context, fs *Filestore, key cid.Cid) *ListRes {
 // returns one by one each block in the Filestore's FileManager.
 // VerifyAll checks that the reference is valid and that the block data
 // can be read.
+//
+// Ordering by file loads all the references in memory. See Filestore.List
+// to stream them, filtered and resumable.
 func VerifyAll(ctx context.Context, fs *Filestore, fileOrder bool) (func(context.Context) *ListRes, error) {
 	if fileOrder {
 		return listAllFileOrder(ctx, fs, true)
@@ -122,7 +122,7 @@ func NewBlocksBackend(blockService blockservice.BlockService, opts ...BlocksBack
 
 	ns = compiledOptions.ns
 	if ns == nil {
-		dns, err := NewDNSResolver(nil, nil)
+		dns, err := NewDNSResolver(nil)
 		if err != nil {
 			return nil, err
 		}
@@ -340,6 +340,13 @@ func (bb *BlocksBackend) GetCAR(ctx context.Context, p path.ImmutablePath, param
 
 	r, w := io.Pipe()
 	go func() {
+		var progress fetcher.Progress
+		ctx := fetcher.ContextWithProgress(ctx, &progress)
+		defer func() {
+			stats := progress.Stats()
+			log.Debugw("car streamed", "path", p, "blocks", stats.BlocksFetched, "bytes", stats.BytesFetched, "duration", stats.Duration, "cacheHits", stats.CacheHits)
+		}()
+
 		cw, err := storage.NewWritable(
 			w,
 			[]cid.Cid{pathMetadata.LastSegment.RootCid()},
@@ -588,6 +595,18 @@ func (bb *BlocksBackend) getPathRoots(ctx context.Context, contentPath path.Immu
 		Note that while the top one will change every time any article is changed,
 		the last root (responsible for specific article) may not change at all.
 	*/
+	if tr, ok := bb.resolver.(resolver.TracingResolver); ok {
+		res, err := tr.ResolveToLastNodeWithTrace(ctx, contentPath)
+		if err == nil {
+			return tracePathRoots(contentPath, res)
+		}
+		// the other not found errors are resolved again segment by segment
+		// below, to return the segment which is not found
+		if !isErrNotFound(err) || errors.Is(err, &resolver.ErrNoLink{}) {
+			return nil, path.ImmutablePath{}, nil, err
+		}
+	}
+
 	var sp strings.Builder
 	var pathRoots []cid.Cid
 	contentPathStr := contentPath.String()
@@ -625,6 +644,27 @@ func (bb *BlocksBackend) getPathRoots(ctx context.Context, contentPath path.Immu
 	return pathRoots, lastPath, remainder, nil
 }
 
+// tracePathRoots returns the values of getPathRoots from the trace of the
+// resolution of contentPath.
+func tracePathRoots(contentPath path.ImmutablePath, res resolver.Result) ([]cid.Cid, path.ImmutablePath, []string, error) {
+	pathRoots := make([]cid.Cid, 0, len(res.Trace)+1)
+	pathRoots = append(pathRoots, contentPath.RootCid())
+	for _, segment := range res.Trace {
+		pathRoots = append(pathRoots, segment.Cid)
+	}
+	pathRoots = pathRoots[:len(pathRoots)-1]
+
+	p, err := path.Join(path.FromCid(res.Cid), res.Remainder...)
+	if err != nil {
+		return nil, path.ImmutablePath{}, nil, err
+	}
+	lastPath, err := path.NewImmutablePath(p)
+	if err != nil {
+		return nil, path.ImmutablePath{}, nil, err
+	}
+	return pathRoots, lastPath, res.Remainder, nil
+}
+
 func (bb *BlocksBackend) ResolveMutable(ctx context.Context, p path.Path) (path.ImmutablePath, time.Duration, time.Time, error) {
 	switch p.Namespace() {
 	case path.IPNSNamespace:
@@ -665,7 +705,7 @@ func (bb *BlocksBackend) GetDNSLinkRecord(ctx context.Context, hostname string)
 			return nil, err
 		}
 		res, err := bb.namesys.Resolve(ctx, p, namesys.ResolveWithDepth(1))
-		if err == namesys.ErrResolveRecursion {
+		if errors.Is(err, namesys.ErrResolveRecursion) {
 			err = nil
 		}
 		return res.Path, err
@@ -860,12 +900,29 @@ func (n *nodeGetterFetcherSingleUseFactory) blankProgress(ctx context.Context) t
 }
 
 func blockOpener(ctx context.Context, ng format.NodeGetter) ipld.BlockReadOpener {
+	progress := fetcher.ProgressFromContext(ctx)
 	return func(_ ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
 		cidLink, ok := lnk.(cidlink.Link)
 		if !ok {
 			return nil, fmt.Errorf("invalid link type for loading: %v", lnk)
 		}
 
+		if progress != nil {
+			// ask for a local copy first, to tell the cache hits apart
+			start := time.Now()
+			cached := true
+			blk, err := ng.Get(blockservice.ContextWithLocalOnly(ctx), cidLink.Cid)
+			if format.IsNotFound(err) {
+				cached = false
+				blk, err = ng.Get(ctx, cidLink.Cid)
+			}
+			if err != nil {
+				return nil, err
+			}
+			progress.BlockLoaded(cidLink.Cid, len(blk.RawData()), cached, time.Since(start))
+			return bytes.NewReader(blk.RawData()), nil
+		}
+
 		blk, err := ng.Get(ctx, cidLink.Cid)
 		if err != nil {
 			return nil, err
@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/ipfs/boxo/namesys"
 	"github.com/libp2p/go-doh-resolver"
-	dns "github.com/miekg/dns"
 	madns "github.com/multiformats/go-multiaddr-dns"
 )
 
@@ -14,20 +14,14 @@ var defaultResolvers = map[string]string{
 	"crypto.": "https://resolver.cloudflare-eth.com/dns-query",
 }
 
-func newResolver(url string, opts ...doh.Option) (madns.BasicResolver, error) {
-	if !strings.HasPrefix(url, "https://") {
-		return nil, fmt.Errorf("invalid resolver url: %s", url)
-	}
-
-	return doh.NewResolver(url, opts...)
-}
-
 // NewDNSResolver creates a new DNS resolver based on the default resolvers and
 // the provided resolvers.
 //
-// The argument 'resolvers' is a map of [FQDNs] to URLs for custom DNS resolution.
-// URLs starting with "https://" indicate [DoH] endpoints. Support for other resolver
-// types may be added in the future.
+// The argument 'resolvers' is a map of [FQDNs] to URLs for custom DNS resolution,
+// see [namesys.NewDoHDNSResolver]. URLs starting with "https://" indicate [DoH]
+// endpoints. Support for other resolver types may be added in the future. An
+// empty URL drops the default resolver of its domain, whose lookups then go to
+// the "." resolver. The dohOpts only apply to the provided resolvers.
 //
 // Example:
 //   - Custom resolver for ENS:          "eth." → "https://eth.link/dns-query"
@@ -36,57 +30,42 @@ func newResolver(url string, opts ...doh.Option) (madns.BasicResolver, error) {
 // [FQDNs]: https://en.wikipedia.org/wiki/Fully_qualified_domain_name
 // [DoH]: https://en.wikipedia.org/wiki/DNS_over_HTTPS
 func NewDNSResolver(resolvers map[string]string, dohOpts ...doh.Option) (*madns.Resolver, error) {
-	var opts []madns.Option
-	var err error
-
-	domains := make(map[string]struct{})           // to track overridden default resolvers
-	rslvrs := make(map[string]madns.BasicResolver) // to reuse resolvers for the same URL
-
-	for domain, url := range resolvers {
-		if domain != "." && !dns.IsFqdn(domain) {
-			return nil, fmt.Errorf("invalid domain %s; must be FQDN", domain)
-		}
-
-		domains[domain] = struct{}{}
-		if url == "" {
-			// allow overriding of implicit defaults with the default resolver
-			continue
-		}
-
-		rslv, ok := rslvrs[url]
-		if !ok {
-			rslv, err = newResolver(url, dohOpts...)
-			if err != nil {
-				return nil, fmt.Errorf("bad resolver for %s: %w", domain, err)
-			}
-			rslvrs[url] = rslv
-		}
+	return newDNSResolver(resolvers, func(url string, opts ...doh.Option) (madns.BasicResolver, error) {
+		return doh.NewResolver(url, opts...)
+	}, dohOpts...)
+}
 
-		if domain != "." {
-			opts = append(opts, madns.WithDomainResolver(domain, rslv))
-		} else {
-			opts = append(opts, madns.WithDefaultResolver(rslv))
+// newDNSResolver is NewDNSResolver with the DoH resolvers built by
+// newResolver.
+func newDNSResolver(resolvers map[string]string, newResolver func(url string, opts ...doh.Option) (madns.BasicResolver, error), dohOpts ...doh.Option) (*madns.Resolver, error) {
+	custom, err := namesys.NewDNSResolverFromURLs(resolvers, func(url string) (madns.BasicResolver, error) {
+		if !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("invalid resolver url: %s", url)
 		}
+		return newResolver(url, dohOpts...)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// fill in defaults if not overridden by the user
+	// fill in defaults if not overridden by the user, the other lookups
+	// going to the provided resolvers
+	defaults := make(map[string]madns.BasicResolver)
+	byURL := make(map[string]madns.BasicResolver)
 	for domain, url := range defaultResolvers {
-		_, ok := domains[domain]
-		if ok {
+		if _, ok := resolvers[domain]; ok {
 			continue
 		}
-
-		rslv, ok := rslvrs[url]
+		rslv, ok := byURL[url]
 		if !ok {
 			rslv, err = newResolver(url)
 			if err != nil {
 				return nil, fmt.Errorf("bad resolver for %s: %w", domain, err)
 			}
-			rslvrs[url] = rslv
+			byURL[url] = rslv
 		}
-
-		opts = append(opts, madns.WithDomainResolver(domain, rslv))
+		defaults[domain] = rslv
 	}
 
-	return madns.NewResolver(opts...)
+	return namesys.NewDNSRoutingResolver(custom, defaults)
 }
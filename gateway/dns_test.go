@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	doh "github.com/libp2p/go-doh-resolver"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/require"
+)
+
+// urlResolver answers every TXT lookup with a dnslink to its URL, so the
+// tests can tell which resolver was used.
+type urlResolver string
+
+func (r urlResolver) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{IsNotFound: true}
+}
+
+func (r urlResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return []string{"dnslink=/ipns/" + string(r)}, nil
+}
+
+func TestNewDNSResolver(t *testing.T) {
+	const private = "https://private.example.com/dns-query"
+
+	// the options only apply to the resolvers provided
+	var withOpts []string
+	newResolver := func(url string, opts ...doh.Option) (madns.BasicResolver, error) {
+		if len(opts) > 0 {
+			withOpts = append(withOpts, url)
+		}
+		return urlResolver(url), nil
+	}
+
+	// an empty URL drops the default resolver of eth., whose lookups go
+	// to the "." resolver
+	rslv, err := newDNSResolver(map[string]string{".": private, "eth.": ""}, newResolver, doh.WithCacheDisabled())
+	require.NoError(t, err)
+	require.Equal(t, []string{private}, withOpts)
+
+	for name, expected := range map[string]string{
+		"_dnslink.vitalik.eth.": private,
+		"_dnslink.example.com.": private,
+		"_dnslink.brad.crypto.": defaultResolvers["crypto."],
+	} {
+		txt, err := rslv.LookupTXT(context.Background(), name)
+		require.NoError(t, err)
+		require.Equal(t, []string{"dnslink=/ipns/" + expected}, txt, name)
+	}
+
+	_, err = NewDNSResolver(map[string]string{".": "http://insecure.example.com/dns-query"})
+	require.Error(t, err)
+}
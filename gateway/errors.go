@@ -147,6 +147,8 @@ func webError(w http.ResponseWriter, r *http.Request, c *Config, err error, defa
 	switch {
 	case errors.Is(err, &cid.ErrInvalidCid{}):
 		code = http.StatusBadRequest
+	case errors.Is(err, &resolver.ErrBudgetExceeded{}):
+		code = http.StatusBadRequest
 	case isErrNotFound(err):
 		code = http.StatusNotFound
 	case isErrContentBlocked(err):
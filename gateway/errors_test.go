@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ipfs/boxo/path/resolver"
 	"github.com/stretchr/testify/require"
 )
 
@@ -78,6 +79,16 @@ func TestWebError(t *testing.T) {
 		require.Equal(t, "50", w.Result().Header.Get("Retry-After"))
 	})
 
+	t.Run("400 Bad Request for a path exceeding the resolver budgets", func(t *testing.T) {
+		t.Parallel()
+
+		err := fmt.Errorf("failed to resolve: %w", &resolver.ErrBudgetExceeded{Budget: resolver.BudgetBlocksFetched, Limit: 10})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/blah", nil)
+		webError(w, r, config, err, http.StatusInternalServerError)
+		require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+	})
+
 	t.Run("ErrorStatusCode propagates HTTP Status Code", func(t *testing.T) {
 		t.Parallel()
 
@@ -231,7 +231,7 @@ func (i *handler) getOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var success bool
-	contentPath, err := path.NewPath(r.URL.Path)
+	contentPath, err := path.NewPathFromURL(r.URL)
 	if err != nil {
 		i.webError(w, r, err, http.StatusBadRequest)
 		return
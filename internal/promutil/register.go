@@ -0,0 +1,26 @@
+// Package promutil holds the helpers shared by the Prometheus metrics of the
+// boxo packages.
+package promutil
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Logger is the part of the package loggers used to report registration
+// errors.
+type Logger interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Register registers c with reg, or returns the collector already registered
+// in its place, for example by another instance of the same component. Other
+// errors are logged and c is returned unregistered.
+func Register[T prometheus.Collector](reg prometheus.Registerer, c T, log Logger) T {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		log.Errorf("failed to register metrics: %v", err)
+	}
+	return c
+}
@@ -160,9 +160,13 @@ func WrapSession(s *bserv.Session) format.NodeGetter {
 	}
 }
 
-// Session returns a NodeGetter using a new session for block fetches.
+// Session returns a NodeGetter using a new session for block fetches, or the
+// session attached to ctx with [bserv.ContextWithSession].
 func (n *dagService) Session(ctx context.Context) format.NodeGetter {
-	session := bserv.NewSession(ctx, n.Blocks)
+	session := bserv.SessionFromContext(ctx)
+	if session == nil {
+		session = bserv.NewSession(ctx, n.Blocks)
+	}
 	return &sesGetter{
 		bs:      session,
 		decoder: n.decoder,
@@ -299,7 +303,17 @@ const defaultConcurrentFetch = 32
 type walkOptions struct {
 	SkipRoot     bool
 	Concurrency  int
+	MaxDepth     int
 	ErrorHandler func(c cid.Cid, err error) error
+	Progress     func(WalkProgress)
+}
+
+func newWalkOptions(options []WalkOption) *walkOptions {
+	opts := &walkOptions{MaxDepth: -1}
+	for _, opt := range options {
+		opt(opts)
+	}
+	return opts
 }
 
 // WalkOption is a setter for walkOptions
@@ -342,6 +356,34 @@ func Concurrency(worker int) WalkOption {
 	}
 }
 
+// MaxDepth is a WalkOption limiting the walk to the nodes at most depth links
+// below the root, which is at depth 0. The links of the nodes at the maximum
+// depth are not fetched. A negative depth means unlimited, the default.
+func MaxDepth(depth int) WalkOption {
+	return func(walkOptions *walkOptions) {
+		walkOptions.MaxDepth = depth
+	}
+}
+
+// WalkProgress is the progress of a concurrent walk, see OnProgress.
+type WalkProgress struct {
+	// Visited is the number of nodes the visit function returned true for.
+	Visited int
+	// Queued is the number of nodes waiting to be handed to a worker.
+	Queued int
+	// InProgress is the number of nodes being visited or fetched.
+	InProgress int
+}
+
+// OnProgress is a WalkOption adding a callback reporting the progress of the
+// concurrent walks each time a node is done. It is called from a single
+// goroutine, and slows the walk down if it blocks.
+func OnProgress(callback func(WalkProgress)) WalkOption {
+	return func(walkOptions *walkOptions) {
+		walkOptions.Progress = callback
+	}
+}
+
 // IgnoreErrors is a WalkOption indicating that the walk should attempt to
 // continue even when an error occur.
 func IgnoreErrors() WalkOption {
@@ -399,10 +441,7 @@ func Walk(ctx context.Context, getLinks GetLinks, c cid.Cid, visit func(cid.Cid)
 // depth to a given visit function. The visit function can be used to limit DAG
 // exploration.
 func WalkDepth(ctx context.Context, getLinks GetLinks, c cid.Cid, visit func(cid.Cid, int) bool, options ...WalkOption) error {
-	opts := &walkOptions{}
-	for _, opt := range options {
-		opt(opts)
-	}
+	opts := newWalkOptions(options)
 
 	if opts.Concurrency > 1 {
 		return parallelWalkDepth(ctx, getLinks, c, visit, opts)
@@ -411,12 +450,35 @@ func WalkDepth(ctx context.Context, getLinks GetLinks, c cid.Cid, visit func(cid
 	}
 }
 
+// WalkGraphConcurrent walks the dag starting at the given root like
+// WalkDepth, fetching the links of the nodes with concurrent workers,
+// defaultConcurrentFetch unless set with the Concurrency option. The visit
+// function is never called concurrently, and the links of a node are only
+// fetched if it returns true, so that it can deduplicate the nodes. The walk
+// order is not guaranteed.
+//
+// The walk returns when all the nodes were walked, on the first error not
+// handled, or when the context is cancelled, once all its workers stopped.
+func WalkGraphConcurrent(ctx context.Context, getLinks GetLinks, root cid.Cid, visit func(cid.Cid, int) bool, options ...WalkOption) error {
+	opts := newWalkOptions(options)
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrentFetch
+	}
+	return parallelWalkDepth(ctx, getLinks, root, visit, opts)
+}
+
 func sequentialWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, depth int, visit func(cid.Cid, int) bool, options *walkOptions) error {
+	if options.MaxDepth >= 0 && depth > options.MaxDepth {
+		return nil
+	}
 	if !(options.SkipRoot && depth == 0) {
 		if !visit(root, depth) {
 			return nil
 		}
 	}
+	if depth == options.MaxDepth {
+		return nil
+	}
 
 	links, err := getLinks(ctx, root)
 	if err != nil && options.ErrorHandler != nil {
@@ -473,7 +535,7 @@ func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, vis
 
 	feed := make(chan cidDepth)
 	out := make(chan linksDepth)
-	done := make(chan struct{})
+	done := make(chan bool)
 
 	var visitlk sync.Mutex
 	var wg sync.WaitGroup
@@ -501,10 +563,11 @@ func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, vis
 					shouldVisit = true
 				}
 
-				if shouldVisit {
-					links, err := getLinks(ctx, ci)
+				// the links of the nodes at the maximum depth are not walked
+				if shouldVisit && depth != options.MaxDepth {
+					links, err := getLinks(fetchersCtx, ci)
 					if err != nil && options.ErrorHandler != nil {
-						err = options.ErrorHandler(root, err)
+						err = options.ErrorHandler(ci, err)
 					}
 					if err != nil {
 						select {
@@ -526,8 +589,9 @@ func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, vis
 					}
 				}
 				select {
-				case done <- struct{}{}:
+				case done <- shouldVisit:
 				case <-fetchersCtx.Done():
+					return
 				}
 			}
 		}()
@@ -536,7 +600,7 @@ func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, vis
 
 	send := feed
 	var todoQueue []cidDepth
-	var inProgress int
+	var inProgress, visited int
 
 	next := cidDepth{
 		cid:   root,
@@ -554,12 +618,25 @@ func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, vis
 				next = cidDepth{}
 				send = nil
 			}
-		case <-done:
+		case didVisit := <-done:
 			inProgress--
+			if didVisit {
+				visited++
+			}
+			if options.Progress != nil {
+				queued := len(todoQueue)
+				if next.cid.Defined() {
+					queued++
+				}
+				options.Progress(WalkProgress{Visited: visited, Queued: queued, InProgress: inProgress})
+			}
 			if inProgress == 0 && !next.cid.Defined() {
 				return nil
 			}
 		case linksDepth := <-out:
+			if options.MaxDepth >= 0 && linksDepth.depth > options.MaxDepth {
+				continue
+			}
 			for _, lnk := range linksDepth.links {
 				cd := cidDepth{
 					cid:   lnk.Cid,
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"io"
 	"math"
+	mrand "math/rand"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -608,6 +610,196 @@ func TestWalk(t *testing.T) {
 	traverseAndCheck(t, root, ds, set.Has)
 }
 
+// makeRandomDAG makes a DAG of n nodes, all reachable from the root, where
+// each node also links to up to extra random nodes made after it, so that
+// many nodes are shared.
+func makeRandomDAG(t testing.TB, ds ipld.DAGService, rng *mrand.Rand, n, extra int) cid.Cid {
+	children := make([][]int, n)
+	for i := 1; i < n; i++ {
+		parent := rng.Intn(i)
+		children[parent] = append(children[parent], i)
+	}
+
+	nodes := make([]*ProtoNode, n)
+	for i := n - 1; i >= 0; i-- {
+		links := children[i]
+		if i < n-1 {
+			for j := rng.Intn(extra + 1); j > 0; j-- {
+				links = append(links, i+1+rng.Intn(n-i-1))
+			}
+		}
+		nd := NodeWithData([]byte(strconv.Itoa(i)))
+		for j, child := range links {
+			if err := nd.AddNodeLink(strconv.Itoa(j), nodes[child]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ds.Add(context.Background(), nd); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = nd
+	}
+	return nodes[0].Cid()
+}
+
+func TestWalkGraphConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ds := NewDAGService(dstest.Bserv())
+	rng := mrand.New(mrand.NewSource(1))
+
+	for i := 0; i < 20; i++ {
+		root := makeRandomDAG(t, ds, rng, 200, 4)
+
+		expected := cid.NewSet()
+		if err := Walk(ctx, ds.GetLinks, root, expected.Visit); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, workers := range []int{1, 2, 8, 0} {
+			visited := cid.NewSet()
+			var last WalkProgress
+			err := WalkGraphConcurrent(ctx, ds.GetLinks, root, func(c cid.Cid, _ int) bool {
+				return visited.Visit(c)
+			}, Concurrency(workers), OnProgress(func(p WalkProgress) { last = p }))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if visited.Len() != expected.Len() {
+				t.Fatalf("visited %d nodes with %d workers, expected %d", visited.Len(), workers, expected.Len())
+			}
+			_ = expected.ForEach(func(c cid.Cid) error {
+				if !visited.Has(c) {
+					t.Fatalf("node %s not visited with %d workers", c, workers)
+				}
+				return nil
+			})
+			if last != (WalkProgress{Visited: expected.Len()}) {
+				t.Fatalf("unexpected final progress %+v", last)
+			}
+		}
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	ctx := context.Background()
+	ds := NewDAGService(dstest.Bserv())
+	root := makeDepthTestingGraph(t, ds)
+
+	tests := []struct {
+		maxDepth int
+		setLen   int
+	}{
+		{0, 1},
+		{1, 4},
+		{2, 6},
+		{-1, 6},
+	}
+
+	for _, tc := range tests {
+		for _, concurrency := range []int{1, 4} {
+			t.Run(fmt.Sprintf("max depth %d concurrency %d", tc.maxDepth, concurrency), func(t *testing.T) {
+				var lk sync.Mutex
+				set := make(map[cid.Cid]bool)
+				var fetched int
+				getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+					lk.Lock()
+					fetched++
+					lk.Unlock()
+					return ds.GetLinks(ctx, c)
+				}
+				err := WalkDepth(ctx, getLinks, root.Cid(), func(c cid.Cid, depth int) bool {
+					if tc.maxDepth >= 0 && depth > tc.maxDepth {
+						t.Errorf("visited %s at depth %d", c, depth)
+					}
+					set[c] = true
+					return true
+				}, MaxDepth(tc.maxDepth), Concurrency(concurrency))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(set) != tc.setLen {
+					t.Fatalf("expected %d nodes but visited %d", tc.setLen, len(set))
+				}
+				if tc.maxDepth == 0 && fetched != 0 {
+					t.Fatalf("fetched the links of %d nodes beyond the maximum depth", fetched)
+				}
+			})
+		}
+	}
+}
+
+func TestWalkGraphConcurrentCancel(t *testing.T) {
+	ds := NewDAGService(dstest.Bserv())
+	root, _ := mkDag(ds, 3)
+
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var lk sync.Mutex
+	var visited int
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		lk.Lock()
+		visited++
+		n := visited
+		lk.Unlock()
+		if n > 20 {
+			// hang until the walk is cancelled
+			cancel()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return ds.GetLinks(ctx, c)
+	}
+
+	errCh := make(chan error)
+	go func() {
+		set := cid.NewSet()
+		errCh <- WalkGraphConcurrent(ctx, getLinks, root, func(c cid.Cid, _ int) bool {
+			return set.Visit(c)
+		}, Concurrency(8))
+	}()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("walk did not return after cancellation")
+	}
+
+	// the workers must all be gone once the walk returned
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("leaked %d goroutines", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func BenchmarkWalkGraphConcurrent(b *testing.B) {
+	ds := NewDAGService(dstest.Bserv())
+	root := makeRandomDAG(b, ds, mrand.New(mrand.NewSource(1)), 500, 4)
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		time.Sleep(100 * time.Microsecond)
+		return ds.GetLinks(ctx, c)
+	}
+
+	for _, workers := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("workers %d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				set := cid.NewSet()
+				err := WalkGraphConcurrent(context.Background(), getLinks, root, func(c cid.Cid, _ int) bool {
+					return set.Visit(c)
+				}, Concurrency(workers))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestFetchFailure(t *testing.T) {
 	ctx := context.Background()
 
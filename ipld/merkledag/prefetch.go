@@ -0,0 +1,276 @@
+package merkledag
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// DefaultPrefetchMaxBytes is the default maximum size of the nodes cached by
+// the NodeGetters of NewPrefetchingNodeGetter.
+const DefaultPrefetchMaxBytes = 4 << 20
+
+// prefetchMaxParents is the number of nodes whose links are remembered by a
+// prefetchingNodeGetter to find the siblings of the nodes requested.
+const prefetchMaxParents = 64
+
+var errNotPrefetched = errors.New("node not prefetched")
+
+// PrefetchOption configures NewPrefetchingNodeGetter.
+type PrefetchOption func(*prefetchingNodeGetter)
+
+// PrefetchMaxBytes sets the maximum size of the nodes cached by the
+// NodeGetter, DefaultPrefetchMaxBytes by default. The oldest nodes are
+// dropped first.
+func PrefetchMaxBytes(n int) PrefetchOption {
+	return func(p *prefetchingNodeGetter) {
+		p.maxBytes = n
+	}
+}
+
+// prefetchingNodeGetter is a NodeGetter fetching the siblings of the nodes
+// requested with Get ahead of time, with GetMany, so that walking the links
+// of a node one by one does not wait for each of them in turn.
+type prefetchingNodeGetter struct {
+	ng       format.NodeGetter
+	window   int
+	maxBytes int
+
+	lk sync.Mutex
+	// siblings are the links of the last nodes returned, by link
+	siblings map[cid.Cid]siblingRef
+	parents  list.List
+	cache    map[cid.Cid]*prefetchEntry
+	// fetched are the entries of the cache already fetched, oldest first
+	fetched list.List
+	size    int
+}
+
+type siblingRef struct {
+	links []cid.Cid
+	index int
+}
+
+type prefetchEntry struct {
+	// ctx is the context of the GetMany fetching the node
+	ctx  context.Context
+	done chan struct{}
+	nd   format.Node
+	err  error
+	elem *list.Element
+}
+
+var _ format.NodeGetter = (*prefetchingNodeGetter)(nil)
+var _ SessionMaker = (*prefetchingNodeGetter)(nil)
+
+// NewPrefetchingNodeGetter returns a NodeGetter which, when asked for a node
+// linked by a node it returned, fetches the next window siblings of the node
+// with a single GetMany on ng and caches them for the following Get calls.
+// This is most useful with a session backed NodeGetter, see NewSession.
+//
+// The nodes are only cached until they are returned once, and those fetched
+// with a context which is done are dropped. Nodes which could not be
+// prefetched are fetched with Get, so that the nodes returned are the same
+// as the ones of ng. ng is returned as is if window is less than 2.
+func NewPrefetchingNodeGetter(ng format.NodeGetter, window int, opts ...PrefetchOption) format.NodeGetter {
+	if window < 2 {
+		return ng
+	}
+	p := &prefetchingNodeGetter{
+		ng:       ng,
+		window:   window,
+		maxBytes: DefaultPrefetchMaxBytes,
+		siblings: make(map[cid.Cid]siblingRef),
+		cache:    make(map[cid.Cid]*prefetchEntry),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Get returns the node for the given CID, from the nodes prefetched if it
+// was, and prefetches its next siblings.
+func (p *prefetchingNodeGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	p.lk.Lock()
+	p.prefetch(ctx, c)
+	e := p.cache[c]
+	p.lk.Unlock()
+
+	if e != nil {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.lk.Lock()
+		p.remove(c, e)
+		p.lk.Unlock()
+		if e.err == nil {
+			p.addSiblings(e.nd)
+			return e.nd, nil
+		}
+	}
+
+	nd, err := p.ng.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	p.addSiblings(nd)
+	return nd, nil
+}
+
+// GetMany returns the nodes of ng.GetMany.
+func (p *prefetchingNodeGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *format.NodeOption {
+	return p.ng.GetMany(ctx, keys)
+}
+
+// Session returns a prefetchingNodeGetter wrapping a session of the
+// NodeGetter, if it implements SessionMaker.
+func (p *prefetchingNodeGetter) Session(ctx context.Context) format.NodeGetter {
+	ng := NewSession(ctx, p.ng)
+	if ng == p.ng {
+		return p
+	}
+	return &prefetchingNodeGetter{
+		ng:       ng,
+		window:   p.window,
+		maxBytes: p.maxBytes,
+		siblings: make(map[cid.Cid]siblingRef),
+		cache:    make(map[cid.Cid]*prefetchEntry),
+	}
+}
+
+// prefetch starts fetching the window of siblings starting at c, if c or
+// enough of them are not cached yet. It drops the entries of the window
+// whose context is done.
+func (p *prefetchingNodeGetter) prefetch(ctx context.Context, c cid.Cid) {
+	if e, ok := p.cache[c]; ok && e.ctx.Err() != nil {
+		p.remove(c, e)
+	}
+
+	ref, ok := p.siblings[c]
+	if !ok {
+		return
+	}
+	end := ref.index + p.window
+	if end > len(ref.links) {
+		end = len(ref.links)
+	}
+	var missing []cid.Cid
+	for _, l := range ref.links[ref.index:end] {
+		if e, ok := p.cache[l]; ok && e != nil && e.ctx.Err() != nil {
+			p.remove(l, e)
+		}
+		if _, ok := p.cache[l]; !ok {
+			missing = append(missing, l)
+			// a node linked twice is fetched once
+			p.cache[l] = nil
+		}
+	}
+	for _, l := range missing {
+		delete(p.cache, l)
+	}
+
+	// fetch half a window at least, unless c itself is missing, so that
+	// walking the siblings does not fetch them one at a time
+	_, cached := p.cache[c]
+	if len(missing) == 0 || (cached && len(missing) < p.window/2) {
+		return
+	}
+
+	entries := make(map[cid.Cid]*prefetchEntry, len(missing))
+	for _, l := range missing {
+		e := &prefetchEntry{ctx: ctx, done: make(chan struct{})}
+		entries[l] = e
+		p.cache[l] = e
+	}
+	go p.fetch(ctx, missing, entries)
+}
+
+// fetch fetches the entries with GetMany, and drops the ones not returned.
+func (p *prefetchingNodeGetter) fetch(ctx context.Context, keys []cid.Cid, entries map[cid.Cid]*prefetchEntry) {
+	for opt := range p.ng.GetMany(ctx, keys) {
+		if opt.Err != nil {
+			continue
+		}
+		e, ok := entries[opt.Node.Cid()]
+		if !ok {
+			continue
+		}
+		delete(entries, opt.Node.Cid())
+
+		p.lk.Lock()
+		e.nd = opt.Node
+		if p.cache[opt.Node.Cid()] == e {
+			e.elem = p.fetched.PushBack(opt.Node.Cid())
+			p.size += len(opt.Node.RawData())
+			p.evict()
+		}
+		p.lk.Unlock()
+		close(e.done)
+	}
+
+	p.lk.Lock()
+	for c, e := range entries {
+		e.err = errNotPrefetched
+		p.remove(c, e)
+	}
+	p.lk.Unlock()
+	for _, e := range entries {
+		close(e.done)
+	}
+}
+
+// evict drops the oldest nodes fetched until the cache fits in maxBytes.
+func (p *prefetchingNodeGetter) evict() {
+	for p.size > p.maxBytes && p.fetched.Len() > 0 {
+		c := p.fetched.Front().Value.(cid.Cid)
+		p.remove(c, p.cache[c])
+	}
+}
+
+// remove removes the entry of c from the cache, if it is still there.
+func (p *prefetchingNodeGetter) remove(c cid.Cid, e *prefetchEntry) {
+	if e == nil || p.cache[c] != e {
+		return
+	}
+	delete(p.cache, c)
+	if e.elem != nil {
+		p.fetched.Remove(e.elem)
+		p.size -= len(e.nd.RawData())
+		e.elem = nil
+	}
+}
+
+// addSiblings remembers the links of the node to prefetch them, forgetting
+// the links of the oldest nodes beyond prefetchMaxParents.
+func (p *prefetchingNodeGetter) addSiblings(nd format.Node) {
+	links := nd.Links()
+	if len(links) < 2 {
+		return
+	}
+	cids := make([]cid.Cid, len(links))
+	for i, l := range links {
+		cids[i] = l.Cid
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	for i, c := range cids {
+		p.siblings[c] = siblingRef{links: cids, index: i}
+	}
+	p.parents.PushBack(cids)
+	for p.parents.Len() > prefetchMaxParents {
+		old := p.parents.Remove(p.parents.Front()).([]cid.Cid)
+		for _, c := range old {
+			if ref, ok := p.siblings[c]; ok && &ref.links[0] == &old[0] {
+				delete(p.siblings, c)
+			}
+		}
+	}
+}
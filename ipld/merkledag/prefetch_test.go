@@ -0,0 +1,161 @@
+package merkledag_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/ipfs/boxo/ipld/merkledag"
+	dstest "github.com/ipfs/boxo/ipld/merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// countingGetter counts the calls to a NodeGetter, and delays them by
+// latency.
+type countingGetter struct {
+	ipld.NodeGetter
+	latency time.Duration
+	// noBatch makes GetMany fail, like a getter without batching support
+	noBatch bool
+
+	gets    atomic.Int32
+	batches atomic.Int32
+}
+
+func (cg *countingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	cg.gets.Add(1)
+	time.Sleep(cg.latency)
+	return cg.NodeGetter.Get(ctx, c)
+}
+
+func (cg *countingGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	cg.batches.Add(1)
+	if cg.noBatch {
+		out := make(chan *ipld.NodeOption, 1)
+		out <- &ipld.NodeOption{Err: errors.New("batching not supported")}
+		close(out)
+		return out
+	}
+	time.Sleep(cg.latency)
+	return cg.NodeGetter.GetMany(ctx, keys)
+}
+
+// makeWideNode adds a node with width raw children.
+func makeWideNode(t testing.TB, ds ipld.DAGService, width int) ipld.Node {
+	ctx := context.Background()
+	parent := NodeWithData(nil)
+	for i := 0; i < width; i++ {
+		data := make([]byte, 64)
+		rand.Read(data)
+		child := NewRawNode(data)
+		if err := ds.Add(ctx, child); err != nil {
+			t.Fatal(err)
+		}
+		if err := parent.AddNodeLink(strconv.Itoa(i), child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ds.Add(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	return parent
+}
+
+// walkChildren gets the node and then each of its children in turn.
+func walkChildren(ctx context.Context, ng ipld.NodeGetter, c cid.Cid) error {
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	for _, l := range nd.Links() {
+		child, err := ng.Get(ctx, l.Cid)
+		if err != nil {
+			return err
+		}
+		if child.Cid() != l.Cid {
+			return fmt.Errorf("got %s instead of %s", child.Cid(), l.Cid)
+		}
+	}
+	return nil
+}
+
+func TestPrefetchingNodeGetter(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.Mock()
+	parent := makeWideNode(t, ds, 1000)
+
+	cg := &countingGetter{NodeGetter: ds}
+	if err := walkChildren(ctx, NewPrefetchingNodeGetter(cg, 100), parent.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	// the children are fetched half a window at a time at worst
+	if gets, batches := cg.gets.Load(), cg.batches.Load(); gets != 1 || batches > 21 {
+		t.Fatalf("expected the parent alone to be fetched with Get and at most 21 batches, got %d and %d", gets, batches)
+	}
+
+	t.Run("without batching", func(t *testing.T) {
+		cg := &countingGetter{NodeGetter: ds, noBatch: true}
+		if err := walkChildren(ctx, NewPrefetchingNodeGetter(cg, 100), parent.Cid()); err != nil {
+			t.Fatal(err)
+		}
+		if gets := cg.gets.Load(); gets != 1001 {
+			t.Fatalf("expected 1001 gets, got %d", gets)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		cg := &countingGetter{NodeGetter: ds}
+		ng := NewPrefetchingNodeGetter(cg, 100)
+		nd, err := ng.Get(ctx, parent.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cctx, cancel := context.WithCancel(ctx)
+		if _, err := ng.Get(cctx, nd.Links()[0].Cid); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+
+		// the nodes prefetched with the cancelled context are fetched
+		// again
+		if err := walkChildren(ctx, ng, parent.Cid()); err != nil {
+			t.Fatal(err)
+		}
+		if batches := cg.batches.Load(); batches < 2 || batches > 22 {
+			t.Fatalf("unexpected number of batches %d", batches)
+		}
+	})
+
+	t.Run("bounded cache", func(t *testing.T) {
+		cg := &countingGetter{NodeGetter: ds}
+		ng := NewPrefetchingNodeGetter(cg, 100, PrefetchMaxBytes(256))
+		if err := walkChildren(ctx, ng, parent.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkPrefetchingNodeGetter(b *testing.B) {
+	ds := dstest.Mock()
+	parent := makeWideNode(b, ds, 1000)
+	cg := &countingGetter{NodeGetter: ds, latency: 100 * time.Microsecond}
+
+	for _, window := range []int{0, 16, 64, 256} {
+		b.Run(fmt.Sprintf("window %d", window), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ng := NewPrefetchingNodeGetter(cg, window)
+				if err := walkChildren(context.Background(), ng, parent.Cid()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package merkledag
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// statBatchSize is the maximum number of nodes requested by each GetMany call
+// of Stat.
+const statBatchSize = 1024
+
+// statConfidenceZ is the z-score of the 95% confidence bounds of the
+// estimates of a sampled Stat.
+const statConfidenceZ = 1.96
+
+// StatBuckets are the upper bounds, inclusive, of the buckets of
+// DagStat.Histogram, in bytes. The last bucket of the histogram holds the
+// blocks larger than all of them.
+var StatBuckets = []int{1 << 10, 16 << 10, 256 << 10, 1 << 20}
+
+// StatOptions configures Stat.
+type StatOptions struct {
+	// Samples switches Stat to the sampling mode when positive: instead of
+	// traversing the whole DAG, it descends Samples random paths from the
+	// root to a leaf and estimates the figures from them.
+	Samples int
+
+	// Rand is the source of the random paths of the sampling mode. A time
+	// seeded one is used if nil.
+	Rand *rand.Rand
+}
+
+// SizeBucket is a bucket of DagStat.Histogram.
+type SizeBucket struct {
+	// Max is the size of the largest blocks of the bucket, or math.MaxInt
+	// for the last one.
+	Max int
+	// Count is the number of blocks of the bucket.
+	Count int64
+}
+
+// Estimate is a figure estimated by a sampled Stat, with its 95% confidence
+// bounds.
+type Estimate struct {
+	Value float64
+	Low   float64
+	High  float64
+}
+
+// DagStat are the statistics of a DAG returned by Stat.
+type DagStat struct {
+	// Blocks is the number of unique blocks of the DAG.
+	Blocks int64
+	// Bytes is the sum of the sizes of the unique blocks.
+	Bytes uint64
+	// MaxDepth is the length of the longest chain of links from the root
+	// to a leaf. A root without links is at depth 0.
+	MaxDepth int
+	// Leaves is the number of blocks without links, and Internal the
+	// number of blocks with links.
+	Leaves   int64
+	Internal int64
+	// LargestBlock is the CID of the largest block, of LargestSize bytes.
+	LargestBlock cid.Cid
+	LargestSize  int
+	// Histogram counts the blocks by size, in the buckets of StatBuckets.
+	Histogram []SizeBucket
+
+	// Sampled is true when the statistics were estimated by the sampling
+	// mode. Blocks, Bytes, Leaves, Internal and the Histogram are then
+	// rounded estimates, whose values and bounds are in BlocksEstimate and
+	// BytesEstimate. MaxDepth and LargestBlock are the ones of the sampled
+	// paths, which are lower bounds.
+	Sampled        bool
+	BlocksEstimate Estimate
+	BytesEstimate  Estimate
+}
+
+func newHistogram() []SizeBucket {
+	h := make([]SizeBucket, len(StatBuckets)+1)
+	for i, max := range StatBuckets {
+		h[i].Max = max
+	}
+	h[len(StatBuckets)].Max = math.MaxInt
+	return h
+}
+
+func histogramBucket(size int) int {
+	for i, max := range StatBuckets {
+		if size <= max {
+			return i
+		}
+	}
+	return len(StatBuckets)
+}
+
+// Stat returns the statistics of the DAG under root. They are exact and
+// gathered by traversing the whole DAG, fetching the nodes of each level
+// with GetMany. The blocks are counted by CID, so that shared subtrees count
+// once. The Tsize of the links is not used.
+//
+// The nodes are fetched through a session when ng implements SessionMaker,
+// and a session backed NodeGetter can be given as is.
+//
+// With StatOptions.Samples, Stat estimates the statistics of DAGs too large
+// to traverse by descending random paths instead (Knuth's estimator). The
+// estimates are unbiased for trees, but the shared subtrees of a DAG are
+// counted each time they are linked.
+func Stat(ctx context.Context, ng format.NodeGetter, root cid.Cid, opts StatOptions) (DagStat, error) {
+	ng = NewSession(ctx, ng)
+	if opts.Samples > 0 {
+		return sampleStat(ctx, ng, root, opts)
+	}
+	return exactStat(ctx, ng, root)
+}
+
+func exactStat(ctx context.Context, ng format.NodeGetter, root cid.Cid) (DagStat, error) {
+	stat := DagStat{Histogram: newHistogram()}
+
+	// the children of each node, by index, to compute the depth once all
+	// the nodes were fetched
+	index := map[cid.Cid]int{root: 0}
+	children := [][]int{nil}
+
+	level := []cid.Cid{root}
+	for len(level) > 0 {
+		var next []cid.Cid
+		for start := 0; start < len(level); start += statBatchSize {
+			end := start + statBatchSize
+			if end > len(level) {
+				end = len(level)
+			}
+			batch := level[start:end]
+			for opt := range ng.GetMany(ctx, batch) {
+				if opt.Err != nil {
+					return DagStat{}, opt.Err
+				}
+				nd := opt.Node
+				stat.addBlock(nd.Cid(), len(nd.RawData()), len(nd.Links()) == 0)
+
+				i := index[nd.Cid()]
+				for _, l := range nd.Links() {
+					ci, ok := index[l.Cid]
+					if !ok {
+						ci = len(children)
+						index[l.Cid] = ci
+						children = append(children, nil)
+						next = append(next, l.Cid)
+					}
+					children[i] = append(children[i], ci)
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				return DagStat{}, err
+			}
+		}
+		level = next
+	}
+
+	stat.MaxDepth = dagHeight(children)
+	return stat, nil
+}
+
+// dagHeight returns the length of the longest path from the node 0 of the
+// DAG given by the children of its nodes.
+func dagHeight(children [][]int) int {
+	height := make([]int, len(children))
+	for i := range height {
+		height[i] = -1
+	}
+
+	type frame struct {
+		node, child int
+	}
+	stack := []frame{{node: 0}}
+	height[0] = 0
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.child < len(children[top.node]) {
+			c := children[top.node][top.child]
+			top.child++
+			if height[c] < 0 {
+				height[c] = 0
+				stack = append(stack, frame{node: c})
+			}
+			continue
+		}
+		h := 0
+		for _, c := range children[top.node] {
+			if height[c]+1 > h {
+				h = height[c] + 1
+			}
+		}
+		height[top.node] = h
+		stack = stack[:len(stack)-1]
+	}
+	return height[0]
+}
+
+func (stat *DagStat) addBlock(c cid.Cid, size int, leaf bool) {
+	stat.Blocks++
+	stat.Bytes += uint64(size)
+	if leaf {
+		stat.Leaves++
+	} else {
+		stat.Internal++
+	}
+	stat.Histogram[histogramBucket(size)].Count++
+	stat.addLargest(c, size)
+}
+
+func (stat *DagStat) addLargest(c cid.Cid, size int) {
+	if size > stat.LargestSize || !stat.LargestBlock.Defined() {
+		stat.LargestBlock = c
+		stat.LargestSize = size
+	}
+}
+
+type sampledNode struct {
+	size  int
+	links []cid.Cid
+}
+
+func sampleStat(ctx context.Context, ng format.NodeGetter, root cid.Cid, opts StatOptions) (DagStat, error) {
+	rng := opts.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	// the nodes on the sampled paths, which start with the same nodes
+	seen := make(map[cid.Cid]sampledNode)
+	get := func(c cid.Cid) (sampledNode, error) {
+		if sn, ok := seen[c]; ok {
+			return sn, nil
+		}
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return sampledNode{}, err
+		}
+		sn := sampledNode{size: len(nd.RawData())}
+		for _, l := range nd.Links() {
+			sn.links = append(sn.links, l.Cid)
+		}
+		seen[c] = sn
+		return sn, nil
+	}
+
+	stat := DagStat{Sampled: true}
+	var leaves, internal float64
+	histogram := make([]float64, len(StatBuckets)+1)
+	blocks := make([]float64, opts.Samples)
+	bytes := make([]float64, opts.Samples)
+
+	for i := 0; i < opts.Samples; i++ {
+		// each node of the path stands for as many nodes as the product
+		// of the branching factors above it
+		weight := 1.0
+		c := root
+		for depth := 0; ; depth++ {
+			sn, err := get(c)
+			if err != nil {
+				return DagStat{}, err
+			}
+			blocks[i] += weight
+			bytes[i] += weight * float64(sn.size)
+			histogram[histogramBucket(sn.size)] += weight
+			stat.addLargest(c, sn.size)
+
+			if len(sn.links) == 0 {
+				leaves += weight
+				if depth > stat.MaxDepth {
+					stat.MaxDepth = depth
+				}
+				break
+			}
+			internal += weight
+			weight *= float64(len(sn.links))
+			c = sn.links[rng.Intn(len(sn.links))]
+		}
+		if err := ctx.Err(); err != nil {
+			return DagStat{}, err
+		}
+	}
+
+	n := float64(opts.Samples)
+	stat.BlocksEstimate = newEstimate(blocks)
+	stat.BytesEstimate = newEstimate(bytes)
+	stat.Blocks = int64(math.Round(stat.BlocksEstimate.Value))
+	stat.Bytes = uint64(math.Round(stat.BytesEstimate.Value))
+	stat.Leaves = int64(math.Round(leaves / n))
+	stat.Internal = int64(math.Round(internal / n))
+	stat.Histogram = newHistogram()
+	for i, count := range histogram {
+		stat.Histogram[i].Count = int64(math.Round(count / n))
+	}
+	return stat, nil
+}
+
+// newEstimate returns the mean of the samples, with the bounds of its 95%
+// confidence interval.
+func newEstimate(samples []float64) Estimate {
+	n := float64(len(samples))
+	var sum float64
+	for _, s := range samples {
+		sum += s
+	}
+	mean := sum / n
+
+	if len(samples) < 2 {
+		return Estimate{Value: mean, Low: mean, High: mean}
+	}
+	var variance float64
+	for _, s := range samples {
+		variance += (s - mean) * (s - mean)
+	}
+	variance /= n - 1
+	margin := statConfidenceZ * math.Sqrt(variance/n)
+	return Estimate{Value: mean, Low: math.Max(mean-margin, 0), High: mean + margin}
+}
@@ -0,0 +1,180 @@
+package merkledag_test
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	. "github.com/ipfs/boxo/ipld/merkledag"
+	dstest "github.com/ipfs/boxo/ipld/merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.Mock()
+
+	add := func(nd ipld.Node) ipld.Node {
+		if err := ds.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	link := func(nds ...ipld.Node) ipld.Node {
+		pn := NodeWithData([]byte("internal"))
+		for i, nd := range nds {
+			if err := pn.AddNodeLink(strconv.Itoa(i), nd); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return add(pn)
+	}
+
+	// root -> a -> shared -> x
+	//      -> b -> shared
+	//           -> y
+	x := add(NewRawNode([]byte("leaf x")))
+	y := add(NewRawNode(make([]byte, 2000)))
+	shared := link(x)
+	a := link(shared)
+	b := link(shared, y)
+	root := link(a, b)
+
+	stat, err := Stat(ctx, ds, root.Cid(), StatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bytes uint64
+	for _, nd := range []ipld.Node{x, y, shared, a, b, root} {
+		bytes += uint64(len(nd.RawData()))
+	}
+	if stat.Blocks != 6 || stat.Bytes != bytes {
+		t.Fatalf("expected 6 blocks of %d bytes, got %d of %d", bytes, stat.Blocks, stat.Bytes)
+	}
+	if stat.Leaves != 2 || stat.Internal != 4 {
+		t.Fatalf("expected 2 leaves and 4 internal nodes, got %d and %d", stat.Leaves, stat.Internal)
+	}
+	if stat.MaxDepth != 3 {
+		t.Fatalf("expected a depth of 3, got %d", stat.MaxDepth)
+	}
+	if stat.LargestBlock != y.Cid() || stat.LargestSize != 2000 {
+		t.Fatalf("expected the largest block to be %s, got %s of %d bytes", y.Cid(), stat.LargestBlock, stat.LargestSize)
+	}
+	if stat.Histogram[0].Count != 5 || stat.Histogram[1].Count != 1 || stat.Histogram[1].Max != 16<<10 {
+		t.Fatalf("unexpected histogram %v", stat.Histogram)
+	}
+	if stat.Sampled {
+		t.Fatal("exact statistics reported as sampled")
+	}
+
+	t.Run("lone root", func(t *testing.T) {
+		stat, err := Stat(ctx, ds, x.Cid(), StatOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Blocks != 1 || stat.Leaves != 1 || stat.MaxDepth != 0 || stat.LargestBlock != x.Cid() {
+			t.Fatalf("unexpected stat %+v", stat)
+		}
+	})
+
+	t.Run("session", func(t *testing.T) {
+		top, children := mkDag(ds, 3)
+		stat, err := Stat(ctx, NewSession(ctx, ds), top, StatOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Blocks != int64(children+1) || stat.Leaves != 1000 || stat.MaxDepth != 3 {
+			t.Fatalf("unexpected stat %+v", stat)
+		}
+	})
+
+	t.Run("missing block", func(t *testing.T) {
+		missing := NodeWithData([]byte("missing"))
+		if _, err := Stat(ctx, ds, link(missing).Cid(), StatOptions{}); err == nil {
+			t.Fatal("expected an error for the missing block")
+		}
+	})
+}
+
+// makeRandomTree makes a tree of the given depth where each node has
+// between 1 and 6 children, and returns its root and its exact statistics.
+func makeRandomTree(t *testing.T, ds ipld.DAGService, rng *rand.Rand, depth int) (cid.Cid, DagStat) {
+	var stat DagStat
+	var mk func(depth int) ipld.Node
+	mk = func(depth int) ipld.Node {
+		var nd ipld.Node
+		if depth == 0 {
+			data := make([]byte, 1+rng.Intn(4096))
+			rng.Read(data)
+			nd = NewRawNode(data)
+		} else {
+			pn := NodeWithData([]byte(strconv.Itoa(rng.Int())))
+			for i := rng.Intn(6); i >= 0; i-- {
+				if err := pn.AddNodeLink(strconv.Itoa(i), mk(depth-1)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			nd = pn
+		}
+		if err := ds.Add(context.Background(), nd); err != nil {
+			t.Fatal(err)
+		}
+		stat.Blocks++
+		stat.Bytes += uint64(len(nd.RawData()))
+		return nd
+	}
+	return mk(depth).Cid(), stat
+}
+
+func TestStatSampling(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.Mock()
+	rng := rand.New(rand.NewSource(1))
+	root, expected := makeRandomTree(t, ds, rng, 6)
+
+	stat, err := Stat(ctx, ds, root, StatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Blocks != expected.Blocks || stat.Bytes != expected.Bytes {
+		t.Fatalf("expected %d blocks of %d bytes, got %d of %d", expected.Blocks, expected.Bytes, stat.Blocks, stat.Bytes)
+	}
+
+	const runs = 40
+	var blocks, bytes float64
+	var blocksWithin, bytesWithin int
+	for i := 0; i < runs; i++ {
+		stat, err := Stat(ctx, ds, root, StatOptions{Samples: 200, Rand: rand.New(rand.NewSource(int64(i)))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stat.Sampled || stat.MaxDepth != 6 {
+			t.Fatalf("unexpected sampled stat %+v", stat)
+		}
+		blocks += stat.BlocksEstimate.Value
+		bytes += stat.BytesEstimate.Value
+		if e := stat.BlocksEstimate; e.Low <= float64(expected.Blocks) && float64(expected.Blocks) <= e.High {
+			blocksWithin++
+		}
+		if e := stat.BytesEstimate; e.Low <= float64(expected.Bytes) && float64(expected.Bytes) <= e.High {
+			bytesWithin++
+		}
+	}
+
+	// the mean of the estimates is close to the exact figures, which are
+	// within the 95% confidence bounds of most runs
+	if bias := math.Abs(blocks/runs/float64(expected.Blocks) - 1); bias > 0.03 {
+		t.Fatalf("block count estimates biased by %.1f%%", bias*100)
+	}
+	if bias := math.Abs(bytes/runs/float64(expected.Bytes) - 1); bias > 0.03 {
+		t.Fatalf("byte count estimates biased by %.1f%%", bias*100)
+	}
+	if blocksWithin < runs*8/10 || bytesWithin < runs*8/10 {
+		t.Fatalf("exact figures within the bounds of only %d and %d of %d runs", blocksWithin, bytesWithin, runs)
+	}
+}
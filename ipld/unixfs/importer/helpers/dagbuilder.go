@@ -89,6 +89,16 @@ func (dbp *DagBuilderParams) New(spl chunker.Splitter) (*DagBuilderHelper, error
 	return db, nil
 }
 
+// NewFromConfig generates a new DagBuilderHelper from the given params, with
+// the data of r split by the splitter of the given chunker.Config.
+func (dbp *DagBuilderParams) NewFromConfig(r io.Reader, c chunker.Config) (*DagBuilderHelper, error) {
+	spl, err := chunker.FromConfig(r, c)
+	if err != nil {
+		return nil, err
+	}
+	return dbp.New(spl)
+}
+
 // prepareNext consumes the next item from the splitter and puts it
 // in the nextData field. it is idempotent-- if nextData is full
 // it will do nothing.
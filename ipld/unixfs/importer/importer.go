@@ -3,6 +3,8 @@
 package importer
 
 import (
+	"io"
+
 	bal "github.com/ipfs/boxo/ipld/unixfs/importer/balanced"
 	h "github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
 	trickle "github.com/ipfs/boxo/ipld/unixfs/importer/trickle"
@@ -25,6 +27,20 @@ func BuildDagFromReader(ds ipld.DAGService, spl chunker.Splitter) (ipld.Node, er
 	return bal.Layout(db)
 }
 
+// BuildDagFromConfig creates a DAG given a DAGService and a reader, split with
+// the splitter of the given chunker.Config, using a Balanced layout.
+func BuildDagFromConfig(ds ipld.DAGService, r io.Reader, c chunker.Config) (ipld.Node, error) {
+	dbp := h.DagBuilderParams{
+		Dagserv:  ds,
+		Maxlinks: h.DefaultLinksPerBlock,
+	}
+	db, err := dbp.NewFromConfig(r, c)
+	if err != nil {
+		return nil, err
+	}
+	return bal.Layout(db)
+}
+
 // BuildTrickleDagFromReader creates a DAG given a DAGService and a Splitter
 // implementation (Splitters are io.Readers), using a Trickle Layout.
 func BuildTrickleDagFromReader(ds ipld.DAGService, spl chunker.Splitter) (ipld.Node, error) {
@@ -39,3 +55,17 @@ func BuildTrickleDagFromReader(ds ipld.DAGService, spl chunker.Splitter) (ipld.N
 	}
 	return trickle.Layout(db)
 }
+
+// BuildTrickleDagFromConfig creates a DAG given a DAGService and a reader,
+// split with the splitter of the given chunker.Config, using a Trickle layout.
+func BuildTrickleDagFromConfig(ds ipld.DAGService, r io.Reader, c chunker.Config) (ipld.Node, error) {
+	dbp := h.DagBuilderParams{
+		Dagserv:  ds,
+		Maxlinks: h.DefaultLinksPerBlock,
+	}
+	db, err := dbp.NewFromConfig(r, c)
+	if err != nil {
+		return nil, err
+	}
+	return trickle.Layout(db)
+}
@@ -69,6 +69,36 @@ func TestStableCid(t *testing.T) {
 	}
 }
 
+func TestBuildDagFromConfig(t *testing.T) {
+	buf := make([]byte, 1024*1024)
+	u.NewSeededRand(0xdeadbeef).Read(buf)
+	c := chunker.Config{Kind: chunker.KindSize, Size: 4096}
+
+	for _, build := range []struct {
+		fromReader func(ipld.DAGService, chunker.Splitter) (ipld.Node, error)
+		fromConfig func(ipld.DAGService, io.Reader, chunker.Config) (ipld.Node, error)
+	}{
+		{BuildDagFromReader, BuildDagFromConfig},
+		{BuildTrickleDagFromReader, BuildTrickleDagFromConfig},
+	} {
+		expected, err := build.fromReader(mdtest.Mock(), chunker.NewSizeSplitter(bytes.NewReader(buf), 4096))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nd, err := build.fromConfig(mdtest.Mock(), bytes.NewReader(buf), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !expected.Cid().Equals(nd.Cid()) {
+			t.Fatalf("expected CID %s, got CID %s", expected.Cid(), nd.Cid())
+		}
+
+		if _, err := build.fromConfig(mdtest.Mock(), bytes.NewReader(buf), chunker.Config{Kind: chunker.KindSize}); err != chunker.ErrSize {
+			t.Fatalf("expected an 'ErrSize' error, got: %#v", err)
+		}
+	}
+}
+
 func TestBalancedDag(t *testing.T) {
 	ds := mdtest.Mock()
 	buf := make([]byte, 10000)
@@ -2,6 +2,7 @@ package ipns
 
 import (
 	"errors"
+	"fmt"
 )
 
 // MaxRecordSize is the IPNS Record [size limit].
@@ -20,8 +21,33 @@ var ErrUnrecognizedValidity = errors.New("record contains an unrecognized validi
 var ErrInvalidValidity = errors.New("record contains an invalid validity")
 
 // ErrRecordSize is returned when an IPNS [Record] exceeds the maximum size.
+// The error returned is a [*RecordSizeError], which matches ErrRecordSize
+// with [errors.Is].
 var ErrRecordSize = errors.New("record exceeds allowed size limit")
 
+// RecordSizeError is returned when an IPNS [Record] of Size bytes exceeds the
+// Limit, which defaults to [MaxRecordSize] and is set with [WithMaxRecordSize].
+type RecordSizeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *RecordSizeError) Error() string {
+	return fmt.Sprintf("%s: %d bytes, limit is %d", ErrRecordSize, e.Size, e.Limit)
+}
+
+func (e *RecordSizeError) Is(target error) bool {
+	return target == ErrRecordSize
+}
+
+// checkRecordSize returns a [*RecordSizeError] when size exceeds limit.
+func checkRecordSize(size, limit int) error {
+	if size > limit {
+		return &RecordSizeError{Size: size, Limit: limit}
+	}
+	return nil
+}
+
 // ErrDataMissing is returned when an IPNS [Record] is missing the data field.
 var ErrDataMissing = errors.New("record is missing the dag-cbor data field")
 
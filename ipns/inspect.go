@@ -0,0 +1,197 @@
+package ipns
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/boxo/util"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/multierr"
+	"google.golang.org/protobuf/proto"
+)
+
+// Inspection is a report on an IPNS [Record], as returned by [Inspect]. The
+// fields which cannot be read from the record are left to their zero value.
+type Inspection struct {
+	Value        path.Path
+	ValidityType ValidityType
+	Validity     time.Time
+	Sequence     uint64
+	TTL          time.Duration
+
+	// SignatureV1 and SignatureV2 tell which signatures the record carries.
+	SignatureV1 bool
+	SignatureV2 bool
+
+	// SignatureV2Valid is true when the V2 signature verifies against the
+	// public key of the name given to [Inspect].
+	SignatureV2Valid bool
+
+	// PublicKey is the public key embedded in the record, if any.
+	PublicKey ic.PubKey
+
+	// PublicKeyMatchesName is true when PublicKey is the key of the name
+	// given to [Inspect].
+	PublicKeyMatchesName bool
+
+	// Size is the size of the encoded record, which must not exceed
+	// [MaxRecordSize], or the limit set with [WithMaxRecordSize].
+	Size int
+
+	// Problems lists the failed checks, as returned by [ValidateWithReasons].
+	// It is empty when the record is valid.
+	Problems []error
+}
+
+// Inspect returns a report on rec as published under name, for diagnostics.
+// The size of rec is checked against [MaxRecordSize], or the limit set with
+// [WithMaxRecordSize].
+func Inspect(rec *Record, name Name, opts ...Option) Inspection {
+	fields := readFields(rec)
+	ins := Inspection{
+		Value:        fields.value,
+		ValidityType: fields.validityType,
+		Validity:     fields.validity,
+		Sequence:     fields.sequence,
+		TTL:          fields.ttl,
+		SignatureV1:  len(rec.pb.GetSignatureV1()) != 0,
+		SignatureV2:  len(rec.pb.GetSignatureV2()) != 0,
+		Size:         proto.Size(rec.pb),
+	}
+
+	if pk, err := rec.PubKey(); err == nil {
+		ins.PublicKey = pk
+		if pid, err := peer.IDFromPublicKey(pk); err == nil {
+			ins.PublicKeyMatchesName = name.Equal(NameFromPeer(pid))
+		}
+	}
+
+	if pk, err := ExtractPublicKey(rec, name); err == nil {
+		ins.SignatureV2Valid = verifySignatureV2(rec, pk) == nil
+	}
+
+	ins.Problems = validateWithReasons(rec, name, fields, opts)
+	return ins
+}
+
+// InspectBytes is [Inspect] for the serialized record data. Unlike
+// [UnmarshalRecord], it accepts the records over the size limit and the
+// V1-only records, which have no DAG-CBOR data, and reports them in the
+// Problems of the [Inspection]. It only fails on data which cannot be
+// decoded.
+func InspectBytes(data []byte, name Name, opts ...Option) (Inspection, error) {
+	rec, err := unmarshalRecord(data, true)
+	if err != nil {
+		return Inspection{}, err
+	}
+	return Inspect(rec, name, opts...), nil
+}
+
+// ValidateWithReasons validates rec against name like [ValidateWithName], but
+// goes through all the checks and returns every failure instead of the first
+// one. It returns nil when the record is valid.
+func ValidateWithReasons(rec *Record, name Name, opts ...Option) []error {
+	return validateWithReasons(rec, name, readFields(rec), opts)
+}
+
+func validateWithReasons(rec *Record, name Name, fields recordFields, opts []Option) []error {
+	var errs []error
+
+	if err := checkRecordSize(proto.Size(rec.pb), processOptions(opts...).maxRecordSize); err != nil {
+		errs = append(errs, err)
+	}
+
+	hasData := len(rec.pb.GetData()) != 0
+	if !hasData {
+		errs = append(errs, multierr.Combine(ErrInvalidRecord, ErrDataMissing))
+	}
+
+	pk, err := ExtractPublicKey(rec, name)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(rec.pb.GetSignatureV2()) == 0 {
+		errs = append(errs, fmt.Errorf("%w: no V2 signature", ErrSignature))
+	} else if pk != nil && hasData {
+		if err := verifySignatureV2(rec, pk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if hasData && (len(rec.pb.GetSignatureV1()) != 0 || len(rec.pb.GetValue()) != 0) {
+		if err := validateCborDataMatchesPbData(rec.pb); err != nil {
+			errs = append(errs, multierr.Combine(ErrInvalidRecord, err))
+		}
+	}
+
+	if fields.valueErr != nil {
+		errs = append(errs, fields.valueErr)
+	}
+
+	if fields.validityErr != nil {
+		errs = append(errs, fields.validityErr)
+	} else if time.Now().After(fields.validity) {
+		errs = append(errs, ErrExpiredRecord)
+	}
+
+	return errs
+}
+
+type recordFields struct {
+	value        path.Path
+	valueErr     error
+	validityType ValidityType
+	validity     time.Time
+	validityErr  error
+	sequence     uint64
+	ttl          time.Duration
+}
+
+// readFields reads the fields of rec from its DAG-CBOR data or, for V1-only
+// records, from the protobuf fields.
+func readFields(rec *Record) recordFields {
+	var f recordFields
+	if rec.node != nil {
+		f.value, f.valueErr = rec.Value()
+		f.validityType, _ = rec.ValidityType()
+		f.validity, f.validityErr = rec.Validity()
+		f.sequence, _ = rec.Sequence()
+		f.ttl, _ = rec.TTL()
+		return f
+	}
+
+	if p, err := path.NewPath(string(rec.pb.GetValue())); err != nil {
+		f.valueErr = multierr.Combine(ErrInvalidPath, err)
+	} else {
+		f.value = p
+	}
+
+	f.validityType = ValidityType(rec.pb.GetValidityType())
+	if f.validityType != ValidityEOL {
+		f.validityErr = ErrUnrecognizedValidity
+	} else if v, err := util.ParseRFC3339(string(rec.pb.GetValidity())); err != nil {
+		f.validityErr = multierr.Combine(ErrInvalidValidity, err)
+	} else {
+		f.validity = v
+	}
+
+	f.sequence = rec.pb.GetSequence()
+	f.ttl = time.Duration(rec.pb.GetTtl())
+	return f
+}
+
+// verifySignatureV2 verifies the V2 signature of rec against pk.
+func verifySignatureV2(rec *Record, pk ic.PubKey) error {
+	sig2Data, err := recordDataForSignatureV2(rec.pb.GetData())
+	if err != nil {
+		return fmt.Errorf("could not compute signature data: %w", err)
+	}
+
+	if ok, err := pk.Verify(sig2Data, rec.pb.GetSignatureV2()); err != nil || !ok {
+		return ErrSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package ipns
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ipns_pb "github.com/ipfs/boxo/ipns/pb"
+	"github.com/ipfs/boxo/path"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func requireProblems(t *testing.T, problems []error, expected ...error) {
+	t.Helper()
+
+	require.Len(t, problems, len(expected), "%v", problems)
+	for i, err := range expected {
+		require.ErrorIs(t, problems[i], err)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+
+	sk, _, name := mustKeyPair(t, ic.Ed25519)
+	eol := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	ttl := 5 * time.Minute
+
+	t.Run("Valid V2-only record", func(t *testing.T) {
+		t.Parallel()
+
+		rec := mustNewRecord(t, sk, testPath, 3, eol, ttl, WithV1Compatibility(false))
+		ins := Inspect(rec, name)
+		require.Equal(t, testPath.String(), ins.Value.String())
+		require.Equal(t, ValidityEOL, ins.ValidityType)
+		require.True(t, eol.Equal(ins.Validity))
+		require.Equal(t, uint64(3), ins.Sequence)
+		require.Equal(t, ttl, ins.TTL)
+		require.False(t, ins.SignatureV1)
+		require.True(t, ins.SignatureV2)
+		require.True(t, ins.SignatureV2Valid)
+		require.Nil(t, ins.PublicKey)
+		require.Equal(t, len(mustMarshal(t, rec)), ins.Size)
+		require.Empty(t, ins.Problems)
+		require.Empty(t, ValidateWithReasons(rec, name))
+	})
+
+	t.Run("V1-only record", func(t *testing.T) {
+		t.Parallel()
+
+		// a record with the V1 fields and signature alone, as published
+		// before the V2 records
+		v1 := mustNewRecord(t, sk, testPath, 3, eol, ttl)
+		pb := proto.Clone(v1.pb).(*ipns_pb.IpnsRecord)
+		pb.Data = nil
+		pb.SignatureV2 = nil
+		data, err := proto.Marshal(pb)
+		require.NoError(t, err)
+
+		_, err = UnmarshalRecord(data)
+		require.ErrorIs(t, err, ErrDataMissing)
+
+		ins, err := InspectBytes(data, name)
+		require.NoError(t, err)
+		require.Equal(t, testPath.String(), ins.Value.String())
+		require.True(t, eol.Equal(ins.Validity))
+		require.Equal(t, uint64(3), ins.Sequence)
+		require.Equal(t, ttl, ins.TTL)
+		require.True(t, ins.SignatureV1)
+		require.False(t, ins.SignatureV2)
+		require.False(t, ins.SignatureV2Valid)
+		requireProblems(t, ins.Problems, ErrDataMissing, ErrSignature)
+	})
+
+	t.Run("Record with mismatched embedded key", func(t *testing.T) {
+		t.Parallel()
+
+		otherSk, otherPk, _ := mustKeyPair(t, ic.Ed25519)
+		rec := mustNewRecord(t, otherSk, testPath, 3, eol, ttl, WithPublicKey(true))
+
+		ins := Inspect(rec, name)
+		require.True(t, otherPk.Equals(ins.PublicKey))
+		require.False(t, ins.PublicKeyMatchesName)
+		require.False(t, ins.SignatureV2Valid)
+		requireProblems(t, ins.Problems, ErrPublicKeyMismatch)
+	})
+
+	t.Run("Oversized record", func(t *testing.T) {
+		t.Parallel()
+
+		value, err := path.Join(testPath, strings.Repeat("a", MaxRecordSize))
+		require.NoError(t, err)
+		rec, err := NewRecord(sk, value, 3, eol, ttl, WithMaxRecordSize(3*MaxRecordSize))
+		require.NoError(t, err)
+
+		ins := Inspect(rec, name)
+		require.Greater(t, ins.Size, MaxRecordSize)
+		require.True(t, ins.SignatureV2Valid)
+		requireProblems(t, ins.Problems, ErrRecordSize)
+
+		// within the limit set with WithMaxRecordSize
+		require.Empty(t, Inspect(rec, name, WithMaxRecordSize(3*MaxRecordSize)).Problems)
+		require.Empty(t, ValidateWithReasons(rec, name, WithMaxRecordSize(3*MaxRecordSize)))
+
+		// and over the limit of the data
+		data, err := proto.Marshal(rec.pb)
+		require.NoError(t, err)
+		_, err = UnmarshalRecord(data)
+		require.ErrorIs(t, err, ErrRecordSize)
+		ins, err = InspectBytes(data, name)
+		require.NoError(t, err)
+		requireProblems(t, ins.Problems, ErrRecordSize)
+	})
+
+	t.Run("Undecodable data", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := InspectBytes([]byte("not a record"), name)
+		require.ErrorIs(t, err, ErrInvalidRecord)
+	})
+
+	t.Run("Expired record", func(t *testing.T) {
+		t.Parallel()
+
+		rec, err := NewRecord(sk, testPath, 3, time.Now().Add(-time.Hour), ttl)
+		require.NoError(t, err)
+
+		ins := Inspect(rec, name)
+		require.True(t, ins.SignatureV2Valid)
+		requireProblems(t, ins.Problems, ErrExpiredRecord)
+		requireProblems(t, ValidateWithReasons(rec, name), ErrExpiredRecord)
+	})
+}
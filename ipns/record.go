@@ -42,12 +42,20 @@ type Record struct {
 // [Record] struct. Please note that this function does not perform a full
 // validation of the record. For that use [Validate].
 //
+// Records larger than [MaxRecordSize], or the limit set with
+// [WithMaxRecordSize], are rejected with a [*RecordSizeError].
+//
 // [Protobuf-serialized]: https://specs.ipfs.tech/ipns/ipns-record/#record-serialization-format
-func UnmarshalRecord(data []byte) (*Record, error) {
-	if len(data) > MaxRecordSize {
-		return nil, ErrRecordSize
+func UnmarshalRecord(data []byte, opts ...Option) (*Record, error) {
+	if err := checkRecordSize(len(data), processOptions(opts...).maxRecordSize); err != nil {
+		return nil, err
 	}
+	return unmarshalRecord(data, false)
+}
 
+// unmarshalRecord parses data into a [Record], which must have DAG-CBOR data
+// unless allowV1Only is set.
+func unmarshalRecord(data []byte, allowV1Only bool) (*Record, error) {
 	var pb ipns_pb.IpnsRecord
 	err := proto.Unmarshal(data, &pb)
 	if err != nil {
@@ -60,6 +68,9 @@ func UnmarshalRecord(data []byte) (*Record, error) {
 
 	// Ensure the record has DAG-CBOR data because we need it.
 	if len(pb.GetData()) == 0 {
+		if allowV1Only {
+			return record, nil
+		}
 		return nil, multierr.Combine(ErrInvalidRecord, ErrDataMissing)
 	}
 
@@ -75,8 +86,15 @@ func UnmarshalRecord(data []byte) (*Record, error) {
 
 // MarshalRecord encodes the given IPNS Record into its [Protobuf serialization format].
 //
+// Records larger than [MaxRecordSize], or the limit set with
+// [WithMaxRecordSize], are rejected with a [*RecordSizeError], as the peers
+// would reject them.
+//
 // [Protobuf serialization format]: https://specs.ipfs.tech/ipns/ipns-record/#record-serialization-format
-func MarshalRecord(rec *Record) ([]byte, error) {
+func MarshalRecord(rec *Record, opts ...Option) ([]byte, error) {
+	if err := checkRecordSize(proto.Size(rec.pb), processOptions(opts...).maxRecordSize); err != nil {
+		return nil, err
+	}
 	return proto.Marshal(rec.pb)
 }
 
@@ -149,6 +167,12 @@ func (rec *Record) TTL() (time.Duration, error) {
 	return time.Duration(value), nil
 }
 
+// V1Compatible reports whether the record carries the deprecated V1 fields
+// and signature, which legacy nodes need to resolve it.
+func (rec *Record) V1Compatible() bool {
+	return len(rec.pb.GetSignatureV1()) != 0
+}
+
 func (rec *Record) PubKey() (ic.PubKey, error) {
 	if pk := rec.pb.GetPubKey(); len(pk) != 0 {
 		return ic.UnmarshalPublicKey(pk)
@@ -196,6 +220,7 @@ const (
 type options struct {
 	v1Compatibility bool
 	embedPublicKey  *bool
+	maxRecordSize   int
 }
 
 type Option func(*options)
@@ -212,11 +237,22 @@ func WithPublicKey(embedded bool) Option {
 	}
 }
 
+// WithMaxRecordSize sets the maximum size of a record, in bytes, for private
+// networks which need larger records than allowed by the specification. It
+// applies to [NewRecord], [UnmarshalRecord], [Validate] and [ValidateWithName],
+// and defaults to [MaxRecordSize].
+func WithMaxRecordSize(size int) Option {
+	return func(o *options) {
+		o.maxRecordSize = size
+	}
+}
+
 func processOptions(opts ...Option) *options {
 	options := &options{
 		// TODO: produce V2-only records by default after IPIP-XXXX ships with Kubo
 		// and Helia for at least 6 months.
 		v1Compatibility: true,
+		maxRecordSize:   MaxRecordSize,
 	}
 
 	for _, opt := range opts {
@@ -229,7 +265,8 @@ func processOptions(opts ...Option) *options {
 // By default, we embed the public key for key types whose peer IDs do not encode
 // the public key, such as RSA and ECDSA key types. This can be changed with the
 // option [WithPublicKey]. In addition, records are, by default created with V1
-// compatibility.
+// compatibility. Records larger than [MaxRecordSize], or the limit set with
+// [WithMaxRecordSize], are rejected with a [*RecordSizeError].
 func NewRecord(sk ic.PrivKey, value path.Path, seq uint64, eol time.Time, ttl time.Duration, opts ...Option) (*Record, error) {
 	options := processOptions(opts...)
 
@@ -296,6 +333,10 @@ func NewRecord(sk ic.PrivKey, value path.Path, seq uint64, eol time.Time, ttl ti
 		pb.PubKey = pkBytes
 	}
 
+	if err := checkRecordSize(proto.Size(&pb), options.maxRecordSize); err != nil {
+		return nil, err
+	}
+
 	return &Record{
 		pb:   &pb,
 		node: node,
@@ -450,22 +491,44 @@ func compare(a, b *Record) (int, error) {
 }
 
 // ExtractPublicKey extracts a [crypto.PubKey] matching the given [Name] from
-// the IPNS Record, if possible.
+// the IPNS Record, if possible. It never accesses the network:
+//
+//   - The names of small keys, such as Ed25519 ones, inline the key with an
+//     identity multihash: the key is derived from the name, and a key embedded
+//     in the record must be the same.
+//   - The names of larger keys, such as RSA ones, are a hash of the key: it
+//     must be embedded in the record. Otherwise, an error matching both
+//     [ErrPublicKeyNotFound] and [peer.ErrNoPublicKey] is returned.
 func ExtractPublicKey(rec *Record, name Name) (ic.PubKey, error) {
-	if pk, err := rec.PubKey(); err == nil {
-		expPid, err := peer.IDFromPublicKey(pk)
-		if err != nil {
-			return nil, multierr.Combine(ErrInvalidPublicKey, err)
+	namePk, err := name.Peer().ExtractPublicKey()
+	if err != nil && !errors.Is(err, peer.ErrNoPublicKey) {
+		return nil, multierr.Combine(ErrInvalidName, err)
+	}
+
+	pk, err := rec.PubKey()
+	switch {
+	case errors.Is(err, ErrPublicKeyNotFound):
+		if namePk == nil {
+			return nil, multierr.Combine(ErrPublicKeyNotFound, peer.ErrNoPublicKey)
 		}
+		return namePk, nil
+	case err != nil:
+		return nil, multierr.Combine(ErrInvalidPublicKey, err)
+	}
 
-		if !name.Equal(NameFromPeer(expPid)) {
+	if namePk != nil {
+		if !namePk.Equals(pk) {
 			return nil, ErrPublicKeyMismatch
 		}
+		return namePk, nil
+	}
 
-		return pk, nil
-	} else if !errors.Is(err, ErrPublicKeyNotFound) {
+	expPid, err := peer.IDFromPublicKey(pk)
+	if err != nil {
 		return nil, multierr.Combine(ErrInvalidPublicKey, err)
-	} else {
-		return name.Peer().ExtractPublicKey()
 	}
+	if !name.Equal(NameFromPeer(expPid)) {
+		return nil, ErrPublicKeyMismatch
+	}
+	return pk, nil
 }
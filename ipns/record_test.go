@@ -2,6 +2,7 @@ package ipns
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 	"time"
 
@@ -290,3 +291,98 @@ func TestUnmarshal(t *testing.T) {
 		require.ErrorIs(t, err, ErrInvalidRecord)
 	})
 }
+
+// mustValueOfSize returns a value for which the V2-only record is size bytes.
+func mustValueOfSize(t *testing.T, sk ic.PrivKey, eol time.Time, size int) path.Path {
+	t.Helper()
+
+	var padding int
+	for i := 0; i < 10; i++ {
+		value, err := path.Join(testPath, strings.Repeat("a", padding))
+		require.NoError(t, err)
+		rec, err := NewRecord(sk, value, 1, eol, 0, WithV1Compatibility(false), WithMaxRecordSize(2*size))
+		require.NoError(t, err)
+		recSize := proto.Size(rec.pb)
+		if recSize == size {
+			return value
+		}
+		padding += size - recSize
+	}
+	t.Fatalf("could not build a record of %d bytes", size)
+	return nil
+}
+
+func TestRecordSize(t *testing.T) {
+	t.Parallel()
+
+	sk, pk, name := mustKeyPair(t, ic.Ed25519)
+	eol := time.Now().Add(time.Hour)
+
+	requireSizeError := func(t *testing.T, err error, size, limit int) {
+		t.Helper()
+
+		require.ErrorIs(t, err, ErrRecordSize)
+		var sizeErr *RecordSizeError
+		require.ErrorAs(t, err, &sizeErr)
+		require.Equal(t, RecordSizeError{Size: size, Limit: limit}, *sizeErr)
+	}
+
+	t.Run("Record at the limit", func(t *testing.T) {
+		t.Parallel()
+
+		value := mustValueOfSize(t, sk, eol, MaxRecordSize)
+		rec, err := NewRecord(sk, value, 1, eol, 0, WithV1Compatibility(false))
+		require.NoError(t, err)
+		require.NoError(t, ValidateWithName(rec, name))
+
+		data := mustMarshal(t, rec)
+		require.Len(t, data, MaxRecordSize)
+		_, err = UnmarshalRecord(data)
+		require.NoError(t, err)
+	})
+
+	t.Run("Record over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		value := mustValueOfSize(t, sk, eol, MaxRecordSize+1)
+		_, err := NewRecord(sk, value, 1, eol, 0, WithV1Compatibility(false))
+		requireSizeError(t, err, MaxRecordSize+1, MaxRecordSize)
+
+		rec, err := NewRecord(sk, value, 1, eol, 0, WithV1Compatibility(false), WithMaxRecordSize(MaxRecordSize+1))
+		require.NoError(t, err)
+		requireSizeError(t, Validate(rec, pk), MaxRecordSize+1, MaxRecordSize)
+		requireSizeError(t, ValidateWithName(rec, name), MaxRecordSize+1, MaxRecordSize)
+		_, err = MarshalRecord(rec)
+		requireSizeError(t, err, MaxRecordSize+1, MaxRecordSize)
+
+		data, err := MarshalRecord(rec, WithMaxRecordSize(MaxRecordSize+1))
+		require.NoError(t, err)
+		_, err = UnmarshalRecord(data)
+		requireSizeError(t, err, MaxRecordSize+1, MaxRecordSize)
+		requireSizeError(t, Validator{}.Validate(string(name.RoutingKey()), data), MaxRecordSize+1, MaxRecordSize)
+	})
+
+	t.Run("Configurable limit", func(t *testing.T) {
+		t.Parallel()
+
+		const limit = 2 * MaxRecordSize
+		value := mustValueOfSize(t, sk, eol, limit)
+		rec, err := NewRecord(sk, value, 1, eol, 0, WithV1Compatibility(false), WithMaxRecordSize(limit))
+		require.NoError(t, err)
+		require.NoError(t, ValidateWithName(rec, name, WithMaxRecordSize(limit)))
+
+		data, err := MarshalRecord(rec, WithMaxRecordSize(limit))
+		require.NoError(t, err)
+		_, err = MarshalRecord(rec, WithMaxRecordSize(limit-1))
+		requireSizeError(t, err, limit, limit-1)
+		_, err = UnmarshalRecord(data, WithMaxRecordSize(limit))
+		require.NoError(t, err)
+		require.NoError(t, Validator{MaxRecordSize: limit}.Validate(string(name.RoutingKey()), data))
+
+		_, err = NewRecord(sk, value, 1, eol, 0, WithV1Compatibility(false), WithMaxRecordSize(limit-1))
+		requireSizeError(t, err, limit, limit-1)
+		_, err = UnmarshalRecord(data, WithMaxRecordSize(limit-1))
+		requireSizeError(t, err, limit, limit-1)
+		requireSizeError(t, Validator{MaxRecordSize: limit - 1}.Validate(string(name.RoutingKey()), data), limit, limit-1)
+	})
+}
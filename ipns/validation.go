@@ -17,23 +17,26 @@ import (
 )
 
 // ValidateWithName validates the given IPNS [Record] against the given [Name].
-func ValidateWithName(rec *Record, name Name) error {
+// It never accesses the network: the public key is taken from the name or the
+// record, see [ExtractPublicKey].
+func ValidateWithName(rec *Record, name Name, opts ...Option) error {
 	pk, err := ExtractPublicKey(rec, name)
 	if err != nil {
 		return err
 	}
 
-	return Validate(rec, pk)
+	return Validate(rec, pk, opts...)
 }
 
 // Validates validates the given IPNS Record against the given [crypto.PubKey],
-// following the [Record Verification] specification.
+// following the [Record Verification] specification. The size limit can be
+// changed with [WithMaxRecordSize].
 //
 // [Record Verification]: https://specs.ipfs.tech/ipns/ipns-record/#record-verification
-func Validate(rec *Record, pk ic.PubKey) error {
+func Validate(rec *Record, pk ic.PubKey, opts ...Option) error {
 	// (1) Ensure size is not over maximum record size.
-	if proto.Size(rec.pb) > MaxRecordSize {
-		return ErrRecordSize
+	if err := checkRecordSize(proto.Size(rec.pb), processOptions(opts...).maxRecordSize); err != nil {
+		return err
 	}
 
 	// (2) Ensure SignatureV2 and Data are present and not empty.
@@ -47,14 +50,9 @@ func Validate(rec *Record, pk ic.PubKey) error {
 	// (3) Extract Public Key - not necessary. Done via [ValidateWithName].
 
 	// (4) Get deserialized Data as DAG-CBOR document.
-	sig2Data, err := recordDataForSignatureV2(rec.pb.GetData())
-	if err != nil {
-		return fmt.Errorf("could not compute signature data: %w", err)
-	}
-
 	// (6) Verify signature against concatenation.
-	if ok, err := pk.Verify(sig2Data, rec.pb.GetSignatureV2()); err != nil || !ok {
-		return ErrSignature
+	if err := verifySignatureV2(rec, pk); err != nil {
+		return err
 	}
 
 	// (5) Ensure that CBOR data matches Protobuf, only if non-CBOR Value or SignatureV1 are present.
@@ -160,6 +158,10 @@ var _ record.Validator = Validator{}
 type Validator struct {
 	// KeyBook, if non-nil, is used to lookup keys for validating IPNS Records.
 	KeyBook peerstore.KeyBook
+
+	// MaxRecordSize, if non-zero, replaces [MaxRecordSize] as the size limit
+	// of the records, see [WithMaxRecordSize].
+	MaxRecordSize int
 }
 
 // Validate validates an IPNS record.
@@ -170,7 +172,12 @@ func (v Validator) Validate(key string, value []byte) error {
 		return ErrInvalidName
 	}
 
-	r, err := UnmarshalRecord(value)
+	var opts []Option
+	if v.MaxRecordSize != 0 {
+		opts = append(opts, WithMaxRecordSize(v.MaxRecordSize))
+	}
+
+	r, err := UnmarshalRecord(value, opts...)
 	if err != nil {
 		return err
 	}
@@ -180,15 +187,16 @@ func (v Validator) Validate(key string, value []byte) error {
 		return err
 	}
 
-	return Validate(r, pk)
+	return Validate(r, pk, opts...)
 }
 
+// getPublicKey returns the public key of name. The KeyBook is only used for
+// names which are a hash of their key, when the key is not embedded in r.
 func (v Validator) getPublicKey(r *Record, name Name) (ic.PubKey, error) {
-	switch pk, err := ExtractPublicKey(r, name); err {
-	case peer.ErrNoPublicKey:
-	case nil:
+	pk, err := ExtractPublicKey(r, name)
+	if err == nil {
 		return pk, nil
-	default:
+	} else if !errors.Is(err, peer.ErrNoPublicKey) {
 		return nil, err
 	}
 
@@ -197,7 +205,7 @@ func (v Validator) getPublicKey(r *Record, name Name) (ic.PubKey, error) {
 		return nil, ErrPublicKeyNotFound
 	}
 
-	pk := v.KeyBook.PubKey(name.Peer())
+	pk = v.KeyBook.PubKey(name.Peer())
 	if pk == nil {
 		log.Debugf("public key with hash %q not found in peer store", name.Peer())
 		return nil, ErrPublicKeyNotFound
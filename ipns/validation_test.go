@@ -7,6 +7,7 @@ import (
 
 	"github.com/ipfs/boxo/path"
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	"github.com/stretchr/testify/assert"
@@ -71,7 +72,7 @@ func TestValidator(t *testing.T) {
 	t.Parallel()
 
 	check := func(t *testing.T, sk ic.PrivKey, keybook peerstore.KeyBook, key, val []byte, eol time.Time, exp error, opts ...Option) {
-		validator := Validator{keybook}
+		validator := Validator{KeyBook: keybook}
 		data := val
 		if data == nil {
 			// do not call mustNewRecord because that validates the record!
@@ -219,8 +220,12 @@ func TestValidate(t *testing.T) {
 		path, err := path.Join(testPath, string(make([]byte, MaxRecordSize)))
 		require.NoError(t, err)
 
-		rec, err := NewRecord(sk, path, 1, eol, 0)
+		_, err = NewRecord(sk, path, 1, eol, 0)
+		require.ErrorIs(t, err, ErrRecordSize)
+
+		rec, err := NewRecord(sk, path, 1, eol, 0, WithMaxRecordSize(2*MaxRecordSize+1024))
 		require.NoError(t, err)
+		require.NoError(t, Validate(rec, pk, WithMaxRecordSize(2*MaxRecordSize+1024)))
 
 		err = Validate(rec, pk)
 		require.ErrorIs(t, err, ErrRecordSize)
@@ -248,4 +253,33 @@ func TestValidateWithName(t *testing.T) {
 		err := ValidateWithName(r, name2)
 		assert.ErrorIs(t, err, ErrSignature)
 	})
+
+	t.Run("key derived from Ed25519 name", func(t *testing.T) {
+		t.Parallel()
+
+		r := mustNewRecord(t, sk, testPath, 1, eol, 0, WithPublicKey(false))
+		require.Empty(t, r.pb.PubKey)
+		assert.NoError(t, ValidateWithName(r, name))
+
+		// An embedded key must be the key of the name.
+		r = mustNewRecord(t, sk, testPath, 1, eol, 0, WithPublicKey(true))
+		assert.NoError(t, ValidateWithName(r, name))
+		sk2, _, _ := mustKeyPair(t, ic.Ed25519)
+		r.pb.PubKey, _ = ic.MarshalPublicKey(sk2.GetPublic())
+		assert.ErrorIs(t, ValidateWithName(r, name), ErrPublicKeyMismatch)
+	})
+
+	t.Run("key embedded for RSA name", func(t *testing.T) {
+		t.Parallel()
+
+		sk, _, name := mustKeyPair(t, ic.RSA)
+		r := mustNewRecord(t, sk, testPath, 1, eol, 0)
+		require.NotEmpty(t, r.pb.PubKey)
+		assert.NoError(t, ValidateWithName(r, name))
+
+		r = mustNewRecord(t, sk, testPath, 1, eol, 0, WithPublicKey(false))
+		err := ValidateWithName(r, name)
+		assert.ErrorIs(t, err, ErrPublicKeyNotFound)
+		assert.ErrorIs(t, err, peer.ErrNoPublicKey)
+	})
 }
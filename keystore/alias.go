@@ -0,0 +1,202 @@
+package keystore
+
+import (
+	"errors"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	errSelfAlias    = errors.New("a key cannot be an alias of itself")
+	errAliasOfAlias = errors.New("the target of an alias must be a key, not an alias")
+)
+
+// lockStripes is the number of locks of the names of an FSKeystore.
+const lockStripes = 64
+
+// aliasFilenamePrefix is the prefix of the files of the aliases, which hold
+// the name of their target.
+const aliasFilenamePrefix = "alias_"
+
+// aliasFilename returns the name of the file of the alias of the encoded name.
+func aliasFilename(name string) string {
+	return aliasFilenamePrefix + strings.TrimPrefix(name, keyFilenamePrefix)
+}
+
+func (ks *FSKeystore) stripe(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % lockStripes)
+}
+
+// rlock read-locks the encoded name.
+func (ks *FSKeystore) rlock(name string) (unlock func()) {
+	l := &ks.locks[ks.stripe(name)]
+	l.RLock()
+	return l.RUnlock
+}
+
+// lock locks the encoded names, in the order of their locks.
+func (ks *FSKeystore) lock(names ...string) (unlock func()) {
+	stripes := make([]int, 0, len(names))
+	for _, name := range names {
+		stripes = append(stripes, ks.stripe(name))
+	}
+	sort.Ints(stripes)
+	locked := stripes[:0]
+	for i, s := range stripes {
+		if i > 0 && s == stripes[i-1] {
+			continue
+		}
+		ks.locks[s].Lock()
+		locked = append(locked, s)
+	}
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			ks.locks[locked[i]].Unlock()
+		}
+	}
+}
+
+// resolve returns the encoded name of the target of the alias of the encoded
+// name, or name if it is not an alias.
+func (ks *FSKeystore) resolve(name string) (string, error) {
+	unlock := ks.rlock(name)
+	defer unlock()
+
+	target, err := os.ReadFile(filepath.Join(ks.dir, aliasFilename(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return name, nil
+		}
+		return "", err
+	}
+	return encode(string(target))
+}
+
+// exists returns whether the file name exists.
+func (ks *FSKeystore) exists(name string) (bool, error) {
+	_, err := os.Lstat(filepath.Join(ks.dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Rename renames the key or the alias oldName to newName, atomically. If
+// newName exists, it returns ErrKeyExists unless overwrite is set, in which case
+// the key or alias of newName is replaced. The aliases of oldName are not
+// updated, and no longer resolve.
+func (ks *FSKeystore) Rename(oldName, newName string, overwrite bool) error {
+	oldName, err := encode(oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = encode(newName)
+	if err != nil {
+		return err
+	}
+
+	unlock := ks.lock(oldName, newName)
+	defer unlock()
+
+	// a key is renamed to a key, and an alias to an alias
+	src, dst, other := oldName, newName, aliasFilename(newName)
+	if ok, err := ks.exists(src); err != nil {
+		return err
+	} else if !ok {
+		src, dst, other = aliasFilename(oldName), aliasFilename(newName), newName
+		if ok, err := ks.exists(src); err != nil {
+			return err
+		} else if !ok {
+			return ErrNoSuchKey
+		}
+	}
+	if oldName == newName {
+		return nil
+	}
+	if ok, err := ks.exists(other); err != nil {
+		return err
+	} else if ok && !overwrite {
+		return ErrKeyExists
+	}
+
+	srcPath, dstPath := filepath.Join(ks.dir, src), filepath.Join(ks.dir, dst)
+	if !overwrite {
+		// reserve the new name, so that no key created meanwhile is replaced
+		fi, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o400)
+		if err != nil {
+			if os.IsExist(err) {
+				err = ErrKeyExists
+			}
+			return err
+		}
+		fi.Close()
+		if err := os.Rename(srcPath, dstPath); err != nil {
+			os.Remove(dstPath)
+			return err
+		}
+		return nil
+	}
+
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(ks.dir, other)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Alias makes name an alias of the key target, without copying it: Has and
+// Get of name resolve to target, and Delete of name removes the alias only.
+// It returns ErrKeyExists if name exists and ErrNoSuchKey if target does not,
+// the aliases of aliases are not supported.
+func (ks *FSKeystore) Alias(name, target string) error {
+	encName, err := encode(name)
+	if err != nil {
+		return err
+	}
+	encTarget, err := encode(target)
+	if err != nil {
+		return err
+	}
+	if encName == encTarget {
+		return errSelfAlias
+	}
+
+	unlock := ks.lock(encName, encTarget)
+	defer unlock()
+
+	if ok, err := ks.exists(aliasFilename(encTarget)); err != nil {
+		return err
+	} else if ok {
+		return errAliasOfAlias
+	}
+	if ok, err := ks.exists(encTarget); err != nil {
+		return err
+	} else if !ok {
+		return ErrNoSuchKey
+	}
+	if ok, err := ks.exists(encName); err != nil {
+		return err
+	} else if ok {
+		return ErrKeyExists
+	}
+
+	return ks.create(aliasFilename(encName), []byte(target))
+}
+
+// Rename renames the key or the alias oldName to newName, see
+// [FSKeystore.Rename].
+func (ks *EncryptedFSKeystore) Rename(oldName, newName string, overwrite bool) error {
+	return ks.fs.Rename(oldName, newName, overwrite)
+}
+
+// Alias makes name an alias of the key target, see [FSKeystore.Alias].
+func (ks *EncryptedFSKeystore) Alias(name, target string) error {
+	return ks.fs.Alias(name, target)
+}
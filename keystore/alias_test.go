@@ -0,0 +1,276 @@
+package keystore
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	ci "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestRename(t *testing.T) {
+	tdir := t.TempDir()
+	ks, err := NewFSKeystore(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1 := privKeyOrFatal(t)
+	k2 := privKeyOrFatal(t)
+	if err := ks.Put("foo", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("bar", k2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Rename("missing", "baz", false); err != ErrNoSuchKey {
+		t.Fatalf("expected ErrNoSuchKey, got %v", err)
+	}
+	if err := ks.Rename("foo", "bar", false); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if err := assertGetKey(ks, "foo", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(ks, "bar", k2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Rename("foo", "baz", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(ks, "baz", k1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("foo"); err != ErrNoSuchKey {
+		t.Fatalf("expected ErrNoSuchKey, got %v", err)
+	}
+
+	// the rotation of a key under a stable name
+	if err := ks.Rename("baz", "bar", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(ks, "bar", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertDirContents(tdir, []string{"bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Rename("bar", "bar", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(ks, "bar", k1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	ks, err := NewFSKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1 := privKeyOrFatal(t)
+	k2 := privKeyOrFatal(t)
+	if err := ks.Put("foo", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("bar", k2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Alias("self", "missing"); err != ErrNoSuchKey {
+		t.Fatalf("expected ErrNoSuchKey, got %v", err)
+	}
+	if err := ks.Alias("bar", "foo"); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if err := ks.Alias("self", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Alias("self", "bar"); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if err := ks.Alias("other", "self"); err != errAliasOfAlias {
+		t.Fatalf("expected errAliasOfAlias, got %v", err)
+	}
+	if err := ks.Put("self", k2); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	has, err := ks.Has("self")
+	if err != nil || !has {
+		t.Fatal("expected the alias to exist")
+	}
+	if err := assertGetKey(ks, "self", k1); err != nil {
+		t.Fatal(err)
+	}
+	l, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(l)
+	if strings.Join(l, ",") != "bar,foo,self" {
+		t.Fatalf("wrong entries listed: %v", l)
+	}
+
+	// an alias is renamed as an alias, and a key over an alias
+	if err := ks.Rename("self", "me", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(ks, "me", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Rename("bar", "me", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(ks, "me", k2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Alias("self", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleting the alias keeps the key
+	if err := ks.Delete("self"); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := ks.Has("self"); has {
+		t.Fatal("expected the alias to be deleted")
+	}
+	if err := assertGetKey(ks, "foo", k1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the aliases of a deleted key do not resolve
+	if err := ks.Alias("self", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := ks.Has("self"); has {
+		t.Fatal("expected the alias not to resolve")
+	}
+	if _, err := ks.Get("self"); err != ErrNoSuchKey {
+		t.Fatalf("expected ErrNoSuchKey, got %v", err)
+	}
+
+	// through the encrypted keystore
+	eks, err := NewEncryptedFSKeystore(t.TempDir(), passphrase, fastKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eks.Put("foo", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := eks.Alias("self", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(eks, "self", k1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListSkipsTempFiles(t *testing.T) {
+	tdir := t.TempDir()
+	ks, err := NewFSKeystore(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("foo", privKeyOrFatal(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tdir, tempFilenamePrefix+"123"), []byte("half"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 || l[0] != "foo" {
+		t.Fatalf("wrong entries listed: %v", l)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	ks, err := NewFSKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []ci.PrivKey{privKeyOrFatal(t), privKeyOrFatal(t), privKeyOrFatal(t)}
+	names := []string{"a", "b", "c", "d", "e", "f"}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for i := 0; i < 200; i++ {
+				name := names[rnd.Intn(len(names))]
+				other := names[rnd.Intn(len(names))]
+				var err error
+				switch rnd.Intn(7) {
+				case 0:
+					err = ks.Put(name, keys[rnd.Intn(len(keys))])
+					if err == ErrKeyExists {
+						err = nil
+					}
+				case 1:
+					err = ks.Rename(name, other, rnd.Intn(2) == 0)
+					if err == ErrNoSuchKey || err == ErrKeyExists {
+						err = nil
+					}
+				case 2:
+					err = ks.Delete(name)
+					if os.IsNotExist(err) {
+						err = nil
+					}
+				case 3:
+					var k ci.PrivKey
+					k, err = ks.Get(name)
+					if err == ErrNoSuchKey {
+						err = nil
+					} else if err == nil && !k.Equals(keys[0]) && !k.Equals(keys[1]) && !k.Equals(keys[2]) {
+						err = fmt.Errorf("unexpected key read as %q", name)
+					}
+				case 4:
+					_, err = ks.Has(name)
+				case 5:
+					err = ks.Alias(name, other)
+					if err == ErrNoSuchKey || err == ErrKeyExists || err == errSelfAlias || err == errAliasOfAlias {
+						err = nil
+					}
+				case 6:
+					var l []string
+					l, err = ks.List()
+					for _, n := range l {
+						if !strings.Contains("abcdef", n) || len(n) != 1 {
+							err = fmt.Errorf("unexpected name listed: %q", n)
+						}
+					}
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,528 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	ci "github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrEncryptedKey is returned by [FSKeystore] when reading a key encrypted by
+// an [EncryptedFSKeystore].
+var ErrEncryptedKey = errors.New("key is encrypted, it must be read with an EncryptedFSKeystore")
+
+// ErrPlaintextKey is returned by [EncryptedFSKeystore] when its directory
+// holds keys which are not encrypted, which must be encrypted with [Migrate]
+// first.
+var ErrPlaintextKey = errors.New("key is not encrypted, the keystore must be migrated")
+
+// ErrDecryption is returned when a key of an [EncryptedFSKeystore] cannot be
+// decrypted, because the passphrase is wrong or the file is corrupted.
+type ErrDecryption struct {
+	Name string
+	Err  error
+}
+
+// Error implements the [errors.Error] interface.
+func (e *ErrDecryption) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("cannot decrypt key: %s", e.Err)
+	}
+	return fmt.Sprintf("cannot decrypt key %q: %s", e.Name, e.Err)
+}
+
+// Unwrap returns the reason of e.
+func (e *ErrDecryption) Unwrap() error {
+	return e.Err
+}
+
+// Is implements [errors.Is] interface.
+func (e *ErrDecryption) Is(err error) bool {
+	switch err.(type) {
+	case *ErrDecryption:
+		return true
+	default:
+		return false
+	}
+}
+
+// KDF is the function deriving the encryption keys from the passphrase.
+type KDF uint8
+
+const (
+	// KDFArgon2id is Argon2id (RFC 9106).
+	KDFArgon2id KDF = iota + 1
+	// KDFScrypt is scrypt (RFC 7914).
+	KDFScrypt
+)
+
+// Cipher is the authenticated encryption of the keys.
+type Cipher uint8
+
+const (
+	// CipherAES256GCM is AES-256 in GCM mode.
+	CipherAES256GCM Cipher = iota + 1
+	// CipherXChaCha20Poly1305 is XChaCha20-Poly1305.
+	CipherXChaCha20Poly1305
+)
+
+const (
+	// DefaultArgon2idTime, DefaultArgon2idMemory (in KiB) and
+	// DefaultArgon2idThreads are the default parameters of KDFArgon2id, the
+	// second recommended option of RFC 9106.
+	DefaultArgon2idTime    = 3
+	DefaultArgon2idMemory  = 64 * 1024
+	DefaultArgon2idThreads = 4
+
+	// DefaultScryptN, DefaultScryptR and DefaultScryptP are the default
+	// parameters of KDFScrypt.
+	DefaultScryptN = 1 << 15
+	DefaultScryptR = 8
+	DefaultScryptP = 1
+
+	// MaxArgon2idTime and MaxArgon2idMemory (in KiB) are the largest
+	// parameters of KDFArgon2id accepted, 16 times the defaults, so that
+	// the header of a corrupted or forged file cannot make the derivation
+	// of its key run for hours or allocate more than 1 GiB.
+	MaxArgon2idTime   = 16 * DefaultArgon2idTime
+	MaxArgon2idMemory = 16 * DefaultArgon2idMemory
+
+	// MaxScryptN and MaxScryptP are the largest parameters of KDFScrypt
+	// accepted, and MaxScryptMemory the largest memory used, 128*n*r
+	// bytes, for the same reason.
+	MaxScryptN      = 1 << 20
+	MaxScryptP      = 16
+	MaxScryptMemory = 1 << 30
+)
+
+type encryptedOptions struct {
+	kdf    KDF
+	params [3]uint32
+	cipher Cipher
+}
+
+// EncryptedOption is an option of [NewEncryptedFSKeystore], [Migrate] and
+// [WithPEMPassphrase].
+type EncryptedOption func(*encryptedOptions)
+
+// WithArgon2id derives the encryption keys with KDFArgon2id, with the given
+// number of passes, memory in KiB and threads. It is the default, with
+// DefaultArgon2idTime, DefaultArgon2idMemory and DefaultArgon2idThreads.
+func WithArgon2id(time, memory uint32, threads uint8) EncryptedOption {
+	return func(o *encryptedOptions) {
+		o.kdf = KDFArgon2id
+		o.params = [3]uint32{time, memory, uint32(threads)}
+	}
+}
+
+// WithScrypt derives the encryption keys with KDFScrypt, with the cost n, a
+// power of two, the block size r and the parallelization p.
+func WithScrypt(n, r, p int) EncryptedOption {
+	return func(o *encryptedOptions) {
+		o.kdf = KDFScrypt
+		o.params = [3]uint32{uint32(n), uint32(r), uint32(p)}
+	}
+}
+
+// WithCipher sets the encryption of the keys. It defaults to CipherAES256GCM.
+func WithCipher(c Cipher) EncryptedOption {
+	return func(o *encryptedOptions) {
+		o.cipher = c
+	}
+}
+
+// EncryptedFSKeystore is a keystore backed by files in a given directory
+// stored on disk, each key encrypted with a key derived from a passphrase.
+//
+// Each file records the parameters of its encryption, so that the keys
+// written with other options can still be read. The file starts with a
+// versioned header:
+//
+//	"BXKEY" | version (1) | KDF (1) | 3 KDF parameters (4 each, big endian) |
+//	cipher (1) | salt (16) | nonce (12 or 24) | encrypted key
+//
+// The header is authenticated with the key. The derivation of the key from
+// the passphrase and the salt of each file is deliberately slow, and done for
+// each Put and Get.
+type EncryptedFSKeystore struct {
+	fs         *FSKeystore
+	passphrase []byte
+	opts       encryptedOptions
+}
+
+var _ Keystore = (*EncryptedFSKeystore)(nil)
+
+// NewEncryptedFSKeystore returns a new filesystem-backed keystore whose keys
+// are encrypted with passphrase. It fails with ErrPlaintextKey if dir already
+// holds keys which are not encrypted, see [Migrate].
+func NewEncryptedFSKeystore(dir string, passphrase []byte, opts ...EncryptedOption) (*EncryptedFSKeystore, error) {
+	ks, err := newEncryptedFSKeystore(dir, passphrase, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := ks.fs.filenames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := ks.fs.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if !isEncrypted(data) {
+			return nil, ErrPlaintextKey
+		}
+	}
+	return ks, nil
+}
+
+func newEncryptedFSKeystore(dir string, passphrase []byte, opts []EncryptedOption) (*EncryptedFSKeystore, error) {
+	o, err := newEncryptedOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must be at least one byte")
+	}
+
+	fs, err := NewFSKeystore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedFSKeystore{
+		fs:         fs,
+		passphrase: bytes.Clone(passphrase),
+		opts:       o,
+	}, nil
+}
+
+// Has returns whether or not a key exists in the Keystore
+func (ks *EncryptedFSKeystore) Has(name string) (bool, error) {
+	return ks.fs.Has(name)
+}
+
+// Put stores a key in the Keystore, if a key with the same name already exists, returns ErrKeyExists
+func (ks *EncryptedFSKeystore) Put(name string, k ci.PrivKey) error {
+	name, err := encode(name)
+	if err != nil {
+		return err
+	}
+
+	b, err := ci.MarshalPrivateKey(k)
+	if err != nil {
+		return err
+	}
+	data, err := seal(ks.opts, ks.passphrase, b, nil)
+	if err != nil {
+		return err
+	}
+
+	return ks.fs.put(name, data)
+}
+
+// Get retrieves a key from the Keystore if it exists, and returns ErrNoSuchKey
+// otherwise. It returns an *ErrDecryption if the key cannot be decrypted.
+func (ks *EncryptedFSKeystore) Get(name string) (ci.PrivKey, error) {
+	encoded, err := encode(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ks.fs.read(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if !isEncrypted(data) {
+		return nil, ErrPlaintextKey
+	}
+	b, err := open(ks.passphrase, data, nil)
+	if err != nil {
+		return nil, &ErrDecryption{Name: name, Err: err}
+	}
+
+	return ci.UnmarshalPrivateKey(b)
+}
+
+// Delete removes a key from the Keystore
+func (ks *EncryptedFSKeystore) Delete(name string) error {
+	return ks.fs.Delete(name)
+}
+
+// List return a list of key identifier
+func (ks *EncryptedFSKeystore) List() ([]string, error) {
+	return ks.fs.List()
+}
+
+// Migrate encrypts in place the keys of the plaintext keystore in dir with
+// passphrase, and returns the encrypted keystore. Each file is replaced
+// atomically, and the keys already encrypted are left as they are, so that
+// an interrupted migration can be run again.
+func Migrate(dir string, passphrase []byte, opts ...EncryptedOption) (*EncryptedFSKeystore, error) {
+	ks, err := newEncryptedFSKeystore(dir, passphrase, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := ks.fs.filenames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := ks.fs.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if isEncrypted(data) {
+			continue
+		}
+		// check that it is a key before replacing it
+		if _, err := ci.UnmarshalPrivateKey(data); err != nil {
+			return nil, fmt.Errorf("migrating %s: %w", name, err)
+		}
+		encrypted, err := seal(ks.opts, ks.passphrase, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.fs.migrate(name, data, encrypted); err != nil {
+			return nil, fmt.Errorf("migrating %s: %w", name, err)
+		}
+	}
+	return ks, nil
+}
+
+// filenames returns the names of the files of the keys in ks.
+func (ks *FSKeystore) filenames() ([]string, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			if _, err := decode(e.Name()); err == nil {
+				names = append(names, e.Name())
+			}
+		}
+	}
+	return names, nil
+}
+
+// migrate replaces the plaintext key data of the file of the encoded name
+// with encrypted, unless it was changed in the meantime.
+func (ks *FSKeystore) migrate(name string, data, encrypted []byte) error {
+	unlock := ks.lock(name)
+	defer unlock()
+
+	current, err := os.ReadFile(filepath.Join(ks.dir, name))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, data) {
+		return errors.New("key changed during the migration")
+	}
+	return ks.replace(name, encrypted)
+}
+
+// replace atomically replaces the content of the file name with data.
+func (ks *FSKeystore) replace(name string, data []byte) error {
+	tmp, err := os.CreateTemp(ks.dir, tempFilenamePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0o400)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(ks.dir, name))
+}
+
+const (
+	encryptedMagic   = "BXKEY"
+	encryptedVersion = 1
+	saltSize         = 16
+	// headerSize is the size of the header of version 1 before the nonce.
+	headerSize = len(encryptedMagic) + 1 + 1 + 3*4 + 1 + saltSize
+)
+
+// isEncrypted returns whether data is the file of an encrypted key. The
+// plaintext keys are protobuf messages, which never start with the magic.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// newEncryptedOptions returns the validated options of opts.
+func newEncryptedOptions(opts []EncryptedOption) (encryptedOptions, error) {
+	o := encryptedOptions{
+		kdf:    KDFArgon2id,
+		params: [3]uint32{DefaultArgon2idTime, DefaultArgon2idMemory, DefaultArgon2idThreads},
+		cipher: CipherAES256GCM,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o, o.validate()
+}
+
+func (o encryptedOptions) validate() error {
+	switch o.kdf {
+	case KDFArgon2id:
+		if o.params[0] == 0 || o.params[2] == 0 || o.params[2] > 255 {
+			return errors.New("argon2id time and threads must be positive")
+		}
+		if o.params[1] < 8*o.params[2] {
+			return errors.New("argon2id memory must be at least 8 KiB per thread")
+		}
+		if o.params[0] > MaxArgon2idTime || o.params[1] > MaxArgon2idMemory {
+			return fmt.Errorf("argon2id time and memory must be at most %d and %d KiB", MaxArgon2idTime, MaxArgon2idMemory)
+		}
+	case KDFScrypt:
+		n, r, p := o.params[0], o.params[1], o.params[2]
+		if n <= 1 || n&(n-1) != 0 {
+			return errors.New("scrypt n must be a power of two greater than 1")
+		}
+		if r == 0 || p == 0 || uint64(r)*uint64(p) >= 1<<30 {
+			return errors.New("scrypt r and p must be positive, with r*p < 2^30")
+		}
+		if n > MaxScryptN || p > MaxScryptP || 128*uint64(n)*uint64(r) > MaxScryptMemory {
+			return fmt.Errorf("scrypt n and p must be at most %d and %d, with 128*n*r at most %d bytes", MaxScryptN, MaxScryptP, MaxScryptMemory)
+		}
+	default:
+		return fmt.Errorf("unsupported key derivation function: %d", o.kdf)
+	}
+	switch o.cipher {
+	case CipherAES256GCM, CipherXChaCha20Poly1305:
+		return nil
+	default:
+		return fmt.Errorf("unsupported cipher: %d", o.cipher)
+	}
+}
+
+// deriveKey derives the key of the cipher from passphrase and salt.
+func (o encryptedOptions) deriveKey(passphrase, salt []byte) ([]byte, error) {
+	switch o.kdf {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, salt, o.params[0], o.params[1], uint8(o.params[2]), 32), nil
+	case KDFScrypt:
+		return scrypt.Key(passphrase, salt, int(o.params[0]), int(o.params[1]), int(o.params[2]), 32)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function: %d", o.kdf)
+	}
+}
+
+func (o encryptedOptions) aead(key []byte) (cipher.AEAD, error) {
+	switch o.cipher {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher: %d", o.cipher)
+	}
+}
+
+// seal returns the file of the key b encrypted with passphrase. The header
+// and ad are authenticated.
+func seal(o encryptedOptions, passphrase, b, ad []byte) ([]byte, error) {
+	header := make([]byte, headerSize, headerSize+chacha20poly1305.NonceSizeX)
+	copy(header, encryptedMagic)
+	i := len(encryptedMagic)
+	header[i] = encryptedVersion
+	header[i+1] = byte(o.kdf)
+	i += 2
+	for _, param := range o.params {
+		binary.BigEndian.PutUint32(header[i:], param)
+		i += 4
+	}
+	header[i] = byte(o.cipher)
+	salt := header[i+1:]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	key, err := o.deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := o.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	header = header[:headerSize+aead.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, header[headerSize:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[headerSize:], b, associatedData(header, ad)), nil
+}
+
+// open returns the key of the encrypted file data, with the parameters
+// recorded in its header, if it was sealed with ad.
+func open(passphrase, data, ad []byte) ([]byte, error) {
+	if len(data) < headerSize {
+		return nil, errors.New("truncated header")
+	}
+	i := len(encryptedMagic)
+	if data[i] != encryptedVersion {
+		return nil, fmt.Errorf("unsupported version: %d", data[i])
+	}
+	o := encryptedOptions{kdf: KDF(data[i+1])}
+	i += 2
+	for j := range o.params {
+		o.params[j] = binary.BigEndian.Uint32(data[i:])
+		i += 4
+	}
+	o.cipher = Cipher(data[i])
+	salt := data[i+1 : headerSize]
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := o.deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := o.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize+aead.NonceSize() {
+		return nil, errors.New("truncated header")
+	}
+	header := data[:headerSize+aead.NonceSize()]
+	return aead.Open(nil, header[headerSize:], data[len(header):], associatedData(header, ad))
+}
+
+// associatedData returns the data authenticated with the key of a file: its
+// header, followed by ad.
+func associatedData(header, ad []byte) []byte {
+	if len(ad) == 0 {
+		return header
+	}
+	return append(bytes.Clone(header), ad...)
+}
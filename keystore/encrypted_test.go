@@ -0,0 +1,294 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	ci "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+var passphrase = []byte("correct horse battery staple")
+
+// fastKDF makes the key derivation cheap enough for the tests.
+var fastKDF = WithArgon2id(1, 64, 1)
+
+func allKeyTypes(t *testing.T) map[string]ci.PrivKey {
+	keys := make(map[string]ci.PrivKey)
+	for name, typ := range map[string]int{
+		"ed25519":   ci.Ed25519,
+		"rsa":       ci.RSA,
+		"secp256k1": ci.Secp256k1,
+		"ecdsa":     ci.ECDSA,
+	} {
+		k, _, err := ci.GenerateKeyPairWithReader(typ, 2048, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = k
+	}
+	return keys
+}
+
+func TestEncryptedKeystoreRoundTrip(t *testing.T) {
+	keys := allKeyTypes(t)
+
+	for name, opts := range map[string][]EncryptedOption{
+		"argon2id-aes256gcm":         {fastKDF},
+		"argon2id-xchacha20poly1305": {fastKDF, WithCipher(CipherXChaCha20Poly1305)},
+		"scrypt-aes256gcm":           {WithScrypt(1<<10, 8, 1)},
+		"scrypt-xchacha20poly1305":   {WithScrypt(1<<10, 8, 1), WithCipher(CipherXChaCha20Poly1305)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tdir := t.TempDir()
+			ks, err := NewEncryptedFSKeystore(tdir, passphrase, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, k := range keys {
+				if err := ks.Put(name, k); err != nil {
+					t.Fatal(err)
+				}
+				if err := ks.Put(name, k); err != ErrKeyExists {
+					t.Fatalf("expected ErrKeyExists, got %v", err)
+				}
+			}
+			if err := assertDirContents(tdir, []string{"ed25519", "rsa", "secp256k1", "ecdsa"}); err != nil {
+				t.Fatal(err)
+			}
+
+			// the keys are read back with other options, as recorded per file
+			ks, err = NewEncryptedFSKeystore(tdir, passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, k := range keys {
+				if err := assertGetKey(ks, name, k); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the files are not readable as plaintext keys
+			fks, err := NewFSKeystore(tdir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fks.Get("rsa"); err != ErrEncryptedKey {
+				t.Fatalf("expected ErrEncryptedKey, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedKeystoreWrongPassphrase(t *testing.T) {
+	tdir := t.TempDir()
+	ks, err := NewEncryptedFSKeystore(tdir, passphrase, fastKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("foo", privKeyOrFatal(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err = NewEncryptedFSKeystore(tdir, []byte("wrong"), fastKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ks.Get("foo")
+	var derr *ErrDecryption
+	if !errors.As(err, &derr) || derr.Name != "foo" {
+		t.Fatalf("expected an ErrDecryption of foo, got %v", err)
+	}
+	if !errors.Is(err, &ErrDecryption{}) {
+		t.Fatal("expected errors.Is to match ErrDecryption")
+	}
+
+	// the other operations do not need the passphrase
+	has, err := ks.Has("foo")
+	if err != nil || !has {
+		t.Fatal("expected the key to exist")
+	}
+	if err := ks.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedKeystoreMigrate(t *testing.T) {
+	tdir := t.TempDir()
+	fks, err := NewFSKeystore(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := allKeyTypes(t)
+	for name, k := range keys {
+		if err := fks.Put(name, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the plaintext keys are not mixed with encrypted ones
+	if _, err := NewEncryptedFSKeystore(tdir, passphrase, fastKDF); err != ErrPlaintextKey {
+		t.Fatalf("expected ErrPlaintextKey, got %v", err)
+	}
+
+	ks, err := Migrate(tdir, passphrase, fastKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertDirContents(tdir, []string{"ed25519", "rsa", "secp256k1", "ecdsa"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, k := range keys {
+		if err := assertGetKey(ks, name, k); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fks.Get(name); err != ErrEncryptedKey {
+			t.Fatalf("expected ErrEncryptedKey, got %v", err)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(tdir, mustEncode(t, "rsa")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o400 {
+		t.Fatalf("expected the migrated key to be read-only, got %v", fi.Mode())
+	}
+
+	// a plaintext key added later is migrated by a second run, which leaves
+	// the encrypted keys as they are
+	before, err := os.ReadFile(filepath.Join(tdir, mustEncode(t, "rsa")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := privKeyOrFatal(t)
+	if err := fks.Put("later", k); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("later"); err != ErrPlaintextKey {
+		t.Fatalf("expected ErrPlaintextKey, got %v", err)
+	}
+	ks, err = Migrate(tdir, passphrase, fastKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertGetKey(ks, "later", k); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(filepath.Join(tdir, mustEncode(t, "rsa")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Fatal("expected the encrypted key to be left as it is")
+	}
+
+	l, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(l)
+	if len(l) != 5 || l[0] != "ecdsa" || l[4] != "secp256k1" {
+		t.Fatalf("wrong entries listed: %v", l)
+	}
+}
+
+func TestEncryptedKeystoreCorrupted(t *testing.T) {
+	tdir := t.TempDir()
+	ks, err := NewEncryptedFSKeystore(tdir, passphrase, fastKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("foo", privKeyOrFatal(t)); err != nil {
+		t.Fatal(err)
+	}
+	kp := filepath.Join(tdir, mustEncode(t, "foo"))
+	data, err := os.ReadFile(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, corrupt := range map[string]func([]byte) []byte{
+		"ciphertext": func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+		"nonce":      func(b []byte) []byte { b[headerSize] ^= 1; return b },
+		"salt":       func(b []byte) []byte { b[headerSize-1] ^= 1; return b },
+		"params":     func(b []byte) []byte { b[len(encryptedMagic)+2+3] ^= 1; return b },
+		"version":    func(b []byte) []byte { b[len(encryptedMagic)] = 2; return b },
+		"kdf":        func(b []byte) []byte { b[len(encryptedMagic)+1] = 0; return b },
+		"truncated":  func(b []byte) []byte { return b[:headerSize+4] },
+		"header":     func(b []byte) []byte { return b[:len(encryptedMagic)+1] },
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := os.Chmod(kp, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(kp, corrupt(append([]byte(nil), data...)), 0o400); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ks.Get("foo"); !errors.Is(err, &ErrDecryption{}) {
+				t.Fatalf("expected ErrDecryption, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedKeystoreOversizedParams(t *testing.T) {
+	if _, err := NewEncryptedFSKeystore(t.TempDir(), passphrase, WithArgon2id(1, MaxArgon2idMemory+1, 1)); err == nil {
+		t.Fatal("expected the argon2id memory over the maximum to be rejected")
+	}
+	if _, err := NewEncryptedFSKeystore(t.TempDir(), passphrase, WithScrypt(MaxScryptN*2, 1, 1)); err == nil {
+		t.Fatal("expected the scrypt n over the maximum to be rejected")
+	}
+
+	params := len(encryptedMagic) + 2
+	for name, tc := range map[string]struct {
+		opt   EncryptedOption
+		param int
+		value uint32
+	}{
+		"argon2id time":   {fastKDF, 0, 1 << 31},
+		"argon2id memory": {fastKDF, 1, 1<<32 - 1},
+		"scrypt n":        {WithScrypt(16, 1, 1), 0, 1 << 30},
+		"scrypt n*r":      {WithScrypt(1<<17, 1, 1), 1, 65},
+		"scrypt p":        {WithScrypt(16, 1, 1), 2, 1 << 29},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tdir := t.TempDir()
+			ks, err := NewEncryptedFSKeystore(tdir, passphrase, tc.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ks.Put("foo", privKeyOrFatal(t)); err != nil {
+				t.Fatal(err)
+			}
+			kp := filepath.Join(tdir, mustEncode(t, "foo"))
+			data, err := os.ReadFile(kp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			binary.BigEndian.PutUint32(data[params+4*tc.param:], tc.value)
+			if err := os.Chmod(kp, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(kp, data, 0o400); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ks.Get("foo"); !errors.Is(err, &ErrDecryption{}) {
+				t.Fatalf("expected ErrDecryption, got %v", err)
+			}
+		})
+	}
+}
+
+func mustEncode(t *testing.T, name string) string {
+	encoded, err := encode(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
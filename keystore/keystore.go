@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	logging "github.com/ipfs/go-log/v2"
 	ci "github.com/libp2p/go-libp2p/core/crypto"
@@ -38,9 +39,17 @@ var ErrKeyExists = errors.New("key by that name already exists, refusing to over
 
 const keyFilenamePrefix = "key_"
 
+// tempFilenamePrefix is the prefix of the files being written, which are not
+// keys yet.
+const tempFilenamePrefix = ".tmp-"
+
 // FSKeystore is a keystore backed by files in a given directory stored on disk.
+// It is safe for concurrent use.
 type FSKeystore struct {
 	dir string
+
+	// locks are the locks of the names, by their hash
+	locks [lockStripes]sync.RWMutex
 }
 
 // NewFSKeystore returns a new filesystem-backed keystore.
@@ -52,7 +61,7 @@ func NewFSKeystore(dir string) (*FSKeystore, error) {
 	default:
 		return nil, err
 	}
-	return &FSKeystore{dir}, nil
+	return &FSKeystore{dir: dir}, nil
 }
 
 // Has returns whether or not a key exists in the Keystore
@@ -62,6 +71,14 @@ func (ks *FSKeystore) Has(name string) (bool, error) {
 		return false, err
 	}
 
+	name, err = ks.resolve(name)
+	if err != nil {
+		return false, err
+	}
+
+	unlock := ks.rlock(name)
+	defer unlock()
+
 	kp := filepath.Join(ks.dir, name)
 
 	_, err = os.Stat(kp)
@@ -84,6 +101,29 @@ func (ks *FSKeystore) Put(name string, k ci.PrivKey) error {
 		return err
 	}
 
+	return ks.put(name, b)
+}
+
+// put writes the file of the encoded name with data, if there is no key or
+// alias of that name.
+func (ks *FSKeystore) put(name string, data []byte) error {
+	unlock := ks.lock(name)
+	defer unlock()
+
+	_, err := os.Lstat(filepath.Join(ks.dir, aliasFilename(name)))
+	if err == nil {
+		return ErrKeyExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return ks.create(name, data)
+}
+
+// create writes data to the new file name. The file is reserved with O_EXCL
+// and the data written to a temporary file renamed over it, so that a key is
+// never read partially written.
+func (ks *FSKeystore) create(name string, data []byte) error {
 	kp := filepath.Join(ks.dir, name)
 
 	fi, err := os.OpenFile(kp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o400)
@@ -93,11 +133,13 @@ func (ks *FSKeystore) Put(name string, k ci.PrivKey) error {
 		}
 		return err
 	}
-	defer fi.Close()
-
-	_, err = fi.Write(b)
+	fi.Close()
 
-	return err
+	if err := ks.replace(name, data); err != nil {
+		os.Remove(kp)
+		return err
+	}
+	return nil
 }
 
 // Get retrieves a key from the Keystore if it exists, and returns ErrNoSuchKey
@@ -108,6 +150,32 @@ func (ks *FSKeystore) Get(name string) (ci.PrivKey, error) {
 		return nil, err
 	}
 
+	data, err := ks.read(name)
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(data) {
+		return nil, ErrEncryptedKey
+	}
+
+	return ci.UnmarshalPrivateKey(data)
+}
+
+// read reads the file of the key of the encoded name, or of its target if it
+// is an alias.
+func (ks *FSKeystore) read(name string) ([]byte, error) {
+	name, err := ks.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return ks.get(name)
+}
+
+// get reads the file of the encoded name.
+func (ks *FSKeystore) get(name string) ([]byte, error) {
+	unlock := ks.rlock(name)
+	defer unlock()
+
 	kp := filepath.Join(ks.dir, name)
 
 	data, err := os.ReadFile(kp)
@@ -117,20 +185,29 @@ func (ks *FSKeystore) Get(name string) (ci.PrivKey, error) {
 		}
 		return nil, err
 	}
-
-	return ci.UnmarshalPrivateKey(data)
+	return data, nil
 }
 
-// Delete removes a key from the Keystore
+// Delete removes a key from the Keystore. An alias is removed without its
+// target.
 func (ks *FSKeystore) Delete(name string) error {
 	name, err := encode(name)
 	if err != nil {
 		return err
 	}
 
+	unlock := ks.lock(name)
+	defer unlock()
+
 	kp := filepath.Join(ks.dir, name)
 
-	return os.Remove(kp)
+	err = os.Remove(kp)
+	if os.IsNotExist(err) {
+		if aerr := os.Remove(filepath.Join(ks.dir, aliasFilename(name))); !os.IsNotExist(aerr) {
+			return aerr
+		}
+	}
+	return err
 }
 
 // List return a list of key identifier
@@ -148,6 +225,12 @@ func (ks *FSKeystore) List() ([]string, error) {
 	list := make([]string, 0, len(dirs))
 
 	for _, name := range dirs {
+		if strings.HasPrefix(name, tempFilenamePrefix) {
+			continue
+		}
+		if strings.HasPrefix(name, aliasFilenamePrefix) {
+			name = keyFilenamePrefix + name[len(aliasFilenamePrefix):]
+		}
 		decodedName, err := decode(name)
 		if err == nil {
 			list = append(list, decodedName)
@@ -0,0 +1,344 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	ci "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+const (
+	pemPKCS8     = "PRIVATE KEY"
+	pemSEC1      = "EC PRIVATE KEY"
+	pemPKCS1     = "RSA PRIVATE KEY"
+	pemEncrypted = "BOXO ENCRYPTED PRIVATE KEY"
+
+	// pemContentType is the header of the encrypted PEM blocks with the type
+	// of the block encrypted.
+	pemContentType = "Content-Type"
+)
+
+// ErrPEMPassphrase is returned by [ImportPEM] for an encrypted PEM block
+// without a passphrase.
+var ErrPEMPassphrase = errors.New("PEM block is encrypted, a passphrase is required")
+
+// oidSecp256k1 is the OID of the secp256k1 curve (SEC 2).
+var oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+// oidECPublicKey is the OID of the elliptic curve keys in PKCS#8 (RFC 5480).
+var oidECPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+type pemOptions struct {
+	passphrase []byte
+	encrypted  []EncryptedOption
+}
+
+// PEMOption is an option of [ExportPEM] and [ImportPEM].
+type PEMOption func(*pemOptions)
+
+// WithPEMPassphrase encrypts the PEM blocks exported with passphrase, as
+// [EncryptedFSKeystore] encrypts its keys with opts, and decrypts the PEM
+// blocks imported. The encrypted blocks are of the type "BOXO ENCRYPTED
+// PRIVATE KEY", with the type of the block encrypted in their Content-Type
+// header, authenticated with the key: they are not the legacy encryption of
+// OpenSSL (DEK-Info). The key derivation parameters of the blocks imported
+// are capped as the ones of the files of [EncryptedFSKeystore].
+func WithPEMPassphrase(passphrase []byte, opts ...EncryptedOption) PEMOption {
+	return func(o *pemOptions) {
+		o.passphrase = passphrase
+		o.encrypted = opts
+	}
+}
+
+// ExportPEM writes k to w as a PEM block. The Ed25519, RSA and ECDSA keys are
+// written as PKCS#8 "PRIVATE KEY" blocks. The secp256k1 keys, which PKCS#8
+// parsers seldom support, are written in the SEC1 form, as "EC PRIVATE KEY"
+// blocks with the curve and the compressed public key.
+func ExportPEM(k ci.PrivKey, w io.Writer, opts ...PEMOption) error {
+	var o pemOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	block, err := marshalPEM(k)
+	if err != nil {
+		return err
+	}
+
+	if o.passphrase != nil {
+		eo, err := newEncryptedOptions(o.encrypted)
+		if err != nil {
+			return err
+		}
+		if len(o.passphrase) == 0 {
+			return errors.New("passphrase must be at least one byte")
+		}
+		// the type of the block is authenticated with the key
+		sealed, err := seal(eo, o.passphrase, block.Bytes, []byte(block.Type))
+		if err != nil {
+			return err
+		}
+		block = &pem.Block{
+			Type:    pemEncrypted,
+			Headers: map[string]string{pemContentType: block.Type},
+			Bytes:   sealed,
+		}
+	}
+	return pem.Encode(w, block)
+}
+
+// ImportPEM reads a key from the first PEM block of r. It supports the PKCS#8
+// "PRIVATE KEY" blocks of Ed25519, RSA, ECDSA and secp256k1 keys, the SEC1
+// "EC PRIVATE KEY" blocks, the PKCS#1 "RSA PRIVATE KEY" blocks, and the blocks
+// encrypted by [ExportPEM], which need [WithPEMPassphrase].
+func ImportPEM(r io.Reader, opts ...PEMOption) (ci.PrivKey, error) {
+	var o pemOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalPEM(data, o)
+}
+
+func unmarshalPEM(data []byte, o pemOptions) (ci.PrivKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if _, ok := block.Headers["DEK-Info"]; ok {
+		return nil, errors.New("legacy PEM encryption is not supported")
+	}
+
+	if block.Type == pemEncrypted {
+		if o.passphrase == nil {
+			return nil, ErrPEMPassphrase
+		}
+		if !isEncrypted(block.Bytes) {
+			return nil, &ErrDecryption{Err: errors.New("missing header")}
+		}
+		der, err := open(o.passphrase, block.Bytes, []byte(block.Headers[pemContentType]))
+		if err != nil {
+			return nil, &ErrDecryption{Err: err}
+		}
+		block = &pem.Block{Type: block.Headers[pemContentType], Bytes: der}
+	}
+
+	var std any
+	var err error
+	switch block.Type {
+	case pemPKCS8:
+		std, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			// x509 does not know the secp256k1 curve
+			if k, serr := parsePKCS8Secp256k1(block.Bytes); serr == nil {
+				return k, nil
+			}
+		}
+	case pemSEC1:
+		if k, serr := parseSEC1Secp256k1(block.Bytes); serr == nil {
+			return k, nil
+		}
+		std, err = x509.ParseECPrivateKey(block.Bytes)
+	case pemPKCS1:
+		std, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromStdKey(std)
+}
+
+func fromStdKey(std any) (ci.PrivKey, error) {
+	if k, ok := std.(ed25519.PrivateKey); ok {
+		std = &k
+	}
+	k, _, err := ci.KeyPairFromStdKey(std)
+	return k, err
+}
+
+// marshalPEM returns the unencrypted PEM block of k.
+func marshalPEM(k ci.PrivKey) (*pem.Block, error) {
+	if k.Type() == ci.Secp256k1 {
+		der, err := marshalSEC1Secp256k1(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: pemSEC1, Bytes: der}, nil
+	}
+
+	std, err := ci.PrivKeyToStdKey(k)
+	if err != nil {
+		return nil, err
+	}
+	switch std := std.(type) {
+	case *ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(*std)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: pemPKCS8, Bytes: der}, nil
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(std)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: pemPKCS8, Bytes: der}, nil
+	default:
+		return nil, ci.ErrBadKeyType
+	}
+}
+
+// ecPrivateKey is the SEC1 ECPrivateKey structure (RFC 5915).
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// pkcs8 is the PKCS#8 PrivateKeyInfo structure (RFC 5208).
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+func marshalSEC1Secp256k1(k ci.PrivKey) ([]byte, error) {
+	raw, err := k.Raw()
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.GetPublic().Raw()
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ecPrivateKey{
+		Version:       1,
+		PrivateKey:    raw,
+		NamedCurveOID: oidSecp256k1,
+		PublicKey:     asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)},
+	})
+}
+
+// parseSEC1Secp256k1 parses the SEC1 form of a secp256k1 key, whose curve is
+// in der or, inside PKCS#8, given by curve.
+func parseSEC1Secp256k1(der []byte, curve ...asn1.ObjectIdentifier) (ci.PrivKey, error) {
+	var key ecPrivateKey
+	rest, err := asn1.Unmarshal(der, &key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after the EC private key")
+	}
+	oid := key.NamedCurveOID
+	if len(oid) == 0 && len(curve) != 0 {
+		oid = curve[0]
+	}
+	if key.Version != 1 || !oid.Equal(oidSecp256k1) {
+		return nil, errors.New("not a secp256k1 private key")
+	}
+	if len(key.PrivateKey) != 32 {
+		return nil, errors.New("invalid secp256k1 private key length")
+	}
+	return ci.UnmarshalSecp256k1PrivateKey(key.PrivateKey)
+}
+
+func parsePKCS8Secp256k1(der []byte) (ci.PrivKey, error) {
+	var key pkcs8
+	if _, err := asn1.Unmarshal(der, &key); err != nil {
+		return nil, err
+	}
+	if !key.Algo.Algorithm.Equal(oidECPublicKey) {
+		return nil, errors.New("not an elliptic curve private key")
+	}
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(key.Algo.Parameters.FullBytes, &curve); err != nil {
+		return nil, err
+	}
+	return parseSEC1Secp256k1(key.PrivateKey, curve)
+}
+
+// exportKey returns the PEM block of the key name of ks.
+func exportKey(ks Keystore, name string, opts []PEMOption) ([]byte, error) {
+	k, err := ks.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := ExportPEM(k, &buf, opts...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// importKey stores the key of pemBytes in ks as name. It returns ErrKeyExists
+// if ks already has a key of that name, before parsing pemBytes.
+func importKey(ks Keystore, name string, pemBytes []byte, opts []PEMOption) error {
+	has, err := ks.Has(name)
+	if err != nil {
+		return err
+	}
+	if has {
+		return ErrKeyExists
+	}
+
+	var o pemOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	k, err := unmarshalPEM(pemBytes, o)
+	if err != nil {
+		return err
+	}
+	return ks.Put(name, k)
+}
+
+// ExportKey returns the key name as a PEM block, see [ExportPEM].
+func (ks *FSKeystore) ExportKey(name string, opts ...PEMOption) ([]byte, error) {
+	return exportKey(ks, name, opts)
+}
+
+// ImportKey stores the key of the PEM block pemBytes as name, see [ImportPEM].
+// It returns ErrKeyExists if a key with the same name already exists.
+func (ks *FSKeystore) ImportKey(name string, pemBytes []byte, opts ...PEMOption) error {
+	return importKey(ks, name, pemBytes, opts)
+}
+
+// ExportKey returns the key name as a PEM block, see [ExportPEM]. The block
+// is not encrypted unless [WithPEMPassphrase] is given.
+func (ks *EncryptedFSKeystore) ExportKey(name string, opts ...PEMOption) ([]byte, error) {
+	return exportKey(ks, name, opts)
+}
+
+// ImportKey stores the key of the PEM block pemBytes as name, see [ImportPEM].
+// It returns ErrKeyExists if a key with the same name already exists.
+func (ks *EncryptedFSKeystore) ImportKey(name string, pemBytes []byte, opts ...PEMOption) error {
+	return importKey(ks, name, pemBytes, opts)
+}
+
+// ExportKey returns the key name as a PEM block, see [ExportPEM].
+func (mk *MemKeystore) ExportKey(name string, opts ...PEMOption) ([]byte, error) {
+	return exportKey(mk, name, opts)
+}
+
+// ImportKey stores the key of the PEM block pemBytes as name, see [ImportPEM].
+// It returns ErrKeyExists if a key with the same name already exists.
+func (mk *MemKeystore) ImportKey(name string, pemBytes []byte, opts ...PEMOption) error {
+	return importKey(mk, name, pemBytes, opts)
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	gopath "path"
 	"strings"
 	"time"
 
@@ -20,18 +19,46 @@ import (
 // LookupTXTFunc is a function that lookups TXT record values.
 type LookupTXTFunc func(ctx context.Context, name string) (txt []string, err error)
 
+// LookupTXTWithTTLFunc is a function that lookups TXT record values and the
+// TTL of the answer.
+type LookupTXTWithTTLFunc func(ctx context.Context, name string) (txt []string, ttl time.Duration, err error)
+
+// TXTWithTTLResolver is implemented by the DNS resolvers which report the TTL
+// of TXT answers. [WithDNSResolver] uses it when available, so that DNSLink
+// results are cached according to their TTL. It is opt-in: neither the OS
+// resolver nor the resolvers of [NewDNSRoutingResolver] implement it.
+type TXTWithTTLResolver interface {
+	LookupTXTWithTTL(ctx context.Context, name string) (txt []string, ttl time.Duration, err error)
+}
+
 // DNSResolver implements [Resolver] on DNS domains.
 type DNSResolver struct {
-	lookupTXT LookupTXTFunc
+	lookupTXT        LookupTXTFunc
+	lookupTXTWithTTL LookupTXTWithTTLFunc
 }
 
 var _ Resolver = &DNSResolver{}
 
-// NewDNSResolver constructs a name resolver using DNS TXT records.
+// NewDNSResolver constructs a name resolver using DNS TXT records. The
+// results have no TTL.
 func NewDNSResolver(lookup LookupTXTFunc) *DNSResolver {
 	return &DNSResolver{lookupTXT: lookup}
 }
 
+// NewDNSResolverWithTTL is like [NewDNSResolver], the results carry the TTL
+// of the DNS answers.
+func NewDNSResolverWithTTL(lookup LookupTXTWithTTLFunc) *DNSResolver {
+	return &DNSResolver{lookupTXTWithTTL: lookup}
+}
+
+func (r *DNSResolver) lookup(ctx context.Context, name string) ([]string, time.Duration, error) {
+	if r.lookupTXTWithTTL != nil {
+		return r.lookupTXTWithTTL(ctx, name)
+	}
+	txt, err := r.lookupTXT(ctx, name)
+	return txt, 0, err
+}
+
 func (r *DNSResolver) Resolve(ctx context.Context, p path.Path, options ...ResolveOption) (Result, error) {
 	ctx, span := startSpan(ctx, "DNSResolver.Resolve", trace.WithAttributes(attribute.Stringer("Path", p)))
 	defer span.End()
@@ -70,44 +97,40 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, p path.Path, options
 		fqdn += "."
 	}
 
-	resChan := make(chan AsyncResult, 1)
-	go workDomain(ctx, r, "_dnslink."+fqdn, resChan)
-
 	go func() {
 		defer close(out)
 		ctx, span := startSpan(ctx, "DNSResolver.ResolveOnceAsync.Worker")
 		defer span.End()
 
-		select {
-		case subRes, ok := <-resChan:
-			if !ok {
-				break
-			}
-			if subRes.Err == nil {
-				p, err := joinPaths(subRes.Path, p)
-				emitOnceResult(ctx, out, AsyncResult{Path: p, LastMod: time.Now(), Err: err})
-				// Return without waiting for rootRes, since this result
-				// (for "_dnslink."+fqdn) takes precedence
-			} else {
-				err := fmt.Errorf("DNSLink lookup for %q failed: %w", gopath.Base(fqdn), subRes.Err)
-				emitOnceResult(ctx, out, AsyncResult{Err: err})
+		// The _dnslink subdomain takes precedence, the apex is the fallback.
+		res := workDomain(ctx, r, "_dnslink."+fqdn)
+		if errors.Is(res.Err, ErrMissingDNSLinkRecord) && ctx.Err() == nil {
+			if apexRes := workDomain(ctx, r, fqdn); apexRes.Err == nil {
+				res = apexRes
 			}
-			return
-		case <-ctx.Done():
+		}
+
+		if res.Err != nil {
+			emitOnceResult(ctx, out, AsyncResult{Err: &DNSLinkError{Domain: strings.TrimSuffix(fqdn, "."), Err: res.Err}})
 			return
 		}
+		p, err := joinPaths(res.Path, p)
+		emitOnceResult(ctx, out, AsyncResult{Path: p, TTL: res.TTL, LastMod: time.Now(), Err: err})
 	}()
 
 	return out
 }
 
-func workDomain(ctx context.Context, r *DNSResolver, name string, res chan AsyncResult) {
+// workDomain looks up the DNSLink TXT records of name. When there are several
+// valid entries, the lexicographically smallest one is used, following the
+// DNSLink specification. The lookup must return one string per TXT record,
+// with the character-strings of a record concatenated, as [net.Resolver]
+// does.
+func workDomain(ctx context.Context, r *DNSResolver, name string) AsyncResult {
 	ctx, span := startSpan(ctx, "DNSResolver.WorkDomain", trace.WithAttributes(attribute.String("Name", name)))
 	defer span.End()
 
-	defer close(res)
-
-	txt, err := r.lookupTXT(ctx, name)
+	txt, ttl, err := r.lookup(ctx, name)
 	if err != nil {
 		var dnsErr *net.DNSError
 		if errors.As(err, &dnsErr) {
@@ -118,8 +141,7 @@ func workDomain(ctx context.Context, r *DNSResolver, name string, res chan Async
 			}
 		}
 		// Could not look up any text records for name
-		res <- AsyncResult{Err: err}
-		return
+		return AsyncResult{Err: err}
 	}
 
 	// Convert all the found TXT records into paths. Ignore invalid ones.
@@ -137,17 +159,15 @@ func workDomain(ctx context.Context, r *DNSResolver, name string, res chan Async
 			item.Namespace() == path.IPNSNamespace
 	})
 
-	switch len(paths) {
-	case 0:
+	if len(paths) == 0 {
 		// There were no TXT records with a dnslink
-		res <- AsyncResult{Err: ErrMissingDNSLinkRecord}
-	case 1:
-		// Found 1 valid! Return it.
-		res <- AsyncResult{Path: paths[0]}
-	default:
-		// Found more than 1 IPFS/IPNS path.
-		res <- AsyncResult{Err: ErrMultipleDNSLinkRecords}
+		return AsyncResult{Err: ErrMissingDNSLinkRecord}
 	}
+
+	best := lo.MinBy(paths, func(a, b path.Path) bool {
+		return a.String() < b.String()
+	})
+	return AsyncResult{Path: best, TTL: ttl}
 }
 
 func parseEntry(txt string) (path.Path, error) {
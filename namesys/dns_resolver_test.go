@@ -5,7 +5,9 @@ import (
 	"net"
 	"testing"
 
+	"github.com/ipfs/boxo/path"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDNSParseEntry(t *testing.T) {
@@ -82,7 +84,7 @@ func newMockDNS() *mockDNS {
 			},
 			"_dnslink.multi-invalid.example.com.": {
 				"some stuff",
-				"dnslink=/ipns/dns1.example.com", // we must error when >1 value with /ipns or /ipfs exists
+				"dnslink=/ipns/dns1.example.com", // the lexicographically smallest value with /ipns or /ipfs is used
 				"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
 				"broken dnslink=/ipns/example.invalid",
 			},
@@ -171,17 +173,17 @@ func TestDNSResolution(t *testing.T) {
 		{"/ipns/multi.example.com", DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil},
 		{"/ipns/multi.example.com", 1, "/ipns/dns1.example.com", ErrResolveRecursion},
 		{"/ipns/multi.example.com", 2, "/ipns/ipfs.example.com", ErrResolveRecursion},
-		{"/ipns/multi-invalid.example.com", 2, "", ErrMultipleDNSLinkRecords},
+		{"/ipns/multi-invalid.example.com", 2, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil},
 		{"/ipns/multi-valid.example.com", DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil},
 		{"/ipns/equals.example.com", DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD/=equals", nil},
 		{"/ipns/loop1.example.com", 1, "/ipns/loop2.example.com", ErrResolveRecursion},
 		{"/ipns/loop1.example.com", 2, "/ipns/loop1.example.com", ErrResolveRecursion},
-		{"/ipns/loop1.example.com", 3, "/ipns/loop2.example.com", ErrResolveRecursion},
-		{"/ipns/loop1.example.com", DefaultDepthLimit, "/ipns/loop1.example.com", ErrResolveRecursion},
+		{"/ipns/loop1.example.com", 3, "/ipns/loop1.example.com", ErrResolveLoop},
+		{"/ipns/loop1.example.com", DefaultDepthLimit, "/ipns/loop1.example.com", ErrResolveLoop},
 		{"/ipns/dloop1.example.com", 1, "/ipns/loop2.example.com", ErrResolveRecursion},
 		{"/ipns/dloop1.example.com", 2, "/ipns/loop1.example.com", ErrResolveRecursion},
 		{"/ipns/dloop1.example.com", 3, "/ipns/loop2.example.com", ErrResolveRecursion},
-		{"/ipns/dloop1.example.com", DefaultDepthLimit, "/ipns/loop1.example.com", ErrResolveRecursion},
+		{"/ipns/dloop1.example.com", DefaultDepthLimit, "/ipns/loop2.example.com", ErrResolveLoop},
 		{"/ipns/bad.example.com", DefaultDepthLimit, "", ErrResolveFailed},
 		{"/ipns/bad.example.com", DefaultDepthLimit, "", ErrResolveFailed},
 		{"/ipns/withsegment.example.com", DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD/sub/segment", nil},
@@ -206,3 +208,68 @@ func TestDNSResolution(t *testing.T) {
 		})
 	}
 }
+
+func TestDNSLinkResolution(t *testing.T) {
+	t.Parallel()
+
+	const (
+		cid1 = "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"
+		cid2 = "/ipfs/QmYvMB9yrsSf7RKBghkfwmHJkzJhW2ZgVwq3LxBXXPasFr"
+	)
+	r := NewDNSResolver((&mockDNS{
+		entries: map[string][]string{
+			"_dnslink.multi.example.com.": {
+				"dnslink=" + cid2,
+				"not a dnslink",
+				"dnslink=/ipns/zzz.example.com",
+				"dnslink=invalid",
+				"dnslink=" + cid1,
+			},
+			"_dnslink.chain1.example.com.": {"dnslink=/ipns/chain2.example.com/a"},
+			"_dnslink.chain2.example.com.": {"dnslink=/ipns/apex.example.com/b"},
+			"apex.example.com.":            {"dnslink=" + cid1},
+			"_dnslink.both.example.com.":   {"dnslink=" + cid1},
+			"both.example.com.":            {"dnslink=" + cid2},
+			"_dnslink.apexinvalid.com.":    {"not a dnslink"},
+			"apexinvalid.com.":             {"dnslink=" + cid2},
+			"_dnslink.loop1.example.com.":  {"dnslink=/ipns/loop2.example.com"},
+			"_dnslink.loop2.example.com.":  {"dnslink=/ipns/LOOP1.example.com."},
+			"_dnslink.self.example.com.":   {"dnslink=/ipns/self.example.com/sub"},
+			"_dnslink.broken.example.com.": {"dnslink=/ipns/missing.example.com"},
+		},
+	}).lookupTXT)
+
+	for _, testCase := range []struct {
+		name, expectedPath string
+		expectedError      error
+		expectedMessage    string
+	}{
+		{"/ipns/multi.example.com", cid1, nil, ""},
+		{"/ipns/chain1.example.com", cid1 + "/b/a", nil, ""},
+		{"/ipns/apex.example.com", cid1, nil, ""},
+		{"/ipns/both.example.com", cid1, nil, ""},
+		{"/ipns/apexinvalid.com", cid2, nil, ""},
+		{"/ipns/loop1.example.com", "/ipns/LOOP1.example.com.", ErrResolveLoop, ErrResolveLoop.Error() + ": /ipns/loop1.example.com -> /ipns/loop2.example.com -> /ipns/LOOP1.example.com."},
+		{"/ipns/self.example.com", "/ipns/self.example.com/sub", ErrResolveLoop, ""},
+		{"/ipns/broken.example.com", "", ErrMissingDNSLinkRecord, `DNSLink lookup for "missing.example.com" failed: ` + ErrMissingDNSLinkRecord.Error()},
+		{"/ipns/unknown.example.com", "", ErrResolveFailed, ""},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := path.NewPath(testCase.name)
+			require.NoError(t, err)
+			res, err := r.Resolve(context.Background(), p)
+			require.ErrorIs(t, err, testCase.expectedError)
+			if testCase.expectedPath == "" {
+				require.Nil(t, res.Path)
+			} else {
+				require.Equal(t, testCase.expectedPath, res.Path.String())
+			}
+			if testCase.expectedMessage != "" {
+				require.EqualError(t, err, testCase.expectedMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package namesys
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	doh "github.com/libp2p/go-doh-resolver"
+	dns "github.com/miekg/dns"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// NewDNSRoutingResolver returns a DNS resolver which sends the lookups of the
+// names under each domain suffix of resolvers to the matching resolver, the
+// longest suffix winning, and the other lookups to def. It can be passed to
+// [WithDNSResolver].
+//
+// The suffixes must be [FQDNs], for example "eth.". The "." suffix replaces
+// def, which defaults to the OS resolver when nil.
+//
+// [FQDNs]: https://en.wikipedia.org/wiki/Fully_qualified_domain_name
+func NewDNSRoutingResolver(def madns.BasicResolver, resolvers map[string]madns.BasicResolver) (*madns.Resolver, error) {
+	if def == nil {
+		def = net.DefaultResolver
+	}
+
+	var opts []madns.Option
+	for domain, rslv := range resolvers {
+		if domain != "." && !dns.IsFqdn(domain) {
+			return nil, fmt.Errorf("invalid domain %s; must be FQDN", domain)
+		}
+		if rslv == nil {
+			return nil, fmt.Errorf("nil resolver for %s", domain)
+		}
+
+		if domain == "." {
+			def = rslv
+		} else {
+			opts = append(opts, madns.WithDomainResolver(domain, rslv))
+		}
+	}
+	opts = append(opts, madns.WithDefaultResolver(def))
+
+	return madns.NewResolver(opts...)
+}
+
+// NewDoHDNSResolver is like [NewDNSRoutingResolver], with the resolvers given
+// as URLs. URLs starting with "https://" are [DoH] endpoints. An empty URL
+// routes the lookups of its suffix to the "." resolver, like the suffixes not
+// given, which allows dropping an implicit default. Domains sharing a URL
+// share the resolver.
+//
+// Example:
+//   - Custom resolver for ENS:          "eth." → "https://eth.link/dns-query"
+//   - Override the default OS resolver: "."    → "https://doh.applied-privacy.net/query"
+//
+// [DoH]: https://en.wikipedia.org/wiki/DNS_over_HTTPS
+func NewDoHDNSResolver(resolvers map[string]string, dohOpts ...doh.Option) (*madns.Resolver, error) {
+	return NewDNSResolverFromURLs(resolvers, func(url string) (madns.BasicResolver, error) {
+		if !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("invalid resolver url: %s", url)
+		}
+		return doh.NewResolver(url, dohOpts...)
+	})
+}
+
+// NewDNSResolverFromURLs is like [NewDoHDNSResolver], with the resolvers of
+// the URLs built by newResolver, once per URL.
+func NewDNSResolverFromURLs(resolvers map[string]string, newResolver func(url string) (madns.BasicResolver, error)) (*madns.Resolver, error) {
+	byURL := make(map[string]madns.BasicResolver) // to reuse resolvers for the same URL
+	rslvrs := make(map[string]madns.BasicResolver, len(resolvers))
+
+	for domain, url := range resolvers {
+		if url == "" {
+			if domain != "." && !dns.IsFqdn(domain) {
+				return nil, fmt.Errorf("invalid domain %s; must be FQDN", domain)
+			}
+			continue
+		}
+
+		rslv, ok := byURL[url]
+		if !ok {
+			var err error
+			rslv, err = newResolver(url)
+			if err != nil {
+				return nil, fmt.Errorf("bad resolver for %s: %w", domain, err)
+			}
+			byURL[url] = rslv
+		}
+		rslvrs[domain] = rslv
+	}
+
+	return NewDNSRoutingResolver(nil, rslvrs)
+}
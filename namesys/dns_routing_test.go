@@ -0,0 +1,138 @@
+package namesys
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ipfs/boxo/path"
+	offroute "github.com/ipfs/boxo/routing/offline"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	record "github.com/libp2p/go-libp2p-record"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/require"
+)
+
+// namedBasicResolver answers every TXT lookup with a dnslink to its name, so
+// the tests can tell which resolver was used.
+type namedBasicResolver struct {
+	name    string
+	lookups []string
+}
+
+func (r *namedBasicResolver) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{IsNotFound: true}
+}
+
+func (r *namedBasicResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.lookups = append(r.lookups, name)
+	return []string{"dnslink=/ipns/" + r.name}, nil
+}
+
+func TestDNSRoutingResolver(t *testing.T) {
+	t.Parallel()
+
+	def := &namedBasicResolver{name: "default"}
+	eth := &namedBasicResolver{name: "eth"}
+	sub := &namedBasicResolver{name: "sub.eth"}
+	crypto := &namedBasicResolver{name: "crypto"}
+
+	rslv, err := NewDNSRoutingResolver(def, map[string]madns.BasicResolver{
+		"eth.":     eth,
+		"sub.eth.": sub,
+		"crypto.":  crypto,
+	})
+	require.NoError(t, err)
+
+	for name, expected := range map[string]string{
+		"_dnslink.vitalik.eth.":      "eth",
+		"_dnslink.a.sub.eth.":        "sub.eth",
+		"_dnslink.sub.eth.":          "sub.eth",
+		"_dnslink.notsub.eth.":       "eth",
+		"_dnslink.wallet.crypto.":    "crypto",
+		"_dnslink.example.com.":      "default",
+		"_dnslink.ethereum.org.":     "default",
+		"_dnslink.singlelabel.":      "default",
+		"_dnslink.www.wealdtech.eth": "eth",
+	} {
+		txt, err := rslv.LookupTXT(context.Background(), name)
+		require.NoError(t, err)
+		require.Equal(t, []string{"dnslink=/ipns/" + expected}, txt, name)
+	}
+
+	// DNSLink resolution goes through the routing resolver.
+	r := offroute.NewOfflineRouter(dssync.MutexWrap(ds.NewMapDatastore()), record.NamespacedValidator{})
+	ns, err := NewNameSystem(r, WithDNSResolver(rslv))
+	require.NoError(t, err)
+	p, err := path.NewPath("/ipns/vitalik.eth")
+	require.NoError(t, err)
+	res, err := ns.Resolve(context.Background(), p, ResolveWithDepth(1))
+	require.ErrorIs(t, err, ErrResolveRecursion)
+	require.Equal(t, "/ipns/eth", res.Path.String())
+}
+
+func TestDNSRoutingResolverOverrideDefault(t *testing.T) {
+	t.Parallel()
+
+	def := &namedBasicResolver{name: "default"}
+	private := &namedBasicResolver{name: "private"}
+	rslv, err := NewDNSRoutingResolver(def, map[string]madns.BasicResolver{".": private})
+	require.NoError(t, err)
+
+	txt, err := rslv.LookupTXT(context.Background(), "_dnslink.example.com.")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dnslink=/ipns/private"}, txt)
+	require.Empty(t, def.lookups)
+
+	_, err = NewDNSRoutingResolver(nil, map[string]madns.BasicResolver{"eth": private})
+	require.Error(t, err, "suffixes must be FQDNs")
+}
+
+func TestDoHDNSResolver(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDoHDNSResolver(map[string]string{"eth.": "http://insecure.example.com/dns-query"})
+	require.Error(t, err)
+
+	_, err = NewDoHDNSResolver(map[string]string{
+		"eth.":    "https://resolver.example.com/dns-query",
+		"crypto.": "https://resolver.example.com/dns-query",
+		"com.":    "",
+	})
+	require.NoError(t, err)
+}
+
+func TestDNSResolverFromURLs(t *testing.T) {
+	t.Parallel()
+
+	built := make(map[string]*namedBasicResolver)
+	newResolver := func(url string) (madns.BasicResolver, error) {
+		require.NotContains(t, built, url, "resolvers are shared by URL")
+		built[url] = &namedBasicResolver{name: url}
+		return built[url], nil
+	}
+
+	// an empty URL falls back to the "." resolver, not to the OS resolver
+	rslv, err := NewDNSResolverFromURLs(map[string]string{
+		".":       "private",
+		"eth.":    "",
+		"crypto.": "crypto",
+		"wallet.": "crypto",
+	}, newResolver)
+	require.NoError(t, err)
+	require.Len(t, built, 2)
+	for name, expected := range map[string]string{
+		"_dnslink.vitalik.eth.": "private",
+		"_dnslink.example.com.": "private",
+		"_dnslink.brad.crypto.": "crypto",
+		"_dnslink.brad.wallet.": "crypto",
+	} {
+		txt, err := rslv.LookupTXT(context.Background(), name)
+		require.NoError(t, err)
+		require.Equal(t, []string{"dnslink=/ipns/" + expected}, txt, name)
+	}
+
+	_, err = NewDNSResolverFromURLs(map[string]string{"eth": ""}, newResolver)
+	require.Error(t, err, "suffixes must be FQDNs")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ipfs/boxo/ipns"
@@ -18,13 +19,27 @@ var (
 	// ErrResolveFailed signals an error when attempting to resolve.
 	ErrResolveFailed = errors.New("could not resolve name")
 
-	// ErrResolveRecursion signals a recursion-depth limit.
+	// ErrResolveRecursion signals a recursion-depth limit. The errors returned
+	// when the limit is exceeded are [*RecursionError].
 	ErrResolveRecursion = errors.New("could not resolve name (recursion limit exceeded)")
 
 	// ErrNoNamesys is an explicit error for when no [NameSystem] is provided.
 	ErrNoNamesys = errors.New("no namesys has been provided")
 
+	// ErrInvalidPublish signals invalid arguments or [PublishOptions] given to
+	// [Publisher.Publish].
+	ErrInvalidPublish = errors.New("invalid publish")
+
+	// ErrResolveLoop signals that a name resolves to itself, directly or
+	// through other names. The errors returned when a loop is detected are
+	// [*LoopError].
+	ErrResolveLoop = fmt.Errorf("%w: loop detected", ErrResolveFailed)
+
 	// ErrMultipleDNSLinkRecords signals that the domain had multiple valid DNSLink TXT entries.
+	//
+	// Deprecated: following the DNSLink specification, the lexicographically
+	// smallest entry is used when there are several, so this error is no
+	// longer returned.
 	ErrMultipleDNSLinkRecords = fmt.Errorf("%w: DNSLink lookup returned more than one IPFS content path; ask domain owner to remove duplicate TXT records", ErrResolveFailed)
 
 	// ErrMissingDNSLinkRecord signals that the domain has no DNSLink TXT entries.
@@ -51,6 +66,11 @@ const (
 
 	// DefaultResolverCacheTTL defines default TTL of a record placed in [NameSystem] cache.
 	DefaultResolverCacheTTL = time.Minute
+
+	// DefaultCacheRefreshFraction is a fraction of its TTL before the expiry
+	// of a cache entry from which the [NameSystem] can refresh it in the
+	// background, see [WithCacheRefresh].
+	DefaultCacheRefreshFraction = 0.1
 )
 
 // NameSystem represents a cohesive name publishing and resolving system.
@@ -70,6 +90,9 @@ type Result struct {
 	Path    path.Path
 	TTL     time.Duration
 	LastMod time.Time
+
+	// Trace lists the names resolved to get to Path, in order.
+	Trace []Hop
 }
 
 // AsyncResult is the return type for [Resolver.ResolveAsync].
@@ -78,6 +101,95 @@ type AsyncResult struct {
 	TTL     time.Duration
 	LastMod time.Time
 	Err     error
+
+	// Trace lists the names resolved to get to Path, in order.
+	Trace []Hop
+
+	// Intermediate is true for the partially resolved names emitted with
+	// [ResolveOptions.IntermediateResults]: Path is still mutable and is
+	// being resolved further. The last [Hop] of Trace is the step which led
+	// to Path. Results with Intermediate false are final values or errors.
+	Intermediate bool
+
+	cached bool // Path comes from the cache of the NameSystem
+}
+
+const (
+	// HopIPNS is the [Hop.Resolver] of the names resolved through IPNS.
+	HopIPNS = "ipns"
+
+	// HopDNSLink is the [Hop.Resolver] of the names resolved through DNSLink.
+	HopDNSLink = "dnslink"
+)
+
+// Hop is a step of a resolution: Name was resolved to Value.
+type Hop struct {
+	Name     path.Path
+	Resolver string // [HopIPNS] or [HopDNSLink]
+	Value    path.Path
+	TTL      time.Duration
+	Cached   bool // Value comes from the cache of the [NameSystem]
+}
+
+// RecursionError is returned when the resolution of a name exceeds
+// [ResolveOptions.Depth]. It matches [ErrResolveRecursion] with [errors.Is].
+type RecursionError struct {
+	// Trace is the chain of names resolved before giving up.
+	Trace []Hop
+}
+
+func (e *RecursionError) Error() string {
+	return traceError(ErrResolveRecursion, e.Trace)
+}
+
+func (e *RecursionError) Is(target error) bool {
+	return target == ErrResolveRecursion
+}
+
+// LoopError is returned when a name resolves to itself, directly or through
+// other names. It matches [ErrResolveLoop] with [errors.Is].
+type LoopError struct {
+	// Trace is the chain of names resolved, the last value being a name
+	// already resolved.
+	Trace []Hop
+}
+
+func (e *LoopError) Error() string {
+	return traceError(ErrResolveLoop, e.Trace)
+}
+
+func (e *LoopError) Unwrap() error {
+	return ErrResolveLoop
+}
+
+// traceError returns the message of err followed by the names of trace.
+func traceError(err error, trace []Hop) string {
+	var sb strings.Builder
+	sb.WriteString(err.Error())
+	for i, hop := range trace {
+		if i == 0 {
+			sb.WriteString(": ")
+			sb.WriteString(hop.Name.String())
+		}
+		sb.WriteString(" -> ")
+		sb.WriteString(hop.Value.String())
+	}
+	return sb.String()
+}
+
+// DNSLinkError is returned when the DNSLink lookup of Domain fails, which may
+// be one of the domains resolved along the way.
+type DNSLinkError struct {
+	Domain string
+	Err    error
+}
+
+func (e *DNSLinkError) Error() string {
+	return fmt.Sprintf("DNSLink lookup for %q failed: %v", e.Domain, e.Err)
+}
+
+func (e *DNSLinkError) Unwrap() error {
+	return e.Err
 }
 
 // Resolver is an object capable of resolving names.
@@ -107,7 +219,9 @@ type Resolver interface {
 
 	// ResolveAsync performs recursive name lookup, like Resolve, but it returns entries as
 	// they are discovered in the DHT. Each returned result is guaranteed to be "better"
-	// (which usually means newer) than the previous one.
+	// (which usually means newer) than the previous one. With
+	// [ResolveWithIntermediateResults], the partially resolved names are
+	// emitted as well.
 	ResolveAsync(context.Context, path.Path, ...ResolveOption) <-chan AsyncResult
 }
 
@@ -125,6 +239,25 @@ type ResolveOptions struct {
 	// (although there may be an implicit timeout due to dial timeouts within
 	// the specific routing system like DHT).
 	DhtTimeout time.Duration
+
+	// Nocache skips the cache of the [NameSystem]: names are always resolved
+	// again, and the results still update the cache.
+	Nocache bool
+
+	// HopFunc, when not nil, is called with each [Hop] as soon as it is
+	// resolved, including the intermediate names of the results not yet
+	// emitted by [Resolver.ResolveAsync]. It may be called concurrently.
+	HopFunc func(Hop)
+
+	// IntermediateResults makes [Resolver.ResolveAsync] also emit an
+	// [AsyncResult] for each partially resolved name, marked as
+	// Intermediate, before the final results. It is ignored by
+	// [Resolver.Resolve].
+	IntermediateResults bool
+
+	// cacheOnly makes names which are not cached fail to resolve, see
+	// [ResolveMany].
+	cacheOnly bool
 }
 
 // DefaultResolveOptions returns the default options for resolving an IPNS Path.
@@ -160,6 +293,27 @@ func ResolveWithDhtTimeout(timeout time.Duration) ResolveOption {
 	}
 }
 
+// ResolveWithoutCache sets [ResolveOptions.Nocache].
+func ResolveWithoutCache() ResolveOption {
+	return func(o *ResolveOptions) {
+		o.Nocache = true
+	}
+}
+
+// ResolveWithHopFunc sets [ResolveOptions.HopFunc].
+func ResolveWithHopFunc(fn func(Hop)) ResolveOption {
+	return func(o *ResolveOptions) {
+		o.HopFunc = fn
+	}
+}
+
+// ResolveWithIntermediateResults sets [ResolveOptions.IntermediateResults].
+func ResolveWithIntermediateResults() ResolveOption {
+	return func(o *ResolveOptions) {
+		o.IntermediateResults = true
+	}
+}
+
 // ProcessResolveOptions converts an array of [ResolveOption] into a [ResolveOptions] object.
 func ProcessResolveOptions(opts []ResolveOption) ResolveOptions {
 	resolveOptions := DefaultResolveOptions()
@@ -183,6 +337,11 @@ type PublishOptions struct {
 	// TTL defines for how long the published value is cached locally before checking for updates.
 	TTL time.Duration
 
+	// V1Compatibility defines whether the published record also carries the
+	// deprecated V1 fields and signature, for legacy nodes. V2-only records
+	// are smaller.
+	V1Compatibility bool
+
 	// IPNSOptions are options passed by [IPNSPublisher] to [ipns.NewRecord] when
 	// creating a new record to publish. With this options, you can further customize
 	// the way IPNS Records are created.
@@ -192,8 +351,9 @@ type PublishOptions struct {
 // DefaultPublishOptions returns the default options for publishing an IPNS Record.
 func DefaultPublishOptions() PublishOptions {
 	return PublishOptions{
-		EOL: time.Now().Add(ipns.DefaultRecordLifetime),
-		TTL: ipns.DefaultRecordTTL,
+		EOL:             time.Now().Add(ipns.DefaultRecordLifetime),
+		TTL:             ipns.DefaultRecordTTL,
+		V1Compatibility: true,
 	}
 }
 
@@ -207,13 +367,20 @@ func PublishWithEOL(eol time.Time) PublishOption {
 	}
 }
 
-// PublishWithEOL sets [PublishOptions.TTL].
+// PublishWithTTL sets [PublishOptions.TTL].
 func PublishWithTTL(ttl time.Duration) PublishOption {
 	return func(o *PublishOptions) {
 		o.TTL = ttl
 	}
 }
 
+// PublishWithV1Compatibility sets [PublishOptions.V1Compatibility].
+func PublishWithV1Compatibility(compatible bool) PublishOption {
+	return func(o *PublishOptions) {
+		o.V1Compatibility = compatible
+	}
+}
+
 // PublishWithIPNSOption adds an [ipns.Option] to [PublishOptions.IPNSOptions].
 // These options are used by [IPNSPublisher], which passes them onto the IPNS
 // record creation at [ipns.NewRecord]
@@ -223,6 +390,23 @@ func PublishWithIPNSOption(option ipns.Option) PublishOption {
 	}
 }
 
+// validatePublish checks the arguments of Publish. Records expiring in the
+// past are only rejected when allowExpired is false, so that [IPNSPublisher] can
+// still be used to publish them.
+func validatePublish(sk ci.PrivKey, value path.Path, opts PublishOptions, allowExpired bool) error {
+	switch {
+	case sk == nil:
+		return fmt.Errorf("%w: no private key", ErrInvalidPublish)
+	case value == nil:
+		return fmt.Errorf("%w: no value", ErrInvalidPublish)
+	case !allowExpired && !opts.EOL.After(time.Now()):
+		return fmt.Errorf("%w: EOL %s is not in the future", ErrInvalidPublish, opts.EOL)
+	case opts.TTL < 0:
+		return fmt.Errorf("%w: negative TTL %s", ErrInvalidPublish, opts.TTL)
+	}
+	return nil
+}
+
 // ProcessPublishOptions converts an array of [PublishOption] into a [PublishOptions] object.
 func ProcessPublishOptions(opts []PublishOption) PublishOptions {
 	publishOptions := DefaultPublishOptions()
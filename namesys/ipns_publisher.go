@@ -41,12 +41,17 @@ func NewIPNSPublisher(route routing.ValueStore, ds ds.Datastore) *IPNSPublisher
 }
 
 func (p *IPNSPublisher) Publish(ctx context.Context, priv crypto.PrivKey, value path.Path, options ...PublishOption) error {
+	opts := ProcessPublishOptions(options)
+	if err := validatePublish(priv, value, opts, true); err != nil {
+		return err
+	}
+
 	log.Debugf("Publish %s", value)
 
 	ctx, span := startSpan(ctx, "IPNSPublisher.Publish", trace.WithAttributes(attribute.String("Value", value.String())))
 	defer span.End()
 
-	record, err := p.updateRecord(ctx, priv, value, options...)
+	record, err := p.updateRecord(ctx, priv, value, opts)
 	if err != nil {
 		return err
 	}
@@ -141,7 +146,7 @@ func (p *IPNSPublisher) GetPublished(ctx context.Context, name ipns.Name, checkR
 	return ipns.UnmarshalRecord(value)
 }
 
-func (p *IPNSPublisher) updateRecord(ctx context.Context, k crypto.PrivKey, value path.Path, options ...PublishOption) (*ipns.Record, error) {
+func (p *IPNSPublisher) updateRecord(ctx context.Context, k crypto.PrivKey, value path.Path, opts PublishOptions) (*ipns.Record, error) {
 	id, err := peer.IDFromPrivateKey(k)
 	if err != nil {
 		return nil, err
@@ -175,10 +180,9 @@ func (p *IPNSPublisher) updateRecord(ctx context.Context, k crypto.PrivKey, valu
 		}
 	}
 
-	opts := ProcessPublishOptions(options)
-
 	// Create record
-	r, err := ipns.NewRecord(k, value, seq, opts.EOL, opts.TTL, opts.IPNSOptions...)
+	ipnsOptions := append([]ipns.Option{ipns.WithV1Compatibility(opts.V1Compatibility)}, opts.IPNSOptions...)
+	r, err := ipns.NewRecord(k, value, seq, opts.EOL, opts.TTL, ipnsOptions...)
 	if err != nil {
 		return nil, err
 	}
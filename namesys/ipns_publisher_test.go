@@ -119,3 +119,69 @@ func (d *checkSyncDS) Sync(ctx context.Context, prefix ds.Key) error {
 	d.syncKeys[prefix] = struct{}{}
 	return d.Datastore.Sync(ctx, prefix)
 }
+
+func TestIPNSPublisherOptions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	privKey, pubKey, err := ci.GenerateKeyPairWithReader(ci.Ed25519, 0, rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pubKey)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	serv := mockrouting.NewServer()
+	r := serv.ClientWithDatastore(ctx, testutil.NewIdentity(pid, testutil.ZeroLocalTCPAddress, privKey, pubKey), dstore)
+	publisher := NewIPNSPublisher(r, dssync.MutexWrap(ds.NewMapDatastore()))
+
+	value, err := path.NewPath("/ipfs/bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4")
+	require.NoError(t, err)
+
+	for _, v1 := range []bool{true, false} {
+		for _, ttl := range []time.Duration{0, 5 * time.Minute} {
+			eol := time.Now().Add(3 * time.Hour).Truncate(time.Second)
+			err := publisher.Publish(ctx, privKey, value, PublishWithV1Compatibility(v1), PublishWithEOL(eol), PublishWithTTL(ttl))
+			require.NoError(t, err)
+
+			data, err := r.GetValue(ctx, string(name.RoutingKey()))
+			require.NoError(t, err)
+			rec, err := ipns.UnmarshalRecord(data)
+			require.NoError(t, err)
+			require.NoError(t, ipns.ValidateWithName(rec, name))
+
+			require.Equal(t, v1, rec.V1Compatible(), "v1 %v, ttl %s", v1, ttl)
+			recEOL, err := rec.Validity()
+			require.NoError(t, err)
+			require.True(t, eol.Equal(recEOL), "expected EOL %s, got %s", eol, recEOL)
+			recTTL, err := rec.TTL()
+			require.NoError(t, err)
+			require.Equal(t, ttl, recTTL)
+		}
+	}
+
+	// V2-only records are smaller.
+	sizes := make(map[bool]int)
+	for _, v1 := range []bool{true, false} {
+		rec, err := ipns.NewRecord(privKey, value, 0, time.Now().Add(time.Hour), 0, ipns.WithV1Compatibility(v1))
+		require.NoError(t, err)
+		data, err := ipns.MarshalRecord(rec)
+		require.NoError(t, err)
+		sizes[v1] = len(data)
+	}
+	require.Less(t, sizes[false], sizes[true])
+
+	err = publisher.Publish(ctx, nil, value)
+	require.ErrorIs(t, err, ErrInvalidPublish)
+	err = publisher.Publish(ctx, privKey, nil)
+	require.ErrorIs(t, err, ErrInvalidPublish)
+
+	ns, err := NewNameSystem(r)
+	require.NoError(t, err)
+	err = ns.Publish(ctx, nil, value)
+	require.ErrorIs(t, err, ErrInvalidPublish)
+	err = ns.Publish(ctx, privKey, value, PublishWithEOL(time.Now().Add(-time.Minute)))
+	require.ErrorIs(t, err, ErrInvalidPublish)
+	err = ns.Publish(ctx, privKey, value, PublishWithTTL(-time.Minute))
+	require.ErrorIs(t, err, ErrInvalidPublish)
+}
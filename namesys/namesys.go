@@ -14,12 +14,13 @@ package namesys
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/ipfs/boxo/ipns"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/go-cid"
@@ -48,8 +49,14 @@ type namesys struct {
 	dnsResolver, ipnsResolver resolver
 	ipnsPublisher             Publisher
 
-	staticMap map[string]*cacheEntry
-	cache     *lru.Cache[string, cacheEntry]
+	staticMap map[string]CacheEntry
+	cache     ResolveCache
+
+	minCacheTTL, maxCacheTTL time.Duration
+	cacheRefreshFraction     float64
+
+	refreshLk  sync.Mutex
+	refreshing map[string]struct{}
 }
 
 var _ NameSystem = &namesys{}
@@ -63,7 +70,7 @@ func WithCache(size int) Option {
 			return fmt.Errorf("invalid cache size %d; must be > 0", size)
 		}
 
-		cache, err := lru.New[string, cacheEntry](size)
+		cache, err := newLRUCache(size)
 		if err != nil {
 			return err
 		}
@@ -73,11 +80,66 @@ func WithCache(size int) Option {
 	}
 }
 
+// WithResolveCache is an option that instructs the name system to use the
+// given cache, for example one shared by several name systems, instead of
+// the LRU cache of [WithCache].
+func WithResolveCache(c ResolveCache) Option {
+	return func(ns *namesys) error {
+		if c == nil {
+			return errors.New("resolve cache must not be nil")
+		}
+
+		ns.cache = c
+		return nil
+	}
+}
+
+// WithCacheTTLBounds is an option that clamps the TTL of cache entries, that
+// is the TTL of the IPNS records and of the DNSLink answers, to [minTTL,
+// maxTTL]. A zero bound is ignored. Results without TTL are still not
+// cached.
+func WithCacheTTLBounds(minTTL, maxTTL time.Duration) Option {
+	return func(ns *namesys) error {
+		if minTTL < 0 || maxTTL < 0 || (maxTTL > 0 && minTTL > maxTTL) {
+			return fmt.Errorf("invalid cache TTL bounds [%s, %s]", minTTL, maxTTL)
+		}
+
+		ns.minCacheTTL, ns.maxCacheTTL = minTTL, maxTTL
+		return nil
+	}
+}
+
+// WithCacheRefresh is an option that sets when cache entries are refreshed:
+// once less than fraction of their TTL is left, the cached value is still
+// returned but the name is resolved again in the background, so names in
+// use do not wait for resolution on expiry. Zero disables the refreshes, which
+// is the default; [DefaultCacheRefreshFraction] is a sensible value.
+//
+// The DNSLink entries are cached for [DefaultResolverCacheTTL] unless the DNS
+// resolver is a [TXTWithTTLResolver], which the resolvers of
+// [NewDNSRoutingResolver] and [NewDoHDNSResolver] are not: refreshing them
+// then queries the DNS more often than their TTL asks for.
+func WithCacheRefresh(fraction float64) Option {
+	return func(ns *namesys) error {
+		if fraction < 0 || fraction >= 1 {
+			return fmt.Errorf("invalid cache refresh fraction %v; must be in [0, 1)", fraction)
+		}
+
+		ns.cacheRefreshFraction = fraction
+		return nil
+	}
+}
+
 // WithDNSResolver is an option that supplies a custom DNS resolver to use instead
-// of the system default.
+// of the system default. The TTL of DNSLink answers is used if rslv is a
+// [TXTWithTTLResolver].
 func WithDNSResolver(rslv madns.BasicResolver) Option {
 	return func(ns *namesys) error {
-		ns.dnsResolver = NewDNSResolver(rslv.LookupTXT)
+		if tr, ok := rslv.(TXTWithTTLResolver); ok {
+			ns.dnsResolver = NewDNSResolverWithTTL(tr.LookupTXTWithTTL)
+		} else {
+			ns.dnsResolver = NewDNSResolver(rslv.LookupTXT)
+		}
 		return nil
 	}
 }
@@ -93,14 +155,14 @@ func WithDatastore(ds ds.Datastore) Option {
 
 // NewNameSystem constructs an IPFS [NameSystem] based on the given [routing.ValueStore].
 func NewNameSystem(r routing.ValueStore, opts ...Option) (NameSystem, error) {
-	var staticMap map[string]*cacheEntry
+	var staticMap map[string]CacheEntry
 
 	// Prewarm namesys cache with static records for deterministic tests and debugging.
 	// Useful for testing things like DNSLink without real DNS lookup.
 	// Example:
 	// IPFS_NS_MAP="dnslink-test.example.com:/ipfs/bafkreicysg23kiwv34eg2d7qweipxwosdo2py4ldv42nbauguluen5v6am"
 	if list := os.Getenv("IPFS_NS_MAP"); list != "" {
-		staticMap = make(map[string]*cacheEntry)
+		staticMap = make(map[string]CacheEntry)
 		for _, pair := range strings.Split(list, ",") {
 			mapping := strings.SplitN(pair, ":", 2)
 			key := mapping[0]
@@ -108,12 +170,13 @@ func NewNameSystem(r routing.ValueStore, opts ...Option) (NameSystem, error) {
 			if err != nil {
 				return nil, err
 			}
-			staticMap[ipns.NamespacePrefix+key] = &cacheEntry{val: value, ttl: 0}
+			staticMap[ipns.NamespacePrefix+key] = CacheEntry{Value: value}
 		}
 	}
 
 	ns := &namesys{
-		staticMap: staticMap,
+		staticMap:  staticMap,
+		refreshing: make(map[string]struct{}),
 	}
 
 	for _, opt := range opts {
@@ -172,39 +235,31 @@ func (ns *namesys) resolveOnceAsync(ctx context.Context, p path.Path, options Re
 		return out
 	}
 
-	if resolvedBase, ttl, lastMod, ok := ns.cacheGet(resolvablePath.String()); ok {
-		p, err = joinPaths(resolvedBase, p)
-		span.SetAttributes(attribute.Bool("CacheHit", true))
-		span.RecordError(err)
-		out <- AsyncResult{Path: p, TTL: ttl, LastMod: lastMod, Err: err}
+	if !options.Nocache {
+		if resolvedBase, ttl, lastMod, refresh, ok := ns.cacheGet(resolvablePath); ok {
+			if refresh {
+				if res, err := ns.selectResolver(resolvablePath); err == nil {
+					ns.cacheRefresh(resolvablePath, res, options)
+				}
+			}
+			p, err = joinPaths(resolvedBase, p)
+			span.SetAttributes(attribute.Bool("CacheHit", true), attribute.Bool("CacheRefresh", refresh))
+			span.RecordError(err)
+			out <- AsyncResult{Path: p, TTL: ttl, LastMod: lastMod, Err: err, cached: true}
+			close(out)
+			return out
+		}
+	}
+	span.SetAttributes(attribute.Bool("CacheHit", false))
+	if options.cacheOnly {
+		out <- AsyncResult{Err: errNotCached}
 		close(out)
 		return out
-	} else {
-		span.SetAttributes(attribute.Bool("CacheHit", false))
 	}
 
-	// Resolver selection:
-	// 	1. If it is an IPNS Name, resolve through IPNS.
-	// 	2. if it is a domain name, resolve through DNSLink.
-
-	var res resolver
-	if _, err := ipns.NameFromString(segments[1]); err == nil {
-		res = ns.ipnsResolver
-	} else if _, ok := dns.IsDomainName(segments[1]); ok {
-		res = ns.dnsResolver
-	} else {
-		// CIDs in IPNS are expected to have libp2p-key multicodec
-		// We ease the transition by returning a more meaningful error with a valid CID
-		ipnsCid, cidErr := cid.Decode(segments[1])
-		if cidErr == nil && ipnsCid.Version() == 1 && ipnsCid.Type() != cid.Libp2pKey {
-			fixedCid := cid.NewCidV1(cid.Libp2pKey, ipnsCid.Hash()).String()
-			codecErr := fmt.Errorf("peer ID represented as CIDv1 require libp2p-key multicodec: retry with /ipns/%s", fixedCid)
-			log.Debugf("RoutingResolver: could not convert public key hash %q to peer ID: %s\n", segments[1], codecErr)
-			out <- AsyncResult{Err: codecErr}
-		} else {
-			out <- AsyncResult{Err: fmt.Errorf("cannot resolve: %q", resolvablePath.String())}
-		}
-
+	res, err := ns.selectResolver(resolvablePath)
+	if err != nil {
+		out <- AsyncResult{Err: err}
 		close(out)
 		return out
 	}
@@ -217,8 +272,8 @@ func (ns *namesys) resolveOnceAsync(ctx context.Context, p path.Path, options Re
 			select {
 			case res, ok := <-resCh:
 				if !ok {
-					if best != (AsyncResult{}) {
-						ns.cacheSet(resolvablePath.String(), best.Path, best.TTL, best.LastMod)
+					if best.Path != nil {
+						ns.cacheSet(resolvablePath, best.Path, best.TTL, best.LastMod)
 					}
 					return
 				}
@@ -243,6 +298,30 @@ func (ns *namesys) resolveOnceAsync(ctx context.Context, p path.Path, options Re
 	return out
 }
 
+// selectResolver returns the resolver for the /ipns/<name> path p:
+//  1. If it is an IPNS Name, resolve through IPNS.
+//  2. if it is a domain name, resolve through DNSLink.
+func (ns *namesys) selectResolver(p path.Path) (resolver, error) {
+	name := p.Segments()[1]
+	if _, err := ipns.NameFromString(name); err == nil {
+		return ns.ipnsResolver, nil
+	}
+	if _, ok := dns.IsDomainName(name); ok {
+		return ns.dnsResolver, nil
+	}
+
+	// CIDs in IPNS are expected to have libp2p-key multicodec
+	// We ease the transition by returning a more meaningful error with a valid CID
+	ipnsCid, cidErr := cid.Decode(name)
+	if cidErr == nil && ipnsCid.Version() == 1 && ipnsCid.Type() != cid.Libp2pKey {
+		fixedCid := cid.NewCidV1(cid.Libp2pKey, ipnsCid.Hash()).String()
+		codecErr := fmt.Errorf("peer ID represented as CIDv1 require libp2p-key multicodec: retry with /ipns/%s", fixedCid)
+		log.Debugf("RoutingResolver: could not convert public key hash %q to peer ID: %s\n", name, codecErr)
+		return nil, codecErr
+	}
+	return nil, fmt.Errorf("cannot resolve: %q", p.String())
+}
+
 func emitOnceResult(ctx context.Context, outCh chan<- AsyncResult, r AsyncResult) {
 	select {
 	case outCh <- r:
@@ -261,6 +340,10 @@ func (ns *namesys) Publish(ctx context.Context, name ci.PrivKey, value path.Path
 	// calculated in this moment.
 	publishOpts := ProcessPublishOptions(options)
 	options = append(options, PublishWithEOL(publishOpts.EOL))
+	if err := validatePublish(name, value, publishOpts, false); err != nil {
+		span.RecordError(err)
+		return err
+	}
 
 	pid, err := peer.IDFromPrivateKey(name)
 	if err != nil {
@@ -269,13 +352,12 @@ func (ns *namesys) Publish(ctx context.Context, name ci.PrivKey, value path.Path
 	}
 
 	ipnsName := ipns.NameFromPeer(pid)
-	cacheKey := ipnsName.String()
 
 	span.SetAttributes(attribute.String("ID", pid.String()))
 	if err := ns.ipnsPublisher.Publish(ctx, name, value, options...); err != nil {
 		// Invalidate the cache. Publishing may _partially_ succeed but
 		// still return an error.
-		ns.cacheInvalidate(cacheKey)
+		ns.cacheInvalidate(ipnsName.AsPath())
 		span.RecordError(err)
 		return err
 	}
@@ -287,7 +369,7 @@ func (ns *namesys) Publish(ctx context.Context, name ci.PrivKey, value path.Path
 	if ttEOL := time.Until(publishOpts.EOL); ttEOL < ttl {
 		ttl = ttEOL
 	}
-	ns.cacheSet(cacheKey, value, ttl, time.Now())
+	ns.cacheSet(ipnsName.AsPath(), value, ttl, time.Now())
 	return nil
 }
 
@@ -1,51 +1,125 @@
 package namesys
 
 import (
+	"context"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/boxo/ipns"
 	"github.com/ipfs/boxo/path"
 )
 
-type cacheEntry struct {
-	val      path.Path     // is the value of this entry
-	ttl      time.Duration // is the ttl of this entry
-	lastMod  time.Time     // is the last time this entry was modified
-	cacheEOL time.Time     // is until when we keep this entry in cache
+// ResolveCache is the cache of the resolved names of a [NameSystem], set with
+// [WithResolveCache]. It can be shared between several name systems, for
+// example by gateway replicas.
+//
+// The keys include the kind of resolution, IPNS or DNSLink, and must be
+// treated as opaque. Implementations must be safe for concurrent use.
+type ResolveCache interface {
+	// Get returns the entry cached under key and when it expires. Expired
+	// entries may be returned, they are not used for resolution.
+	Get(key string) (entry CacheEntry, expiry time.Time, ok bool)
+
+	// Put caches entry under key for ttl.
+	Put(key string, entry CacheEntry, ttl time.Duration)
+
+	// Invalidate removes the entry cached under key, if any.
+	Invalidate(key string)
+}
+
+// CacheEntry is an entry of a [ResolveCache].
+type CacheEntry struct {
+	Value   path.Path     // is the value of this entry
+	TTL     time.Duration // is the ttl of this entry
+	LastMod time.Time     // is the last time this entry was modified
+}
+
+// lruCache is the default [ResolveCache], see [WithCache].
+type lruCache struct {
+	cache *lru.Cache[string, lruEntry]
+}
+
+type lruEntry struct {
+	entry  CacheEntry
+	expiry time.Time
+}
+
+func newLRUCache(size int) (*lruCache, error) {
+	cache, err := lru.New[string, lruEntry](size)
+	if err != nil {
+		return nil, err
+	}
+	return &lruCache{cache: cache}, nil
+}
+
+func (c *lruCache) Get(key string) (CacheEntry, time.Time, bool) {
+	e, ok := c.cache.Get(key)
+	return e.entry, e.expiry, ok
+}
+
+func (c *lruCache) Put(key string, entry CacheEntry, ttl time.Duration) {
+	// Add automatically evicts previous entry, so it works for updating.
+	c.cache.Add(key, lruEntry{entry: entry, expiry: time.Now().Add(ttl)})
+}
+
+func (c *lruCache) Invalidate(key string) {
+	c.cache.Remove(key)
 }
 
-func (ns *namesys) cacheGet(name string) (path.Path, time.Duration, time.Time, bool) {
+// cacheKey returns the cache key of the /ipns/<name> path p, which tells
+// IPNS names and DNSLink names apart.
+func cacheKey(p path.Path) string {
+	kind := HopDNSLink
+	if _, err := ipns.NameFromString(p.Segments()[1]); err == nil {
+		kind = HopIPNS
+	}
+	return kind + ":" + p.String()
+}
+
+// cacheGet returns the cached value of the /ipns/<name> path p. refresh is
+// true when the entry is close enough to its expiry to be resolved again.
+func (ns *namesys) cacheGet(p path.Path) (val path.Path, ttl time.Duration, lastMod time.Time, refresh bool, ok bool) {
 	// existence of optional mapping defined via IPFS_NS_MAP is checked first
 	if ns.staticMap != nil {
-		entry, ok := ns.staticMap[name]
+		entry, ok := ns.staticMap[p.String()]
 		if ok {
-			return entry.val, entry.ttl, entry.lastMod, true
+			return entry.Value, entry.TTL, entry.LastMod, false, true
 		}
 	}
 
 	if ns.cache == nil {
-		return nil, 0, time.Now(), false
+		return nil, 0, time.Now(), false, false
 	}
 
-	entry, ok := ns.cache.Get(name)
+	entry, expiry, ok := ns.cache.Get(cacheKey(p))
 	if !ok {
-		return nil, 0, time.Now(), false
+		return nil, 0, time.Now(), false, false
 	}
 
-	if time.Now().Before(entry.cacheEOL) {
-		return entry.val, entry.ttl, entry.lastMod, true
+	left := time.Until(expiry)
+	if left > 0 {
+		refresh = ns.cacheRefreshFraction > 0 && left <= time.Duration(float64(entry.TTL)*ns.cacheRefreshFraction)
+		return entry.Value, entry.TTL, entry.LastMod, refresh, true
 	}
 
 	// We do not delete the entry from the cache. Removals are handled by the
 	// backing cache system. It is useful to keep it since cacheSet can use
 	// previously existing values to heuristically update a cache entry.
-	return nil, 0, time.Now(), false
+	return nil, 0, time.Now(), false, false
 }
 
-func (ns *namesys) cacheSet(name string, val path.Path, ttl time.Duration, lastMod time.Time) {
+func (ns *namesys) cacheSet(p path.Path, val path.Path, ttl time.Duration, lastMod time.Time) {
 	if ns.cache == nil || ttl <= 0 {
 		return
 	}
 
+	if ns.minCacheTTL > 0 && ttl < ns.minCacheTTL {
+		ttl = ns.minCacheTTL
+	}
+	if ns.maxCacheTTL > 0 && ttl > ns.maxCacheTTL {
+		ttl = ns.maxCacheTTL
+	}
+
 	// Set the current date if there's no lastMod.
 	if lastMod.IsZero() {
 		lastMod = time.Now()
@@ -53,26 +127,67 @@ func (ns *namesys) cacheSet(name string, val path.Path, ttl time.Duration, lastM
 
 	// If there's an already cached version with the same path, but
 	// different lastMod date, keep the oldest.
-	entry, ok := ns.cache.Get(name)
-	if ok && entry.val.String() == val.String() {
-		if lastMod.After(entry.lastMod) {
-			lastMod = entry.lastMod
+	key := cacheKey(p)
+	entry, _, ok := ns.cache.Get(key)
+	if ok && entry.Value.String() == val.String() {
+		if lastMod.After(entry.LastMod) {
+			lastMod = entry.LastMod
 		}
 	}
 
-	// Add automatically evicts previous entry, so it works for updating.
-	ns.cache.Add(name, cacheEntry{
-		val:      val,
-		ttl:      ttl,
-		lastMod:  lastMod,
-		cacheEOL: time.Now().Add(ttl),
-	})
+	ns.cache.Put(key, CacheEntry{
+		Value:   val,
+		TTL:     ttl,
+		LastMod: lastMod,
+	}, ttl)
 }
 
-func (ns *namesys) cacheInvalidate(name string) {
+func (ns *namesys) cacheInvalidate(p path.Path) {
 	if ns.cache == nil {
 		return
 	}
 
-	ns.cache.Remove(name)
+	ns.cache.Invalidate(cacheKey(p))
+}
+
+// cacheRefresh resolves name again in the background and updates its cache
+// entry, unless a refresh of name is already running.
+func (ns *namesys) cacheRefresh(name path.Path, res resolver, options ResolveOptions) {
+	key := name.String()
+	ns.refreshLk.Lock()
+	if _, ok := ns.refreshing[key]; ok {
+		ns.refreshLk.Unlock()
+		return
+	}
+	if ns.refreshing == nil {
+		ns.refreshing = make(map[string]struct{})
+	}
+	ns.refreshing[key] = struct{}{}
+	ns.refreshLk.Unlock()
+
+	go func() {
+		defer func() {
+			ns.refreshLk.Lock()
+			delete(ns.refreshing, key)
+			ns.refreshLk.Unlock()
+		}()
+
+		// not bound to the request which found the entry close to expiry
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultResolverDhtTimeout)
+		defer cancel()
+		ctx, span := startSpan(ctx, "namesys.CacheRefresh")
+		defer span.End()
+
+		var best AsyncResult
+		for r := range res.resolveOnceAsync(ctx, name, options) {
+			if r.Err == nil {
+				best = r
+			}
+		}
+		if best.Path == nil {
+			log.Debugf("failed to refresh the cache entry of %s", key)
+			return
+		}
+		ns.cacheSet(name, best.Path, best.TTL, best.LastMod)
+	}()
 }
@@ -0,0 +1,325 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	offroute "github.com/ipfs/boxo/routing/offline"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	record "github.com/libp2p/go-libp2p-record"
+	ci "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// countingValueStore counts the record searches.
+type countingValueStore struct {
+	routing.ValueStore
+	searches atomic.Int64
+}
+
+func (vs *countingValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	vs.searches.Add(1)
+	return vs.ValueStore.SearchValue(ctx, key, opts...)
+}
+
+func newCountingValueStore() *countingValueStore {
+	dst := dssync.MutexWrap(ds.NewMapDatastore())
+	return &countingValueStore{ValueStore: offroute.NewOfflineRouter(dst, record.NamespacedValidator{
+		"ipns": ipns.Validator{},
+		"pk":   record.PublicKeyValidator{},
+	})}
+}
+
+// putRecord stores a record of sk for value with the given TTL and returns
+// the /ipns path of its name.
+func putRecord(t *testing.T, vs routing.ValueStore, sk ci.PrivKey, value string, seq uint64, ttl time.Duration) path.Path {
+	t.Helper()
+
+	v, err := path.NewPath(value)
+	require.NoError(t, err)
+	rec, err := ipns.NewRecord(sk, v, seq, time.Now().Add(time.Hour), ttl)
+	require.NoError(t, err)
+	data, err := ipns.MarshalRecord(rec)
+	require.NoError(t, err)
+
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+	require.NoError(t, vs.PutValue(context.Background(), string(name.RoutingKey()), data))
+	return name.AsPath()
+}
+
+func generateKey(t *testing.T) ci.PrivKey {
+	t.Helper()
+
+	sk, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+	require.NoError(t, err)
+	return sk
+}
+
+const (
+	cacheTestValue1 = "/ipfs/bafkreidgvpkjawlxz6sffxzwgooowe5yt7i6wsyg236mfoks77nywkptdq"
+	cacheTestValue2 = "/ipfs/bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+)
+
+func mustResolve(t *testing.T, ns NameSystem, p path.Path, options ...ResolveOption) string {
+	t.Helper()
+
+	res, err := ns.Resolve(context.Background(), p, options...)
+	require.NoError(t, err)
+	return res.Path.String()
+}
+
+func TestCacheRecordTTL(t *testing.T) {
+	vs := newCountingValueStore()
+	short := putRecord(t, vs, generateKey(t), cacheTestValue1, 1, 200*time.Millisecond)
+	long := putRecord(t, vs, generateKey(t), cacheTestValue1, 1, time.Hour)
+
+	ns, err := NewNameSystem(vs, WithCache(16), WithCacheRefresh(0))
+	require.NoError(t, err)
+
+	mustResolve(t, ns, short)
+	mustResolve(t, ns, long)
+	require.EqualValues(t, 2, vs.searches.Load())
+
+	mustResolve(t, ns, short)
+	mustResolve(t, ns, long)
+	require.EqualValues(t, 2, vs.searches.Load(), "both names are cached")
+
+	time.Sleep(300 * time.Millisecond)
+	mustResolve(t, ns, short)
+	mustResolve(t, ns, long)
+	require.EqualValues(t, 3, vs.searches.Load(), "only the short TTL expired")
+
+	mustResolve(t, ns, long, ResolveWithoutCache())
+	require.EqualValues(t, 4, vs.searches.Load(), "the cache is bypassed")
+}
+
+func TestCacheTTLBounds(t *testing.T) {
+	vs := newCountingValueStore()
+	short := putRecord(t, vs, generateKey(t), cacheTestValue1, 1, 10*time.Millisecond)
+	long := putRecord(t, vs, generateKey(t), cacheTestValue1, 1, time.Hour)
+
+	_, err := NewNameSystem(vs, WithCacheTTLBounds(time.Minute, time.Second))
+	require.Error(t, err)
+
+	ns, err := NewNameSystem(vs, WithCache(16), WithCacheTTLBounds(time.Second, 5*time.Second))
+	require.NoError(t, err)
+	mustResolve(t, ns, short)
+	mustResolve(t, ns, long)
+
+	cache := ns.(*namesys).cache
+	entry, _, ok := cache.Get(cacheKey(short))
+	require.True(t, ok)
+	require.Equal(t, time.Second, entry.TTL)
+	entry, _, ok = cache.Get(cacheKey(long))
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, entry.TTL)
+}
+
+func TestCacheRefresh(t *testing.T) {
+	vs := newCountingValueStore()
+	sk := generateKey(t)
+	name := putRecord(t, vs, sk, cacheTestValue1, 1, time.Second)
+
+	// the refreshes are opt-in
+	ns, err := NewNameSystem(vs, WithCache(16))
+	require.NoError(t, err)
+	require.Zero(t, ns.(*namesys).cacheRefreshFraction)
+
+	ns, err = NewNameSystem(vs, WithCache(16), WithCacheRefresh(0.3))
+	require.NoError(t, err)
+	require.Equal(t, cacheTestValue1, mustResolve(t, ns, name))
+
+	putRecord(t, vs, sk, cacheTestValue2, 2, time.Second)
+	require.Equal(t, cacheTestValue1, mustResolve(t, ns, name), "the entry is fresh")
+	require.EqualValues(t, 1, vs.searches.Load())
+
+	// Close to expiry the cached value is returned and refreshed in the
+	// background.
+	time.Sleep(750 * time.Millisecond)
+	require.Equal(t, cacheTestValue1, mustResolve(t, ns, name))
+	require.Eventually(t, func() bool {
+		entry, _, ok := ns.(*namesys).cache.Get(cacheKey(name))
+		return ok && entry.Value.String() == cacheTestValue2
+	}, 5*time.Second, 5*time.Millisecond)
+	require.EqualValues(t, 2, vs.searches.Load())
+
+	// The refreshed entry is served without waiting, past the first expiry.
+	time.Sleep(400 * time.Millisecond)
+	require.Equal(t, cacheTestValue2, mustResolve(t, ns, name))
+	require.EqualValues(t, 2, vs.searches.Load())
+}
+
+// ttlDNS answers TXT lookups with the TTL of its entries.
+type ttlDNS struct {
+	entries map[string]string
+	ttl     time.Duration
+}
+
+func (r *ttlDNS) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{IsNotFound: true}
+}
+
+func (r *ttlDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txt, _, err := r.LookupTXTWithTTL(ctx, name)
+	return txt, err
+}
+
+func (r *ttlDNS) LookupTXTWithTTL(ctx context.Context, name string) ([]string, time.Duration, error) {
+	txt, ok := r.entries[name]
+	if !ok {
+		return nil, 0, &net.DNSError{IsNotFound: true}
+	}
+	return []string{txt}, r.ttl, nil
+}
+
+func TestCacheDNSLinkTTL(t *testing.T) {
+	rslv := &ttlDNS{
+		entries: map[string]string{"_dnslink.example.com.": "dnslink=" + cacheTestValue1},
+		ttl:     3 * time.Minute,
+	}
+	ns, err := NewNameSystem(newCountingValueStore(), WithCache(16), WithDNSResolver(rslv))
+	require.NoError(t, err)
+
+	p, err := path.NewPath("/ipns/example.com")
+	require.NoError(t, err)
+	res, err := ns.Resolve(context.Background(), p)
+	require.NoError(t, err)
+	require.Equal(t, cacheTestValue1, res.Path.String())
+	require.Equal(t, 3*time.Minute, res.TTL)
+
+	entry, _, ok := ns.(*namesys).cache.Get(cacheKey(p))
+	require.True(t, ok)
+	require.Equal(t, 3*time.Minute, entry.TTL)
+}
+
+// recordingCache is a [ResolveCache] which records the calls made to it.
+type recordingCache struct {
+	mu      sync.Mutex
+	entries map[string]CacheEntry
+	expiry  map[string]time.Time
+	calls   []string
+	ttls    map[string]time.Duration
+}
+
+func newRecordingCache() *recordingCache {
+	return &recordingCache{
+		entries: make(map[string]CacheEntry),
+		expiry:  make(map[string]time.Time),
+		ttls:    make(map[string]time.Duration),
+	}
+}
+
+func (c *recordingCache) Get(key string) (CacheEntry, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, "get "+key)
+	entry, ok := c.entries[key]
+	return entry, c.expiry[key], ok
+}
+
+func (c *recordingCache) Put(key string, entry CacheEntry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, "put "+key)
+	c.entries[key] = entry
+	c.expiry[key] = time.Now().Add(ttl)
+	c.ttls[key] = ttl
+}
+
+func (c *recordingCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, "invalidate "+key)
+	delete(c.entries, key)
+	delete(c.expiry, key)
+}
+
+// takeCalls returns the calls recorded since the last call to takeCalls.
+func (c *recordingCache) takeCalls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls := c.calls
+	c.calls = nil
+	return calls
+}
+
+func TestResolveCache(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewNameSystem(newCountingValueStore(), WithResolveCache(nil))
+	require.Error(t, err)
+
+	vs := newCountingValueStore()
+	name := putRecord(t, vs, generateKey(t), cacheTestValue1, 1, 10*time.Minute)
+	rslv := &ttlDNS{
+		entries: map[string]string{"_dnslink.example.com.": "dnslink=" + cacheTestValue1},
+		ttl:     3 * time.Minute,
+	}
+	cache := newRecordingCache()
+	ns, err := NewNameSystem(vs, WithResolveCache(cache), WithDNSResolver(rslv), WithCacheRefresh(0))
+	require.NoError(t, err)
+
+	ipnsKey := "ipns:" + name.String()
+	dnsKey := "dnslink:/ipns/example.com"
+	dnsPath, err := path.NewPath("/ipns/example.com")
+	require.NoError(t, err)
+
+	t.Run("Read-through", func(t *testing.T) {
+		require.Equal(t, cacheTestValue1, mustResolve(t, ns, name))
+		require.Equal(t, []string{"get " + ipnsKey, "get " + ipnsKey, "put " + ipnsKey}, cache.takeCalls())
+		require.EqualValues(t, 1, vs.searches.Load())
+
+		require.Equal(t, cacheTestValue1, mustResolve(t, ns, name))
+		require.Equal(t, []string{"get " + ipnsKey}, cache.takeCalls())
+		require.EqualValues(t, 1, vs.searches.Load(), "the name is served from the cache")
+
+		mustResolve(t, ns, name, ResolveWithoutCache())
+		require.Equal(t, []string{"get " + ipnsKey, "put " + ipnsKey}, cache.takeCalls(), "the result is still written back")
+		require.EqualValues(t, 2, vs.searches.Load())
+	})
+
+	t.Run("Write-back with the TTL of the entries", func(t *testing.T) {
+		require.Equal(t, cacheTestValue1, mustResolve(t, ns, dnsPath))
+		require.Contains(t, cache.takeCalls(), "put "+dnsKey)
+		require.Equal(t, 3*time.Minute, cache.ttls[dnsKey])
+		require.Equal(t, 10*time.Minute, cache.ttls[ipnsKey])
+		require.Equal(t, cacheTestValue1, cache.entries[dnsKey].Value.String())
+	})
+
+	t.Run("Publish updates or invalidates the entry", func(t *testing.T) {
+		sk := generateKey(t)
+		pid, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+		key := cacheKey(ipns.NameFromPeer(pid).AsPath())
+
+		value, err := path.NewPath(cacheTestValue2)
+		require.NoError(t, err)
+		require.NoError(t, ns.Publish(context.Background(), sk, value, PublishWithTTL(time.Minute)))
+		require.Equal(t, []string{"get " + key, "put " + key}, cache.takeCalls())
+		require.Equal(t, cacheTestValue2, cache.entries[key].Value.String())
+		require.Equal(t, time.Minute, cache.ttls[key])
+
+		ns.(*namesys).ipnsPublisher = failingPublisher{}
+		require.Error(t, ns.Publish(context.Background(), sk, value))
+		require.Equal(t, []string{"invalidate " + key}, cache.takeCalls())
+		require.NotContains(t, cache.entries, key)
+	})
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(ctx context.Context, sk ci.PrivKey, value path.Path, options ...PublishOption) error {
+	return errors.New("publish failed")
+}
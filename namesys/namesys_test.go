@@ -13,6 +13,7 @@ import (
 	record "github.com/libp2p/go-libp2p-record"
 	ci "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/stretchr/testify/require"
 )
 
@@ -159,7 +160,160 @@ func TestPublishWithTTL(t *testing.T) {
 	err = ns.Publish(context.Background(), priv, p, PublishWithEOL(eol), PublishWithTTL(ttl))
 	require.NoError(t, err)
 
-	entry, ok := ns.(*namesys).cache.Get(ipns.NameFromPeer(pid).String())
+	_, expiry, ok := ns.(*namesys).cache.Get(cacheKey(ipns.NameFromPeer(pid).AsPath()))
 	require.True(t, ok)
-	require.LessOrEqual(t, entry.cacheEOL.Sub(eol), 10*time.Millisecond)
+	require.LessOrEqual(t, expiry.Sub(eol), 10*time.Millisecond)
+}
+
+func TestResolveTrace(t *testing.T) {
+	vs := newCountingValueStore()
+	k2 := putRecord(t, vs, generateKey(t), cacheTestValue1, 0, 2*time.Minute)
+	k1 := putRecord(t, vs, generateKey(t), k2.String(), 0, time.Minute)
+	rslv := &ttlDNS{
+		entries: map[string]string{"_dnslink.example.com.": "dnslink=" + k1.String()},
+		ttl:     3 * time.Minute,
+	}
+	ns, err := NewNameSystem(vs, WithDNSResolver(rslv))
+	require.NoError(t, err)
+
+	p, err := path.NewPath("/ipns/example.com")
+	require.NoError(t, err)
+	final, err := path.NewPath(cacheTestValue1)
+	require.NoError(t, err)
+	expected := []Hop{
+		{Name: p, Resolver: HopDNSLink, Value: k1, TTL: 3 * time.Minute},
+		{Name: k1, Resolver: HopIPNS, Value: k2, TTL: time.Minute},
+		{Name: k2, Resolver: HopIPNS, Value: final, TTL: 2 * time.Minute},
+	}
+
+	res, err := ns.Resolve(context.Background(), p)
+	require.NoError(t, err)
+	require.Equal(t, cacheTestValue1, res.Path.String())
+	require.Equal(t, expected, res.Trace)
+
+	var hops []Hop
+	var results []AsyncResult
+	for res := range ns.ResolveAsync(context.Background(), p, ResolveWithHopFunc(func(h Hop) { hops = append(hops, h) })) {
+		results = append(results, res)
+	}
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, expected, results[0].Trace)
+	require.Equal(t, expected, hops)
+}
+
+func TestResolveRecursionError(t *testing.T) {
+	rslv := &ttlDNS{
+		entries: map[string]string{
+			"_dnslink.a.example.com.": "dnslink=/ipns/b.example.com",
+			"_dnslink.b.example.com.": "dnslink=/ipns/c.example.com",
+			"_dnslink.c.example.com.": "dnslink=" + cacheTestValue1,
+		},
+	}
+	ns, err := NewNameSystem(newCountingValueStore(), WithDNSResolver(rslv))
+	require.NoError(t, err)
+
+	p, err := path.NewPath("/ipns/a.example.com")
+	require.NoError(t, err)
+	res, err := ns.Resolve(context.Background(), p, ResolveWithDepth(2))
+	require.ErrorIs(t, err, ErrResolveRecursion)
+	require.Equal(t, "/ipns/c.example.com", res.Path.String())
+
+	var rerr *RecursionError
+	require.ErrorAs(t, err, &rerr)
+	require.Len(t, rerr.Trace, 2)
+	require.Equal(t, res.Trace, rerr.Trace)
+	require.Equal(t, ErrResolveRecursion.Error()+": /ipns/a.example.com -> /ipns/b.example.com -> /ipns/c.example.com", err.Error())
+
+	res, err = ns.Resolve(context.Background(), p, ResolveWithDepth(3))
+	require.NoError(t, err)
+	require.Equal(t, cacheTestValue1, res.Path.String())
+}
+
+// blockingValueStore never finds the records, until the search is canceled.
+type blockingValueStore struct {
+	routing.ValueStore
+	searching chan struct{}
+}
+
+func (vs *blockingValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	close(vs.searching)
+	out := make(chan []byte)
+	go func() {
+		<-ctx.Done()
+		close(out)
+	}()
+	return out, nil
+}
+
+func TestResolveIntermediateResults(t *testing.T) {
+	vs := newCountingValueStore()
+	k := putRecord(t, vs, generateKey(t), cacheTestValue1, 0, time.Minute)
+	rslv := &ttlDNS{
+		entries: map[string]string{"_dnslink.example.com.": "dnslink=" + k.String()},
+		ttl:     3 * time.Minute,
+	}
+	ns, err := NewNameSystem(vs, WithCache(16), WithDNSResolver(rslv))
+	require.NoError(t, err)
+
+	p, err := path.NewPath("/ipns/example.com")
+	require.NoError(t, err)
+	final, err := path.NewPath(cacheTestValue1)
+	require.NoError(t, err)
+	dnslinkHop := Hop{Name: p, Resolver: HopDNSLink, Value: k, TTL: 3 * time.Minute}
+	ipnsHop := Hop{Name: k, Resolver: HopIPNS, Value: final, TTL: time.Minute}
+
+	collect := func() []AsyncResult {
+		var results []AsyncResult
+		for res := range ns.ResolveAsync(context.Background(), p, ResolveWithIntermediateResults()) {
+			require.NoError(t, res.Err)
+			res.LastMod = time.Time{}
+			results = append(results, res)
+		}
+		return results
+	}
+
+	require.Equal(t, []AsyncResult{
+		{Path: k, TTL: 3 * time.Minute, Trace: []Hop{dnslinkHop}, Intermediate: true},
+		{Path: final, TTL: time.Minute, Trace: []Hop{dnslinkHop, ipnsHop}},
+	}, collect())
+
+	// Resolved again from the cache.
+	dnslinkHop.Cached, ipnsHop.Cached = true, true
+	require.Equal(t, []AsyncResult{
+		{Path: k, TTL: 3 * time.Minute, Trace: []Hop{dnslinkHop}, Intermediate: true},
+		{Path: final, TTL: time.Minute, Trace: []Hop{dnslinkHop, ipnsHop}},
+	}, collect())
+
+	// Resolve only returns the final value.
+	res, err := ns.Resolve(context.Background(), p, ResolveWithIntermediateResults())
+	require.NoError(t, err)
+	require.Equal(t, final, res.Path)
+}
+
+func TestResolveIntermediateResultsCancel(t *testing.T) {
+	vs := &blockingValueStore{ValueStore: newCountingValueStore(), searching: make(chan struct{})}
+	k := putRecord(t, vs.ValueStore, generateKey(t), cacheTestValue1, 0, time.Minute)
+	rslv := &ttlDNS{entries: map[string]string{"_dnslink.example.com.": "dnslink=" + k.String()}}
+	ns, err := NewNameSystem(vs, WithDNSResolver(rslv))
+	require.NoError(t, err)
+
+	p, err := path.NewPath("/ipns/example.com")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := ns.ResolveAsync(ctx, p, ResolveWithIntermediateResults())
+
+	res := <-results
+	require.True(t, res.Intermediate)
+	require.Equal(t, k, res.Path)
+
+	<-vs.searching
+	cancel()
+	require.Eventually(t, func() bool {
+		for res := range results {
+			require.Error(t, res.Err)
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
 }
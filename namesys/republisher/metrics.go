@@ -0,0 +1,47 @@
+package republisher
+
+import (
+	"errors"
+
+	"github.com/ipfs/boxo/internal/promutil"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics is nil when WithMetrics is not used, its methods are no-ops then.
+type metrics struct {
+	ok       prometheus.Counter
+	failed   prometheus.Counter
+	noRecord prometheus.Counter
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	republishes := promutil.Register(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "namesys_republisher",
+			Name:      "republishes_total",
+			Help:      "The number of IPNS records republished, by outcome.",
+		},
+		[]string{"outcome"},
+	), log)
+
+	return &metrics{
+		ok:       republishes.WithLabelValues("ok"),
+		failed:   republishes.WithLabelValues("error"),
+		noRecord: republishes.WithLabelValues("no-record"),
+	}
+}
+
+func (m *metrics) republished(err error) {
+	if m == nil {
+		return
+	}
+	switch {
+	case err == nil:
+		m.ok.Inc()
+	case errors.Is(err, errNoEntry):
+		m.noRecord.Inc()
+	default:
+		m.failed.Inc()
+	}
+}
@@ -3,10 +3,16 @@
 package republisher
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"math/rand"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
+
 	"github.com/ipfs/boxo/keystore"
 	"github.com/ipfs/boxo/namesys"
 	"go.opentelemetry.io/otel"
@@ -20,6 +26,8 @@ import (
 	gpctx "github.com/jbenet/goprocess/context"
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
@@ -39,10 +47,17 @@ const (
 
 	// DefaultRecordLifetime is the default lifetime for IPNS records
 	DefaultRecordLifetime = ipns.DefaultRecordLifetime
+
+	// DefaultJitter is the default [Republisher.Jitter].
+	DefaultJitter = time.Minute * 10
 )
 
 // Republisher facilitates the regular publishing of all the IPNS records
 // associated to keys in a [keystore.Keystore].
+//
+// Each key is scheduled on its own: a key whose republish fails is retried
+// with an exponential backoff, starting at [FailureRetryInterval] and up to
+// Interval, without delaying the other keys.
 type Republisher struct {
 	ns   namesys.Publisher
 	ds   ds.Datastore
@@ -53,79 +68,306 @@ type Republisher struct {
 
 	// how long records that are republished should be valid for
 	RecordLifetime time.Duration
+
+	// Jitter is the maximum random delay added to the schedule of each key,
+	// so that many keys are not republished all at once. It is capped to a
+	// quarter of Interval.
+	Jitter time.Duration
+
+	keys    []ic.PrivKey
+	routing routing.ValueStore
+	metrics *metrics
+	clock   clock.Clock
+	randInt func(n int64) int64
+
+	statusLk sync.Mutex
+	status   map[peer.ID]*KeyStatus
+	// republished is notified when a key is rescheduled
+	republished chan struct{}
+	wg          sync.WaitGroup
+}
+
+// Option is an option for [NewRepublisher].
+type Option func(*Republisher)
+
+// WithKeys republishes the records of keys too, in addition to the ones of
+// the keystore.
+func WithKeys(keys ...ic.PrivKey) Option {
+	return func(rp *Republisher) {
+		rp.keys = append(rp.keys, keys...)
+	}
+}
+
+// WithRouting makes the [Republisher] read the current record of each key
+// from vs before republishing it, and use it when it is better than the
+// local one, e.g. because it was published by another node with the same
+// key. This preserves the sequence number of the records. The records which
+// are not valid for the key are ignored.
+func WithRouting(vs routing.ValueStore) Option {
+	return func(rp *Republisher) {
+		rp.routing = vs
+	}
+}
+
+// WithMetrics registers the metrics of the [Republisher] with reg:
+// ipfs_namesys_republisher_republishes_total counts the republishes by
+// outcome: "ok", "error", or "no-record" for the keys without a record to
+// republish.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(rp *Republisher) {
+		rp.metrics = newMetrics(reg)
+	}
 }
 
 // NewRepublisher creates a new [Republisher] from the given options.
-func NewRepublisher(ns namesys.Publisher, ds ds.Datastore, self ic.PrivKey, ks keystore.Keystore) *Republisher {
-	return &Republisher{
+func NewRepublisher(ns namesys.Publisher, ds ds.Datastore, self ic.PrivKey, ks keystore.Keystore, opts ...Option) *Republisher {
+	rp := &Republisher{
 		ns:             ns,
 		ds:             ds,
 		self:           self,
 		ks:             ks,
 		Interval:       DefaultRebroadcastInterval,
 		RecordLifetime: DefaultRecordLifetime,
+		Jitter:         DefaultJitter,
+		clock:          clock.New(),
+		randInt:        rand.Int63n,
+		status:         make(map[peer.ID]*KeyStatus),
+		republished:    make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(rp)
 	}
+	return rp
+}
+
+// KeyStatus is the republishing status of a key, as returned by
+// [Republisher.Status].
+type KeyStatus struct {
+	Name ipns.Name
+
+	// LastSuccess is when the record was last republished, zero if never.
+	LastSuccess time.Time
+
+	// LastError is the error of the last failed republish, at LastErrorTime.
+	LastError     error
+	LastErrorTime time.Time
+
+	// Failures is the number of republishes which failed since the last
+	// success.
+	Failures int
+
+	// Next is when the record is republished next.
+	Next time.Time
+
+	priv    ic.PrivKey
+	running bool
+}
+
+// Status returns the status of the keys being republished, sorted by name.
+func (rp *Republisher) Status() []KeyStatus {
+	rp.statusLk.Lock()
+	defer rp.statusLk.Unlock()
+
+	status := make([]KeyStatus, 0, len(rp.status))
+	for _, st := range rp.status {
+		status = append(status, *st)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name.String() < status[j].Name.String()
+	})
+	return status
 }
 
 // Run starts the republisher facility. It can be stopped by stopping the provided proc.
 func (rp *Republisher) Run(proc goprocess.Process) {
-	timer := time.NewTimer(InitialRebroadcastDelay)
-	defer timer.Stop()
+	ctx, cancel := context.WithCancel(gpctx.OnClosingContext(proc))
+	defer rp.wg.Wait()
+	defer cancel()
+
+	delay := InitialRebroadcastDelay
 	if rp.Interval < InitialRebroadcastDelay {
-		timer.Reset(rp.Interval)
+		delay = rp.Interval
 	}
+	first := rp.clock.Now().Add(delay)
+	timer := rp.clock.Timer(delay)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-timer.C:
-			timer.Reset(rp.Interval)
-			err := rp.republishEntries(proc)
-			if err != nil {
-				log.Info("republisher failed to republish: ", err)
-				if FailureRetryInterval < rp.Interval {
-					timer.Reset(FailureRetryInterval)
+			if err := rp.updateKeys(first); err != nil {
+				log.Info("republisher failed to list the keys: ", err)
+			}
+			rp.republishDue(ctx)
+		case <-rp.republished:
+			// a key was rescheduled
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
 			}
 		case <-proc.Closing():
 			return
 		}
+		timer.Reset(rp.nextDue().Sub(rp.clock.Now()))
 	}
 }
 
-func (rp *Republisher) republishEntries(p goprocess.Process) error {
-	ctx, cancel := context.WithCancel(gpctx.OnClosingContext(p))
-	defer cancel()
-	ctx, span := startSpan(ctx, "Republisher.RepublishEntries")
-	defer span.End()
-
-	// TODO: Use rp.ipns.ListPublished(). We can't currently *do* that
-	// because:
-	// 1. There's no way to get keys from the keystore by ID.
-	// 2. We don't actually have access to the IPNS publisher.
-	err := rp.republishEntry(ctx, rp.self)
+// updateKeys updates the keys to republish, scheduling the new ones from
+// start.
+func (rp *Republisher) updateKeys(start time.Time) error {
+	keys, err := rp.listKeys()
 	if err != nil {
 		return err
 	}
 
+	now := rp.clock.Now()
+	if start.Before(now) {
+		start = now
+	}
+
+	rp.statusLk.Lock()
+	defer rp.statusLk.Unlock()
+
+	current := make(map[peer.ID]struct{}, len(keys))
+	for _, priv := range keys {
+		id, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			log.Info("republisher failed to get the peer ID of a key: ", err)
+			continue
+		}
+		current[id] = struct{}{}
+		if _, ok := rp.status[id]; !ok {
+			rp.status[id] = &KeyStatus{
+				Name: ipns.NameFromPeer(id),
+				Next: start.Add(rp.jitter()),
+				priv: priv,
+			}
+		}
+	}
+	for id := range rp.status {
+		if _, ok := current[id]; !ok {
+			delete(rp.status, id)
+		}
+	}
+	return nil
+}
+
+func (rp *Republisher) listKeys() ([]ic.PrivKey, error) {
+	var keys []ic.PrivKey
+	if rp.self != nil {
+		keys = append(keys, rp.self)
+	}
+	keys = append(keys, rp.keys...)
+
 	if rp.ks != nil {
+		// TODO: Use rp.ipns.ListPublished(). We can't currently *do* that
+		// because:
+		// 1. There's no way to get keys from the keystore by ID.
+		// 2. We don't actually have access to the IPNS publisher.
 		keyNames, err := rp.ks.List()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for _, name := range keyNames {
 			priv, err := rp.ks.Get(name)
 			if err != nil {
-				return err
-			}
-			err = rp.republishEntry(ctx, priv)
-			if err != nil {
-				return err
+				return nil, err
 			}
+			keys = append(keys, priv)
+		}
+	}
+	return keys, nil
+}
 
+// republishDue starts republishing the keys which are due, each in its own
+// goroutine, so that a slow key does not delay the others.
+func (rp *Republisher) republishDue(ctx context.Context) {
+	now := rp.clock.Now()
+	rp.statusLk.Lock()
+	defer rp.statusLk.Unlock()
+	for _, st := range rp.status {
+		if st.running || st.Next.After(now) {
+			continue
 		}
+		st.running = true
+		rp.wg.Add(1)
+		go func(st *KeyStatus) {
+			defer rp.wg.Done()
+			rp.republishKey(ctx, st)
+		}(st)
 	}
+}
 
-	return nil
+// republishKey republishes the key of st and reschedules it.
+func (rp *Republisher) republishKey(ctx context.Context, st *KeyStatus) {
+	err := rp.republishEntry(ctx, st.priv)
+	rp.metrics.republished(err)
+	if err != nil && !errors.Is(err, errNoEntry) {
+		log.Infof("republisher failed to republish %s: %s", st.Name, err)
+	}
+
+	now := rp.clock.Now()
+	rp.statusLk.Lock()
+	st.running = false
+	switch {
+	case err == nil, errors.Is(err, errNoEntry):
+		if err == nil {
+			st.LastSuccess = now
+		}
+		st.Failures = 0
+		st.Next = now.Add(rp.Interval + rp.jitter())
+	default:
+		st.LastError = err
+		st.LastErrorTime = now
+		st.Failures++
+		st.Next = now.Add(rp.backoff(st.Failures))
+	}
+	rp.statusLk.Unlock()
+
+	select {
+	case rp.republished <- struct{}{}:
+	default:
+	}
+}
+
+// nextDue returns when the next key not being republished is due. It is
+// within Interval, so that new keys are found.
+func (rp *Republisher) nextDue() time.Time {
+	next := rp.clock.Now().Add(rp.Interval)
+	rp.statusLk.Lock()
+	defer rp.statusLk.Unlock()
+	for _, st := range rp.status {
+		if !st.running && st.Next.Before(next) {
+			next = st.Next
+		}
+	}
+	return next
+}
+
+func (rp *Republisher) jitter() time.Duration {
+	jitter := rp.Jitter
+	if jitter > rp.Interval/4 {
+		jitter = rp.Interval / 4
+	}
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rp.randInt(int64(jitter)))
+}
+
+// backoff returns the delay before retrying a key after failures consecutive
+// failures.
+func (rp *Republisher) backoff(failures int) time.Duration {
+	delay := FailureRetryInterval
+	for i := 1; i < failures && delay < rp.Interval; i++ {
+		delay *= 2
+	}
+	if delay > rp.Interval {
+		delay = rp.Interval
+	}
+	return delay
 }
 
 func (rp *Republisher) republishEntry(ctx context.Context, priv ic.PrivKey) error {
@@ -144,7 +386,7 @@ func (rp *Republisher) republishEntry(ctx context.Context, priv ic.PrivKey) erro
 	if err != nil {
 		if err == errNoEntry {
 			span.SetAttributes(attribute.Bool("NoEntry", true))
-			return nil
+			return err
 		}
 		span.RecordError(err)
 		return err
@@ -163,29 +405,75 @@ func (rp *Republisher) republishEntry(ctx context.Context, priv ic.PrivKey) erro
 	}
 
 	// update record with same sequence number
-	eol := time.Now().Add(rp.RecordLifetime)
+	eol := rp.clock.Now().Add(rp.RecordLifetime)
 	if prevEol.After(eol) {
 		eol = prevEol
 	}
-	err = rp.ns.Publish(ctx, priv, p, namesys.PublishWithEOL(eol))
+	// keep the choices of the previous publish
+	opts := []namesys.PublishOption{
+		namesys.PublishWithEOL(eol),
+		namesys.PublishWithV1Compatibility(rec.V1Compatible()),
+	}
+	if ttl, err := rec.TTL(); err == nil {
+		opts = append(opts, namesys.PublishWithTTL(ttl))
+	}
+	err = rp.ns.Publish(ctx, priv, p, opts...)
 	span.RecordError(err)
 	return err
 }
 
+// getLastIPNSRecord returns the record of name from the datastore or, with
+// [WithRouting], the routing when its record is better. That record is then
+// stored in the datastore, so that it is republished with its sequence
+// number.
 func (rp *Republisher) getLastIPNSRecord(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
-	// Look for it locally only
+	// Look for it locally
 	val, err := rp.ds.Get(ctx, namesys.IpnsDsKey(name))
 	switch err {
 	case nil:
 	case ds.ErrNotFound:
-		return nil, errNoEntry
+		val = nil
 	default:
 		return nil, err
 	}
 
+	if rp.routing != nil {
+		remote, err := rp.routing.GetValue(ctx, string(name.RoutingKey()))
+		if err != nil {
+			log.Debugf("republisher could not get the record of %s from the routing: %s", name, err)
+		} else if err := validateRecord(name, remote); err != nil {
+			// never republish a record not signed by the key, whatever
+			// its sequence number
+			log.Infof("republisher ignored an invalid record of %s from the routing: %s", name, err)
+		} else if val == nil || rp.better(name, remote, val) {
+			if err := rp.ds.Put(ctx, namesys.IpnsDsKey(name), remote); err != nil {
+				return nil, err
+			}
+			val = remote
+		}
+	}
+
+	if val == nil {
+		return nil, errNoEntry
+	}
 	return ipns.UnmarshalRecord(val)
 }
 
+// validateRecord validates the marshaled record of name.
+func validateRecord(name ipns.Name, data []byte) error {
+	rec, err := ipns.UnmarshalRecord(data)
+	if err != nil {
+		return err
+	}
+	return ipns.ValidateWithName(rec, name)
+}
+
+// better tells whether the record a of name is better than b.
+func (rp *Republisher) better(name ipns.Name, a, b []byte) bool {
+	i, err := ipns.Validator{}.Select(string(name.RoutingKey()), [][]byte{b, a})
+	return err == nil && i == 1 && !bytes.Equal(a, b)
+}
+
 var tracer = otel.Tracer("boxo/namesys/republisher")
 
 func startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
@@ -9,6 +9,7 @@ import (
 	"github.com/jbenet/goprocess"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	host "github.com/libp2p/go-libp2p/core/host"
 	peer "github.com/libp2p/go-libp2p/core/peer"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/ipfs/boxo/ipns"
 	"github.com/ipfs/boxo/path"
+	offroute "github.com/ipfs/boxo/routing/offline"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 
@@ -199,6 +201,50 @@ func TestLongEOLRepublish(t *testing.T) {
 	require.Equal(t, expiration.UTC(), finalEol.UTC())
 }
 
+func TestRepublishKeepsPublishOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	priv, _, err := ic.GenerateKeyPair(ic.Ed25519, 0)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	p, err := path.NewPath("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	require.NoError(t, err)
+
+	rp := namesys.NewIPNSPublisher(offroute.NewOfflineRouter(dstore, record.NamespacedValidator{
+		"ipns": ipns.Validator{},
+		"pk":   record.PublicKeyValidator{},
+	}), dstore)
+	err = rp.Publish(ctx, priv, p, namesys.PublishWithV1Compatibility(false), namesys.PublishWithTTL(3*time.Minute))
+	require.NoError(t, err)
+	rec, err := getLastIPNSRecord(ctx, dstore, ipns.NameFromPeer(id))
+	require.NoError(t, err)
+	eol, err := rec.Validity()
+	require.NoError(t, err)
+
+	repub := NewRepublisher(rp, dstore, priv, keystore.NewMemKeystore())
+	repub.Interval = time.Millisecond * 100
+	repub.RecordLifetime = ipns.DefaultRecordLifetime * 2
+
+	proc := goprocess.Go(repub.Run)
+	defer proc.Close()
+
+	require.Eventually(t, func() bool {
+		rec, err = getLastIPNSRecord(ctx, dstore, ipns.NameFromPeer(id))
+		require.NoError(t, err)
+		newEol, err := rec.Validity()
+		require.NoError(t, err)
+		return newEol.After(eol)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.False(t, rec.V1Compatible())
+	ttl, err := rec.TTL()
+	require.NoError(t, err)
+	require.Equal(t, 3*time.Minute, ttl)
+}
+
 func getLastIPNSRecord(ctx context.Context, dstore ds.Datastore, name ipns.Name) (*ipns.Record, error) {
 	// Look for it locally only
 	val, err := dstore.Get(ctx, namesys.IpnsDsKey(name))
@@ -0,0 +1,365 @@
+package republisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/boxo/internal/promutil"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	offroute "github.com/ipfs/boxo/routing/offline"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	record "github.com/libp2p/go-libp2p-record"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var testPath path.Path
+
+func init() {
+	var err error
+	testPath, err = path.NewPath("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	if err != nil {
+		panic(err)
+	}
+}
+
+// failingPublisher fails to publish the records of fail.
+type failingPublisher struct {
+	namesys.Publisher
+	fail peer.ID
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, sk ic.PrivKey, value path.Path, options ...namesys.PublishOption) error {
+	if id, _ := peer.IDFromPrivateKey(sk); id == p.fail {
+		return errors.New("publish failed")
+	}
+	return p.Publisher.Publish(ctx, sk, value, options...)
+}
+
+type testEnv struct {
+	dstore ds.Datastore
+	pub    namesys.Publisher
+	keys   []ic.PrivKey
+}
+
+// newTestEnv publishes a record for n keys.
+func newTestEnv(t *testing.T, n int) *testEnv {
+	t.Helper()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	env := &testEnv{
+		dstore: dstore,
+		pub: namesys.NewIPNSPublisher(offroute.NewOfflineRouter(dstore, record.NamespacedValidator{
+			"ipns": ipns.Validator{},
+			"pk":   record.PublicKeyValidator{},
+		}), dstore),
+	}
+	for i := 0; i < n; i++ {
+		sk, _, err := ic.GenerateKeyPair(ic.Ed25519, 0)
+		require.NoError(t, err)
+		require.NoError(t, env.pub.Publish(context.Background(), sk, testPath))
+		env.keys = append(env.keys, sk)
+	}
+	return env
+}
+
+func (env *testEnv) lastRecord(t *testing.T, sk ic.PrivKey) *ipns.Record {
+	t.Helper()
+
+	id, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	val, err := env.dstore.Get(context.Background(), namesys.IpnsDsKey(ipns.NameFromPeer(id)))
+	require.NoError(t, err)
+	rec, err := ipns.UnmarshalRecord(val)
+	require.NoError(t, err)
+	return rec
+}
+
+func (env *testEnv) newRepublisher(pub namesys.Publisher, mock *clock.Mock, opts ...Option) *Republisher {
+	rp := NewRepublisher(pub, env.dstore, nil, nil, append([]Option{WithKeys(env.keys...)}, opts...)...)
+	rp.Interval = time.Hour
+	rp.clock = mock
+	return rp
+}
+
+// republishDue republishes the keys which are due, waits for them and
+// returns when the next key is due.
+func republishDue(rp *Republisher, ctx context.Context) time.Time {
+	rp.republishDue(ctx)
+	rp.wg.Wait()
+	return rp.nextDue()
+}
+
+func TestRepublisherJitter(t *testing.T) {
+	env := newTestEnv(t, 3)
+	mock := clock.NewMock()
+	rp := env.newRepublisher(env.pub, mock)
+	rp.Jitter = 10 * time.Minute
+	var calls int64
+	rp.randInt = func(n int64) int64 {
+		defer func() { calls++ }()
+		return []int64{0, n / 2, n - 1}[calls%3]
+	}
+	ctx := context.Background()
+
+	start := mock.Now()
+	require.NoError(t, rp.updateKeys(start))
+	var nexts []time.Time
+	for _, st := range rp.Status() {
+		nexts = append(nexts, st.Next)
+	}
+	require.ElementsMatch(t, []time.Time{start, start.Add(5 * time.Minute), start.Add(10*time.Minute - 1)}, nexts)
+
+	// The keys are republished one at a time, as they are due.
+	require.Equal(t, start.Add(5*time.Minute), republishDue(rp, ctx))
+	republished := func() (n int) {
+		for _, st := range rp.Status() {
+			if !st.LastSuccess.IsZero() {
+				n++
+			}
+		}
+		return n
+	}
+	require.Equal(t, 1, republished())
+
+	mock.Add(5 * time.Minute)
+	require.Equal(t, start.Add(10*time.Minute-1), republishDue(rp, ctx))
+	require.Equal(t, 2, republished())
+
+	mock.Add(5 * time.Minute)
+	republishDue(rp, ctx)
+	require.Equal(t, 3, republished())
+	for _, st := range rp.Status() {
+		delay := st.Next.Sub(st.LastSuccess)
+		require.GreaterOrEqual(t, delay, time.Hour)
+		require.Less(t, delay, time.Hour+10*time.Minute)
+	}
+}
+
+func TestRepublisherErrorIsolation(t *testing.T) {
+	env := newTestEnv(t, 3)
+	failing, err := peer.IDFromPrivateKey(env.keys[1])
+	require.NoError(t, err)
+	mock := clock.NewMock()
+	reg := prometheus.NewRegistry()
+	rp := env.newRepublisher(&failingPublisher{Publisher: env.pub, fail: failing}, mock, WithMetrics(reg))
+	rp.Jitter = 0
+	ctx := context.Background()
+
+	status := func(id peer.ID) KeyStatus {
+		for _, st := range rp.Status() {
+			if st.Name.Peer() == id {
+				return st
+			}
+		}
+		return KeyStatus{}
+	}
+
+	start := mock.Now()
+	require.NoError(t, rp.updateKeys(start))
+	require.Equal(t, start.Add(FailureRetryInterval), republishDue(rp, ctx))
+	for i, sk := range env.keys {
+		id, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+		st := status(id)
+		if i == 1 {
+			require.Error(t, st.LastError)
+			require.Equal(t, 1, st.Failures)
+			require.True(t, st.LastSuccess.IsZero())
+			require.Equal(t, start.Add(FailureRetryInterval), st.Next)
+		} else {
+			require.NoError(t, st.LastError)
+			require.Equal(t, start, st.LastSuccess)
+			require.Equal(t, start.Add(time.Hour), st.Next)
+		}
+	}
+
+	// The failing key is retried with a backoff, the others are left alone.
+	mock.Add(FailureRetryInterval)
+	require.Equal(t, mock.Now().Add(2*FailureRetryInterval), republishDue(rp, ctx))
+	st := status(failing)
+	require.Equal(t, 2, st.Failures)
+	require.Equal(t, mock.Now(), st.LastErrorTime)
+
+	republishes := func(outcome string) float64 {
+		vec := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "namesys_republisher",
+			Name:      "republishes_total",
+			Help:      "The number of IPNS records republished, by outcome.",
+		}, []string{"outcome"})
+		return testutil.ToFloat64(promutil.Register(reg, vec, log).WithLabelValues(outcome))
+	}
+	require.Equal(t, float64(2), republishes("ok"))
+	require.Equal(t, float64(2), republishes("error"))
+}
+
+func TestRepublisherRoutingRecord(t *testing.T) {
+	env := newTestEnv(t, 1)
+	sk := env.keys[0]
+	id, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(id)
+
+	// Another node published a newer record with the same key.
+	other, err := ipns.NewRecord(sk, testPath, 7, time.Now().Add(time.Hour), 3*time.Minute)
+	require.NoError(t, err)
+	data, err := ipns.MarshalRecord(other)
+	require.NoError(t, err)
+	vs := offroute.NewOfflineRouter(dssync.MutexWrap(ds.NewMapDatastore()), record.NamespacedValidator{
+		"ipns": ipns.Validator{},
+		"pk":   record.PublicKeyValidator{},
+	})
+	require.NoError(t, vs.PutValue(context.Background(), string(name.RoutingKey()), data))
+
+	mock := clock.NewMock()
+	rp := env.newRepublisher(env.pub, mock, WithRouting(vs))
+	rp.Jitter = 0
+	require.NoError(t, rp.updateKeys(mock.Now()))
+	republishDue(rp, context.Background())
+	require.False(t, rp.Status()[0].LastSuccess.IsZero())
+
+	rec := env.lastRecord(t, sk)
+	seq, err := rec.Sequence()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), seq)
+	ttl, err := rec.TTL()
+	require.NoError(t, err)
+	require.Equal(t, 3*time.Minute, ttl)
+}
+
+// blockingPublisher blocks the publishes of the records of block until
+// release is closed.
+type blockingPublisher struct {
+	namesys.Publisher
+	block   peer.ID
+	release chan struct{}
+}
+
+func (p *blockingPublisher) Publish(ctx context.Context, sk ic.PrivKey, value path.Path, options ...namesys.PublishOption) error {
+	if id, _ := peer.IDFromPrivateKey(sk); id == p.block {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return p.Publisher.Publish(ctx, sk, value, options...)
+}
+
+func TestRepublisherSlowKey(t *testing.T) {
+	env := newTestEnv(t, 3)
+	slow, err := peer.IDFromPrivateKey(env.keys[0])
+	require.NoError(t, err)
+	pub := &blockingPublisher{Publisher: env.pub, block: slow, release: make(chan struct{})}
+	mock := clock.NewMock()
+	rp := env.newRepublisher(pub, mock)
+	rp.Jitter = 0
+
+	start := mock.Now()
+	require.NoError(t, rp.updateKeys(start))
+	rp.republishDue(context.Background())
+
+	// the other keys are republished while the slow one is blocked
+	require.Eventually(t, func() bool {
+		var done int
+		for _, st := range rp.Status() {
+			if !st.LastSuccess.IsZero() {
+				done++
+			}
+		}
+		return done == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, start.Add(time.Hour), rp.nextDue())
+
+	close(pub.release)
+	rp.wg.Wait()
+	for _, st := range rp.Status() {
+		require.Equal(t, start, st.LastSuccess)
+	}
+}
+
+// mapValueStore is a routing.ValueStore which does not validate the records.
+type mapValueStore map[string][]byte
+
+func (vs mapValueStore) PutValue(_ context.Context, key string, val []byte, _ ...routing.Option) error {
+	vs[key] = val
+	return nil
+}
+
+func (vs mapValueStore) GetValue(_ context.Context, key string, _ ...routing.Option) ([]byte, error) {
+	val, ok := vs[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return val, nil
+}
+
+func (vs mapValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	val, err := vs.GetValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []byte, 1)
+	out <- val
+	close(out)
+	return out, nil
+}
+
+func TestRepublisherForgedRoutingRecord(t *testing.T) {
+	env := newTestEnv(t, 1)
+	sk := env.keys[0]
+	id, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(id)
+
+	// A record with a higher sequence number, signed by another key.
+	forger, _, err := ic.GenerateKeyPair(ic.Ed25519, 0)
+	require.NoError(t, err)
+	evil, err := path.NewPath("/ipfs/bafkqaaa")
+	require.NoError(t, err)
+	forged, err := ipns.NewRecord(forger, evil, 100, time.Now().Add(time.Hour), time.Hour)
+	require.NoError(t, err)
+	data, err := ipns.MarshalRecord(forged)
+	require.NoError(t, err)
+	vs := mapValueStore{string(name.RoutingKey()): data}
+
+	mock := clock.NewMock()
+	rp := env.newRepublisher(env.pub, mock, WithRouting(vs))
+	rp.Jitter = 0
+	require.NoError(t, rp.updateKeys(mock.Now()))
+	republishDue(rp, context.Background())
+	require.False(t, rp.Status()[0].LastSuccess.IsZero())
+
+	rec := env.lastRecord(t, sk)
+	seq, err := rec.Sequence()
+	require.NoError(t, err)
+	require.NotEqual(t, uint64(100), seq)
+	val, err := rec.Value()
+	require.NoError(t, err)
+	require.Equal(t, testPath, val)
+}
+
+func TestRepublisherEOLFromClock(t *testing.T) {
+	env := newTestEnv(t, 1)
+	mock := clock.NewMock()
+	mock.Set(time.Now().Add(7 * 24 * time.Hour))
+	rp := env.newRepublisher(env.pub, mock)
+	rp.Jitter = 0
+	require.NoError(t, rp.updateKeys(mock.Now()))
+	republishDue(rp, context.Background())
+
+	eol, err := env.lastRecord(t, env.keys[0]).Validity()
+	require.NoError(t, err)
+	require.True(t, eol.Equal(mock.Now().Add(rp.RecordLifetime)), "EOL %s not computed from the clock", eol)
+}
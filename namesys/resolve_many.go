@@ -0,0 +1,202 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/path"
+)
+
+const (
+	// DefaultResolveManyConcurrency is the default number of names resolved
+	// at the same time by [ResolveMany].
+	DefaultResolveManyConcurrency = 16
+
+	// DefaultResolveManyTimeout is the default time after which [ResolveMany]
+	// gives up on resolving a name.
+	DefaultResolveManyTimeout = DefaultResolverDhtTimeout
+)
+
+// errNotCached is returned by resolutions restricted to the cache when the
+// name is not cached.
+var errNotCached = errors.New("name is not cached")
+
+// BatchResult is the result of the resolution of a name by [ResolveMany].
+type BatchResult struct {
+	// Name is the name given to [ResolveMany].
+	Name path.Path
+
+	// Result is the result of the resolution of Name, when Err is nil.
+	Result
+	Err error
+
+	// Cached is true when Name was resolved from the cache of the
+	// [NameSystem], without network lookups.
+	Cached bool
+
+	// Duration is how long the resolution of Name took.
+	Duration time.Duration
+}
+
+// ResolveManyOptions specifies options for [ResolveMany].
+type ResolveManyOptions struct {
+	// Concurrency is the maximum number of names resolved at the same time.
+	Concurrency int
+
+	// Timeout is the time after which the resolution of a name is given up,
+	// so that a stuck name does not hold the batch. Zero means no timeout.
+	Timeout time.Duration
+
+	// ResolveOptions are the options of the resolution of each name.
+	ResolveOptions []ResolveOption
+}
+
+// DefaultResolveManyOptions returns the default options for [ResolveMany].
+func DefaultResolveManyOptions() ResolveManyOptions {
+	return ResolveManyOptions{
+		Concurrency: DefaultResolveManyConcurrency,
+		Timeout:     DefaultResolveManyTimeout,
+	}
+}
+
+// ResolveManyOption is used to set an option of [ResolveMany].
+type ResolveManyOption func(*ResolveManyOptions)
+
+// ResolveManyWithConcurrency sets [ResolveManyOptions.Concurrency].
+func ResolveManyWithConcurrency(concurrency int) ResolveManyOption {
+	return func(o *ResolveManyOptions) {
+		o.Concurrency = concurrency
+	}
+}
+
+// ResolveManyWithTimeout sets [ResolveManyOptions.Timeout].
+func ResolveManyWithTimeout(timeout time.Duration) ResolveManyOption {
+	return func(o *ResolveManyOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// ResolveManyWithResolveOptions sets [ResolveManyOptions.ResolveOptions].
+func ResolveManyWithResolveOptions(options ...ResolveOption) ResolveManyOption {
+	return func(o *ResolveManyOptions) {
+		o.ResolveOptions = append(o.ResolveOptions, options...)
+	}
+}
+
+// ProcessResolveManyOptions converts an array of [ResolveManyOption] into a
+// [ResolveManyOptions] object.
+func ProcessResolveManyOptions(opts []ResolveManyOption) ResolveManyOptions {
+	options := DefaultResolveManyOptions()
+	for _, option := range opts {
+		option(&options)
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	return options
+}
+
+// ResolveMany resolves names with ns, at most [ResolveManyOptions.Concurrency]
+// at a time, and sends a [BatchResult] for each of them to the returned
+// channel, which is closed once all the names are resolved.
+//
+// When ns was created with [NewNameSystem], the cached names are sent first,
+// before any network lookup. A name given several times is resolved once.
+// Canceling ctx ends the batch: the names left are sent with the error of ctx.
+func ResolveMany(ctx context.Context, ns NameSystem, names []path.Path, opts ...ResolveManyOption) <-chan BatchResult {
+	// the span ends once the workers are done, in the goroutine closing out
+	ctx, span := startSpan(ctx, "ResolveMany")
+
+	options := ProcessResolveManyOptions(opts)
+	out := make(chan BatchResult, len(names))
+
+	// coalesce the names given several times
+	var unique []path.Path
+	inputs := make(map[string][]path.Path, len(names))
+	for _, p := range names {
+		key := p.String()
+		if _, ok := inputs[key]; !ok {
+			unique = append(unique, p)
+		}
+		inputs[key] = append(inputs[key], p)
+	}
+	emit := func(res BatchResult) {
+		for _, name := range inputs[res.Name.String()] {
+			res.Name = name
+			out <- res
+		}
+	}
+
+	pending := unique
+	if ns, ok := ns.(*namesys); ok {
+		cacheOpts := ProcessResolveOptions(options.ResolveOptions)
+		if !cacheOpts.Nocache {
+			cacheOpts.cacheOnly = true
+			pending = nil
+			for _, p := range unique {
+				start := time.Now()
+				res, err := resolve(ctx, ns, p, cacheOpts)
+				if err != nil {
+					pending = append(pending, p)
+					continue
+				}
+				emit(BatchResult{Name: p, Result: res, Cached: true, Duration: time.Since(start)})
+			}
+		}
+	}
+
+	work := make(chan path.Path)
+	var wg sync.WaitGroup
+	for i := 0; i < options.Concurrency && i < len(pending); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				emit(resolveOne(ctx, ns, p, options))
+			}
+		}()
+	}
+
+	go func() {
+		defer close(out)
+		defer span.End()
+		defer wg.Wait()
+		defer close(work)
+
+		for i, p := range pending {
+			select {
+			case work <- p:
+			case <-ctx.Done():
+				for _, p := range pending[i:] {
+					emit(BatchResult{Name: p, Err: ctx.Err()})
+				}
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func resolveOne(ctx context.Context, ns NameSystem, p path.Path, options ResolveManyOptions) BatchResult {
+	start := time.Now()
+	if ctx.Err() != nil {
+		return BatchResult{Name: p, Err: ctx.Err()}
+	}
+
+	nameCtx := ctx
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		nameCtx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	res, err := ns.Resolve(nameCtx, p, options.ResolveOptions...)
+	if err != nil && ctx.Err() == nil && nameCtx.Err() != nil {
+		err = fmt.Errorf("resolving %s timed out after %s: %w", p, options.Timeout, nameCtx.Err())
+	}
+	return BatchResult{Name: p, Result: res, Err: err, Duration: time.Since(start)}
+}
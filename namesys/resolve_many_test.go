@@ -0,0 +1,180 @@
+package namesys
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// latencyValueStore delays the record searches, and blocks the ones of the
+// stuck keys until they are canceled. It tracks the searches in flight.
+type latencyValueStore struct {
+	*countingValueStore
+	latency func(key string) time.Duration
+	stuck   map[string]bool
+
+	inFlight, maxInFlight atomic.Int64
+}
+
+func newLatencyValueStore(latency func(key string) time.Duration) *latencyValueStore {
+	return &latencyValueStore{
+		countingValueStore: newCountingValueStore(),
+		latency:            latency,
+		stuck:              make(map[string]bool),
+	}
+}
+
+func (vs *latencyValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	n := vs.inFlight.Add(1)
+	defer vs.inFlight.Add(-1)
+	for {
+		max := vs.maxInFlight.Load()
+		if n <= max || vs.maxInFlight.CompareAndSwap(max, n) {
+			break
+		}
+	}
+
+	delay := vs.latency(key)
+	if vs.stuck[key] {
+		delay = time.Hour
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return vs.countingValueStore.SearchValue(ctx, key, opts...)
+}
+
+func collectBatch(t *testing.T, ch <-chan BatchResult) []BatchResult {
+	t.Helper()
+
+	var results []BatchResult
+	for res := range ch {
+		results = append(results, res)
+	}
+	return results
+}
+
+func TestResolveManyConcurrency(t *testing.T) {
+	t.Parallel()
+
+	vs := newLatencyValueStore(func(key string) time.Duration {
+		return time.Duration(10+int(key[len(key)-1])%4*10) * time.Millisecond
+	})
+	var names []path.Path
+	for i := 0; i < 12; i++ {
+		names = append(names, putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue1, 0, time.Minute))
+	}
+	ns, err := NewNameSystem(vs)
+	require.NoError(t, err)
+
+	results := collectBatch(t, ResolveMany(context.Background(), ns, names, ResolveManyWithConcurrency(3)))
+	require.Len(t, results, len(names))
+	for _, res := range results {
+		require.NoError(t, res.Err)
+		require.Equal(t, cacheTestValue1, res.Path.String())
+		require.False(t, res.Cached)
+		require.Greater(t, res.Duration, 10*time.Millisecond)
+	}
+	require.EqualValues(t, 3, vs.maxInFlight.Load())
+}
+
+func TestResolveManyTimeout(t *testing.T) {
+	t.Parallel()
+
+	vs := newLatencyValueStore(func(string) time.Duration { return 10 * time.Millisecond })
+	good := putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue1, 0, time.Minute)
+	stuck := putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue1, 0, time.Minute)
+	name, err := ipns.NameFromString(stuck.Segments()[1])
+	require.NoError(t, err)
+	vs.stuck[string(name.RoutingKey())] = true
+	ns, err := NewNameSystem(vs)
+	require.NoError(t, err)
+
+	start := time.Now()
+	results := collectBatch(t, ResolveMany(context.Background(), ns, []path.Path{stuck, good}, ResolveManyWithTimeout(200*time.Millisecond)))
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Len(t, results, 2)
+
+	// the stuck name does not hold the other one
+	require.Equal(t, good, results[0].Name)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, stuck, results[1].Name)
+	require.ErrorIs(t, results[1].Err, context.DeadlineExceeded)
+	require.GreaterOrEqual(t, results[1].Duration, 200*time.Millisecond)
+}
+
+func TestResolveManyCoalescing(t *testing.T) {
+	t.Parallel()
+
+	vs := newLatencyValueStore(func(string) time.Duration { return 20 * time.Millisecond })
+	a := putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue1, 0, time.Minute)
+	b := putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue2, 0, time.Minute)
+	ns, err := NewNameSystem(vs)
+	require.NoError(t, err)
+
+	results := collectBatch(t, ResolveMany(context.Background(), ns, []path.Path{a, b, a, a, b}))
+	require.Len(t, results, 5)
+	values := make(map[string][]string)
+	for _, res := range results {
+		require.NoError(t, res.Err)
+		values[res.Name.String()] = append(values[res.Name.String()], res.Path.String())
+	}
+	require.Equal(t, []string{cacheTestValue1, cacheTestValue1, cacheTestValue1}, values[a.String()])
+	require.Equal(t, []string{cacheTestValue2, cacheTestValue2}, values[b.String()])
+	require.EqualValues(t, 2, vs.countingValueStore.searches.Load(), "each name is looked up once")
+}
+
+func TestResolveManyCacheFirst(t *testing.T) {
+	t.Parallel()
+
+	vs := newLatencyValueStore(func(string) time.Duration { return 50 * time.Millisecond })
+	cached := putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue1, 0, time.Minute)
+	uncached := putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue2, 0, time.Minute)
+	ns, err := NewNameSystem(vs, WithCache(16))
+	require.NoError(t, err)
+	mustResolve(t, ns, cached)
+
+	ch := ResolveMany(context.Background(), ns, []path.Path{uncached, cached})
+	// the cached name is sent before ResolveMany returns
+	require.Len(t, ch, 1)
+	results := collectBatch(t, ch)
+	require.Len(t, results, 2)
+	require.Equal(t, cached, results[0].Name)
+	require.True(t, results[0].Cached)
+	require.Equal(t, cacheTestValue1, results[0].Path.String())
+	require.Equal(t, uncached, results[1].Name)
+	require.False(t, results[1].Cached)
+	require.Equal(t, cacheTestValue2, results[1].Path.String())
+	require.EqualValues(t, 2, vs.countingValueStore.searches.Load())
+}
+
+func TestResolveManyCancel(t *testing.T) {
+	t.Parallel()
+
+	vs := newLatencyValueStore(func(string) time.Duration { return time.Hour })
+	var names []path.Path
+	for i := 0; i < 4; i++ {
+		names = append(names, putRecord(t, vs.countingValueStore, generateKey(t), cacheTestValue1, 0, time.Minute))
+	}
+	ns, err := NewNameSystem(vs)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := ResolveMany(ctx, ns, names, ResolveManyWithConcurrency(1))
+	require.Eventually(t, func() bool { return vs.inFlight.Load() == 1 }, 5*time.Second, time.Millisecond)
+	cancel()
+
+	results := collectBatch(t, ch)
+	require.Len(t, results, len(names))
+	for _, res := range results {
+		require.Error(t, res.Err)
+	}
+}
@@ -2,9 +2,12 @@ package namesys
 
 import (
 	"context"
+	"errors"
 	"strings"
 
+	"github.com/ipfs/boxo/ipns"
 	"github.com/ipfs/boxo/path"
+	"github.com/samber/lo"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -22,7 +25,10 @@ func resolve(ctx context.Context, r resolver, p path.Path, options ResolveOption
 	resCh := resolveAsync(ctx, r, p, options)
 
 	for res := range resCh {
-		result.Path, result.TTL, result.LastMod, err = res.Path, res.TTL, res.LastMod, res.Err
+		if res.Intermediate {
+			continue
+		}
+		result.Path, result.TTL, result.LastMod, result.Trace, err = res.Path, res.TTL, res.LastMod, res.Trace, res.Err
 		if err != nil {
 			break
 		}
@@ -32,6 +38,12 @@ func resolve(ctx context.Context, r resolver, p path.Path, options ResolveOption
 }
 
 func resolveAsync(ctx context.Context, r resolver, p path.Path, options ResolveOptions) <-chan AsyncResult {
+	return resolveChainAsync(ctx, r, p, options, nil)
+}
+
+// resolveChainAsync implements resolveAsync. seen holds the names resolved
+// before getting to p, to detect loops.
+func resolveChainAsync(ctx context.Context, r resolver, p path.Path, options ResolveOptions, seen []string) <-chan AsyncResult {
 	ctx, span := startSpan(ctx, "ResolveAsync")
 	defer span.End()
 
@@ -45,6 +57,7 @@ func resolveAsync(ctx context.Context, r resolver, p path.Path, options ResolveO
 		defer span.End()
 
 		var subCh <-chan AsyncResult
+		var subHop Hop // the hop continued by subCh
 		var cancelSub context.CancelFunc
 		defer func() {
 			if cancelSub != nil {
@@ -67,17 +80,38 @@ func resolveAsync(ctx context.Context, r resolver, p path.Path, options ResolveO
 
 				log.Debugf("resolved %s to %s", p.String(), res.Path.String())
 
+				var hop Hop
+				if p.Mutable() {
+					hop = Hop{Name: p, Resolver: hopResolver(p), Value: res.Path, TTL: res.TTL, Cached: res.cached}
+					if options.HopFunc != nil {
+						options.HopFunc(hop)
+					}
+					res.Trace = []Hop{hop}
+				}
+				res.cached = false
+
 				if !res.Path.Mutable() {
 					emitResult(ctx, outCh, res)
 					break
 				}
 
 				if depth == 1 {
-					res.Err = ErrResolveRecursion
+					res.Err = &RecursionError{Trace: res.Trace}
 					emitResult(ctx, outCh, res)
 					break
 				}
 
+				subSeen := append(seen[:len(seen):len(seen)], rootName(p))
+				if lo.Contains(subSeen, rootName(res.Path)) {
+					res.Err = &LoopError{Trace: res.Trace}
+					emitResult(ctx, outCh, res)
+					break
+				}
+
+				if options.IntermediateResults {
+					emitResult(ctx, outCh, AsyncResult{Path: res.Path, TTL: res.TTL, LastMod: res.LastMod, Trace: res.Trace, Intermediate: true})
+				}
+
 				subOpts := options
 				if subOpts.Depth > 1 {
 					subOpts.Depth--
@@ -92,13 +126,24 @@ func resolveAsync(ctx context.Context, r resolver, p path.Path, options ResolveO
 				subCtx, cancelSub = context.WithCancel(ctx)
 				_ = cancelSub
 
-				subCh = resolveAsync(subCtx, r, res.Path, subOpts)
+				subCh = resolveChainAsync(subCtx, r, res.Path, subOpts, subSeen)
+				subHop = hop
 			case res, ok := <-subCh:
 				if !ok {
 					subCh = nil
 					break
 				}
 
+				res.Trace = append([]Hop{subHop}, res.Trace...)
+				var recursionErr *RecursionError
+				var loopErr *LoopError
+				switch {
+				case errors.As(res.Err, &recursionErr):
+					res.Err = &RecursionError{Trace: res.Trace}
+				case errors.As(res.Err, &loopErr):
+					res.Err = &LoopError{Trace: res.Trace}
+				}
+
 				// We don't bother returning here in case of context timeout as there is
 				// no good reason to do that, and we may still be able to emit a result
 				emitResult(ctx, outCh, res)
@@ -113,6 +158,25 @@ func resolveAsync(ctx context.Context, r resolver, p path.Path, options ResolveO
 	return outCh
 }
 
+// rootName returns the /ipns/<name> root of the mutable path p, with domain
+// names normalized, to compare the names of a resolution.
+func rootName(p path.Path) string {
+	name := p.Segments()[1]
+	if _, err := ipns.NameFromString(name); err != nil {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+	}
+	return name
+}
+
+// hopResolver returns the [Hop.Resolver] of the /ipns/ path p, following the
+// choice of the resolver by [NameSystem].
+func hopResolver(p path.Path) string {
+	if _, err := ipns.NameFromString(p.Segments()[1]); err == nil {
+		return HopIPNS
+	}
+	return HopDNSLink
+}
+
 func emitResult(ctx context.Context, outCh chan<- AsyncResult, r AsyncResult) {
 	select {
 	case outCh <- r:
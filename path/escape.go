@@ -0,0 +1,107 @@
+package path
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// EscapedOption configures [NewPathFromEscaped] and [NewPathFromURL].
+type EscapedOption func(*escapedOptions)
+
+type escapedOptions struct {
+	literalEncodedSlashes bool
+}
+
+// WithLiteralEncodedSlashes keeps the encoded slashes ("%2F") of the segments
+// as is, instead of rejecting them: the segment "a%2Fb" is the name "a%2Fb".
+func WithLiteralEncodedSlashes() EscapedOption {
+	return func(o *escapedOptions) {
+		o.literalEncodedSlashes = true
+	}
+}
+
+// NewPathFromURL returns the [Path] of the percent-encoded path of u, as
+// [NewPathFromEscaped].
+func NewPathFromURL(u *url.URL, opts ...EscapedOption) (Path, error) {
+	return NewPathFromEscaped(u.EscapedPath(), opts...)
+}
+
+// NewPathFromEscaped takes a percent-encoded path, as found in URLs, and returns
+// the [Path] of its decoded segments. Unlike [NewPath], which takes the
+// segments literally, "/ipfs/{cid}/a%20b" is the path of the name "a b".
+//
+// The path is normalized as follows:
+//
+//   - Empty segments, from duplicate slashes, are removed.
+//   - The "." segments are removed, and the ".." segments remove the previous
+//     segment, encoded or not. A ".." which would remove the root, or the
+//     namespace, is rejected with [ErrEscapesRoot].
+//   - The trailing slash is preserved, and a trailing "." or ".." segment
+//     leaves one.
+//
+// Segments with an encoded NUL byte ("%00") are rejected, and so are the ones
+// with an encoded slash ("%2F"), unless [WithLiteralEncodedSlashes] is passed.
+func NewPathFromEscaped(str string, opts ...EscapedOption) (Path, error) {
+	var o escapedOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if !strings.HasPrefix(str, "/") {
+		return nil, &ErrInvalidPath{err: ErrInsufficientComponents, path: str}
+	}
+
+	rawSegments := strings.Split(str[1:], "/")
+	segments := make([]string, 0, len(rawSegments))
+	trailingSlash := false
+	for _, raw := range rawSegments {
+		if o.literalEncodedSlashes {
+			raw = strings.ReplaceAll(strings.ReplaceAll(raw, "%2F", "%252F"), "%2f", "%252f")
+		}
+		segment, err := url.PathUnescape(raw)
+		if err != nil {
+			return nil, &ErrInvalidPath{err: err, path: str}
+		}
+
+		trailingSlash = true
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) <= 2 {
+				return nil, &ErrInvalidPath{err: ErrEscapesRoot, path: str}
+			}
+			segments = segments[:len(segments)-1]
+		default:
+			if strings.ContainsAny(segment, "/\x00") {
+				return nil, &ErrInvalidPath{err: fmt.Errorf("%w: %q", ErrInvalidSegment, raw), path: str}
+			}
+			segments = append(segments, segment)
+			trailingSlash = false
+		}
+	}
+
+	cleaned := SegmentsToString(segments...)
+	if trailingSlash && len(segments) != 0 {
+		cleaned += "/"
+	}
+	if len(segments) < 2 {
+		return nil, &ErrInvalidPath{err: ErrInsufficientComponents, path: str}
+	}
+	return NewPath(cleaned)
+}
+
+// EscapedString returns the string of p with its segments percent-encoded, to
+// be used in a URL. It is the inverse of [NewPathFromEscaped].
+func EscapedString(p Path) string {
+	segments := p.Segments()
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	str := SegmentsToString(escaped...)
+	if strings.HasSuffix(p.String(), "/") {
+		str += "/"
+	}
+	return str
+}
@@ -0,0 +1,105 @@
+package path
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPathFromEscaped(t *testing.T) {
+	t.Parallel()
+
+	const root = "/ipfs/bafkqaaa"
+
+	testCases := []struct {
+		escaped  string
+		expected string
+		err      error
+	}{
+		{root + "/a%20b.txt", root + "/a b.txt", nil},
+		{root + "/a b.txt", root + "/a b.txt", nil},
+		{root + "/%E2%9C%93/%F0%9F%90%88", root + "/✓/🐈", nil},
+		{root + "/✓", root + "/✓", nil},
+		{root + "/a%25b", root + "/a%b", nil},
+		{root + "/a+b?", root + "/a+b?", nil},
+		{root + "/a%3Fb%23c", root + "/a?b#c", nil},
+		{root + "/trailing.", root + "/trailing.", nil},
+		{root + "/trailing../...", root + "/trailing../...", nil},
+		{root + "/dir/", root + "/dir/", nil},
+		{root + "//a///b", root + "/a/b", nil},
+		{root + "/./a/./b", root + "/a/b", nil},
+		{root + "/a/b/../c", root + "/a/c", nil},
+		{root + "/a/%2E%2E/c", root + "/c", nil},
+		{root + "/a/..", root + "/", nil},
+		{root + "/a/.", root + "/a/", nil},
+		{"/ipns/example.net/a%20b/", "/ipns/example.net/a b/", nil},
+		{"//ipns//example.net", "/ipns/example.net", nil},
+
+		{root + "/a%2Fb", "", ErrInvalidSegment},
+		{root + "/a%2fb", "", ErrInvalidSegment},
+		{root + "/a%00b", "", ErrInvalidSegment},
+		{root + "/..", "", ErrEscapesRoot},
+		{root + "/a/../../b", "", ErrEscapesRoot},
+		{root + "/a/%2e%2e/..", "", ErrEscapesRoot},
+		{"/ipfs/../ipns/example.net", "", ErrEscapesRoot},
+		{root + "/a%zz", "", url.EscapeError("%zz")},
+		{root + "/a%", "", url.EscapeError("%")},
+		{"/ipfs", "", ErrInsufficientComponents},
+		{"/ipfs/%2E", "", ErrInsufficientComponents},
+		{"ipfs/bafkqaaa", "", ErrInsufficientComponents},
+		{"/ipfs/bafkqaaa%2F", "", ErrInvalidSegment},
+		{"/unknown/bafkqaaa", "", ErrUnknownNamespace},
+	}
+
+	for _, testCase := range testCases {
+		p, err := NewPathFromEscaped(testCase.escaped)
+		if testCase.err != nil {
+			assert.ErrorIs(t, err, testCase.err, testCase.escaped)
+			assert.ErrorIs(t, err, &ErrInvalidPath{}, testCase.escaped)
+			continue
+		}
+		if !assert.NoError(t, err, testCase.escaped) {
+			continue
+		}
+		assert.Equal(t, testCase.expected, p.String(), testCase.escaped)
+
+		// the escaped string is parsed back to the same path
+		rp, err := NewPathFromEscaped(EscapedString(p))
+		assert.NoError(t, err, testCase.escaped)
+		assert.Equal(t, p.String(), rp.String(), testCase.escaped)
+	}
+}
+
+func TestNewPathFromEscapedLiteralSlashes(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewPathFromEscaped("/ipfs/bafkqaaa/a%2Fb%20c/d%2f", WithLiteralEncodedSlashes())
+	assert.NoError(t, err)
+	assert.Equal(t, "/ipfs/bafkqaaa/a%2Fb c/d%2f", p.String())
+	assert.Equal(t, []string{"ipfs", "bafkqaaa", "a%2Fb c", "d%2f"}, p.Segments())
+	assert.Equal(t, "/ipfs/bafkqaaa/a%252Fb%20c/d%252f", EscapedString(p))
+
+	_, err = NewPathFromEscaped("/ipfs/bafkqaaa/a%00", WithLiteralEncodedSlashes())
+	assert.ErrorIs(t, err, ErrInvalidSegment)
+}
+
+func TestNewPathFromURL(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("https://example.net/ipfs/bafkqaaa/a%20b/%E2%9C%93?query=1#fragment")
+	assert.NoError(t, err)
+	p, err := NewPathFromURL(u)
+	assert.NoError(t, err)
+	assert.Equal(t, "/ipfs/bafkqaaa/a b/✓", p.String())
+	assert.Equal(t, "/ipfs/bafkqaaa/a%20b/%E2%9C%93", EscapedString(p))
+	_, isImmutable := p.(ImmutablePath)
+	assert.True(t, isImmutable)
+
+	// the encoded slashes are told apart from the separators
+	u, err = url.Parse("https://example.net/ipfs/bafkqaaa/a%2Fb")
+	assert.NoError(t, err)
+	assert.Equal(t, "/ipfs/bafkqaaa/a/b", u.Path)
+	_, err = NewPathFromURL(u)
+	assert.ErrorIs(t, err, ErrInvalidSegment)
+}
@@ -185,6 +185,81 @@ func Join(p Path, segments ...string) (Path, error) {
 	return NewPathFromSegments(s...)
 }
 
+// JoinNames is like [Join], but each segment is a single name, taken
+// verbatim: an empty segment, ".", "..", or a segment with a forward slash
+// ("/") or a NUL byte is rejected with [ErrInvalidSegment], instead of being
+// cleaned into another path. The trailing slash of p, if any, is not
+// preserved.
+func JoinNames(p Path, names ...string) (Path, error) {
+	for _, name := range names {
+		if err := validateSegment(name); err != nil {
+			return nil, &ErrInvalidPath{err: err, path: p.String()}
+		}
+	}
+	return Join(p, names...)
+}
+
+func validateSegment(segment string) error {
+	if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "/\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidSegment, segment)
+	}
+	return nil
+}
+
+// Remainder returns the segments of p after its namespace and root. For
+// example, the remainder of "/ipfs/bafkqaaa/a/b" is ["a", "b"].
+func Remainder(p Path) []string {
+	return p.Segments()[2:]
+}
+
+// Parent returns the path of the parent of the last segment of p, and true, or
+// p and false if p has no segments after its root. The trailing slash of p, if
+// any, is not preserved.
+//
+// For any path p with segments after its root, and without trailing slash,
+// JoinNames(Parent(p), Base(p)) returns p.
+func Parent(p Path) (Path, bool) {
+	segments := p.Segments()
+	if len(segments) <= 2 {
+		return p, false
+	}
+	parent, err := NewPathFromSegments(segments[:len(segments)-1]...)
+	if err != nil {
+		return p, false
+	}
+	return parent, true
+}
+
+// Base returns the last segment of p after its root, or an empty string if p
+// has no segments after its root.
+func Base(p Path) string {
+	segments := Remainder(p)
+	if len(segments) == 0 {
+		return ""
+	}
+	return segments[len(segments)-1]
+}
+
+// Relative returns the segments of p under base, and true, or false if p is
+// not base or one of its descendants. Paths of different namespaces or roots
+// are never relative to each other.
+//
+// For example, the segments of "/ipns/example.net/a/b/c" relative to
+// "/ipns/example.net/a" are ["b", "c"].
+func Relative(base, p Path) ([]string, bool) {
+	baseSegments := base.Segments()
+	segments := p.Segments()
+	if len(segments) < len(baseSegments) {
+		return nil, false
+	}
+	for i, segment := range baseSegments {
+		if segments[i] != segment {
+			return nil, false
+		}
+	}
+	return segments[len(baseSegments):], true
+}
+
 // SegmentsToString converts an array of segments into a string. The returned string
 // will always be prefixed with a "/" if there are any segments. For example, if the
 // given segments array is ["foo", "bar"], the returned value will be "/foo/bar".
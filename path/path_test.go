@@ -267,6 +267,119 @@ func TestJoin(t *testing.T) {
 	}
 }
 
+func TestJoinNames(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		path     string
+		names    []string
+		expected string
+	}{
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", []string{"a", "b"}, "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/", []string{"a", "b"}, "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a", []string{"b? #<'"}, "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n/a/b? #<'"},
+		{"/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", nil, "/ipfs/QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"},
+		{"/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", []string{"a", "b"}, "/ipfs/bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku/a/b"},
+		{"/ipns/example.net", []string{"a", "b"}, "/ipns/example.net/a/b"},
+	}
+
+	for _, testCase := range testCases {
+		p, err := NewPath(testCase.path)
+		assert.NoError(t, err)
+		jp, err := JoinNames(p, testCase.names...)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, jp.String())
+		assert.Equal(t, p.Mutable(), jp.Mutable())
+	}
+
+	p, err := NewPath("/ipns/example.net/a")
+	assert.NoError(t, err)
+	for _, name := range []string{"", ".", "..", "a/b", "/a", "a/", "a\x00b"} {
+		_, err := JoinNames(p, "b", name)
+		assert.ErrorIs(t, err, ErrInvalidSegment, name)
+		assert.ErrorIs(t, err, &ErrInvalidPath{}, name)
+
+		// which Join cleans into another path, or keeps
+		_, err = Join(p, "b", name)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestParentBase(t *testing.T) {
+	t.Parallel()
+
+	for _, str := range []string{
+		"/ipfs/bafkqaaa/a",
+		"/ipfs/bafkqaaa/a/b/c",
+		"/ipld/bafkqaaa/a/b",
+		"/ipns/example.net/a/b? #<'",
+		"/ipns/k51qzi5uqu5dhkdbjdsauuyk8iyp7cx1ig8h2080yroxjtzsu2hb1cedkz5dfy/a",
+	} {
+		p, err := NewPath(str)
+		assert.NoError(t, err)
+
+		parent, ok := Parent(p)
+		assert.True(t, ok, str)
+		jp, err := JoinNames(parent, Base(p))
+		assert.NoError(t, err, str)
+		assert.Equal(t, str, jp.String())
+		assert.Equal(t, p.Mutable(), parent.Mutable(), str)
+
+		// the paths round-trip through NewPath
+		np, err := NewPath(parent.String())
+		assert.NoError(t, err, str)
+		assert.Equal(t, parent.String(), np.String())
+	}
+
+	p, err := NewPath("/ipfs/bafkqaaa/a/b/")
+	assert.NoError(t, err)
+	parent, ok := Parent(p)
+	assert.True(t, ok)
+	assert.Equal(t, "/ipfs/bafkqaaa/a", parent.String())
+	assert.Equal(t, "b", Base(p))
+	_, isImmutable := parent.(ImmutablePath)
+	assert.True(t, isImmutable)
+
+	for _, str := range []string{"/ipfs/bafkqaaa", "/ipns/example.net/"} {
+		p, err := NewPath(str)
+		assert.NoError(t, err)
+		parent, ok := Parent(p)
+		assert.False(t, ok, str)
+		assert.Equal(t, p, parent, str)
+		assert.Equal(t, "", Base(p), str)
+		assert.Empty(t, Remainder(p), str)
+	}
+}
+
+func TestRelative(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		base     string
+		path     string
+		expected []string
+		ok       bool
+	}{
+		{"/ipns/example.net/a", "/ipns/example.net/a/b/c", []string{"b", "c"}, true},
+		{"/ipfs/bafkqaaa/", "/ipfs/bafkqaaa/a", []string{"a"}, true},
+		{"/ipfs/bafkqaaa/a", "/ipfs/bafkqaaa/a/", []string{}, true},
+		{"/ipfs/bafkqaaa/a", "/ipfs/bafkqaaa/ab", nil, false},
+		{"/ipfs/bafkqaaa/a/b", "/ipfs/bafkqaaa/a", nil, false},
+		{"/ipfs/bafkqaaa", "/ipld/bafkqaaa/a", nil, false},
+		{"/ipns/example.net", "/ipns/example.org/a", nil, false},
+	}
+
+	for _, testCase := range testCases {
+		base, err := NewPath(testCase.base)
+		assert.NoError(t, err)
+		p, err := NewPath(testCase.path)
+		assert.NoError(t, err)
+		segments, ok := Relative(base, p)
+		assert.Equal(t, testCase.ok, ok, testCase.path)
+		assert.Equal(t, testCase.expected, segments, testCase.path)
+	}
+}
+
 func TestStringToSegments(t *testing.T) {
 	testCases := []struct {
 		str      string
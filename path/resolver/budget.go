@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ipfs/boxo/fetcher"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipld/go-ipld-prime"
+)
+
+const (
+	// DefaultMaxPathDepth is the default maximum number of segments after
+	// the root of the paths resolved.
+	DefaultMaxPathDepth = 1024
+
+	// DefaultMaxBlocksFetched is the default maximum number of blocks fetched
+	// to resolve a path, the shards of the HAMT sharded directories included.
+	DefaultMaxBlocksFetched = 16 * 1024
+)
+
+// Budget is a limit of the resolution of a path.
+type Budget int
+
+const (
+	// BudgetPathDepth is the limit set with [WithMaxPathDepth].
+	BudgetPathDepth Budget = iota
+	// BudgetBlocksFetched is the limit set with [WithMaxBlocksFetched].
+	BudgetBlocksFetched
+)
+
+func (b Budget) String() string {
+	switch b {
+	case BudgetPathDepth:
+		return "max path depth"
+	case BudgetBlocksFetched:
+		return "max blocks fetched"
+	default:
+		return fmt.Sprintf("Budget(%d)", int(b))
+	}
+}
+
+// ErrBudgetExceeded is returned when the resolution of a path exceeds one of
+// the budgets of the resolver.
+type ErrBudgetExceeded struct {
+	Budget Budget
+	Limit  int
+	Path   path.ImmutablePath
+
+	// Trace is the trace of the segments resolved within the budget, as in
+	// [Result.Trace]. For [BudgetPathDepth], it is the one of the first Limit
+	// segments.
+	Trace []ResolvedSegment
+}
+
+// Error implements the [errors.Error] interface.
+func (e *ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf("resolving %s exceeds the %s of %d, after %d segments", e.Path, e.Budget, e.Limit, len(e.Trace))
+}
+
+// Is implements [errors.Is] interface.
+func (e *ErrBudgetExceeded) Is(err error) bool {
+	switch err.(type) {
+	case *ErrBudgetExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithMaxPathDepth sets the maximum number of segments after the root of the
+// paths resolved. The paths with more segments, once their symlinks are
+// followed, fail with an [ErrBudgetExceeded]. Zero means unlimited. It
+// defaults to [DefaultMaxPathDepth].
+func WithMaxPathDepth(n int) Option {
+	return func(r *basicResolver) {
+		r.maxPathDepth = n
+	}
+}
+
+// WithMaxBlocksFetched sets the maximum number of blocks fetched by a call of
+// the resolver, after which it fails with an [ErrBudgetExceeded]. Zero means
+// unlimited. It defaults to [DefaultMaxBlocksFetched].
+//
+// The blocks are counted for the fetcher factories which support
+// [fetcher.ContextWithBlockLoadHook].
+func WithMaxBlocksFetched(n int) Option {
+	return func(r *basicResolver) {
+		r.maxBlocksFetched = n
+	}
+}
+
+// withBlockBudget returns a context under which the blocks fetched past the
+// maximum fail with an *ErrBudgetExceeded.
+func (r *basicResolver) withBlockBudget(ctx context.Context) context.Context {
+	if r.maxBlocksFetched <= 0 {
+		return ctx
+	}
+	var fetched atomic.Int64
+	limit := r.maxBlocksFetched
+	return fetcher.ContextWithBlockLoadHook(ctx, func(ipld.Link) error {
+		if fetched.Add(1) > int64(limit) {
+			return &ErrBudgetExceeded{Budget: BudgetBlocksFetched, Limit: limit}
+		}
+		return nil
+	})
+}
+
+// checkDepth returns an *ErrBudgetExceeded if fpath is deeper than the
+// maximum, with the trace of the resolution of its first segments.
+func (r *basicResolver) checkDepth(ctx context.Context, fpath path.ImmutablePath) error {
+	segments := fpath.Segments()
+	if r.maxPathDepth <= 0 || len(segments)-2 <= r.maxPathDepth {
+		return nil
+	}
+
+	berr := &ErrBudgetExceeded{Budget: BudgetPathDepth, Limit: r.maxPathDepth, Path: fpath}
+	p, err := path.NewPathFromSegments(segments[:2+r.maxPathDepth]...)
+	if err != nil {
+		return berr
+	}
+	prefix, err := path.NewImmutablePath(p)
+	if err != nil {
+		return berr
+	}
+	res, err := r.resolveToLastNode(ctx, prefix)
+	var perr *ErrBudgetExceeded
+	if errors.As(err, &perr) {
+		return perr
+	}
+	berr.Trace = res.Trace
+	return berr
+}
+
+// budgetErr fills the path and the trace of the *ErrBudgetExceeded of err, if
+// it has one, and returns it.
+func budgetErr(err error, fpath path.ImmutablePath, trace []ResolvedSegment) error {
+	var berr *ErrBudgetExceeded
+	if !errors.As(err, &berr) {
+		return err
+	}
+	if berr.Path.RootCid().Defined() {
+		return berr
+	}
+	berr.Path = fpath
+	berr.Trace = trace
+	return berr
+}
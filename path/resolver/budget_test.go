@@ -0,0 +1,162 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	dagmock "github.com/ipfs/boxo/ipld/merkledag/test"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
+	"github.com/ipfs/boxo/path/resolver"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxPathDepth(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	// a chain of 30 directories, each linking the next one as "a"
+	chain := make([]cid.Cid, 30)
+	nd := ft.EmptyDirNode()
+	require.NoError(t, bsrv.AddBlock(ctx, nd))
+	chain[len(chain)-1] = nd.Cid()
+	for i := len(chain) - 2; i >= 0; i-- {
+		parent := ft.EmptyDirNode()
+		require.NoError(t, parent.AddNodeLink("a", nd))
+		require.NoError(t, bsrv.AddBlock(ctx, parent))
+		chain[i], nd = parent.Cid(), parent
+	}
+	segments := func(n int) []string {
+		s := make([]string, n)
+		for i := range s {
+			s[i] = "a"
+		}
+		return s
+	}
+
+	r := newUnixFSResolver(bsrv, resolver.WithMaxPathDepth(10))
+
+	// at the threshold
+	c, _, err := r.ResolveToLastNode(ctx, fixturePath(t, chain[0], segments(10)...))
+	require.NoError(t, err)
+	require.Equal(t, chain[10], c)
+
+	p := fixturePath(t, chain[0], segments(11)...)
+	_, err = r.(resolver.TracingResolver).ResolveToLastNodeWithTrace(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrBudgetExceeded{})
+	var berr *resolver.ErrBudgetExceeded
+	require.ErrorAs(t, err, &berr)
+	require.Equal(t, resolver.BudgetPathDepth, berr.Budget)
+	require.Equal(t, 10, berr.Limit)
+	require.Equal(t, p, berr.Path)
+	require.Len(t, berr.Trace, 10)
+	for i, s := range berr.Trace {
+		require.Equal(t, resolver.ResolvedSegment{Name: "a", Cid: chain[i+1]}, s)
+	}
+
+	_, _, err = r.ResolvePath(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrBudgetExceeded{})
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrBudgetExceeded{})
+
+	// zero is unlimited
+	c, _, err = newUnixFSResolver(bsrv, resolver.WithMaxPathDepth(0)).ResolveToLastNode(ctx, fixturePath(t, chain[0], segments(29)...))
+	require.NoError(t, err)
+	require.Equal(t, chain[29], c)
+}
+
+func TestMaxBlocksFetched(t *testing.T) {
+	ctx := context.Background()
+	bstore := &countingBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())),
+		gets:       make(map[cid.Cid]int),
+	}
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bsrv)
+
+	// root/hamt/entry/f, where hamt is a wide HAMT sharded directory
+	f := merkledag.NodeWithData(ft.FilePBData([]byte("f"), 1))
+	require.NoError(t, dserv.Add(ctx, f))
+	entry := ft.EmptyDirNode()
+	require.NoError(t, entry.AddNodeLink("f", f))
+	require.NoError(t, dserv.Add(ctx, entry))
+	shard, err := hamt.NewShard(dserv, 16)
+	require.NoError(t, err)
+	require.NoError(t, shard.Set(ctx, "entry", entry))
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("file-%d", i), f))
+	}
+	wide, err := shard.Node()
+	require.NoError(t, err)
+	root := ft.EmptyDirNode()
+	require.NoError(t, root.AddNodeLink("hamt", wide))
+	require.NoError(t, dserv.Add(ctx, root))
+	p := fixturePath(t, root.Cid(), "hamt", "entry", "f")
+
+	// the blocks needed: the root, the shards of the HAMT leading to the
+	// entry, and the entry
+	c, _, err := newUnixFSResolver(bsrv, resolver.WithMaxBlocksFetched(0)).ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, f.Cid(), c)
+	needed := bstore.total()
+	require.Greater(t, needed, 4)
+
+	// at the threshold
+	c, _, err = newUnixFSResolver(bsrv, resolver.WithMaxBlocksFetched(needed)).ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, f.Cid(), c)
+
+	// one block short, the entry is not fetched
+	r := newUnixFSResolver(bsrv, resolver.WithMaxBlocksFetched(needed-1))
+	_, err = r.(resolver.TracingResolver).ResolveToLastNodeWithTrace(ctx, p)
+	var berr *resolver.ErrBudgetExceeded
+	require.ErrorAs(t, err, &berr)
+	require.Equal(t, resolver.BudgetBlocksFetched, berr.Budget)
+	require.Equal(t, needed-1, berr.Limit)
+	require.Equal(t, p, berr.Path)
+	require.Equal(t, []resolver.ResolvedSegment{{Name: "hamt", Cid: wide.Cid()}}, berr.Trace)
+
+	_, _, err = r.ResolvePath(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrBudgetExceeded{})
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrBudgetExceeded{})
+
+	// only the root is fetched
+	_, err = newUnixFSResolver(bsrv, resolver.WithMaxBlocksFetched(1)).(resolver.TracingResolver).ResolveToLastNodeWithTrace(ctx, p)
+	require.ErrorAs(t, err, &berr)
+	require.Empty(t, berr.Trace)
+
+	// the blocks of the traversals shared by a deduplicating fetcher count
+	// for each resolution
+	fc := unixFSFetcherConfig(bsrv).WithDeduplication(0)
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limit := needed
+			if i%2 == 1 {
+				limit = needed - 1
+			}
+			_, _, errs[i] = resolver.NewBasicResolver(fc, resolver.WithMaxBlocksFetched(limit)).ResolveToLastNode(ctx, p)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if i%2 == 1 {
+			require.ErrorIs(t, err, &resolver.ErrBudgetExceeded{})
+		} else {
+			require.NoError(t, err)
+		}
+	}
+}
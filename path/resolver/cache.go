@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"math"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultLinkCacheMaxBytes is the default maximum size of a [LinkCache].
+const DefaultLinkCacheMaxBytes = 8 << 20
+
+// linkCacheEntryOverhead is the approximate size of an entry of a LinkCache,
+// besides its CIDs and name.
+const linkCacheEntryOverhead = 64
+
+type linkKey struct {
+	parent cid.Cid
+	name   string
+}
+
+// LinkCache caches the CIDs linked by the names of the nodes, as found by the
+// resolvers, so that the resolution of the paths which share a prefix does not
+// fetch and decode its nodes again. As the nodes are immutable, the entries
+// are never invalidated: the least recently used ones are evicted once the
+// cache is full.
+//
+// For the HAMT sharded directories, the CID of the entry of a name is cached,
+// not the ones of the shards leading to it.
+//
+// A LinkCache is safe for concurrent use, and can be shared by resolvers with
+// [WithLinkCache].
+type LinkCache struct {
+	mu       sync.Mutex
+	lru      *simplelru.LRU[linkKey, cid.Cid]
+	size     int
+	maxBytes int
+}
+
+// NewLinkCache returns a [LinkCache] which holds up to about maxBytes of
+// entries, or [DefaultLinkCacheMaxBytes] if maxBytes is not positive.
+func NewLinkCache(maxBytes int) *LinkCache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultLinkCacheMaxBytes
+	}
+	c := &LinkCache{maxBytes: maxBytes}
+	// the entries are bounded by their size, not their number
+	c.lru, _ = simplelru.NewLRU[linkKey, cid.Cid](math.MaxInt, func(k linkKey, v cid.Cid) {
+		c.size -= linkEntrySize(k, v)
+	})
+	return c
+}
+
+func linkEntrySize(k linkKey, v cid.Cid) int {
+	return k.parent.ByteLen() + len(k.name) + v.ByteLen() + linkCacheEntryOverhead
+}
+
+func (c *LinkCache) get(parent cid.Cid, name string) (cid.Cid, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Get(linkKey{parent: parent, name: name})
+}
+
+func (c *LinkCache) add(parent cid.Cid, name string, child cid.Cid) {
+	k := linkKey{parent: parent, name: name}
+	size := linkEntrySize(k, child)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru.Contains(k) {
+		return
+	}
+	c.lru.Add(k, child)
+	c.size += size
+	for c.size > c.maxBytes {
+		c.lru.RemoveOldest()
+	}
+}
+
+// Len returns the number of entries of the cache.
+func (c *LinkCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// WithLinkCache sets the [LinkCache] consulted and filled by the resolver to
+// resolve paths with [Resolver.ResolveToLastNode]. There is no cache by
+// default.
+func WithLinkCache(cache *LinkCache) Option {
+	return func(r *basicResolver) {
+		r.linkCache = cache
+	}
+}
+
+// cachedPrefix follows the links of the cache from c for the first segments,
+// and returns the CID reached with the trace of the segments followed.
+func (r *basicResolver) cachedPrefix(c cid.Cid, segments []string) (cid.Cid, []ResolvedSegment) {
+	if r.linkCache == nil {
+		return c, nil
+	}
+	var trace []ResolvedSegment
+	for _, name := range segments {
+		child, ok := r.linkCache.get(c, name)
+		if !ok {
+			break
+		}
+		trace = append(trace, ResolvedSegment{Name: name, Cid: child})
+		c = child
+	}
+	return c, trace
+}
+
+// cacheLinks adds the links of the trace which go from the root of a block to
+// another block to the cache. root is the CID the trace starts from.
+func (r *basicResolver) cacheLinks(root cid.Cid, trace []ResolvedSegment) {
+	if r.linkCache == nil {
+		return
+	}
+	parent, parentIsBlockRoot := root, true
+	for _, s := range trace {
+		isLink := !s.Cid.Equals(parent)
+		if parentIsBlockRoot && isLink {
+			r.linkCache.add(parent, s.Name, s.Cid)
+		}
+		parent, parentIsBlockRoot = s.Cid, isLink
+	}
+}
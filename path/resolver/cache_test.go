@@ -0,0 +1,161 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/boxo/path/resolver"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingBlockstore counts the blocks read.
+type countingBlockstore struct {
+	blockstore.Blockstore
+
+	mu   sync.Mutex
+	gets map[cid.Cid]int
+}
+
+func (bs *countingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	bs.mu.Lock()
+	bs.gets[c]++
+	bs.mu.Unlock()
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func (bs *countingBlockstore) count(c cid.Cid) int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.gets[c]
+}
+
+func (bs *countingBlockstore) total() int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	var n int
+	for _, count := range bs.gets {
+		n += count
+	}
+	return n
+}
+
+func TestLinkCache(t *testing.T) {
+	ctx := context.Background()
+	bstore := &countingBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())),
+		gets:       make(map[cid.Cid]int),
+	}
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bsrv)
+
+	// root/static/js/file-{i}, where static is a HAMT sharded directory
+	js := ft.EmptyDirNode()
+	files := make([]cid.Cid, 100)
+	for i := range files {
+		f := merkledag.NodeWithData(ft.FilePBData([]byte(fmt.Sprint(i)), uint64(len(fmt.Sprint(i)))))
+		require.NoError(t, dserv.Add(ctx, f))
+		require.NoError(t, js.AddNodeLink(fmt.Sprintf("file-%d", i), f))
+		files[i] = f.Cid()
+	}
+	require.NoError(t, dserv.Add(ctx, js))
+	shard, err := hamt.NewShard(dserv, 16)
+	require.NoError(t, err)
+	require.NoError(t, shard.Set(ctx, "js", js))
+	for i := 0; i < 64; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("css-%d", i), js))
+	}
+	static, err := shard.Node()
+	require.NoError(t, err)
+	root := ft.EmptyDirNode()
+	require.NoError(t, root.AddNodeLink("static", static))
+	require.NoError(t, dserv.Add(ctx, root))
+
+	cache := resolver.NewLinkCache(0)
+	r := newUnixFSResolver(bsrv, resolver.WithLinkCache(cache))
+	for i := 0; i < 100; i++ {
+		c, remainder, err := r.ResolveToLastNode(ctx, fixturePath(t, root.Cid(), "static", "js", fmt.Sprintf("file-%d", i)))
+		require.NoError(t, err)
+		require.Empty(t, remainder)
+		require.Equal(t, files[i], c)
+	}
+	// the blocks of the shared prefix are fetched once, the ones of js are
+	// fetched to look up each file
+	require.Equal(t, 1, bstore.count(root.Cid()))
+	require.Equal(t, 1, bstore.count(static.Cid()))
+	require.Equal(t, 100, bstore.count(js.Cid()))
+	require.Equal(t, 102, cache.Len())
+
+	// the paths resolved are not fetched again, by another resolver sharing
+	// the cache too
+	total := bstore.total()
+	other := newUnixFSResolver(bsrv, resolver.WithLinkCache(cache))
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int, p path.ImmutablePath, r resolver.Resolver) {
+			defer wg.Done()
+			c, _, err := r.ResolveToLastNode(ctx, p)
+			assert.NoError(t, err)
+			assert.Equal(t, files[i], c)
+		}(i, fixturePath(t, root.Cid(), "static", "js", fmt.Sprintf("file-%d", i)), []resolver.Resolver{r, other}[i%2])
+	}
+	wg.Wait()
+	require.Equal(t, total, bstore.total())
+
+	// the trace of the cached segments is kept
+	res, err := other.(resolver.TracingResolver).ResolveToLastNodeWithTrace(ctx, fixturePath(t, root.Cid(), "static", "js", "file-0"))
+	require.NoError(t, err)
+	require.Equal(t, []resolver.ResolvedSegment{
+		{Name: "static", Cid: static.Cid()},
+		{Name: "js", Cid: js.Cid()},
+		{Name: "file-0", Cid: files[0]},
+	}, res.Trace)
+
+	// without cache, the prefix is fetched for each path
+	bstore.gets = make(map[cid.Cid]int)
+	r = newUnixFSResolver(bsrv)
+	for i := 0; i < 10; i++ {
+		_, _, err := r.ResolveToLastNode(ctx, fixturePath(t, root.Cid(), "static", "js", fmt.Sprintf("file-%d", i)))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 10, bstore.count(root.Cid()))
+}
+
+func TestLinkCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bsrv)
+
+	dir := ft.EmptyDirNode()
+	f := merkledag.NodeWithData(ft.FilePBData([]byte("f"), 1))
+	require.NoError(t, dserv.Add(ctx, f))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, dir.AddNodeLink(fmt.Sprintf("file-%d", i), f))
+	}
+	require.NoError(t, dserv.Add(ctx, dir))
+
+	// room for about 10 entries
+	cache := resolver.NewLinkCache(10 * (2*f.Cid().ByteLen() + 7 + 64))
+	r := newUnixFSResolver(bsrv, resolver.WithLinkCache(cache))
+	for i := 0; i < 100; i++ {
+		_, _, err := r.ResolveToLastNode(ctx, fixturePath(t, dir.Cid(), fmt.Sprintf("file-%d", i)))
+		require.NoError(t, err)
+	}
+	require.LessOrEqual(t, cache.Len(), 10)
+	require.NotZero(t, cache.Len())
+}
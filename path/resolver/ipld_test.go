@@ -0,0 +1,105 @@
+package resolver_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/blockservice"
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	dagmock "github.com/ipfs/boxo/ipld/merkledag/test"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/path/resolver"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec"
+	dagcbor "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	dagjson "github.com/ipld/go-ipld-prime/codec/dagjson"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func addIPLDBlock(t *testing.T, bsrv blockservice.BlockService, codecType uint64, encode codec.Encoder, json string) cid.Cid {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	require.NoError(t, dagjson.Decode(nb, strings.NewReader(json)))
+	out := new(bytes.Buffer)
+	require.NoError(t, encode(nb.Build(), out))
+	c, err := cid.Prefix{Version: 1, Codec: codecType, MhType: multihash.SHA2_256, MhLength: 32}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), c)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(context.Background(), blk))
+	return c
+}
+
+// TestResolveIPLDToUnixFS resolves paths through the maps and the lists of
+// dag-cbor and dag-json nodes, and the links from them to UnixFS nodes.
+func TestResolveIPLDToUnixFS(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+
+	file := merkledag.NodeWithData(ft.FilePBData([]byte("image"), 5))
+	require.NoError(t, bsrv.AddBlock(ctx, file))
+	dir := ft.EmptyDirNode()
+	require.NoError(t, dir.AddNodeLink("image.png", file))
+	require.NoError(t, bsrv.AddBlock(ctx, dir))
+
+	doc := addIPLDBlock(t, bsrv, cid.DagJSON, dagjson.Encode, `{"images": [{"/": "`+file.Cid().String()+`"}]}`)
+	meta := addIPLDBlock(t, bsrv, cid.DagCBOR, dagcbor.Encode, `{"métadonnées": {"files": [0, {"dir": {"/": "`+dir.Cid().String()+`"}}], "doc": {"/": "`+doc.String()+`"}, "n": 1}}`)
+
+	r := newUnixFSResolver(bsrv)
+	for _, tc := range []struct {
+		segments  []string
+		cid       cid.Cid
+		remainder []string
+	}{
+		{[]string{"métadonnées", "files", "1", "dir", "image.png"}, file.Cid(), []string{}},
+		{[]string{"métadonnées", "files", "1", "dir"}, dir.Cid(), []string{}},
+		{[]string{"métadonnées", "doc", "images", "0"}, file.Cid(), []string{}},
+		{[]string{"métadonnées", "doc", "images"}, doc, []string{"images"}},
+		{[]string{"métadonnées", "files", "0"}, meta, []string{"métadonnées", "files", "0"}},
+	} {
+		p := fixturePath(t, meta, tc.segments...)
+		c, remainder, err := r.ResolveToLastNode(ctx, p)
+		require.NoError(t, err, p)
+		require.Equal(t, tc.cid, c, p)
+		require.Equal(t, tc.remainder, remainder, p)
+	}
+
+	// the UnixFS semantics are resumed past the links to dag-pb
+	nd, lnk, err := r.ResolvePath(ctx, fixturePath(t, meta, "métadonnées", "files", "1", "dir", "image.png"))
+	require.NoError(t, err)
+	require.Equal(t, file.Cid().String(), lnk.String())
+	data, err := nd.AsBytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte("image"), data)
+
+	// the errors name the segment not found, and the block it is looked up in
+	for _, tc := range []struct {
+		segments []string
+		name     string
+		node     cid.Cid
+	}{
+		{[]string{"missing"}, "missing", meta},
+		{[]string{"métadonnées", "missing", "x"}, "missing", meta},
+		// a list index out of range, or which is not an integer
+		{[]string{"métadonnées", "files", "2"}, "2", meta},
+		{[]string{"métadonnées", "files", "x"}, "x", meta},
+		{[]string{"métadonnées", "files", "x", "dir"}, "x", meta},
+		// indexing a scalar
+		{[]string{"métadonnées", "n", "x"}, "x", meta},
+		{[]string{"métadonnées", "n", "x", "y"}, "x", meta},
+		{[]string{"métadonnées", "files", "1", "dir", "missing.png"}, "missing.png", dir.Cid()},
+		{[]string{"métadonnées", "files", "1", "dir", "image.png", "x"}, "x", file.Cid()},
+		{[]string{"métadonnées", "doc", "images", "0", "x"}, "x", file.Cid()},
+	} {
+		p := fixturePath(t, meta, tc.segments...)
+		_, _, err := r.ResolveToLastNode(ctx, p)
+		require.ErrorIs(t, err, &resolver.ErrNoLink{}, p)
+		var nerr *resolver.ErrNoLink
+		require.ErrorAs(t, err, &nerr, p)
+		require.Equal(t, &resolver.ErrNoLink{Name: tc.name, Node: tc.node}, nerr, p)
+	}
+}
@@ -3,6 +3,7 @@ package resolver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	cid "github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/schema"
@@ -67,13 +69,32 @@ type Resolver interface {
 // which is used to resolve the nodes.
 type basicResolver struct {
 	FetcherFactory fetcher.Factory
+
+	maxSymlinks      int
+	symlinkPolicy    SymlinkPolicy
+	linkCache        *LinkCache
+	maxPathDepth     int
+	maxBlocksFetched int
 }
 
 // NewBasicResolver constructs a new basic resolver using the given [fetcher.Factory].
-func NewBasicResolver(factory fetcher.Factory) Resolver {
-	return &basicResolver{
-		FetcherFactory: factory,
+//
+// The UnixFS symlinks met before the last segment of a path are followed, and
+// the rest of the path is resolved from their target.
+//
+// The depth of the paths and the blocks fetched to resolve them are limited,
+// see [WithMaxPathDepth] and [WithMaxBlocksFetched].
+func NewBasicResolver(factory fetcher.Factory, opts ...Option) Resolver {
+	r := &basicResolver{
+		FetcherFactory:   factory,
+		maxSymlinks:      DefaultMaxSymlinks,
+		maxPathDepth:     DefaultMaxPathDepth,
+		maxBlocksFetched: DefaultMaxBlocksFetched,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // ResolveToLastNode implements [Resolver.ResolveToLastNode].
@@ -81,12 +102,44 @@ func (r *basicResolver) ResolveToLastNode(ctx context.Context, fpath path.Immuta
 	ctx, span := startSpan(ctx, "basicResolver.ResolveToLastNode", trace.WithAttributes(attribute.Stringer("Path", fpath)))
 	defer span.End()
 
+	res, err := r.resolveWithTrace(ctx, fpath)
+	return res.Cid, res.Remainder, err
+}
+
+func (r *basicResolver) resolveWithTrace(ctx context.Context, fpath path.ImmutablePath) (Result, error) {
+	ctx = r.withBlockBudget(ctx)
+	for jumps := 0; ; jumps++ {
+		if err := r.checkDepth(ctx, fpath); err != nil {
+			return Result{}, err
+		}
+		res, err := r.resolveToLastNode(ctx, fpath)
+		if !errors.Is(err, &ErrNoLink{}) {
+			return res, err
+		}
+		next, ok, serr := r.followSymlink(ctx, fpath, jumps)
+		if serr != nil || !ok {
+			return res, symlinkErr(err, serr)
+		}
+		fpath = next
+	}
+}
+
+func (r *basicResolver) resolveToLastNode(ctx context.Context, fpath path.ImmutablePath) (Result, error) {
 	c, remainder := fpath.RootCid(), fpath.Segments()[2:]
 
 	if len(remainder) == 0 {
-		return c, nil, nil
+		return Result{Cid: c}, nil
 	}
 
+	// follow the links already in the cache, and resolve the rest of the path
+	// from the last one
+	c, cached := r.cachedPrefix(c, remainder)
+	if len(cached) == len(remainder) {
+		return Result{Cid: c, Remainder: []string{}, Trace: cached}, nil
+	}
+	root := c
+	remainder = remainder[len(cached):]
+
 	// create a selector to traverse and match all path segments
 	pathSelector := pathAllSelector(remainder[:len(remainder)-1])
 
@@ -95,47 +148,90 @@ func (r *basicResolver) ResolveToLastNode(ctx context.Context, fpath path.Immuta
 	defer cancel()
 
 	// resolve node before last path segment
-	nodes, lastCid, depth, err := r.resolveNodes(ctx, c, pathSelector)
+	nodes, blocks, depth, err := r.resolveNodes(ctx, c, pathSelector)
 	if err != nil {
-		return cid.Cid{}, nil, err
+		return Result{}, budgetErr(err, fpath, append(cached, segmentsTrace(remainder, blocks)...))
 	}
 
 	if len(nodes) < 1 {
-		return cid.Cid{}, nil, fmt.Errorf("path %v did not resolve to a node", fpath)
-	} else if len(nodes) < len(remainder) {
-		return cid.Undef, nil, &ErrNoLink{Name: remainder[len(nodes)-1], Node: lastCid}
+		return Result{}, fmt.Errorf("path %v did not resolve to a node", fpath)
+	}
+	lastCid := blocks[len(blocks)-1]
+	if len(nodes) < len(remainder) {
+		return Result{}, &ErrNoLink{Name: remainder[len(nodes)-1], Node: lastCid}
 	}
 
+	// the first node is the root, and the others the segments before the last
+	trace := make([]ResolvedSegment, 0, len(cached)+len(remainder))
+	trace = append(trace, cached...)
+	trace = append(trace, segmentsTrace(remainder, blocks)...)
+
 	parent := nodes[len(nodes)-1]
 	lastSegment := remainder[len(remainder)-1]
 
 	// find final path segment within node
 	nd, err := parent.LookupBySegment(ipld.ParsePathSegment(lastSegment))
-	switch err.(type) {
-	case nil:
-	case schema.ErrNoSuchField:
-		return cid.Undef, nil, &ErrNoLink{Name: lastSegment, Node: lastCid}
-	default:
-		return cid.Cid{}, nil, err
+	if isLookupNotFound(err) {
+		log.Debugw("cannot look up the last segment", "path", fpath, "error", err)
+		return Result{}, &ErrNoLink{Name: lastSegment, Node: lastCid}
+	} else if err != nil {
+		return Result{}, budgetErr(err, fpath, trace)
 	}
 
+	var res Result
 	// if last node is not a link, just return it's cid, add path to remainder and return
 	if nd.Kind() != ipld.Kind_Link {
 		// return the cid and the remainder of the path
-		return lastCid, remainder[len(remainder)-depth-1:], nil
-	}
+		res = Result{
+			Cid:       lastCid,
+			Remainder: remainder[len(remainder)-depth-1:],
+			Trace:     append(trace, ResolvedSegment{Name: lastSegment, Cid: lastCid}),
+		}
+	} else {
+		lnk, err := nd.AsLink()
+		if err != nil {
+			return Result{}, err
+		}
 
-	lnk, err := nd.AsLink()
-	if err != nil {
-		return cid.Cid{}, nil, err
-	}
+		clnk, ok := lnk.(cidlink.Link)
+		if !ok {
+			return Result{}, fmt.Errorf("path %v resolves to a link that is not a cid link: %v", fpath, lnk)
+		}
 
-	clnk, ok := lnk.(cidlink.Link)
-	if !ok {
-		return cid.Cid{}, nil, fmt.Errorf("path %v resolves to a link that is not a cid link: %v", fpath, lnk)
+		res = Result{
+			Cid:       clnk.Cid,
+			Remainder: []string{},
+			Trace:     append(trace, ResolvedSegment{Name: lastSegment, Cid: clnk.Cid}),
+		}
 	}
 
-	return clnk.Cid, []string{}, nil
+	r.cacheLinks(root, res.Trace[len(cached):])
+	return res, nil
+}
+
+// isLookupNotFound reports whether err is the error of the lookup of a segment
+// which does not exist in a node: a missing map key or list index, a segment
+// which is not an index in a list, or a lookup in a node which is neither a
+// map nor a list.
+func isLookupNotFound(err error) bool {
+	var (
+		noSuchField   schema.ErrNoSuchField
+		notExists     datamodel.ErrNotExists
+		wrongKind     datamodel.ErrWrongKind
+		invalidInList datamodel.ErrInvalidSegmentForList
+	)
+	return errors.As(err, &noSuchField) || errors.As(err, &notExists) ||
+		errors.As(err, &wrongKind) || errors.As(err, &invalidInList)
+}
+
+// segmentsTrace returns the trace of the segments matched by the nodes of the
+// blocks returned by resolveNodes, the first of which is the root.
+func segmentsTrace(segments []string, blocks []cid.Cid) []ResolvedSegment {
+	var trace []ResolvedSegment
+	for i := 1; i < len(blocks) && i <= len(segments); i++ {
+		trace = append(trace, ResolvedSegment{Name: segments[i-1], Cid: blocks[i]})
+	}
+	return trace
 }
 
 // ResolvePath implements [Resolver.ResolvePath].
@@ -146,19 +242,31 @@ func (r *basicResolver) ResolvePath(ctx context.Context, fpath path.ImmutablePat
 	ctx, span := startSpan(ctx, "basicResolver.ResolvePath", trace.WithAttributes(attribute.Stringer("Path", fpath)))
 	defer span.End()
 
-	c, remainder := fpath.RootCid(), fpath.Segments()[2:]
+	ctx = r.withBlockBudget(ctx)
+	for jumps := 0; ; jumps++ {
+		if err := r.checkDepth(ctx, fpath); err != nil {
+			return nil, nil, err
+		}
+		c, remainder := fpath.RootCid(), fpath.Segments()[2:]
 
-	// create a selector to traverse all path segments but only match the last
-	pathSelector := pathLeafSelector(remainder)
+		// create a selector to traverse all path segments but only match the last
+		pathSelector := pathLeafSelector(remainder)
 
-	nodes, c, _, err := r.resolveNodes(ctx, c, pathSelector)
-	if err != nil {
-		return nil, nil, err
-	}
-	if len(nodes) < 1 {
-		return nil, nil, fmt.Errorf("path %v did not resolve to a node", fpath)
+		nodes, blocks, _, err := r.resolveNodes(ctx, c, pathSelector)
+		if err != nil {
+			return nil, nil, budgetErr(err, fpath, nil)
+		}
+		if len(nodes) >= 1 {
+			return nodes[len(nodes)-1], cidlink.Link{Cid: blocks[len(blocks)-1]}, nil
+		}
+
+		err = fmt.Errorf("path %v did not resolve to a node", fpath)
+		next, ok, serr := r.followSymlink(ctx, fpath, jumps)
+		if serr != nil || !ok {
+			return nil, nil, symlinkErr(err, serr)
+		}
+		fpath = next
 	}
-	return nodes[len(nodes)-1], cidlink.Link{Cid: c}, nil
 }
 
 // ResolvePathComponents implements [Resolver.ResolvePathComponents].
@@ -171,18 +279,38 @@ func (r *basicResolver) ResolvePathComponents(ctx context.Context, fpath path.Im
 
 	defer log.Debugw("resolvePathComponents", "fpath", fpath, "error", err)
 
-	c, remainder := fpath.RootCid(), fpath.Segments()[2:]
+	ctx = r.withBlockBudget(ctx)
+	for jumps := 0; ; jumps++ {
+		if err = r.checkDepth(ctx, fpath); err != nil {
+			return nil, err
+		}
+		c, remainder := fpath.RootCid(), fpath.Segments()[2:]
 
-	// create a selector to traverse and match all path segments
-	pathSelector := pathAllSelector(remainder)
+		// create a selector to traverse and match all path segments
+		pathSelector := pathAllSelector(remainder)
+
+		var blocks []cid.Cid
+		nodes, blocks, _, err = r.resolveNodes(ctx, c, pathSelector)
+		if err != nil {
+			return nil, budgetErr(err, fpath, segmentsTrace(remainder, blocks))
+		}
+		if len(nodes) > len(remainder) {
+			return nodes, nil
+		}
 
-	nodes, _, _, err = r.resolveNodes(ctx, c, pathSelector)
-	return nodes, err
+		// the path is resolved partially
+		next, ok, serr := r.followSymlink(ctx, fpath, jumps)
+		if serr != nil || !ok {
+			return nodes, symlinkErr(nil, serr)
+		}
+		fpath = next
+	}
 }
 
-// Finds nodes matching the selector starting with a cid. Returns the matched nodes, the cid of the block containing
-// the last node, and the depth of the last node within its block (root is depth 0).
-func (r *basicResolver) resolveNodes(ctx context.Context, c cid.Cid, sel ipld.Node) ([]ipld.Node, cid.Cid, int, error) {
+// Finds nodes matching the selector starting with a cid. Returns the matched nodes, the cids of the blocks containing
+// each of them, and the depth of the last node within its block (root is depth 0). On error, the blocks of the nodes
+// matched before the error are returned.
+func (r *basicResolver) resolveNodes(ctx context.Context, c cid.Cid, sel ipld.Node) ([]ipld.Node, []cid.Cid, int, error) {
 	ctx, span := startSpan(ctx, "basicResolver.resolveNodes", trace.WithAttributes(attribute.Stringer("CID", c)))
 	defer span.End()
 	session := r.FetcherFactory.NewSession(ctx)
@@ -191,6 +319,7 @@ func (r *basicResolver) resolveNodes(ctx context.Context, c cid.Cid, sel ipld.No
 	lastLink := cid.Undef
 	depth := 0
 	nodes := []ipld.Node{}
+	var blocks []cid.Cid
 	err := fetcherhelpers.BlockMatching(ctx, session, cidlink.Link{Cid: c}, sel, func(res fetcher.FetchResult) error {
 		if res.LastBlockLink == nil {
 			res.LastBlockLink = cidlink.Link{Cid: c}
@@ -209,13 +338,14 @@ func (r *basicResolver) resolveNodes(ctx context.Context, c cid.Cid, sel ipld.No
 		}
 
 		nodes = append(nodes, res.Node)
+		blocks = append(blocks, lastLink)
 		return nil
 	})
 	if err != nil {
-		return nil, cid.Undef, 0, err
+		return nil, blocks, 0, err
 	}
 
-	return nodes, lastLink, depth, nil
+	return nodes, blocks, depth, nil
 }
 
 func pathLeafSelector(path []string) ipld.Node {
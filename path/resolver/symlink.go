@@ -0,0 +1,192 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// DefaultMaxSymlinks is the default maximum number of symlinks followed to
+// resolve a path.
+const DefaultMaxSymlinks = 40
+
+// ErrTooManySymlinks is returned when resolving a path follows more symlinks
+// than the maximum, which is the case of the symlinks which loop.
+type ErrTooManySymlinks struct {
+	Path path.ImmutablePath
+}
+
+// Error implements the [errors.Error] interface.
+func (e *ErrTooManySymlinks) Error() string {
+	return fmt.Sprintf("too many symlinks followed resolving %s", e.Path)
+}
+
+// Is implements [errors.Is] interface.
+func (e *ErrTooManySymlinks) Is(err error) bool {
+	switch err.(type) {
+	case *ErrTooManySymlinks:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrSymlinkEscape is returned when a symlink points above the root of the
+// path resolved, or to an absolute path not allowed by the [SymlinkPolicy].
+type ErrSymlinkEscape struct {
+	Name   string
+	Target string
+}
+
+// Error implements the [errors.Error] interface.
+func (e *ErrSymlinkEscape) Error() string {
+	return fmt.Sprintf("symlink %q points outside of the path root: %q", e.Name, e.Target)
+}
+
+// Is implements [errors.Is] interface.
+func (e *ErrSymlinkEscape) Is(err error) bool {
+	switch err.(type) {
+	case *ErrSymlinkEscape:
+		return true
+	default:
+		return false
+	}
+}
+
+// SymlinkPolicy selects the absolute symlink targets which are followed.
+type SymlinkPolicy int
+
+const (
+	// SymlinkRelativeOnly only follows the relative targets, within the root
+	// of the path resolved.
+	SymlinkRelativeOnly SymlinkPolicy = iota
+	// SymlinkAllowIPFS also follows the absolute /ipfs/ targets, resolving
+	// the rest of the path from them.
+	SymlinkAllowIPFS
+)
+
+// Option configures the resolver built by [NewBasicResolver].
+type Option func(*basicResolver)
+
+// WithMaxSymlinks sets the maximum number of symlinks followed to resolve a
+// path. Zero does not follow symlinks. It defaults to [DefaultMaxSymlinks].
+func WithMaxSymlinks(n int) Option {
+	return func(r *basicResolver) {
+		r.maxSymlinks = n
+	}
+}
+
+// WithSymlinkPolicy sets the absolute symlink targets which are followed. It
+// defaults to [SymlinkRelativeOnly].
+func WithSymlinkPolicy(policy SymlinkPolicy) Option {
+	return func(r *basicResolver) {
+		r.symlinkPolicy = policy
+	}
+}
+
+// followSymlink returns the path with its first symlink, which is not the last
+// segment, replaced by its target, if there is one. jumps is the number of
+// symlinks already followed.
+func (r *basicResolver) followSymlink(ctx context.Context, fpath path.ImmutablePath, jumps int) (path.ImmutablePath, bool, error) {
+	segments := fpath.Segments()
+	remainder := segments[2:]
+	if r.maxSymlinks <= 0 || len(remainder) < 2 {
+		return path.ImmutablePath{}, false, nil
+	}
+
+	// the symlinks of the last segment are not followed
+	nodes, _, _, err := r.resolveNodes(ctx, fpath.RootCid(), pathAllSelector(remainder[:len(remainder)-1]))
+	if err != nil {
+		return path.ImmutablePath{}, false, budgetErr(err, fpath, nil)
+	}
+	for i := 1; i < len(nodes); i++ {
+		target, ok := symlinkTarget(nodes[i])
+		if !ok {
+			continue
+		}
+		if jumps >= r.maxSymlinks {
+			return path.ImmutablePath{}, false, &ErrTooManySymlinks{Path: fpath}
+		}
+		name, rest := remainder[i-1], remainder[i:]
+		log.Debugw("following symlink", "path", fpath, "name", name, "target", target)
+
+		var p path.Path
+		if strings.HasPrefix(target, "/") {
+			if r.symlinkPolicy != SymlinkAllowIPFS || !strings.HasPrefix(target, "/"+path.IPFSNamespace+"/") {
+				return path.ImmutablePath{}, false, &ErrSymlinkEscape{Name: name, Target: target}
+			}
+			p, err = path.NewPath(target)
+			if err == nil {
+				p, err = path.Join(p, rest...)
+			}
+		} else {
+			dir, ok := resolveRelative(remainder[:i-1], target)
+			if !ok {
+				return path.ImmutablePath{}, false, &ErrSymlinkEscape{Name: name, Target: target}
+			}
+			p, err = path.NewPathFromSegments(append(append(segments[:2:2], dir...), rest...)...)
+		}
+		if err != nil {
+			return path.ImmutablePath{}, false, fmt.Errorf("invalid target of symlink %q: %w", name, err)
+		}
+		ip, err := path.NewImmutablePath(p)
+		if err != nil {
+			return path.ImmutablePath{}, false, fmt.Errorf("invalid target of symlink %q: %w", name, err)
+		}
+		return ip, true, nil
+	}
+	return path.ImmutablePath{}, false, nil
+}
+
+// resolveRelative returns the segments of the target relative to the
+// directory dir, or false if it is above the root.
+func resolveRelative(dir []string, target string) ([]string, bool) {
+	segments := append([]string(nil), dir...)
+	for _, s := range strings.Split(target, "/") {
+		switch s {
+		case "", ".":
+		case "..":
+			if len(segments) == 0 {
+				return nil, false
+			}
+			segments = segments[:len(segments)-1]
+		default:
+			segments = append(segments, s)
+		}
+	}
+	return segments, true
+}
+
+// symlinkTarget returns the target of nd if it is a UnixFS symlink.
+func symlinkTarget(nd ipld.Node) (string, bool) {
+	pbnd, ok := nd.(interface{ FieldData() dagpb.MaybeBytes })
+	if !ok || !pbnd.FieldData().Exists() {
+		return "", false
+	}
+	ufsData, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	if err != nil || ufsData.FieldDataType().Int() != data.Data_Symlink {
+		return "", false
+	}
+	if !ufsData.FieldData().Exists() {
+		return "", true
+	}
+	return string(ufsData.FieldData().Must().Bytes()), true
+}
+
+// symlinkErr returns the error of following a symlink, if it is one of the
+// symlink or budget errors, or the error of the resolution.
+func symlinkErr(err, serr error) error {
+	if errors.Is(serr, &ErrTooManySymlinks{}) || errors.Is(serr, &ErrSymlinkEscape{}) || errors.Is(serr, &ErrBudgetExceeded{}) {
+		return serr
+	}
+	if serr != nil {
+		log.Debugw("cannot follow symlinks", "error", serr)
+	}
+	return err
+}
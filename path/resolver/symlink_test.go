@@ -0,0 +1,184 @@
+package resolver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/blockservice"
+	bsfetcher "github.com/ipfs/boxo/fetcher/impl/blockservice"
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	dagmock "github.com/ipfs/boxo/ipld/merkledag/test"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/boxo/path/resolver"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func unixFSFetcherConfig(bsrv blockservice.BlockService) bsfetcher.FetcherConfig {
+	fetcherFactory := bsfetcher.NewFetcherConfig(bsrv)
+	fetcherFactory.NodeReifier = unixfsnode.Reify
+	fetcherFactory.PrototypeChooser = dagpb.AddSupportToChooser(func(lnk ipld.Link, lnkCtx ipld.LinkContext) (ipld.NodePrototype, error) {
+		if tlnkNd, ok := lnkCtx.LinkNode.(schema.TypedLinkNode); ok {
+			return tlnkNd.LinkTargetNodePrototype(), nil
+		}
+		return basicnode.Prototype.Any, nil
+	})
+	return fetcherFactory
+}
+
+func newUnixFSResolver(bsrv blockservice.BlockService, opts ...resolver.Option) resolver.Resolver {
+	return resolver.NewBasicResolver(unixFSFetcherConfig(bsrv), opts...)
+}
+
+type symlinkFixture struct {
+	root, file, otherFile, rel cid.Cid
+}
+
+// makeSymlinkFixture makes a directory with:
+//
+//	a/b/f
+//	a/up -> ../a/b
+//	rel -> a
+//	chain1 -> chain2 -> a
+//	loop1 -> loop2 -> loop1
+//	escape -> ../..
+//	abs -> /ipfs/{other}, other containing b/f
+func makeSymlinkFixture(t *testing.T, bsrv blockservice.BlockService) symlinkFixture {
+	ctx := context.Background()
+	add := func(nd *merkledag.ProtoNode) *merkledag.ProtoNode {
+		require.NoError(t, bsrv.AddBlock(ctx, nd))
+		return nd
+	}
+	dir := func(links map[string]*merkledag.ProtoNode) *merkledag.ProtoNode {
+		nd := ft.EmptyDirNode()
+		for name, child := range links {
+			require.NoError(t, nd.AddNodeLink(name, child))
+		}
+		return add(nd)
+	}
+	symlink := func(target string) *merkledag.ProtoNode {
+		data, err := ft.SymlinkData(target)
+		require.NoError(t, err)
+		return add(merkledag.NodeWithData(data))
+	}
+
+	file := add(merkledag.NodeWithData(ft.FilePBData([]byte("f"), 1)))
+	otherFile := add(merkledag.NodeWithData(ft.FilePBData([]byte("other"), 5)))
+	other := dir(map[string]*merkledag.ProtoNode{"b": dir(map[string]*merkledag.ProtoNode{"f": otherFile})})
+	rel := symlink("a")
+	root := dir(map[string]*merkledag.ProtoNode{
+		"a": dir(map[string]*merkledag.ProtoNode{
+			"b":  dir(map[string]*merkledag.ProtoNode{"f": file}),
+			"up": symlink("../a/b"),
+		}),
+		"rel":    rel,
+		"chain1": symlink("chain2"),
+		"chain2": symlink("./a/"),
+		"loop1":  symlink("loop2"),
+		"loop2":  symlink("loop1"),
+		"escape": symlink("../.."),
+		"abs":    symlink("/ipfs/" + other.Cid().String()),
+	})
+	return symlinkFixture{
+		root:      root.Cid(),
+		file:      file.Cid(),
+		otherFile: otherFile.Cid(),
+		rel:       rel.Cid(),
+	}
+}
+
+func fixturePath(t *testing.T, root cid.Cid, segments ...string) path.ImmutablePath {
+	p, err := path.Join(path.FromCid(root), segments...)
+	require.NoError(t, err)
+	ip, err := path.NewImmutablePath(p)
+	require.NoError(t, err)
+	return ip
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	f := makeSymlinkFixture(t, bsrv)
+	r := newUnixFSResolver(bsrv)
+
+	for _, segments := range [][]string{
+		{"a", "b", "f"},
+		{"rel", "b", "f"},
+		{"a", "up", "f"},
+		{"chain1", "b", "f"},
+		{"chain1", "up", "f"},
+	} {
+		p := fixturePath(t, f.root, segments...)
+
+		c, remainder, err := r.ResolveToLastNode(ctx, p)
+		require.NoError(t, err, p)
+		require.Equal(t, f.file, c, p)
+		require.Empty(t, remainder, p)
+
+		_, lnk, err := r.ResolvePath(ctx, p)
+		require.NoError(t, err, p)
+		require.Equal(t, cidlink.Link{Cid: f.file}, lnk, p)
+
+		nodes, err := r.ResolvePathComponents(ctx, p)
+		require.NoError(t, err, p)
+		require.Len(t, nodes, 4, p)
+	}
+
+	// the symlink of the last segment is not followed
+	c, _, err := r.ResolveToLastNode(ctx, fixturePath(t, f.root, "rel"))
+	require.NoError(t, err)
+	require.Equal(t, f.rel, c)
+}
+
+func TestResolveSymlinkErrors(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	f := makeSymlinkFixture(t, bsrv)
+	r := newUnixFSResolver(bsrv)
+
+	p := fixturePath(t, f.root, "loop1", "f")
+	_, _, err := r.ResolveToLastNode(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrTooManySymlinks{})
+	_, _, err = r.ResolvePath(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrTooManySymlinks{})
+	_, err = r.ResolvePathComponents(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrTooManySymlinks{})
+
+	// a chain longer than the maximum
+	_, _, err = newUnixFSResolver(bsrv, resolver.WithMaxSymlinks(1)).ResolveToLastNode(ctx, fixturePath(t, f.root, "chain1", "b", "f"))
+	require.ErrorIs(t, err, &resolver.ErrTooManySymlinks{})
+
+	_, _, err = r.ResolveToLastNode(ctx, fixturePath(t, f.root, "escape", "f"))
+	require.ErrorIs(t, err, &resolver.ErrSymlinkEscape{})
+
+	// the symlinks are not followed without jumps
+	_, _, err = newUnixFSResolver(bsrv, resolver.WithMaxSymlinks(0)).ResolveToLastNode(ctx, fixturePath(t, f.root, "rel", "b", "f"))
+	require.ErrorIs(t, err, &resolver.ErrNoLink{})
+}
+
+func TestResolveAbsoluteSymlink(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	f := makeSymlinkFixture(t, bsrv)
+	p := fixturePath(t, f.root, "abs", "b", "f")
+
+	_, _, err := newUnixFSResolver(bsrv).ResolveToLastNode(ctx, p)
+	require.ErrorIs(t, err, &resolver.ErrSymlinkEscape{})
+
+	r := newUnixFSResolver(bsrv, resolver.WithSymlinkPolicy(resolver.SymlinkAllowIPFS))
+	c, _, err := r.ResolveToLastNode(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, f.otherFile, c)
+
+	nodes, err := r.ResolvePathComponents(ctx, p)
+	require.NoError(t, err)
+	// the components are the ones of the path from the target
+	require.Len(t, nodes, 3)
+}
@@ -0,0 +1,56 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ResolvedSegment is a segment of a resolved path, and the CID of the block it
+// resolved to.
+type ResolvedSegment struct {
+	Name string
+	// Cid is the CID of the block linked by the segment, or of the block which
+	// contains it if the segment is not a link, as for the segments within a
+	// dag-cbor node. The segments of a HAMT sharded directory resolve to the
+	// block of their entry: the blocks of the shards are not part of the trace.
+	Cid cid.Cid
+}
+
+// Result is the result of [TracingResolver.ResolveToLastNodeWithTrace].
+type Result struct {
+	// Cid and Remainder are the values returned by
+	// [Resolver.ResolveToLastNode]: the CID of the last block of the path, and
+	// the segments of the path within it.
+	Cid       cid.Cid
+	Remainder []string
+
+	// Trace has an entry for each segment of the path after its root, in
+	// order. If symlinks are followed, they are the segments of the path
+	// with the symlinks replaced by their targets.
+	Trace []ResolvedSegment
+}
+
+// TracingResolver is a [Resolver] which also reports the blocks traversed to
+// resolve a path.
+type TracingResolver interface {
+	Resolver
+
+	// ResolveToLastNodeWithTrace resolves the path as
+	// [Resolver.ResolveToLastNode], and returns the block resolved by each of
+	// its segments too.
+	ResolveToLastNodeWithTrace(context.Context, path.ImmutablePath) (Result, error)
+}
+
+var _ TracingResolver = (*basicResolver)(nil)
+
+// ResolveToLastNodeWithTrace implements [TracingResolver.ResolveToLastNodeWithTrace].
+func (r *basicResolver) ResolveToLastNodeWithTrace(ctx context.Context, fpath path.ImmutablePath) (Result, error) {
+	ctx, span := startSpan(ctx, "basicResolver.ResolveToLastNodeWithTrace", trace.WithAttributes(attribute.Stringer("Path", fpath)))
+	defer span.End()
+
+	return r.resolveWithTrace(ctx, fpath)
+}
@@ -0,0 +1,116 @@
+package resolver_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	dagmock "github.com/ipfs/boxo/ipld/merkledag/test"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
+	"github.com/ipfs/boxo/path/resolver"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dagcbor "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	dagjson "github.com/ipld/go-ipld-prime/codec/dagjson"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveToLastNodeWithTrace(t *testing.T) {
+	ctx := context.Background()
+	bsrv := dagmock.Bserv()
+	dserv := merkledag.NewDAGService(bsrv)
+
+	add := func(nd *merkledag.ProtoNode) *merkledag.ProtoNode {
+		require.NoError(t, bsrv.AddBlock(ctx, nd))
+		return nd
+	}
+	dir := func(name string, child *merkledag.ProtoNode) *merkledag.ProtoNode {
+		nd := ft.EmptyDirNode()
+		require.NoError(t, nd.AddNodeLink(name, child))
+		return add(nd)
+	}
+
+	// root/a/b/c/f, where a is a HAMT sharded directory with enough entries
+	// to have shards under its root
+	f := add(merkledag.NodeWithData(ft.FilePBData([]byte("f"), 1)))
+	c := dir("f", f)
+	b := dir("c", c)
+	shard, err := hamt.NewShard(dserv, 16)
+	require.NoError(t, err)
+	require.NoError(t, shard.Set(ctx, "b", b))
+	for i := 0; i < 64; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("file-%d", i), f))
+	}
+	a, err := shard.Node()
+	require.NoError(t, err)
+	var subShards int
+	for _, lnk := range a.Links() {
+		if len(lnk.Name) == 1 {
+			subShards++
+		}
+	}
+	require.NotZero(t, subShards)
+
+	// root/d/foo/bar, where d is a dag-cbor node
+	nb := basicnode.Prototype.Any.NewBuilder()
+	require.NoError(t, dagjson.Decode(nb, strings.NewReader(`{"foo": {"bar": "baz"}}`)))
+	out := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encode(nb.Build(), out))
+	d, err := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: 32}.Sum(out.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(out.Bytes(), d)
+	require.NoError(t, err)
+	require.NoError(t, bsrv.AddBlock(ctx, blk))
+
+	root := ft.EmptyDirNode()
+	require.NoError(t, root.AddNodeLink("a", a))
+	require.NoError(t, root.AddRawLink("d", &ipld.Link{Cid: d}))
+	add(root)
+
+	r := newUnixFSResolver(bsrv).(resolver.TracingResolver)
+
+	res, err := r.ResolveToLastNodeWithTrace(ctx, fixturePath(t, root.Cid(), "a", "b", "c", "f"))
+	require.NoError(t, err)
+	require.Equal(t, resolver.Result{
+		Cid:       f.Cid(),
+		Remainder: []string{},
+		Trace: []resolver.ResolvedSegment{
+			{Name: "a", Cid: a.Cid()},
+			{Name: "b", Cid: b.Cid()},
+			{Name: "c", Cid: c.Cid()},
+			{Name: "f", Cid: f.Cid()},
+		},
+	}, res)
+
+	res, err = r.ResolveToLastNodeWithTrace(ctx, fixturePath(t, root.Cid(), "d", "foo", "bar"))
+	require.NoError(t, err)
+	require.Equal(t, resolver.Result{
+		Cid:       d,
+		Remainder: []string{"foo", "bar"},
+		Trace: []resolver.ResolvedSegment{
+			{Name: "d", Cid: d},
+			{Name: "foo", Cid: d},
+			{Name: "bar", Cid: d},
+		},
+	}, res)
+
+	// the trace agrees with ResolveToLastNode
+	lastCid, remainder, err := r.ResolveToLastNode(ctx, fixturePath(t, root.Cid(), "d", "foo", "bar"))
+	require.NoError(t, err)
+	require.Equal(t, res.Cid, lastCid)
+	require.Equal(t, res.Remainder, remainder)
+
+	res, err = r.ResolveToLastNodeWithTrace(ctx, fixturePath(t, root.Cid()))
+	require.NoError(t, err)
+	require.Equal(t, resolver.Result{Cid: root.Cid()}, res)
+
+	_, err = r.ResolveToLastNodeWithTrace(ctx, fixturePath(t, root.Cid(), "a", "missing", "f"))
+	require.ErrorIs(t, err, &resolver.ErrNoLink{})
+}
@@ -0,0 +1,92 @@
+package peering
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithMetrics registers the metrics of the peering service with reg:
+//
+//   - ipfs_peering_connected_peers is the number of peers of the service
+//     which are connected.
+//   - ipfs_peering_reconnect_attempts_total counts the reconnections to the
+//     peers, and ipfs_peering_reconnect_failures_total the ones which failed.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(ps *PeeringService) {
+		ps.metrics = newMetrics(reg, ps)
+	}
+}
+
+// metrics is nil when WithMetrics is not used, all its methods are no-ops
+// then.
+type metrics struct {
+	attempts prometheus.Counter
+	failures prometheus.Counter
+}
+
+func newMetrics(reg prometheus.Registerer, ps *PeeringService) *metrics {
+	connected := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "ipfs",
+			Subsystem: "peering",
+			Name:      "connected_peers",
+			Help:      "The number of peers of the peering service which are connected.",
+		},
+		func() float64 {
+			return float64(ps.countPeers(PeeringStateConnected))
+		},
+	)
+	m := &metrics{
+		attempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "peering",
+			Name:      "reconnect_attempts_total",
+			Help:      "The number of reconnections to the peers of the peering service.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "peering",
+			Name:      "reconnect_failures_total",
+			Help:      "The number of reconnections to the peers of the peering service which failed.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{connected, m.attempts, m.failures} {
+		if err := reg.Register(c); err != nil {
+			logger.Errorf("failed to register peering metrics: %v", err)
+		}
+	}
+	return m
+}
+
+func (m *metrics) reconnectAttempt() {
+	if m == nil {
+		return
+	}
+	m.attempts.Inc()
+}
+
+func (m *metrics) reconnectFailure() {
+	if m == nil {
+		return
+	}
+	m.failures.Inc()
+}
+
+// countPeers returns the number of peers in the state s.
+func (ps *PeeringService) countPeers(s PeeringState) int {
+	ps.mu.RLock()
+	handlers := make([]*peerHandler, 0, len(ps.peers))
+	for _, handler := range ps.peers {
+		handlers = append(handlers, handler)
+	}
+	ps.mu.RUnlock()
+
+	var n int
+	for _, handler := range handlers {
+		handler.mu.Lock()
+		if handler.state == s {
+			n++
+		}
+		handler.mu.Unlock()
+	}
+	return n
+}
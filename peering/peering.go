@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -23,11 +24,12 @@ const (
 	// between 90-100% of the max backoff.
 	maxBackoffJitter = 10 // %
 	connmgrTag       = "ipfs-peering"
-	// This needs to be sufficient to prevent two sides from simultaneously
-	// dialing.
-	initialDelay = 5 * time.Second
 )
 
+// This needs to be sufficient to prevent two sides from simultaneously
+// dialing.
+var initialDelay = 5 * time.Second
+
 var logger = log.Logger("peering")
 
 type State uint
@@ -51,6 +53,61 @@ const (
 	StateStopped
 )
 
+// PeeringState is the state of the connection to a peer of the PeeringService.
+type PeeringState uint
+
+const (
+	// PeeringStateUnknown is the state of the peers which are not in the
+	// peering service, or have been removed from it.
+	PeeringStateUnknown PeeringState = iota
+	// PeeringStateDisconnected is the state of the peers which are not
+	// connected, which the running service reconnects to after a back-off.
+	PeeringStateDisconnected
+	// PeeringStateConnecting is the state of the peers being reconnected to.
+	PeeringStateConnecting
+	// PeeringStateConnected is the state of the connected peers.
+	PeeringStateConnected
+)
+
+func (s PeeringState) String() string {
+	switch s {
+	case PeeringStateUnknown:
+		return "unknown"
+	case PeeringStateDisconnected:
+		return "disconnected"
+	case PeeringStateConnecting:
+		return "connecting"
+	case PeeringStateConnected:
+		return "connected"
+	default:
+		return "unknown peer state: " + strconv.FormatUint(uint64(s), 10)
+	}
+}
+
+// PeerStatusInfo is the status of a peer of the peering service, see
+// [PeeringService.PeerStatus].
+type PeerStatusInfo struct {
+	peer.AddrInfo
+	State     PeeringState
+	LastError error
+	NextRetry time.Time
+}
+
+// Option is an option of [NewPeeringService].
+type Option func(*PeeringService)
+
+// WithOnStateChange registers f to be called with each change of the state of
+// each peer, once per change and in order for a peer, including the change
+// from and to PeeringStateUnknown on AddPeer and RemovePeer. f is called
+// synchronously from the goroutines of the service without any of its locks
+// held, so that it may call the service, but it holds up the reconnections
+// to the peer: it must be fast.
+func WithOnStateChange(f func(peer.ID, PeeringState)) Option {
+	return func(ps *PeeringService) {
+		ps.onStateChange = f
+	}
+}
+
 // peerHandler keeps track of all state related to a specific "peering" peer.
 type peerHandler struct {
 	peer   peer.ID
@@ -58,11 +115,82 @@ type peerHandler struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	onStateChange func(peer.ID, PeeringState)
+	metrics       *metrics
+
 	mu             sync.Mutex
 	addrs          []multiaddr.Multiaddr
 	reconnectTimer *time.Timer
 
-	nextDelay time.Duration
+	initialDelay time.Duration
+	nextDelay    time.Duration
+
+	state     PeeringState
+	lastErr   error
+	nextRetry time.Time
+	// stopped is set once the handler is stopped, after which its state
+	// only changes to PeeringStateUnknown when the peer is removed.
+	stopped bool
+	// pending are the states not passed to onStateChange yet, by the
+	// goroutine which is notifying if any.
+	pending   []PeeringState
+	notifying bool
+}
+
+// setStateLocked sets the state of the peer, and queues its notification if
+// it changed.
+func (ph *peerHandler) setStateLocked(s PeeringState) {
+	if ph.stopped {
+		return
+	}
+	ph.changeStateLocked(s)
+}
+
+// changeStateLocked is setStateLocked, for stopped handlers too.
+func (ph *peerHandler) changeStateLocked(s PeeringState) {
+	if ph.state == s {
+		return
+	}
+	ph.state = s
+	if s != PeeringStateDisconnected {
+		ph.nextRetry = time.Time{}
+	}
+	if ph.onStateChange != nil {
+		ph.pending = append(ph.pending, s)
+	}
+}
+
+// notify passes the queued states to onStateChange, in order, with no lock
+// held. The states queued while another goroutine is notifying are passed by
+// that goroutine.
+func (ph *peerHandler) notify() {
+	ph.mu.Lock()
+	if ph.notifying {
+		ph.mu.Unlock()
+		return
+	}
+	ph.notifying = true
+	for len(ph.pending) > 0 {
+		s := ph.pending[0]
+		ph.pending = ph.pending[1:]
+		ph.mu.Unlock()
+		ph.onStateChange(ph.peer, s)
+		ph.mu.Lock()
+	}
+	ph.notifying = false
+	ph.mu.Unlock()
+}
+
+// status returns the status of the peer.
+func (ph *peerHandler) status() PeerStatusInfo {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	return PeerStatusInfo{
+		AddrInfo:  peer.AddrInfo{ID: ph.peer, Addrs: append([]multiaddr.Multiaddr(nil), ph.addrs...)},
+		State:     ph.state,
+		LastError: ph.lastErr,
+		NextRetry: ph.nextRetry,
+	}
 }
 
 // setAddrs sets the addresses for this peer.
@@ -83,8 +211,10 @@ func (ph *peerHandler) getAddrs() []multiaddr.Multiaddr {
 	return ph.addrs
 }
 
-// stop permanently stops the peer handler.
-func (ph *peerHandler) stop() {
+// stop permanently stops the peer handler. The peer is set to
+// PeeringStateUnknown if removed, even if the handler was already stopped
+// with the service, its state is kept otherwise.
+func (ph *peerHandler) stop(removed bool) {
 	ph.cancel()
 
 	ph.mu.Lock()
@@ -93,6 +223,10 @@ func (ph *peerHandler) stop() {
 		ph.reconnectTimer.Stop()
 		ph.reconnectTimer = nil
 	}
+	if removed {
+		ph.changeStateLocked(PeeringStateUnknown)
+	}
+	ph.stopped = true
 }
 
 func (ph *peerHandler) nextBackoff() time.Duration {
@@ -111,19 +245,31 @@ func (ph *peerHandler) nextBackoff() time.Duration {
 }
 
 func (ph *peerHandler) reconnect() {
+	defer ph.notify()
+
 	// Try connecting
-	addrs := ph.getAddrs()
+	ph.mu.Lock()
+	addrs := ph.addrs
+	ph.setStateLocked(PeeringStateConnecting)
+	ph.mu.Unlock()
+	ph.notify()
 	logger.Debugw("reconnecting", "peer", ph.peer, "addrs", addrs)
+	ph.metrics.reconnectAttempt()
 
 	err := ph.host.Connect(ph.ctx, peer.AddrInfo{ID: ph.peer, Addrs: addrs})
 	if err != nil {
 		logger.Debugw("failed to reconnect", "peer", ph.peer, "error", err)
+		ph.metrics.reconnectFailure()
 		// Ok, we failed. Extend the timeout.
 		ph.mu.Lock()
+		ph.lastErr = err
 		if ph.reconnectTimer != nil {
 			// Only counts if the reconnectTimer still exists. If not, a
 			// connection _was_ somehow established.
-			ph.reconnectTimer.Reset(ph.nextBackoff())
+			delay := ph.nextBackoff()
+			ph.reconnectTimer.Reset(delay)
+			ph.setStateLocked(PeeringStateDisconnected)
+			ph.nextRetry = time.Now().Add(delay)
 		}
 		// Otherwise, someone else has stopped us so we can assume that
 		// we're either connected or someone else will start us.
@@ -136,26 +282,36 @@ func (ph *peerHandler) reconnect() {
 }
 
 func (ph *peerHandler) stopIfConnected() {
+	defer ph.notify()
 	ph.mu.Lock()
 	defer ph.mu.Unlock()
 
-	if ph.reconnectTimer != nil && ph.host.Network().Connectedness(ph.peer) == network.Connected {
+	if ph.host.Network().Connectedness(ph.peer) != network.Connected {
+		return
+	}
+	if ph.reconnectTimer != nil {
 		logger.Debugw("successfully reconnected", "peer", ph.peer)
 		ph.reconnectTimer.Stop()
 		ph.reconnectTimer = nil
-		ph.nextDelay = initialDelay
+		ph.nextDelay = ph.initialDelay
 	}
+	ph.lastErr = nil
+	ph.setStateLocked(PeeringStateConnected)
 }
 
 // startIfDisconnected is the inverse of stopIfConnected.
 func (ph *peerHandler) startIfDisconnected() {
+	defer ph.notify()
 	ph.mu.Lock()
 	defer ph.mu.Unlock()
 
 	if ph.reconnectTimer == nil && ph.host.Network().Connectedness(ph.peer) != network.Connected {
 		logger.Debugw("disconnected from peer", "peer", ph.peer)
 		// Always start with a short timeout so we can stagger things a bit.
-		ph.reconnectTimer = time.AfterFunc(ph.nextBackoff(), ph.reconnect)
+		delay := ph.nextBackoff()
+		ph.reconnectTimer = time.AfterFunc(delay, ph.reconnect)
+		ph.setStateLocked(PeeringStateDisconnected)
+		ph.nextRetry = time.Now().Add(delay)
 	}
 }
 
@@ -164,37 +320,57 @@ func (ph *peerHandler) startIfDisconnected() {
 type PeeringService struct {
 	host host.Host
 
-	mu    sync.RWMutex
-	peers map[peer.ID]*peerHandler
-	state State
+	onStateChange func(peer.ID, PeeringState)
+	metrics       *metrics
+	ds            ds.Datastore
+
+	mu          sync.RWMutex
+	peers       map[peer.ID]*peerHandler
+	state       State
+	stopPersist context.CancelFunc
 }
 
 // NewPeeringService constructs a new peering service. Peers can be added and
 // removed immediately, but connections won't be formed until `Start` is called.
-func NewPeeringService(host host.Host) *PeeringService {
-	return &PeeringService{host: host, peers: make(map[peer.ID]*peerHandler)}
+func NewPeeringService(host host.Host, opts ...Option) *PeeringService {
+	ps := &PeeringService{host: host, peers: make(map[peer.ID]*peerHandler)}
+	for _, opt := range opts {
+		opt(ps)
+	}
+	return ps
 }
 
 // Start starts the peering service, connecting and maintaining connections to
-// all registered peers. It returns an error if the service has already been
-// stopped.
+// all registered peers, and the ones saved in its datastore if any. It
+// returns an error if the service has already been stopped.
 func (ps *PeeringService) Start() error {
 	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
 	switch ps.state {
 	case StateInit:
 		logger.Infow("starting")
 	case StateRunning:
+		ps.mu.Unlock()
 		return nil
 	case StateStopped:
+		ps.mu.Unlock()
 		return errors.New("already stopped")
 	}
+	loaded := ps.loadPeersLocked()
 	ps.host.Network().Notify((*netNotifee)(ps))
 	ps.state = StateRunning
 	for _, handler := range ps.peers {
 		go handler.startIfDisconnected()
 	}
+	if ps.ds != nil {
+		var ctx context.Context
+		ctx, ps.stopPersist = context.WithCancel(context.Background())
+		go ps.persistLoop(ctx, persistInterval)
+	}
+	ps.mu.Unlock()
+
+	for _, handler := range loaded {
+		handler.notify()
+	}
 	return nil
 }
 
@@ -215,7 +391,10 @@ func (ps *PeeringService) Stop() {
 	case StateInit, StateRunning:
 		logger.Infow("stopping")
 		for _, handler := range ps.peers {
-			handler.stop()
+			handler.stop(false)
+		}
+		if ps.stopPersist != nil {
+			ps.stopPersist()
 		}
 		ps.state = StateStopped
 	}
@@ -229,33 +408,49 @@ func (ps *PeeringService) Stop() {
 // addresses will replace the old.
 func (ps *PeeringService) AddPeer(info peer.AddrInfo) {
 	ps.mu.Lock()
-	defer ps.mu.Unlock()
+	handler := ps.addPeerLocked(info)
+	ps.mu.Unlock()
+	handler.notify()
+}
 
+func (ps *PeeringService) addPeerLocked(info peer.AddrInfo) *peerHandler {
 	if handler, ok := ps.peers[info.ID]; ok {
 		logger.Infow("updating addresses", "peer", info.ID, "addrs", info.Addrs)
 		handler.setAddrs(info.Addrs)
+		ps.savePeerLocked(handler)
+		return handler
+	}
+
+	logger.Infow("peer added", "peer", info.ID, "addrs", info.Addrs)
+	ps.host.ConnManager().Protect(info.ID, connmgrTag)
+
+	handler := &peerHandler{
+		host:          ps.host,
+		peer:          info.ID,
+		addrs:         info.Addrs,
+		initialDelay:  initialDelay,
+		nextDelay:     initialDelay,
+		onStateChange: ps.onStateChange,
+		metrics:       ps.metrics,
+	}
+	handler.ctx, handler.cancel = context.WithCancel(context.Background())
+	if ps.host.Network().Connectedness(info.ID) == network.Connected {
+		handler.setStateLocked(PeeringStateConnected)
 	} else {
-		logger.Infow("peer added", "peer", info.ID, "addrs", info.Addrs)
-		ps.host.ConnManager().Protect(info.ID, connmgrTag)
-
-		handler = &peerHandler{
-			host:      ps.host,
-			peer:      info.ID,
-			addrs:     info.Addrs,
-			nextDelay: initialDelay,
-		}
-		handler.ctx, handler.cancel = context.WithCancel(context.Background())
-		ps.peers[info.ID] = handler
-		switch ps.state {
-		case StateRunning:
-			go handler.startIfDisconnected()
-		case StateStopped:
-			// We still construct everything in this state because
-			// it's easier to reason about. But we should still free
-			// resources.
-			handler.cancel()
-		}
+		handler.setStateLocked(PeeringStateDisconnected)
+	}
+	ps.peers[info.ID] = handler
+	ps.savePeerLocked(handler)
+	switch ps.state {
+	case StateRunning:
+		go handler.startIfDisconnected()
+	case StateStopped:
+		// We still construct everything in this state because
+		// it's easier to reason about. But we should still free
+		// resources.
+		handler.stop(false)
 	}
+	return handler
 }
 
 // ListPeers lists peers in the peering service.
@@ -277,15 +472,96 @@ func (ps *PeeringService) ListPeers() []peer.AddrInfo {
 // after it stops.
 func (ps *PeeringService) RemovePeer(id peer.ID) {
 	ps.mu.Lock()
-	defer ps.mu.Unlock()
+	handler, ok := ps.peers[id]
+	if ok {
+		ps.removePeerLocked(handler)
+	}
+	ps.mu.Unlock()
+
+	if ok {
+		handler.notify()
+	}
+}
+
+func (ps *PeeringService) removePeerLocked(handler *peerHandler) {
+	logger.Infow("peer removed", "peer", handler.peer)
+	ps.host.ConnManager().Unprotect(handler.peer, connmgrTag)
 
-	if handler, ok := ps.peers[id]; ok {
-		logger.Infow("peer removed", "peer", id)
-		ps.host.ConnManager().Unprotect(id, connmgrTag)
+	handler.stop(true)
+	delete(ps.peers, handler.peer)
+	ps.deletePeerLocked(handler.peer)
+}
 
-		handler.stop()
-		delete(ps.peers, id)
+// SetPeers replaces the peers of the peering service with peers: the peers
+// which are not in peers are removed, the new ones are added and the
+// addresses of the others are updated if they changed, as with RemovePeer
+// and AddPeer. The peers which do not change are left as they are.
+func (ps *PeeringService) SetPeers(peers []peer.AddrInfo) {
+	keep := make(map[peer.ID]struct{}, len(peers))
+	for _, info := range peers {
+		keep[info.ID] = struct{}{}
 	}
+
+	var changed []*peerHandler
+	ps.mu.Lock()
+	for id, handler := range ps.peers {
+		if _, ok := keep[id]; !ok {
+			ps.removePeerLocked(handler)
+			changed = append(changed, handler)
+		}
+	}
+	for _, info := range peers {
+		if handler, ok := ps.peers[info.ID]; ok && equalAddrs(handler.getAddrs(), info.Addrs) {
+			continue
+		}
+		changed = append(changed, ps.addPeerLocked(info))
+	}
+	ps.mu.Unlock()
+
+	for _, handler := range changed {
+		handler.notify()
+	}
+}
+
+func equalAddrs(a, b []multiaddr.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// PeerStatus returns the state of the connection to the peer id, the error
+// of the last failed reconnection since it was last connected, and the time
+// of the next reconnection if one is scheduled. The peers which are not in
+// the service are in the PeeringStateUnknown state. Once the service is
+// stopped, the states are the ones at the time it stopped.
+func (ps *PeeringService) PeerStatus(id peer.ID) (state PeeringState, lastError error, nextRetry time.Time) {
+	ps.mu.RLock()
+	handler, ok := ps.peers[id]
+	ps.mu.RUnlock()
+	if !ok {
+		return PeeringStateUnknown, nil, time.Time{}
+	}
+	s := handler.status()
+	return s.State, s.LastError, s.NextRetry
+}
+
+// ListPeerStatus lists the peers in the peering service with their status,
+// see [PeeringService.PeerStatus].
+func (ps *PeeringService) ListPeerStatus() []PeerStatusInfo {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	out := make([]PeerStatusInfo, 0, len(ps.peers))
+	for _, handler := range ps.peers {
+		out = append(out, handler.status())
+	}
+	return out
 }
 
 type netNotifee PeeringService
@@ -2,6 +2,8 @@ package peering
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,6 +12,9 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/stretchr/testify/require"
 )
@@ -170,3 +175,117 @@ func TestNextBackoff(t *testing.T) {
 		}
 	}
 }
+
+func TestPeerStatus(t *testing.T) {
+	defer func(d time.Duration) { initialDelay = d }(initialDelay)
+	initialDelay = 10 * time.Millisecond
+
+	mn := mocknet.New()
+	defer mn.Close()
+	h1, err := mn.GenPeer()
+	require.NoError(t, err)
+	h2, err := mn.GenPeer()
+	require.NoError(t, err)
+	_, err = mn.LinkPeers(h1.ID(), h2.ID())
+	require.NoError(t, err)
+
+	var ps *PeeringService
+	var mu sync.Mutex
+	var states []PeeringState
+	reg := prometheus.NewRegistry()
+	ps = NewPeeringService(h1,
+		WithOnStateChange(func(id peer.ID, s PeeringState) {
+			require.Equal(t, h2.ID(), id)
+			// the callback may call the service
+			ps.PeerStatus(id)
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, s)
+		}),
+		WithMetrics(reg),
+	)
+	waitStates := func(expected ...PeeringState) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(states) < len(expected) {
+				return false
+			}
+			require.Equal(t, expected, states[:len(expected)])
+			states = states[len(expected):]
+			return true
+		}, 10*time.Second, time.Millisecond)
+	}
+
+	state, lastErr, nextRetry := ps.PeerStatus(h2.ID())
+	require.Equal(t, PeeringStateUnknown, state)
+	require.NoError(t, lastErr)
+	require.True(t, nextRetry.IsZero())
+
+	ps.AddPeer(peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	waitStates(PeeringStateDisconnected)
+	state, _, nextRetry = ps.PeerStatus(h2.ID())
+	require.Equal(t, PeeringStateDisconnected, state)
+	require.True(t, nextRetry.IsZero(), "no reconnection before Start")
+
+	require.NoError(t, ps.Start())
+	defer ps.Stop()
+	waitStates(PeeringStateConnecting, PeeringStateConnected)
+	require.Equal(t, float64(1), testutil.ToFloat64(ps.metrics.attempts))
+	require.Equal(t, float64(0), testutil.ToFloat64(ps.metrics.failures))
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP ipfs_peering_connected_peers The number of peers of the peering service which are connected.
+# TYPE ipfs_peering_connected_peers gauge
+ipfs_peering_connected_peers 1
+`), "ipfs_peering_connected_peers"))
+
+	// the reconnections fail while the peers are not linked
+	require.NoError(t, mn.UnlinkPeers(h1.ID(), h2.ID()))
+	require.NoError(t, mn.DisconnectPeers(h1.ID(), h2.ID()))
+	waitStates(PeeringStateDisconnected, PeeringStateConnecting, PeeringStateDisconnected)
+	state, lastErr, nextRetry = ps.PeerStatus(h2.ID())
+	if state == PeeringStateDisconnected {
+		require.Error(t, lastErr)
+		require.False(t, nextRetry.IsZero())
+	}
+	require.GreaterOrEqual(t, testutil.ToFloat64(ps.metrics.failures), float64(1))
+
+	_, err = mn.LinkPeers(h1.ID(), h2.ID())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		state, lastErr, nextRetry := ps.PeerStatus(h2.ID())
+		return state == PeeringStateConnected && lastErr == nil && nextRetry.IsZero()
+	}, 10*time.Second, time.Millisecond)
+	l := ps.ListPeerStatus()
+	require.Len(t, l, 1)
+	require.Equal(t, h2.ID(), l[0].ID)
+	require.Equal(t, PeeringStateConnected, l[0].State)
+
+	ps.RemovePeer(h2.ID())
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(states) > 0 && states[len(states)-1] == PeeringStateUnknown
+	}, 10*time.Second, time.Millisecond)
+	state, _, _ = ps.PeerStatus(h2.ID())
+	require.Equal(t, PeeringStateUnknown, state)
+	require.Empty(t, ps.ListPeerStatus())
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP ipfs_peering_connected_peers The number of peers of the peering service which are connected.
+# TYPE ipfs_peering_connected_peers gauge
+ipfs_peering_connected_peers 0
+`), "ipfs_peering_connected_peers"))
+
+	// the peers removed after Stop leave the PeeringStateUnknown state too
+	mu.Lock()
+	states = nil
+	mu.Unlock()
+	ps.AddPeer(peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	waitStates(PeeringStateConnected)
+	ps.Stop()
+	ps.RemovePeer(h2.ID())
+	waitStates(PeeringStateUnknown)
+	state, _, _ = ps.PeerStatus(h2.ID())
+	require.Equal(t, PeeringStateUnknown, state)
+}
@@ -0,0 +1,160 @@
+package peering
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// PeersKey is the datastore key under which the peers of the service are
+// saved when WithDatastore is used, one child key per peer ID.
+var PeersKey = ds.NewKey("/peering/peers")
+
+// persistInterval is the interval between the saves of all the peers while
+// the service runs, which record the addresses learnt by the host.
+var persistInterval = 10 * time.Minute
+
+// WithDatastore saves the peers of the service in d, so that the peers added
+// at runtime are restored after a restart. The peers are saved on every
+// AddPeer, RemovePeer and SetPeers, with the other addresses the host knows
+// of them periodically, and are loaded by Start before any reconnection. The
+// peers added before Start take precedence over the saved ones. The entries
+// of d which cannot be parsed are logged and skipped. d must be safe for
+// concurrent use.
+func WithDatastore(d ds.Datastore) Option {
+	return func(ps *PeeringService) {
+		ps.ds = d
+	}
+}
+
+type peerRecord struct {
+	// Addrs are the addresses the peer was added with.
+	Addrs []string `json:",omitempty"`
+	// Known are the other addresses the host knew of the peer when saved,
+	// which are restored to the peerstore.
+	Known []string `json:",omitempty"`
+}
+
+func peerKey(id peer.ID) ds.Key {
+	return PeersKey.ChildString(id.String())
+}
+
+// savePeerLocked saves the peer of handler. The lock of ps must be held.
+func (ps *PeeringService) savePeerLocked(handler *peerHandler) {
+	if ps.ds == nil {
+		return
+	}
+
+	addrs := handler.getAddrs()
+	var rec peerRecord
+	for _, a := range addrs {
+		rec.Addrs = append(rec.Addrs, a.String())
+	}
+	for _, a := range ps.host.Peerstore().Addrs(handler.peer) {
+		if !multiaddr.Contains(addrs, a) {
+			rec.Known = append(rec.Known, a.String())
+		}
+	}
+	value, err := json.Marshal(rec)
+	if err != nil {
+		logger.Errorw("failed to marshal peer", "peer", handler.peer, "error", err)
+		return
+	}
+	if err := ps.ds.Put(context.Background(), peerKey(handler.peer), value); err != nil {
+		logger.Errorw("failed to save peer", "peer", handler.peer, "error", err)
+	}
+}
+
+// deletePeerLocked deletes the saved peer id. The lock of ps must be held.
+func (ps *PeeringService) deletePeerLocked(id peer.ID) {
+	if ps.ds == nil {
+		return
+	}
+	if err := ps.ds.Delete(context.Background(), peerKey(id)); err != nil {
+		logger.Errorw("failed to delete peer", "peer", id, "error", err)
+	}
+}
+
+// loadPeersLocked adds the saved peers which are not in the service yet, and
+// returns their handlers. The lock of ps must be held.
+func (ps *PeeringService) loadPeersLocked() []*peerHandler {
+	if ps.ds == nil {
+		return nil
+	}
+
+	results, err := ps.ds.Query(context.Background(), dsq.Query{Prefix: PeersKey.String()})
+	if err != nil {
+		logger.Errorw("failed to load the peers", "error", err)
+		return nil
+	}
+	defer results.Close()
+
+	var handlers []*peerHandler
+	for result := range results.Next() {
+		if result.Error != nil {
+			// Might as well keep what we could read.
+			logger.Errorw("failed to load the peers", "error", result.Error)
+			break
+		}
+		key := ds.RawKey(result.Key)
+		id, err := peer.Decode(key.Name())
+		if err != nil || !key.Parent().Equal(PeersKey) {
+			logger.Errorw("invalid saved peer key", "key", result.Key)
+			continue
+		}
+		var rec peerRecord
+		if err := json.Unmarshal(result.Value, &rec); err != nil {
+			logger.Errorw("invalid saved peer", "peer", id, "error", err)
+			continue
+		}
+
+		known := parseAddrs(id, rec.Known)
+		if len(known) > 0 {
+			ps.host.Peerstore().AddAddrs(id, known, peerstore.AddressTTL)
+		}
+		if _, ok := ps.peers[id]; ok {
+			continue
+		}
+		handlers = append(handlers, ps.addPeerLocked(peer.AddrInfo{ID: id, Addrs: parseAddrs(id, rec.Addrs)}))
+	}
+	return handlers
+}
+
+// parseAddrs parses the saved addresses of the peer id, skipping the invalid
+// ones.
+func parseAddrs(id peer.ID, addrs []string) []multiaddr.Multiaddr {
+	out := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, s := range addrs {
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			logger.Errorw("invalid saved peer address", "peer", id, "addr", s, "error", err)
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// persistLoop saves all the peers every interval until ctx is done.
+func (ps *PeeringService) persistLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ps.mu.RLock()
+			for _, handler := range ps.peers {
+				ps.savePeerLocked(handler)
+			}
+			ps.mu.RUnlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
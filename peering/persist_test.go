@@ -0,0 +1,151 @@
+package peering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newMocknet(t *testing.T, n int) (mocknet.Mocknet, []host.Host) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	hosts := make([]host.Host, n)
+	for i := range hosts {
+		h, err := mn.GenPeer()
+		require.NoError(t, err)
+		hosts[i] = h
+	}
+	require.NoError(t, mn.LinkAll())
+	return mn, hosts
+}
+
+func addrInfo(h host.Host) peer.AddrInfo {
+	return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+}
+
+func TestPeeringPersistence(t *testing.T) {
+	defer func(d, i time.Duration) { initialDelay, persistInterval = d, i }(initialDelay, persistInterval)
+	initialDelay = 10 * time.Millisecond
+	persistInterval = 10 * time.Millisecond
+
+	_, hosts := newMocknet(t, 5)
+	h1, h2, h3, h4, h5 := hosts[0], hosts[1], hosts[2], hosts[3], hosts[4]
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	ps := NewPeeringService(h1, WithDatastore(d))
+	ps.AddPeer(addrInfo(h2))
+	require.NoError(t, ps.Start())
+	ps.AddPeer(addrInfo(h3))
+	ps.AddPeer(addrInfo(h4))
+	ps.RemovePeer(h4.ID())
+
+	// the other addresses of the peers are saved periodically
+	extra := multiaddr.StringCast("/ip4/192.0.2.1/tcp/4001")
+	h1.Peerstore().AddAddr(h3.ID(), extra, peerstore.PermanentAddrTTL)
+	require.Eventually(t, func() bool {
+		value, err := d.Get(context.Background(), peerKey(h3.ID()))
+		require.NoError(t, err)
+		return string(value) == `{"Addrs":["`+h3.Addrs()[0].String()+`"],"Known":["`+extra.String()+`"]}`
+	}, 10*time.Second, time.Millisecond)
+	ps.Stop()
+
+	// a new service over the same datastore reconnects to the same peers
+	ps = NewPeeringService(h5, WithDatastore(d))
+	require.Empty(t, ps.ListPeers())
+	require.NoError(t, ps.Start())
+	defer ps.Stop()
+	require.ElementsMatch(t, []peer.AddrInfo{addrInfo(h2), addrInfo(h3)}, ps.ListPeers())
+	require.Contains(t, h5.Peerstore().Addrs(h3.ID()), extra)
+	require.Eventually(t, func() bool {
+		return h5.Network().Connectedness(h2.ID()) == network.Connected &&
+			h5.Network().Connectedness(h3.ID()) == network.Connected
+	}, 10*time.Second, time.Millisecond)
+	require.NotEqual(t, network.Connected, h5.Network().Connectedness(h4.ID()))
+}
+
+func TestPeeringPersistencePrecedence(t *testing.T) {
+	_, hosts := newMocknet(t, 3)
+	h1, h2, h3 := hosts[0], hosts[1], hosts[2]
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	ps := NewPeeringService(h1, WithDatastore(d))
+	ps.AddPeer(addrInfo(h2))
+	ps.AddPeer(addrInfo(h3))
+	ps.Stop()
+
+	// the peers added before Start are kept as they are
+	other := peer.AddrInfo{ID: h2.ID(), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/192.0.2.1/tcp/4001")}}
+	ps = NewPeeringService(h1, WithDatastore(d))
+	ps.AddPeer(other)
+	require.NoError(t, ps.Start())
+	defer ps.Stop()
+	require.ElementsMatch(t, []peer.AddrInfo{other, addrInfo(h3)}, ps.ListPeers())
+}
+
+func TestPeeringPersistenceCorrupted(t *testing.T) {
+	_, hosts := newMocknet(t, 4)
+	h1, h2, h3, h4 := hosts[0], hosts[1], hosts[2], hosts[3]
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	ctx := context.Background()
+
+	valid := `{"Addrs":["` + h3.Addrs()[0].String() + `"]}`
+	for key, value := range map[ds.Key]string{
+		peerKey(h2.ID()):                            `{"Addrs":["/ip4/`,
+		PeersKey.ChildString("not-a-peer"):          valid,
+		PeersKey.Child(peerKey(h4.ID())):            valid,
+		peerKey(h3.ID()):                            `{"Addrs":["/invalid","` + h3.Addrs()[0].String() + `"],"Known":["/invalid"]}`,
+		ds.NewKey("/other").Child(peerKey(h4.ID())): valid,
+	} {
+		require.NoError(t, d.Put(ctx, key, []byte(value)))
+	}
+
+	ps := NewPeeringService(h1, WithDatastore(d))
+	require.NoError(t, ps.Start())
+	defer ps.Stop()
+	require.Equal(t, []peer.AddrInfo{addrInfo(h3)}, ps.ListPeers())
+
+	// the valid peer is saved back without its invalid addresses
+	value, err := d.Get(ctx, peerKey(h3.ID()))
+	require.NoError(t, err)
+	require.Equal(t, valid, string(value))
+}
+
+func TestSetPeers(t *testing.T) {
+	_, hosts := newMocknet(t, 4)
+	h1, h2, h3, h4 := hosts[0], hosts[1], hosts[2], hosts[3]
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	var changes []peer.ID
+	ps := NewPeeringService(h1, WithDatastore(d), WithOnStateChange(func(id peer.ID, _ PeeringState) {
+		changes = append(changes, id)
+	}))
+	ps.SetPeers([]peer.AddrInfo{addrInfo(h2), addrInfo(h3)})
+	require.ElementsMatch(t, []peer.AddrInfo{addrInfo(h2), addrInfo(h3)}, ps.ListPeers())
+	require.ElementsMatch(t, []peer.ID{h2.ID(), h3.ID()}, changes)
+
+	// h2 is removed, h4 added, and h3 left as it is
+	changes = nil
+	ps.SetPeers([]peer.AddrInfo{addrInfo(h3), addrInfo(h4)})
+	require.ElementsMatch(t, []peer.AddrInfo{addrInfo(h3), addrInfo(h4)}, ps.ListPeers())
+	require.ElementsMatch(t, []peer.ID{h2.ID(), h4.ID()}, changes)
+
+	for id, saved := range map[peer.ID]bool{h2.ID(): false, h3.ID(): true, h4.ID(): true} {
+		has, err := d.Has(context.Background(), peerKey(id))
+		require.NoError(t, err)
+		require.Equal(t, saved, has)
+	}
+
+	ps.SetPeers(nil)
+	require.Empty(t, ps.ListPeers())
+}
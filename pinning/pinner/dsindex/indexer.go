@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"strings"
 
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
@@ -46,6 +47,31 @@ type Indexer interface {
 
 	// Search returns all values for the given key
 	Search(ctx context.Context, key string) (values []string, err error)
+
+	// Query calls the function for each value matched by q, in a stable
+	// order, until there are no more values, or until the function returns
+	// false.  The matching and the ordering are done by the datastore, if it
+	// supports them.
+	Query(ctx context.Context, q Query, fn func(key, value string) bool) error
+}
+
+// Query selects the values of an Indexer.  The values are ordered by their
+// encoded key, and then by their encoded value, which is not the order of the
+// keys and values themselves.
+type Query struct {
+	// Key, if not empty, only matches the values of this key.
+	Key string
+
+	// KeyPrefix, if not empty, only matches the values of the keys starting
+	// with it.
+	KeyPrefix string
+
+	// AfterKey and AfterValue, if AfterKey is not empty, only match the
+	// values which come after this key and value, in the order of the query.
+	AfterKey, AfterValue string
+
+	// Limit, if positive, is the maximum number of values matched.
+	Limit int
 }
 
 // indexer is a simple implementation of Indexer.  This implementation relies
@@ -179,6 +205,70 @@ func (x *indexer) Search(ctx context.Context, key string) ([]string, error) {
 	return values, nil
 }
 
+func (x *indexer) Query(ctx context.Context, q Query, fn func(key, value string) bool) error {
+	dq := query.Query{
+		KeysOnly: true,
+		Orders:   []query.Order{query.OrderByKey{}},
+	}
+	if q.Key != "" {
+		dq.Prefix = encode(q.Key)
+	}
+	// The encoding of a prefix is a prefix of the encoding of the keys only
+	// for whole groups of 3 bytes, the rest is checked after decoding.
+	exact := true
+	if q.KeyPrefix != "" {
+		n := len(q.KeyPrefix) - len(q.KeyPrefix)%3
+		if n != 0 {
+			dq.Filters = append(dq.Filters, query.FilterKeyPrefix{Prefix: "/" + encode(q.KeyPrefix[:n])})
+		}
+		exact = n == len(q.KeyPrefix)
+	}
+	if q.AfterKey != "" {
+		after := ds.NewKey(encode(q.AfterKey)).ChildString(encode(q.AfterValue))
+		dq.Filters = append(dq.Filters, query.FilterKeyCompare{Op: query.GreaterThan, Key: after.String()})
+	}
+	if q.Limit > 0 && exact {
+		dq.Limit = q.Limit
+	}
+
+	results, err := x.dstore.Query(ctx, dq)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	var count int
+	for r := range results.Next() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if r.Error != nil {
+			return fmt.Errorf("cannot read index: %v", r.Error)
+		}
+		ent := r.Entry
+		decIdx, err := decode(path.Base(path.Dir(ent.Key)))
+		if err != nil {
+			return fmt.Errorf("cannot decode index: %v", err)
+		}
+		if !strings.HasPrefix(decIdx, q.KeyPrefix) {
+			continue
+		}
+		decKey, err := decode(path.Base(ent.Key))
+		if err != nil {
+			return fmt.Errorf("cannot decode key: %v", err)
+		}
+		if !fn(decIdx, decKey) {
+			return nil
+		}
+		count++
+		if q.Limit > 0 && count == q.Limit {
+			return nil
+		}
+	}
+
+	return nil
+}
+
 // SyncIndex synchronizes the keys in the target Indexer to match those of the
 // ref Indexer. This function does not change this indexer's key root (name
 // passed into New).
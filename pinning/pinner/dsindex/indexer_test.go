@@ -2,6 +2,9 @@ package dsindex
 
 import (
 	"context"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	ds "github.com/ipfs/go-datastore"
@@ -284,3 +287,54 @@ func TestSyncIndex(t *testing.T) {
 		t.Fatal("different number of items in sync source and target")
 	}
 }
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	nameIndex := createIndexer()
+	nameIndex.Add(ctx, "bobby", "b3")
+
+	query := func(q Query) []string {
+		var values []string
+		err := nameIndex.Query(ctx, q, func(key, value string) bool {
+			values = append(values, key+"="+value)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	all := query(Query{})
+	if len(all) != 5 {
+		t.Fatal("expected 5 values, got", all)
+	}
+	// the order is stable, and resumes after a value
+	for i := range all {
+		key, value, _ := strings.Cut(all[i], "=")
+		rest := query(Query{AfterKey: key, AfterValue: value})
+		if expected := append([]string(nil), all[i+1:]...); !reflect.DeepEqual(rest, expected) {
+			t.Fatalf("expected %v after %s, got %v", all[i+1:], all[i], rest)
+		}
+	}
+
+	for _, tc := range []struct {
+		q        Query
+		expected []string
+	}{
+		{Query{Key: "bob"}, []string{"bob=b1", "bob=b2"}},
+		{Query{KeyPrefix: "bo"}, []string{"bob=b1", "bob=b2", "bobby=b3"}},
+		{Query{KeyPrefix: "bobb"}, []string{"bobby=b3"}},
+		{Query{KeyPrefix: "cat"}, []string{"cathy=c1"}},
+		{Query{KeyPrefix: "dave"}, nil},
+		{Query{Key: "bob", Limit: 1}, []string{"bob=b1"}},
+		{Query{KeyPrefix: "b", Limit: 2}, []string{"bob=b1", "bob=b2"}},
+		{Query{Key: "bob", AfterKey: "bob", AfterValue: "b1"}, []string{"bob=b2"}},
+	} {
+		values := query(tc.q)
+		sort.Strings(values)
+		if !reflect.DeepEqual(values, tc.expected) {
+			t.Fatalf("expected %v for %+v, got %v", tc.expected, tc.q, values)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package dspinner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipfspinner "github.com/ipfs/boxo/pinning/pinner"
+)
+
+// PinResult is the result of pinning or unpinning a CID with PinMany or
+// UnpinMany.
+type PinResult struct {
+	Cid cid.Cid
+	Err error
+}
+
+// BulkProgress is the progress of PinMany or UnpinMany.
+type BulkProgress struct {
+	// Pins is the number of CIDs done, pinned or not.
+	Pins int
+	// Blocks is the number of blocks fetched.
+	Blocks int
+}
+
+type bulkOptions struct {
+	progress func(BulkProgress)
+}
+
+// BulkOption configures PinMany and UnpinMany.
+type BulkOption func(*bulkOptions)
+
+// WithBulkProgress calls fn after the blocks of each CID are fetched, and after
+// each CID is done.
+func WithBulkProgress(fn func(BulkProgress)) BulkOption {
+	return func(o *bulkOptions) {
+		o.progress = fn
+	}
+}
+
+func bulkOpts(opts []BulkOption) *bulkOptions {
+	o := &bulkOptions{progress: func(BulkProgress) {}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// PinMany pins the cids, recursively or not, and returns the result of each
+// of them. Unlike Pin, the nodes are fetched, with all their descendants if
+// recursive: the blocks of the DAGs shared by several cids are only fetched
+// once. The pins are then added at once, and synced together. A cid whose DAG
+// cannot be fetched is not pinned, and does not prevent the others to be.
+//
+// The returned error is set if the pins could not be stored, in which case
+// the state of the pins is the one of a failed Pin.
+func (p *pinner) PinMany(ctx context.Context, cids []cid.Cid, recursive bool, opts ...BulkOption) ([]PinResult, error) {
+	o := bulkOpts(opts)
+	results := make([]PinResult, len(cids))
+	var progress BulkProgress
+
+	// Fetch the graphs without holding the lock, sharing the visited blocks.
+	// The blocks visited by a failed walk are forgotten, as some of them were
+	// not fetched.
+	ng := merkledag.NewSession(ctx, p.dserv)
+	visited := cid.NewSet()
+	for i, c := range cids {
+		results[i].Cid = c
+		if recursive {
+			var walked []cid.Cid
+			err := merkledag.Walk(ctx, merkledag.GetLinksDirect(ng), c, func(k cid.Cid) bool {
+				if !visited.Visit(k) {
+					return false
+				}
+				walked = append(walked, k)
+				progress.Blocks++
+				return true
+			}, merkledag.Concurrent())
+			if err != nil {
+				for _, k := range walked {
+					visited.Remove(k)
+				}
+				results[i].Err = err
+			}
+		} else if _, err := ng.Get(ctx, c); err != nil {
+			results[i].Err = err
+		} else {
+			progress.Blocks++
+		}
+		o.progress(progress)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// If autosyncing, sync dag service before making any change to pins
+	err := p.flushDagService(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range cids {
+		if results[i].Err == nil && !recursive {
+			live, _, err := p.pinExpiry(ctx, p.cidRIndex, c.KeyString())
+			if err != nil {
+				return nil, err
+			}
+			if live {
+				results[i].Err = fmt.Errorf("%s already pinned recursively", c.String())
+			}
+		}
+		if results[i].Err == nil {
+			if recursive {
+				err = p.addRecursivePin(ctx, c, "", time.Time{})
+			} else {
+				err = p.addDirectPin(ctx, c, "", time.Time{})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		progress.Pins++
+		o.progress(progress)
+	}
+
+	return results, p.flushPins(ctx, false)
+}
+
+// UnpinMany unpins the cids, as Unpin, and returns the result of each of
+// them. The pins are removed at once, and synced together. A cid which is not
+// pinned, or only recursively when recursive is false, is not unpinned, and
+// does not prevent the others to be.
+//
+// The returned error is set if the pins could not be removed, in which case
+// the state of the pins is the one of a failed Unpin.
+func (p *pinner) UnpinMany(ctx context.Context, cids []cid.Cid, recursive bool, opts ...BulkOption) ([]PinResult, error) {
+	o := bulkOpts(opts)
+	results := make([]PinResult, len(cids))
+	var progress BulkProgress
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var removedAny bool
+	for i, c := range cids {
+		results[i].Cid = c
+		cidKey := c.KeyString()
+
+		has, err := p.cidRIndex.HasAny(ctx, cidKey)
+		if err != nil {
+			return nil, err
+		}
+		if has && !recursive {
+			results[i].Err = fmt.Errorf("%s is pinned recursively", c.String())
+		} else if !has {
+			has, err = p.cidDIndex.HasAny(ctx, cidKey)
+			if err != nil {
+				return nil, err
+			}
+			if !has {
+				results[i].Err = ipfspinner.ErrNotPinned
+			}
+		}
+
+		if results[i].Err == nil {
+			removed, err := p.removePinsForCid(ctx, c, ipfspinner.Any)
+			if err != nil {
+				return nil, err
+			}
+			removedAny = removedAny || removed
+		}
+		progress.Pins++
+		o.progress(progress)
+	}
+
+	if !removedAny {
+		return results, nil
+	}
+	return results, p.flushPins(ctx, false)
+}
@@ -0,0 +1,217 @@
+package dspinner
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipfspinner "github.com/ipfs/boxo/pinning/pinner"
+)
+
+var (
+	// checkBatchSize is the number of CIDs looked up in the pin indexes at
+	// once by CheckIfPinnedStream, holding the lock.
+	checkBatchSize = 1024
+	// indirectBatchSize is the number of CIDs, not pinned directly nor
+	// recursively, looked for at once in the DAGs of the recursive pins by
+	// CheckIfPinnedStream.
+	indirectBatchSize = 16 * 1024
+)
+
+// CheckIfPinnedStream checks if the cids are pinned, as CheckIfPinned, until
+// cids is closed, and streams the results. The results of the CIDs pinned
+// directly or recursively are sent first, the others once they are looked for
+// in the DAGs of the recursive pins, which are walked once for many CIDs. If
+// the pins cannot be checked, a result with Err set is sent, and the stream
+// ends.
+//
+// The CIDs are looked up in batches, and the lock of the pins is released
+// between them, so that the pins can be changed during a long check. The DAGs
+// are walked without the lock, from the recursive pins of the start of the
+// walk.
+func (p *pinner) CheckIfPinnedStream(ctx context.Context, cids <-chan cid.Cid) <-chan ipfspinner.Pinned {
+	out := make(chan ipfspinner.Pinned)
+
+	go func() {
+		defer close(out)
+
+		send := func(results []ipfspinner.Pinned, err error) bool {
+			if err != nil {
+				results = append(results[:0], ipfspinner.Pinned{Err: err})
+			}
+			for _, r := range results {
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return err == nil
+		}
+
+		batch := make([]cid.Cid, 0, checkBatchSize)
+		toCheck := cid.NewSet()
+		expired := cid.NewSet()
+		for {
+			var open bool
+			batch, open = readBatch(ctx, cids, batch[:0])
+			if ctx.Err() != nil {
+				return
+			}
+
+			if len(batch) != 0 {
+				results, err := p.checkRootPins(ctx, batch, toCheck, expired)
+				if !send(results, err) {
+					return
+				}
+			}
+
+			if toCheck.Len() >= indirectBatchSize || (!open && toCheck.Len() != 0) {
+				results, err := p.checkIndirectPins(ctx, toCheck, expired)
+				if !send(results, err) {
+					return
+				}
+				toCheck = cid.NewSet()
+				expired = cid.NewSet()
+			}
+
+			if !open {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// readBatch reads the CIDs available from cids, at least one unless cids is
+// closed, up to the capacity of batch. It returns false once cids is closed.
+func readBatch(ctx context.Context, cids <-chan cid.Cid, batch []cid.Cid) ([]cid.Cid, bool) {
+	select {
+	case c, ok := <-cids:
+		if !ok {
+			return batch, false
+		}
+		batch = append(batch, c)
+	case <-ctx.Done():
+		return batch, true
+	}
+	for len(batch) < cap(batch) {
+		select {
+		case c, ok := <-cids:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, c)
+		default:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// checkRootPins returns the results of the cids pinned directly or
+// recursively, and adds the others to toCheck, and to expired if they have
+// expired pins.
+func (p *pinner) checkRootPins(ctx context.Context, cids []cid.Cid, toCheck, expired *cid.Set) ([]ipfspinner.Pinned, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	var pinned []ipfspinner.Pinned
+	for _, c := range cids {
+		cidKey := c.KeyString()
+		has, _, err := p.pinExpiry(ctx, p.cidRIndex, cidKey)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			pinned = append(pinned, ipfspinner.Pinned{Key: c, Mode: ipfspinner.Recursive})
+			continue
+		}
+		has, _, err = p.pinExpiry(ctx, p.cidDIndex, cidKey)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			pinned = append(pinned, ipfspinner.Pinned{Key: c, Mode: ipfspinner.Direct})
+			continue
+		}
+		toCheck.Add(c)
+		has, err = p.expiryIndex.HasAny(ctx, cidKey)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			expired.Add(c)
+		}
+	}
+	return pinned, nil
+}
+
+// checkIndirectPins looks for the CIDs of toCheck in the DAGs of the
+// recursive pins, and returns their results. toCheck is emptied.
+func (p *pinner) checkIndirectPins(ctx context.Context, toCheck, expired *cid.Set) ([]ipfspinner.Pinned, error) {
+	roots, err := p.liveRecursiveRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := make([]ipfspinner.Pinned, 0, toCheck.Len())
+	visited := cid.NewSet()
+	for _, rk := range roots {
+		if toCheck.Len() == 0 {
+			break
+		}
+		err = merkledag.Walk(ctx, merkledag.GetLinksWithDAG(p.dserv), rk, func(c cid.Cid) bool {
+			if toCheck.Len() == 0 || !visited.Visit(c) {
+				return false
+			}
+			if toCheck.Has(c) {
+				pinned = append(pinned, ipfspinner.Pinned{Key: c, Mode: ipfspinner.Indirect, Via: rk})
+				toCheck.Remove(c)
+			}
+			return true
+		}, merkledag.Concurrent())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, k := range toCheck.Keys() {
+		pinned = append(pinned, ipfspinner.Pinned{Key: k, Mode: ipfspinner.NotPinned, Expired: expired.Has(k)})
+		toCheck.Remove(k)
+	}
+	return pinned, nil
+}
+
+// liveRecursiveRoots returns the CIDs pinned recursively by pins which have not
+// expired.
+func (p *pinner) liveRecursiveRoots(ctx context.Context) ([]cid.Cid, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	var roots []cid.Cid
+	seen := cid.NewSet()
+	var e error
+	err := p.cidRIndex.ForEach(ctx, "", func(key, value string) bool {
+		var exp bool
+		exp, e = p.pinExpired(ctx, key, value)
+		if e != nil || exp {
+			return e == nil
+		}
+		var rk cid.Cid
+		rk, e = cid.Cast([]byte(key))
+		if e != nil {
+			return false
+		}
+		if seen.Visit(rk) {
+			roots = append(roots, rk)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roots, e
+}
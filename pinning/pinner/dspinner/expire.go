@@ -0,0 +1,182 @@
+package dspinner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+
+	"github.com/ipfs/boxo/pinning/pinner/dsindex"
+)
+
+// PinOptions configures a pin added by PinWithOptions.
+type PinOptions struct {
+	// Recursive pins the DAG of the CID, instead of its block only.
+	Recursive bool
+
+	// Name is the name of the pin, listed by LsWithOptions.
+	Name string
+
+	// ExpiresAt, if not zero, is when the pin expires. An expired pin does
+	// not pin its CID anymore, but stays listed until it is removed by
+	// ReapExpired, or replaced by a new pin of its CID.
+	ExpiresAt time.Time
+}
+
+// PinWithOptions pins c, fetching its DAG if recursive. A recursive pin of c
+// lasting at least as long is kept, otherwise the pins of c are replaced.
+func (p *pinner) PinWithOptions(ctx context.Context, c cid.Cid, opts PinOptions) error {
+	if !opts.ExpiresAt.IsZero() && !opts.ExpiresAt.After(p.clock.Now()) {
+		return errors.New("pin expiry is not in the future")
+	}
+	if opts.Recursive {
+		return p.doPinRecursive(ctx, c, true, opts.Name, opts.ExpiresAt)
+	}
+	return p.doPinDirect(ctx, c, opts.Name, opts.ExpiresAt)
+}
+
+// ReapExpired removes the expired pins, and returns how many were removed.
+func (p *pinner) ReapExpired(ctx context.Context) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	keys := map[string]struct{}{}
+	err := p.expiryIndex.ForEach(ctx, "", func(key, value string) bool {
+		keys[key] = struct{}{}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	for key := range keys {
+		c, err := cid.Cast([]byte(key))
+		if err != nil {
+			return removed, err
+		}
+		n, err := p.removeExpiredPins(ctx, c)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, p.flushPins(ctx, false)
+}
+
+// removeExpiredPins removes the expired pins of c, and returns how many were
+// removed.
+func (p *pinner) removeExpiredPins(ctx context.Context, c cid.Cid) (int, error) {
+	cidKey := c.KeyString()
+	ids, err := p.expiryIndex.Search(ctx, cidKey)
+	if err != nil {
+		return 0, err
+	}
+
+	now := p.clock.Now()
+	var removed int
+	for _, id := range ids {
+		pp, err := p.loadPin(ctx, id)
+		if err == ds.ErrNotFound {
+			// the index of a removed pin
+			p.setDirty(ctx)
+			if err = p.expiryIndex.Delete(ctx, cidKey, id); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if !pp.expired(now) {
+			continue
+		}
+		if err = p.removePin(ctx, pp); err != nil {
+			return removed, fmt.Errorf("cannot remove expired pin: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (pp *pin) expired(now time.Time) bool {
+	return pp.ExpiresAt != 0 && pp.ExpiresAt <= now.UnixNano()
+}
+
+// pinExpired reports whether the pin id of the CID key is expired.
+func (p *pinner) pinExpired(ctx context.Context, cidKey, id string) (bool, error) {
+	expiring, err := p.expiryIndex.HasValue(ctx, cidKey, id)
+	if err != nil || !expiring {
+		return false, err
+	}
+	pp, err := p.loadPin(ctx, id)
+	if err == ds.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pp.expired(p.clock.Now()), nil
+}
+
+// pinExpiry reports whether the CID key has a pin in index which has not
+// expired, and when the last of them expires, zero if one of them does not
+// expire.
+func (p *pinner) pinExpiry(ctx context.Context, index dsindex.Indexer, cidKey string) (bool, time.Time, error) {
+	has, err := index.HasAny(ctx, cidKey)
+	if err != nil || !has {
+		return false, time.Time{}, err
+	}
+	expiring, err := p.expiryIndex.HasAny(ctx, cidKey)
+	if err != nil || !expiring {
+		return true, time.Time{}, err
+	}
+
+	ids, err := index.Search(ctx, cidKey)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	now := p.clock.Now()
+	var live bool
+	var until time.Time
+	for _, id := range ids {
+		expiring, err := p.expiryIndex.HasValue(ctx, cidKey, id)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		if !expiring {
+			return true, time.Time{}, nil
+		}
+		pp, err := p.loadPin(ctx, id)
+		if err == ds.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		if pp.expired(now) {
+			continue
+		}
+		live = true
+		if t := time.Unix(0, pp.ExpiresAt); t.After(until) {
+			until = t
+		}
+	}
+	return live, until, nil
+}
+
+// recursivePinCovers reports whether c has a recursive pin lasting at least
+// until expiresAt, or forever if it is zero.
+func (p *pinner) recursivePinCovers(ctx context.Context, c cid.Cid, expiresAt time.Time) (bool, error) {
+	live, until, err := p.pinExpiry(ctx, p.cidRIndex, c.KeyString())
+	if err != nil || !live {
+		return false, err
+	}
+	return until.IsZero() || (!expiresAt.IsZero() && !until.Before(expiresAt)), nil
+}
@@ -0,0 +1,292 @@
+package dspinner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore/query"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipfspinner "github.com/ipfs/boxo/pinning/pinner"
+)
+
+// The exports are newline delimited JSON: a header, followed by a line for
+// each pin.
+const (
+	exportFormat  = "dspinner-pins"
+	exportVersion = 1
+
+	// maxExportLine is the maximum length of the lines read by Import.
+	maxExportLine = 1 << 20
+)
+
+type exportHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+type exportedPin struct {
+	Cid       string                 `json:"cid"`
+	Mode      string                 `json:"mode"`
+	Name      string                 `json:"name,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	ExpiresAt *time.Time             `json:"expiresAt,omitempty"`
+}
+
+// Export writes the pins to w, one JSON object by line after a versioned
+// header, with their CID, mode, name, metadata and expiry. The expired pins
+// are exported too.
+func (p *pinner) Export(ctx context.Context, w io.Writer) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(exportHeader{Format: exportFormat, Version: exportVersion}); err != nil {
+		return err
+	}
+
+	results, err := p.dstore.Query(ctx, query.Query{Prefix: pinKeyPath})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for r := range results.Next() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if r.Error != nil {
+			return fmt.Errorf("cannot read pins: %v", r.Error)
+		}
+		pp, err := decodePin(path.Base(r.Entry.Key), r.Entry.Value)
+		if err != nil {
+			return err
+		}
+		mode, ok := ipfspinner.ModeToString(pp.Mode)
+		if !ok {
+			return fmt.Errorf("pin %s has unrecognized mode %d", pp.Id, pp.Mode)
+		}
+		ep := exportedPin{
+			Cid:      pp.Cid.String(),
+			Mode:     mode,
+			Name:     pp.Name,
+			Metadata: pp.Metadata,
+		}
+		if pp.ExpiresAt != 0 {
+			t := time.Unix(0, pp.ExpiresAt).UTC()
+			ep.ExpiresAt = &t
+		}
+		if err = enc.Encode(ep); err != nil {
+			return fmt.Errorf("cannot export pin %s: %w", pp.Id, err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ImportConflict is what Import does with a pin of a CID which is already
+// pinned.
+type ImportConflict int
+
+const (
+	// ImportSkip keeps the pins of the CID, and skips the imported one.
+	ImportSkip ImportConflict = iota
+	// ImportOverwrite replaces the pins of the CID by the imported one, with
+	// its mode and name.
+	ImportOverwrite
+	// ImportFail stops the import with an error.
+	ImportFail
+)
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	Conflict ImportConflict
+
+	// SkipErrors skips the malformed pins, and the ones which cannot be
+	// fetched, instead of stopping the import. They are reported in
+	// ImportResult.Errors.
+	SkipErrors bool
+
+	// Fetch, if set, is used to fetch the node of each pin, with all its
+	// descendants if recursive, before the pin is added. Otherwise the pins
+	// are added without checking that their blocks are present.
+	Fetch ipld.DAGService
+}
+
+// ImportResult is the result of Import.
+type ImportResult struct {
+	// Imported is the number of pins added, and Skipped the number of pins
+	// skipped as their CID was already pinned.
+	Imported int
+	Skipped  int
+	// Errors are the errors of the pins skipped with ImportOptions.SkipErrors.
+	Errors []*ImportLineError
+}
+
+// ImportLineError is the error of the pin of a line of an import.
+type ImportLineError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportLineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *ImportLineError) Unwrap() error {
+	return e.Err
+}
+
+// ErrPinConflict is the error of an imported pin whose CID is already pinned,
+// with ImportFail.
+var ErrPinConflict = errors.New("cid already pinned")
+
+// Import adds the pins read from r, written by Export. The DAGs of the pins
+// are not fetched unless opts.Fetch is set. The pins are synced at the end,
+// the ones imported before an error included.
+func (p *pinner) Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	var res ImportResult
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxExportLine)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return res, err
+		}
+		return res, errors.New("missing pin export header")
+	}
+	var header exportHeader
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil || header.Format != exportFormat {
+		return res, errors.New("invalid pin export header")
+	}
+	if header.Version != exportVersion {
+		return res, fmt.Errorf("unsupported pin export version %d", header.Version)
+	}
+
+	err := p.importPins(ctx, sc, opts, &res)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if res.Imported != 0 {
+		if ferr := p.flushPins(ctx, false); err == nil {
+			err = ferr
+		}
+	}
+	return res, err
+}
+
+func (p *pinner) importPins(ctx context.Context, sc *bufio.Scanner, opts ImportOptions, res *ImportResult) error {
+	for line := 2; sc.Scan(); line++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		pp, err := parseExportedPin(sc.Bytes())
+		if err == nil && opts.Fetch != nil {
+			err = fetchPin(ctx, opts.Fetch, pp)
+		}
+		if err != nil {
+			lerr := &ImportLineError{Line: line, Err: err}
+			if !opts.SkipErrors {
+				return lerr
+			}
+			res.Errors = append(res.Errors, lerr)
+			continue
+		}
+
+		imported, err := p.importPin(ctx, pp, opts.Conflict)
+		if err != nil {
+			return &ImportLineError{Line: line, Err: err}
+		}
+		if imported {
+			res.Imported++
+		} else {
+			res.Skipped++
+		}
+	}
+	return sc.Err()
+}
+
+func parseExportedPin(b []byte) (*pin, error) {
+	var ep exportedPin
+	if err := json.Unmarshal(b, &ep); err != nil {
+		return nil, fmt.Errorf("invalid pin: %w", err)
+	}
+	c, err := cid.Decode(ep.Cid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pin cid: %w", err)
+	}
+	mode, ok := ipfspinner.StringToMode(ep.Mode)
+	if !ok || (mode != ipfspinner.Recursive && mode != ipfspinner.Direct) {
+		return nil, fmt.Errorf("invalid pin mode %q", ep.Mode)
+	}
+
+	pp := newPin(c, mode, ep.Name)
+	pp.Metadata = ep.Metadata
+	if ep.ExpiresAt != nil {
+		pp.ExpiresAt = ep.ExpiresAt.UnixNano()
+	}
+	return pp, nil
+}
+
+func fetchPin(ctx context.Context, ng ipld.DAGService, pp *pin) error {
+	var err error
+	if pp.Mode == ipfspinner.Recursive {
+		err = merkledag.FetchGraph(ctx, pp.Cid, ng)
+	} else {
+		_, err = ng.Get(ctx, pp.Cid)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot fetch %s: %w", pp.Cid, err)
+	}
+	return nil
+}
+
+// importPin adds pp, unless its CID is already pinned and conflict is
+// ImportSkip, and reports whether it was added.
+func (p *pinner) importPin(ctx context.Context, pp *pin, conflict ImportConflict) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, err := p.removeExpiredPins(ctx, pp.Cid); err != nil {
+		return false, err
+	}
+	cidKey := pp.Cid.KeyString()
+	pinned, err := p.cidRIndex.HasAny(ctx, cidKey)
+	if err == nil && !pinned {
+		pinned, err = p.cidDIndex.HasAny(ctx, cidKey)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if pinned {
+		switch conflict {
+		case ImportSkip:
+			return false, nil
+		case ImportOverwrite:
+			if _, err = p.removePinsForCid(ctx, pp.Cid, ipfspinner.Any); err != nil {
+				return false, err
+			}
+		default:
+			return false, fmt.Errorf("%w: %s", ErrPinConflict, pp.Cid)
+		}
+	}
+
+	if err = p.storePin(ctx, pp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
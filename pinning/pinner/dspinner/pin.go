@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"path"
 	"sync"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
@@ -36,9 +38,10 @@ var (
 
 	linkDirect, linkRecursive string
 
-	pinCidDIndexPath string
-	pinCidRIndexPath string
-	pinNameIndexPath string
+	pinCidDIndexPath   string
+	pinCidRIndexPath   string
+	pinNameIndexPath   string
+	pinExpiryIndexPath string
 
 	dirtyKey = ds.NewKey(dirtyKeyPath)
 
@@ -61,6 +64,7 @@ func init() {
 	pinCidRIndexPath = path.Join(indexKeyPath, "cidRindex")
 	pinCidDIndexPath = path.Join(indexKeyPath, "cidDindex")
 	pinNameIndexPath = path.Join(indexKeyPath, "nameIndex")
+	pinExpiryIndexPath = path.Join(indexKeyPath, "expiryIndex")
 
 	pinAtl = atlas.MustBuild(
 		atlas.BuildEntry(pin{}).StructMap().
@@ -68,6 +72,7 @@ func init() {
 			AddField("Metadata", atlas.StructMapEntry{SerialName: "metadata", OmitEmpty: true}).
 			AddField("Mode", atlas.StructMapEntry{SerialName: "mode"}).
 			AddField("Name", atlas.StructMapEntry{SerialName: "name", OmitEmpty: true}).
+			AddField("ExpiresAt", atlas.StructMapEntry{SerialName: "expiresAt", OmitEmpty: true}).
 			Complete(),
 		atlas.BuildEntry(cid.Cid{}).Transform().
 			TransformMarshal(atlas.MakeMarshalTransformFunc(func(live cid.Cid) ([]byte, error) { return live.MarshalBinary() })).
@@ -94,6 +99,10 @@ type pinner struct {
 	cidDIndex dsindex.Indexer
 	cidRIndex dsindex.Indexer
 	nameIndex dsindex.Indexer
+	// expiryIndex indexes the pins which expire by CID
+	expiryIndex dsindex.Indexer
+
+	clock clock.Clock
 
 	clean int64
 	dirty int64
@@ -107,6 +116,9 @@ type pin struct {
 	Metadata map[string]interface{}
 	Mode     ipfspinner.Mode
 	Name     string
+	// ExpiresAt is the expiry of the pin in Unix nanoseconds, zero if the
+	// pin does not expire.
+	ExpiresAt int64
 }
 
 func (p *pin) dsKey() ds.Key {
@@ -135,12 +147,14 @@ type syncDAGService interface {
 // called explicitly.
 func New(ctx context.Context, dstore ds.Datastore, dserv ipld.DAGService) (*pinner, error) {
 	p := &pinner{
-		autoSync:  true,
-		cidDIndex: dsindex.New(dstore, ds.NewKey(pinCidDIndexPath)),
-		cidRIndex: dsindex.New(dstore, ds.NewKey(pinCidRIndexPath)),
-		nameIndex: dsindex.New(dstore, ds.NewKey(pinNameIndexPath)),
-		dserv:     dserv,
-		dstore:    dstore,
+		autoSync:    true,
+		cidDIndex:   dsindex.New(dstore, ds.NewKey(pinCidDIndexPath)),
+		cidRIndex:   dsindex.New(dstore, ds.NewKey(pinCidRIndexPath)),
+		nameIndex:   dsindex.New(dstore, ds.NewKey(pinNameIndexPath)),
+		expiryIndex: dsindex.New(dstore, ds.NewKey(pinExpiryIndexPath)),
+		clock:       clock.New(),
+		dserv:       dserv,
+		dstore:      dstore,
 	}
 
 	data, err := dstore.Get(ctx, dirtyKey)
@@ -181,28 +195,24 @@ func (p *pinner) Pin(ctx context.Context, node ipld.Node, recurse bool) error {
 	}
 
 	if recurse {
-		return p.doPinRecursive(ctx, node.Cid(), true)
+		return p.doPinRecursive(ctx, node.Cid(), true, "", time.Time{})
 	} else {
-		return p.doPinDirect(ctx, node.Cid())
+		return p.doPinDirect(ctx, node.Cid(), "", time.Time{})
 	}
 }
 
-func (p *pinner) doPinRecursive(ctx context.Context, c cid.Cid, fetch bool) error {
-	cidKey := c.KeyString()
-
+func (p *pinner) doPinRecursive(ctx context.Context, c cid.Cid, fetch bool, name string, expiresAt time.Time) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	found, err := p.cidRIndex.HasAny(ctx, cidKey)
+	covered, err := p.recursivePinCovers(ctx, c, expiresAt)
 	if err != nil {
 		return err
 	}
-	if found {
+	if covered {
 		return nil
 	}
 
-	dirtyBefore := p.dirty
-
 	if fetch {
 		// temporary unlock to fetch the entire graph
 		p.lock.Unlock()
@@ -220,66 +230,96 @@ func (p *pinner) doPinRecursive(ctx context.Context, c cid.Cid, fetch bool) erro
 		return err
 	}
 
-	// Only look again if something has changed.
-	if p.dirty != dirtyBefore {
-		found, err = p.cidRIndex.HasAny(ctx, cidKey)
-		if err != nil {
-			return err
-		}
-		if found {
-			return nil
-		}
+	err = p.addRecursivePin(ctx, c, name, expiresAt)
+	if err != nil {
+		return err
 	}
+	return p.flushPins(ctx, false)
+}
 
-	// TODO: remove this to support multiple pins per CID
-	found, err = p.cidDIndex.HasAny(ctx, cidKey)
+// addRecursivePin adds a recursive pin for c, unless it has one which lasts as
+// long, replacing its other recursive pins and its direct pins.
+func (p *pinner) addRecursivePin(ctx context.Context, c cid.Cid, name string, expiresAt time.Time) error {
+	if _, err := p.removeExpiredPins(ctx, c); err != nil {
+		return err
+	}
+
+	// Look again, the pins could have changed while fetching.
+	covered, err := p.recursivePinCovers(ctx, c, expiresAt)
 	if err != nil {
 		return err
 	}
-	if found {
-		_, err = p.removePinsForCid(ctx, c, ipfspinner.Direct)
-		if err != nil {
-			return err
-		}
+	if covered {
+		return nil
 	}
 
-	_, err = p.addPin(ctx, c, ipfspinner.Recursive, "")
+	// The pins lasting less than the new one are replaced, which includes
+	// the direct ones.
+	// TODO: remove this to support multiple pins per CID
+	_, err = p.removePinsForCid(ctx, c, ipfspinner.Any)
 	if err != nil {
 		return err
 	}
-	return p.flushPins(ctx, false)
-}
 
-func (p *pinner) doPinDirect(ctx context.Context, c cid.Cid) error {
-	cidKey := c.KeyString()
+	_, err = p.addExpiringPin(ctx, c, ipfspinner.Recursive, name, expiresAt)
+	return err
+}
 
+func (p *pinner) doPinDirect(ctx context.Context, c cid.Cid, name string, expiresAt time.Time) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	found, err := p.cidRIndex.HasAny(ctx, cidKey)
+	err := p.addDirectPin(ctx, c, name, expiresAt)
 	if err != nil {
 		return err
 	}
-	if found {
-		return fmt.Errorf("%s already pinned recursively", c.String())
+
+	return p.flushPins(ctx, false)
+}
+
+// addDirectPin adds a direct pin for c, unless it is recursively pinned. The
+// expired pins of c are replaced.
+func (p *pinner) addDirectPin(ctx context.Context, c cid.Cid, name string, expiresAt time.Time) error {
+	if _, err := p.removeExpiredPins(ctx, c); err != nil {
+		return err
 	}
 
-	_, err = p.addPin(ctx, c, ipfspinner.Direct, "")
+	found, err := p.cidRIndex.HasAny(ctx, c.KeyString())
 	if err != nil {
 		return err
 	}
+	if found {
+		return fmt.Errorf("%s already pinned recursively", c.String())
+	}
 
-	return p.flushPins(ctx, false)
+	_, err = p.addExpiringPin(ctx, c, ipfspinner.Direct, name, expiresAt)
+	return err
 }
 
 func (p *pinner) addPin(ctx context.Context, c cid.Cid, mode ipfspinner.Mode, name string) (string, error) {
+	return p.addExpiringPin(ctx, c, mode, name, time.Time{})
+}
+
+// addExpiringPin adds a pin which expires at expiresAt, or never if it is
+// zero.
+func (p *pinner) addExpiringPin(ctx context.Context, c cid.Cid, mode ipfspinner.Mode, name string, expiresAt time.Time) (string, error) {
 	// Create new pin and store in datastore
 	pp := newPin(c, mode, name)
+	if !expiresAt.IsZero() {
+		pp.ExpiresAt = expiresAt.UnixNano()
+	}
+	if err := p.storePin(ctx, pp); err != nil {
+		return "", err
+	}
+	return pp.Id, nil
+}
 
+// storePin stores the new pin pp, and its indexes.
+func (p *pinner) storePin(ctx context.Context, pp *pin) error {
 	// Serialize pin
 	pinData, err := encodePin(pp)
 	if err != nil {
-		return "", fmt.Errorf("could not encode pin: %v", err)
+		return fmt.Errorf("could not encode pin: %v", err)
 	}
 
 	p.setDirty(ctx)
@@ -287,42 +327,51 @@ func (p *pinner) addPin(ctx context.Context, c cid.Cid, mode ipfspinner.Mode, na
 	// Store the pin
 	err = p.dstore.Put(ctx, pp.dsKey(), pinData)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	// Store the expiry index before the CID index, for the pin not to be
+	// seen as permanent
+	if pp.ExpiresAt != 0 {
+		err = p.expiryIndex.Add(ctx, pp.Cid.KeyString(), pp.Id)
+		if err != nil {
+			return fmt.Errorf("could not add pin expiry index: %v", err)
+		}
 	}
 
 	// Store CID index
-	switch mode {
+	switch pp.Mode {
 	case ipfspinner.Recursive:
-		err = p.cidRIndex.Add(ctx, c.KeyString(), pp.Id)
+		err = p.cidRIndex.Add(ctx, pp.Cid.KeyString(), pp.Id)
 	case ipfspinner.Direct:
-		err = p.cidDIndex.Add(ctx, c.KeyString(), pp.Id)
+		err = p.cidDIndex.Add(ctx, pp.Cid.KeyString(), pp.Id)
 	default:
 		panic("pin mode must be recursive or direct")
 	}
 	if err != nil {
-		return "", fmt.Errorf("could not add pin cid index: %v", err)
+		return fmt.Errorf("could not add pin cid index: %v", err)
 	}
 
-	if name != "" {
+	if pp.Name != "" {
 		// Store name index
-		err = p.nameIndex.Add(ctx, name, pp.Id)
+		err = p.nameIndex.Add(ctx, pp.Name, pp.Id)
 		if err != nil {
-			if mode == ipfspinner.Recursive {
-				e := p.cidRIndex.Delete(ctx, c.KeyString(), pp.Id)
+			if pp.Mode == ipfspinner.Recursive {
+				e := p.cidRIndex.Delete(ctx, pp.Cid.KeyString(), pp.Id)
 				if e != nil {
 					log.Errorf("error deleting index: %s", e)
 				}
 			} else {
-				e := p.cidDIndex.Delete(ctx, c.KeyString(), pp.Id)
+				e := p.cidDIndex.Delete(ctx, pp.Cid.KeyString(), pp.Id)
 				if e != nil {
 					log.Errorf("error deleting index: %s", e)
 				}
 			}
-			return "", fmt.Errorf("could not add pin name index: %v", err)
+			return fmt.Errorf("could not add pin name index: %v", err)
 		}
 	}
 
-	return pp.Id, nil
+	return nil
 }
 
 func (p *pinner) removePin(ctx context.Context, pp *pin) error {
@@ -339,6 +388,13 @@ func (p *pinner) removePin(ctx context.Context, pp *pin) error {
 		return err
 	}
 
+	if pp.ExpiresAt != 0 {
+		err = p.expiryIndex.Delete(ctx, pp.Cid.KeyString(), pp.Id)
+		if err != nil {
+			return err
+		}
+	}
+
 	if pp.Name != "" {
 		// Remove name index from datastore
 		err = p.nameIndex.Delete(ctx, pp.Name, pp.Id)
@@ -421,7 +477,7 @@ func (p *pinner) isPinnedWithType(ctx context.Context, c cid.Cid, mode ipfspinne
 	cidKey := c.KeyString()
 	switch mode {
 	case ipfspinner.Recursive:
-		has, err := p.cidRIndex.HasAny(ctx, cidKey)
+		has, _, err := p.pinExpiry(ctx, p.cidRIndex, cidKey)
 		if err != nil {
 			return "", false, err
 		}
@@ -430,7 +486,7 @@ func (p *pinner) isPinnedWithType(ctx context.Context, c cid.Cid, mode ipfspinne
 		}
 		return "", false, nil
 	case ipfspinner.Direct:
-		has, err := p.cidDIndex.HasAny(ctx, cidKey)
+		has, _, err := p.pinExpiry(ctx, p.cidDIndex, cidKey)
 		if err != nil {
 			return "", false, err
 		}
@@ -442,14 +498,14 @@ func (p *pinner) isPinnedWithType(ctx context.Context, c cid.Cid, mode ipfspinne
 		return "", false, nil
 	case ipfspinner.Indirect:
 	case ipfspinner.Any:
-		has, err := p.cidRIndex.HasAny(ctx, cidKey)
+		has, _, err := p.pinExpiry(ctx, p.cidRIndex, cidKey)
 		if err != nil {
 			return "", false, err
 		}
 		if has {
 			return linkRecursive, true, nil
 		}
-		has, err = p.cidDIndex.HasAny(ctx, cidKey)
+		has, _, err = p.pinExpiry(ctx, p.cidDIndex, cidKey)
 		if err != nil {
 			return "", false, err
 		}
@@ -472,6 +528,14 @@ func (p *pinner) isPinnedWithType(ctx context.Context, c cid.Cid, mode ipfspinne
 	var rc cid.Cid
 	var e error
 	err := p.cidRIndex.ForEach(ctx, "", func(key, value string) bool {
+		var expired bool
+		expired, e = p.pinExpired(ctx, key, value)
+		if e != nil {
+			return false
+		}
+		if expired {
+			return true
+		}
 		rc, e = cid.Cast([]byte(key))
 		if e != nil {
 			return false
@@ -506,6 +570,7 @@ func (p *pinner) isPinnedWithType(ctx context.Context, c cid.Cid, mode ipfspinne
 func (p *pinner) CheckIfPinned(ctx context.Context, cids ...cid.Cid) ([]ipfspinner.Pinned, error) {
 	pinned := make([]ipfspinner.Pinned, 0, len(cids))
 	toCheck := cid.NewSet()
+	expired := cid.NewSet()
 
 	p.lock.RLock()
 	defer p.lock.RUnlock()
@@ -513,14 +578,14 @@ func (p *pinner) CheckIfPinned(ctx context.Context, cids ...cid.Cid) ([]ipfspinn
 	// First check for non-Indirect pins directly
 	for _, c := range cids {
 		cidKey := c.KeyString()
-		has, err := p.cidRIndex.HasAny(ctx, cidKey)
+		has, _, err := p.pinExpiry(ctx, p.cidRIndex, cidKey)
 		if err != nil {
 			return nil, err
 		}
 		if has {
 			pinned = append(pinned, ipfspinner.Pinned{Key: c, Mode: ipfspinner.Recursive})
 		} else {
-			has, err = p.cidDIndex.HasAny(ctx, cidKey)
+			has, _, err = p.pinExpiry(ctx, p.cidDIndex, cidKey)
 			if err != nil {
 				return nil, err
 			}
@@ -528,6 +593,14 @@ func (p *pinner) CheckIfPinned(ctx context.Context, cids ...cid.Cid) ([]ipfspinn
 				pinned = append(pinned, ipfspinner.Pinned{Key: c, Mode: ipfspinner.Direct})
 			} else {
 				toCheck.Add(c)
+				// the pins left are expired ones
+				has, err = p.expiryIndex.HasAny(ctx, cidKey)
+				if err != nil {
+					return nil, err
+				}
+				if has {
+					expired.Add(c)
+				}
 			}
 		}
 	}
@@ -535,6 +608,14 @@ func (p *pinner) CheckIfPinned(ctx context.Context, cids ...cid.Cid) ([]ipfspinn
 	var e error
 	visited := cid.NewSet()
 	err := p.cidRIndex.ForEach(ctx, "", func(key, value string) bool {
+		var exp bool
+		exp, e = p.pinExpired(ctx, key, value)
+		if e != nil {
+			return false
+		}
+		if exp {
+			return true
+		}
 		var rk cid.Cid
 		rk, e = cid.Cast([]byte(key))
 		if e != nil {
@@ -566,7 +647,7 @@ func (p *pinner) CheckIfPinned(ctx context.Context, cids ...cid.Cid) ([]ipfspinn
 
 	// Anything left in toCheck is not pinned
 	for _, k := range toCheck.Keys() {
-		pinned = append(pinned, ipfspinner.Pinned{Key: k, Mode: ipfspinner.NotPinned})
+		pinned = append(pinned, ipfspinner.Pinned{Key: k, Mode: ipfspinner.NotPinned, Expired: expired.Has(k)})
 	}
 
 	return pinned, nil
@@ -686,6 +767,14 @@ func (p *pinner) streamIndex(ctx context.Context, index dsindex.Indexer) <-chan
 		cidSet := cid.NewSet()
 
 		err := index.ForEach(ctx, "", func(key, value string) bool {
+			expired, err := p.pinExpired(ctx, key, value)
+			if err != nil {
+				out <- ipfspinner.StreamedCid{Err: err}
+				return false
+			}
+			if expired {
+				return true
+			}
 			c, err := cid.Cast([]byte(key))
 			if err != nil {
 				out <- ipfspinner.StreamedCid{Err: err}
@@ -725,7 +814,7 @@ func (p *pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	found, err := p.cidRIndex.HasAny(ctx, from.KeyString())
+	found, _, err := p.pinExpiry(ctx, p.cidRIndex, from.KeyString())
 	if err != nil {
 		return err
 	}
@@ -739,7 +828,7 @@ func (p *pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error
 	}
 
 	// Check if the `to` cid is already recursively pinned
-	found, err = p.cidRIndex.HasAny(ctx, to.KeyString())
+	found, _, err = p.pinExpiry(ctx, p.cidRIndex, to.KeyString())
 	if err != nil {
 		return err
 	}
@@ -756,7 +845,7 @@ func (p *pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error
 		return err
 	}
 
-	_, err = p.addPin(ctx, to, ipfspinner.Recursive, "")
+	err = p.addRecursivePin(ctx, to, "", time.Time{})
 	if err != nil {
 		return err
 	}
@@ -813,9 +902,9 @@ func (p *pinner) PinWithMode(ctx context.Context, c cid.Cid, mode ipfspinner.Mod
 	// TODO: remove his to support multiple pins per CID
 	switch mode {
 	case ipfspinner.Recursive:
-		return p.doPinRecursive(ctx, c, false)
+		return p.doPinRecursive(ctx, c, false, "", time.Time{})
 	case ipfspinner.Direct:
-		return p.doPinDirect(ctx, c)
+		return p.doPinDirect(ctx, c, "", time.Time{})
 	default:
 		return errors.New("unrecognized pin mode")
 	}
@@ -997,6 +1086,20 @@ func (p *pinner) rebuildIndexes(ctx context.Context) error {
 				repaired = true
 			}
 		}
+		// Check for missing expiry index
+		if pp.ExpiresAt != 0 {
+			ok, err = p.expiryIndex.HasValue(ctx, indexKey, pp.Id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				log.Errorf("repairing expiry pin index for cid: %s", pp.Cid.String())
+				if err = p.expiryIndex.Add(ctx, indexKey, pp.Id); err != nil {
+					return err
+				}
+				repaired = true
+			}
+		}
 		// Check for missing name index
 		if pp.Name != "" {
 			ok, err = p.nameIndex.HasValue(ctx, pp.Name, pp.Id)
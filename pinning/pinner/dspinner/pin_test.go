@@ -5,13 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	mrand "math/rand"
 	"path"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/benbjohnson/clock"
+
 	bs "github.com/ipfs/boxo/blockservice"
 	mdag "github.com/ipfs/boxo/ipld/merkledag"
 
+	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
@@ -1347,3 +1353,929 @@ func verifyIndexValue(ctx context.Context, pinner *pinner, cidKey, expectedPid s
 	}
 	return nil
 }
+
+func lsPins(t *testing.T, p *pinner, q PinQuery) []PinInfo {
+	infos, err := p.LsWithOptions(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pins []PinInfo
+	for info := range infos {
+		if info.Err != nil {
+			t.Fatal(info.Err)
+		}
+		pins = append(pins, info)
+	}
+	return pins
+}
+
+func TestLsWithOptions(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := makeStore()
+	p, err := New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 30 recursive and 20 direct pins, named alpha-N, beta-N, shared or
+	// unnamed
+	type pinned struct {
+		mode ipfspin.Mode
+		name string
+	}
+	pins := map[cid.Cid]pinned{}
+	for i := 0; i < 50; i++ {
+		_, c := randNode()
+		mode := ipfspin.Recursive
+		if i >= 30 {
+			mode = ipfspin.Direct
+		}
+		var name string
+		switch i % 4 {
+		case 0:
+			name = fmt.Sprint("alpha-", i)
+		case 1:
+			name = fmt.Sprint("beta-", i)
+		case 2:
+			name = "shared"
+		}
+		if _, err = p.addPin(ctx, c, mode, name); err != nil {
+			t.Fatal(err)
+		}
+		pins[c] = pinned{mode, name}
+	}
+
+	for _, q := range []PinQuery{
+		{Mode: ipfspin.Any},
+		{Mode: ipfspin.Recursive},
+		{Mode: ipfspin.Direct},
+		{Mode: ipfspin.Any, Name: "shared"},
+		{Mode: ipfspin.Direct, Name: "shared"},
+		{Mode: ipfspin.Any, NamePrefix: "alpha-"},
+		{Mode: ipfspin.Recursive, NamePrefix: "al"},
+		{Mode: ipfspin.Any, NamePrefix: "beta-3"},
+		{Mode: ipfspin.Any, NamePrefix: "gamma"},
+	} {
+		q := q
+		t.Run(fmt.Sprintf("%+v", q), func(t *testing.T) {
+			expected := map[cid.Cid]bool{}
+			for c, pp := range pins {
+				if q.Mode != ipfspin.Any && pp.mode != q.Mode {
+					continue
+				}
+				if q.Name != "" && pp.name != q.Name {
+					continue
+				}
+				if q.NamePrefix != "" && !strings.HasPrefix(pp.name, q.NamePrefix) {
+					continue
+				}
+				expected[c] = true
+			}
+
+			all := lsPins(t, p, q)
+			if len(all) != len(expected) {
+				t.Fatalf("expected %d pins, got %d", len(expected), len(all))
+			}
+			for _, info := range all {
+				pp := pins[info.Cid]
+				if !expected[info.Cid] || info.Mode != pp.mode || info.Name != pp.name {
+					t.Fatalf("unexpected pin %+v", info)
+				}
+			}
+
+			// the pages list the same pins, in the same order
+			const pageSize = 7
+			var paged []PinInfo
+			q.Limit = pageSize
+			for {
+				page := lsPins(t, p, q)
+				if len(page) > pageSize {
+					t.Fatalf("expected at most %d pins, got %d", pageSize, len(page))
+				}
+				if len(page) == 0 {
+					break
+				}
+				if len(page) < pageSize && len(paged)+len(page) != len(all) {
+					t.Fatal("short page before the last one")
+				}
+				paged = append(paged, page...)
+				q.After = page[len(page)-1].Cursor
+			}
+			if len(paged) != len(all) {
+				t.Fatalf("expected %d paged pins, got %d", len(all), len(paged))
+			}
+			for i := range all {
+				if all[i].Cid != paged[i].Cid {
+					t.Fatalf("pin %d differs between the listing and its pages", i)
+				}
+			}
+		})
+	}
+
+	for _, q := range []PinQuery{
+		{Mode: ipfspin.Indirect},
+		{Mode: ipfspin.Any, Name: "shared", NamePrefix: "s"},
+		{Mode: ipfspin.Any, After: "garbage"},
+		{Mode: ipfspin.Any, Name: "shared", After: encodeCursor(cursorRecursive, "a", "b")},
+		{Mode: ipfspin.Recursive, After: encodeCursor(cursorDirect, "a", "b")},
+	} {
+		if _, err := p.LsWithOptions(ctx, q); err == nil {
+			t.Fatalf("expected an error for %+v", q)
+		}
+	}
+}
+
+// countingDAGService counts the nodes got from the DAG service.
+type countingDAGService struct {
+	ipld.DAGService
+	gets atomic.Int64
+}
+
+func (d *countingDAGService) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	d.gets.Add(1)
+	return d.DAGService.Get(ctx, c)
+}
+
+// makeOverlappingDAGs makes count roots, each linking to its own leaf and to
+// a shared subtree of shared nodes.
+func makeOverlappingDAGs(ctx context.Context, dserv ipld.DAGService, count, shared int) ([]ipld.Node, error) {
+	var prev *mdag.ProtoNode
+	for i := 0; i < shared; i++ {
+		n, _ := randNode()
+		if prev != nil {
+			if err := n.AddNodeLink("child", prev); err != nil {
+				return nil, err
+			}
+		}
+		if err := dserv.Add(ctx, n); err != nil {
+			return nil, err
+		}
+		prev = n
+	}
+
+	roots := make([]ipld.Node, count)
+	for i := range roots {
+		leaf, _ := randNode()
+		if err := dserv.Add(ctx, leaf); err != nil {
+			return nil, err
+		}
+		root, _ := randNode()
+		if err := root.AddNodeLink("leaf", leaf); err != nil {
+			return nil, err
+		}
+		if err := root.AddNodeLink("shared", prev); err != nil {
+			return nil, err
+		}
+		if err := dserv.Add(ctx, root); err != nil {
+			return nil, err
+		}
+		roots[i] = root
+	}
+	return roots, nil
+}
+
+func TestPinMany(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := makeStore()
+	counting := &countingDAGService{DAGService: dserv}
+	p, err := New(ctx, dstore, counting)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots, err := makeOverlappingDAGs(ctx, dserv, 5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a root with a missing child, whose nodes are shared with the next one
+	missing, _ := randNode()
+	partial, _ := randNode()
+	if err = partial.AddNodeLink("missing", missing); err != nil {
+		t.Fatal(err)
+	}
+	if err = dserv.Add(ctx, partial); err != nil {
+		t.Fatal(err)
+	}
+	sharingMissing, _ := randNode()
+	if err = sharingMissing.AddNodeLink("missing", missing); err != nil {
+		t.Fatal(err)
+	}
+	if err = dserv.Add(ctx, sharingMissing); err != nil {
+		t.Fatal(err)
+	}
+	_, absent := randNode()
+
+	cids := []cid.Cid{roots[0].Cid(), partial.Cid(), sharingMissing.Cid(), absent}
+	for _, r := range roots[1:] {
+		cids = append(cids, r.Cid())
+	}
+
+	var progress []BulkProgress
+	results, err := p.PinMany(ctx, cids, true, WithBulkProgress(func(bp BulkProgress) {
+		progress = append(progress, bp)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gets := counting.gets.Load()
+	if len(results) != len(cids) {
+		t.Fatalf("expected %d results, got %d", len(cids), len(results))
+	}
+	for i, r := range results {
+		if r.Cid != cids[i] {
+			t.Fatalf("result %d is for %s instead of %s", i, r.Cid, cids[i])
+		}
+		failed := i >= 1 && i <= 3
+		if failed != (r.Err != nil) {
+			t.Fatalf("unexpected result for %s: %v", r.Cid, r.Err)
+		}
+		if failed {
+			assertUnpinned(t, p, r.Cid, "failed pin is pinned")
+		} else {
+			assertPinnedWithType(t, p, r.Cid, ipfspin.Recursive, "pin is not pinned")
+		}
+	}
+
+	// the 10 shared nodes, and the 5 roots and their leaves, are fetched
+	// once, besides the nodes of the failed pins
+	const fetched = 10 + 5*2
+	if gets > fetched+5 {
+		t.Fatalf("expected at most %d fetches, got %d", fetched+5, gets)
+	}
+	last := progress[len(progress)-1]
+	if last.Pins != len(cids) || last.Blocks < fetched {
+		t.Fatalf("unexpected last progress %+v", last)
+	}
+
+	// the direct pins are not added over the recursive ones
+	err = p.PinWithMode(ctx, roots[0].Links()[0].Cid, ipfspin.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = p.PinMany(ctx, []cid.Cid{roots[0].Cid(), partial.Cid()}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err == nil || results[1].Err != nil {
+		t.Fatalf("unexpected direct pin results %+v", results)
+	}
+	assertPinnedWithType(t, p, partial.Cid(), ipfspin.Direct, "direct pin is not pinned")
+
+	results, err = p.UnpinMany(ctx, []cid.Cid{roots[1].Cid(), partial.Cid(), absent, roots[2].Cid()}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err == nil || results[1].Err != nil || !errors.Is(results[2].Err, ipfspin.ErrNotPinned) || results[3].Err == nil {
+		t.Fatalf("unexpected non recursive unpin results %+v", results)
+	}
+	assertUnpinned(t, p, partial.Cid(), "direct pin is still pinned")
+
+	results, err = p.UnpinMany(ctx, []cid.Cid{roots[1].Cid(), roots[2].Cid()}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		assertUnpinned(t, p, r.Cid, "recursive pin is still pinned")
+	}
+	assertPinned(t, p, roots[3].Cid(), "other pin was unpinned")
+}
+
+// BenchmarkPinMany compares pinning DAGs sharing most of their nodes with
+// PinMany and with a Pin each, reporting the nodes fetched.
+func BenchmarkPinMany(b *testing.B) {
+	for _, many := range []bool{false, true} {
+		name := "Pin"
+		if many {
+			name = "PinMany"
+		}
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			dstore, dserv := makeStore()
+			counting := &countingDAGService{DAGService: dserv}
+			pinner, err := New(ctx, dstore, counting)
+			if err != nil {
+				b.Fatal(err)
+			}
+			roots, err := makeOverlappingDAGs(ctx, dserv, 100, 100)
+			if err != nil {
+				b.Fatal(err)
+			}
+			cids := make([]cid.Cid, len(roots))
+			for i, r := range roots {
+				cids[i] = r.Cid()
+			}
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if many {
+					if _, err = pinner.PinMany(ctx, cids, true); err != nil {
+						b.Fatal(err)
+					}
+				} else {
+					for _, r := range roots {
+						if err = pinner.Pin(ctx, r, true); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+
+				b.StopTimer()
+				unpinNodes(roots, pinner)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(counting.gets.Load())/float64(b.N), "fetches/op")
+		})
+	}
+}
+
+func TestPinExpiry(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := makeStore()
+	p, err := New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewMock()
+	p.clock = clk
+
+	a, _ := randNode()
+	b, bk := randNode()
+	if err = dserv.Add(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	child, ck := randNode()
+	if err = dserv.Add(ctx, child); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	if err = dserv.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	ak := a.Cid()
+
+	err = p.PinWithOptions(ctx, ak, PinOptions{Recursive: true, ExpiresAt: clk.Now()})
+	if err == nil {
+		t.Fatal("expected error pinning with an expiry in the past")
+	}
+
+	expiresAt := clk.Now().Add(time.Hour)
+	if err = p.PinWithOptions(ctx, ak, PinOptions{Recursive: true, Name: "a", ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.PinWithOptions(ctx, bk, PinOptions{ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Add(time.Hour - time.Nanosecond)
+	assertPinnedWithType(t, p, ak, ipfspin.Recursive, "pin expired before its expiry")
+	assertPinned(t, p, ck, "child unpinned before the expiry")
+	assertPinnedWithType(t, p, bk, ipfspin.Direct, "pin expired before its expiry")
+
+	clk.Add(time.Nanosecond)
+	assertUnpinned(t, p, ak, "expired pin still pinned")
+	assertUnpinned(t, p, ck, "child of expired pin still pinned")
+	assertUnpinned(t, p, bk, "expired pin still pinned")
+
+	res, err := p.CheckIfPinned(ctx, ak, ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Mode != ipfspin.NotPinned {
+			t.Fatalf("%s should not be pinned", r.Key)
+		}
+		if r.Expired != r.Key.Equals(ak) {
+			t.Fatalf("%s: wrong expired %t", r.Key, r.Expired)
+		}
+	}
+
+	for c := range p.RecursiveKeys(ctx) {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		t.Fatalf("expired pin listed as recursive key %s", c.C)
+	}
+
+	// the expired pins are listed until they are removed
+	pins := lsPins(t, p, PinQuery{Mode: ipfspin.Any})
+	if len(pins) != 2 {
+		t.Fatalf("expected 2 pins, got %d", len(pins))
+	}
+	for _, pi := range pins {
+		if !pi.ExpiresAt.Equal(expiresAt) {
+			t.Fatalf("wrong expiry %s", pi.ExpiresAt)
+		}
+	}
+
+	// pinning again replaces the expired pin
+	if err = p.PinWithOptions(ctx, ak, PinOptions{Recursive: true, ExpiresAt: clk.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	assertPinnedWithType(t, p, ak, ipfspin.Recursive, "pin not renewed")
+	pins = lsPins(t, p, PinQuery{Mode: ipfspin.Recursive})
+	if len(pins) != 1 || !pins[0].ExpiresAt.Equal(clk.Now().Add(time.Hour)) {
+		t.Fatalf("expected the renewed pin only, got %v", pins)
+	}
+
+	// a pin which does not expire covers the ones that do
+	if err = p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.PinWithOptions(ctx, ak, PinOptions{Recursive: true, ExpiresAt: clk.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	pins = lsPins(t, p, PinQuery{Mode: ipfspin.Recursive})
+	if len(pins) != 1 || !pins[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected the pin which does not expire only, got %v", pins)
+	}
+
+	// the expiry is persisted
+	p, err = New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.clock = clk
+	pins = lsPins(t, p, PinQuery{Mode: ipfspin.Direct})
+	if len(pins) != 1 || !pins[0].ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected the expired direct pin, got %v", pins)
+	}
+
+	n, err := p.ReapExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 expired pin removed, got %d", n)
+	}
+	if pins = lsPins(t, p, PinQuery{Mode: ipfspin.Direct}); len(pins) != 0 {
+		t.Fatalf("expired pin still listed: %v", pins)
+	}
+	if n, err = p.ReapExpired(ctx); err != nil || n != 0 {
+		t.Fatalf("expected no more expired pins, got %d, %v", n, err)
+	}
+	assertPinnedWithType(t, p, ak, ipfspin.Recursive, "pin which does not expire removed")
+}
+
+func verifyPins(t *testing.T, p *pinner, opts VerifyOptions) map[cid.Cid]VerifyResult {
+	t.Helper()
+	ch, err := p.Verify(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := map[cid.Cid]VerifyResult{}
+	for res := range ch {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		results[res.Cid] = res
+	}
+	return results
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := mdag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	p, err := New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a and b share mid, c has a raw leaf of its own
+	leaf := mdag.NewRawNode([]byte("leaf"))
+	mid, _ := randNode()
+	if err = mid.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	cLeaf := mdag.NewRawNode([]byte("c leaf"))
+	var roots []*mdag.ProtoNode
+	for _, child := range []ipld.Node{mid, mid, cLeaf} {
+		root, _ := randNode()
+		if err = root.AddNodeLink("child", child); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+	nodes := []ipld.Node{leaf, mid, cLeaf}
+	for _, r := range roots {
+		nodes = append(nodes, r)
+	}
+	if err = dserv.AddMany(ctx, nodes); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range roots {
+		if err = p.Pin(ctx, r, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b, c := roots[0].Cid(), roots[1].Cid(), roots[2].Cid()
+
+	if _, err = p.Verify(ctx, VerifyOptions{}); err == nil {
+		t.Fatal("expected error verifying without a blockstore")
+	}
+
+	results := verifyPins(t, p, VerifyOptions{Blockstore: bstore})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	var checked int
+	for k, res := range results {
+		if !res.Ok || len(res.Missing) != 0 || len(res.Corrupt) != 0 {
+			t.Fatalf("%s should be ok: %+v", k, res)
+		}
+		checked += res.BlocksChecked
+	}
+	// the shared blocks are checked once
+	if checked != len(nodes) {
+		t.Fatalf("expected %d blocks checked, got %d", len(nodes), checked)
+	}
+
+	if err = bstore.DeleteBlock(ctx, mid.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	// the corrupt leaf is only detected by a deep verification
+	corrupt, err := blocks.NewBlockWithCid([]byte("corrupt"), cLeaf.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bstore.DeleteBlock(ctx, cLeaf.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err = bstore.Put(ctx, corrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	results = verifyPins(t, p, VerifyOptions{Blockstore: bstore})
+	for _, k := range []cid.Cid{a, b} {
+		res := results[k]
+		if res.Ok || len(res.Missing) != 1 || !res.Missing[0].Equals(mid.Cid()) {
+			t.Fatalf("%s should miss %s: %+v", k, mid.Cid(), res)
+		}
+	}
+	if !results[c].Ok {
+		t.Fatalf("%s should be ok in quick mode: %+v", c, results[c])
+	}
+
+	results = verifyPins(t, p, VerifyOptions{Blockstore: bstore, Mode: VerifyDeep})
+	if res := results[a]; res.Ok || len(res.Missing) != 1 || !res.Missing[0].Equals(mid.Cid()) {
+		t.Fatalf("%s should miss %s: %+v", a, mid.Cid(), res)
+	}
+	res := results[c]
+	if res.Ok || len(res.Missing) != 0 || len(res.Corrupt) != 1 || !res.Corrupt[0].Equals(cLeaf.Cid()) {
+		t.Fatalf("%s should have corrupt %s: %+v", c, cLeaf.Cid(), res)
+	}
+}
+
+func TestCheckIfPinnedStream(t *testing.T) {
+	ctx := context.Background()
+	// the blocks are stored apart, to not slow down the queries of the pins
+	// of the map datastore
+	dstore, _ := makeStore()
+	_, dserv := makeStore()
+	counting := &countingDAGService{DAGService: dserv}
+	p, err := New(ctx, dstore, counting)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deep recursive pins: chains of nodes, each with a leaf
+	var cids []cid.Cid
+	for i := 0; i < 4; i++ {
+		var next *mdag.ProtoNode
+		for j := 0; j < 250; j++ {
+			nd, _ := randNode()
+			leaf, _ := randNode()
+			if err = nd.AddNodeLink("leaf", leaf); err != nil {
+				t.Fatal(err)
+			}
+			if next != nil {
+				if err = nd.AddNodeLink("next", next); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = dserv.AddMany(ctx, []ipld.Node{leaf, nd}); err != nil {
+				t.Fatal(err)
+			}
+			cids = append(cids, nd.Cid(), leaf.Cid())
+			next = nd
+		}
+		if err = p.Pin(ctx, next, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		nd, _ := randNode()
+		if err = dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Pin(ctx, nd, false); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, nd.Cid())
+	}
+	// an expired pin
+	expiring, _ := randNode()
+	if err = dserv.Add(ctx, expiring); err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewMock()
+	p.clock = clk
+	if err = p.PinWithOptions(ctx, expiring.Cid(), PinOptions{ExpiresAt: clk.Now().Add(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	clk.Add(time.Second)
+	cids = append(cids, expiring.Cid())
+	for len(cids) < 50000 {
+		_, c := randNode()
+		cids = append(cids, c)
+	}
+	mrand.Shuffle(len(cids), func(i, j int) { cids[i], cids[j] = cids[j], cids[i] })
+
+	expected, err := p.CheckIfPinned(ctx, cids...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[cid.Cid]ipfspin.Pinned{}
+	for _, r := range expected {
+		want[r.Key] = r
+	}
+
+	// a caller bounding its memory checks the CIDs in chunks
+	counting.gets.Store(0)
+	for i := 0; i < len(cids); i += 1000 {
+		if _, err = p.CheckIfPinned(ctx, cids[i:i+1000]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chunkedGets := counting.gets.Load()
+
+	counting.gets.Store(0)
+	in := make(chan cid.Cid)
+	go func() {
+		defer close(in)
+		for _, c := range cids {
+			in <- c
+		}
+	}()
+	var count int
+	for r := range p.CheckIfPinnedStream(ctx, in) {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		count++
+		w, ok := want[r.Key]
+		if !ok {
+			t.Fatalf("unexpected result for %s", r.Key)
+		}
+		if r.Mode != w.Mode || r.Via != w.Via || r.Expired != w.Expired {
+			t.Fatalf("%s: expected %+v, got %+v", r.Key, w, r)
+		}
+		if r.Key.Equals(expiring.Cid()) && !r.Expired {
+			t.Fatal("expired pin not reported")
+		}
+	}
+	streamGets := counting.gets.Load()
+	if count != len(cids) {
+		t.Fatalf("expected %d results, got %d", len(cids), count)
+	}
+	t.Logf("node fetches: %d checking in chunks, %d streaming", chunkedGets, streamGets)
+	if streamGets*5 > chunkedGets {
+		t.Fatalf("expected far fewer fetches than %d, got %d", chunkedGets, streamGets)
+	}
+}
+
+// loadPins returns the pins of p by CID, without their IDs.
+func loadPins(t *testing.T, p *pinner) map[cid.Cid]pin {
+	t.Helper()
+	results, err := p.dstore.Query(context.Background(), query.Query{Prefix: pinKeyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins := map[cid.Cid]pin{}
+	for _, ent := range entries {
+		pp, err := decodePin(path.Base(ent.Key), ent.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pp.Id = ""
+		pins[pp.Cid] = *pp
+	}
+	return pins
+}
+
+func exportPins(t *testing.T, p *pinner) string {
+	t.Helper()
+	var buf strings.Builder
+	if err := p.Export(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := makeStore()
+	p, err := New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ak := randNode()
+	b, bk := randNode()
+	c, ck := randNode()
+	if err = dserv.AddMany(ctx, []ipld.Node{a, b, c}); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.PinWithOptions(ctx, ak, PinOptions{Recursive: true, Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.PinWithOptions(ctx, bk, PinOptions{Name: "b", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	withMeta := newPin(ck, ipfspin.Recursive, "")
+	withMeta.Metadata = map[string]interface{}{"origin": "test"}
+	if err = p.storePin(ctx, withMeta); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	export := exportPins(t, p)
+	if !strings.HasPrefix(export, `{"format":"dspinner-pins","version":1}`+"\n") {
+		t.Fatalf("unexpected export header: %q", export)
+	}
+
+	// the DAGs are not needed to import the pins
+	dstore2, dserv2 := makeStore()
+	p2, err := New(ctx, dstore2, dserv2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p2.Import(ctx, strings.NewReader(export), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 3 || res.Skipped != 0 || len(res.Errors) != 0 {
+		t.Fatalf("unexpected import result %+v", res)
+	}
+	want, got := loadPins(t, p), loadPins(t, p2)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d pins, got %d", len(want), len(got))
+	}
+	for k, w := range want {
+		g := got[k]
+		if g.Mode != w.Mode || g.Name != w.Name || g.ExpiresAt != w.ExpiresAt || fmt.Sprint(g.Metadata) != fmt.Sprint(w.Metadata) {
+			t.Fatalf("%s: expected %+v, got %+v", k, w, g)
+		}
+	}
+	assertPinnedWithType(t, p2, ak, ipfspin.Recursive, "imported pin missing")
+	assertPinnedWithType(t, p2, bk, ipfspin.Direct, "imported pin missing")
+	// the indexes are persisted
+	p2, err = New(ctx, dstore2, dserv2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pins := lsPins(t, p2, PinQuery{Mode: ipfspin.Any, Name: "b"}); len(pins) != 1 || pins[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected the expiring pin named b, got %v", pins)
+	}
+
+	// a reference pinner for the conflicts, with a of another mode and name
+	conflicting := func(t *testing.T) *pinner {
+		dstore, dserv := makeStore()
+		p, err := New(ctx, dstore, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.PinWithOptions(ctx, ak, PinOptions{Name: "other"}); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		p := conflicting(t)
+		res, err := p.Import(ctx, strings.NewReader(export), ImportOptions{Conflict: ImportSkip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Imported != 2 || res.Skipped != 1 {
+			t.Fatalf("unexpected import result %+v", res)
+		}
+		if pp := loadPins(t, p)[ak]; pp.Mode != ipfspin.Direct || pp.Name != "other" {
+			t.Fatalf("existing pin changed: %+v", pp)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		p := conflicting(t)
+		res, err := p.Import(ctx, strings.NewReader(export), ImportOptions{Conflict: ImportOverwrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Imported != 3 || res.Skipped != 0 {
+			t.Fatalf("unexpected import result %+v", res)
+		}
+		pins := loadPins(t, p)
+		if len(pins) != 3 {
+			t.Fatalf("expected 3 pins, got %d", len(pins))
+		}
+		if pp := pins[ak]; pp.Mode != ipfspin.Recursive || pp.Name != "a" {
+			t.Fatalf("existing pin not overwritten: %+v", pp)
+		}
+		if pins := lsPins(t, p, PinQuery{Mode: ipfspin.Any, Name: "other"}); len(pins) != 0 {
+			t.Fatalf("overwritten pin still listed: %v", pins)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		p := conflicting(t)
+		_, err := p.Import(ctx, strings.NewReader(export), ImportOptions{Conflict: ImportFail})
+		if !errors.Is(err, ErrPinConflict) {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+		if pp := loadPins(t, p)[ak]; pp.Mode != ipfspin.Direct || pp.Name != "other" {
+			t.Fatalf("existing pin changed: %+v", pp)
+		}
+	})
+}
+
+func TestImportMalformed(t *testing.T) {
+	ctx := context.Background()
+	_, ak := randNode()
+	_, bk := randNode()
+	export := strings.Join([]string{
+		`{"format":"dspinner-pins","version":1}`,
+		`{"cid":"` + ak.String() + `","mode":"recursive"}`,
+		`{"cid":"not a cid","mode":"recursive"}`,
+		`{"cid":"` + bk.String() + `","mode":"indirect"}`,
+		`not json`,
+		``,
+		`{"cid":"` + bk.String() + `","mode":"direct"}`,
+	}, "\n")
+
+	dstore, dserv := makeStore()
+	p, err := New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.Import(ctx, strings.NewReader(export), ImportOptions{})
+	var lerr *ImportLineError
+	if !errors.As(err, &lerr) || lerr.Line != 3 {
+		t.Fatalf("expected error on line 3, got %v", err)
+	}
+	// the pins before the error are imported
+	if res.Imported != 1 {
+		t.Fatalf("expected 1 pin imported, got %d", res.Imported)
+	}
+
+	dstore, dserv = makeStore()
+	p, err = New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = p.Import(ctx, strings.NewReader(export), ImportOptions{SkipErrors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 2 {
+		t.Fatalf("expected 2 pins imported, got %d", res.Imported)
+	}
+	var lines []int
+	for _, e := range res.Errors {
+		lines = append(lines, e.Line)
+	}
+	if fmt.Sprint(lines) != "[3 4 5]" {
+		t.Fatalf("expected errors on lines 3, 4 and 5, got %v", res.Errors)
+	}
+
+	if _, err = p.Import(ctx, strings.NewReader(`{"format":"dspinner-pins","version":2}`), ImportOptions{}); err == nil {
+		t.Fatal("expected error importing an unsupported version")
+	}
+
+	// the pins whose DAG cannot be fetched are not imported
+	_, emptyDAG := makeStore()
+	dstore, dserv = makeStore()
+	p, err = New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = p.Import(ctx, strings.NewReader(export), ImportOptions{SkipErrors: true, Fetch: emptyDAG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 0 || len(res.Errors) != 5 {
+		t.Fatalf("expected no pins imported, got %+v", res)
+	}
+}
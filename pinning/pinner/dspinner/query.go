@@ -0,0 +1,201 @@
+package dspinner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multibase"
+
+	ipfspinner "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/pinning/pinner/dsindex"
+)
+
+// PinQuery selects the pins listed by LsWithOptions.
+type PinQuery struct {
+	// Mode is ipfspinner.Recursive, ipfspinner.Direct or ipfspinner.Any.
+	Mode ipfspinner.Mode
+
+	// Name, if not empty, only matches the pins with this name, and
+	// NamePrefix the pins whose name starts with it.
+	Name       string
+	NamePrefix string
+
+	// Limit, if positive, is the maximum number of pins listed.
+	Limit int
+
+	// After, if not empty, is the Cursor of the last pin of the previous
+	// page, the listing starting after it.
+	After string
+}
+
+// PinInfo is a pin listed by LsWithOptions.
+type PinInfo struct {
+	Cid  cid.Cid
+	Mode ipfspinner.Mode
+	Name string
+
+	// ExpiresAt is when the pin expires, zero if it does not. The expired
+	// pins are listed until they are removed.
+	ExpiresAt time.Time
+
+	// Cursor identifies the position of the pin in the listing, for the
+	// PinQuery.After of the next page.
+	Cursor string
+
+	Err error
+}
+
+// The cursors are the index of the listing, followed by the key and the
+// value of the pin in that index.
+const (
+	cursorRecursive = "r"
+	cursorDirect    = "d"
+	cursorName      = "n"
+)
+
+// LsWithOptions lists the pins matched by q, which are selected and ordered by
+// the queries of the pin indexes.
+//
+// Without a name, the recursive pins are listed before the direct ones, each
+// ordered by CID. With a name or a name prefix, the pins are ordered by name,
+// and then by their internal ID, and the mode is matched after the pins are
+// loaded. The order does not depend on when the pins were added, so listing
+// the pages after the cursors lists every pin once, except the ones added or
+// removed in the meanwhile.
+func (p *pinner) LsWithOptions(ctx context.Context, q PinQuery) (<-chan PinInfo, error) {
+	switch q.Mode {
+	case ipfspinner.Recursive, ipfspinner.Direct, ipfspinner.Any:
+	default:
+		return nil, fmt.Errorf("cannot list pins of mode %d", q.Mode)
+	}
+	if q.Name != "" && q.NamePrefix != "" {
+		return nil, errors.New("cannot list pins of a name and of a name prefix")
+	}
+
+	byName := q.Name != "" || q.NamePrefix != ""
+	var after []string
+	if q.After != "" {
+		after = strings.Split(q.After, "/")
+		if len(after) != 3 || byName != (after[0] == cursorName) {
+			return nil, errors.New("invalid pin cursor")
+		}
+		for i := 1; i < len(after); i++ {
+			_, b, err := multibase.Decode(after[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid pin cursor: %w", err)
+			}
+			after[i] = string(b)
+		}
+	}
+
+	type walk struct {
+		cursor string
+		index  dsindex.Indexer
+		mode   ipfspinner.Mode
+	}
+	var walks []walk
+	switch {
+	case byName:
+		walks = []walk{{cursorName, p.nameIndex, ipfspinner.Any}}
+	case q.Mode == ipfspinner.Recursive:
+		walks = []walk{{cursorRecursive, p.cidRIndex, ipfspinner.Recursive}}
+	case q.Mode == ipfspinner.Direct:
+		walks = []walk{{cursorDirect, p.cidDIndex, ipfspinner.Direct}}
+	default:
+		walks = []walk{
+			{cursorRecursive, p.cidRIndex, ipfspinner.Recursive},
+			{cursorDirect, p.cidDIndex, ipfspinner.Direct},
+		}
+	}
+	if after != nil {
+		// skip the walks before the cursor
+		for len(walks) != 0 && walks[0].cursor != after[0] {
+			walks = walks[1:]
+		}
+		if len(walks) == 0 {
+			return nil, errors.New("invalid pin cursor for this query")
+		}
+	}
+
+	out := make(chan PinInfo)
+	go func() {
+		defer close(out)
+
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+
+		count := 0
+		for i, w := range walks {
+			iq := dsindex.Query{Key: q.Name, KeyPrefix: q.NamePrefix}
+			if i == 0 && after != nil {
+				iq.AfterKey, iq.AfterValue = after[1], after[2]
+			}
+			// the mode of the pins of the name index is known once they are
+			// loaded
+			if q.Limit > 0 && (!byName || q.Mode == ipfspinner.Any) {
+				iq.Limit = q.Limit - count
+			}
+
+			var loadErr error
+			err := w.index.Query(ctx, iq, func(key, value string) bool {
+				pp, err := p.loadPin(ctx, value)
+				if err != nil {
+					loadErr = fmt.Errorf("cannot load pin %s: %w", value, err)
+					return false
+				}
+				if q.Mode != ipfspinner.Any && pp.Mode != q.Mode {
+					return true
+				}
+
+				info := PinInfo{
+					Cid:    pp.Cid,
+					Mode:   pp.Mode,
+					Name:   pp.Name,
+					Cursor: encodeCursor(w.cursor, key, value),
+				}
+				if pp.ExpiresAt != 0 {
+					info.ExpiresAt = time.Unix(0, pp.ExpiresAt)
+				}
+				select {
+				case out <- info:
+				case <-ctx.Done():
+					return false
+				}
+				count++
+				return q.Limit <= 0 || count < q.Limit
+			})
+			if err == nil {
+				err = loadErr
+			}
+			if err != nil {
+				select {
+				case out <- PinInfo{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			if ctx.Err() != nil || (q.Limit > 0 && count >= q.Limit) {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func encodeCursor(index, key, value string) string {
+	parts := []string{index, key, value}
+	for i := 1; i < len(parts); i++ {
+		enc, err := multibase.Encode(multibase.Base64url, []byte(parts[i]))
+		if err != nil {
+			// programming error; using unsupported encoding
+			panic(err.Error())
+		}
+		parts[i] = enc
+	}
+	return strings.Join(parts, "/")
+}
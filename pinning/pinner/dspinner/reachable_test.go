@@ -0,0 +1,177 @@
+package dspinner
+
+import (
+	"context"
+	"fmt"
+	mrand "math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	mdag "github.com/ipfs/boxo/ipld/merkledag"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	ipfspin "github.com/ipfs/boxo/pinning/pinner"
+)
+
+// markReachable returns the CIDs reachable from the pins of p, the way the
+// mark phase of a garbage collector enumerates them: the recursive pins and
+// their descendants, walked with the given options, and the direct pins.
+func markReachable(ctx context.Context, p ipfspin.Pinner, getLinks mdag.GetLinks, opts ...mdag.WalkOption) (*cid.Set, error) {
+	set := cid.NewSet()
+	for streamed := range p.RecursiveKeys(ctx) {
+		if streamed.Err != nil {
+			return nil, streamed.Err
+		}
+		if err := mdag.Walk(ctx, getLinks, streamed.C, set.Visit, opts...); err != nil {
+			return nil, err
+		}
+	}
+	for streamed := range p.DirectKeys(ctx) {
+		if streamed.Err != nil {
+			return nil, streamed.Err
+		}
+		set.Add(streamed.C)
+	}
+	return set, nil
+}
+
+// makeRandomGCDAG adds count nodes linking to up to maxLinks random nodes
+// added before them, so that the subtrees are shared, and returns them.
+func makeRandomGCDAG(ctx context.Context, dserv ipld.DAGService, rng *mrand.Rand, count, maxLinks int) ([]ipld.Node, error) {
+	nodes := make([]ipld.Node, 0, count)
+	for i := 0; i < count; i++ {
+		nd := mdag.NodeWithData([]byte(strconv.Itoa(rng.Int())))
+		if len(nodes) > 0 {
+			for j := rng.Intn(maxLinks + 1); j > 0; j-- {
+				if err := nd.AddNodeLink(strconv.Itoa(j), nodes[rng.Intn(len(nodes))]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := dserv.Add(ctx, nd); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, nd)
+	}
+	return nodes, nil
+}
+
+func TestMarkReachableConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	for seed := int64(0); seed < 20; seed++ {
+		rng := mrand.New(mrand.NewSource(seed))
+		dstore, dserv := makeStore()
+		p, err := New(ctx, dstore, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nodes, err := makeRandomGCDAG(ctx, dserv, rng, 300, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// pin a few nodes of the DAG, leaving the others to collect
+		for i := 0; i < 5; i++ {
+			if err := p.Pin(ctx, nodes[rng.Intn(len(nodes))], true); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.PinWithMode(ctx, nodes[rng.Intn(len(nodes))].Cid(), ipfspin.Direct); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		getLinks := mdag.GetLinksWithDAG(dserv)
+		expected, err := markReachable(ctx, p, getLinks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected.Len() == len(nodes) {
+			t.Fatalf("seed %d: all the nodes reachable, nothing left to collect", seed)
+		}
+
+		for _, workers := range []int{2, 8, 32} {
+			marked, err := markReachable(ctx, p, getLinks, mdag.Concurrency(workers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if marked.Len() != expected.Len() {
+				t.Fatalf("seed %d: marked %d nodes with %d workers, expected %d", seed, marked.Len(), workers, expected.Len())
+			}
+			_ = expected.ForEach(func(c cid.Cid) error {
+				if !marked.Has(c) {
+					t.Fatalf("seed %d: node %s not marked with %d workers", seed, c, workers)
+				}
+				return nil
+			})
+		}
+	}
+}
+
+// BenchmarkMarkReachable marks a pinned DAG of a million blocks, a root with
+// 1024 children of 1023 raw leaves each, sequentially and concurrently. The
+// blocks with links are read with a latency standing for the one of a disk,
+// the raw leaves are not read at all.
+func BenchmarkMarkReachable(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skipping the million blocks DAG in short mode")
+	}
+	ctx := context.Background()
+	dstore, dserv := makeStore()
+	p, err := New(ctx, dstore, dserv)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	root := mdag.NodeWithData([]byte("root"))
+	for i := 0; i < 1024; i++ {
+		parent := mdag.NodeWithData([]byte(strconv.Itoa(i)))
+		leaves := make([]ipld.Node, 1023)
+		for j := range leaves {
+			leaves[j] = mdag.NewRawNode([]byte(fmt.Sprintf("%d/%d", i, j)))
+			if err := parent.AddNodeLink(strconv.Itoa(j), leaves[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := dserv.AddMany(ctx, append(leaves, parent)); err != nil {
+			b.Fatal(err)
+		}
+		if err := root.AddNodeLink(strconv.Itoa(i), parent); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := dserv.Add(ctx, root); err != nil {
+		b.Fatal(err)
+	}
+	if err := p.PinWithMode(ctx, root.Cid(), ipfspin.Recursive); err != nil {
+		b.Fatal(err)
+	}
+	dagLinks := mdag.GetLinksWithDAG(dserv)
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		if c.Type() != cid.Raw {
+			time.Sleep(time.Millisecond)
+		}
+		return dagLinks(ctx, c)
+	}
+
+	for _, workers := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("workers %d", workers), func(b *testing.B) {
+			var opts []mdag.WalkOption
+			if workers > 1 {
+				opts = append(opts, mdag.Concurrency(workers))
+			}
+			for i := 0; i < b.N; i++ {
+				marked, err := markReachable(ctx, p, getLinks, opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if marked.Len() != 1+1024*1024 {
+					b.Fatalf("marked %d blocks", marked.Len())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package dspinner
+
+import (
+	"context"
+	"errors"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	"github.com/ipfs/boxo/blockservice"
+	blockstore "github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+)
+
+// VerifyMode selects how the blocks are checked by Verify.
+type VerifyMode int
+
+const (
+	// VerifyQuick checks that the blocks are present. The blocks with links
+	// are read, but only the presence of the raw leaves is checked.
+	VerifyQuick VerifyMode = iota
+	// VerifyDeep also reads every block, and checks that its data matches its
+	// CID.
+	VerifyDeep
+)
+
+// defaultMaxMissing is the default VerifyOptions.MaxMissing.
+const defaultMaxMissing = 100
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// Blockstore holds the local blocks, which are the only ones read.
+	Blockstore blockstore.Blockstore
+
+	Mode VerifyMode
+
+	// MaxMissing, if positive, is the maximum number of missing CIDs, and of
+	// corrupt CIDs, reported by pin. It defaults to 100.
+	MaxMissing int
+}
+
+// VerifyResult is the result of verifying a recursive pin.
+type VerifyResult struct {
+	Cid cid.Cid
+	// Ok is true if all the blocks of the DAG are present, and match their CIDs
+	// with VerifyDeep.
+	Ok bool
+	// Missing are the CIDs of the missing blocks, and Corrupt the ones of the
+	// blocks whose data does not match, at most VerifyOptions.MaxMissing of
+	// each. The descendants of a missing or corrupt block are not checked.
+	Missing []cid.Cid
+	Corrupt []cid.Cid
+	// BlocksChecked is the number of blocks checked for this pin. The blocks
+	// shared with a pin verified before are only checked once, and counted
+	// for the first one.
+	BlocksChecked int
+
+	Err error
+}
+
+// Verify checks that the blocks of the DAGs of the recursive pins are in
+// opts.Blockstore, and returns the result of each pin. The blocks are only
+// read from opts.Blockstore, and never fetched.
+func (p *pinner) Verify(ctx context.Context, opts VerifyOptions) (<-chan VerifyResult, error) {
+	if opts.Blockstore == nil {
+		return nil, errors.New("cannot verify pins without a blockstore")
+	}
+	if opts.MaxMissing <= 0 {
+		opts.MaxMissing = defaultMaxMissing
+	}
+
+	out := make(chan VerifyResult)
+	go func() {
+		defer close(out)
+
+		// the pins are listed before their DAGs are walked, to not block the
+		// changes of the pins during the walks
+		var roots []cid.Cid
+		for sc := range p.RecursiveKeys(ctx) {
+			if sc.Err != nil {
+				select {
+				case out <- VerifyResult{Err: sc.Err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			roots = append(roots, sc.C)
+		}
+
+		bs := opts.Blockstore
+		if opts.Mode == VerifyDeep {
+			bs = hashingBlockstore{bs}
+		}
+		v := &verifier{
+			bs:       bs,
+			dag:      merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+			deep:     opts.Mode == VerifyDeep,
+			max:      opts.MaxMissing,
+			statuses: map[cid.Cid]*blockStatus{},
+		}
+		for _, root := range roots {
+			checked := v.checked
+			status, err := v.check(ctx, root)
+			res := VerifyResult{Cid: root, BlocksChecked: v.checked - checked, Err: err}
+			if err == nil {
+				res.Ok = status == okStatus
+				res.Missing = status.missing
+				res.Corrupt = status.corrupt
+			}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// blockStatus is the status of the DAG of a block.
+type blockStatus struct {
+	missing []cid.Cid
+	corrupt []cid.Cid
+}
+
+// okStatus is the status of the complete DAGs, shared to not allocate one for
+// each block.
+var okStatus = &blockStatus{}
+
+// verifier walks the DAGs of the pins, sharing the statuses of the blocks
+// already checked.
+type verifier struct {
+	bs   blockstore.Blockstore
+	dag  ipld.DAGService
+	deep bool
+	max  int
+
+	statuses map[cid.Cid]*blockStatus
+	checked  int
+}
+
+func (v *verifier) check(ctx context.Context, c cid.Cid) (*blockStatus, error) {
+	if status, ok := v.statuses[c]; ok {
+		return status, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	v.checked++
+
+	status, err := v.checkBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	v.statuses[c] = status
+	return status, nil
+}
+
+func (v *verifier) checkBlock(ctx context.Context, c cid.Cid) (*blockStatus, error) {
+	if !v.deep && c.Type() == cid.Raw {
+		has, err := v.bs.Has(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			return &blockStatus{missing: []cid.Cid{c}}, nil
+		}
+		return okStatus, nil
+	}
+
+	nd, err := v.dag.Get(ctx, c)
+	if ipld.IsNotFound(err) {
+		return &blockStatus{missing: []cid.Cid{c}}, nil
+	}
+	if errors.Is(err, blockstore.ErrHashMismatch) {
+		return &blockStatus{corrupt: []cid.Cid{c}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := okStatus
+	var seen *cid.Set
+	for _, l := range nd.Links() {
+		child, err := v.check(ctx, l.Cid)
+		if err != nil {
+			return nil, err
+		}
+		if child == okStatus {
+			continue
+		}
+		if status == okStatus {
+			status = &blockStatus{}
+			seen = cid.NewSet()
+		}
+		status.missing = v.merge(status.missing, child.missing, seen)
+		status.corrupt = v.merge(status.corrupt, child.corrupt, seen)
+	}
+	return status, nil
+}
+
+// merge appends the CIDs of from not seen yet to to, up to the maximum.
+func (v *verifier) merge(to, from []cid.Cid, seen *cid.Set) []cid.Cid {
+	for _, c := range from {
+		if len(to) >= v.max {
+			break
+		}
+		if seen.Visit(c) {
+			to = append(to, c)
+		}
+	}
+	return to
+}
+
+// hashingBlockstore checks that the data of the blocks read matches their CID.
+type hashingBlockstore struct {
+	blockstore.Blockstore
+}
+
+func (bs hashingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := c.Prefix().Sum(blk.RawData())
+	if err != nil || !sum.Equals(c) {
+		return nil, blockstore.ErrHashMismatch
+	}
+	return blk, nil
+}
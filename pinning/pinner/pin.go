@@ -138,6 +138,11 @@ type Pinned struct {
 	Key  cid.Cid
 	Mode Mode
 	Via  cid.Cid
+	// Expired is true if the CID is not pinned, but has expired pins.
+	Expired bool
+	// Err is set, and the other fields are not, if a stream of pin checks
+	// failed.
+	Err error
 }
 
 // Pinned returns whether or not the given cid is pinned
@@ -149,6 +154,9 @@ func (p Pinned) Pinned() bool {
 func (p Pinned) String() string {
 	switch p.Mode {
 	case NotPinned:
+		if p.Expired {
+			return "not pinned: expired"
+		}
 		return "not pinned"
 	case Indirect:
 		return fmt.Sprintf("pinned via %s", p.Via)
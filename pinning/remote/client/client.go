@@ -55,6 +55,7 @@ var PinOpts = pinOpts{}
 type pinOpts struct {
 	pinLsOpts
 	pinAddOpts
+	pinWaitOpts
 }
 
 type pinLsOpts struct{}
@@ -288,6 +289,7 @@ type addSettings struct {
 	name    string
 	origins []string
 	meta    map[string]string
+	wait    []WaitOption
 }
 
 type AddOption func(options *addSettings) error
@@ -353,14 +355,19 @@ func (c *Client) Add(ctx context.Context, cid cid.Cid, opts ...AddOption) (PinSt
 }
 
 func (c *Client) GetStatusByID(ctx context.Context, pinID string) (PinStatusGetter, error) {
+	ps, _, err := c.getStatusByID(ctx, pinID)
+	return ps, err
+}
+
+func (c *Client) getStatusByID(ctx context.Context, pinID string) (PinStatusGetter, *http.Response, error) {
 	getter := c.client.PinsApi.PinsRequestidGet(ctx, pinID)
 	result, httpresp, err := getter.Execute()
 	if err != nil {
 		err := httperr(httpresp, err)
-		return nil, err
+		return nil, httpresp, err
 	}
 
-	return &pinStatusObject{result}, nil
+	return &pinStatusObject{result}, httpresp, nil
 }
 
 func (c *Client) DeleteByID(ctx context.Context, pinID string) error {
@@ -0,0 +1,231 @@
+package go_pinning_service_http_client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	defaultPollInterval    = time.Second
+	defaultMaxPollInterval = 30 * time.Second
+)
+
+// ErrWaitTimeout is returned when the status of a pin request is not terminal
+// before the timeout set with WaitTimeout.
+var ErrWaitTimeout = errors.New("timed out waiting for the remote pin status")
+
+// PinFailedError is returned when a pin request ends with the failed status.
+type PinFailedError struct {
+	RequestID string
+	// Info is the info of the status returned by the service.
+	Info map[string]string
+}
+
+func (e *PinFailedError) Error() string {
+	if len(e.Info) == 0 {
+		return fmt.Sprintf("remote pin request %s failed", e.RequestID)
+	}
+	return fmt.Sprintf("remote pin request %s failed: %v", e.RequestID, e.Info)
+}
+
+type waitSettings struct {
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
+	timeout         time.Duration
+}
+
+type WaitOption func(options *waitSettings) error
+
+type pinWaitOpts struct{}
+
+// PollInterval sets the interval before the first poll of the status, which
+// is doubled after each poll. It defaults to one second.
+func (pinWaitOpts) PollInterval(d time.Duration) WaitOption {
+	return func(options *waitSettings) error {
+		if d <= 0 {
+			return errors.New("poll interval must be positive")
+		}
+		options.pollInterval = d
+		return nil
+	}
+}
+
+// MaxPollInterval caps the interval between the polls of the status. It
+// defaults to 30 seconds.
+func (pinWaitOpts) MaxPollInterval(d time.Duration) WaitOption {
+	return func(options *waitSettings) error {
+		if d <= 0 {
+			return errors.New("max poll interval must be positive")
+		}
+		options.maxPollInterval = d
+		return nil
+	}
+}
+
+// WaitTimeout sets how long to wait for a terminal status, after which
+// ErrWaitTimeout is returned. There is no timeout by default, other than the
+// one of the context.
+func (pinWaitOpts) WaitTimeout(d time.Duration) WaitOption {
+	return func(options *waitSettings) error {
+		options.timeout = d
+		return nil
+	}
+}
+
+// WithWait sets the options of the wait of AddAndWait.
+func (pinAddOpts) WithWait(opts ...WaitOption) AddOption {
+	return func(options *addSettings) error {
+		options.wait = append(options.wait, opts...)
+		return nil
+	}
+}
+
+// AddAndWait adds a pin request, as Add, and waits for its status to be
+// terminal, as WaitForStatus. The options of the wait are set with
+// PinOpts.WithWait.
+func (c *Client) AddAndWait(ctx context.Context, cid cid.Cid, opts ...AddOption) (PinStatusGetter, error) {
+	settings := new(addSettings)
+	for _, o := range opts {
+		if err := o(settings); err != nil {
+			return nil, err
+		}
+	}
+	ws, err := newWaitSettings(settings.wait)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := c.Add(ctx, cid, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = failedErr(ps); err != nil || ps.GetStatus() == StatusPinned {
+		return ps, err
+	}
+	return c.wait(ctx, ps.GetRequestId(), ws)
+}
+
+// WaitForStatus polls the status of the pin request until it is pinned or
+// failed, and returns it. The interval between the polls starts at the
+// PollInterval, and is doubled after each poll up to the MaxPollInterval,
+// unless the service asks for another one with a Retry-After header. The polls
+// rate limited or refused with a 429 or a 503 are retried. A failed status is
+// returned with a *PinFailedError.
+func (c *Client) WaitForStatus(ctx context.Context, requestID string, opts ...WaitOption) (PinStatusGetter, error) {
+	ws, err := newWaitSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.wait(ctx, requestID, ws)
+}
+
+func newWaitSettings(opts []WaitOption) (*waitSettings, error) {
+	settings := &waitSettings{
+		pollInterval:    defaultPollInterval,
+		maxPollInterval: defaultMaxPollInterval,
+	}
+	for _, o := range opts {
+		if err := o(settings); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+func (c *Client) wait(ctx context.Context, requestID string, settings *waitSettings) (PinStatusGetter, error) {
+	// the timeout is told apart from the end of ctx, to return ErrWaitTimeout
+	waitCtx := ctx
+	if settings.timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, settings.timeout)
+		defer cancel()
+	}
+	waitErr := func() error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrWaitTimeout
+	}
+
+	var last PinStatusGetter
+	interval := settings.pollInterval
+	delay := interval
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-waitCtx.Done():
+			timer.Stop()
+			return last, waitErr()
+		}
+
+		if interval *= 2; interval > settings.maxPollInterval {
+			interval = settings.maxPollInterval
+		}
+		delay = interval
+
+		ps, httpresp, err := c.getStatusByID(waitCtx, requestID)
+		if err != nil {
+			if waitCtx.Err() != nil {
+				return last, waitErr()
+			}
+			retryAfter, ok := parseRetryAfter(httpresp)
+			if !ok {
+				return last, err
+			}
+			if retryAfter >= 0 {
+				delay = retryAfter
+			}
+			logger.Debugf("retrying the status of remote pin request %s in %s: %s", requestID, delay, err)
+			continue
+		}
+
+		last = ps
+		if err = failedErr(ps); err != nil || ps.GetStatus() == StatusPinned {
+			return ps, err
+		}
+	}
+}
+
+// failedErr returns the error of a failed status.
+func failedErr(ps PinStatusGetter) error {
+	if ps.GetStatus() != StatusFailed {
+		return nil
+	}
+	return &PinFailedError{RequestID: ps.GetRequestId(), Info: ps.GetInfo()}
+}
+
+// parseRetryAfter reports whether the failed status request of resp is worth
+// retrying, and returns the delay of its Retry-After header, negative if it
+// has none.
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return 0, false
+	}
+
+	h := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(h)
+	if err != nil {
+		return -1, true
+	}
+	d := time.Until(t)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
@@ -0,0 +1,144 @@
+package go_pinning_service_http_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// statusServer serves the statuses of a pin request in turn, the last one
+// repeatedly. An empty status is answered with a 429.
+type statusServer struct {
+	t        *testing.T
+	statuses []string
+	info     map[string]string
+
+	lock  sync.Mutex
+	polls []time.Time
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	if r.Method == http.MethodGet {
+		s.polls = append(s.polls, time.Now())
+	}
+	status := "queued"
+	if r.Method == http.MethodGet {
+		i := len(s.polls) - 1
+		if i >= len(s.statuses) {
+			i = len(s.statuses) - 1
+		}
+		status = s.statuses[i]
+	}
+	s.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status == "" {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"reason":"RATE_LIMITED"}}`))
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"requestid": "req",
+		"status":    status,
+		"created":   time.Now().Format(time.RFC3339),
+		"pin":       map[string]string{"cid": "bafkqaaa"},
+		"delegates": []string{},
+		"info":      s.info,
+	})
+	require.NoError(s.t, err)
+}
+
+func (s *statusServer) pollTimes() []time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]time.Time(nil), s.polls...)
+}
+
+func newStatusServer(t *testing.T, statuses ...string) (*statusServer, *Client) {
+	s := &statusServer{t: t, statuses: statuses}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, NewClient(srv.URL, "token")
+}
+
+func TestAddAndWait(t *testing.T) {
+	t.Parallel()
+
+	s, c := newStatusServer(t, "queued", "pinning", "pinning", "pinned")
+	mh, err := multihash.Sum(nil, multihash.IDENTITY, -1)
+	require.NoError(t, err)
+
+	ps, err := c.AddAndWait(context.Background(), cid.NewCidV1(cid.Raw, mh),
+		PinOpts.WithName("name"),
+		PinOpts.WithWait(PinOpts.PollInterval(10*time.Millisecond), PinOpts.MaxPollInterval(20*time.Millisecond)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, StatusPinned, ps.GetStatus())
+
+	polls := s.pollTimes()
+	require.Len(t, polls, 4)
+	// the interval is doubled, up to the maximum
+	require.GreaterOrEqual(t, polls[1].Sub(polls[0]), 20*time.Millisecond)
+	require.GreaterOrEqual(t, polls[3].Sub(polls[2]), 20*time.Millisecond)
+	require.Less(t, polls[3].Sub(polls[2]), time.Second)
+}
+
+func TestWaitForStatusFailed(t *testing.T) {
+	t.Parallel()
+
+	s, c := newStatusServer(t, "pinning", "failed")
+	s.info = map[string]string{"reason": "not found"}
+
+	ps, err := c.WaitForStatus(context.Background(), "req", PinOpts.PollInterval(time.Millisecond))
+	var failed *PinFailedError
+	require.True(t, errors.As(err, &failed), "unexpected error %v", err)
+	require.Equal(t, "req", failed.RequestID)
+	require.Equal(t, s.info, failed.Info)
+	require.Equal(t, StatusFailed, ps.GetStatus())
+}
+
+func TestWaitForStatusRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	s, c := newStatusServer(t, "queued", "", "pinned")
+
+	ps, err := c.WaitForStatus(context.Background(), "req", PinOpts.PollInterval(time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, StatusPinned, ps.GetStatus())
+
+	polls := s.pollTimes()
+	require.Len(t, polls, 3)
+	require.GreaterOrEqual(t, polls[2].Sub(polls[1]), time.Second)
+}
+
+func TestWaitForStatusTimeout(t *testing.T) {
+	t.Parallel()
+
+	_, c := newStatusServer(t, "queued")
+
+	ps, err := c.WaitForStatus(context.Background(), "req", PinOpts.PollInterval(time.Millisecond), PinOpts.WaitTimeout(50*time.Millisecond))
+	require.ErrorIs(t, err, ErrWaitTimeout)
+	require.Equal(t, StatusQueued, ps.GetStatus())
+
+	// the cancellation of the context stops the polling at once
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = c.WaitForStatus(ctx, "req", PinOpts.PollInterval(time.Hour))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	cid "github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
@@ -31,6 +32,8 @@ type Queue struct {
 	closed  sync.WaitGroup
 
 	counter uint64
+	// size is the number of cids in the datastore
+	size atomic.Int64
 }
 
 // NewQueue creates a queue for cids
@@ -44,6 +47,7 @@ func NewQueue(ds datastore.Datastore) *Queue {
 		enqueue: make(chan cid.Cid),
 		close:   cancel,
 	}
+	q.size.Store(int64(q.countEntries()))
 	q.closed.Add(1)
 	go q.worker()
 	return q
@@ -69,6 +73,11 @@ func (q *Queue) Enqueue(cid cid.Cid) error {
 	}
 }
 
+// Len returns the number of cids in the queue.
+func (q *Queue) Len() int {
+	return int(q.size.Load())
+}
+
 // Dequeue returns a channel that if listened to will remove entries from the queue
 func (q *Queue) Dequeue() <-chan cid.Cid {
 	return q.dequeue
@@ -100,6 +109,7 @@ func (q *Queue) worker() {
 						log.Errorf("error deleting queue entry with key (%s), due to error (%s), stopping provider", head.Key, err)
 						return
 					}
+					q.size.Add(-1)
 					continue
 				}
 			default:
@@ -129,12 +139,14 @@ func (q *Queue) worker() {
 				log.Errorf("Failed to enqueue cid: %s", err)
 				continue
 			}
+			q.size.Add(1)
 		case dequeue <- c:
 			err := q.ds.Delete(q.ctx, k)
 			if err != nil {
 				log.Errorf("Failed to delete queued cid %s with key %s: %s", c, k, err)
 				continue
 			}
+			q.size.Add(-1)
 			c = cid.Undef
 		case <-q.ctx.Done():
 			return
@@ -142,6 +154,26 @@ func (q *Queue) worker() {
 	}
 }
 
+// countEntries returns the number of cids left in the datastore by a previous
+// queue.
+func (q *Queue) countEntries() int {
+	results, err := q.ds.Query(q.ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		log.Errorf("error counting the queue entries: %s", err)
+		return 0
+	}
+	defer results.Close()
+	var n int
+	for r := range results.Next() {
+		if r.Error != nil {
+			log.Errorf("error counting the queue entries: %s", r.Error)
+			break
+		}
+		n++
+	}
+	return n
+}
+
 func (q *Queue) getQueueHead() (*query.Entry, error) {
 	qry := query.Query{Orders: []query.Order{query.OrderByKey{}}, Limit: 1}
 	results, err := q.ds.Query(q.ctx, qry)
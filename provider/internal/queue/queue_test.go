@@ -125,3 +125,37 @@ func TestInitializationWithManyCids(t *testing.T) {
 
 	assertOrdered(cids, queue, t)
 }
+
+func waitLen(t *testing.T, q *Queue, expected int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); q.Len() != expected; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a queue of %d cids, got %d", expected, q.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLen(t *testing.T) {
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	queue := NewQueue(ds)
+
+	cids := makeCids(10)
+	for _, c := range cids {
+		queue.Enqueue(c)
+	}
+	waitLen(t, queue, 10)
+
+	assertOrdered(cids[:3], queue, t)
+	waitLen(t, queue, 7)
+	queue.Close()
+
+	// the cids left by the previous queue are counted
+	queue = NewQueue(ds)
+	defer queue.Close()
+	if queue.Len() != 7 {
+		t.Fatalf("expected a queue of 7 cids, got %d", queue.Len())
+	}
+	assertOrdered(cids[3:], queue, t)
+	waitLen(t, queue, 0)
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+)
+
+// NewUnionKeyChan returns a key provider streaming the keys of funcs, one
+// after the other, without duplicates. The keys are compared by multihash, as
+// they are announced. The sources are started in order, once the previous one
+// closed its channel, and a source failing to start is logged and skipped.
+func NewUnionKeyChan(funcs ...KeyChanFunc) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		outCh := make(chan cid.Cid)
+		go func() {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			defer close(outCh)
+
+			seen := make(map[string]struct{})
+			for _, f := range funcs {
+				ch, err := f(ctx)
+				if err != nil {
+					logR.Errorf("starting key provider: %s", err)
+					continue
+				}
+				for c := range ch {
+					if _, ok := seen[string(c.Hash())]; ok {
+						continue
+					}
+					seen[string(c.Hash())] = struct{}{}
+					select {
+					case outCh <- c:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}()
+		return outCh, nil
+	}
+}
+
+// NewExcludingKeyChan returns a key provider streaming the keys of src for
+// which exclude returns false.
+func NewExcludingKeyChan(src KeyChanFunc, exclude func(cid.Cid) bool) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch, err := src(ctx)
+		if err != nil {
+			return nil, err
+		}
+		outCh := make(chan cid.Cid)
+		go func() {
+			defer close(outCh)
+			for c := range ch {
+				if exclude(c) {
+					continue
+				}
+				select {
+				case outCh <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return outCh, nil
+	}
+}
+
+// NewBufferedKeyChan returns a key provider reading up to size keys of src
+// ahead of its consumer, for a slow source, such as a blockstore scan, not to
+// hold up the announcements.
+func NewBufferedKeyChan(src KeyChanFunc, size int) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch, err := src(ctx)
+		if err != nil {
+			return nil, err
+		}
+		outCh := make(chan cid.Cid, size)
+		go func() {
+			defer close(outCh)
+			for c := range ch {
+				select {
+				case outCh <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return outCh, nil
+	}
+}
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func collectKeys(t *testing.T, f KeyChanFunc) []cid.Cid {
+	t.Helper()
+	ch, err := f(context.Background())
+	require.NoError(t, err)
+	var keys []cid.Cid
+	for c := range ch {
+		keys = append(keys, c)
+	}
+	return keys
+}
+
+func TestUnionKeyChan(t *testing.T) {
+	t.Parallel()
+
+	a, b, c, d, e := makeKey(t, "a"), makeKey(t, "b"), makeKey(t, "c"), makeKey(t, "d"), makeKey(t, "e")
+	failing := func(context.Context) (<-chan cid.Cid, error) {
+		return nil, errors.New("no keys")
+	}
+	union := NewUnionKeyChan(
+		keySource([]cid.Cid{a, b, c}, false),
+		failing,
+		keySource([]cid.Cid{b, d}, false),
+		// the same multihash as a
+		keySource([]cid.Cid{cid.NewCidV0(a.Hash()), e}, false),
+	)
+	require.Equal(t, []cid.Cid{a, b, c, d, e}, collectKeys(t, union))
+}
+
+func TestExcludingKeyChan(t *testing.T) {
+	t.Parallel()
+
+	a, b, c := makeKey(t, "a"), makeKey(t, "b"), makeKey(t, "c")
+	excluding := NewExcludingKeyChan(keySource([]cid.Cid{a, b, c}, false), func(k cid.Cid) bool {
+		return k == b
+	})
+	require.Equal(t, []cid.Cid{a, c}, collectKeys(t, excluding))
+
+	_, err := NewExcludingKeyChan(func(context.Context) (<-chan cid.Cid, error) {
+		return nil, errors.New("no keys")
+	}, nil)(context.Background())
+	require.Error(t, err)
+}
+
+func TestBufferedKeyChan(t *testing.T) {
+	t.Parallel()
+
+	keys := makeKeys(t, "key", 20)
+	var read atomic.Int32
+	src := func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch := make(chan cid.Cid)
+		go func() {
+			defer close(ch)
+			for _, k := range keys {
+				select {
+				case ch <- k:
+					read.Add(1)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+
+	ch, err := NewBufferedKeyChan(src, 10)(context.Background())
+	require.NoError(t, err)
+
+	// the keys are read ahead of the consumer, up to the buffer
+	require.Eventually(t, func() bool {
+		return read.Load() >= 10
+	}, 5*time.Second, time.Millisecond)
+	require.Never(t, func() bool {
+		return read.Load() > 12
+	}, 50*time.Millisecond, time.Millisecond)
+
+	var got []cid.Cid
+	for c := range ch {
+		got = append(got, c)
+	}
+	require.Equal(t, keys, got)
+}
+
+func TestKeyChanCancel(t *testing.T) {
+	// Not parallel, as it counts the goroutines.
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := NewBufferedKeyChan(NewExcludingKeyChan(NewUnionKeyChan(
+		keySource(makeKeys(t, "a", 10), true),
+		keySource(makeKeys(t, "b", 10), true),
+	), func(cid.Cid) bool { return false }), 4)
+	ch, err := f(ctx)
+	require.NoError(t, err)
+
+	<-ch
+	cancel()
+	// the channel is closed after the cancellation
+	for range ch {
+	}
+
+	// not require.Eventually, which runs the condition in a goroutine
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines leaked", runtime.NumGoroutine()-goroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics registers the metrics of the [System] with reg, which are read from
+// its [ReproviderStats]:
+//
+//   - ipfs_provider_queue_size is the number of keys waiting to be provided.
+//   - ipfs_provider_provides_total and ipfs_provider_provide_errors_total
+//     count the keys provided, and the ones whose provide failed.
+//   - ipfs_provider_skipped_provides_total counts the provides and the
+//     announcements skipped by the [RecencyFilter].
+//   - ipfs_provider_throughput is the rate of the last batch of provides, in
+//     keys per second, and ipfs_provider_rate_limit the limit of that rate, if
+//     any.
+//   - ipfs_provider_reprovide_cycle_provides is the number of keys provided
+//     by the running or last reprovide cycle.
+//   - ipfs_provider_last_reprovide_timestamp_seconds and
+//     ipfs_provider_last_reprovide_cycle_duration_seconds are the completion
+//     time and the duration of the last reprovide cycle.
+//   - ipfs_provider_reprovide_remaining_seconds estimates the time left
+//     before the running reprovide cycle completes.
+func Metrics(reg prometheus.Registerer) Option {
+	return func(system *reprovider) error {
+		system.metricsRegisterer = reg
+		return nil
+	}
+}
+
+func (s *reprovider) registerMetrics(reg prometheus.Registerer) error {
+	stat := func() ReproviderStats {
+		stats, _ := s.Stat()
+		return stats
+	}
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{Namespace: "ipfs", Subsystem: "provider", Name: name, Help: help}
+	}
+
+	for _, c := range []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("queue_size", "The number of keys waiting to be provided.")), func() float64 {
+			return float64(stat().QueueSize)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts(opts("provides_total", "The number of keys provided.")), func() float64 {
+			return float64(stat().TotalProvides)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts(opts("provide_errors_total", "The number of keys whose provide failed.")), func() float64 {
+			return float64(stat().ProvideErrors)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts(opts("skipped_provides_total", "The number of provides skipped by the recency filter.")), func() float64 {
+			return float64(stat().SkippedProvides)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("throughput", "The rate of the last batch of provides, in keys per second.")), func() float64 {
+			return stat().Throughput
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("rate_limit", "The limit of the provides, in keys per second, or zero.")), func() float64 {
+			return stat().RateLimit
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("reprovide_cycle_provides", "The number of keys provided by the running or last reprovide cycle.")), func() float64 {
+			return float64(stat().CycleProvides)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("last_reprovide_timestamp_seconds", "The completion time of the last reprovide cycle.")), func() float64 {
+			last := stat().LastReprovide
+			if last.IsZero() {
+				return 0
+			}
+			return float64(last.UnixNano()) / 1e9
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("last_reprovide_cycle_duration_seconds", "The duration of the last reprovide cycle.")), func() float64 {
+			return stat().LastCycleDuration.Seconds()
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("reprovide_remaining_seconds", "The estimated time left before the running reprovide cycle completes.")), func() float64 {
+			return stat().ReprovideRemaining.Seconds()
+		}),
+	} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// RateLimit limits the announcements, of the provides and of the reprovides
+// together, to perSecond keys per second on average, with bursts of up to
+// burst keys. If burst is not positive, it is perSecond rounded up. Zero, the
+// default, means no limit. The batches of keys given to [ProvideMany] are cut
+// to at most burst keys.
+func RateLimit(perSecond float64, burst int) Option {
+	return func(system *reprovider) error {
+		if perSecond < 0 {
+			return errors.New("the rate limit cannot be negative")
+		}
+		if perSecond > 0 && burst <= 0 {
+			burst = int(perSecond)
+			if float64(burst) < perSecond {
+				burst++
+			}
+		}
+		system.rateLimit = perSecond
+		system.rateBurst = burst
+		return nil
+	}
+}
+
+// ProvideWorkers sets the number of keys announced in parallel when the
+// router does not implement [ProvideMany]. Defaults to 1.
+func ProvideWorkers(n int) Option {
+	return func(system *reprovider) error {
+		if n < 1 {
+			return errors.New("there must be at least one provide worker")
+		}
+		system.provideWorkers = n
+		return nil
+	}
+}
+
+// withClock sets the clock of the rate limiter, for the tests.
+func withClock(clk clock.Clock) Option {
+	return func(system *reprovider) error {
+		system.clock = clk
+		return nil
+	}
+}
+
+// limiter is a token bucket, which lets the waits borrow tokens: a wait
+// reserves its tokens, and then waits until the bucket refilled them.
+type limiter struct {
+	clock clock.Clock
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(clk clock.Clock, rate float64, burst int) *limiter {
+	return &limiter{clock: clk, rate: rate, burst: burst, tokens: float64(burst), last: clk.Now()}
+}
+
+// wait waits until n tokens are available, n being at most the burst.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	timer := l.clock.Timer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doProvideMany announces keys within the rate limit, and returns the number
+// of keys which were not announced.
+func (s *reprovider) doProvideMany(ctx context.Context, keys []multihash.Multihash) (int, error) {
+	if many, ok := s.rsys.(ProvideMany); ok {
+		chunk := len(keys)
+		if s.limiter != nil {
+			chunk = s.limiter.burst
+		}
+		for i := 0; i < len(keys); i += chunk {
+			end := i + chunk
+			if end > len(keys) {
+				end = len(keys)
+			}
+			if err := s.waitLimit(ctx, end-i); err != nil {
+				return len(keys) - i, err
+			}
+			if err := many.ProvideMany(ctx, keys[i:end]); err != nil {
+				return len(keys) - i, err
+			}
+		}
+		return 0, nil
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+		first  error
+	)
+	work := make(chan multihash.Multihash)
+	for w := 0; w < s.provideWorkers && w < len(keys); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range work {
+				if err := s.rsys.Provide(ctx, cid.NewCidV1(cid.Raw, k), true); err != nil {
+					mu.Lock()
+					failed++
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i, k := range keys {
+		if err := s.waitLimit(ctx, 1); err != nil {
+			close(work)
+			wg.Wait()
+			return failed + len(keys) - i, err
+		}
+		work <- k
+	}
+	close(work)
+	wg.Wait()
+	return failed, first
+}
+
+func (s *reprovider) waitLimit(ctx context.Context, n int) error {
+	if s.limiter == nil {
+		return nil
+	}
+	return s.limiter.wait(ctx, n)
+}
@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	l := newLimiter(clk, 10, 5)
+	ctx := context.Background()
+
+	// the burst is absorbed without waiting
+	for i := 0; i < 5; i++ {
+		require.NoError(t, l.wait(ctx, 1))
+	}
+
+	// then the tokens come at the sustained rate
+	start := clk.Now()
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 10; i++ {
+			if err := l.wait(ctx, 1); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, clk.Since(start), time.Second)
+			return
+		default:
+			clk.Add(10 * time.Millisecond)
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	t.Parallel()
+
+	l := newLimiter(clock.NewMock(), 1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, l.wait(ctx, 1))
+	cancel()
+	require.ErrorIs(t, l.wait(ctx, 1), context.Canceled)
+}
+
+// countingRouter counts the keys announced, and the provides running at once.
+type countingRouter struct {
+	delay    time.Duration
+	count    atomic.Int64
+	inflight atomic.Int64
+	maxLk    sync.Mutex
+	max      int64
+	batches  []int
+}
+
+func (r *countingRouter) Provide(ctx context.Context, _ cid.Cid, _ bool) error {
+	n := r.inflight.Add(1)
+	defer r.inflight.Add(-1)
+	r.maxLk.Lock()
+	if n > r.max {
+		r.max = n
+	}
+	r.maxLk.Unlock()
+	time.Sleep(r.delay)
+	r.count.Add(1)
+	return nil
+}
+
+func (r *countingRouter) maxInflight() int64 {
+	r.maxLk.Lock()
+	defer r.maxLk.Unlock()
+	return r.max
+}
+
+// countingManyRouter is a countingRouter which provides the keys in batches.
+type countingManyRouter struct {
+	*countingRouter
+}
+
+func (r countingManyRouter) ProvideMany(ctx context.Context, keys []mh.Multihash) error {
+	r.maxLk.Lock()
+	r.batches = append(r.batches, len(keys))
+	r.maxLk.Unlock()
+	r.count.Add(int64(len(keys)))
+	return nil
+}
+
+func makeKeys(t *testing.T, prefix string, n int) []cid.Cid {
+	keys := make([]cid.Cid, n)
+	for i := range keys {
+		keys[i] = makeKey(t, prefix+strconv.Itoa(i))
+	}
+	return keys
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rate  = 10
+		burst = 5
+	)
+
+	// advance moves clk until the router announced n keys, checking that
+	// they stay within the limit
+	advance := func(t *testing.T, clk *clock.Mock, router *countingRouter, n int64) {
+		start := clk.Now()
+		require.Eventually(t, func() bool {
+			count := router.count.Load()
+			limit := burst + rate*clk.Since(start).Seconds()
+			require.LessOrEqual(t, float64(count), limit)
+			if count == n {
+				return true
+			}
+			clk.Add(10 * time.Millisecond)
+			return false
+		}, 10*time.Second, time.Millisecond)
+	}
+
+	t.Run("single", func(t *testing.T) {
+		t.Parallel()
+
+		clk := clock.NewMock()
+		router := &countingRouter{delay: 20 * time.Millisecond}
+		sys, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), Online(router),
+			KeyProvider(keySource(makeKeys(t, "reprovide", 20), false)),
+			RateLimit(rate, burst), ProvideWorkers(4), withClock(clk))
+		require.NoError(t, err)
+		defer sys.Close()
+
+		stats, err := sys.Stat()
+		require.NoError(t, err)
+		require.EqualValues(t, rate, stats.RateLimit)
+
+		// the provides and the reprovides share the limit
+		done := make(chan error)
+		go func() { done <- sys.Reprovide(context.Background()) }()
+		for _, k := range makeKeys(t, "provide", 5) {
+			require.NoError(t, sys.Provide(k))
+		}
+
+		// only the burst is announced until the clock moves
+		require.Eventually(t, func() bool {
+			return router.count.Load() == burst
+		}, 5*time.Second, time.Millisecond)
+		require.Never(t, func() bool {
+			return router.count.Load() > burst
+		}, 100*time.Millisecond, time.Millisecond)
+
+		advance(t, clk, router, 25)
+		require.NoError(t, <-done)
+		require.EqualValues(t, 4, router.maxInflight())
+	})
+
+	t.Run("many", func(t *testing.T) {
+		t.Parallel()
+
+		clk := clock.NewMock()
+		router := countingManyRouter{&countingRouter{}}
+		sys, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), Online(router),
+			KeyProvider(keySource(makeKeys(t, "reprovide", 12), false)),
+			RateLimit(rate, burst), withClock(clk))
+		require.NoError(t, err)
+		defer sys.Close()
+
+		done := make(chan error)
+		go func() { done <- sys.Reprovide(context.Background()) }()
+		advance(t, clk, router.countingRouter, 12)
+		require.NoError(t, <-done)
+
+		router.maxLk.Lock()
+		defer router.maxLk.Unlock()
+		for _, n := range router.batches {
+			require.LessOrEqual(t, n, burst)
+		}
+	})
+}
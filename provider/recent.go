@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	bloom "github.com/ipfs/bbloom"
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	// recencyBuckets is the number of bloom filters of a recency filter,
+	// each holding the keys of a fraction of the window.
+	recencyBuckets = 4
+	// recencyFalsePositives is the false positive rate of each bloom filter,
+	// when it holds as many keys as the capacity.
+	recencyFalsePositives = 0.001
+)
+
+// RecencyFilter skips the provides, and the announcements of the reprovides,
+// of the keys which were already provided or reprovided within window. It
+// records the keys of up to capacity provides per window, in about 8 MiB for
+// a million provides.
+//
+// The keys are recorded in bloom filters, which can have false positives: a
+// key can be skipped while it was not provided within window, at a rate of
+// about 0.1% per filter when they hold capacity keys, and more when that is
+// exceeded. It is then announced by a later reprovide. The keys are recorded
+// when they are enqueued, so the failed provides are not retried within
+// window either. A key can be provided again up to a quarter of window before
+// it lapses, but never after.
+//
+// window must be shorter than the reprovide interval, otherwise the keys
+// provided within it would never be reprovided: New fails if it is not.
+func RecencyFilter(window time.Duration, capacity int) Option {
+	return func(system *reprovider) error {
+		if window <= 0 || capacity < 1 {
+			return errors.New("the recency filter needs a window and a capacity")
+		}
+		system.recencyWindow = window
+		system.recencyCapacity = capacity
+		return nil
+	}
+}
+
+// recencyFilter is a ring of bloom filters, each recording the keys of a
+// period of the window, the oldest one being cleared when a period starts.
+type recencyFilter struct {
+	clock  clock.Clock
+	period time.Duration
+
+	mu      sync.Mutex
+	buckets []*bloom.Bloom
+	cur     int
+	start   time.Time
+}
+
+func newRecencyFilter(clk clock.Clock, window time.Duration, capacity int) (*recencyFilter, error) {
+	f := &recencyFilter{
+		clock:   clk,
+		period:  window / recencyBuckets,
+		buckets: make([]*bloom.Bloom, recencyBuckets),
+		start:   clk.Now(),
+	}
+	if f.period == 0 {
+		f.period = 1
+	}
+	for i := range f.buckets {
+		b, err := bloom.New(float64(capacity), recencyFalsePositives)
+		if err != nil {
+			return nil, err
+		}
+		f.buckets[i] = b
+	}
+	return f, nil
+}
+
+// seen reports whether c was recorded within the window, and records it
+// otherwise.
+func (f *recencyFilter) seen(c cid.Cid) bool {
+	key := []byte(c.Hash())
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if elapsed := f.clock.Since(f.start); elapsed >= f.period {
+		periods := int(elapsed / f.period)
+		for i := 0; i < periods && i < len(f.buckets); i++ {
+			f.cur = (f.cur + 1) % len(f.buckets)
+			f.buckets[f.cur].Clear()
+		}
+		f.start = f.start.Add(time.Duration(periods) * f.period)
+	}
+
+	for _, b := range f.buckets {
+		if b.Has(key) {
+			return true
+		}
+	}
+	f.buckets[f.cur].Add(key)
+	return false
+}
+
+// recentlyProvided reports whether c was provided within the window of the
+// recency filter, if any, and counts the skipped provide.
+func (s *reprovider) recentlyProvided(c cid.Cid) bool {
+	if s.recency == nil || !s.recency.seen(c) {
+		return false
+	}
+	s.statLk.Lock()
+	defer s.statLk.Unlock()
+	s.skippedProvides++
+	return true
+}
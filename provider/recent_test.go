@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecencyFilterWindow(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	f, err := newRecencyFilter(clk, time.Hour, 100)
+	require.NoError(t, err)
+
+	a, b := makeKey(t, "a"), makeKey(t, "b")
+	require.False(t, f.seen(a))
+	require.True(t, f.seen(a))
+	require.False(t, f.seen(b))
+
+	// the keys are kept for at least three quarters of the window
+	clk.Add(45*time.Minute - time.Second)
+	require.True(t, f.seen(a))
+	// and never for more than the window
+	clk.Add(15*time.Minute + time.Second)
+	require.False(t, f.seen(a))
+	require.False(t, f.seen(b))
+
+	// the whole ring is cleared after a long pause
+	clk.Add(10 * time.Hour)
+	require.False(t, f.seen(a))
+}
+
+func TestRecencyFilter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("skips the provides within the window", func(t *testing.T) {
+		t.Parallel()
+
+		clk := clock.NewMock()
+		prov := &mockProvideMany{}
+		sys, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), Online(prov), RecencyFilter(time.Hour, 1000), withClock(clk))
+		require.NoError(t, err)
+		defer sys.Close()
+
+		c := makeKey(t, "root")
+		for i := 0; i < 3; i++ {
+			require.NoError(t, sys.Provide(c))
+		}
+		require.Eventually(t, func() bool {
+			_, calls := prov.GetKeys()
+			return calls == 1
+		}, 5*time.Second, time.Millisecond)
+
+		clk.Add(time.Hour)
+		require.NoError(t, sys.Provide(c))
+		require.Eventually(t, func() bool {
+			_, calls := prov.GetKeys()
+			return calls == 2
+		}, 5*time.Second, time.Millisecond)
+		requireProvided(t, prov, c, c)
+
+		stats, err := sys.Stat()
+		require.NoError(t, err)
+		require.EqualValues(t, 2, stats.SkippedProvides)
+	})
+
+	t.Run("skips the announcements of the reprovides", func(t *testing.T) {
+		t.Parallel()
+
+		a, b := makeKey(t, "a"), makeKey(t, "b")
+		prov := &mockProvideMany{}
+		sys, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), Online(prov),
+			KeyProvider(keySource([]cid.Cid{a, b}, false)), RecencyFilter(time.Hour, 1000))
+		require.NoError(t, err)
+		defer sys.Close()
+
+		require.NoError(t, sys.Provide(a))
+		require.Eventually(t, func() bool {
+			keys, _ := prov.GetKeys()
+			return len(keys) == 1
+		}, 5*time.Second, time.Millisecond)
+
+		require.NoError(t, sys.Reprovide(context.Background()))
+		requireProvided(t, prov, a, b)
+		require.NoError(t, sys.Reprovide(context.Background()))
+		requireProvided(t, prov, a, b)
+
+		stats, err := sys.Stat()
+		require.NoError(t, err)
+		require.EqualValues(t, 3, stats.SkippedProvides)
+	})
+
+	t.Run("needs a window shorter than the reprovide interval", func(t *testing.T) {
+		t.Parallel()
+
+		ds := dssync.MutexWrap(datastore.NewMapDatastore())
+		_, err := New(ds, ReproviderInterval(time.Hour), RecencyFilter(time.Hour, 1000))
+		require.Error(t, err)
+
+		// unless the reprovides are disabled
+		sys, err := New(ds, ReproviderInterval(0), RecencyFilter(time.Hour, 1000))
+		require.NoError(t, err)
+		require.NoError(t, sys.Close())
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/ipfs/boxo/provider/internal/queue"
 	"github.com/ipfs/boxo/verifcid"
 	"github.com/ipfs/go-cid"
@@ -16,6 +17,7 @@ import (
 	"github.com/ipfs/go-datastore/namespace"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/multiformats/go-multihash"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -50,6 +52,7 @@ type reprovider struct {
 	allowlist   verifcid.Allowlist
 	rsys        Provide
 	keyProvider KeyChanFunc
+	keyTiers    []KeyTier
 
 	q  *queue.Queue
 	ds datastore.Batching
@@ -59,9 +62,28 @@ type reprovider struct {
 
 	maxReprovideBatchSize uint
 
+	clock          clock.Clock
+	rateLimit      float64
+	rateBurst      int
+	limiter        *limiter
+	provideWorkers int
+
+	recencyWindow   time.Duration
+	recencyCapacity int
+	recency         *recencyFilter
+
 	statLk                                    sync.Mutex
 	totalProvides, lastReprovideBatchSize     uint64
 	avgProvideDuration, lastReprovideDuration time.Duration
+	reprovideTier                             string
+	provideErrors, skippedProvides            uint64
+	throughput                                float64
+	// the current or last reprovide cycle, and the last completed one
+	reproviding                      bool
+	cycleStart                       time.Time
+	cycleProvides, lastCycleProvides uint64
+	lastCycleDuration                time.Duration
+	lastReprovide                    time.Time
 
 	throughputCallback ThroughputCallback
 	// throughputProvideCurrentCount counts how many provides has been done since the last call to throughputCallback
@@ -71,6 +93,8 @@ type reprovider struct {
 	throughputMinimumProvides uint
 
 	keyPrefix datastore.Key
+
+	metricsRegisterer prometheus.Registerer
 }
 
 var _ System = (*reprovider)(nil)
@@ -111,6 +135,8 @@ func New(ds datastore.Batching, opts ...Option) (System, error) {
 		keyPrefix:             DefaultKeyPrefix,
 		reprovideCh:           make(chan cid.Cid),
 		noReprovideInFlight:   make(chan struct{}),
+		clock:                 clock.New(),
+		provideWorkers:        1,
 	}
 
 	for _, o := range opts {
@@ -133,9 +159,31 @@ func New(ds datastore.Batching, opts ...Option) (System, error) {
 		}
 	}
 
+	if s.rateLimit > 0 {
+		s.limiter = newLimiter(s.clock, s.rateLimit, s.rateBurst)
+	}
+
+	if s.recencyWindow > 0 {
+		if s.reprovideInterval > 0 && s.recencyWindow >= s.reprovideInterval {
+			return nil, fmt.Errorf("the recency window %s must be shorter than the reprovide interval %s", s.recencyWindow, s.reprovideInterval)
+		}
+		f, err := newRecencyFilter(s.clock, s.recencyWindow, s.recencyCapacity)
+		if err != nil {
+			return nil, err
+		}
+		s.recency = f
+	}
+
 	s.ds = namespace.Wrap(ds, s.keyPrefix)
 	s.q = queue.NewQueue(s.ds)
 
+	if s.metricsRegisterer != nil {
+		if err := s.registerMetrics(s.metricsRegisterer); err != nil {
+			s.q.Close()
+			return nil, fmt.Errorf("registering provider metrics: %w", err)
+		}
+	}
+
 	// This is after the options processing so we do not have to worry about leaking a context if there is an
 	// initialization error processing the options
 	ctx, cancel := context.WithCancel(context.Background())
@@ -144,7 +192,7 @@ func New(ds datastore.Batching, opts ...Option) (System, error) {
 
 	if s.rsys != nil {
 		if _, ok := s.rsys.(ProvideMany); !ok {
-			s.maxReprovideBatchSize = 1
+			s.maxReprovideBatchSize = uint(s.provideWorkers)
 		}
 
 		s.run()
@@ -228,6 +276,8 @@ func (s *reprovider) run() {
 		defer s.closewg.Done()
 
 		m := make(map[cid.Cid]struct{})
+		// the keys of m in the order they were received
+		var ordered []cid.Cid
 
 		// setup stopped timers
 		maxCollectionDurationTimer := time.NewTimer(time.Hour)
@@ -272,10 +322,16 @@ func (s *reprovider) run() {
 				select {
 				case c := <-provCh:
 					resetTimersAfterReceivingProvide()
-					m[c] = struct{}{}
+					if _, ok := m[c]; !ok {
+						m[c] = struct{}{}
+						ordered = append(ordered, c)
+					}
 				case c := <-s.reprovideCh:
 					resetTimersAfterReceivingProvide()
-					m[c] = struct{}{}
+					if _, ok := m[c]; !ok {
+						m[c] = struct{}{}
+						ordered = append(ordered, c)
+					}
 					performedReprovide = true
 				case <-pauseDetectTimer.C:
 					// if this timer has fired then the max collection timer has started so let's stop and empty it
@@ -301,7 +357,7 @@ func (s *reprovider) run() {
 			}
 
 			keys := make([]multihash.Multihash, 0, len(m))
-			for c := range m {
+			for _, c := range ordered {
 				delete(m, c)
 
 				// hash security
@@ -313,6 +369,8 @@ func (s *reprovider) run() {
 				keys = append(keys, c.Hash())
 			}
 
+			ordered = ordered[:0]
+
 			// in case after removing all the invalid CIDs there are no valid ones left
 			if len(keys) == 0 {
 				continue
@@ -333,10 +391,17 @@ func (s *reprovider) run() {
 
 			log.Debugf("starting provide of %d keys", len(keys))
 			start := time.Now()
-			err := doProvideMany(s.ctx, s.rsys, keys)
+			failed, err := s.doProvideMany(s.ctx, keys)
 			if err != nil {
 				log.Debugf("providing failed %v", err)
-				continue
+				s.statLk.Lock()
+				s.provideErrors += uint64(failed)
+				s.statLk.Unlock()
+				if failed == len(keys) {
+					continue
+				}
+				// only the count of the keys matters from now on
+				keys = keys[:len(keys)-failed]
 			}
 			dur := time.Since(start)
 
@@ -346,12 +411,16 @@ func (s *reprovider) run() {
 			s.statLk.Lock()
 			s.avgProvideDuration = time.Duration((totalProvideTime + dur) / (time.Duration(s.totalProvides) + time.Duration(len(keys))))
 			s.totalProvides += uint64(len(keys))
+			if dur > 0 {
+				s.throughput = float64(len(keys)) / dur.Seconds()
+			}
 
 			log.Debugf("finished providing of %d keys. It took %v with an average of %v per provide", len(keys), dur, recentAvgProvideDuration)
 
 			if performedReprovide {
 				s.lastReprovideBatchSize = uint64(len(keys))
 				s.lastReprovideDuration = dur
+				s.cycleProvides += uint64(len(keys))
 
 				s.statLk.Unlock()
 
@@ -445,6 +514,9 @@ func (s *reprovider) Close() error {
 }
 
 func (s *reprovider) Provide(cid cid.Cid) error {
+	if s.recentlyProvided(cid) {
+		return nil
+	}
 	return s.q.Enqueue(cid)
 }
 
@@ -457,11 +529,44 @@ func (s *reprovider) reprovide(ctx context.Context, force bool) error {
 		return nil
 	}
 
+	s.statLk.Lock()
+	s.reproviding = true
+	s.cycleStart = time.Now()
+	s.cycleProvides = 0
+	s.statLk.Unlock()
+
+	err := s.reprovideCycle(ctx)
+
+	s.statLk.Lock()
+	s.reproviding = false
+	if err == nil {
+		s.lastReprovide = time.Now()
+		s.lastCycleDuration = s.lastReprovide.Sub(s.cycleStart)
+		s.lastCycleProvides = s.cycleProvides
+	}
+	s.statLk.Unlock()
+	return err
+}
+
+// reprovideCycle reprovides all the keys, and waits until they were provided.
+func (s *reprovider) reprovideCycle(ctx context.Context) error {
+	if len(s.keyTiers) > 0 {
+		return s.reprovideTiers(ctx)
+	}
+
 	kch, err := s.keyProvider(ctx)
 	if err != nil {
 		return err
 	}
+	if err := s.reprovideKeys(ctx, kch, nil); err != nil {
+		return err
+	}
+	return s.waitReprovide(ctx)
+}
 
+// reprovideKeys sends the keys of kch to the reprovide batches. If seen is
+// not nil, the keys in it are skipped, and the others are added to it.
+func (s *reprovider) reprovideKeys(ctx context.Context, kch <-chan cid.Cid, seen map[string]struct{}) error {
 reprovideCidLoop:
 	for {
 		select {
@@ -469,6 +574,15 @@ reprovideCidLoop:
 			if !ok {
 				break reprovideCidLoop
 			}
+			if seen != nil {
+				if _, ok := seen[string(c.Hash())]; ok {
+					continue
+				}
+				seen[string(c.Hash())] = struct{}{}
+			}
+			if s.recentlyProvided(c) {
+				continue
+			}
 
 			select {
 			case s.reprovideCh <- c:
@@ -483,8 +597,12 @@ reprovideCidLoop:
 			return errors.New("failed to reprovide: shutting down")
 		}
 	}
+	return nil
+}
 
-	// Wait until the underlying operation has completed
+// waitReprovide waits until the keys sent to the reprovide batches were
+// provided.
+func (s *reprovider) waitReprovide(ctx context.Context) error {
 	select {
 	case <-s.noReprovideInFlight:
 		return nil
@@ -513,6 +631,11 @@ func (s *reprovider) getLastReprovideTime() (time.Time, error) {
 }
 
 func (s *reprovider) shouldReprovide() bool {
+	// resume the interrupted reprovides
+	if s.hasReprovideCheckpoint() {
+		return true
+	}
+
 	t, err := s.getLastReprovideTime()
 	if err != nil {
 		log.Debugf("getting last reprovide time failed: %s", err)
@@ -528,6 +651,38 @@ func (s *reprovider) shouldReprovide() bool {
 type ReproviderStats struct {
 	TotalProvides, LastReprovideBatchSize     uint64
 	AvgProvideDuration, LastReprovideDuration time.Duration
+	// ReprovideTier is the name of the [KeyTier] being reprovided, if any.
+	ReprovideTier string
+
+	// QueueSize is the number of keys waiting to be provided.
+	QueueSize int
+	// ProvideErrors is the number of keys whose provide failed.
+	ProvideErrors uint64
+	// SkippedProvides is the number of provides and announcements skipped
+	// by the [RecencyFilter].
+	SkippedProvides uint64
+	// Throughput is the rate of the last batch of provides, in keys per
+	// second. It is the effective rate, which includes the waits of the
+	// [RateLimit].
+	Throughput float64
+	// RateLimit is the limit of the provides, in keys per second, or zero.
+	RateLimit float64
+
+	// Reproviding is true while a reprovide cycle runs. CycleProvides is the
+	// number of keys it provided so far, or that the last one provided.
+	Reproviding   bool
+	CycleProvides uint64
+	// LastReprovide is when the last reprovide cycle completed, and
+	// LastCycleDuration and LastCycleProvides its duration and number of
+	// keys provided.
+	LastReprovide     time.Time
+	LastCycleDuration time.Duration
+	LastCycleProvides uint64
+	// ReprovideRemaining estimates the time left before the running
+	// reprovide cycle completes, at the current throughput, assuming it has
+	// as many keys as the last one, plus the queued ones. It is zero when no
+	// cycle runs or there is no estimate.
+	ReprovideRemaining time.Duration
 }
 
 // Stat returns various stats about this provider system
@@ -539,18 +694,30 @@ func (s *reprovider) Stat() (ReproviderStats, error) {
 		LastReprovideBatchSize: s.lastReprovideBatchSize,
 		AvgProvideDuration:     s.avgProvideDuration,
 		LastReprovideDuration:  s.lastReprovideDuration,
+		ReprovideTier:          s.reprovideTier,
+		QueueSize:              s.q.Len(),
+		ProvideErrors:          s.provideErrors,
+		SkippedProvides:        s.skippedProvides,
+		Throughput:             s.throughput,
+		RateLimit:              s.rateLimit,
+		Reproviding:            s.reproviding,
+		CycleProvides:          s.cycleProvides,
+		LastReprovide:          s.lastReprovide,
+		LastCycleDuration:      s.lastCycleDuration,
+		LastCycleProvides:      s.lastCycleProvides,
+		ReprovideRemaining:     s.reprovideRemaining(),
 	}, nil
 }
 
-func doProvideMany(ctx context.Context, r Provide, keys []multihash.Multihash) error {
-	if many, ok := r.(ProvideMany); ok {
-		return many.ProvideMany(ctx, keys)
+// reprovideRemaining estimates the time left before the running reprovide
+// cycle completes. s.statLk must be held.
+func (s *reprovider) reprovideRemaining() time.Duration {
+	if !s.reproviding || s.throughput <= 0 {
+		return 0
 	}
-
-	for _, k := range keys {
-		if err := r.Provide(ctx, cid.NewCidV1(cid.Raw, k), true); err != nil {
-			return err
-		}
+	remaining := s.q.Len()
+	if s.lastCycleProvides > s.cycleProvides {
+		remaining += int(s.lastCycleProvides - s.cycleProvides)
 	}
-	return nil
+	return time.Duration(float64(remaining) / s.throughput * float64(time.Second))
 }
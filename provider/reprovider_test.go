@@ -3,9 +3,11 @@ package provider
 import (
 	"bytes"
 	"context"
+	"errors"
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,7 +16,9 @@ import (
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 	mh "github.com/multiformats/go-multihash"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type allFeatures interface {
@@ -221,3 +225,131 @@ func TestOfflineRecordsThenOnlineRepublish(t *testing.T) {
 		t.Fatalf("keys are not equal expected %v, got %v", someHash, prov.keys[0])
 	}
 }
+
+// failingProvider fails the provides of the keys in fail, it is meant to be
+// used as a singleMockWrapper.
+type failingProvider struct {
+	*mockProvideMany
+	fail map[cid.Cid]bool
+}
+
+func (p failingProvider) Provide(ctx context.Context, key cid.Cid, announce bool) error {
+	if p.fail[key] {
+		return errors.New("announce failed")
+	}
+	return p.mockProvideMany.Provide(ctx, key, announce)
+}
+
+func TestStat(t *testing.T) {
+	t.Parallel()
+
+	keys := make([]cid.Cid, 10)
+	for i := range keys {
+		h, err := mh.Sum([]byte(strconv.Itoa(i)), mh.SHA2_256, -1)
+		require.NoError(t, err)
+		keys[i] = cid.NewCidV1(cid.Raw, h)
+	}
+	fail := map[cid.Cid]bool{keys[1]: true, keys[4]: true, keys[7]: true}
+
+	// the keys of the second cycle wait for release after the first two
+	var cycles atomic.Int32
+	release := make(chan struct{})
+	keyProvider := func(ctx context.Context) (<-chan cid.Cid, error) {
+		second := cycles.Add(1) == 2
+		ch := make(chan cid.Cid)
+		go func() {
+			defer close(ch)
+			for i, k := range keys {
+				if second && i == 2 {
+					<-release
+				}
+				select {
+				case ch <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+
+	reg := prometheus.NewRegistry()
+	prov := failingProvider{mockProvideMany: &mockProvideMany{delay: time.Millisecond}, fail: fail}
+	sys, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), Online(singleMockWrapper{prov}), KeyProvider(keyProvider), Metrics(reg))
+	require.NoError(t, err)
+	defer sys.Close()
+
+	stats, err := sys.Stat()
+	require.NoError(t, err)
+	require.Equal(t, ReproviderStats{}, stats)
+
+	start := time.Now()
+	require.NoError(t, sys.Reprovide(context.Background()))
+	stats, err = sys.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, 7, stats.TotalProvides)
+	require.EqualValues(t, 3, stats.ProvideErrors)
+	require.EqualValues(t, 7, stats.CycleProvides)
+	require.EqualValues(t, 7, stats.LastCycleProvides)
+	require.False(t, stats.Reproviding)
+	require.WithinRange(t, stats.LastReprovide, start, time.Now())
+	require.Positive(t, stats.LastCycleDuration)
+	require.Positive(t, stats.Throughput)
+	require.Zero(t, stats.ReprovideRemaining)
+
+	// during the second cycle
+	done := make(chan error)
+	go func() { done <- sys.Reprovide(context.Background()) }()
+	require.Eventually(t, func() bool {
+		stats, err := sys.Stat()
+		require.NoError(t, err)
+		return stats.Reproviding && stats.CycleProvides == 1 && stats.ProvideErrors == 4
+	}, 5*time.Second, time.Millisecond)
+	stats, err = sys.Stat()
+	require.NoError(t, err)
+	require.Positive(t, stats.ReprovideRemaining)
+	close(release)
+	require.NoError(t, <-done)
+
+	stats, err = sys.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, 14, stats.TotalProvides)
+	require.EqualValues(t, 6, stats.ProvideErrors)
+
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, m := range metrics {
+		metric := m.GetMetric()[0]
+		if c := metric.GetCounter(); c != nil {
+			values[m.GetName()] = c.GetValue()
+		} else {
+			values[m.GetName()] = metric.GetGauge().GetValue()
+		}
+	}
+	require.Equal(t, float64(14), values["ipfs_provider_provides_total"])
+	require.Equal(t, float64(6), values["ipfs_provider_provide_errors_total"])
+	require.Equal(t, float64(7), values["ipfs_provider_reprovide_cycle_provides"])
+	require.Equal(t, float64(0), values["ipfs_provider_queue_size"])
+	require.Positive(t, values["ipfs_provider_last_reprovide_timestamp_seconds"])
+}
+
+func TestStatQueueSize(t *testing.T) {
+	t.Parallel()
+
+	// offline, the provides stay in the queue
+	sys, err := New(dssync.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, err)
+	defer sys.Close()
+
+	for i := 0; i < 5; i++ {
+		h, err := mh.Sum([]byte(strconv.Itoa(i)), mh.SHA2_256, -1)
+		require.NoError(t, err)
+		require.NoError(t, sys.Provide(cid.NewCidV1(cid.Raw, h)))
+	}
+	require.Eventually(t, func() bool {
+		stats, err := sys.Stat()
+		require.NoError(t, err)
+		return stats.QueueSize == 5
+	}, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+)
+
+var reprovideTierKey = datastore.NewKey("/reprovide/tier")
+
+// KeyTier is a tier of the keys to reprovide, see [PrioritizedKeyProvider].
+type KeyTier struct {
+	// Name identifies the tier in the checkpoints of the reprovides and in
+	// [ReproviderStats.ReprovideTier].
+	Name string
+
+	// Keys streams the keys of the tier.
+	Keys KeyChanFunc
+
+	// Recency, if set, returns when a key was last accessed, for the keys of
+	// the tier to be reprovided from the most recent one. They are all read
+	// before being sorted, which is meant for the last tier.
+	Recency func(cid.Cid) time.Time
+}
+
+// PrioritizedKeyProvider reprovides the keys of the tiers in order, such as
+// the pin roots, then the pin descendants, then MFS and then the rest of the
+// blockstore, instead of the keys of [KeyProvider]. A key of several tiers is
+// only reprovided with the first one. The keys of a tier are announced before
+// the ones of the next tier are collected.
+//
+// The tier being reprovided is checkpointed in the datastore: a reprovide
+// interrupted by a restart resumes at the start of that tier. The keys of the
+// previous tiers are then not read, so the ones which are in that tier too
+// are reprovided again.
+func PrioritizedKeyProvider(tiers ...KeyTier) Option {
+	return func(system *reprovider) error {
+		for _, t := range tiers {
+			if t.Name == "" || t.Keys == nil {
+				return errors.New("key tiers need a name and keys")
+			}
+		}
+		system.keyTiers = tiers
+		return nil
+	}
+}
+
+// reprovideTiers sends the keys of the tiers to the reprovide batches, from
+// the checkpointed tier, if any.
+func (s *reprovider) reprovideTiers(ctx context.Context) error {
+	start := 0
+	if name, err := s.ds.Get(ctx, reprovideTierKey); err == nil {
+		for i, t := range s.keyTiers {
+			if t.Name == string(name) {
+				start = i
+				log.Infof("resuming reprovide at tier %q", t.Name)
+				break
+			}
+		}
+	}
+	defer s.setReprovideTier("")
+
+	seen := make(map[string]struct{})
+	for _, t := range s.keyTiers[start:] {
+		if err := s.ds.Put(ctx, reprovideTierKey, []byte(t.Name)); err != nil {
+			return err
+		}
+		if err := s.ds.Sync(ctx, reprovideTierKey); err != nil {
+			return err
+		}
+		s.setReprovideTier(t.Name)
+
+		kch, err := t.Keys(ctx)
+		if err != nil {
+			return err
+		}
+		if t.Recency != nil {
+			kch = byRecency(ctx, kch, t.Recency)
+		}
+		if err := s.reprovideKeys(ctx, kch, seen); err != nil {
+			return err
+		}
+		// announce the tier before the next one
+		if err := s.waitReprovide(ctx); err != nil {
+			return err
+		}
+	}
+
+	return s.ds.Delete(ctx, reprovideTierKey)
+}
+
+func (s *reprovider) setReprovideTier(name string) {
+	s.statLk.Lock()
+	defer s.statLk.Unlock()
+	s.reprovideTier = name
+}
+
+// hasReprovideCheckpoint reports whether a reprovide was interrupted.
+func (s *reprovider) hasReprovideCheckpoint() bool {
+	if len(s.keyTiers) == 0 {
+		return false
+	}
+	has, err := s.ds.Has(s.ctx, reprovideTierKey)
+	return err == nil && has
+}
+
+// byRecency returns the keys of kch from the most recently accessed one.
+func byRecency(ctx context.Context, kch <-chan cid.Cid, recency func(cid.Cid) time.Time) <-chan cid.Cid {
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+
+		type key struct {
+			c  cid.Cid
+			at time.Time
+		}
+		var keys []key
+	collectLoop:
+		for {
+			select {
+			case c, ok := <-kch:
+				if !ok {
+					break collectLoop
+				}
+				keys = append(keys, key{c: c, at: recency(c)})
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		sort.SliceStable(keys, func(i, j int) bool {
+			return keys[i].at.After(keys[j].at)
+		})
+
+		for _, k := range keys {
+			select {
+			case out <- k.c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func makeKey(t *testing.T, s string) cid.Cid {
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+// keySource streams the keys, and then blocks until ctx is done if block is
+// set.
+func keySource(keys []cid.Cid, block bool) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch := make(chan cid.Cid)
+		go func() {
+			defer close(ch)
+			for _, k := range keys {
+				select {
+				case ch <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if block {
+				<-ctx.Done()
+			}
+		}()
+		return ch, nil
+	}
+}
+
+func requireProvided(t *testing.T, prov *mockProvideMany, expected ...cid.Cid) {
+	t.Helper()
+	keys, _ := prov.GetKeys()
+	expectedKeys := make([]mh.Multihash, len(expected))
+	for i, c := range expected {
+		expectedKeys[i] = c.Hash()
+	}
+	require.Equal(t, expectedKeys, keys)
+}
+
+func TestPrioritizedKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	keys := map[string]cid.Cid{}
+	for _, s := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		keys[s] = makeKey(t, s)
+	}
+	ks := func(names ...string) []cid.Cid {
+		var cids []cid.Cid
+		for _, n := range names {
+			cids = append(cids, keys[n])
+		}
+		return cids
+	}
+	accessed := map[cid.Cid]time.Time{
+		keys["f"]: time.Unix(1, 0),
+		keys["g"]: time.Unix(2, 0),
+		keys["h"]: time.Unix(3, 0),
+	}
+
+	tiers := func(blockDescendants bool) []KeyTier {
+		return []KeyTier{
+			{Name: "roots", Keys: keySource(ks("a", "b"), false)},
+			{Name: "descendants", Keys: keySource(ks("a", "c", "d"), blockDescendants)},
+			{Name: "mfs", Keys: keySource(ks("e", "c"), false)},
+			{Name: "all", Keys: keySource(ks("f", "g", "h", "b"), false), Recency: func(c cid.Cid) time.Time {
+				return accessed[c]
+			}},
+		}
+	}
+
+	t.Run("reprovides the tiers in order without duplicates", func(t *testing.T) {
+		t.Parallel()
+		for _, single := range []bool{false, true} {
+			prov := &mockProvideMany{}
+			var provider Provide = prov
+			if single {
+				provider = singleMockWrapper{prov}
+			}
+			sys, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), Online(provider), PrioritizedKeyProvider(tiers(false)...))
+			require.NoError(t, err)
+
+			require.NoError(t, sys.Reprovide(context.Background()))
+			requireProvided(t, prov, ks("a", "b", "c", "d", "e", "h", "g", "f")...)
+
+			stats, err := sys.Stat()
+			require.NoError(t, err)
+			require.Empty(t, stats.ReprovideTier)
+			require.NoError(t, sys.Close())
+		}
+	})
+
+	t.Run("resumes an interrupted reprovide at its tier", func(t *testing.T) {
+		t.Parallel()
+		ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+		prov := &mockProvideMany{}
+		sys, err := New(ds, Online(prov), PrioritizedKeyProvider(tiers(true)...))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- sys.Reprovide(ctx) }()
+
+		require.Eventually(t, func() bool {
+			stats, err := sys.Stat()
+			require.NoError(t, err)
+			return stats.ReprovideTier == "descendants"
+		}, 5*time.Second, 10*time.Millisecond)
+		// the roots were announced before the next tier was collected
+		requireProvided(t, prov, ks("a", "b")...)
+
+		cancel()
+		require.True(t, errors.Is(<-done, context.Canceled))
+		require.NoError(t, sys.Close())
+
+		// the restarted system resumes at the descendants, without waiting
+		// for the reprovide interval, and does not know the roots anymore
+		prov = &mockProvideMany{}
+		sys, err = New(ds, Online(prov), PrioritizedKeyProvider(tiers(false)...), initialReprovideDelay(0))
+		require.NoError(t, err)
+		defer sys.Close()
+
+		require.Eventually(t, func() bool {
+			keys, _ := prov.GetKeys()
+			return len(keys) == 8
+		}, 5*time.Second, 10*time.Millisecond)
+		requireProvided(t, prov, ks("a", "c", "d", "e", "h", "g", "f", "b")...)
+
+		require.Eventually(t, func() bool {
+			has, err := ds.Has(context.Background(), DefaultKeyPrefix.Child(reprovideTierKey))
+			require.NoError(t, err)
+			return !has
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
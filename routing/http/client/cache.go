@@ -0,0 +1,281 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+const (
+	// DefaultCacheMaxEntries is the default maximum number of responses kept
+	// by the cache of a [Client].
+	DefaultCacheMaxEntries = 1024
+
+	// DefaultCacheMaxBytes is the default maximum size of the responses kept
+	// by the cache of a [Client].
+	DefaultCacheMaxBytes = 16 << 20
+
+	// DefaultCacheNotFoundTTL is the default time during which a 404 Not
+	// Found response is cached.
+	DefaultCacheNotFoundTTL = 10 * time.Second
+)
+
+// CacheOptions are the options of the response cache of a [Client], see
+// [WithCache].
+type CacheOptions struct {
+	// MaxEntries is the maximum number of cached responses,
+	// [DefaultCacheMaxEntries] if not positive.
+	MaxEntries int
+
+	// MaxBytes is the maximum size of the cached response bodies,
+	// [DefaultCacheMaxBytes] if not positive. A response larger than
+	// MaxBytes, streamed or not, is not cached.
+	MaxBytes int
+
+	// DefaultMaxAge is how long the responses without a Cache-Control
+	// max-age stay fresh. Zero means they are not cached.
+	DefaultMaxAge time.Duration
+
+	// NotFoundTTL is how long the 404 Not Found responses are cached. Zero
+	// means they are not cached.
+	NotFoundTTL time.Duration
+}
+
+// DefaultCacheOptions returns the default [CacheOptions].
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{
+		MaxEntries:  DefaultCacheMaxEntries,
+		MaxBytes:    DefaultCacheMaxBytes,
+		NotFoundTTL: DefaultCacheNotFoundTTL,
+	}
+}
+
+// CacheStats are the counters of the response cache of a [Client].
+type CacheStats struct {
+	// Hits is the number of requests answered from the cache, including the
+	// revalidated ones.
+	Hits uint64
+	// Misses is the number of requests sent to the server in full.
+	Misses uint64
+	// Revalidations is the number of stale responses which the server
+	// confirmed with a 304 Not Modified.
+	Revalidations uint64
+}
+
+// cachingHTTPClient caches the successful and the 404 responses to GET
+// requests. It honors the Cache-Control max-age of the responses and
+// revalidates the stale ones with their ETag.
+type cachingHTTPClient struct {
+	httpClient
+	clock clock.Clock
+	opts  CacheOptions
+
+	mu    sync.Mutex
+	lru   *simplelru.LRU[string, *cacheEntry]
+	bytes int
+
+	hits, misses, revalidations atomic.Uint64
+}
+
+type cacheEntry struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	etag       string
+	expiry     time.Time
+}
+
+func newCachingHTTPClient(c httpClient, clk clock.Clock, opts CacheOptions) *cachingHTTPClient {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultCacheMaxEntries
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultCacheMaxBytes
+	}
+	cc := &cachingHTTPClient{httpClient: c, clock: clk, opts: opts}
+	cc.lru, _ = simplelru.NewLRU[string, *cacheEntry](opts.MaxEntries, func(_ string, e *cacheEntry) {
+		cc.bytes -= len(e.body)
+	})
+	return cc
+}
+
+func (c *cachingHTTPClient) stats() CacheStats {
+	return CacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Revalidations: c.revalidations.Load(),
+	}
+}
+
+func cacheKey(req *http.Request) string {
+	return req.URL.String() + " " + req.Header.Get("Accept")
+}
+
+func (c *cachingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.httpClient.Do(req)
+	}
+
+	key := cacheKey(req)
+	c.mu.Lock()
+	entry, ok := c.lru.Get(key)
+	c.mu.Unlock()
+	if ok && c.clock.Now().Before(entry.expiry) {
+		c.hits.Add(1)
+		return entry.response(req), nil
+	}
+	if ok && entry.etag != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		c.hits.Add(1)
+		c.revalidations.Add(1)
+		// the entry is not modified in place, it may be in use
+		refreshed := *entry
+		refreshed.expiry = c.clock.Now().Add(c.maxAge(resp.Header))
+		c.put(key, &refreshed)
+		return refreshed.response(req), nil
+	}
+	c.misses.Add(1)
+
+	var ttl time.Duration
+	switch resp.StatusCode {
+	case http.StatusOK:
+		ttl = c.maxAge(resp.Header)
+	case http.StatusNotFound:
+		ttl = c.opts.NotFoundTTL
+	}
+	if ttl <= 0 {
+		if ok {
+			c.invalidate(key)
+		}
+		return resp, nil
+	}
+
+	entry = &cacheEntry{
+		statusCode: resp.StatusCode,
+		header:     resp.Header.Clone(),
+		etag:       resp.Header.Get("Etag"),
+		expiry:     c.clock.Now().Add(ttl),
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == mediaTypeNDJSON {
+		// streamed responses are cached once they were read to the end
+		resp.Body = &cachingBody{ReadCloser: resp.Body, c: c, key: key, entry: entry}
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(c.opts.MaxBytes)+1))
+	if err != nil || len(body) > c.opts.MaxBytes {
+		// too large or broken, give the response as it is
+		resp.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	entry.body = body
+	c.put(key, entry)
+	return entry.response(req), nil
+}
+
+// maxAge returns how long a response with header stays fresh.
+func (c *cachingHTTPClient) maxAge(header http.Header) time.Duration {
+	maxAge := c.opts.DefaultMaxAge
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return maxAge
+}
+
+func (c *cachingHTTPClient) put(key string, entry *cacheEntry) {
+	if len(entry.body) > c.opts.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Add(key, entry)
+	c.bytes += len(entry.body)
+	for c.bytes > c.opts.MaxBytes {
+		c.lru.RemoveOldest()
+	}
+}
+
+func (c *cachingHTTPClient) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Remove(key)
+}
+
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(e.statusCode) + " " + http.StatusText(e.statusCode),
+		StatusCode:    e.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// cachingBody keeps a copy of a streamed response body, and caches the
+// response once the body was read to the end.
+type cachingBody struct {
+	io.ReadCloser
+	c     *cachingHTTPClient
+	key   string
+	entry *cacheEntry
+	buf   []byte
+	full  bool
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.full {
+		if len(b.buf)+n > b.c.opts.MaxBytes {
+			b.full = true
+			b.buf = nil
+		} else {
+			b.buf = append(b.buf, p[:n]...)
+		}
+	}
+	if err == io.EOF && !b.full {
+		b.entry.body = b.buf
+		b.c.put(b.key, b.entry)
+		// cache it once
+		b.full = true
+	}
+	return n, err
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheTestServer answers the provider requests with a record, or with the
+// given status code, and counts the requests.
+type cacheTestServer struct {
+	*httptest.Server
+	requests, revalidations atomic.Int64
+	statusCode              atomic.Int64
+}
+
+func newCacheTestServer(t *testing.T, contentType, cacheControl, body string) *cacheTestServer {
+	s := &cacheTestServer{}
+	s.statusCode.Store(http.StatusOK)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if code := int(s.statusCode.Load()); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		w.Header().Set("Etag", `"v1"`)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			s.revalidations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newCachingClient(t *testing.T, url string, opts CacheOptions) (*Client, *clock.Mock) {
+	c, err := New(url, WithCache(opts))
+	require.NoError(t, err)
+	clk := clock.NewMock()
+	c.cache.clock = clk
+	return c, clk
+}
+
+func findProvidersRecords(t *testing.T, c *Client, cid cid.Cid) []iter.Result[types.Record] {
+	it, err := c.FindProviders(context.Background(), cid)
+	require.NoError(t, err)
+	defer it.Close()
+	return iter.ReadAll[iter.Result[types.Record]](it)
+}
+
+func TestClientCache(t *testing.T) {
+	peerRecord := makePeerRecord()
+	line := marshalLine(t, peerRecord)
+	expected := []iter.Result[types.Record]{{Val: &peerRecord}}
+
+	for _, c := range []struct {
+		name, contentType, body string
+	}{
+		{name: "NDJSON", contentType: mediaTypeNDJSON, body: line + "\n"},
+		{name: "JSON", contentType: mediaTypeJSON, body: `{"Providers":[` + line + `]}`},
+	} {
+		c := c
+		t.Run("caches and revalidates "+c.name+" responses", func(t *testing.T) {
+			s := newCacheTestServer(t, c.contentType, "public, max-age=60", c.body)
+			client, clk := newCachingClient(t, s.URL, DefaultCacheOptions())
+
+			cid := makeCID()
+			for i := 0; i < 3; i++ {
+				it, err := client.FindProviders(context.Background(), cid)
+				require.NoError(t, err)
+				require.Equal(t, expected, iter.ReadAll[iter.Result[types.Record]](it))
+				require.NoError(t, it.Close())
+			}
+			require.EqualValues(t, 1, s.requests.Load())
+			require.Equal(t, CacheStats{Hits: 2, Misses: 1}, client.CacheStats())
+
+			// once stale, the response is revalidated with its ETag
+			clk.Add(61 * time.Second)
+			it, err := client.FindProviders(context.Background(), cid)
+			require.NoError(t, err)
+			require.Equal(t, expected, iter.ReadAll[iter.Result[types.Record]](it))
+			require.EqualValues(t, 2, s.requests.Load())
+			require.EqualValues(t, 1, s.revalidations.Load())
+			require.Equal(t, CacheStats{Hits: 3, Misses: 1, Revalidations: 1}, client.CacheStats())
+
+			// and fresh again
+			it, err = client.FindProviders(context.Background(), cid)
+			require.NoError(t, err)
+			require.Equal(t, expected, iter.ReadAll[iter.Result[types.Record]](it))
+			require.EqualValues(t, 2, s.requests.Load())
+		})
+	}
+
+	t.Run("does not cache a partly read stream", func(t *testing.T) {
+		// larger than what the first read buffers
+		s := newCacheTestServer(t, mediaTypeNDJSON, "max-age=60", strings.Repeat(line+"\n", 1000))
+		client, _ := newCachingClient(t, s.URL, DefaultCacheOptions())
+
+		cid := makeCID()
+		it, err := client.FindProvidersAsync(context.Background(), cid, 1)
+		require.NoError(t, err)
+		require.Len(t, iter.ReadAll[iter.Result[types.Record]](it), 1)
+		it, err = client.FindProvidersAsync(context.Background(), cid, 0)
+		require.NoError(t, err)
+		require.Len(t, iter.ReadAll[iter.Result[types.Record]](it), 1000)
+		require.EqualValues(t, 2, s.requests.Load())
+	})
+
+	t.Run("does not cache responses larger than MaxBytes", func(t *testing.T) {
+		s := newCacheTestServer(t, mediaTypeJSON, "max-age=60", `{"Providers":[`+line+`]}`)
+		opts := DefaultCacheOptions()
+		opts.MaxBytes = 16
+		client, _ := newCachingClient(t, s.URL, opts)
+		cid := makeCID()
+
+		require.Equal(t, expected, findProvidersRecords(t, client, cid))
+		require.Equal(t, expected, findProvidersRecords(t, client, cid))
+		require.EqualValues(t, 2, s.requests.Load())
+	})
+
+	t.Run("defaults the zero limits", func(t *testing.T) {
+		s := newCacheTestServer(t, mediaTypeJSON, "max-age=60", `{"Providers":[`+line+`]}`)
+		client, _ := newCachingClient(t, s.URL, CacheOptions{})
+		require.Equal(t, DefaultCacheMaxEntries, client.cache.opts.MaxEntries)
+		require.Equal(t, DefaultCacheMaxBytes, client.cache.opts.MaxBytes)
+		cid := makeCID()
+
+		require.Equal(t, expected, findProvidersRecords(t, client, cid))
+		require.Equal(t, expected, findProvidersRecords(t, client, cid))
+		require.EqualValues(t, 1, s.requests.Load())
+	})
+
+	t.Run("does not cache responses without max-age by default", func(t *testing.T) {
+		s := newCacheTestServer(t, mediaTypeJSON, "", `{"Providers":[`+line+`]}`)
+		client, _ := newCachingClient(t, s.URL, DefaultCacheOptions())
+		cid := makeCID()
+
+		findProvidersRecords(t, client, cid)
+		findProvidersRecords(t, client, cid)
+		require.EqualValues(t, 2, s.requests.Load())
+
+		opts := DefaultCacheOptions()
+		opts.DefaultMaxAge = time.Minute
+		client, _ = newCachingClient(t, s.URL, opts)
+		findProvidersRecords(t, client, cid)
+		findProvidersRecords(t, client, cid)
+		require.EqualValues(t, 3, s.requests.Load())
+	})
+
+	t.Run("caches 404 responses for NotFoundTTL", func(t *testing.T) {
+		s := newCacheTestServer(t, mediaTypeJSON, "max-age=60", `{"Providers":[`+line+`]}`)
+		s.statusCode.Store(http.StatusNotFound)
+		client, clk := newCachingClient(t, s.URL, DefaultCacheOptions())
+		cid := makeCID()
+
+		require.Empty(t, findProvidersRecords(t, client, cid))
+		clk.Add(DefaultCacheNotFoundTTL - time.Second)
+		require.Empty(t, findProvidersRecords(t, client, cid))
+		require.EqualValues(t, 1, s.requests.Load())
+
+		// the window is over
+		s.statusCode.Store(http.StatusOK)
+		clk.Add(2 * time.Second)
+		require.Equal(t, expected, findProvidersRecords(t, client, cid))
+		require.EqualValues(t, 2, s.requests.Load())
+	})
+
+	t.Run("does not cache errors", func(t *testing.T) {
+		s := newCacheTestServer(t, mediaTypeJSON, "max-age=60", "")
+		s.statusCode.Store(http.StatusInternalServerError)
+		client, _ := newCachingClient(t, s.URL, DefaultCacheOptions())
+
+		for i := 0; i < 2; i++ {
+			_, err := client.FindProviders(context.Background(), makeCID())
+			require.Error(t, err)
+		}
+		require.EqualValues(t, 2, s.requests.Load())
+		require.Equal(t, CacheStats{Misses: 2}, client.CacheStats())
+	})
+}
+
+func TestClientCacheIPNS(t *testing.T) {
+	sk, name := makeName(t)
+	record, _ := makeIPNSRecord(t, sk)
+
+	deps := makeTestDeps(t, []Option{WithCache(DefaultCacheOptions())}, nil)
+	deps.router.On("GetIPNS", mock.Anything, name).Return(record, nil).Once()
+
+	for i := 0; i < 2; i++ {
+		received, err := deps.client.GetIPNS(context.Background(), name)
+		require.NoError(t, err)
+		require.Equal(t, record, received)
+	}
+	require.Equal(t, CacheStats{Hits: 1, Misses: 1}, deps.client.CacheStats())
+	deps.router.AssertExpectations(t)
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -34,7 +35,6 @@ var (
 		Transport: &ResponseBodyLimitedTransport{
 			RoundTripper: http.DefaultTransport,
 			LimitBytes:   1 << 20,
-			UserAgent:    defaultUserAgent,
 		},
 	}
 )
@@ -43,6 +43,10 @@ const (
 	mediaTypeJSON       = "application/json"
 	mediaTypeNDJSON     = "application/x-ndjson"
 	mediaTypeIPNSRecord = "application/vnd.ipfs.ipns-record"
+
+	// continuationHeader is the header of the continuation token of the next
+	// page of results.
+	continuationHeader = "Routing-Continuation"
 )
 
 type Client struct {
@@ -50,6 +54,21 @@ type Client struct {
 	httpClient httpClient
 	clock      clock.Clock
 	accepts    string
+	userAgent  string
+	header     http.Header
+	mutators   []func(*http.Request)
+
+	maxRecordSize int
+	skipInvalid   bool
+
+	cacheOpts *CacheOptions
+	cache     *cachingHTTPClient
+
+	retryOpts   *RetryOptions
+	breakerOpts *CircuitBreakerOptions
+
+	filterAddrs     []string
+	filterProtocols []string
 
 	peerID   peer.ID
 	addrs    []types.Multiaddr
@@ -85,20 +104,14 @@ func WithHTTPClient(h httpClient) Option {
 	}
 }
 
+// WithUserAgent sets the User-Agent of the requests, which defaults to the
+// import path and the version of boxo.
 func WithUserAgent(ua string) Option {
 	return func(c *Client) {
 		if ua == "" {
 			return
 		}
-		httpClient, ok := c.httpClient.(*http.Client)
-		if !ok {
-			return
-		}
-		transport, ok := httpClient.Transport.(*ResponseBodyLimitedTransport)
-		if !ok {
-			return
-		}
-		transport.UserAgent = ua
+		c.userAgent = ua
 	}
 }
 
@@ -117,6 +130,82 @@ func WithStreamResultsRequired() Option {
 	}
 }
 
+// WithMaxRecordSize sets the maximum size of a single record of a streamed
+// response, in bytes. Larger records are reported as invalid. Defaults to
+// [ndjson.DefaultMaxRecordSize].
+func WithMaxRecordSize(size int) Option {
+	return func(c *Client) {
+		c.maxRecordSize = size
+	}
+}
+
+// WithSkipInvalidRecords skips the records of a streamed response which cannot
+// be decoded, instead of returning them as errors. They are still counted by
+// the [ViewInvalidRecords] metric.
+func WithSkipInvalidRecords() Option {
+	return func(c *Client) {
+		c.skipInvalid = true
+	}
+}
+
+// WithCache caches the responses of the GET requests in memory, such as the
+// ones of [Client.FindProviders] and [Client.GetIPNS], following their
+// Cache-Control and ETag headers. Errors are not cached, except the 404 Not
+// Found responses, for [CacheOptions.NotFoundTTL]. See [DefaultCacheOptions].
+func WithCache(opts CacheOptions) Option {
+	return func(c *Client) {
+		c.cacheOpts = &opts
+	}
+}
+
+// WithRetry retries the requests which fail with a transport error, a 5xx or a
+// 429 Too Many Requests response, such as the ones of [Client.FindProviders],
+// [Client.FindPeers] and [Client.GetIPNS], with exponential backoff. The
+// Retry-After header of the responses is honored. The provides are not
+// retried, unless [RetryOptions.RetryWrites] is set. See [DefaultRetryOptions].
+func WithRetry(opts RetryOptions) Option {
+	return func(c *Client) {
+		c.retryOpts = &opts
+	}
+}
+
+// WithCircuitBreaker fails fast with [ErrCircuitOpen] the requests to an
+// endpoint which failed [CircuitBreakerOptions.Failures] times in a row, with
+// a transport error or a 5xx response, until a request let through after
+// [CircuitBreakerOptions.Cooldown] succeeds. The state changes are counted by
+// the [ViewCircuitBreakerState] metric. See [DefaultCircuitBreakerOptions].
+func WithCircuitBreaker(opts CircuitBreakerOptions) Option {
+	return func(c *Client) {
+		c.breakerOpts = &opts
+	}
+}
+
+// WithFilterAddrs sets the filter-addrs of the provider and peer lookups, as
+// defined by [IPIP-484]: only the addresses with one of the given multiaddr
+// protocols, such as "webtransport", and none of the negated ones, such as
+// "!p2p-circuit", are returned. The records left without addresses are
+// dropped, unless [types.FilterUnknown] is given. The results are filtered by
+// the client too, for the servers which ignore the filters.
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+func WithFilterAddrs(addrs ...string) Option {
+	return func(c *Client) {
+		c.filterAddrs = append(c.filterAddrs, types.ParseFilter(strings.Join(addrs, ","))...)
+	}
+}
+
+// WithFilterProtocols sets the filter-protocols of the provider and peer
+// lookups, as defined by [IPIP-484]: only the records with one of the given
+// transfer protocols, such as "transport-bitswap", are returned. The results
+// are filtered by the client too, for the servers which ignore the filters.
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+func WithFilterProtocols(protocols ...string) Option {
+	return func(c *Client) {
+		c.filterProtocols = append(c.filterProtocols, types.ParseFilter(strings.Join(protocols, ","))...)
+	}
+}
+
 // New creates a content routing API client.
 // The Provider and identity parameters are option. If they are nil, the [client.ProvideBitswap] method will not function.
 func New(baseURL string, opts ...Option) (*Client, error) {
@@ -124,6 +213,7 @@ func New(baseURL string, opts ...Option) (*Client, error) {
 		baseURL:    baseURL,
 		httpClient: defaultHTTPClient,
 		clock:      clock.New(),
+		userAgent:  defaultUserAgent,
 		accepts:    strings.Join([]string{mediaTypeNDJSON, mediaTypeJSON}, ","),
 	}
 
@@ -131,6 +221,19 @@ func New(baseURL string, opts ...Option) (*Client, error) {
 		opt(client)
 	}
 
+	// every attempt of a request goes through the breaker, and the cache is
+	// in front of both
+	if client.breakerOpts != nil {
+		client.httpClient = newBreakingHTTPClient(client.httpClient, client.clock, *client.breakerOpts)
+	}
+	if client.retryOpts != nil {
+		client.httpClient = newRetryingHTTPClient(client.httpClient, client.clock, *client.retryOpts)
+	}
+	if client.cacheOpts != nil {
+		client.cache = newCachingHTTPClient(client.httpClient, client.clock, *client.cacheOpts)
+		client.httpClient = client.cache
+	}
+
 	if client.identity != nil && client.peerID.Size() != 0 && !client.peerID.MatchesPublicKey(client.identity.GetPublic()) {
 		return nil, errors.New("identity does not match provider")
 	}
@@ -138,6 +241,14 @@ func New(baseURL string, opts ...Option) (*Client, error) {
 	return client, nil
 }
 
+// CacheStats returns the counters of the response cache, see [WithCache].
+func (c *Client) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.stats()
+}
+
 // measuringIter measures the length of the iter and then publishes metrics about the whole req once the iter is closed.
 // Of course, if the caller forgets to close the iter, this won't publish anything.
 type measuringIter[T any] struct {
@@ -156,6 +267,9 @@ func (c *measuringIter[T]) Val() T {
 }
 
 func (c *measuringIter[T]) Close() error {
+	if s, ok := any(c.Iter).(*ndjson.RecordsStream); ok {
+		c.m.invalid = s.Invalid()
+	}
 	c.m.record(c.ctx)
 	return c.Iter.Close()
 }
@@ -163,14 +277,110 @@ func (c *measuringIter[T]) Close() error {
 // FindProviders searches for providers that are able to provide the given [cid.Cid].
 // In a more generic way, it is also used as a mapping between CIDs and relevant metadata.
 func (c *Client) FindProviders(ctx context.Context, key cid.Cid) (providers iter.ResultIter[types.Record], err error) {
-	// TODO test measurements
-	m := newMeasurement("FindProviders")
+	return c.findProviders(ctx, "FindProviders", key, 0)
+}
 
-	url := c.baseURL + "/routing/v1/providers/" + key.String()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// FindProvidersAsync is like [Client.FindProviders], but returns at most limit
+// records, or all of them if limit is 0. Streamed responses are decoded as the
+// records arrive: a record which cannot be decoded is returned as an error, or
+// skipped with [WithSkipInvalidRecords], without ending the iterator.
+//
+// The response body is closed once limit records were read or the iterator is
+// closed, which must be done to release the connection.
+func (c *Client) FindProvidersAsync(ctx context.Context, key cid.Cid, limit int) (iter.ResultIter[types.Record], error) {
+	return c.findProviders(ctx, "FindProvidersAsync", key, limit)
+}
+
+// FindProvidersPaged is like [Client.FindProviders], but follows the
+// continuation tokens of the servers which return the providers by pages, see
+// [github.com/ipfs/boxo/routing/http/server.ProvidersPager], until max records
+// were read, or all of them if max is 0. The pages are requested as the
+// iterator reaches their end. An error requesting a page is returned as the
+// last result of the iterator.
+func (c *Client) FindProvidersPaged(ctx context.Context, key cid.Cid, max int) (iter.ResultIter[types.Record], error) {
+	page, next, err := c.findProvidersPage(ctx, "FindProvidersPaged", key, max, "")
 	if err != nil {
 		return nil, err
 	}
+	return &pagingIter{c: c, ctx: ctx, key: key, max: max, page: page, next: next}, nil
+}
+
+// pagingIter iterates over the pages of the providers of a CID.
+type pagingIter struct {
+	c   *Client
+	ctx context.Context
+	key cid.Cid
+	max int
+
+	page  iter.ResultIter[types.Record]
+	next  string
+	count int
+	val   iter.Result[types.Record]
+}
+
+func (p *pagingIter) Next() bool {
+	for p.page != nil {
+		if p.max > 0 && p.count >= p.max {
+			return false
+		}
+		if p.page.Next() {
+			p.val = p.page.Val()
+			p.count++
+			return true
+		}
+
+		p.page.Close()
+		p.page = nil
+		if p.next == "" {
+			return false
+		}
+		limit := 0
+		if p.max > 0 {
+			limit = p.max - p.count
+		}
+		var err error
+		p.page, p.next, err = p.c.findProvidersPage(p.ctx, "FindProvidersPaged", p.key, limit, p.next)
+		if err != nil {
+			p.val = iter.Result[types.Record]{Err: err}
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pagingIter) Val() iter.Result[types.Record] {
+	return p.val
+}
+
+func (p *pagingIter) Close() error {
+	if p.page == nil {
+		return nil
+	}
+	err := p.page.Close()
+	p.page = nil
+	return err
+}
+
+func (c *Client) findProviders(ctx context.Context, operation string, key cid.Cid, limit int) (iter.ResultIter[types.Record], error) {
+	it, _, err := c.findProvidersPage(ctx, operation, key, limit, "")
+	return it, err
+}
+
+// findProvidersPage returns at most limit providers of key, from the page of
+// the given continuation token, and the token of the next page, if any.
+func (c *Client) findProvidersPage(ctx context.Context, operation string, key cid.Cid, limit int, continuation string) (iter.ResultIter[types.Record], string, error) {
+	// TODO test measurements
+	m := newMeasurement(operation)
+
+	query := url.Values{}
+	if continuation != "" {
+		query.Set("continuation", continuation)
+	}
+	url := c.baseURL + "/routing/v1/providers/" + key.String() + c.query(query)
+	req, err := c.newRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
 	req.Header.Set("Accept", c.accepts)
 
 	m.host = req.Host
@@ -183,21 +393,21 @@ func (c *Client) FindProviders(ctx context.Context, key cid.Cid) (providers iter
 
 	if err != nil {
 		m.record(ctx)
-		return nil, err
+		return nil, "", err
 	}
 
 	m.statusCode = resp.StatusCode
 	if resp.StatusCode == http.StatusNotFound {
 		resp.Body.Close()
 		m.record(ctx)
-		return iter.FromSlice[iter.Result[types.Record]](nil), nil
+		return iter.FromSlice[iter.Result[types.Record]](nil), "", nil
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		err := httpError(resp.StatusCode, resp.Body)
+		err := httpError(resp.StatusCode, resp.Body, resp.Request)
 		resp.Body.Close()
 		m.record(ctx)
-		return nil, err
+		return nil, "", err
 	}
 
 	respContentType := resp.Header.Get("Content-Type")
@@ -206,7 +416,7 @@ func (c *Client) FindProviders(ctx context.Context, key cid.Cid) (providers iter
 		resp.Body.Close()
 		m.err = err
 		m.record(ctx)
-		return nil, fmt.Errorf("parsing Content-Type: %w", err)
+		return nil, "", fmt.Errorf("parsing Content-Type: %w", err)
 	}
 
 	m.mediaType = mediaType
@@ -219,21 +429,43 @@ func (c *Client) FindProviders(ctx context.Context, key cid.Cid) (providers iter
 	}()
 
 	var it iter.ResultIter[types.Record]
+	next := resp.Header.Get(continuationHeader)
 	switch mediaType {
 	case mediaTypeJSON:
 		parsedResp := &jsontypes.ProvidersResponse{}
 		err = json.NewDecoder(resp.Body).Decode(parsedResp)
+		if parsedResp.Continuation != "" {
+			next = parsedResp.Continuation
+		}
+		if c.hasFilters() {
+			var filtered []types.Record
+			for _, r := range parsedResp.Providers {
+				if r = types.FilterRecord(r, c.filterAddrs, c.filterProtocols); r != nil {
+					filtered = append(filtered, r)
+				}
+			}
+			parsedResp.Providers = filtered
+		}
+		if limit > 0 && len(parsedResp.Providers) > limit {
+			parsedResp.Providers = parsedResp.Providers[:limit]
+		}
 		var sliceIt iter.Iter[types.Record] = iter.FromSlice(parsedResp.Providers)
 		it = iter.ToResultIter(sliceIt)
 	case mediaTypeNDJSON:
 		skipBodyClose = true
-		it = ndjson.NewRecordsIter(resp.Body)
+		it = ndjson.NewRecordsStream(resp.Body, ndjson.RecordsStreamOptions{
+			MaxRecordSize:   c.maxRecordSize,
+			Limit:           limit,
+			SkipInvalid:     c.skipInvalid,
+			FilterAddrs:     c.filterAddrs,
+			FilterProtocols: c.filterProtocols,
+		})
 	default:
 		logger.Errorw("unknown media type", "MediaType", mediaType, "ContentType", respContentType)
-		return nil, errors.New("unknown content type")
+		return nil, "", errors.New("unknown content type")
 	}
 
-	return &measuringIter[iter.Result[types.Record]]{Iter: it, ctx: ctx, m: m}, nil
+	return &measuringIter[iter.Result[types.Record]]{Iter: it, ctx: ctx, m: m}, next, nil
 }
 
 // Deprecated: protocol-agnostic provide is being worked on in [IPIP-378]:
@@ -296,7 +528,7 @@ func (c *Client) provideSignedBitswapRecord(ctx context.Context, bswp *types.Wri
 		return 0, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewBuffer(b))
+	httpReq, err := c.newRequest(ctx, http.MethodPut, url, bytes.NewBuffer(b))
 	if err != nil {
 		return 0, err
 	}
@@ -308,7 +540,7 @@ func (c *Client) provideSignedBitswapRecord(ctx context.Context, bswp *types.Wri
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, httpError(resp.StatusCode, resp.Body)
+		return 0, httpError(resp.StatusCode, resp.Body, resp.Request)
 	}
 
 	//lint:ignore SA1019 // ignore staticcheck
@@ -338,8 +570,8 @@ func (c *Client) provideSignedBitswapRecord(ctx context.Context, bswp *types.Wri
 func (c *Client) FindPeers(ctx context.Context, pid peer.ID) (peers iter.ResultIter[*types.PeerRecord], err error) {
 	m := newMeasurement("FindPeers")
 
-	url := c.baseURL + "/routing/v1/peers/" + peer.ToCid(pid).String()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	url := c.baseURL + "/routing/v1/peers/" + peer.ToCid(pid).String() + c.filterQuery()
+	req, err := c.newRequest(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -366,7 +598,7 @@ func (c *Client) FindPeers(ctx context.Context, pid peer.ID) (peers iter.ResultI
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		err := httpError(resp.StatusCode, resp.Body)
+		err := httpError(resp.StatusCode, resp.Body, resp.Request)
 		resp.Body.Close()
 		m.record(ctx)
 		return nil, err
@@ -405,16 +637,56 @@ func (c *Client) FindPeers(ctx context.Context, pid peer.ID) (peers iter.ResultI
 		return nil, errors.New("unknown content type")
 	}
 
+	if c.hasFilters() {
+		mapped := iter.Map[iter.Result[*types.PeerRecord]](it, func(res iter.Result[*types.PeerRecord]) iter.Result[*types.PeerRecord] {
+			if res.Err == nil && res.Val != nil {
+				res.Val = types.FilterPeerRecord(res.Val, c.filterAddrs, c.filterProtocols)
+			}
+			return res
+		})
+		it = iter.Filter[iter.Result[*types.PeerRecord]](mapped, func(res iter.Result[*types.PeerRecord]) bool {
+			return res.Err != nil || res.Val != nil
+		})
+	}
+
 	return &measuringIter[iter.Result[*types.PeerRecord]]{Iter: it, ctx: ctx, m: m}, nil
 }
 
+func (c *Client) hasFilters() bool {
+	return len(c.filterAddrs) > 0 || len(c.filterProtocols) > 0
+}
+
+// filterQuery returns the query string of the [IPIP-484] filters, if any.
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+func (c *Client) filterQuery() string {
+	return c.query(url.Values{})
+}
+
+// query returns the query string of the given parameters and of the
+// [IPIP-484] filters, if any.
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+func (c *Client) query(query url.Values) string {
+	if len(c.filterAddrs) > 0 {
+		query.Set("filter-addrs", strings.Join(c.filterAddrs, ","))
+	}
+	if len(c.filterProtocols) > 0 {
+		query.Set("filter-protocols", strings.Join(c.filterProtocols, ","))
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
 // GetIPNS tries to retrieve the [ipns.Record] for the given [ipns.Name]. The record is
 // validated against the given name. If validation fails, an error is returned, but no
-// record.
+// record. If the server has no record, the error matches [routing.ErrNotFound].
 func (c *Client) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
 	url := c.baseURL + "/routing/v1/ipns/" + name.String()
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	httpReq, err := c.newRequest(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -427,11 +699,12 @@ func (c *Client) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, err
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, httpError(resp.StatusCode, resp.Body)
+		return nil, httpError(resp.StatusCode, resp.Body, resp.Request)
 	}
 
-	// Limit the reader to the maximum record size.
-	rawRecord, err := io.ReadAll(io.LimitReader(resp.Body, int64(ipns.MaxRecordSize)))
+	// Limit the reader to the maximum record size. One more byte is read so
+	// that larger records are rejected with an [ipns.RecordSizeError].
+	rawRecord, err := io.ReadAll(io.LimitReader(resp.Body, int64(ipns.MaxRecordSize)+1))
 	if err != nil {
 		return nil, fmt.Errorf("making HTTP req to get IPNS record: %w", err)
 	}
@@ -458,7 +731,7 @@ func (c *Client) PutIPNS(ctx context.Context, name ipns.Name, record *ipns.Recor
 		return err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(rawRecord))
+	httpReq, err := c.newRequest(ctx, http.MethodPut, url, bytes.NewReader(rawRecord))
 	if err != nil {
 		return err
 	}
@@ -471,7 +744,7 @@ func (c *Client) PutIPNS(ctx context.Context, name ipns.Name, record *ipns.Recor
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return httpError(resp.StatusCode, resp.Body)
+		return httpError(resp.StatusCode, resp.Body, resp.Request)
 	}
 
 	return nil
@@ -1,26 +1,37 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/benbjohnson/clock"
 	ipns "github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/namesys"
 	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/boxo/routing/http/contentrouter"
 	"github.com/ipfs/boxo/routing/http/server"
 	"github.com/ipfs/boxo/routing/http/types"
 	"github.com/ipfs/boxo/routing/http/types/iter"
+	"github.com/ipfs/boxo/routing/http/types/ndjson"
 	"github.com/ipfs/go-cid"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multibase"
 	"github.com/multiformats/go-multihash"
@@ -31,8 +42,8 @@ import (
 
 type mockContentRouter struct{ mock.Mock }
 
-func (m *mockContentRouter) FindProviders(ctx context.Context, key cid.Cid, limit int) (iter.ResultIter[types.Record], error) {
-	args := m.Called(ctx, key, limit)
+func (m *mockContentRouter) FindProviders(ctx context.Context, key cid.Cid, opts server.FindOptions) (iter.ResultIter[types.Record], error) {
+	args := m.Called(ctx, key, opts)
 	return args.Get(0).(iter.ResultIter[types.Record]), args.Error(1)
 }
 
@@ -42,8 +53,8 @@ func (m *mockContentRouter) ProvideBitswap(ctx context.Context, req *server.Bits
 	return args.Get(0).(time.Duration), args.Error(1)
 }
 
-func (m *mockContentRouter) FindPeers(ctx context.Context, pid peer.ID, limit int) (iter.ResultIter[*types.PeerRecord], error) {
-	args := m.Called(ctx, pid, limit)
+func (m *mockContentRouter) FindPeers(ctx context.Context, pid peer.ID, opts server.FindOptions) (iter.ResultIter[*types.PeerRecord], error) {
+	args := m.Called(ctx, pid, opts)
 	return args.Get(0).(iter.ResultIter[*types.PeerRecord]), args.Error(1)
 }
 
@@ -344,9 +355,9 @@ func TestClient_FindProviders(t *testing.T) {
 
 			routerResultIter := iter.FromSlice(c.routerResult)
 			if c.expStreamingResponse {
-				router.On("FindProviders", mock.Anything, cid, 0).Return(routerResultIter, c.routerErr)
+				router.On("FindProviders", mock.Anything, cid, server.FindOptions{Limit: 0}).Return(routerResultIter, c.routerErr)
 			} else {
-				router.On("FindProviders", mock.Anything, cid, 20).Return(routerResultIter, c.routerErr)
+				router.On("FindProviders", mock.Anything, cid, server.FindOptions{Limit: 20}).Return(routerResultIter, c.routerErr)
 			}
 
 			resultIter, err := client.FindProviders(ctx, cid)
@@ -358,6 +369,271 @@ func TestClient_FindProviders(t *testing.T) {
 	}
 }
 
+// slowNDJSONServer streams lines, one every interval, and records when the
+// client goes away.
+type slowNDJSONServer struct {
+	*httptest.Server
+	written atomic.Int64
+	gone    chan struct{}
+}
+
+func newSlowNDJSONServer(t *testing.T, interval time.Duration, lines ...string) *slowNDJSONServer {
+	s := &slowNDJSONServer{gone: make(chan struct{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(s.gone)
+		w.Header().Set("Content-Type", mediaTypeNDJSON)
+		for _, line := range lines {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			s.written.Add(1)
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func marshalLine(t *testing.T, v any) string {
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestClient_FindProvidersAsync(t *testing.T) {
+	peerRecord := makePeerRecord()
+	record := marshalLine(t, peerRecord)
+	invalid := `{"Schema":"peer","ID":"not a peer"}`
+	large := marshalLine(t, map[string]string{"Schema": "other", "Data": strings.Repeat("a", 1024)})
+
+	t.Run("closes the stream once the limit is reached", func(t *testing.T) {
+		lines := make([]string, 100)
+		for i := range lines {
+			lines[i] = record
+		}
+		s := newSlowNDJSONServer(t, 50*time.Millisecond, lines...)
+		client, err := New(s.URL)
+		require.NoError(t, err)
+
+		it, err := client.FindProvidersAsync(context.Background(), makeCID(), 2)
+		require.NoError(t, err)
+		results := iter.ReadAll[iter.Result[types.Record]](it)
+		require.Len(t, results, 2)
+		for _, res := range results {
+			require.NoError(t, res.Err)
+			require.Equal(t, &peerRecord, res.Val)
+		}
+
+		select {
+		case <-s.gone:
+		case <-time.After(time.Second):
+			t.Fatal("the connection was not closed at the limit")
+		}
+		require.Less(t, s.written.Load(), int64(5))
+	})
+
+	t.Run("closes the stream when the iterator is closed", func(t *testing.T) {
+		lines := make([]string, 100)
+		for i := range lines {
+			lines[i] = record
+		}
+		s := newSlowNDJSONServer(t, 50*time.Millisecond, lines...)
+		client, err := New(s.URL)
+		require.NoError(t, err)
+
+		it, err := client.FindProvidersAsync(context.Background(), makeCID(), 0)
+		require.NoError(t, err)
+		require.True(t, it.Next())
+		require.NoError(t, it.Val().Err)
+		require.NoError(t, it.Close())
+
+		select {
+		case <-s.gone:
+		case <-time.After(time.Second):
+			t.Fatal("the connection was not closed with the iterator")
+		}
+	})
+
+	t.Run("returns invalid records as errors and continues", func(t *testing.T) {
+		s := newSlowNDJSONServer(t, 0, record, invalid, "not json", "", large, record)
+		client, err := New(s.URL, WithMaxRecordSize(512))
+		require.NoError(t, err)
+
+		it, err := client.FindProvidersAsync(context.Background(), makeCID(), 0)
+		require.NoError(t, err)
+		results := iter.ReadAll[iter.Result[types.Record]](it)
+		require.Len(t, results, 5)
+		require.Equal(t, &peerRecord, results[0].Val)
+		require.Error(t, results[1].Err)
+		require.Error(t, results[2].Err)
+		require.ErrorIs(t, results[3].Err, ndjson.ErrRecordTooLarge)
+		require.Equal(t, &peerRecord, results[4].Val)
+	})
+
+	t.Run("skips invalid records", func(t *testing.T) {
+		s := newSlowNDJSONServer(t, 0, invalid, record, large, "not json", record)
+		client, err := New(s.URL, WithMaxRecordSize(512), WithSkipInvalidRecords())
+		require.NoError(t, err)
+
+		it, err := client.FindProvidersAsync(context.Background(), makeCID(), 0)
+		require.NoError(t, err)
+		results := iter.ReadAll[iter.Result[types.Record]](it)
+		require.Equal(t, []iter.Result[types.Record]{{Val: &peerRecord}, {Val: &peerRecord}}, results)
+		require.Equal(t, 3, it.(*measuringIter[iter.Result[types.Record]]).Iter.(*ndjson.RecordsStream).Invalid())
+	})
+
+	t.Run("limits JSON responses", func(t *testing.T) {
+		deps := makeTestDeps(t, nil, []server.Option{server.WithStreamingResultsDisabled()})
+		cid := makeCID()
+		results := []iter.Result[types.Record]{{Val: &peerRecord}, {Val: &peerRecord}, {Val: &peerRecord}}
+		deps.router.On("FindProviders", mock.Anything, cid, server.FindOptions{Limit: 20}).Return(iter.FromSlice(results), nil)
+
+		it, err := deps.client.FindProvidersAsync(context.Background(), cid, 2)
+		require.NoError(t, err)
+		require.Equal(t, results[:2], iter.ReadAll[iter.Result[types.Record]](it))
+	})
+}
+
+// pagingRouter serves its records by pages, with the offset of the next page
+// as continuation token.
+type pagingRouter struct {
+	mockContentRouter
+	records []iter.Result[types.Record]
+}
+
+func (r *pagingRouter) FindProvidersPage(ctx context.Context, key cid.Cid, opts server.FindOptions, continuation string) (iter.ResultIter[types.Record], string, error) {
+	var offset int
+	if continuation != "" {
+		if _, err := fmt.Sscanf(continuation, "offset:%d", &offset); err != nil {
+			return nil, "", err
+		}
+	}
+	if offset > len(r.records) {
+		return nil, "", errors.New("offset out of range")
+	}
+	end := len(r.records)
+	if opts.Limit > 0 && offset+opts.Limit < end {
+		end = offset + opts.Limit
+	}
+	var next string
+	if end < len(r.records) {
+		next = fmt.Sprintf("offset:%d", end)
+	}
+	return iter.FromSlice(r.records[offset:end]), next, nil
+}
+
+func TestClient_FindProvidersPaged(t *testing.T) {
+	records := make([]iter.Result[types.Record], 5000)
+	for i := range records {
+		records[i] = iter.Result[types.Record]{Val: &types.PeerRecord{
+			Schema:    types.SchemaPeer,
+			Protocols: []string{fmt.Sprintf("transport-%d", i)},
+		}}
+	}
+
+	newPagingServer := func(t *testing.T, router server.ContentRouter, opts ...server.Option) (*httptest.Server, *atomic.Int64) {
+		var requests atomic.Int64
+		s := httptest.NewServer(&recordingHandler{
+			Handler: server.Handler(router, opts...),
+			f:       []func(*http.Request){func(*http.Request) { requests.Add(1) }},
+		})
+		t.Cleanup(s.Close)
+		return s, &requests
+	}
+	readProtocols := func(t *testing.T, it iter.ResultIter[types.Record]) []string {
+		defer it.Close()
+		var protocols []string
+		for it.Next() {
+			res := it.Val()
+			require.NoError(t, res.Err)
+			protocols = append(protocols, res.Val.(*types.PeerRecord).Protocols[0])
+		}
+		return protocols
+	}
+
+	for _, c := range []struct {
+		name             string
+		accepts          string
+		serverOpts       []server.Option
+		max              int
+		expectedRecords  int
+		expectedRequests int64
+	}{
+		{name: "follows all the JSON pages", accepts: mediaTypeJSON, expectedRecords: 5000, expectedRequests: 5000 / server.DefaultRecordsLimit},
+		{name: "stops at the cap", accepts: mediaTypeJSON, max: 45, expectedRecords: 45, expectedRequests: 3},
+		{name: "stops at a page boundary cap", accepts: mediaTypeJSON, max: 40, expectedRecords: 40, expectedRequests: 2},
+		{name: "follows all the NDJSON pages", accepts: mediaTypeNDJSON, serverOpts: []server.Option{server.WithStreamingRecordsLimit(1000)}, expectedRecords: 5000, expectedRequests: 5},
+		{name: "reads an unpaged NDJSON response", accepts: mediaTypeNDJSON, expectedRecords: 5000, expectedRequests: 1},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			s, requests := newPagingServer(t, &pagingRouter{records: records}, c.serverOpts...)
+			client, err := New(s.URL)
+			require.NoError(t, err)
+			client.accepts = c.accepts
+
+			it, err := client.FindProvidersPaged(context.Background(), makeCID(), c.max)
+			require.NoError(t, err)
+			protocols := readProtocols(t, it)
+			require.Len(t, protocols, c.expectedRecords)
+			for i, p := range protocols {
+				require.Equal(t, fmt.Sprintf("transport-%d", i), p)
+			}
+			require.Equal(t, c.expectedRequests, requests.Load())
+		})
+	}
+
+	t.Run("reads a single page from a server which does not page", func(t *testing.T) {
+		router := &mockContentRouter{}
+		router.On("FindProviders", mock.Anything, mock.Anything, server.FindOptions{Limit: server.DefaultRecordsLimit}).
+			Return(iter.FromSlice(records[:server.DefaultRecordsLimit]), nil)
+		s, requests := newPagingServer(t, router)
+		client, err := New(s.URL)
+		require.NoError(t, err)
+		client.accepts = mediaTypeJSON
+
+		it, err := client.FindProvidersPaged(context.Background(), makeCID(), 0)
+		require.NoError(t, err)
+		require.Len(t, readProtocols(t, it), server.DefaultRecordsLimit)
+		require.EqualValues(t, 1, requests.Load())
+	})
+
+	t.Run("returns the error of a page as the last result", func(t *testing.T) {
+		// the second page fails
+		router := &pagingRouter{records: records[:30]}
+		s, _ := newPagingServer(t, &failingPager{pagingRouter: router})
+		client, err := New(s.URL)
+		require.NoError(t, err)
+		client.accepts = mediaTypeJSON
+
+		it, err := client.FindProvidersPaged(context.Background(), makeCID(), 0)
+		require.NoError(t, err)
+		defer it.Close()
+		results := iter.ReadAll[iter.Result[types.Record]](it)
+		require.Len(t, results, server.DefaultRecordsLimit+1)
+		var httpErr *HTTPError
+		require.ErrorAs(t, results[len(results)-1].Err, &httpErr)
+		require.Equal(t, http.StatusInternalServerError, httpErr.StatusCode)
+	})
+}
+
+// failingPager fails the requests of the pages after the first one.
+type failingPager struct {
+	*pagingRouter
+}
+
+func (p *failingPager) FindProvidersPage(ctx context.Context, key cid.Cid, opts server.FindOptions, continuation string) (iter.ResultIter[types.Record], string, error) {
+	if continuation != "" {
+		return nil, "", errors.New("backend failure")
+	}
+	return p.pagingRouter.FindProvidersPage(ctx, key, opts, continuation)
+}
+
 func TestClient_Provide(t *testing.T) {
 	cases := []struct {
 		name            string
@@ -598,9 +874,9 @@ func TestClient_FindPeers(t *testing.T) {
 
 			routerResultIter := iter.FromSlice(c.routerResult)
 			if c.expStreamingResponse {
-				router.On("FindPeers", mock.Anything, pid, 0).Return(routerResultIter, c.routerErr)
+				router.On("FindPeers", mock.Anything, pid, server.FindOptions{Limit: 0}).Return(routerResultIter, c.routerErr)
 			} else {
-				router.On("FindPeers", mock.Anything, pid, 20).Return(routerResultIter, c.routerErr)
+				router.On("FindPeers", mock.Anything, pid, server.FindOptions{Limit: 20}).Return(routerResultIter, c.routerErr)
 			}
 
 			resultIter, err := client.FindPeers(ctx, pid)
@@ -612,6 +888,59 @@ func TestClient_FindPeers(t *testing.T) {
 	}
 }
 
+func TestClient_FindPeersContract(t *testing.T) {
+	peerRecord := makePeerRecord()
+	pid := *peerRecord.ID
+
+	for _, streaming := range []bool{true, false} {
+		streaming := streaming
+		var serverOpts []server.Option
+		limit := 0
+		contentType := mediaTypeNDJSON
+		if !streaming {
+			serverOpts = append(serverOpts, server.WithStreamingResultsDisabled())
+			limit = 20
+			contentType = mediaTypeJSON
+		}
+
+		t.Run("finds the peer ("+contentType+")", func(t *testing.T) {
+			deps := makeTestDeps(t, nil, serverOpts)
+			deps.recordingHTTPClient.f = append(deps.recordingHTTPClient.f, func(r *http.Response) {
+				assert.Equal(t, contentType, r.Header.Get("Content-Type"))
+			})
+			results := []iter.Result[*types.PeerRecord]{{Val: &peerRecord}}
+			deps.router.On("FindPeers", mock.Anything, pid, server.FindOptions{Limit: limit}).Return(iter.FromSlice(results), nil)
+
+			resultIter, err := deps.client.FindPeers(context.Background(), pid)
+			require.NoError(t, err)
+			require.Equal(t, results, iter.ReadAll[iter.Result[*types.PeerRecord]](resultIter))
+		})
+
+		t.Run("returns no peers for an unknown peer ("+contentType+")", func(t *testing.T) {
+			deps := makeTestDeps(t, nil, serverOpts)
+			deps.recordingHTTPClient.f = append(deps.recordingHTTPClient.f, func(r *http.Response) {
+				assert.Equal(t, http.StatusNotFound, r.StatusCode)
+			})
+			deps.router.On("FindPeers", mock.Anything, pid, server.FindOptions{Limit: limit}).Return(iter.FromSlice([]iter.Result[*types.PeerRecord]{}), nil)
+
+			resultIter, err := deps.client.FindPeers(context.Background(), pid)
+			require.NoError(t, err)
+			require.Empty(t, iter.ReadAll[iter.Result[*types.PeerRecord]](resultIter))
+		})
+
+		t.Run("returns ErrNotSupported without peer routing ("+contentType+")", func(t *testing.T) {
+			deps := makeTestDeps(t, nil, serverOpts)
+			deps.router.On("FindPeers", mock.Anything, pid, server.FindOptions{Limit: limit}).Return(iter.FromSlice([]iter.Result[*types.PeerRecord]{}), routing.ErrNotSupported)
+
+			_, err := deps.client.FindPeers(context.Background(), pid)
+			require.ErrorIs(t, err, routing.ErrNotSupported)
+			var httpErr *HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			require.Equal(t, http.StatusNotImplemented, httpErr.StatusCode)
+		})
+	}
+}
+
 func makeName(t *testing.T) (crypto.PrivKey, ipns.Name) {
 	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
 	require.NoError(t, err)
@@ -709,3 +1038,155 @@ func TestClient_IPNS(t *testing.T) {
 		runWithRecordOptions(t, ipns.WithV1Compatibility(false))
 	})
 }
+
+// memoryIPNSRouter is a [server.ContentRouter] storing the IPNS records in
+// memory, as they were put.
+type memoryIPNSRouter struct {
+	mockContentRouter
+	mu      sync.Mutex
+	records map[string][]byte
+}
+
+func (m *memoryIPNSRouter) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	raw, ok := m.records[name.String()]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return ipns.UnmarshalRecord(raw)
+}
+
+func (m *memoryIPNSRouter) PutIPNS(ctx context.Context, name ipns.Name, record *ipns.Record) error {
+	raw, err := ipns.MarshalRecord(record)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[name.String()] = raw
+	return nil
+}
+
+func TestClient_IPNSEndToEnd(t *testing.T) {
+	ctx := context.Background()
+	router := &memoryIPNSRouter{records: make(map[string][]byte)}
+	srv := httptest.NewServer(server.Handler(router))
+	t.Cleanup(srv.Close)
+	client, err := New(srv.URL)
+	require.NoError(t, err)
+
+	sk, name := makeName(t)
+	value := path.FromCid(makeCID())
+
+	// namesys publishes and resolves through the delegated router
+	ns, err := namesys.NewNameSystem(contentrouter.NewContentRoutingClient(client))
+	require.NoError(t, err)
+	_, err = client.GetIPNS(ctx, name)
+	require.ErrorIs(t, err, routing.ErrNotFound)
+	require.NoError(t, ns.Publish(ctx, sk, value))
+	res, err := ns.Resolve(ctx, name.AsPath(), namesys.ResolveWithoutCache())
+	require.NoError(t, err)
+	require.Equal(t, value.String(), res.Path.String())
+
+	record, err := client.GetIPNS(ctx, name)
+	require.NoError(t, err)
+	got, err := record.Value()
+	require.NoError(t, err)
+	require.Equal(t, value.String(), got.String())
+
+	t.Run("rejects tampered records", func(t *testing.T) {
+		other, err := ipns.NewRecord(sk, value, 1, time.Now().Add(time.Hour), 0)
+		require.NoError(t, err)
+		raw, err := ipns.MarshalRecord(other)
+		require.NoError(t, err)
+
+		// a record signed by another key is refused by the server
+		_, otherName := makeName(t)
+		err = client.PutIPNS(ctx, otherName, other)
+		var httpErr *HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+
+		// and by the client, when the server returns it anyway
+		router.mu.Lock()
+		router.records[otherName.String()] = raw
+		router.mu.Unlock()
+		_, err = client.GetIPNS(ctx, otherName)
+		require.ErrorContains(t, err, "not valid")
+
+		// the same goes for a record modified after signing
+		tampered := bytes.Replace(raw, []byte(value.String()), []byte(path.FromCid(makeCID()).String()), 1)
+		require.NotEqual(t, raw, tampered)
+		router.mu.Lock()
+		router.records[name.String()] = tampered
+		router.mu.Unlock()
+		_, err = client.GetIPNS(ctx, name)
+		require.ErrorContains(t, err, "not valid")
+	})
+}
+
+func TestClient_Filters(t *testing.T) {
+	pid := *makePeerRecord().ID
+	keep := types.PeerRecord{
+		Schema:    types.SchemaPeer,
+		ID:        &pid,
+		Protocols: []string{"transport-bitswap"},
+		Addrs:     addrsToDRAddrs([]multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1/webtransport")}),
+		Extra:     map[string]json.RawMessage{},
+	}
+	mixed := keep
+	mixed.Addrs = append(addrsToDRAddrs([]multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")}), keep.Addrs...)
+	relayed := keep
+	relayed.Addrs = addrsToDRAddrs([]multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1/webtransport/p2p/" + pid.String() + "/p2p-circuit")})
+	gateway := keep
+	gateway.Protocols = []string{"transport-ipfs-gateway-http"}
+
+	for _, contentType := range []string{mediaTypeJSON, mediaTypeNDJSON} {
+		contentType := contentType
+		t.Run(contentType, func(t *testing.T) {
+			// a server ignoring the filters
+			var queries []url.Values
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				queries = append(queries, r.URL.Query())
+				w.Header().Set("Content-Type", contentType)
+				var lines []string
+				for _, r := range []types.PeerRecord{mixed, relayed, gateway} {
+					lines = append(lines, marshalLine(t, r))
+				}
+				switch {
+				case contentType == mediaTypeNDJSON:
+					io.WriteString(w, strings.Join(lines, "\n"))
+				case strings.HasPrefix(r.URL.Path, "/routing/v1/peers/"):
+					io.WriteString(w, `{"Peers":[`+strings.Join(lines, ",")+`]}`)
+				default:
+					io.WriteString(w, `{"Providers":[`+strings.Join(lines, ",")+`]}`)
+				}
+			}))
+			t.Cleanup(srv.Close)
+
+			client, err := New(srv.URL, WithFilterAddrs("webtransport", "!p2p-circuit"), WithFilterProtocols("transport-bitswap"))
+			require.NoError(t, err)
+
+			provIter, err := client.FindProviders(context.Background(), makeCID())
+			require.NoError(t, err)
+			require.Equal(t, []iter.Result[types.Record]{{Val: &keep}}, iter.ReadAll[iter.Result[types.Record]](provIter))
+
+			peersIter, err := client.FindPeers(context.Background(), pid)
+			require.NoError(t, err)
+			require.Equal(t, []iter.Result[*types.PeerRecord]{{Val: &keep}}, iter.ReadAll[iter.Result[*types.PeerRecord]](peersIter))
+
+			require.Len(t, queries, 2)
+			for _, query := range queries {
+				require.Equal(t, "webtransport,!p2p-circuit", query.Get("filter-addrs"))
+				require.Equal(t, "transport-bitswap", query.Get("filter-protocols"))
+			}
+		})
+	}
+
+	t.Run("no filters", func(t *testing.T) {
+		client, err := New("http://example.com")
+		require.NoError(t, err)
+		require.Empty(t, client.filterQuery())
+	})
+}
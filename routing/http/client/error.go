@@ -3,6 +3,9 @@ package client
 import (
 	"fmt"
 	"io"
+	"net/http"
+
+	"github.com/libp2p/go-libp2p/core/routing"
 )
 
 type HTTPError struct {
@@ -14,7 +17,23 @@ func (e *HTTPError) Error() string {
 	return fmt.Sprintf("HTTP error with StatusCode=%d: %s", e.StatusCode, e.Body)
 }
 
-func httpError(statusCode int, body io.Reader) error {
+// Is matches [routing.ErrNotSupported] for the 501 Not Implemented responses
+// of servers which do not support the operation, and [routing.ErrNotFound]
+// for the 404 Not Found responses.
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case routing.ErrNotSupported:
+		return e.StatusCode == http.StatusNotImplemented
+	case routing.ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	default:
+		return false
+	}
+}
+
+// httpError returns the error of a response to req, without the values of
+// its sensitive headers, which servers may echo.
+func httpError(statusCode int, body io.Reader, req *http.Request) error {
 	bodyBytes, err := io.ReadAll(io.LimitReader(body, 1024))
 	if err != nil {
 		logger.Warnw("could not read body bytes from error response", "Error", err)
@@ -22,6 +41,6 @@ func httpError(statusCode int, body io.Reader) error {
 	}
 	return &HTTPError{
 		StatusCode: statusCode,
-		Body:       string(bodyBytes),
+		Body:       redactHeaders(string(bodyBytes), req),
 	}
 }
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// redacted replaces the values of the sensitive headers in the errors.
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are the headers whose values are redacted from the errors
+// of the [Client], see [WithHeader].
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+	"Api-Key",
+}
+
+// WithHeader sets a header of all the requests of the [Client], such as the
+// Authorization or the API key of an endpoint. The values of the
+// credential headers, such as Authorization, Cookie or X-Api-Key, are
+// redacted from the errors. The User-Agent is set with [WithUserAgent].
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		if c.header == nil {
+			c.header = http.Header{}
+		}
+		c.header.Add(key, value)
+	}
+}
+
+// WithRequestMutator sets a function called on every request of the
+// [Client] before it is sent, after the headers of [WithHeader] and of
+// [WithRequestOptions] were set, for example to refresh a token.
+func WithRequestMutator(f func(*http.Request)) Option {
+	return func(c *Client) {
+		c.mutators = append(c.mutators, f)
+	}
+}
+
+// RequestOption is an option of the requests sent by a [Client] with a
+// context, see [WithRequestOptions].
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	header http.Header
+}
+
+// WithRequestHeader sets a header of the requests, over the ones of
+// [WithHeader].
+func WithRequestHeader(key, value string) RequestOption {
+	return func(o *requestOptions) {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		o.header.Add(key, value)
+	}
+}
+
+type requestOptionsKey struct{}
+
+// WithRequestOptions returns a context whose requests, such as the ones of
+// [Client.FindProviders], get the given options, which are added to the ones
+// of ctx.
+//
+// The options are given with the context, rather than to the methods, for
+// the [Client] to keep implementing [contentrouter.Client].
+func WithRequestOptions(ctx context.Context, opts ...RequestOption) context.Context {
+	var o requestOptions
+	if parent, ok := ctx.Value(requestOptionsKey{}).(*requestOptions); ok {
+		o.header = parent.header.Clone()
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return context.WithValue(ctx, requestOptionsKey{}, &o)
+}
+
+// newRequest returns a request with the User-Agent and the headers of c and
+// of ctx, and applies the mutators of c to it.
+func (c *Client) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for key, values := range c.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	if o, ok := ctx.Value(requestOptionsKey{}).(*requestOptions); ok {
+		for key, values := range o.header {
+			req.Header[key] = append([]string(nil), values...)
+		}
+	}
+	for _, mutate := range c.mutators {
+		mutate(req)
+	}
+	return req, nil
+}
+
+// redactHeaders replaces the values of the sensitive headers of req in s,
+// and the credentials of the ones with an authentication scheme, such as
+// "Bearer <token>".
+func redactHeaders(s string, req *http.Request) string {
+	if req == nil {
+		return s
+	}
+	for _, key := range sensitiveHeaders {
+		for _, value := range req.Header.Values(key) {
+			if value == "" {
+				continue
+			}
+			s = strings.ReplaceAll(s, value, redacted)
+			if _, credentials, ok := strings.Cut(value, " "); ok && credentials != "" {
+				s = strings.ReplaceAll(s, credentials, redacted)
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+// headersServer records the headers of the requests, and answers them with
+// 404 Not Found, or with 500 and the Authorization header in the body.
+type headersServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	headers map[string][]http.Header
+	echo    bool
+}
+
+func newHeadersServer(t *testing.T) *headersServer {
+	s := &headersServer{headers: map[string][]http.Header{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.headers[r.Method+" "+r.URL.Path] = append(s.headers[r.Method+" "+r.URL.Path], r.Header.Clone())
+		echo := s.echo
+		s.mu.Unlock()
+		if echo {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("invalid credentials: " + r.Header.Get("Authorization")))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *headersServer) allHeaders() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []http.Header
+	for _, headers := range s.headers {
+		all = append(all, headers...)
+	}
+	return all
+}
+
+func TestClientHeaders(t *testing.T) {
+	peerID, addrs, identity := makeProviderAndIdentity()
+	sk, name := makeName(t)
+	record, _ := makeIPNSRecord(t, sk)
+
+	// calls each endpoint
+	callAll := func(ctx context.Context, c *Client) {
+		it, err := c.FindProviders(ctx, makeCID())
+		if err == nil {
+			it.Close()
+		}
+		peers, err := c.FindPeers(ctx, peerID)
+		if err == nil {
+			peers.Close()
+		}
+		_, _ = c.ProvideBitswap(ctx, []cid.Cid{makeCID()}, time.Hour)
+		_, _ = c.GetIPNS(ctx, name)
+		_ = c.PutIPNS(ctx, name, record)
+	}
+
+	t.Run("sends the headers to every endpoint", func(t *testing.T) {
+		s := newHeadersServer(t)
+		var seq atomic.Int64
+		c, err := New(s.URL,
+			WithProviderInfo(peerID, addrs),
+			WithIdentity(identity),
+			WithHeader("Authorization", "Bearer secret"),
+			WithHeader("X-Trace-Id", "default"),
+			WithRequestMutator(func(r *http.Request) {
+				r.Header.Set("X-Seq", strconv.FormatInt(seq.Add(1), 10))
+			}),
+		)
+		require.NoError(t, err)
+
+		callAll(WithRequestOptions(context.Background(), WithRequestHeader("X-Trace-Id", "call")), c)
+
+		s.mu.Lock()
+		require.Len(t, s.headers, 5)
+		s.mu.Unlock()
+		seqs := map[string]bool{}
+		for _, h := range s.allHeaders() {
+			require.Equal(t, "Bearer secret", h.Get("Authorization"))
+			require.Equal(t, []string{"call"}, h.Values("X-Trace-Id"))
+			require.Equal(t, defaultUserAgent, h.Get("User-Agent"))
+			seqs[h.Get("X-Seq")] = true
+		}
+		// the mutator ran once per request
+		require.Len(t, seqs, 5)
+	})
+
+	t.Run("the request options of the contexts add up", func(t *testing.T) {
+		s := newHeadersServer(t)
+		c, err := New(s.URL, WithHeader("X-Trace-Id", "default"))
+		require.NoError(t, err)
+
+		ctx := WithRequestOptions(context.Background(), WithRequestHeader("X-Tenant", "a"))
+		ctx = WithRequestOptions(ctx, WithRequestHeader("X-Trace-Id", "call"))
+		_, _ = c.GetIPNS(ctx, name)
+
+		headers := s.allHeaders()
+		require.Len(t, headers, 1)
+		require.Equal(t, "a", headers[0].Get("X-Tenant"))
+		require.Equal(t, []string{"call"}, headers[0].Values("X-Trace-Id"))
+	})
+
+	t.Run("overrides the User-Agent with a custom HTTP client", func(t *testing.T) {
+		s := newHeadersServer(t)
+		c, err := New(s.URL, WithHTTPClient(&http.Client{}), WithUserAgent("custom"))
+		require.NoError(t, err)
+
+		_, _ = c.GetIPNS(context.Background(), name)
+
+		headers := s.allHeaders()
+		require.Len(t, headers, 1)
+		require.Equal(t, "custom", headers[0].Get("User-Agent"))
+	})
+
+	t.Run("redacts the credentials from the errors", func(t *testing.T) {
+		s := newHeadersServer(t)
+		s.echo = true
+		c, err := New(s.URL, WithHeader("Authorization", "Bearer secret-token"))
+		require.NoError(t, err)
+
+		_, err = c.GetIPNS(context.Background(), name)
+		require.Error(t, err)
+		require.NotContains(t, err.Error(), "secret-token")
+		require.Contains(t, err.Error(), "invalid credentials: "+redacted)
+
+		_, err = c.FindPeers(context.Background(), peerID)
+		require.Error(t, err)
+		require.NotContains(t, err.Error(), "secret-token")
+	})
+}
@@ -18,12 +18,15 @@ var (
 
 	measureLatency = stats.Int64("routing_http_client_latency", "the latency of operations by the routing HTTP client", stats.UnitMilliseconds)
 	measureLength  = stats.Int64("routing_http_client_length", "the number of elements in a response collection", stats.UnitDimensionless)
+	measureInvalid = stats.Int64("routing_http_client_invalid_records", "the number of invalid records in a streamed response", stats.UnitDimensionless)
+	measureCircuit = stats.Int64("routing_http_client_circuit_breaker_state", "the state changes of the circuit breaker of an endpoint", stats.UnitDimensionless)
 
 	keyOperation  = tag.MustNewKey("operation")
 	keyHost       = tag.MustNewKey("host")
 	keyStatusCode = tag.MustNewKey("code")
 	keyError      = tag.MustNewKey("error")
 	keyMediaType  = tag.MustNewKey("mediatype")
+	keyState      = tag.MustNewKey("state")
 
 	ViewLatency = &view.View{
 		Measure:     measureLatency,
@@ -35,10 +38,22 @@ var (
 		Aggregation: distLength,
 		TagKeys:     []tag.Key{keyOperation, keyHost},
 	}
+	ViewInvalidRecords = &view.View{
+		Measure:     measureInvalid,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{keyOperation, keyHost},
+	}
+	ViewCircuitBreakerState = &view.View{
+		Measure:     measureCircuit,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{keyHost, keyState},
+	}
 
 	OpenCensusViews = []*view.View{
 		ViewLatency,
 		ViewLength,
+		ViewInvalidRecords,
+		ViewCircuitBreakerState,
 	}
 )
 
@@ -50,6 +65,7 @@ type measurement struct {
 	statusCode int
 	host       string
 	length     int
+	invalid    int
 }
 
 func (m measurement) record(ctx context.Context) {
@@ -62,6 +78,19 @@ func (m measurement) record(ctx context.Context) {
 	}
 	stats.RecordWithTags(ctx, muts, measureLatency.M(m.latency.Milliseconds()))
 	stats.RecordWithTags(ctx, muts, measureLength.M(int64(m.length)))
+	if m.invalid > 0 {
+		stats.RecordWithTags(ctx, muts, measureInvalid.M(int64(m.invalid)))
+	}
+}
+
+// recordCircuitState records the change of the circuit breaker of host to
+// state.
+func recordCircuitState(ctx context.Context, host string, state circuitState) {
+	muts := []tag.Mutator{
+		tag.Upsert(keyHost, host),
+		tag.Upsert(keyState, state.String()),
+	}
+	stats.RecordWithTags(ctx, muts, measureCircuit.M(1))
 }
 
 func newMeasurement(operation string) *measurement {
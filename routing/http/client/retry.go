@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+const (
+	// DefaultRetryMaxAttempts is the default maximum number of attempts of a
+	// request, including the first one.
+	DefaultRetryMaxAttempts = 3
+
+	// DefaultRetryMaxElapsed is the default maximum time spent on the
+	// attempts of a request.
+	DefaultRetryMaxElapsed = 30 * time.Second
+
+	// DefaultRetryInitialBackoff is the default time waited before the first
+	// retry. It doubles after each attempt.
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+
+	// DefaultRetryMaxBackoff is the default maximum time waited between two
+	// attempts.
+	DefaultRetryMaxBackoff = 5 * time.Second
+
+	// DefaultCircuitBreakerFailures is the default number of consecutive
+	// failures after which the circuit breaker opens.
+	DefaultCircuitBreakerFailures = 5
+
+	// DefaultCircuitBreakerCooldown is the default time after which an open
+	// circuit breaker lets a request through, to probe the endpoint.
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned for the requests rejected by an open circuit
+// breaker, see [WithCircuitBreaker].
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// RetryOptions are the options of the retries of the requests of a [Client],
+// see [WithRetry].
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of attempts of a request, including
+	// the first one.
+	MaxAttempts int
+
+	// MaxElapsed is the maximum time spent on the attempts of a request,
+	// waits included. Zero means no maximum.
+	MaxElapsed time.Duration
+
+	// InitialBackoff is the time waited before the first retry, doubled after
+	// each attempt up to MaxBackoff. The actual waits are randomized between
+	// half and all of it. A Retry-After header overrides it.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// RetryWrites retries the requests which are not idempotent, such as the
+	// provides, too.
+	RetryWrites bool
+}
+
+// DefaultRetryOptions returns the default [RetryOptions].
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts:    DefaultRetryMaxAttempts,
+		MaxElapsed:     DefaultRetryMaxElapsed,
+		InitialBackoff: DefaultRetryInitialBackoff,
+		MaxBackoff:     DefaultRetryMaxBackoff,
+	}
+}
+
+// CircuitBreakerOptions are the options of the circuit breaker of a
+// [Client], see [WithCircuitBreaker].
+type CircuitBreakerOptions struct {
+	// Failures is the number of consecutive failures of an endpoint after
+	// which the breaker opens.
+	Failures int
+
+	// Cooldown is the time after which an open breaker lets a request
+	// through. The breaker closes if it succeeds, and opens again otherwise.
+	Cooldown time.Duration
+}
+
+// DefaultCircuitBreakerOptions returns the default [CircuitBreakerOptions].
+func DefaultCircuitBreakerOptions() CircuitBreakerOptions {
+	return CircuitBreakerOptions{
+		Failures: DefaultCircuitBreakerFailures,
+		Cooldown: DefaultCircuitBreakerCooldown,
+	}
+}
+
+// isRetryable reports whether a request failed with resp or err may succeed
+// if sent again.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// the errors of the context are the caller's
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrCircuitOpen)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryingHTTPClient sends the requests again when they fail with a transport
+// error, a 5xx or a 429 response, with exponential backoff.
+type retryingHTTPClient struct {
+	httpClient
+	clock clock.Clock
+	opts  RetryOptions
+}
+
+func newRetryingHTTPClient(c httpClient, clk clock.Clock, opts RetryOptions) *retryingHTTPClient {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	return &retryingHTTPClient{httpClient: c, clock: clk, opts: opts}
+}
+
+func (c *retryingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+	if !idempotent && (!c.opts.RetryWrites || (req.Body != nil && req.GetBody == nil)) {
+		return c.httpClient.Do(req)
+	}
+
+	start := c.clock.Now()
+	backoff := c.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if attempt >= c.opts.MaxAttempts || !isRetryable(resp, err) {
+			return resp, err
+		}
+
+		// randomized between half and all of the backoff
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), c.clock.Now()); ok {
+				wait = retryAfter
+			}
+		}
+		if c.opts.MaxElapsed > 0 && c.clock.Since(start)+wait > c.opts.MaxElapsed {
+			return resp, err
+		}
+		if resp != nil {
+			// release the connection
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		logger.Debugw("retrying request", "URL", req.URL, "Attempt", attempt, "Wait", wait, "Error", err)
+
+		timer := c.clock.Timer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+
+		backoff *= 2
+		if c.opts.MaxBackoff > 0 && backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, in seconds or as
+// an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(v); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakingHTTPClient fails fast the requests to the endpoints which failed
+// too many times in a row, until a probe request succeeds.
+type breakingHTTPClient struct {
+	httpClient
+	clock clock.Clock
+	opts  CircuitBreakerOptions
+
+	mu        sync.Mutex
+	endpoints map[string]*circuit
+}
+
+type circuit struct {
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreakingHTTPClient(c httpClient, clk clock.Clock, opts CircuitBreakerOptions) *breakingHTTPClient {
+	if opts.Failures <= 0 {
+		opts.Failures = DefaultCircuitBreakerFailures
+	}
+	return &breakingHTTPClient{
+		httpClient: c,
+		clock:      clk,
+		opts:       opts,
+		endpoints:  make(map[string]*circuit),
+	}
+}
+
+func (c *breakingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	endpoint := req.URL.Host
+	if err := c.acquire(req.Context(), endpoint); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	failed := (err != nil && !errors.Is(err, context.Canceled)) || (resp != nil && resp.StatusCode >= http.StatusInternalServerError)
+	c.release(req.Context(), endpoint, failed)
+	return resp, err
+}
+
+// acquire returns [ErrCircuitOpen] if no request may be sent to endpoint.
+func (c *breakingHTTPClient) acquire(ctx context.Context, endpoint string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cb, ok := c.endpoints[endpoint]
+	if !ok {
+		cb = &circuit{}
+		c.endpoints[endpoint] = cb
+	}
+	switch cb.state {
+	case circuitOpen:
+		if c.clock.Since(cb.openedAt) < c.opts.Cooldown {
+			return ErrCircuitOpen
+		}
+		c.setState(ctx, endpoint, cb, circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		// a single probe at a time
+		if cb.probing {
+			return ErrCircuitOpen
+		}
+		cb.probing = true
+	}
+	return nil
+}
+
+func (c *breakingHTTPClient) release(ctx context.Context, endpoint string, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cb := c.endpoints[endpoint]
+	cb.probing = false
+	if !failed {
+		cb.failures = 0
+		if cb.state != circuitClosed {
+			c.setState(ctx, endpoint, cb, circuitClosed)
+		}
+		return
+	}
+
+	cb.failures++
+	if cb.state == circuitHalfOpen || (cb.state == circuitClosed && cb.failures >= c.opts.Failures) {
+		cb.openedAt = c.clock.Now()
+		c.setState(ctx, endpoint, cb, circuitOpen)
+	}
+}
+
+func (c *breakingHTTPClient) setState(ctx context.Context, endpoint string, cb *circuit, state circuitState) {
+	logger.Infow("circuit breaker state change", "Endpoint", endpoint, "From", cb.state, "To", state)
+	cb.state = state
+	recordCircuitState(ctx, endpoint, state)
+}
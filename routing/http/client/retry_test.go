@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+// transportFailure makes a scriptedServer drop the connection.
+const transportFailure = -1
+
+// scriptedServer answers the requests with the scripted status codes, in
+// order, and then with 200 OK and a provider record.
+type scriptedServer struct {
+	*httptest.Server
+	requests atomic.Int64
+
+	mu         sync.Mutex
+	script     []int
+	retryAfter string
+	delay      time.Duration
+}
+
+func newScriptedServer(t *testing.T, script ...int) *scriptedServer {
+	line := marshalLine(t, makePeerRecord())
+	s := &scriptedServer{script: script}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		code := http.StatusOK
+		if len(s.script) > 0 {
+			code, s.script = s.script[0], s.script[1:]
+		}
+		retryAfter, delay := s.retryAfter, s.delay
+		s.mu.Unlock()
+
+		time.Sleep(delay)
+		switch code {
+		case transportFailure:
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+		case http.StatusOK:
+			w.Header().Set("Content-Type", mediaTypeJSON)
+			w.Write([]byte(`{"Providers":[` + line + `]}`))
+		default:
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(code)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func findProvidersErr(c *Client) error {
+	it, err := c.FindProviders(context.Background(), makeCID())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		if err := it.Val().Err; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fastRetries() RetryOptions {
+	opts := DefaultRetryOptions()
+	opts.InitialBackoff = time.Millisecond
+	opts.MaxBackoff = 10 * time.Millisecond
+	return opts
+}
+
+func TestClientRetry(t *testing.T) {
+	t.Run("retries the failed requests until they succeed", func(t *testing.T) {
+		s := newScriptedServer(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+		c, err := New(s.URL, WithRetry(fastRetries()))
+		require.NoError(t, err)
+
+		require.NoError(t, findProvidersErr(c))
+		require.EqualValues(t, 3, s.requests.Load())
+	})
+
+	t.Run("retries the transport errors", func(t *testing.T) {
+		s := newScriptedServer(t, transportFailure, transportFailure)
+		c, err := New(s.URL, WithRetry(fastRetries()))
+		require.NoError(t, err)
+
+		require.NoError(t, findProvidersErr(c))
+		require.EqualValues(t, 3, s.requests.Load())
+	})
+
+	t.Run("gives up after the maximum number of attempts", func(t *testing.T) {
+		s := newScriptedServer(t, http.StatusBadGateway, http.StatusBadGateway)
+		opts := fastRetries()
+		opts.MaxAttempts = 2
+		c, err := New(s.URL, WithRetry(opts))
+		require.NoError(t, err)
+
+		var httpErr *HTTPError
+		require.ErrorAs(t, findProvidersErr(c), &httpErr)
+		require.Equal(t, http.StatusBadGateway, httpErr.StatusCode)
+		require.EqualValues(t, 2, s.requests.Load())
+	})
+
+	t.Run("gives up when the wait exceeds the time budget", func(t *testing.T) {
+		s := newScriptedServer(t, http.StatusTooManyRequests)
+		s.retryAfter = "60"
+		opts := fastRetries()
+		opts.MaxElapsed = time.Second
+		c, err := New(s.URL, WithRetry(opts))
+		require.NoError(t, err)
+
+		start := time.Now()
+		var httpErr *HTTPError
+		require.ErrorAs(t, findProvidersErr(c), &httpErr)
+		require.Equal(t, http.StatusTooManyRequests, httpErr.StatusCode)
+		require.Less(t, time.Since(start), time.Second)
+		require.EqualValues(t, 1, s.requests.Load())
+	})
+
+	t.Run("honors Retry-After", func(t *testing.T) {
+		s := newScriptedServer(t, http.StatusTooManyRequests)
+		s.retryAfter = "1"
+		c, err := New(s.URL, WithRetry(fastRetries()))
+		require.NoError(t, err)
+
+		start := time.Now()
+		require.NoError(t, findProvidersErr(c))
+		require.GreaterOrEqual(t, time.Since(start), time.Second)
+		require.EqualValues(t, 2, s.requests.Load())
+	})
+
+	t.Run("does not retry the client errors", func(t *testing.T) {
+		s := newScriptedServer(t, http.StatusBadRequest)
+		c, err := New(s.URL, WithRetry(fastRetries()))
+		require.NoError(t, err)
+
+		require.Error(t, findProvidersErr(c))
+		require.EqualValues(t, 1, s.requests.Load())
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		s := newScriptedServer(t, http.StatusServiceUnavailable)
+		s.retryAfter = "10"
+		c, err := New(s.URL, WithRetry(fastRetries()))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = c.FindProviders(ctx, makeCID())
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.EqualValues(t, 1, s.requests.Load())
+	})
+
+	t.Run("retries the writes only when enabled", func(t *testing.T) {
+		sk, name := makeName(t)
+		record, _ := makeIPNSRecord(t, sk)
+
+		s := newScriptedServer(t, http.StatusBadGateway)
+		c, err := New(s.URL, WithRetry(fastRetries()))
+		require.NoError(t, err)
+		require.Error(t, c.PutIPNS(context.Background(), name, record))
+		require.EqualValues(t, 1, s.requests.Load())
+
+		s = newScriptedServer(t, http.StatusBadGateway)
+		opts := fastRetries()
+		opts.RetryWrites = true
+		c, err = New(s.URL, WithRetry(opts))
+		require.NoError(t, err)
+		require.NoError(t, c.PutIPNS(context.Background(), name, record))
+		require.EqualValues(t, 2, s.requests.Load())
+	})
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	require.NoError(t, view.Register(ViewCircuitBreakerState))
+	t.Cleanup(func() { view.Unregister(ViewCircuitBreakerState) })
+
+	s := newScriptedServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	s.delay = 100 * time.Millisecond
+	c, err := New(s.URL, WithRetry(fastRetries()), WithCircuitBreaker(CircuitBreakerOptions{Failures: 3, Cooldown: time.Minute}))
+	require.NoError(t, err)
+	clk := clock.NewMock()
+	c.httpClient.(*retryingHTTPClient).httpClient.(*breakingHTTPClient).clock = clk
+
+	// the third attempt opens the breaker
+	var httpErr *HTTPError
+	require.ErrorAs(t, findProvidersErr(c), &httpErr)
+	require.EqualValues(t, 3, s.requests.Load())
+
+	// fails fast while open, without retries
+	start := time.Now()
+	require.ErrorIs(t, findProvidersErr(c), ErrCircuitOpen)
+	require.Less(t, time.Since(start), s.delay)
+	require.EqualValues(t, 3, s.requests.Load())
+
+	// half-open after the cooldown: the failed probe opens it again
+	clk.Add(time.Minute)
+	opts := fastRetries()
+	opts.MaxAttempts = 1
+	c.httpClient.(*retryingHTTPClient).opts = opts
+	require.ErrorAs(t, findProvidersErr(c), &httpErr)
+	require.EqualValues(t, 4, s.requests.Load())
+	require.ErrorIs(t, findProvidersErr(c), ErrCircuitOpen)
+
+	// and the successful one closes it
+	clk.Add(time.Minute)
+	require.NoError(t, findProvidersErr(c))
+	require.NoError(t, findProvidersErr(c))
+	require.EqualValues(t, 6, s.requests.Load())
+
+	rows, err := view.RetrieveData(ViewCircuitBreakerState.Name)
+	require.NoError(t, err)
+	counts := map[string]int64{}
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key == keyState {
+				counts[tag.Value] += row.Data.(*view.CountData).Value
+			}
+		}
+	}
+	require.Equal(t, map[string]int64{"open": 2, "half-open": 2, "closed": 1}, counts)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{value: ""},
+		{value: "bogus"},
+		{value: "3", expected: 3 * time.Second, ok: true},
+		{value: now.Add(time.Minute).Format(http.TimeFormat), expected: time.Minute, ok: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), ok: true},
+	} {
+		d, ok := parseRetryAfter(c.value, now)
+		require.Equal(t, c.ok, ok, c.value)
+		require.Equal(t, c.expected, d, c.value)
+	}
+}
@@ -0,0 +1,431 @@
+// Package composite implements a delegated routing client querying several
+// sources at once, such as several [client.Client] for different endpoints.
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/routing/http/contentrouter"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var logger = logging.Logger("routing/http/composite")
+
+// DefaultTimeout is the default time after which a source is given up.
+const DefaultTimeout = 30 * time.Second
+
+// ErrQuorum is returned when fewer sources than the quorum accepted a write.
+var ErrQuorum = errors.New("not enough sources succeeded")
+
+// Source is a source of a [Client].
+type Source struct {
+	// Name identifies the source in the errors and the metrics.
+	Name string
+
+	Client contentrouter.Client
+
+	// Timeout bounds the requests to this source, including the reading of
+	// the results. Zero means the timeout of the [Client], see [WithTimeout].
+	Timeout time.Duration
+}
+
+// ErrorPolicy tells how a [Client] handles the failures of its sources.
+type ErrorPolicy int
+
+const (
+	// IgnoreErrors ignores the failed sources, unless they all failed.
+	IgnoreErrors ErrorPolicy = iota
+
+	// FailOnError fails the requests when a source fails, and returns the
+	// errors of the sources in the results.
+	FailOnError
+)
+
+// Client is a [contentrouter.Client] sending the requests to all its sources
+// concurrently, and merging their responses.
+type Client struct {
+	sources     []Source
+	timeout     time.Duration
+	limit       int
+	errorPolicy ErrorPolicy
+	quorum      int
+}
+
+var _ contentrouter.Client = &Client{}
+
+type Option func(*Client)
+
+// WithTimeout sets the default timeout of the sources. Defaults to
+// [DefaultTimeout].
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithLimit sets the maximum number of unique records returned by
+// [Client.FindProviders] and [Client.FindPeers]. Once it is reached, the
+// requests still running are canceled. Zero means no limit.
+func WithLimit(limit int) Option {
+	return func(c *Client) {
+		c.limit = limit
+	}
+}
+
+// WithErrorPolicy sets how the failures of the sources are handled. Defaults
+// to [IgnoreErrors].
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return func(c *Client) {
+		c.errorPolicy = policy
+	}
+}
+
+// WithQuorum sets the number of sources which must accept a write, with
+// [Client.ProvideBitswap] or [Client.PutIPNS], for it to succeed. Defaults to
+// one.
+func WithQuorum(quorum int) Option {
+	return func(c *Client) {
+		c.quorum = quorum
+	}
+}
+
+// New creates a [Client] querying sources.
+func New(sources []Source, opts ...Option) (*Client, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no sources")
+	}
+
+	c := &Client{
+		sources: append([]Source(nil), sources...),
+		timeout: DefaultTimeout,
+		quorum:  1,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.quorum < 1 || c.quorum > len(sources) {
+		return nil, fmt.Errorf("quorum of %d for %d sources", c.quorum, len(sources))
+	}
+	for i := range c.sources {
+		if c.sources[i].Name == "" {
+			c.sources[i].Name = fmt.Sprintf("source-%d", i)
+		}
+		if c.sources[i].Timeout == 0 {
+			c.sources[i].Timeout = c.timeout
+		}
+	}
+
+	return c, nil
+}
+
+// FindProviders queries all the sources and merges their records, skipping
+// the ones of a peer and protocol already returned.
+func (c *Client) FindProviders(ctx context.Context, key cid.Cid) (iter.ResultIter[types.Record], error) {
+	return find(ctx, c, "FindProviders", providerKeys, func(ctx context.Context, client contentrouter.Client) (iter.ResultIter[types.Record], error) {
+		return client.FindProviders(ctx, key)
+	})
+}
+
+// FindPeers queries all the sources and merges their records, skipping the
+// ones of a peer and protocol already returned.
+func (c *Client) FindPeers(ctx context.Context, pid peer.ID) (iter.ResultIter[*types.PeerRecord], error) {
+	return find(ctx, c, "FindPeers", func(r *types.PeerRecord) []string { return providerKeys(r) }, func(ctx context.Context, client contentrouter.Client) (iter.ResultIter[*types.PeerRecord], error) {
+		return client.FindPeers(ctx, pid)
+	})
+}
+
+// providerKeys returns the (peer ID, protocol) pairs of r. The records without
+// peer ID have no keys, and are never considered duplicates.
+func providerKeys(r types.Record) []string {
+	switch r := r.(type) {
+	case *types.PeerRecord:
+		if r.ID == nil {
+			return nil
+		}
+		if len(r.Protocols) == 0 {
+			return []string{r.ID.String()}
+		}
+		keys := make([]string, len(r.Protocols))
+		for i, proto := range r.Protocols {
+			keys[i] = r.ID.String() + "/" + proto
+		}
+		return keys
+	//lint:ignore SA1019 // ignore staticcheck
+	case *types.BitswapRecord:
+		if r.ID == nil {
+			return nil
+		}
+		return []string{r.ID.String() + "/" + r.Protocol}
+	default:
+		return nil
+	}
+}
+
+type findFunc[T any] func(ctx context.Context, client contentrouter.Client) (iter.ResultIter[T], error)
+
+// find runs query against all the sources, and returns once a source
+// responded, or they all failed.
+func find[T any](ctx context.Context, c *Client, operation string, keys func(T) []string, query findFunc[T]) (iter.ResultIter[T], error) {
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan iter.Result[T])
+	started := make(chan error, len(c.sources))
+
+	var wg sync.WaitGroup
+	for _, src := range c.sources {
+		src := src
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := newMeasurement(operation, src.Name)
+			defer func() {
+				m.latency = time.Since(m.start)
+				m.record(ctx)
+			}()
+
+			sctx, scancel := context.WithTimeout(ctx, src.Timeout)
+			defer scancel()
+
+			it, err := query(sctx, src.Client)
+			if err != nil {
+				m.err = err
+				started <- fmt.Errorf("%s: %w", src.Name, err)
+				return
+			}
+			defer it.Close()
+			started <- nil
+
+			for it.Next() {
+				res := it.Val()
+				if res.Err != nil {
+					if c.errorPolicy == IgnoreErrors {
+						logger.Debugw("ignoring failed result", "Source", src.Name, "Error", res.Err)
+						m.err = res.Err
+						continue
+					}
+					res.Err = fmt.Errorf("%s: %w", src.Name, res.Err)
+				} else {
+					m.length++
+				}
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	var errs []error
+	for range c.sources {
+		err := <-started
+		if err == nil {
+			if c.errorPolicy == IgnoreErrors {
+				break
+			}
+			continue
+		}
+		if c.errorPolicy == FailOnError {
+			cancel()
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == len(c.sources) {
+		cancel()
+		return nil, errors.Join(errs...)
+	}
+
+	return &mergeIter[T]{
+		ch:     out,
+		cancel: cancel,
+		keys:   keys,
+		seen:   make(map[string]struct{}),
+		limit:  c.limit,
+	}, nil
+}
+
+// mergeIter reads the merged results of the sources, without duplicates.
+type mergeIter[T any] struct {
+	ch     <-chan iter.Result[T]
+	cancel context.CancelFunc
+	keys   func(T) []string
+	seen   map[string]struct{}
+	limit  int
+	count  int
+	val    iter.Result[T]
+	done   bool
+}
+
+func (it *mergeIter[T]) Next() bool {
+	for !it.done {
+		if it.limit > 0 && it.count >= it.limit {
+			// cancel the sources still running
+			it.Close()
+			return false
+		}
+
+		res, ok := <-it.ch
+		if !ok {
+			it.Close()
+			return false
+		}
+		if res.Err == nil && !it.isNew(res.Val) {
+			continue
+		}
+
+		it.count++
+		it.val = res
+		return true
+	}
+	return false
+}
+
+// isNew tells whether a key of v was not seen yet, and marks them seen.
+func (it *mergeIter[T]) isNew(v T) bool {
+	keys := it.keys(v)
+	if len(keys) == 0 {
+		return true
+	}
+	isNew := false
+	for _, key := range keys {
+		if _, ok := it.seen[key]; !ok {
+			it.seen[key] = struct{}{}
+			isNew = true
+		}
+	}
+	return isNew
+}
+
+func (it *mergeIter[T]) Val() iter.Result[T] {
+	return it.val
+}
+
+func (it *mergeIter[T]) Close() error {
+	if !it.done {
+		it.done = true
+		it.cancel()
+	}
+	return nil
+}
+
+// GetIPNS queries all the sources, and returns the record with the highest
+// sequence number.
+func (c *Client) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	var mu sync.Mutex
+	var best *ipns.Record
+	var bestSeq uint64
+	_, errs := c.fanOut(ctx, "GetIPNS", func(ctx context.Context, client contentrouter.Client) error {
+		record, err := client.GetIPNS(ctx, name)
+		if err != nil {
+			return err
+		}
+		seq, err := record.Sequence()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if best == nil || seq > bestSeq {
+			best, bestSeq = record, seq
+		}
+		return nil
+	})
+	if best == nil || (c.errorPolicy == FailOnError && len(errs) > 0) {
+		return nil, errors.Join(errs...)
+	}
+	return best, nil
+}
+
+// PutIPNS puts record to all the sources, and succeeds if the quorum of them
+// did, see [WithQuorum].
+func (c *Client) PutIPNS(ctx context.Context, name ipns.Name, record *ipns.Record) error {
+	succeeded, errs := c.fanOut(ctx, "PutIPNS", func(ctx context.Context, client contentrouter.Client) error {
+		return client.PutIPNS(ctx, name, record)
+	})
+	return c.checkQuorum(succeeded, errs)
+}
+
+// ProvideBitswap provides keys to all the sources, and succeeds if the quorum
+// of them did, see [WithQuorum]. It returns the smallest advisory TTL of
+// the sources.
+//
+// Deprecated: protocol-agnostic provide is being worked on in [IPIP-378]:
+//
+// [IPIP-378]: https://github.com/ipfs/specs/pull/378
+func (c *Client) ProvideBitswap(ctx context.Context, keys []cid.Cid, ttl time.Duration) (time.Duration, error) {
+	var mu sync.Mutex
+	var minTTL time.Duration
+	succeeded, errs := c.fanOut(ctx, "ProvideBitswap", func(ctx context.Context, client contentrouter.Client) error {
+		advisoryTTL, err := client.ProvideBitswap(ctx, keys, ttl)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if minTTL == 0 || advisoryTTL < minTTL {
+			minTTL = advisoryTTL
+		}
+		return nil
+	})
+	if err := c.checkQuorum(succeeded, errs); err != nil {
+		return 0, err
+	}
+	return minTTL, nil
+}
+
+func (c *Client) checkQuorum(succeeded int, errs []error) error {
+	if succeeded < c.quorum {
+		return fmt.Errorf("%w: %d of %d, with a quorum of %d: %w", ErrQuorum, succeeded, len(c.sources), c.quorum, errors.Join(errs...))
+	}
+	if c.errorPolicy == FailOnError && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// fanOut runs f against all the sources concurrently, and returns once they
+// all finished.
+func (c *Client) fanOut(ctx context.Context, operation string, f func(ctx context.Context, client contentrouter.Client) error) (int, []error) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(c.sources))
+	for i, src := range c.sources {
+		i, src := i, src
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := newMeasurement(operation, src.Name)
+			sctx, cancel := context.WithTimeout(ctx, src.Timeout)
+			defer cancel()
+
+			m.err = f(sctx, src.Client)
+			m.latency = time.Since(m.start)
+			m.record(ctx)
+			if m.err != nil {
+				errs[i] = fmt.Errorf("%s: %w", src.Name, m.err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return len(c.sources) - len(failed), failed
+}
@@ -0,0 +1,290 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource returns its records one every delay, then blocks until canceled
+// when it is endless. It counts the records read and the canceled queries.
+type fakeSource struct {
+	records  []types.Record
+	delay    time.Duration
+	endless  bool
+	err      error
+	ttl      time.Duration
+	record   *ipns.Record
+	read     atomic.Int64
+	canceled atomic.Int64
+	puts     atomic.Int64
+}
+
+type fakeIter struct {
+	ctx   context.Context
+	s     *fakeSource
+	i     int
+	val   iter.Result[types.Record]
+	close func()
+}
+
+func (it *fakeIter) Next() bool {
+	if it.i >= len(it.s.records) && !it.s.endless {
+		return false
+	}
+	select {
+	case <-time.After(it.s.delay):
+	case <-it.ctx.Done():
+		it.s.canceled.Add(1)
+		return false
+	}
+	if it.i >= len(it.s.records) {
+		<-it.ctx.Done()
+		it.s.canceled.Add(1)
+		return false
+	}
+	it.val = iter.Result[types.Record]{Val: it.s.records[it.i]}
+	it.i++
+	it.s.read.Add(1)
+	return true
+}
+
+func (it *fakeIter) Val() iter.Result[types.Record] { return it.val }
+func (it *fakeIter) Close() error                   { return nil }
+
+func (s *fakeSource) FindProviders(ctx context.Context, key cid.Cid) (iter.ResultIter[types.Record], error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &fakeIter{ctx: ctx, s: s}, nil
+}
+
+func (s *fakeSource) ProvideBitswap(ctx context.Context, keys []cid.Cid, ttl time.Duration) (time.Duration, error) {
+	s.puts.Add(1)
+	return s.ttl, s.err
+}
+
+func (s *fakeSource) FindPeers(ctx context.Context, pid peer.ID) (iter.ResultIter[*types.PeerRecord], error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var records []*types.PeerRecord
+	for _, r := range s.records {
+		records = append(records, r.(*types.PeerRecord))
+	}
+	return iter.ToResultIter[*types.PeerRecord](iter.FromSlice(records)), nil
+}
+
+func (s *fakeSource) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.record, nil
+}
+
+func (s *fakeSource) PutIPNS(ctx context.Context, name ipns.Name, record *ipns.Record) error {
+	s.puts.Add(1)
+	return s.err
+}
+
+func makeCID(t *testing.T) cid.Cid {
+	mh, err := multihash.Sum([]byte(t.Name()), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func makePeerRecord(t *testing.T, protocols ...string) *types.PeerRecord {
+	_, pk, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+	return &types.PeerRecord{Schema: types.SchemaPeer, ID: &pid, Protocols: protocols}
+}
+
+func sources(clients ...*fakeSource) []Source {
+	srcs := make([]Source, len(clients))
+	for i, c := range clients {
+		srcs[i] = Source{Client: c}
+	}
+	return srcs
+}
+
+func TestFindProvidersDeduplication(t *testing.T) {
+	a := makePeerRecord(t, "transport-bitswap")
+	b := makePeerRecord(t, "transport-bitswap", "transport-ipfs-gateway-http")
+	bGateway := &types.PeerRecord{Schema: types.SchemaPeer, ID: b.ID, Protocols: []string{"transport-ipfs-gateway-http"}}
+	bGraphsync := &types.PeerRecord{Schema: types.SchemaPeer, ID: b.ID, Protocols: []string{"transport-graphsync-filecoinv1"}}
+	//lint:ignore SA1019 // ignore staticcheck
+	aBitswap := &types.BitswapRecord{Schema: types.SchemaBitswap, Protocol: "transport-bitswap", ID: a.ID}
+	unknown := &types.UnknownRecord{Schema: "other"}
+
+	c, err := New(sources(
+		&fakeSource{records: []types.Record{a, b, unknown}},
+		// answers after the first source
+		&fakeSource{records: []types.Record{bGateway, aBitswap, bGraphsync, unknown}, delay: 50 * time.Millisecond},
+	))
+	require.NoError(t, err)
+
+	it, err := c.FindProviders(context.Background(), makeCID(t))
+	require.NoError(t, err)
+	var records []types.Record
+	for _, res := range iter.ReadAll[iter.Result[types.Record]](it) {
+		require.NoError(t, res.Err)
+		records = append(records, res.Val)
+	}
+	require.ElementsMatch(t, []types.Record{a, b, bGraphsync, unknown, unknown}, records)
+}
+
+func TestFindProvidersPartialFailure(t *testing.T) {
+	record := makePeerRecord(t, "transport-bitswap")
+	failing := &fakeSource{err: errors.New("unreachable")}
+	working := &fakeSource{records: []types.Record{record}, delay: 10 * time.Millisecond}
+
+	t.Run("ignores the failed sources", func(t *testing.T) {
+		c, err := New(sources(failing, working))
+		require.NoError(t, err)
+
+		it, err := c.FindProviders(context.Background(), makeCID(t))
+		require.NoError(t, err)
+		require.Equal(t, []iter.Result[types.Record]{{Val: record}}, iter.ReadAll[iter.Result[types.Record]](it))
+	})
+
+	t.Run("fails when all the sources failed", func(t *testing.T) {
+		c, err := New(sources(failing, &fakeSource{err: errors.New("down")}))
+		require.NoError(t, err)
+
+		_, err = c.FindProviders(context.Background(), makeCID(t))
+		require.ErrorContains(t, err, "unreachable")
+		require.ErrorContains(t, err, "down")
+	})
+
+	t.Run("fails on error with FailOnError", func(t *testing.T) {
+		c, err := New(sources(failing, working), WithErrorPolicy(FailOnError))
+		require.NoError(t, err)
+
+		_, err = c.FindProviders(context.Background(), makeCID(t))
+		require.ErrorContains(t, err, "source-0: unreachable")
+	})
+
+	t.Run("times out a slow source", func(t *testing.T) {
+		slow := &fakeSource{records: []types.Record{makePeerRecord(t)}, delay: time.Hour}
+		c, err := New([]Source{{Client: slow, Timeout: 50 * time.Millisecond}, {Client: working}})
+		require.NoError(t, err)
+
+		start := time.Now()
+		it, err := c.FindProviders(context.Background(), makeCID(t))
+		require.NoError(t, err)
+		require.Equal(t, []iter.Result[types.Record]{{Val: record}}, iter.ReadAll[iter.Result[types.Record]](it))
+		require.Less(t, time.Since(start), 5*time.Second)
+		require.EqualValues(t, 1, slow.canceled.Load())
+	})
+}
+
+func TestFindProvidersEarlyCompletion(t *testing.T) {
+	fast := &fakeSource{delay: time.Millisecond}
+	for i := 0; i < 3; i++ {
+		fast.records = append(fast.records, makePeerRecord(t, "transport-bitswap"))
+	}
+	// never ends on its own
+	straggler := &fakeSource{records: []types.Record{makePeerRecord(t)}, delay: time.Hour, endless: true}
+
+	c, err := New(sources(fast, straggler), WithLimit(2))
+	require.NoError(t, err)
+
+	start := time.Now()
+	it, err := c.FindProviders(context.Background(), makeCID(t))
+	require.NoError(t, err)
+	require.Len(t, iter.ReadAll[iter.Result[types.Record]](it), 2)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// the straggler is canceled
+	require.Eventually(t, func() bool { return straggler.canceled.Load() == 1 }, 5*time.Second, time.Millisecond)
+	require.LessOrEqual(t, fast.read.Load(), int64(3))
+}
+
+func TestFindPeers(t *testing.T) {
+	record := makePeerRecord(t, "transport-bitswap")
+	c, err := New(sources(
+		&fakeSource{records: []types.Record{record}},
+		&fakeSource{records: []types.Record{record}},
+	))
+	require.NoError(t, err)
+
+	it, err := c.FindPeers(context.Background(), *record.ID)
+	require.NoError(t, err)
+	require.Equal(t, []iter.Result[*types.PeerRecord]{{Val: record}}, iter.ReadAll[iter.Result[*types.PeerRecord]](it))
+}
+
+func TestWriteQuorum(t *testing.T) {
+	sk, pk, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+	record, err := ipns.NewRecord(sk, path.FromCid(makeCID(t)), 1, time.Now().Add(time.Hour), 0)
+	require.NoError(t, err)
+
+	failing := func() *fakeSource { return &fakeSource{err: errors.New("refused")} }
+	working := func(ttl time.Duration) *fakeSource { return &fakeSource{ttl: ttl} }
+
+	t.Run("succeeds with the quorum", func(t *testing.T) {
+		srcs := []*fakeSource{working(time.Hour), failing(), working(time.Minute)}
+		c, err := New(sources(srcs...), WithQuorum(2))
+		require.NoError(t, err)
+
+		require.NoError(t, c.PutIPNS(context.Background(), name, record))
+		ttl, err := c.ProvideBitswap(context.Background(), []cid.Cid{makeCID(t)}, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, ttl)
+		for _, src := range srcs {
+			require.EqualValues(t, 2, src.puts.Load(), "the writes go to all the sources")
+		}
+	})
+
+	t.Run("fails without the quorum", func(t *testing.T) {
+		c, err := New(sources(working(time.Hour), failing(), failing()), WithQuorum(2))
+		require.NoError(t, err)
+
+		err = c.PutIPNS(context.Background(), name, record)
+		require.ErrorIs(t, err, ErrQuorum)
+		require.ErrorContains(t, err, "refused")
+		_, err = c.ProvideBitswap(context.Background(), []cid.Cid{makeCID(t)}, time.Hour)
+		require.ErrorIs(t, err, ErrQuorum)
+	})
+
+	t.Run("rejects an impossible quorum", func(t *testing.T) {
+		_, err := New(sources(working(0)), WithQuorum(2))
+		require.Error(t, err)
+	})
+}
+
+func TestGetIPNS(t *testing.T) {
+	sk, pk, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+	value := path.FromCid(makeCID(t))
+	old, err := ipns.NewRecord(sk, value, 1, time.Now().Add(time.Hour), 0)
+	require.NoError(t, err)
+	latest, err := ipns.NewRecord(sk, value, 2, time.Now().Add(time.Hour), 0)
+	require.NoError(t, err)
+
+	c, err := New(sources(&fakeSource{record: old}, &fakeSource{record: latest}, &fakeSource{err: errors.New("down")}))
+	require.NoError(t, err)
+
+	record, err := c.GetIPNS(context.Background(), ipns.NameFromPeer(pid))
+	require.NoError(t, err)
+	require.Equal(t, latest, record)
+}
@@ -0,0 +1,80 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	distMS     = view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000)
+	distLength = view.Distribution(0, 1, 2, 5, 10, 11, 12, 15, 20, 50, 100, 200, 500)
+
+	measureLatency = stats.Int64("routing_http_composite_latency", "the latency of operations by a source of the composite routing client", stats.UnitMilliseconds)
+	measureLength  = stats.Int64("routing_http_composite_length", "the number of records returned by a source of the composite routing client", stats.UnitDimensionless)
+
+	keyOperation = tag.MustNewKey("operation")
+	keySource    = tag.MustNewKey("source")
+	keyError     = tag.MustNewKey("error")
+
+	ViewLatency = &view.View{
+		Measure:     measureLatency,
+		Aggregation: distMS,
+		TagKeys:     []tag.Key{keyOperation, keySource, keyError},
+	}
+	ViewLength = &view.View{
+		Measure:     measureLength,
+		Aggregation: distLength,
+		TagKeys:     []tag.Key{keyOperation, keySource},
+	}
+
+	OpenCensusViews = []*view.View{
+		ViewLatency,
+		ViewLength,
+	}
+)
+
+type measurement struct {
+	operation string
+	source    string
+	start     time.Time
+	latency   time.Duration
+	length    int
+	err       error
+}
+
+func newMeasurement(operation, source string) *measurement {
+	return &measurement{
+		operation: operation,
+		source:    source,
+		start:     time.Now(),
+	}
+}
+
+func (m *measurement) record(ctx context.Context) {
+	muts := []tag.Mutator{
+		tag.Upsert(keyOperation, m.operation),
+		tag.Upsert(keySource, m.source),
+		tag.Upsert(keyError, metricsErrStr(m.err)),
+	}
+	stats.RecordWithTags(ctx, muts, measureLatency.M(m.latency.Milliseconds()))
+	stats.RecordWithTags(ctx, muts, measureLength.M(int64(m.length)))
+}
+
+// metricsErrStr converts an error into a string that can be used as a metric label.
+func metricsErrStr(err error) string {
+	switch {
+	case err == nil:
+		return "None"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "DeadlineExceeded"
+	case errors.Is(err, context.Canceled):
+		return "Canceled"
+	default:
+		return "Other"
+	}
+}
@@ -2,6 +2,7 @@ package contentrouter
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"strings"
 	"time"
@@ -239,6 +240,10 @@ func (c *contentRouter) GetValue(ctx context.Context, key string, opts ...routin
 	}
 
 	record, err := c.client.GetIPNS(ctx, name)
+	if errors.Is(err, routing.ErrNotFound) {
+		// callers compare with the sentinel error
+		return nil, routing.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRequestBodySize is the default maximum size of the body of the
+// write requests, see [WithMaxRequestBodySize].
+const DefaultMaxRequestBodySize = 1 << 20
+
+var errTooManyRequests = errors.New("too many requests")
+
+// Limiter limits the requests of the clients, see [WithLimiter].
+// Implementations must be safe for concurrent use.
+type Limiter interface {
+	// Allow reports whether a request of the client identified by key to
+	// route may be served. When it may not, retryAfter tells the client when
+	// to retry, zero meaning no advice.
+	Allow(route, key string) (ok bool, retryAfter time.Duration)
+}
+
+// WithLimiter sets the [Limiter] of the requests. The clients are identified
+// with [ClientKey], unless set otherwise with [WithLimiterKey]. The requests
+// it does not allow are answered with 429 Too Many Requests.
+func WithLimiter(limiter Limiter) Option {
+	return func(s *server) {
+		s.limiter = limiter
+	}
+}
+
+// WithLimiterKey sets how the clients given to the [Limiter] are identified.
+// Defaults to [ClientKey].
+func WithLimiterKey(key func(r *http.Request) string) Option {
+	return func(s *server) {
+		s.limiterKey = key
+	}
+}
+
+// WithMaxInFlight sets the maximum number of requests served at the same time
+// per route. The requests above it are answered with 429 Too Many Requests.
+// Zero, the default, means no maximum.
+func WithMaxInFlight(max int) Option {
+	return func(s *server) {
+		s.maxInFlight = max
+	}
+}
+
+// WithMaxRequestBodySize sets the maximum size of the body of the write
+// requests, above which they are rejected with 413 Request Entity Too Large.
+// Default is [DefaultMaxRequestBodySize].
+func WithMaxRequestBodySize(size int64) Option {
+	return func(s *server) {
+		s.maxRequestBodySize = size
+	}
+}
+
+// ClientKey identifies the client of r by its bearer token, if it has one,
+// or else by its IP address.
+func ClientKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return "token:" + token
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// guard applies the limits of s to the requests to the route served by h.
+func (s *server) guard(route string, h http.HandlerFunc) http.HandlerFunc {
+	var inFlight chan struct{}
+	if s.maxInFlight > 0 {
+		inFlight = make(chan struct{}, s.maxInFlight)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter != nil {
+			key := s.limiterKey
+			if key == nil {
+				key = ClientKey
+			}
+			if ok, retryAfter := s.limiter.Allow(route, key(r)); !ok {
+				if retryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				}
+				writeErr(w, route, http.StatusTooManyRequests, errTooManyRequests)
+				return
+			}
+		}
+
+		if inFlight != nil {
+			select {
+			case inFlight <- struct{}{}:
+				defer func() { <-inFlight }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				writeErr(w, route, http.StatusTooManyRequests, fmt.Errorf("%w in flight", errTooManyRequests))
+				return
+			}
+		}
+
+		if r.Method == http.MethodPut && s.maxRequestBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.maxRequestBodySize)
+		}
+
+		h(w, r)
+	}
+}
+
+// bodyErrStatus returns the status code of the error of the reading of a
+// request body.
+func bodyErrStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// requestLimit returns the limit of the results of r: the one of its limit
+// query parameter, if any, clamped to max, which may be 0 for no limit.
+func requestLimit(r *http.Request, max int) (int, error) {
+	param := r.URL.Query().Get("limit")
+	if param == "" {
+		return max, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit %q", param)
+	}
+	if limit == 0 || (max > 0 && limit > max) {
+		return max, nil
+	}
+	return limit, nil
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// budgetLimiter allows budget requests per client.
+type budgetLimiter struct {
+	mu     sync.Mutex
+	budget int
+	used   map[string]int
+}
+
+func (l *budgetLimiter) Allow(route, key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.used[key] >= l.budget {
+		return false, 1500 * time.Millisecond
+	}
+	l.used[key]++
+	return true, 0
+}
+
+func TestGuards(t *testing.T) {
+	cid, err := cid.Decode("bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4")
+	require.NoError(t, err)
+	noRecords := func() iter.ResultIter[types.Record] {
+		return iter.FromSlice([]iter.Result[types.Record]{})
+	}
+	get := func(t *testing.T, url string, header http.Header) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("clamps the limit of the requests", func(t *testing.T) {
+		router := &mockContentRouter{}
+		server := httptest.NewServer(Handler(router, WithRecordsLimit(10)))
+		t.Cleanup(server.Close)
+		router.On("FindProviders", mock.Anything, cid, FindOptions{Limit: 10}).Return(noRecords(), nil).Twice()
+		router.On("FindProviders", mock.Anything, cid, FindOptions{Limit: 5}).Return(noRecords(), nil).Once()
+
+		for _, limit := range []string{"1000", "", "5"} {
+			resp := get(t, server.URL+"/routing/v1/providers/"+cid.String()+"?limit="+limit, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+		resp := get(t, server.URL+"/routing/v1/providers/"+cid.String()+"?limit=-1", nil)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		router.AssertExpectations(t)
+	})
+
+	t.Run("rejects oversized bodies with 413", func(t *testing.T) {
+		router := &mockContentRouter{}
+		server := httptest.NewServer(Handler(router, WithMaxRequestBodySize(1024)))
+		t.Cleanup(server.Close)
+		_, name := makeName(t)
+
+		for _, path := range []string{"/routing/v1/providers/", "/routing/v1/ipns/" + name.String()} {
+			body := `{"Providers":"` + strings.Repeat("a", 2048) + `"}`
+			req, err := http.NewRequest(http.MethodPut, server.URL+path, strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", mediaTypeIPNSRecord)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, path)
+		}
+	})
+
+	t.Run("rejects the requests over the budget of a client with 429", func(t *testing.T) {
+		router := &mockContentRouter{}
+		limiter := &budgetLimiter{budget: 2, used: make(map[string]int)}
+		server := httptest.NewServer(Handler(router, WithLimiter(limiter)))
+		t.Cleanup(server.Close)
+		router.On("FindProviders", mock.Anything, cid, mock.Anything).Return(noRecords(), nil)
+
+		url := server.URL + "/routing/v1/providers/" + cid.String()
+		burst := http.Header{"Authorization": []string{"Bearer burst"}}
+		var statuses []int
+		for i := 0; i < 5; i++ {
+			statuses = append(statuses, get(t, url, burst).StatusCode)
+		}
+		require.Equal(t, []int{200, 200, 429, 429, 429}, statuses)
+		resp := get(t, url, burst)
+		require.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+		// the other clients are within their budget
+		require.Equal(t, http.StatusOK, get(t, url, http.Header{"Authorization": []string{"Bearer other"}}).StatusCode)
+		require.Equal(t, http.StatusOK, get(t, url, nil).StatusCode)
+	})
+
+	t.Run("caps the requests in flight per route", func(t *testing.T) {
+		router := &mockContentRouter{}
+		server := httptest.NewServer(Handler(router, WithMaxInFlight(2)))
+		t.Cleanup(server.Close)
+
+		release := make(chan struct{})
+		var started sync.WaitGroup
+		started.Add(2)
+		for i := 0; i < 2; i++ {
+			router.On("FindProviders", mock.Anything, cid, mock.Anything).Run(func(mock.Arguments) {
+				started.Done()
+				<-release
+			}).Return(noRecords(), nil).Once()
+		}
+		_, pid := makePeerID(t)
+		router.On("FindPeers", mock.Anything, pid, mock.Anything).Return(iter.FromSlice([]iter.Result[*types.PeerRecord]{}), nil)
+
+		url := server.URL + "/routing/v1/providers/" + cid.String()
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := http.Get(url)
+				if err == nil {
+					resp.Body.Close()
+				}
+			}()
+		}
+		started.Wait()
+
+		resp := get(t, url, nil)
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.Equal(t, "1", resp.Header.Get("Retry-After"))
+		// the other routes are not held
+		resp = get(t, server.URL+"/routing/v1/peers/"+pid.String(), nil)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		close(release)
+		wg.Wait()
+		router.On("FindProviders", mock.Anything, cid, mock.Anything).Return(noRecords(), nil)
+		require.Equal(t, http.StatusOK, get(t, url, nil).StatusCode)
+	})
+}
+
+func TestClientKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	require.Equal(t, "ip:192.0.2.1", ClientKey(r))
+	r.Header.Set("Authorization", "Bearer secret")
+	require.Equal(t, "token:secret", ClientKey(r))
+	require.False(t, strings.Contains(ClientKey(httptest.NewRequest(http.MethodGet, "/", nil)), ":1234"))
+}
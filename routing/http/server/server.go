@@ -22,6 +22,7 @@ import (
 	jsontypes "github.com/ipfs/boxo/routing/http/types/json"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/multiformats/go-multiaddr"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -39,11 +40,17 @@ const (
 
 var logger = logging.Logger("routing/http/server")
 
+var errNoPeers = errors.New("no peers found")
+
 const (
 	providePath       = "/routing/v1/providers/"
 	findProvidersPath = "/routing/v1/providers/{cid}"
 	findPeersPath     = "/routing/v1/peers/{peer-id}"
 	GetIPNSPath       = "/routing/v1/ipns/{cid}"
+
+	// continuationHeader echoes the continuation token of the next page of
+	// results, see [ProvidersPager].
+	continuationHeader = "Routing-Continuation"
 )
 
 type FindProvidersAsyncResponse struct {
@@ -51,10 +58,24 @@ type FindProvidersAsyncResponse struct {
 	Error            error
 }
 
+// FindOptions are the options of [ContentRouter.FindProviders] and
+// [ContentRouter.FindPeers].
+type FindOptions struct {
+	// Limit indicates the maximum amount of results to return; 0 means unbounded.
+	Limit int
+
+	// FilterAddrs and FilterProtocols are the filters of the request, as
+	// defined by [IPIP-484]. They may be ignored: the server applies them to
+	// the results anyway, see [types.FilterRecord].
+	//
+	// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+	FilterAddrs     []string
+	FilterProtocols []string
+}
+
 type ContentRouter interface {
 	// FindProviders searches for peers who are able to provide the given [cid.Cid].
-	// Limit indicates the maximum amount of results to return; 0 means unbounded.
-	FindProviders(ctx context.Context, cid cid.Cid, limit int) (iter.ResultIter[types.Record], error)
+	FindProviders(ctx context.Context, cid cid.Cid, opts FindOptions) (iter.ResultIter[types.Record], error)
 
 	// Deprecated: protocol-agnostic provide is being worked on in [IPIP-378]:
 	//
@@ -62,8 +83,7 @@ type ContentRouter interface {
 	ProvideBitswap(ctx context.Context, req *BitswapWriteProvideRequest) (time.Duration, error)
 
 	// FindPeers searches for peers who have the provided [peer.ID].
-	// Limit indicates the maximum amount of results to return; 0 means unbounded.
-	FindPeers(ctx context.Context, pid peer.ID, limit int) (iter.ResultIter[*types.PeerRecord], error)
+	FindPeers(ctx context.Context, pid peer.ID, opts FindOptions) (iter.ResultIter[*types.PeerRecord], error)
 
 	// GetIPNS searches for an [ipns.Record] for the given [ipns.Name].
 	GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error)
@@ -73,6 +93,22 @@ type ContentRouter interface {
 	PutIPNS(ctx context.Context, name ipns.Name, record *ipns.Record) error
 }
 
+// ProvidersPager is implemented by the [ContentRouter]s which can return the
+// providers of a CID by pages, for the CIDs with more providers than fit in a
+// response. The server uses it instead of [ContentRouter.FindProviders].
+//
+// The continuation tokens are opaque to the server and the clients: the
+// server returns the one of the next page in the Continuation field of the
+// JSON responses and in the Routing-Continuation header, and the clients
+// give it back in the continuation query parameter to get that page.
+type ProvidersPager interface {
+	// FindProvidersPage returns the page of at most opts.Limit providers of
+	// cid starting at the continuation token, which is empty for the first
+	// page. It also returns the token of the next page, or an empty one if
+	// it is the last page.
+	FindProvidersPage(ctx context.Context, cid cid.Cid, opts FindOptions, continuation string) (providers iter.ResultIter[types.Record], next string, err error)
+}
+
 // Deprecated: protocol-agnostic provide is being worked on in [IPIP-378]:
 //
 // [IPIP-378]: https://github.com/ipfs/specs/pull/378
@@ -104,6 +140,7 @@ func WithStreamingResultsDisabled() Option {
 
 // WithRecordsLimit sets a limit that will be passed to [ContentRouter.FindProviders]
 // and [ContentRouter.FindPeers] for non-streaming requests (application/json).
+// It is the maximum of the limit query parameter of the requests.
 // Default is [DefaultRecordsLimit].
 func WithRecordsLimit(limit int) Option {
 	return func(s *server) {
@@ -113,6 +150,7 @@ func WithRecordsLimit(limit int) Option {
 
 // WithStreamingRecordsLimit sets a limit that will be passed to [ContentRouter.FindProviders]
 // and [ContentRouter.FindPeers] for streaming requests (application/x-ndjson).
+// It is the maximum of the limit query parameter of the requests.
 // Default is [DefaultStreamingRecordsLimit].
 func WithStreamingRecordsLimit(limit int) Option {
 	return func(s *server) {
@@ -125,6 +163,7 @@ func Handler(svc ContentRouter, opts ...Option) http.Handler {
 		svc:                   svc,
 		recordsLimit:          DefaultRecordsLimit,
 		streamingRecordsLimit: DefaultStreamingRecordsLimit,
+		maxRequestBodySize:    DefaultMaxRequestBodySize,
 	}
 
 	for _, opt := range opts {
@@ -132,11 +171,11 @@ func Handler(svc ContentRouter, opts ...Option) http.Handler {
 	}
 
 	r := mux.NewRouter()
-	r.HandleFunc(findProvidersPath, server.findProviders).Methods(http.MethodGet)
-	r.HandleFunc(providePath, server.provide).Methods(http.MethodPut)
-	r.HandleFunc(findPeersPath, server.findPeers).Methods(http.MethodGet)
-	r.HandleFunc(GetIPNSPath, server.GetIPNS).Methods(http.MethodGet)
-	r.HandleFunc(GetIPNSPath, server.PutIPNS).Methods(http.MethodPut)
+	r.HandleFunc(findProvidersPath, server.guard("FindProviders", server.findProviders)).Methods(http.MethodGet)
+	r.HandleFunc(providePath, server.guard("Provide", server.provide)).Methods(http.MethodPut)
+	r.HandleFunc(findPeersPath, server.guard("FindPeers", server.findPeers)).Methods(http.MethodGet)
+	r.HandleFunc(GetIPNSPath, server.guard("GetIPNS", server.GetIPNS)).Methods(http.MethodGet)
+	r.HandleFunc(GetIPNSPath, server.guard("PutIPNS", server.PutIPNS)).Methods(http.MethodPut)
 	return r
 }
 
@@ -145,6 +184,10 @@ type server struct {
 	disableNDJSON         bool
 	recordsLimit          int
 	streamingRecordsLimit int
+	maxRequestBodySize    int64
+	maxInFlight           int
+	limiter               Limiter
+	limiterKey            func(r *http.Request) string
 }
 
 func (s *server) detectResponseType(r *http.Request) (string, error) {
@@ -200,7 +243,7 @@ func (s *server) findProviders(w http.ResponseWriter, httpReq *http.Request) {
 	}
 
 	var (
-		handlerFunc  func(w http.ResponseWriter, provIter iter.ResultIter[types.Record])
+		handlerFunc  func(w http.ResponseWriter, provIter iter.ResultIter[types.Record], next string)
 		recordsLimit int
 	)
 
@@ -212,16 +255,35 @@ func (s *server) findProviders(w http.ResponseWriter, httpReq *http.Request) {
 		recordsLimit = s.recordsLimit
 	}
 
-	provIter, err := s.svc.FindProviders(httpReq.Context(), cid, recordsLimit)
+	opts, err := findOptions(httpReq, recordsLimit)
+	if err != nil {
+		writeErr(w, "FindProviders", http.StatusBadRequest, err)
+		return
+	}
+
+	var (
+		provIter iter.ResultIter[types.Record]
+		next     string
+	)
+	continuation := httpReq.URL.Query().Get("continuation")
+	if pager, ok := s.svc.(ProvidersPager); ok {
+		provIter, next, err = pager.FindProvidersPage(httpReq.Context(), cid, opts, continuation)
+	} else if continuation != "" {
+		writeErr(w, "FindProviders", http.StatusBadRequest, errors.New("continuation tokens are not supported"))
+		return
+	} else {
+		provIter, err = s.svc.FindProviders(httpReq.Context(), cid, opts)
+	}
 	if err != nil {
 		writeErr(w, "FindProviders", http.StatusInternalServerError, fmt.Errorf("delegate error: %w", err))
 		return
 	}
 
-	handlerFunc(w, provIter)
+	// the backends may return more results than the limit
+	handlerFunc(w, iter.Limit[iter.Result[types.Record]](filterRecords(provIter, opts), opts.Limit), next)
 }
 
-func (s *server) findProvidersJSON(w http.ResponseWriter, provIter iter.ResultIter[types.Record]) {
+func (s *server) findProvidersJSON(w http.ResponseWriter, provIter iter.ResultIter[types.Record], next string) {
 	defer provIter.Close()
 
 	providers, err := iter.ReadAllResults(provIter)
@@ -230,31 +292,24 @@ func (s *server) findProvidersJSON(w http.ResponseWriter, provIter iter.ResultIt
 		return
 	}
 
+	if next != "" {
+		w.Header().Set(continuationHeader, next)
+	}
 	writeJSONResult(w, "FindProviders", jsontypes.ProvidersResponse{
-		Providers: providers,
+		Providers:    providers,
+		Continuation: next,
 	})
 }
 
-func (s *server) findProvidersNDJSON(w http.ResponseWriter, provIter iter.ResultIter[types.Record]) {
+func (s *server) findProvidersNDJSON(w http.ResponseWriter, provIter iter.ResultIter[types.Record], next string) {
+	if next != "" {
+		w.Header().Set(continuationHeader, next)
+	}
 	writeResultsIterNDJSON(w, provIter)
 }
 
 func (s *server) findPeers(w http.ResponseWriter, r *http.Request) {
-	pidStr := mux.Vars(r)["peer-id"]
-
-	// pidStr must be in CIDv1 format. Therefore, use [cid.Decode]. We can't use
-	// [peer.Decode] because that would allow other formats to pass through.
-	cid, err := cid.Decode(pidStr)
-	if err != nil {
-		if pid, err := peer.Decode(pidStr); err == nil {
-			writeErr(w, "FindPeers", http.StatusBadRequest, fmt.Errorf("the value is a peer ID, try using its CID representation: %s", peer.ToCid(pid).String()))
-		} else {
-			writeErr(w, "FindPeers", http.StatusBadRequest, fmt.Errorf("unable to parse peer ID: %w", err))
-		}
-		return
-	}
-
-	pid, err := peer.FromCid(cid)
+	pid, err := parsePeerID(mux.Vars(r)["peer-id"])
 	if err != nil {
 		writeErr(w, "FindPeers", http.StatusBadRequest, fmt.Errorf("unable to parse peer ID: %w", err))
 		return
@@ -279,13 +334,77 @@ func (s *server) findPeers(w http.ResponseWriter, r *http.Request) {
 		recordsLimit = s.recordsLimit
 	}
 
-	provIter, err := s.svc.FindPeers(r.Context(), pid, recordsLimit)
+	opts, err := findOptions(r, recordsLimit)
+	if err != nil {
+		writeErr(w, "FindPeers", http.StatusBadRequest, err)
+		return
+	}
+	provIter, err := s.svc.FindPeers(r.Context(), pid, opts)
+	if errors.Is(err, routing.ErrNotSupported) {
+		writeErr(w, "FindPeers", http.StatusNotImplemented, fmt.Errorf("delegate error: %w", err))
+		return
+	}
 	if err != nil {
 		writeErr(w, "FindPeers", http.StatusInternalServerError, fmt.Errorf("delegate error: %w", err))
 		return
 	}
 
-	handlerFunc(w, provIter)
+	handlerFunc(w, iter.Limit[iter.Result[*types.PeerRecord]](filterRecords(provIter, opts), opts.Limit))
+}
+
+// findOptions returns the [FindOptions] of r, with its [IPIP-484] filters.
+// The limit requested by r is clamped to maxLimit.
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+func findOptions(r *http.Request, maxLimit int) (FindOptions, error) {
+	limit, err := requestLimit(r, maxLimit)
+	if err != nil {
+		return FindOptions{}, err
+	}
+	query := r.URL.Query()
+	return FindOptions{
+		Limit:           limit,
+		FilterAddrs:     types.ParseFilter(query.Get("filter-addrs")),
+		FilterProtocols: types.ParseFilter(query.Get("filter-protocols")),
+	}, nil
+}
+
+// filterRecords applies the filters of opts to the results of it, for the
+// backends which ignore them.
+func filterRecords[T types.Record](it iter.ResultIter[T], opts FindOptions) iter.ResultIter[T] {
+	if len(opts.FilterAddrs) == 0 && len(opts.FilterProtocols) == 0 {
+		return it
+	}
+	// the results filtered out are mapped to nil
+	mapped := iter.Map[iter.Result[T]](it, func(res iter.Result[T]) *iter.Result[T] {
+		if res.Err != nil {
+			return &res
+		}
+		filtered, ok := types.FilterRecord(res.Val, opts.FilterAddrs, opts.FilterProtocols).(T)
+		if !ok {
+			return nil
+		}
+		return &iter.Result[T]{Val: filtered}
+	})
+	kept := iter.Filter[*iter.Result[T]](mapped, func(res *iter.Result[T]) bool {
+		return res != nil
+	})
+	return iter.Map[*iter.Result[T]](kept, func(res *iter.Result[T]) iter.Result[T] {
+		return *res
+	})
+}
+
+// parsePeerID parses a peer ID in its CIDv1 libp2p-key form or, for
+// compatibility, in its legacy base58 form.
+func parsePeerID(pidStr string) (peer.ID, error) {
+	cid, err := cid.Decode(pidStr)
+	if err != nil {
+		if pid, err := peer.Decode(pidStr); err == nil {
+			return pid, nil
+		}
+		return "", err
+	}
+	return peer.FromCid(cid)
 }
 
 func (s *server) provide(w http.ResponseWriter, httpReq *http.Request) {
@@ -294,7 +413,7 @@ func (s *server) provide(w http.ResponseWriter, httpReq *http.Request) {
 	err := json.NewDecoder(httpReq.Body).Decode(&req)
 	_ = httpReq.Body.Close()
 	if err != nil {
-		writeErr(w, "Provide", http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		writeErr(w, "Provide", bodyErrStatus(err), fmt.Errorf("invalid request: %w", err))
 		return
 	}
 
@@ -355,6 +474,10 @@ func (s *server) findPeersJSON(w http.ResponseWriter, peersIter iter.ResultIter[
 		writeErr(w, "FindPeers", http.StatusInternalServerError, fmt.Errorf("delegate error: %w", err))
 		return
 	}
+	if len(peers) == 0 {
+		writeErr(w, "FindPeers", http.StatusNotFound, errNoPeers)
+		return
+	}
 
 	writeJSONResult(w, "FindPeers", jsontypes.PeersResponse{
 		Peers: peers,
@@ -362,7 +485,13 @@ func (s *server) findPeersJSON(w http.ResponseWriter, peersIter iter.ResultIter[
 }
 
 func (s *server) findPeersNDJSON(w http.ResponseWriter, peersIter iter.ResultIter[*types.PeerRecord]) {
-	writeResultsIterNDJSON(w, peersIter)
+	// look at the first result before the status is sent
+	if !peersIter.Next() {
+		peersIter.Close()
+		writeErr(w, "FindPeers", http.StatusNotFound, errNoPeers)
+		return
+	}
+	writeResultsIterNDJSON[*types.PeerRecord](w, &peekedIter[iter.Result[*types.PeerRecord]]{Iter: peersIter, peeked: true})
 }
 
 func (s *server) GetIPNS(w http.ResponseWriter, r *http.Request) {
@@ -386,6 +515,14 @@ func (s *server) GetIPNS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	record, err := s.svc.GetIPNS(r.Context(), name)
+	if errors.Is(err, routing.ErrNotFound) || (err == nil && record == nil) {
+		writeErr(w, "GetIPNS", http.StatusNotFound, errors.New("no record found"))
+		return
+	}
+	if errors.Is(err, routing.ErrNotSupported) {
+		writeErr(w, "GetIPNS", http.StatusNotImplemented, fmt.Errorf("delegate error: %w", err))
+		return
+	}
 	if err != nil {
 		writeErr(w, "GetIPNS", http.StatusInternalServerError, fmt.Errorf("delegate error: %w", err))
 		return
@@ -397,10 +534,18 @@ func (s *server) GetIPNS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	maxAge := time.Minute
 	if ttl, err := record.TTL(); err == nil {
-		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(ttl.Seconds())))
+		maxAge = ttl
+	}
+	// the record must not be cached past its end of life
+	if eol, err := record.Validity(); err == nil && time.Until(eol) < maxAge {
+		maxAge = time.Until(eol)
+	}
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
 	} else {
-		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Cache-Control", "no-store")
 	}
 
 	recordEtag := strconv.FormatUint(xxhash.Sum64(rawRecord), 32)
@@ -429,10 +574,11 @@ func (s *server) PutIPNS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Limit the reader to the maximum record size.
-	rawRecord, err := io.ReadAll(io.LimitReader(r.Body, int64(ipns.MaxRecordSize)))
+	// Limit the reader to the maximum record size, plus one byte so that
+	// larger records are rejected with an [ipns.RecordSizeError].
+	rawRecord, err := io.ReadAll(io.LimitReader(r.Body, int64(ipns.MaxRecordSize)+1))
 	if err != nil {
-		writeErr(w, "PutIPNS", http.StatusBadRequest, fmt.Errorf("provided record is too long: %w", err))
+		writeErr(w, "PutIPNS", bodyErrStatus(err), fmt.Errorf("reading the record: %w", err))
 		return
 	}
 
@@ -449,6 +595,10 @@ func (s *server) PutIPNS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = s.svc.PutIPNS(r.Context(), name, record)
+	if errors.Is(err, routing.ErrNotSupported) {
+		writeErr(w, "PutIPNS", http.StatusNotImplemented, fmt.Errorf("delegate error: %w", err))
+		return
+	}
 	if err != nil {
 		writeErr(w, "PutIPNS", http.StatusInternalServerError, fmt.Errorf("delegate error: %w", err))
 		return
@@ -491,6 +641,21 @@ func logErr(method, msg string, err error) {
 	logger.Infow(msg, "Method", method, "Error", err)
 }
 
+// peekedIter is an [iter.Iter] whose Next was already called once, when
+// peeked is true.
+type peekedIter[T any] struct {
+	iter.Iter[T]
+	peeked bool
+}
+
+func (it *peekedIter[T]) Next() bool {
+	if it.peeked {
+		it.peeked = false
+		return true
+	}
+	return it.Iter.Next()
+}
+
 func writeResultsIterNDJSON[T any](w http.ResponseWriter, resultIter iter.ResultIter[T]) {
 	defer resultIter.Close()
 
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,7 +21,9 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 	b58 "github.com/mr-tron/base58/base58"
+	"github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -39,7 +45,7 @@ func TestHeaders(t *testing.T) {
 	cb, err := cid.Decode(c)
 	require.NoError(t, err)
 
-	router.On("FindProviders", mock.Anything, cb, DefaultRecordsLimit).
+	router.On("FindProviders", mock.Anything, cb, FindOptions{Limit: DefaultRecordsLimit}).
 		Return(results, nil)
 
 	resp, err := http.Get(serverAddr + "/routing/v1/providers/" + c)
@@ -107,7 +113,7 @@ func TestProviders(t *testing.T) {
 		if expectedStream {
 			limit = DefaultStreamingRecordsLimit
 		}
-		router.On("FindProviders", mock.Anything, cid, limit).Return(results, nil)
+		router.On("FindProviders", mock.Anything, cid, FindOptions{Limit: limit}).Return(results, nil)
 		urlStr := serverAddr + "/routing/v1/providers/" + cidStr
 
 		req, err := http.NewRequest(http.MethodGet, urlStr, nil)
@@ -135,6 +141,230 @@ func TestProviders(t *testing.T) {
 	})
 }
 
+func TestFindProvidersFilters(t *testing.T) {
+	cid, err := cid.Decode("bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4")
+	require.NoError(t, err)
+
+	mustAddrs := func(addrs ...string) []types.Multiaddr {
+		var mas []types.Multiaddr
+		for _, a := range addrs {
+			mas = append(mas, types.Multiaddr{Multiaddr: multiaddr.StringCast(a)})
+		}
+		return mas
+	}
+	_, pid1 := makePeerID(t)
+	_, pid2 := makePeerID(t)
+	_, pid3 := makePeerID(t)
+	records := []iter.Result[types.Record]{
+		{Val: &types.PeerRecord{
+			Schema:    types.SchemaPeer,
+			ID:        &pid1,
+			Protocols: []string{"transport-bitswap"},
+			Addrs:     mustAddrs("/ip4/1.2.3.4/tcp/4001", "/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"),
+		}},
+		{Val: &types.PeerRecord{
+			Schema:    types.SchemaPeer,
+			ID:        &pid2,
+			Protocols: []string{"transport-ipfs-gateway-http"},
+			Addrs:     mustAddrs("/dns4/example.com/tcp/443/wss"),
+		}},
+		{Val: &types.PeerRecord{
+			Schema:    types.SchemaPeer,
+			ID:        &pid3,
+			Protocols: []string{"transport-bitswap"},
+			Addrs:     mustAddrs("/ip4/1.2.3.4/udp/4001/quic-v1/webtransport/p2p/" + pid1.String() + "/p2p-circuit"),
+		}},
+	}
+
+	for _, contentType := range []string{mediaTypeJSON, mediaTypeNDJSON} {
+		contentType := contentType
+		t.Run(contentType, func(t *testing.T) {
+			router := &mockContentRouter{}
+			server := httptest.NewServer(Handler(router))
+			t.Cleanup(server.Close)
+
+			limit := DefaultRecordsLimit
+			if contentType == mediaTypeNDJSON {
+				limit = DefaultStreamingRecordsLimit
+			}
+			// the backend ignores the filters it gets
+			router.On("FindProviders", mock.Anything, cid, FindOptions{
+				Limit:           limit,
+				FilterAddrs:     []string{"webtransport", "wss", "!p2p-circuit"},
+				FilterProtocols: []string{"transport-bitswap"},
+			}).Return(iter.FromSlice(records), nil)
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/routing/v1/providers/"+cid.String()+"?filter-addrs=webtransport,wss,!p2p-circuit&filter-protocols=transport-bitswap", nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept", contentType)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, 200, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			expected := `{"Addrs":["/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"],"ID":"` + pid1.String() + `","Protocols":["transport-bitswap"],"Schema":"peer"}`
+			if contentType == mediaTypeJSON {
+				expected = `{"Providers":[` + expected + `]}`
+			} else {
+				expected += "\n"
+			}
+			require.Equal(t, expected, string(body))
+		})
+	}
+}
+
+// pagingRouter serves its records by pages, with the offset of the next page
+// as continuation token.
+type pagingRouter struct {
+	mockContentRouter
+	records []iter.Result[types.Record]
+}
+
+func (r *pagingRouter) FindProvidersPage(ctx context.Context, key cid.Cid, opts FindOptions, continuation string) (iter.ResultIter[types.Record], string, error) {
+	var offset int
+	if continuation != "" {
+		if _, err := fmt.Sscanf(continuation, "offset:%d", &offset); err != nil {
+			return nil, "", err
+		}
+	}
+	end := len(r.records)
+	if opts.Limit > 0 && offset+opts.Limit < end {
+		end = offset + opts.Limit
+	}
+	var next string
+	if end < len(r.records) {
+		next = fmt.Sprintf("offset:%d", end)
+	}
+	return iter.FromSlice(r.records[offset:end]), next, nil
+}
+
+func makePagedRecords(n int) []iter.Result[types.Record] {
+	records := make([]iter.Result[types.Record], n)
+	for i := range records {
+		records[i] = iter.Result[types.Record]{Val: &types.PeerRecord{
+			Schema:    types.SchemaPeer,
+			Protocols: []string{fmt.Sprintf("transport-%d", i)},
+		}}
+	}
+	return records
+}
+
+func TestFindProvidersPages(t *testing.T) {
+	cid, err := cid.Decode("bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4")
+	require.NoError(t, err)
+	records := makePagedRecords(5000)
+
+	// getPage returns the protocols of the records of a page, and its token
+	getPage := func(t *testing.T, url, contentType string) ([]string, string) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		next := resp.Header.Get("Routing-Continuation")
+
+		var page []types.PeerRecord
+		if contentType == mediaTypeJSON {
+			var body struct {
+				Providers    []types.PeerRecord
+				Continuation string
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			require.Equal(t, next, body.Continuation)
+			page = body.Providers
+		} else {
+			for dec := json.NewDecoder(resp.Body); dec.More(); {
+				var rec types.PeerRecord
+				require.NoError(t, dec.Decode(&rec))
+				page = append(page, rec)
+			}
+		}
+		protocols := make([]string, len(page))
+		for i, rec := range page {
+			protocols[i] = rec.Protocols[0]
+		}
+		return protocols, next
+	}
+
+	for _, c := range []struct {
+		name, contentType, query string
+		opts                     []Option
+		pageSize                 int
+	}{
+		{name: "JSON with the default limit", contentType: mediaTypeJSON, pageSize: DefaultRecordsLimit},
+		{name: "JSON with a requested limit", contentType: mediaTypeJSON, query: "limit=7", pageSize: 7},
+		{name: "NDJSON", contentType: mediaTypeNDJSON, opts: []Option{WithStreamingRecordsLimit(1000)}, pageSize: 1000},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(Handler(&pagingRouter{records: records}, c.opts...))
+			t.Cleanup(server.Close)
+
+			var (
+				all   []string
+				next  string
+				pages int
+			)
+			for {
+				query := url.Values{}
+				if c.query != "" {
+					query, err = url.ParseQuery(c.query)
+					require.NoError(t, err)
+				}
+				if next != "" {
+					query.Set("continuation", next)
+				}
+				var page []string
+				page, next = getPage(t, server.URL+"/routing/v1/providers/"+cid.String()+"?"+query.Encode(), c.contentType)
+				pages++
+				if next != "" {
+					require.Len(t, page, c.pageSize)
+					require.Equal(t, fmt.Sprintf("offset:%d", pages*c.pageSize), next)
+				}
+				all = append(all, page...)
+				if next == "" {
+					break
+				}
+			}
+			require.Equal(t, (len(records)+c.pageSize-1)/c.pageSize, pages)
+			require.Len(t, all, len(records))
+			for i, p := range all {
+				require.Equal(t, fmt.Sprintf("transport-%d", i), p)
+			}
+		})
+	}
+
+	t.Run("rejects continuation tokens if the backend does not page", func(t *testing.T) {
+		server := httptest.NewServer(Handler(&mockContentRouter{}))
+		t.Cleanup(server.Close)
+
+		resp, err := http.Get(server.URL + "/routing/v1/providers/" + cid.String() + "?continuation=offset:20")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("enforces the limit on the backends returning more records", func(t *testing.T) {
+		for _, contentType := range []string{mediaTypeJSON, mediaTypeNDJSON} {
+			router := &mockContentRouter{}
+			server := httptest.NewServer(Handler(router, WithStreamingRecordsLimit(10)))
+			t.Cleanup(server.Close)
+			limit := DefaultRecordsLimit
+			if contentType == mediaTypeNDJSON {
+				limit = 10
+			}
+			router.On("FindProviders", mock.Anything, cid, FindOptions{Limit: limit}).Return(iter.FromSlice(records[:100]), nil)
+
+			page, next := getPage(t, server.URL+"/routing/v1/providers/"+cid.String(), contentType)
+			require.Len(t, page, limit)
+			require.Empty(t, next)
+		}
+	})
+}
+
 func TestPeers(t *testing.T) {
 	makeRequest := func(t *testing.T, router *mockContentRouter, contentType, arg string) *http.Response {
 		server := httptest.NewServer(Handler(router))
@@ -155,15 +385,51 @@ func TestPeers(t *testing.T) {
 		require.Equal(t, 400, resp.StatusCode)
 	})
 
-	t.Run("GET /routing/v1/peers/{base58-peer-id} returns 400", func(t *testing.T) {
+	t.Run("GET /routing/v1/peers/{base58-peer-id} returns 200", func(t *testing.T) {
 		t.Parallel()
 
 		_, pid := makePeerID(t)
+		results := iter.FromSlice([]iter.Result[*types.PeerRecord]{
+			{Val: &types.PeerRecord{Schema: types.SchemaPeer, ID: &pid, Addrs: []types.Multiaddr{}}},
+		})
 		router := &mockContentRouter{}
+		router.On("FindPeers", mock.Anything, pid, FindOptions{Limit: 20}).Return(results, nil)
 		resp := makeRequest(t, router, mediaTypeJSON, b58.Encode([]byte(pid)))
-		require.Equal(t, 400, resp.StatusCode)
+		require.Equal(t, 200, resp.StatusCode)
 	})
 
+	for _, contentType := range []string{mediaTypeJSON, mediaTypeNDJSON} {
+		contentType := contentType
+		limit := 20
+		if contentType == mediaTypeNDJSON {
+			limit = 0
+		}
+
+		t.Run("GET /routing/v1/peers/{cid-peer-id} returns 404 for an unknown peer ("+contentType+")", func(t *testing.T) {
+			t.Parallel()
+
+			_, pid := makePeerID(t)
+			router := &mockContentRouter{}
+			router.On("FindPeers", mock.Anything, pid, FindOptions{Limit: limit}).Return(iter.FromSlice([]iter.Result[*types.PeerRecord]{}), nil)
+			resp := makeRequest(t, router, contentType, peer.ToCid(pid).String())
+			require.Equal(t, 404, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, "no peers found", string(body))
+		})
+
+		t.Run("GET /routing/v1/peers/{cid-peer-id} returns 501 without peer routing ("+contentType+")", func(t *testing.T) {
+			t.Parallel()
+
+			_, pid := makePeerID(t)
+			router := &mockContentRouter{}
+			router.On("FindPeers", mock.Anything, pid, FindOptions{Limit: limit}).Return(iter.FromSlice([]iter.Result[*types.PeerRecord]{}), routing.ErrNotSupported)
+			resp := makeRequest(t, router, contentType, peer.ToCid(pid).String())
+			require.Equal(t, 501, resp.StatusCode)
+		})
+	}
+
 	t.Run("GET /routing/v1/peers/{cid-peer-id} returns 200 with correct body (JSON)", func(t *testing.T) {
 		t.Parallel()
 
@@ -184,7 +450,7 @@ func TestPeers(t *testing.T) {
 		})
 
 		router := &mockContentRouter{}
-		router.On("FindPeers", mock.Anything, pid, 20).Return(results, nil)
+		router.On("FindPeers", mock.Anything, pid, FindOptions{Limit: 20}).Return(results, nil)
 
 		resp := makeRequest(t, router, mediaTypeJSON, peer.ToCid(pid).String())
 		require.Equal(t, 200, resp.StatusCode)
@@ -219,7 +485,7 @@ func TestPeers(t *testing.T) {
 		})
 
 		router := &mockContentRouter{}
-		router.On("FindPeers", mock.Anything, pid, 0).Return(results, nil)
+		router.On("FindPeers", mock.Anything, pid, FindOptions{Limit: 0}).Return(results, nil)
 
 		resp := makeRequest(t, router, mediaTypeNDJSON, peer.ToCid(pid).String())
 		require.Equal(t, 200, resp.StatusCode)
@@ -297,6 +563,47 @@ func TestIPNS(t *testing.T) {
 			require.Equal(t, body, rawRecord1)
 		})
 
+		t.Run("GET /routing/v1/ipns/{cid-peer-id} caps Cache-Control at the EOL", func(t *testing.T) {
+			t.Parallel()
+
+			rec, err := ipns.NewRecord(sk, path.FromCid(cid1), 1, time.Now().Add(10*time.Minute), time.Hour, opts...)
+			require.NoError(t, err)
+
+			router := &mockContentRouter{}
+			router.On("GetIPNS", mock.Anything, name1).Return(rec, nil)
+
+			resp := makeRequest(t, router, "/routing/v1/ipns/"+name1.String())
+			require.Equal(t, 200, resp.StatusCode)
+			var maxAge int
+			_, err = fmt.Sscanf(resp.Header.Get("Cache-Control"), "max-age=%d", &maxAge)
+			require.NoError(t, err)
+			require.InDelta(t, 600, maxAge, 5)
+		})
+
+		t.Run("GET /routing/v1/ipns/{cid-peer-id} does not cache expired records", func(t *testing.T) {
+			t.Parallel()
+
+			rec, err := ipns.NewRecord(sk, path.FromCid(cid1), 1, time.Now().Add(-time.Minute), time.Hour, opts...)
+			require.NoError(t, err)
+
+			router := &mockContentRouter{}
+			router.On("GetIPNS", mock.Anything, name1).Return(rec, nil)
+
+			resp := makeRequest(t, router, "/routing/v1/ipns/"+name1.String())
+			require.Equal(t, 200, resp.StatusCode)
+			require.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+		})
+
+		t.Run("GET /routing/v1/ipns/{cid-peer-id} returns 404 for unknown name", func(t *testing.T) {
+			t.Parallel()
+
+			router := &mockContentRouter{}
+			router.On("GetIPNS", mock.Anything, name2).Return((*ipns.Record)(nil), routing.ErrNotFound)
+
+			resp := makeRequest(t, router, "/routing/v1/ipns/"+name2.String())
+			require.Equal(t, 404, resp.StatusCode)
+		})
+
 		t.Run("GET /routing/v1/ipns/{non-peer-cid} returns 400", func(t *testing.T) {
 			t.Parallel()
 
@@ -351,6 +658,61 @@ func TestIPNS(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, 400, resp.StatusCode)
 		})
+
+		t.Run("PUT /routing/v1/ipns/{cid-peer-id} returns 400 for expired record", func(t *testing.T) {
+			t.Parallel()
+
+			record, err := ipns.NewRecord(sk, path.FromCid(cid1), 1, time.Now().Add(-time.Hour), 0, opts...)
+			require.NoError(t, err)
+			rawRecord, err := ipns.MarshalRecord(record)
+			require.NoError(t, err)
+
+			router := &mockContentRouter{}
+
+			server := httptest.NewServer(Handler(router))
+			t.Cleanup(server.Close)
+			urlStr := "http://" + server.Listener.Addr().String() + "/routing/v1/ipns/" + name1.String()
+
+			req, err := http.NewRequest(http.MethodPut, urlStr, bytes.NewReader(rawRecord))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", mediaTypeIPNSRecord)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, 400, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), ipns.ErrExpiredRecord.Error())
+		})
+
+		t.Run("PUT /routing/v1/ipns/{cid-peer-id} returns 400 for oversized record", func(t *testing.T) {
+			t.Parallel()
+
+			value, err := path.Join(path.FromCid(cid1), strings.Repeat("a", ipns.MaxRecordSize))
+			require.NoError(t, err)
+			record, err := ipns.NewRecord(sk, value, 1, time.Now().Add(time.Hour), 0, append(opts, ipns.WithMaxRecordSize(3*ipns.MaxRecordSize))...)
+			require.NoError(t, err)
+			rawRecord, err := ipns.MarshalRecord(record, ipns.WithMaxRecordSize(3*ipns.MaxRecordSize))
+			require.NoError(t, err)
+
+			router := &mockContentRouter{}
+
+			server := httptest.NewServer(Handler(router))
+			t.Cleanup(server.Close)
+			serverAddr := "http://" + server.Listener.Addr().String()
+			urlStr := serverAddr + "/routing/v1/ipns/" + name1.String()
+
+			req, err := http.NewRequest(http.MethodPut, urlStr, bytes.NewReader(rawRecord))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", mediaTypeIPNSRecord)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, 400, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), ipns.ErrRecordSize.Error())
+		})
 	}
 
 	t.Run("V1+V2 IPNS Records", func(t *testing.T) {
@@ -364,8 +726,8 @@ func TestIPNS(t *testing.T) {
 
 type mockContentRouter struct{ mock.Mock }
 
-func (m *mockContentRouter) FindProviders(ctx context.Context, key cid.Cid, limit int) (iter.ResultIter[types.Record], error) {
-	args := m.Called(ctx, key, limit)
+func (m *mockContentRouter) FindProviders(ctx context.Context, key cid.Cid, opts FindOptions) (iter.ResultIter[types.Record], error) {
+	args := m.Called(ctx, key, opts)
 	return args.Get(0).(iter.ResultIter[types.Record]), args.Error(1)
 }
 
@@ -374,8 +736,8 @@ func (m *mockContentRouter) ProvideBitswap(ctx context.Context, req *BitswapWrit
 	return args.Get(0).(time.Duration), args.Error(1)
 }
 
-func (m *mockContentRouter) FindPeers(ctx context.Context, pid peer.ID, limit int) (iter.ResultIter[*types.PeerRecord], error) {
-	args := m.Called(ctx, pid, limit)
+func (m *mockContentRouter) FindPeers(ctx context.Context, pid peer.ID, opts FindOptions) (iter.ResultIter[*types.PeerRecord], error) {
+	args := m.Called(ctx, pid, opts)
 	return args.Get(0).(iter.ResultIter[*types.PeerRecord]), args.Error(1)
 }
 
@@ -0,0 +1,143 @@
+package types
+
+import (
+	"strings"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+// FilterUnknown is the filter of [IPIP-484] keeping the records without
+// addresses, with filter-addrs, or without protocols, with filter-protocols.
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+const FilterUnknown = "unknown"
+
+// ParseFilter parses the comma-separated value of the filter-addrs or the
+// filter-protocols query parameter of [IPIP-484].
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+func ParseFilter(param string) []string {
+	var filters []string
+	for _, f := range strings.Split(param, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f != "" {
+			filters = append(filters, f)
+		}
+	}
+	return filters
+}
+
+// FilterRecord applies the filters of [IPIP-484] to r, and returns nil if r
+// is filtered out. filterAddrs are multiaddr protocol names, such as
+// "webtransport", or negated ones, such as "!p2p-circuit". filterProtocols are
+// transfer protocols, such as "transport-bitswap". The records with an unknown
+// schema are returned as they are, and r is not modified.
+//
+// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+func FilterRecord(r Record, filterAddrs, filterProtocols []string) Record {
+	switch r := r.(type) {
+	case *PeerRecord:
+		if pr := FilterPeerRecord(r, filterAddrs, filterProtocols); pr != nil {
+			return pr
+		}
+		return nil
+	//lint:ignore SA1019 // ignore staticcheck
+	case *BitswapRecord:
+		if !protocolsAllowed([]string{r.Protocol}, filterProtocols) {
+			return nil
+		}
+		addrs, ok := filterAddrsOf(r.Addrs, filterAddrs)
+		if !ok {
+			return nil
+		}
+		br := *r
+		br.Addrs = addrs
+		return &br
+	default:
+		return r
+	}
+}
+
+// FilterPeerRecord is like [FilterRecord] for a [PeerRecord].
+func FilterPeerRecord(r *PeerRecord, filterAddrs, filterProtocols []string) *PeerRecord {
+	if !protocolsAllowed(r.Protocols, filterProtocols) {
+		return nil
+	}
+	addrs, ok := filterAddrsOf(r.Addrs, filterAddrs)
+	if !ok {
+		return nil
+	}
+	pr := *r
+	pr.Addrs = addrs
+	return &pr
+}
+
+// protocolsAllowed reports whether one of protocols is in filters.
+func protocolsAllowed(protocols, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	if len(protocols) == 0 || (len(protocols) == 1 && protocols[0] == "") {
+		return contains(filters, FilterUnknown)
+	}
+	for _, p := range protocols {
+		if contains(filters, strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAddrsOf returns the addrs matching one of the positive filters, if
+// any, and none of the negative ones. It returns false when no addresses are
+// left, unless there were none and the filters keep [FilterUnknown].
+func filterAddrsOf(addrs []Multiaddr, filters []string) ([]Multiaddr, bool) {
+	if len(filters) == 0 {
+		return addrs, true
+	}
+	if len(addrs) == 0 {
+		return addrs, contains(filters, FilterUnknown)
+	}
+
+	var positive, negative []string
+	for _, f := range filters {
+		if name, ok := strings.CutPrefix(f, "!"); ok {
+			negative = append(negative, name)
+		} else if f != FilterUnknown {
+			positive = append(positive, f)
+		}
+	}
+
+	var filtered []Multiaddr
+	for _, addr := range addrs {
+		if addr.Multiaddr == nil {
+			continue
+		}
+		if len(positive) > 0 && !hasProtocol(addr.Multiaddr, positive) {
+			continue
+		}
+		if hasProtocol(addr.Multiaddr, negative) {
+			continue
+		}
+		filtered = append(filtered, addr)
+	}
+	return filtered, len(filtered) > 0
+}
+
+func hasProtocol(addr multiaddr.Multiaddr, names []string) bool {
+	for _, p := range addr.Protocols() {
+		if contains(names, p.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
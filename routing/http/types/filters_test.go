@@ -0,0 +1,130 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func makeAddrs(t *testing.T, addrs ...string) []Multiaddr {
+	var mas []Multiaddr
+	for _, a := range addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		require.NoError(t, err)
+		mas = append(mas, Multiaddr{Multiaddr: ma})
+	}
+	return mas
+}
+
+func TestParseFilter(t *testing.T) {
+	require.Nil(t, ParseFilter(""))
+	require.Equal(t, []string{"webtransport", "!p2p-circuit"}, ParseFilter("WebTransport, !p2p-circuit,,"))
+}
+
+func TestFilterPeerRecord(t *testing.T) {
+	pid := peer.ID("peer")
+	tcp := "/ip4/1.2.3.4/tcp/4001"
+	webtransport := "/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"
+	circuit := "/ip4/1.2.3.4/udp/4001/quic-v1/webtransport/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN/p2p-circuit"
+	record := &PeerRecord{
+		Schema:    SchemaPeer,
+		ID:        &pid,
+		Addrs:     makeAddrs(t, tcp, webtransport, circuit),
+		Protocols: []string{"transport-bitswap", "transport-ipfs-gateway-http"},
+	}
+
+	for _, c := range []struct {
+		name            string
+		record          *PeerRecord
+		filterAddrs     string
+		filterProtocols string
+		expAddrs        []Multiaddr
+		expDropped      bool
+	}{
+		{
+			name:     "no filters",
+			record:   record,
+			expAddrs: record.Addrs,
+		},
+		{
+			name:        "positive addrs filter",
+			record:      record,
+			filterAddrs: "webtransport",
+			expAddrs:    makeAddrs(t, webtransport, circuit),
+		},
+		{
+			name:        "positive and negative addrs filters",
+			record:      record,
+			filterAddrs: "webtransport,!p2p-circuit",
+			expAddrs:    makeAddrs(t, webtransport),
+		},
+		{
+			name:        "negative addrs filter only",
+			record:      record,
+			filterAddrs: "!p2p-circuit",
+			expAddrs:    makeAddrs(t, tcp, webtransport),
+		},
+		{
+			name:        "no addrs left",
+			record:      record,
+			filterAddrs: "wss",
+			expDropped:  true,
+		},
+		{
+			name:        "no addrs without unknown",
+			record:      &PeerRecord{Schema: SchemaPeer, ID: &pid},
+			filterAddrs: "tcp",
+			expDropped:  true,
+		},
+		{
+			name:        "no addrs with unknown",
+			record:      &PeerRecord{Schema: SchemaPeer, ID: &pid},
+			filterAddrs: "tcp,unknown",
+		},
+		{
+			name:            "matching protocol",
+			record:          record,
+			filterProtocols: "transport-bitswap",
+			expAddrs:        record.Addrs,
+		},
+		{
+			name:            "no matching protocol",
+			record:          record,
+			filterProtocols: "transport-graphsync-filecoinv1",
+			expDropped:      true,
+		},
+		{
+			name:            "no protocols with unknown",
+			record:          &PeerRecord{Schema: SchemaPeer, ID: &pid},
+			filterProtocols: "transport-bitswap,unknown",
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			filtered := FilterPeerRecord(c.record, ParseFilter(c.filterAddrs), ParseFilter(c.filterProtocols))
+			if c.expDropped {
+				require.Nil(t, filtered)
+				return
+			}
+			require.NotNil(t, filtered)
+			require.Equal(t, c.expAddrs, filtered.Addrs)
+		})
+	}
+
+	// the record itself is not modified
+	require.Len(t, record.Addrs, 3)
+}
+
+func TestFilterRecord(t *testing.T) {
+	pid := peer.ID("peer")
+	//lint:ignore SA1019 // ignore staticcheck
+	bitswap := &BitswapRecord{Schema: SchemaBitswap, ID: &pid, Protocol: "transport-bitswap", Addrs: makeAddrs(t, "/ip4/1.2.3.4/tcp/4001")}
+	require.Equal(t, bitswap, FilterRecord(bitswap, []string{"tcp"}, []string{"transport-bitswap"}))
+	require.Nil(t, FilterRecord(bitswap, nil, []string{"transport-ipfs-gateway-http"}))
+	require.Nil(t, FilterRecord(bitswap, []string{"!tcp"}, nil))
+
+	unknown := &UnknownRecord{Schema: "other"}
+	require.Equal(t, unknown, FilterRecord(unknown, []string{"tcp"}, []string{"transport-bitswap"}))
+}
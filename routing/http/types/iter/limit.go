@@ -0,0 +1,33 @@
+package iter
+
+// Limit returns an iterator of the first n elements of iter, or of all of them
+// if n is 0.
+func Limit[T any](iter Iter[T], n int) *LimitIter[T] {
+	return &LimitIter[T]{iter: iter, n: n}
+}
+
+type LimitIter[T any] struct {
+	iter Iter[T]
+	n    int
+
+	count int
+}
+
+func (l *LimitIter[T]) Next() bool {
+	if l.n > 0 && l.count >= l.n {
+		return false
+	}
+	if !l.iter.Next() {
+		return false
+	}
+	l.count++
+	return true
+}
+
+func (l *LimitIter[T]) Val() T {
+	return l.iter.Val()
+}
+
+func (l *LimitIter[T]) Close() error {
+	return l.iter.Close()
+}
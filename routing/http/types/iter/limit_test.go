@@ -0,0 +1,41 @@
+package iter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimit(t *testing.T) {
+	for _, c := range []struct {
+		input      Iter[int]
+		n          int
+		expResults []int
+	}{
+		{
+			input:      FromSlice([]int{1, 2, 3, 4}),
+			n:          2,
+			expResults: []int{1, 2},
+		},
+		{
+			input:      FromSlice([]int{1, 2}),
+			n:          3,
+			expResults: []int{1, 2},
+		},
+		{
+			input:      FromSlice([]int{1, 2, 3}),
+			n:          0,
+			expResults: []int{1, 2, 3},
+		},
+		{
+			input:      FromSlice([]int{}),
+			n:          1,
+			expResults: nil,
+		},
+	} {
+		t.Run(fmt.Sprintf("%v", c.input), func(t *testing.T) {
+			assert.Equal(t, c.expResults, ReadAll[int](Limit(c.input, c.n)))
+		})
+	}
+}
@@ -9,6 +9,9 @@ import (
 // ProvidersResponse is the result of a GET Providers request.
 type ProvidersResponse struct {
 	Providers RecordsArray
+
+	// Continuation is the token of the next page of providers, if any.
+	Continuation string `json:",omitempty"`
 }
 
 // PeersResponse is the result of a GET Peers request.
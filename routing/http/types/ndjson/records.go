@@ -17,34 +17,38 @@ func NewRecordsIter(r io.Reader) iter.Iter[iter.Result[types.Record]] {
 			result.Err = upr.Err
 			return result
 		}
-		switch upr.Val.Schema {
-		case types.SchemaPeer:
-			var prov types.PeerRecord
-			err := json.Unmarshal(upr.Val.Bytes, &prov)
-			if err != nil {
-				result.Err = err
-				return result
-			}
-			result.Val = &prov
-		//lint:ignore SA1019 // ignore staticcheck
-		case types.SchemaBitswap:
-			//lint:ignore SA1019 // ignore staticcheck
-			var prov types.BitswapRecord
-			err := json.Unmarshal(upr.Val.Bytes, &prov)
-			if err != nil {
-				result.Err = err
-				return result
-			}
-			result.Val = &prov
-		default:
-			result.Val = &upr.Val
-		}
+		result.Val, result.Err = decodeRecord(upr.Val)
 		return result
 	}
 
 	return iter.Map[iter.Result[types.UnknownRecord]](jsonIter, mapFn)
 }
 
+// decodeRecord decodes upr into the record type of its schema. The records
+// with an unknown schema are returned as they are.
+func decodeRecord(upr types.UnknownRecord) (types.Record, error) {
+	switch upr.Schema {
+	case types.SchemaPeer:
+		var prov types.PeerRecord
+		err := json.Unmarshal(upr.Bytes, &prov)
+		if err != nil {
+			return nil, err
+		}
+		return &prov, nil
+	//lint:ignore SA1019 // ignore staticcheck
+	case types.SchemaBitswap:
+		//lint:ignore SA1019 // ignore staticcheck
+		var prov types.BitswapRecord
+		err := json.Unmarshal(upr.Bytes, &prov)
+		if err != nil {
+			return nil, err
+		}
+		return &prov, nil
+	default:
+		return &upr, nil
+	}
+}
+
 // NewPeerRecordsIter returns an iterator that reads [types.PeerRecord] from the given [io.Reader].
 // Records with a different schema are safely ignored. If you want to read all records, use
 // [NewRecordsIter] instead.
@@ -0,0 +1,171 @@
+package ndjson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
+)
+
+// DefaultMaxRecordSize is the default maximum size of a single record read by
+// a [RecordsStream].
+const DefaultMaxRecordSize = 64 << 10
+
+// ErrRecordTooLarge is returned for the records larger than the maximum size
+// of a [RecordsStream].
+var ErrRecordTooLarge = errors.New("record exceeds the maximum size")
+
+// RecordsStreamOptions are the options of [NewRecordsStream].
+type RecordsStreamOptions struct {
+	// MaxRecordSize is the maximum size of a single record, in bytes.
+	// Defaults to [DefaultMaxRecordSize].
+	MaxRecordSize int
+
+	// Limit is the number of records after which the stream ends. Zero
+	// means no limit.
+	Limit int
+
+	// SkipInvalid skips the records which cannot be decoded instead of
+	// returning them as errors. Either way, they are counted by
+	// [RecordsStream.Invalid] and the stream goes on.
+	SkipInvalid bool
+
+	// FilterAddrs and FilterProtocols are filters of [IPIP-484] applied to
+	// the records, see [types.FilterRecord]. The records filtered out do not
+	// count toward Limit.
+	//
+	// [IPIP-484]: https://github.com/ipfs/specs/pull/484
+	FilterAddrs     []string
+	FilterProtocols []string
+}
+
+// RecordsStream iterates over the [types.Record] of a newline-delimited JSON
+// stream, decoding them as they are read. Unlike [NewRecordsIter], a record
+// which cannot be decoded does not end the stream. The reader is closed, if
+// it is a closer, once the stream ends or the limit is reached.
+type RecordsStream struct {
+	r       io.Reader
+	br      *bufio.Reader
+	opts    RecordsStreamOptions
+	res     iter.Result[types.Record]
+	read    int
+	invalid int
+	done    bool
+}
+
+var _ iter.ResultIter[types.Record] = &RecordsStream{}
+
+// NewRecordsStream returns a [RecordsStream] reading from r.
+func NewRecordsStream(r io.Reader, opts RecordsStreamOptions) *RecordsStream {
+	if opts.MaxRecordSize <= 0 {
+		opts.MaxRecordSize = DefaultMaxRecordSize
+	}
+	return &RecordsStream{
+		r: r,
+		// one more byte for the newline
+		br:   bufio.NewReaderSize(r, opts.MaxRecordSize+1),
+		opts: opts,
+	}
+}
+
+func (s *RecordsStream) Next() bool {
+	for !s.done {
+		if s.opts.Limit > 0 && s.read >= s.opts.Limit {
+			s.Close()
+			return false
+		}
+
+		line, err := s.readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				s.Close()
+				return false
+			}
+			if !errors.Is(err, ErrRecordTooLarge) {
+				// the stream is broken, stop there
+				s.res = iter.Result[types.Record]{Err: err}
+				s.Close()
+				return true
+			}
+		} else if len(line) == 0 {
+			continue
+		} else {
+			var rec types.Record
+			rec, err = decodeLine(line)
+			if err == nil && (len(s.opts.FilterAddrs) > 0 || len(s.opts.FilterProtocols) > 0) {
+				rec = types.FilterRecord(rec, s.opts.FilterAddrs, s.opts.FilterProtocols)
+				if rec == nil {
+					continue
+				}
+			}
+			if err == nil {
+				s.read++
+				s.res = iter.Result[types.Record]{Val: rec}
+				return true
+			}
+		}
+
+		s.invalid++
+		if !s.opts.SkipInvalid {
+			s.read++
+			s.res = iter.Result[types.Record]{Err: err}
+			return true
+		}
+	}
+	return false
+}
+
+// readLine reads the next line, without its newline and surrounding spaces.
+// The lines larger than the maximum record size are discarded with
+// [ErrRecordTooLarge].
+func (s *RecordsStream) readLine() ([]byte, error) {
+	line, err := s.br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = s.br.ReadSlice('\n')
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w of %d bytes", ErrRecordTooLarge, s.opts.MaxRecordSize)
+	}
+	if err != nil && !(errors.Is(err, io.EOF) && len(bytes.TrimSpace(line)) != 0) {
+		// the last line may have no newline
+		return nil, err
+	}
+	return bytes.TrimSpace(line), nil
+}
+
+func (s *RecordsStream) Val() iter.Result[types.Record] {
+	return s.res
+}
+
+// Invalid returns the number of records which could not be decoded so far.
+func (s *RecordsStream) Invalid() int {
+	return s.invalid
+}
+
+func (s *RecordsStream) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	if closer, ok := s.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func decodeLine(line []byte) (types.Record, error) {
+	var upr types.UnknownRecord
+	// the record keeps its bytes, which are overwritten by the next read
+	if err := json.Unmarshal(bytes.Clone(line), &upr); err != nil {
+		return nil, err
+	}
+	return decodeRecord(upr)
+}
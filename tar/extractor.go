@@ -8,12 +8,86 @@ import (
 	"os"
 	fp "path/filepath"
 	"strings"
+	"time"
 )
 
 var (
 	errTraverseSymlink          = errors.New("cannot traverse symlinks")
 	errInvalidRoot              = errors.New("tar has invalid root")
 	errInvalidRootMultipleRoots = fmt.Errorf("contains more than one root or the root directory is not the first entry : %w", errInvalidRoot)
+
+	// ErrSymlinkNotAllowed is returned when extracting a symlink with the SymlinksDisallowed policy.
+	ErrSymlinkNotAllowed = errors.New("symlinks are not allowed")
+	// ErrSymlinkEscapesRoot is returned when extracting a symlink whose target could resolve outside of the
+	// extraction root with the SymlinksSanitized policy.
+	ErrSymlinkEscapesRoot = errors.New("symlink target is outside of the extraction root")
+)
+
+// ErrLimitExceeded matches the LimitError of the extractions exceeding one of the limits of the Extractor.
+var ErrLimitExceeded = errors.New("extraction limit exceeded")
+
+// Limit identifies one of the limits of the Extractor.
+type Limit int
+
+const (
+	// LimitBytes is the limit of Extractor.MaxBytes.
+	LimitBytes Limit = iota
+	// LimitEntries is the limit of Extractor.MaxEntries.
+	LimitEntries
+	// LimitDepth is the limit of Extractor.MaxDepth.
+	LimitDepth
+	// LimitFileSize is the limit of Extractor.MaxFileSize.
+	LimitFileSize
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitBytes:
+		return "total bytes"
+	case LimitEntries:
+		return "entries"
+	case LimitDepth:
+		return "path depth"
+	case LimitFileSize:
+		return "file size"
+	default:
+		return fmt.Sprintf("Limit(%d)", int(l))
+	}
+}
+
+// LimitError is returned when an extraction exceeds one of the limits of the Extractor. It matches
+// ErrLimitExceeded.
+type LimitError struct {
+	Limit Limit
+	Max   int64
+	// Path is the path in the tar file of the entry exceeding the limit.
+	Path string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%q : exceeds the %s limit of %d : %s", e.Path, e.Limit, e.Max, ErrLimitExceeded)
+}
+
+func (e *LimitError) Is(err error) bool {
+	return err == ErrLimitExceeded
+}
+
+// SymlinkPolicy selects the symlinks the Extractor extracts.
+type SymlinkPolicy int
+
+const (
+	// SymlinksSanitized only extracts the symlinks whose target stays within the extraction root, which is the
+	// directory of the root of the tar file, or the directory of its single file or symlink. Absolute targets are
+	// refused, and so are the targets with '..' components after other components, since those could be resolved
+	// through other symlinks. The leading '..' components of a target cannot go above the extraction root.
+	//
+	// The targets are only checked when the symlinks are extracted: the objects already in the extraction path
+	// are trusted.
+	SymlinksSanitized SymlinkPolicy = iota
+	// SymlinksDisallowed fails the extraction of any symlink.
+	SymlinksDisallowed
+	// SymlinksAllowed extracts all the symlinks, whatever their target. It is only meant for trusted tar files.
+	SymlinksAllowed
 )
 
 // Extractor is used for extracting tar files to a filesystem.
@@ -28,9 +102,52 @@ var (
 //
 // Overwriting: Extraction of files and symlinks will result in overwriting the existing objects with the same name
 // when possible (i.e. other files, symlinks, and empty directories).
+//
+// Limits: An extraction exceeding one of the limits of the Extractor fails with a LimitError. The objects extracted
+// before remain, but not the file being extracted when the limit is exceeded. The limits should be set when
+// extracting untrusted tar files, which could otherwise exhaust the disk and the inodes of the file system.
+//
+// Symlinks: The symlinks extracted depend on the Symlinks policy, SymlinksSanitized by default. Whatever the policy,
+// the extraction never writes through a symlink. On Windows, targets with a volume name or starting with a separator
+// are absolute, backslashes are separators, and symlinks the system refuses to create (e.g. without the required
+// privilege) fail the extraction.
 type Extractor struct {
 	Path     string
 	Progress func(int64) int64
+
+	// Symlinks is the policy of the extraction of symlinks.
+	Symlinks SymlinkPolicy
+
+	// PreservePermissions applies the permission bits of the headers, masked by Umask, to the extracted files and
+	// directories. The setuid, setgid and sticky bits are stripped unless PreserveSpecialBits is set. Otherwise the
+	// files are only readable and writable by the user, and the directories have 0755 permissions.
+	PreservePermissions bool
+	// Umask clears its permission bits from the ones applied with PreservePermissions.
+	Umask os.FileMode
+	// PreserveSpecialBits keeps the setuid, setgid and sticky bits applied with PreservePermissions. It is
+	// dangerous with untrusted tar files, which could then extract setuid executables.
+	PreserveSpecialBits bool
+
+	// PreserveMtime applies the modification times of the headers to the extracted objects. The times of the
+	// directories are applied once the extraction is done, so that extracting their contents does not change them.
+	// The times of the symlinks are only applied on the platforms able to set them without following the links.
+	PreserveMtime bool
+
+	// MaxBytes limits the total size of the extracted files. The bytes actually written are counted, whatever
+	// the sizes in the headers. There is no limit if zero, and so for the other limits.
+	MaxBytes int64
+	// MaxEntries limits the number of entries of the tar file.
+	MaxEntries int
+	// MaxDepth limits the number of components of the paths of the entries, the root included.
+	MaxDepth int
+	// MaxFileSize limits the size of each extracted file.
+	MaxFileSize int64
+}
+
+// extractedDir is a directory whose permissions and modification time are applied at the end of the extraction.
+type extractedDir struct {
+	path   string
+	header *tar.Header
 }
 
 // Extract extracts a tar file to the file system. See the Extractor for more information on the limitations on the
@@ -43,6 +160,9 @@ func (te *Extractor) Extract(reader io.Reader) error {
 	tarReader := tar.NewReader(reader)
 
 	var firstObjectWasDir bool
+	var dirs []extractedDir
+	var entries int
+	var written int64
 
 	header, err := tarReader.Next()
 	if err != nil && err != io.EOF {
@@ -64,6 +184,10 @@ func (te *Extractor) Extract(reader io.Reader) error {
 		return fmt.Errorf("invalid root path: %q : %w", header.Name, errInvalidRoot)
 	}
 	rootName := header.Name
+	entries++
+	if err := te.checkEntry(header.Name, entries); err != nil {
+		return err
+	}
 
 	// Get the platform-specific output path
 	rootOutputPath := fp.Clean(te.Path)
@@ -85,6 +209,7 @@ func (te *Extractor) Extract(reader io.Reader) error {
 		if err := te.extractDir(rootOutputPath); err != nil {
 			return err
 		}
+		dirs = append(dirs, extractedDir{rootOutputPath, header})
 	case tar.TypeReg, tar.TypeSymlink:
 		// Check if the output path already exists, so we know whether we should
 		// create our output with that name, or if we should put the output inside
@@ -114,10 +239,10 @@ func (te *Extractor) Extract(reader io.Reader) error {
 
 		// If an object with the target name already exists overwrite it
 		if header.Typeflag == tar.TypeReg {
-			if err := te.extractFile(outputPath, tarReader); err != nil {
+			if err := te.extractFile(outputPath, header, tarReader, &written); err != nil {
 				return err
 			}
-		} else if err := te.extractSymlink(outputPath, header); err != nil {
+		} else if err := te.extractSymlink(outputPath, 0, header); err != nil {
 			return err
 		}
 	default:
@@ -143,6 +268,10 @@ func (te *Extractor) Extract(reader io.Reader) error {
 		if err := validateTarPath(header.Name); err != nil {
 			return err
 		}
+		entries++
+		if err := te.checkEntry(header.Name, entries); err != nil {
+			return err
+		}
 		cleanedPath := header.Name
 
 		relPath, err := getRelativePath(rootName, cleanedPath)
@@ -173,18 +302,26 @@ func (te *Extractor) Extract(reader io.Reader) error {
 			if err := te.extractDir(outputPath); err != nil {
 				return err
 			}
+			dirs = append(dirs, extractedDir{outputPath, header})
 		case tar.TypeReg:
-			if err := te.extractFile(outputPath, tarReader); err != nil {
+			if err := te.extractFile(outputPath, header, tarReader, &written); err != nil {
 				return err
 			}
 		case tar.TypeSymlink:
-			if err := te.extractSymlink(outputPath, header); err != nil {
+			if err := te.extractSymlink(outputPath, strings.Count(relPath, "/"), header); err != nil {
 				return err
 			}
 		default:
 			return fmt.Errorf("unrecognized tar header type: %d", header.Typeflag)
 		}
 	}
+
+	// apply the attributes of the deepest directories first, in case they prevent modifying their parents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := te.applyAttributes(dirs[i].path, dirs[i].header); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -267,15 +404,101 @@ func (te *Extractor) extractDir(path string) error {
 	return nil
 }
 
-func (te *Extractor) extractSymlink(path string, h *tar.Header) error {
+// extractSymlink extracts the symlink h at path, which is depth directories below the extraction root.
+func (te *Extractor) extractSymlink(path string, depth int, h *tar.Header) error {
+	switch te.Symlinks {
+	case SymlinksSanitized:
+		if err := validateSymlinkTarget(h.Linkname, depth); err != nil {
+			return fmt.Errorf("%q -> %q : %w", h.Name, h.Linkname, err)
+		}
+	case SymlinksDisallowed:
+		return fmt.Errorf("%q : %w", h.Name, ErrSymlinkNotAllowed)
+	case SymlinksAllowed:
+	default:
+		return fmt.Errorf("unknown symlink policy: %d", te.Symlinks)
+	}
+
 	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
 
-	return os.Symlink(h.Linkname, path)
+	if err := os.Symlink(h.Linkname, path); err != nil {
+		return err
+	}
+
+	if te.PreserveMtime {
+		atime, mtime := headerTimes(h)
+		return lchtimes(path, atime, mtime)
+	}
+	return nil
+}
+
+// validateSymlinkTarget returns an error if the target of a symlink depth directories below the extraction root
+// could resolve outside of it.
+func validateSymlinkTarget(target string, depth int) error {
+	if target == "" {
+		return errors.New("symlink target is empty")
+	}
+
+	slashTarget := fp.ToSlash(target)
+	if fp.IsAbs(target) || fp.VolumeName(target) != "" || strings.HasPrefix(slashTarget, "/") {
+		return fmt.Errorf("absolute target : %w", ErrSymlinkEscapesRoot)
+	}
+
+	leading := true
+	for _, e := range strings.Split(slashTarget, "/") {
+		switch e {
+		case "", ".":
+		case "..":
+			if !leading {
+				return fmt.Errorf("'..' after other components : %w", ErrSymlinkEscapesRoot)
+			}
+			depth--
+			if depth < 0 {
+				return ErrSymlinkEscapesRoot
+			}
+		default:
+			leading = false
+		}
+	}
+	return nil
+}
+
+// checkEntry returns an error if the entry at tarPath, the n-th of the tar file, exceeds the limits.
+func (te *Extractor) checkEntry(tarPath string, n int) error {
+	if te.MaxEntries > 0 && n > te.MaxEntries {
+		return &LimitError{LimitEntries, int64(te.MaxEntries), tarPath}
+	}
+	if te.MaxDepth > 0 && strings.Count(tarPath, "/")+1 > te.MaxDepth {
+		return &LimitError{LimitDepth, int64(te.MaxDepth), tarPath}
+	}
+	return nil
+}
+
+// budgetReader fails the reads of a file past the size limits of the extraction.
+type budgetReader struct {
+	te      *Extractor
+	r       io.Reader
+	name    string
+	size    int64
+	written *int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.size += int64(n)
+	*b.written += int64(n)
+	if b.te.MaxFileSize > 0 && b.size > b.te.MaxFileSize {
+		return 0, &LimitError{LimitFileSize, b.te.MaxFileSize, b.name}
+	}
+	if b.te.MaxBytes > 0 && *b.written > b.te.MaxBytes {
+		return 0, &LimitError{LimitBytes, b.te.MaxBytes, b.name}
+	}
+	return n, err
 }
 
-func (te *Extractor) extractFile(path string, r *tar.Reader) error {
+// extractFile extracts the file h at path, adding its size to the bytes written.
+func (te *Extractor) extractFile(path string, h *tar.Header, r *tar.Reader, written *int64) error {
 	// Attempt removing the target so we can overwrite files, symlinks and empty directories
 	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
@@ -288,7 +511,7 @@ func (te *Extractor) extractFile(path string, r *tar.Reader) error {
 	if err != nil {
 		return err
 	}
-	if err := copyWithProgress(tmpfile, r, te.Progress); err != nil {
+	if err := copyWithProgress(tmpfile, &budgetReader{te: te, r: r, name: h.Name, written: written}, te.Progress); err != nil {
 		_ = tmpfile.Close()
 		_ = os.Remove(tmpfile.Name())
 		return err
@@ -297,6 +520,10 @@ func (te *Extractor) extractFile(path string, r *tar.Reader) error {
 		_ = os.Remove(tmpfile.Name())
 		return err
 	}
+	if err := te.applyAttributes(tmpfile.Name(), h); err != nil {
+		_ = os.Remove(tmpfile.Name())
+		return err
+	}
 
 	if err := os.Rename(tmpfile.Name(), path); err != nil {
 		_ = os.Remove(tmpfile.Name())
@@ -306,6 +533,40 @@ func (te *Extractor) extractFile(path string, r *tar.Reader) error {
 	return nil
 }
 
+// applyAttributes applies the permissions and the modification time of the header to the file or directory at path,
+// as configured.
+func (te *Extractor) applyAttributes(path string, h *tar.Header) error {
+	if te.PreservePermissions {
+		mode := h.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if !te.PreserveSpecialBits {
+			mode &^= os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+		}
+		mode &^= te.Umask & os.ModePerm
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	if te.PreserveMtime {
+		atime, mtime := headerTimes(h)
+		if err := os.Chtimes(path, atime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// headerTimes returns the access and modification times of the header. The access time is the modification time
+// when the header has none.
+func headerTimes(h *tar.Header) (atime, mtime time.Time) {
+	mtime = h.ModTime
+	atime = h.AccessTime
+	if atime.IsZero() {
+		atime = mtime
+	}
+	return atime, mtime
+}
+
 func copyWithProgress(to io.Writer, from io.Reader, cb func(int64) int64) error {
 	buf := make([]byte, 4096)
 	for {
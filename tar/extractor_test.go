@@ -8,6 +8,7 @@ import (
 	"os"
 	fp "path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -268,7 +269,7 @@ func TestExternalSymlinkTraverse(t *testing.T) {
 	if !symlinksEnabled {
 		t.Skip("symlinks disabled on this platform", symlinksEnabledErr)
 	}
-	testTarExtraction(t, nil, []tarEntry{
+	testTarExtractionWith(t, Extractor{Symlinks: SymlinksAllowed}, nil, []tarEntry{
 		// FIXME: We are ignoring the first element in the path check so
 		//  we add a directory at the start to bypass this.
 		&dirTarEntry{"inner"},
@@ -285,7 +286,7 @@ func TestLastElementOverwrite(t *testing.T) {
 		t.Skip("symlinks disabled on this platform", symlinksEnabledErr)
 	}
 	const originalData = "original"
-	testTarExtraction(t, func(t *testing.T, rootDir string) {
+	testTarExtractionWith(t, Extractor{Symlinks: SymlinksAllowed}, func(t *testing.T, rootDir string) {
 		// Create an outside target that will try to be overwritten.
 		// This file will reside outside of the extraction directory root.
 		f, err := os.Create(fp.Join(rootDir, "outside-ref"))
@@ -311,9 +312,304 @@ func TestLastElementOverwrite(t *testing.T) {
 	)
 }
 
+func TestSymlinksDisallowed(t *testing.T) {
+	disallowed := Extractor{Symlinks: SymlinksDisallowed}
+	testTarExtractionWith(t, disallowed, nil, []tarEntry{
+		&symlinkTarEntry{"file", "symlink"},
+	}, nil, ErrSymlinkNotAllowed)
+	testTarExtractionWith(t, disallowed, nil, []tarEntry{
+		&dirTarEntry{"root"},
+		&fileTarEntry{"root/file", []byte("data")},
+		&symlinkTarEntry{"file", "root/symlink"},
+	}, nil, ErrSymlinkNotAllowed)
+}
+
+func TestSymlinksSanitized(t *testing.T) {
+	if !symlinksEnabled {
+		t.Skip("symlinks disabled on this platform", symlinksEnabledErr)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		entries []tarEntry
+		err     error
+	}{
+		{"single symlink to parent", []tarEntry{
+			&symlinkTarEntry{"../outside", "symlink"},
+		}, ErrSymlinkEscapesRoot},
+		{"absolute target", []tarEntry{
+			&dirTarEntry{"root"},
+			&symlinkTarEntry{"/etc/passwd", "root/symlink"},
+		}, ErrSymlinkEscapesRoot},
+		{"parent of the root", []tarEntry{
+			&dirTarEntry{"root"},
+			&symlinkTarEntry{"../outside-ref", "root/symlink"},
+		}, ErrSymlinkEscapesRoot},
+		{"above the root from a subdirectory", []tarEntry{
+			&dirTarEntry{"root"},
+			&dirTarEntry{"root/a"},
+			&dirTarEntry{"root/a/b"},
+			&symlinkTarEntry{"../../../outside-ref", "root/a/b/symlink"},
+		}, ErrSymlinkEscapesRoot},
+		{"parent hidden after other components", []tarEntry{
+			&dirTarEntry{"root"},
+			&dirTarEntry{"root/a"},
+			&symlinkTarEntry{"a/../../outside-ref", "root/symlink"},
+		}, ErrSymlinkEscapesRoot},
+		{"escape through an earlier symlink", []tarEntry{
+			// self/.. is the parent of the root, not the root
+			&dirTarEntry{"root"},
+			&symlinkTarEntry{".", "root/self"},
+			&symlinkTarEntry{"self/..", "root/escape"},
+		}, ErrSymlinkEscapesRoot},
+		{"write through an earlier symlink", []tarEntry{
+			&dirTarEntry{"root"},
+			&dirTarEntry{"root/dir"},
+			&symlinkTarEntry{"dir", "root/symlink"},
+			&fileTarEntry{"root/symlink/file", []byte("data")},
+		}, errTraverseSymlink},
+		{"within the root", []tarEntry{
+			&dirTarEntry{"root"},
+			&dirTarEntry{"root/a"},
+			&dirTarEntry{"root/a/b"},
+			&fileTarEntry{"root/file", []byte("data")},
+			&symlinkTarEntry{"../../file", "root/a/b/up"},
+			&symlinkTarEntry{"./a/b/up", "root/down"},
+			&symlinkTarEntry{"nonexistent", "root/dangling"},
+		}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testTarExtraction(t, nil, tc.entries, nil, tc.err)
+		})
+	}
+}
+
+func TestSymlinksAllowed(t *testing.T) {
+	if !symlinksEnabled {
+		t.Skip("symlinks disabled on this platform", symlinksEnabledErr)
+	}
+	testTarExtractionWith(t, Extractor{Symlinks: SymlinksAllowed}, nil, []tarEntry{
+		&dirTarEntry{"root"},
+		&symlinkTarEntry{"/etc/passwd", "root/absolute"},
+		&symlinkTarEntry{"../outside-ref", "root/parent"},
+	}, func(t *testing.T, extractDir string) {
+		target, err := os.Readlink(fp.Join(extractDir, "absolute"))
+		assert.NoError(t, err)
+		assert.Equal(t, "/etc/passwd", target)
+		target, err = os.Readlink(fp.Join(extractDir, "parent"))
+		assert.NoError(t, err)
+		assert.Equal(t, "../outside-ref", target)
+	}, nil)
+}
+
+func TestValidateSymlinkTarget(t *testing.T) {
+	for _, tc := range []struct {
+		target string
+		depth  int
+		valid  bool
+	}{
+		{"file", 0, true},
+		{"./dir/file", 0, true},
+		{"dir/./file/", 0, true},
+		{"../file", 0, false},
+		{"../file", 1, true},
+		{"../../file", 1, false},
+		{".././../file", 2, true},
+		{"dir/../file", 1, false},
+		{"/file", 3, false},
+		{"", 0, false},
+	} {
+		err := validateSymlinkTarget(tc.target, tc.depth)
+		assert.Equal(t, tc.valid, err == nil, "%q at depth %d: %v", tc.target, tc.depth, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		for _, target := range []string{`C:\Windows`, `C:file`, `\\server\share`, `\file`, `..\..\file`, `dir\..\file`} {
+			assert.Error(t, validateSymlinkTarget(target, 1), target)
+		}
+	}
+}
+
+func TestPreserveAttributes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not supported on windows")
+	}
+
+	// PAX headers keep the sub-second precision of the times
+	mtime := func(sec int64, nsec int64) time.Time { return time.Unix(sec, nsec) }
+	entries := []tarEntry{
+		&headerTarEntry{&tar.Header{Name: "root", Typeflag: tar.TypeDir, Mode: 0o750, ModTime: mtime(1000000000, 123456789)}, nil},
+		&headerTarEntry{&tar.Header{Name: "root/bin", Typeflag: tar.TypeDir, Mode: 0o700, ModTime: mtime(1100000000, 1)}, nil},
+		&headerTarEntry{&tar.Header{Name: "root/bin/exe", Typeflag: tar.TypeReg, Mode: 0o775, ModTime: mtime(1200000000, 500000000)}, []byte("#!/bin/sh")},
+		&headerTarEntry{&tar.Header{Name: "root/bin/suid", Typeflag: tar.TypeReg, Mode: 0o4755, ModTime: mtime(1250000000, 0)}, []byte("suid")},
+		&headerTarEntry{&tar.Header{Name: "root/readonly", Typeflag: tar.TypeReg, Mode: 0o444, ModTime: mtime(1300000000, 999999999)}, []byte("ro")},
+		&headerTarEntry{&tar.Header{Name: "root/ro-dir", Typeflag: tar.TypeDir, Mode: 0o555, ModTime: mtime(1400000000, 42)}, nil},
+		&headerTarEntry{&tar.Header{Name: "root/ro-dir/file", Typeflag: tar.TypeReg, Mode: 0o600, ModTime: mtime(1500000000, 0)}, []byte("file")},
+	}
+	if symlinksEnabled {
+		entries = append(entries, &headerTarEntry{&tar.Header{Name: "root/link", Typeflag: tar.TypeSymlink, Linkname: "readonly", ModTime: mtime(1600000000, 7)}, nil})
+	}
+
+	expectedModes := map[string]os.FileMode{
+		"":            0o750,
+		"bin":         0o700,
+		"bin/exe":     0o775,
+		"bin/suid":    0o755,
+		"readonly":    0o444,
+		"ro-dir":      0o555,
+		"ro-dir/file": 0o600,
+	}
+	expectedTimes := map[string]time.Time{
+		"":            mtime(1000000000, 123456789),
+		"bin":         mtime(1100000000, 1),
+		"bin/exe":     mtime(1200000000, 500000000),
+		"bin/suid":    mtime(1250000000, 0),
+		"readonly":    mtime(1300000000, 999999999),
+		"ro-dir":      mtime(1400000000, 42),
+		"ro-dir/file": mtime(1500000000, 0),
+	}
+
+	for _, tc := range []struct {
+		name      string
+		extractor Extractor
+	}{
+		{"defaults", Extractor{}},
+		{"permissions", Extractor{PreservePermissions: true}},
+		{"permissions with umask", Extractor{PreservePermissions: true, Umask: 0o027}},
+		{"permissions with special bits", Extractor{PreservePermissions: true, PreserveSpecialBits: true}},
+		{"mtime", Extractor{PreserveMtime: true}},
+		{"permissions and mtime", Extractor{PreservePermissions: true, PreserveMtime: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now().Add(-time.Minute)
+			testTarExtractionWith(t, tc.extractor, nil, entries, func(t *testing.T, extractDir string) {
+				// leave the read-only directory removable
+				defer os.Chmod(fp.Join(extractDir, "ro-dir"), 0o755)
+
+				for name, mode := range expectedModes {
+					fi, err := os.Stat(fp.Join(extractDir, name))
+					if !assert.NoError(t, err) {
+						continue
+					}
+
+					switch {
+					case !tc.extractor.PreservePermissions && fi.IsDir():
+						mode = 0o755
+					case !tc.extractor.PreservePermissions:
+						mode = 0o600
+					case name == "bin/suid" && tc.extractor.PreserveSpecialBits:
+						mode |= os.ModeSetuid
+					default:
+						mode &^= tc.extractor.Umask
+					}
+					assert.Equal(t, mode, fi.Mode()&(os.ModePerm|os.ModeSetuid), name)
+
+					if tc.extractor.PreserveMtime {
+						assert.True(t, expectedTimes[name].Equal(fi.ModTime()), "%s: expected %s, got %s", name, expectedTimes[name], fi.ModTime())
+					} else {
+						assert.True(t, fi.ModTime().After(start), "%s: unexpected %s", name, fi.ModTime())
+					}
+				}
+
+				if symlinksEnabled && tc.extractor.PreserveMtime && runtime.GOOS == "linux" {
+					fi, err := os.Lstat(fp.Join(extractDir, "link"))
+					if assert.NoError(t, err) {
+						assert.True(t, mtime(1600000000, 7).Equal(fi.ModTime()), "symlink: unexpected %s", fi.ModTime())
+					}
+				}
+			}, nil)
+		})
+	}
+}
+
+func TestExtractionLimits(t *testing.T) {
+	manyEntries := []tarEntry{&dirTarEntry{"root"}}
+	for i := 0; i < 100; i++ {
+		manyEntries = append(manyEntries, &fileTarEntry{fmt.Sprintf("root/file%d", i), nil})
+	}
+
+	deep := []tarEntry{&dirTarEntry{"root"}}
+	deepPath := "root"
+	for i := 0; i < 20; i++ {
+		deepPath += "/d"
+		deep = append(deep, &dirTarEntry{deepPath})
+	}
+
+	manyBytes := []tarEntry{&dirTarEntry{"root"}}
+	for i := 0; i < 10; i++ {
+		manyBytes = append(manyBytes, &fileTarEntry{fmt.Sprintf("root/file%d", i), make([]byte, 1000)})
+	}
+
+	bigFile := []tarEntry{
+		&dirTarEntry{"root"},
+		&fileTarEntry{"root/small", make([]byte, 100)},
+		&fileTarEntry{"root/big", make([]byte, 10000)},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		extractor Extractor
+		entries   []tarEntry
+		limit     Limit
+		path      string
+	}{
+		{"entries", Extractor{MaxEntries: 50}, manyEntries, LimitEntries, "root/file49"},
+		{"depth", Extractor{MaxDepth: 10}, deep, LimitDepth, "root" + strings.Repeat("/d", 10)},
+		{"total bytes", Extractor{MaxBytes: 5500}, manyBytes, LimitBytes, "root/file5"},
+		{"file size", Extractor{MaxFileSize: 4096}, bigFile, LimitFileSize, "root/big"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testTarExtractionWith(t, tc.extractor, nil, tc.entries, func(t *testing.T, extractDir string) {
+				// the objects extracted before the limit remain, but not the file exceeding it
+				err := fp.Walk(extractDir, func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if info.Mode().IsRegular() && info.Size() > 1000 {
+						t.Errorf("file exceeding the limits extracted: %s", path)
+					}
+					return nil
+				})
+				assert.NoError(t, err)
+			}, ErrLimitExceeded)
+
+			tarFile := fp.Join(t.TempDir(), "bomb.tar")
+			writeTarFile(t, tarFile, tc.entries)
+			extractor := tc.extractor
+			extractor.Path = fp.Join(t.TempDir(), tarOutRoot)
+			f, err := os.Open(tarFile)
+			assert.NoError(t, err)
+			defer f.Close()
+
+			var lerr *LimitError
+			if assert.ErrorAs(t, extractor.Extract(f), &lerr) {
+				assert.Equal(t, tc.limit, lerr.Limit)
+				assert.Equal(t, tc.path, lerr.Path)
+			}
+		})
+	}
+
+	t.Run("under the limits", func(t *testing.T) {
+		testTarExtractionWith(t, Extractor{MaxEntries: 11, MaxDepth: 2, MaxBytes: 10000, MaxFileSize: 1000}, nil, manyBytes,
+			func(t *testing.T, extractDir string) {
+				for i := 0; i < 10; i++ {
+					fi, err := os.Stat(fp.Join(extractDir, fmt.Sprintf("file%d", i)))
+					if assert.NoError(t, err) {
+						assert.Equal(t, int64(1000), fi.Size())
+					}
+				}
+			}, nil)
+	})
+}
+
 const tarOutRoot = "tar-out-root"
 
 func testTarExtraction(t *testing.T, setup func(t *testing.T, rootDir string), tarEntries []tarEntry, check func(t *testing.T, extractDir string), extractError error) {
+	testTarExtractionWith(t, Extractor{}, setup, tarEntries, check, extractError)
+}
+
+// testTarExtractionWith is like testTarExtraction, extracting with the options of the extractor.
+func testTarExtractionWith(t *testing.T, extractor Extractor, setup func(t *testing.T, rootDir string), tarEntries []tarEntry, check func(t *testing.T, extractDir string), extractError error) {
 	var err error
 
 	// Directory structure.
@@ -339,20 +635,21 @@ func testTarExtraction(t *testing.T, setup func(t *testing.T, rootDir string), t
 
 	writeTarFile(t, tarFilename, tarEntries)
 
-	testExtract(t, tarFilename, extractDir, extractError)
+	extractor.Path = extractDir
+	testExtract(t, tarFilename, &extractor, extractError)
 
 	if check != nil {
 		check(t, extractDir)
 	}
 }
 
-func testExtract(t *testing.T, tarFile string, extractDir string, expectedError error) {
+func testExtract(t *testing.T, tarFile string, extractor *Extractor, expectedError error) {
 	var err error
 
 	tarReader, err := os.Open(tarFile)
 	assert.NoError(t, err)
+	defer tarReader.Close()
 
-	extractor := &Extractor{Path: extractDir}
 	err = extractor.Extract(tarReader)
 
 	assert.ErrorIs(t, err, expectedError)
@@ -413,6 +710,22 @@ func writeFileHeader(w *tar.Writer, fpath string, size uint64) error {
 	})
 }
 
+type headerTarEntry struct {
+	header *tar.Header
+	buf    []byte
+}
+
+func (e *headerTarEntry) write(tw *tar.Writer) error {
+	h := *e.header
+	h.Size = int64(len(e.buf))
+	h.Format = tar.FormatPAX
+	if err := tw.WriteHeader(&h); err != nil {
+		return err
+	}
+	_, err := tw.Write(e.buf)
+	return err
+}
+
 type dirTarEntry struct {
 	path string
 }
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package tar
+
+import (
+	"time"
+)
+
+// lchtimes does nothing, as the times of symlinks cannot be set on this platform.
+func lchtimes(path string, atime, mtime time.Time) error {
+	return nil
+}
@@ -13,6 +13,26 @@ type Allowlist interface {
 	IsAllowed(code uint64) bool
 }
 
+// DigestLimits is implemented by the allowlists with their own limits of the
+// length of the digests, see [WithMaxDigestSize] and
+// [WithMaxIdentityDigestSize]. [ValidateCid] uses [DefaultMaxDigestSize] and
+// [DefaultMaxIdentityDigestSize] for the other allowlists.
+type DigestLimits interface {
+	// MaxDigestSize returns the maximum length of the digests, except the
+	// identity ones.
+	MaxDigestSize() int
+	// MaxIdentityDigestSize returns the maximum length of the identity
+	// digests, which inline the data in the CID.
+	MaxIdentityDigestSize() int
+}
+
+func digestLimits(al Allowlist) (maxDigest, maxIdentity int) {
+	if l, ok := al.(DigestLimits); ok {
+		return l.MaxDigestSize(), l.MaxIdentityDigestSize()
+	}
+	return DefaultMaxDigestSize, DefaultMaxIdentityDigestSize
+}
+
 // NewAllowlist constructs new [Allowlist] from the given map set.
 func NewAllowlist(allowset map[uint64]bool) Allowlist {
 	return allowlist{allowset: allowset}
@@ -41,6 +61,91 @@ func (al allowlist) IsAllowed(code uint64) bool {
 	return false
 }
 
+func (al allowlist) MaxDigestSize() int {
+	maxDigest, _ := digestLimits(al.override)
+	return maxDigest
+}
+
+func (al allowlist) MaxIdentityDigestSize() int {
+	_, maxIdentity := digestLimits(al.override)
+	return maxIdentity
+}
+
+// WithAdditional returns an [Allowlist] allowing the codes in addition to the
+// ones allowed by base, with the digest limits of base.
+func WithAdditional(base Allowlist, codes ...uint64) Allowlist {
+	allowset := make(map[uint64]bool, len(codes))
+	for _, code := range codes {
+		allowset[code] = true
+	}
+	return NewOverridingAllowlist(base, allowset)
+}
+
+// Union returns an [Allowlist] allowing the codes allowed by any of the
+// allowlists. Its digest limits are the largest ones of the allowlists.
+func Union(allowlists ...Allowlist) Allowlist {
+	return union(allowlists)
+}
+
+type union []Allowlist
+
+func (u union) IsAllowed(code uint64) bool {
+	for _, al := range u {
+		if al.IsAllowed(code) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u union) MaxDigestSize() int {
+	var size int
+	for _, al := range u {
+		if maxDigest, _ := digestLimits(al); maxDigest > size {
+			size = maxDigest
+		}
+	}
+	return size
+}
+
+func (u union) MaxIdentityDigestSize() int {
+	var size int
+	for _, al := range u {
+		if _, maxIdentity := digestLimits(al); maxIdentity > size {
+			size = maxIdentity
+		}
+	}
+	return size
+}
+
+// WithMaxDigestSize returns an [Allowlist] allowing the codes allowed by
+// base, with digests of at most n bytes, except the identity ones.
+func WithMaxDigestSize(base Allowlist, n int) Allowlist {
+	_, maxIdentity := digestLimits(base)
+	return limitedAllowlist{base, n, maxIdentity}
+}
+
+// WithMaxIdentityDigestSize returns an [Allowlist] allowing the codes
+// allowed by base, with identity digests of at most n bytes.
+func WithMaxIdentityDigestSize(base Allowlist, n int) Allowlist {
+	maxDigest, _ := digestLimits(base)
+	return limitedAllowlist{base, maxDigest, n}
+}
+
+type limitedAllowlist struct {
+	Allowlist
+	maxDigest   int
+	maxIdentity int
+}
+
+func (al limitedAllowlist) MaxDigestSize() int {
+	return al.maxDigest
+}
+
+func (al limitedAllowlist) MaxIdentityDigestSize() int {
+	return al.maxIdentity
+}
+
 type defaultAllowlist struct{}
 
 func (defaultAllowlist) IsAllowed(code uint64) bool {
@@ -1,6 +1,7 @@
 package verifcid
 
 import (
+	"errors"
 	"testing"
 
 	mh "github.com/multiformats/go-multihash"
@@ -66,3 +67,78 @@ func TestDefaultAllowList(t *testing.T) {
 		t.Errorf("a CID that was longer than the maximum hash length did not error with ErrAboveMaximumHashLength")
 	}
 }
+
+func TestAllowlistComposition(t *testing.T) {
+	mhcid := func(code uint64, length int) cid.Cid {
+		mhash, err := mh.Sum([]byte{}, code, length)
+		if err != nil {
+			t.Fatalf("%v: code: %x length: %d", err, code, length)
+		}
+		return cid.NewCidV1(cid.Raw, mhash)
+	}
+	identity := func(size int) cid.Cid {
+		mhash, err := mh.Sum(make([]byte, size), mh.IDENTITY, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cid.NewCidV1(cid.Raw, mhash)
+	}
+
+	strict := NewOverridingAllowlist(DefaultAllowlist, map[uint64]bool{mh.SHA1: false})
+	if strict.IsAllowed(mh.SHA1) || !strict.IsAllowed(mh.SHA2_256) {
+		t.Fatal("overriding allowlist does not override")
+	}
+
+	murmur := WithAdditional(strict, mh.MURMUR3X64_64)
+	if !murmur.IsAllowed(mh.MURMUR3X64_64) || murmur.IsAllowed(mh.SHA1) || !murmur.IsAllowed(mh.SHA2_256) {
+		t.Fatal("WithAdditional does not add to its base")
+	}
+
+	u := Union(NewAllowlist(map[uint64]bool{mh.SHA1: true}), NewAllowlist(map[uint64]bool{mh.BLAKE3: true, mh.SHA1: false}))
+	if !u.IsAllowed(mh.SHA1) || !u.IsAllowed(mh.BLAKE3) || u.IsAllowed(mh.SHA2_256) {
+		t.Fatal("Union does not allow the codes of any allowlist")
+	}
+	if Union().IsAllowed(mh.SHA2_256) {
+		t.Fatal("empty union allows codes")
+	}
+
+	short := WithMaxDigestSize(DefaultAllowlist, 32)
+	if err := ValidateCid(short, mhcid(mh.SHA2_256, 32)); err != nil {
+		t.Fatal(err)
+	}
+	err := ValidateCid(short, mhcid(mh.SHA2_512, 64))
+	var serr *DigestSizeError
+	if !errors.As(err, &serr) || serr.Max != 32 || serr.Size != 64 || !errors.Is(err, ErrAboveMaximumHashLength) {
+		t.Fatalf("expected a DigestSizeError, got %v", err)
+	}
+	// the identity digests have their own limit
+	if err := ValidateCid(short, identity(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	inline := WithMaxIdentityDigestSize(short, 16)
+	if err := ValidateCid(inline, identity(16)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateCid(inline, identity(17)); !errors.Is(err, ErrAboveMaximumHashLength) {
+		t.Fatalf("expected %s, got %v", ErrAboveMaximumHashLength, err)
+	}
+	if err := ValidateCid(inline, mhcid(mh.SHA2_512, 64)); !errors.Is(err, ErrAboveMaximumHashLength) {
+		t.Fatal("WithMaxIdentityDigestSize does not keep the limit of its base")
+	}
+
+	// the composed allowlists keep the limits of their base, and the union
+	// the largest ones
+	if err := ValidateCid(WithAdditional(inline, mh.MURMUR3X64_64), identity(17)); !errors.Is(err, ErrAboveMaximumHashLength) {
+		t.Fatal("WithAdditional does not keep the limits of its base")
+	}
+	if err := ValidateCid(Union(inline, short), identity(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateCid(Union(inline, DefaultAllowlist), mhcid(mh.SHA2_512, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateCid(DefaultAllowlist, identity(129)); err != ErrAboveMaximumHashLength {
+		t.Fatalf("expected %s, got %v", ErrAboveMaximumHashLength, err)
+	}
+}
@@ -11,15 +11,40 @@ import (
 var (
 	ErrPossiblyInsecureHashFunction = errors.New("potentially insecure hash functions not allowed")
 	ErrBelowMinimumHashLength       = fmt.Errorf("hashes must be at least %d bytes long", minimumHashLength)
-	ErrAboveMaximumHashLength       = fmt.Errorf("hashes must be at most %d bytes long", maximumHashLength)
+	ErrAboveMaximumHashLength       = fmt.Errorf("hashes must be at most %d bytes long", DefaultMaxDigestSize)
 )
 
 const (
+	// DefaultMaxDigestSize is the maximum length of the digests, except the
+	// identity ones, for the allowlists without their own [DigestLimits].
+	DefaultMaxDigestSize = 128
+	// DefaultMaxIdentityDigestSize is the maximum length of the identity
+	// digests for the allowlists without their own [DigestLimits].
+	DefaultMaxIdentityDigestSize = 128
+
 	minimumHashLength = 20
-	maximumHashLength = 128
 )
 
-// ValidateCid validates multihash allowance behind given CID.
+// DigestSizeError is returned by [ValidateCid] for digests longer than the
+// limits of an allowlist other than the default ones. It matches
+// [ErrAboveMaximumHashLength].
+type DigestSizeError struct {
+	Size int
+	Max  int
+}
+
+func (e *DigestSizeError) Error() string {
+	return fmt.Sprintf("hashes must be at most %d bytes long, got %d", e.Max, e.Size)
+}
+
+func (e *DigestSizeError) Is(err error) bool {
+	return err == ErrAboveMaximumHashLength
+}
+
+// ValidateCid validates multihash allowance behind given CID. Identity
+// multihashes, which inline the data in the CID, are not subject to the
+// minimum length but are to the maximum one. The maximum lengths are the
+// [DigestLimits] of the allowlist, if it has some.
 func ValidateCid(allowlist Allowlist, c cid.Cid) error {
 	pref := c.Prefix()
 	if !allowlist.IsAllowed(pref.MhType) {
@@ -30,8 +55,15 @@ func ValidateCid(allowlist Allowlist, c cid.Cid) error {
 		return ErrBelowMinimumHashLength
 	}
 
-	if pref.MhType != mh.IDENTITY && pref.MhLength > maximumHashLength {
-		return ErrAboveMaximumHashLength
+	maxDigest, maxIdentity := digestLimits(allowlist)
+	if pref.MhType == mh.IDENTITY {
+		maxDigest = maxIdentity
+	}
+	if pref.MhLength > maxDigest {
+		if maxDigest == DefaultMaxDigestSize {
+			return ErrAboveMaximumHashLength
+		}
+		return &DigestSizeError{Size: pref.MhLength, Max: maxDigest}
 	}
 
 	return nil